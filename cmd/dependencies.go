@@ -0,0 +1,99 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dependenciesCmd = &cobra.Command{
+	Use:   "dependencies [projectDir]",
+	Short: "Print the dependency graph of stored computed fields",
+	Long: `Print the dependency graph of the stored computed and related fields of the project in 'projectDir'.
+For each field, list the fields whose modification recomputes it and the fields recomputed after it,
+and flag the fields that are part of a dependency cycle or that depend on expensive relation traversals.
+Use --model to print only the fields of a model and --json to print the graph as JSON.
+If projectDir is omitted, defaults to the current directory.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		runProject(projectDir, "dependencies", []string{
+			"--model", viper.GetString("DependenciesModel"),
+			fmt.Sprintf("--json=%t", viper.GetBool("DependenciesJSON")),
+		})
+	},
+}
+
+// PrintDependencyGraph prints the dependency graph of the stored fields of the
+// models. It is meant to be called from a project start file which imports all
+// the project's module.
+func PrintDependencyGraph() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	var graph []models.FieldDependencies
+	for _, fd := range models.DependencyGraph() {
+		if model := viper.GetString("DependenciesModel"); model != "" && fd.Model != model {
+			continue
+		}
+		graph = append(graph, fd)
+	}
+	if viper.GetBool("DependenciesJSON") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(graph); err != nil {
+			log.Panic("Unable to encode dependency graph", "error", err)
+		}
+		return
+	}
+	writeDependencyGraph(os.Stdout, graph)
+}
+
+// writeDependencyGraph writes the given dependency graph to w in a human readable format.
+func writeDependencyGraph(w io.Writer, graph []models.FieldDependencies) {
+	for _, fd := range graph {
+		kind := "computed"
+		if fd.Related {
+			kind = "related"
+		}
+		fmt.Fprintf(w, "%s.%s (%s)", fd.Model, fd.Field, kind)
+		if fd.InCycle {
+			fmt.Fprint(w, " [cycle]")
+		}
+		if fd.Expensive {
+			fmt.Fprint(w, " [expensive]")
+		}
+		fmt.Fprintln(w)
+		for _, dep := range fd.TriggeredBy {
+			fmt.Fprintf(w, "    <- %s\n", dep)
+		}
+		for _, dep := range fd.Triggers {
+			fmt.Fprintf(w, "    -> %s\n", dep)
+		}
+	}
+}
+
+// SetDependenciesFlags adds the dependencies flags to the given command.
+func SetDependenciesFlags(c *cobra.Command) {
+	c.PersistentFlags().String("model", "", "Name of the model whose fields to print. Defaults to all models")
+	viper.BindPFlag("DependenciesModel", c.PersistentFlags().Lookup("model"))
+	c.PersistentFlags().Bool("json", false, "Print the dependency graph as JSON")
+	viper.BindPFlag("DependenciesJSON", c.PersistentFlags().Lookup("json"))
+}
+
+func init() {
+	SetDependenciesFlags(dependenciesCmd)
+	HexyaCmd.AddCommand(dependenciesCmd)
+}
@@ -323,6 +323,64 @@ func main() {
 	}
 	hexyaCmd.AddCommand(updateDBCmd)
 
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage SQL migration scripts",
+		Long: "Generate and apply SQL migration scripts to update the database schema.",
+	}
+	var migrateGenerateCmd = &cobra.Command{
+		Use:   "generate",
+		Short: "Generate an SQL migration script",
+		Long: "Write in the migrations directory an SQL migration script to synchronize the database schema with the models definitions.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.GenerateMigration()
+		},
+	}
+	var migrateApplyCmd = &cobra.Command{
+		Use:   "apply",
+		Short: "Apply pending SQL migration scripts",
+		Long: "Apply in order the SQL migration scripts of the migrations directory that have not been applied yet.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.ApplyMigrations()
+		},
+	}
+	migrateCmd.AddCommand(migrateGenerateCmd)
+	migrateCmd.AddCommand(migrateApplyCmd)
+	hexyaCmd.AddCommand(migrateCmd)
+	cmd.SetMigrateFlags(migrateCmd)
+
+	var recomputeCmd = &cobra.Command{
+		Use:   "recompute",
+		Short: "Recompute stored computed fields",
+		Long: "Recompute and store the stored computed fields of all the records.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.RecomputeFields()
+		},
+	}
+	hexyaCmd.AddCommand(recomputeCmd)
+	cmd.SetRecomputeFlags(recomputeCmd)
+
+	var uninstallCmd = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Uninstall modules",
+		Long: "Remove the data records of the given modules from the database.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.UninstallModules(args)
+		},
+	}
+	hexyaCmd.AddCommand(uninstallCmd)
+
+	var dependenciesCmd = &cobra.Command{
+		Use:   "dependencies",
+		Short: "Print the dependency graph of stored computed fields",
+		Long: "Print for each stored computed or related field what triggers its recomputation and which fields it triggers.",
+		Run: func(c *cobra.Command, args []string) {
+			cmd.PrintDependencyGraph()
+		},
+	}
+	hexyaCmd.AddCommand(dependenciesCmd)
+	cmd.SetDependenciesFlags(dependenciesCmd)
+
 	cobra.OnInitialize(cmd.InitConfig)
 
 	if err := hexyaCmd.Execute(); err != nil {
@@ -0,0 +1,187 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// migrationUpMarker starts the section of a migration script
+	// with the statements to apply.
+	migrationUpMarker = "-- +hexya Up"
+	// migrationDownMarker starts the section of a migration script
+	// with the statements to revert the migration.
+	migrationDownMarker = "-- +hexya Down"
+	// migrationVersionFormat is the time format of the version
+	// at the beginning of migration scripts names.
+	migrationVersionFormat = "20060102150405"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage SQL migration scripts",
+	Long: `Generate and apply SQL migration scripts to update the database schema.
+This allows reviewing the changes to the database schema before applying them,
+instead of synchronizing the schema directly with 'updatedb'.`,
+}
+
+var migrateGenerateCmd = &cobra.Command{
+	Use:   "generate [projectDir]",
+	Short: "Generate an SQL migration script",
+	Long: `Write in the migrations directory a timestamped SQL migration script with the statements
+that 'updatedb' would run to synchronize the database schema with the models definitions
+of the project in 'projectDir', as well as the statements to revert them.
+If projectDir is omitted, defaults to the current directory.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		runProject(projectDir, "migrate", append([]string{"generate", "--migrations-dir", viper.GetString("MigrationsDir")}, args...))
+	},
+}
+
+var migrateApplyCmd = &cobra.Command{
+	Use:   "apply [projectDir]",
+	Short: "Apply pending SQL migration scripts",
+	Long: `Apply in order the SQL migration scripts of the migrations directory that have not been applied yet
+to the database of the project in 'projectDir'. Applied scripts are tracked in the schema_migrations table.
+If projectDir is omitted, defaults to the current directory.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		runProject(projectDir, "migrate", append([]string{"apply", "--migrations-dir", viper.GetString("MigrationsDir")}, args...))
+	},
+}
+
+// GenerateMigration writes an SQL migration script with the statements needed to
+// synchronize the database schema with the models. It is meant to be called from
+// a project start file which imports all the project's module.
+func GenerateMigration() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	migration := models.GenerateMigration()
+	if migration.IsEmpty() {
+		log.Info("Database schema is up to date, no migration generated")
+		return
+	}
+	migrationsDir, err := filepath.Abs(viper.GetString("MigrationsDir"))
+	if err != nil {
+		log.Panic("Unable to find migrations directory", "error", err)
+	}
+	if err = os.MkdirAll(migrationsDir, 0755); err != nil {
+		log.Panic("Unable to create migrations directory", "directory", migrationsDir, "error", err)
+	}
+	version := time.Now().UTC().Format(migrationVersionFormat)
+	fileName := filepath.Join(migrationsDir, fmt.Sprintf("%s.sql", version))
+	if err = ioutil.WriteFile(fileName, []byte(migrationScript(migration)), 0644); err != nil {
+		log.Panic("Unable to write migration file", "file", fileName, "error", err)
+	}
+	log.Info("Migration generated successfully", "file", fileName)
+}
+
+// ApplyMigrations applies in order the migration scripts of the migrations directory
+// that have not been applied yet. It is meant to be called from a project start
+// file which imports all the project's module.
+func ApplyMigrations() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	migrationsDir, err := filepath.Abs(viper.GetString("MigrationsDir"))
+	if err != nil {
+		log.Panic("Unable to find migrations directory", "error", err)
+	}
+	fileNames, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	if err != nil {
+		log.Panic("Unable to list migration files", "directory", migrationsDir, "error", err)
+	}
+	sort.Strings(fileNames)
+	applied := models.AppliedMigrations()
+	for _, fileName := range fileNames {
+		version := strings.TrimSuffix(filepath.Base(fileName), ".sql")
+		if applied[version] {
+			continue
+		}
+		up, _ := readMigrationFile(fileName)
+		models.ApplyMigration(version, up)
+		log.Info("Migration applied", "version", version)
+	}
+	log.Info("Database migrated successfully")
+}
+
+// migrationScript returns the content of the migration file of the given migration
+func migrationScript(migration models.Migration) string {
+	var res strings.Builder
+	res.WriteString("-- This file has been generated by Hexya. Review it before applying it.\n")
+	res.WriteString("-- Statements that cannot be reverted have no counterpart in the Down section.\n\n")
+	res.WriteString(migrationUpMarker + "\n")
+	for _, stmt := range migration.Up {
+		res.WriteString(stmt + ";\n\n")
+	}
+	res.WriteString(migrationDownMarker + "\n")
+	for _, stmt := range migration.Down {
+		res.WriteString(stmt + ";\n\n")
+	}
+	return res.String()
+}
+
+// readMigrationFile returns the Up and Down sections of the given migration file
+func readMigrationFile(fileName string) (string, string) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Panic("Unable to open migration file", "file", fileName, "error", err)
+	}
+	defer file.Close()
+	var up, down strings.Builder
+	var section *strings.Builder
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.TrimSpace(line) {
+		case migrationUpMarker:
+			section = &up
+			continue
+		case migrationDownMarker:
+			section = &down
+			continue
+		}
+		if section != nil {
+			section.WriteString(line + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Panic("Unable to read migration file", "file", fileName, "error", err)
+	}
+	return up.String(), down.String()
+}
+
+// SetMigrateFlags adds the migrate flags to the given command.
+func SetMigrateFlags(c *cobra.Command) {
+	c.PersistentFlags().String("migrations-dir", "./migrations", "Path to the directory of the SQL migration scripts")
+	viper.BindPFlag("MigrationsDir", c.PersistentFlags().Lookup("migrations-dir"))
+}
+
+func init() {
+	SetMigrateFlags(migrateCmd)
+	migrateCmd.AddCommand(migrateGenerateCmd)
+	migrateCmd.AddCommand(migrateApplyCmd)
+	HexyaCmd.AddCommand(migrateCmd)
+}
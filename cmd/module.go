@@ -151,6 +151,8 @@ const MODULE_NAME string = {{ .ModuleName }}
 func init() {
 	server.RegisterModule(&server.Module{
 		Name:     MODULE_NAME,
+		// Names of the hexya modules imported above
+		Depends:  []string{},
 		PostInit: func() {},
 	})
 }
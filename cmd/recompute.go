@@ -0,0 +1,57 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var recomputeCmd = &cobra.Command{
+	Use:   "recompute [projectDir]",
+	Short: "Recompute stored computed fields",
+	Long: `Recompute and store the stored computed fields of all the records of the project in 'projectDir'.
+Use --model to recompute only the fields of a model and --fields to recompute only some fields of this model.
+This is needed after adding a stored computed field with 'migrate apply', since 'updatedb' backfills new fields itself.
+If projectDir is omitted, defaults to the current directory.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		runProject(projectDir, "recompute", []string{
+			"--model", viper.GetString("RecomputeModel"),
+			"--fields", strings.Join(viper.GetStringSlice("RecomputeFields"), ","),
+		})
+	},
+}
+
+// RecomputeFields recomputes stored computed fields of the model and fields
+// given in the configuration. It is meant to be called from a project start
+// file which imports all the project's module.
+func RecomputeFields() {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	models.RecomputeStoredFields(viper.GetString("RecomputeModel"), viper.GetStringSlice("RecomputeFields")...)
+	log.Info("Stored fields recomputed successfully")
+}
+
+// SetRecomputeFlags adds the recompute flags to the given command.
+func SetRecomputeFlags(c *cobra.Command) {
+	c.PersistentFlags().String("model", "", "Name of the model whose fields to recompute. Defaults to all models")
+	viper.BindPFlag("RecomputeModel", c.PersistentFlags().Lookup("model"))
+	c.PersistentFlags().StringSlice("fields", []string{}, "Names of the fields to recompute. Defaults to all stored computed and related fields of the model")
+	viper.BindPFlag("RecomputeFields", c.PersistentFlags().Lookup("fields"))
+}
+
+func init() {
+	SetRecomputeFlags(recomputeCmd)
+	HexyaCmd.AddCommand(recomputeCmd)
+}
@@ -15,10 +15,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
@@ -77,10 +82,16 @@ func StartServer() {
 		log.Panic("Unable to find Resource directory", "error", err)
 	}
 	server.ResourceDir = resourceDir
+	models.MaxReadDepth = viper.GetInt("Server.MaxReadDepth")
+	models.QueryBudget = viper.GetInt("Server.QueryBudget")
+	models.QueryBudgetStrict = viper.GetBool("Debug")
 	server.PreInit()
 	connectToDB()
 	i18n.BootStrap()
 	models.BootStrap()
+	if err := server.CheckUninstalledModules(false); err != nil {
+		log.Panic("Unable to start server", "error", err)
+	}
 	models.RunWorkerLoop()
 	server.LoadTranslations(resourceDir, i18n.Langs)
 	server.LoadInternalResources(resourceDir)
@@ -96,16 +107,40 @@ func StartServer() {
 	cert := viper.GetString("Server.Certificate")
 	key := viper.GetString("Server.PrivateKey")
 	domain := viper.GetString("Server.Domain")
+	shutdownDone := handleShutdownSignals()
 	switch {
 	case cert != "":
-		srv.RunTLS(address, cert, key)
+		err = srv.RunTLS(address, cert, key)
 	case domain != "":
-		srv.RunAutoTLS(domain)
+		err = srv.RunAutoTLS(domain)
 	default:
-		srv.Run(address)
+		err = srv.Run(address)
+	}
+	if err == http.ErrServerClosed {
+		<-shutdownDone
 	}
 }
 
+// handleShutdownSignals gracefully shuts the server down when the process
+// receives SIGTERM or SIGINT. The returned channel is closed once the server
+// is shut down.
+func handleShutdownSignals() <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		defer close(done)
+		sig := <-signals
+		log.Info("Received signal, shutting down", "signal", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("Server.ShutdownTimeout"))
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warn("Server did not shut down gracefully", "error", err)
+		}
+	}()
+	return done
+}
+
 // setupLogger initializes the logger
 func setupLogger() {
 	logging.Initialize()
@@ -151,6 +186,22 @@ func SetServerFlags(c *cobra.Command) {
 	viper.BindPFlag("Server.Certificate", c.PersistentFlags().Lookup("certificate"))
 	c.PersistentFlags().StringP("private-key", "K", "", "Private key file for HTTPS.")
 	viper.BindPFlag("Server.PrivateKey", c.PersistentFlags().Lookup("private-key"))
+	c.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for running requests and jobs to finish when the server is stopped")
+	viper.BindPFlag("Server.ShutdownTimeout", c.PersistentFlags().Lookup("shutdown-timeout"))
+	c.PersistentFlags().Int("max-read-depth", 3, "Maximum depth of the nested fields that can be read in a single request")
+	viper.BindPFlag("Server.MaxReadDepth", c.PersistentFlags().Lookup("max-read-depth"))
+	c.PersistentFlags().Int("query-budget", 0, "Maximum number of queries per transaction before a warning is logged, or an error is raised in debug mode. 0 means no limit")
+	viper.BindPFlag("Server.QueryBudget", c.PersistentFlags().Lookup("query-budget"))
+	c.PersistentFlags().String("smtp-host", "localhost", "SMTP server through which emails are sent")
+	viper.BindPFlag("Mail.Host", c.PersistentFlags().Lookup("smtp-host"))
+	c.PersistentFlags().String("smtp-port", "25", "Port of the SMTP server")
+	viper.BindPFlag("Mail.Port", c.PersistentFlags().Lookup("smtp-port"))
+	c.PersistentFlags().String("smtp-user", "", "User to authenticate on the SMTP server. Leave empty for no authentication")
+	viper.BindPFlag("Mail.User", c.PersistentFlags().Lookup("smtp-user"))
+	c.PersistentFlags().String("smtp-password", "", "Password to authenticate on the SMTP server")
+	viper.BindPFlag("Mail.Password", c.PersistentFlags().Lookup("smtp-password"))
+	c.PersistentFlags().String("email-from", "", "Default sender address of outgoing emails")
+	viper.BindPFlag("Mail.From", c.PersistentFlags().Lookup("email-from"))
 }
 
 func runCommand(c string, args ...string) error {
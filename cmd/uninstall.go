@@ -0,0 +1,59 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall MODULE...",
+	Short: "Uninstall modules",
+	Long: `Remove from the database the data records of the given modules of the project in the current directory, after running their UninstallHook.
+Modules that depend on the given modules must be uninstalled at the same time.
+Then remove the modules from the project and run 'hexya updatedb' to drop the tables and columns of their models and fields.
+The server and 'hexya updatedb' refuse to start while uninstalled modules are still in the project.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("You must specify at least one module name.")
+			os.Exit(1)
+		}
+		runProject(".", "uninstall", args)
+	},
+}
+
+// UninstallModules removes the data of the given modules from the database.
+// It is meant to be called from a project start file which imports all the
+// project's module.
+func UninstallModules(names []string) {
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	resourceDir, err := filepath.Abs(viper.GetString("ResourceDir"))
+	if err != nil {
+		log.Panic("Unable to find Resource directory", "error", err)
+	}
+	if err = server.UninstallModules(resourceDir, names...); err != nil {
+		log.Panic("Unable to uninstall modules", "modules", names, "error", err)
+	}
+	log.Info("Modules uninstalled successfully", "modules", names)
+	fmt.Printf(`The data of modules %s has been removed. To complete the uninstallation:
+1. Remove the modules from the project.
+2. Run 'hexya updatedb' to drop the tables and columns of their models and fields.
+The server and 'hexya updatedb' will not start until then.
+`, strings.Join(names, ", "))
+}
+
+func init() {
+	HexyaCmd.AddCommand(uninstallCmd)
+}
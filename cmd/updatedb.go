@@ -34,6 +34,9 @@ func UpdateDB() {
 	connectToDB()
 	models.BootStrap()
 	models.SyncDatabase()
+	if err := server.CheckUninstalledModules(true); err != nil {
+		log.Panic("Unable to update database", "error", err)
+	}
 	resourceDir, err := filepath.Abs(viper.GetString("ResourceDir"))
 	if err != nil {
 		log.Panic("Unable to find Resource directory", "error", err)
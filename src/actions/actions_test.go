@@ -17,10 +17,12 @@ package actions
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/xmlutils"
 	"github.com/hexya-erp/hexya/src/views"
 	. "github.com/smartystreets/goconvey/convey"
@@ -64,6 +66,23 @@ func TestActions(t *testing.T) {
 		partner.AddFields(map[string]models.FieldDefinition{
 			"Name": fields.Char{},
 		})
+		partner.NewMethod("ActionOpenUser", func(rc *models.RecordCollection) *Action {
+			return &Action{
+				Type:     ActionActWindow,
+				Name:     rc.Get(partner.FieldName("Name")).(string),
+				Model:    "User",
+				ViewMode: "form",
+				ResID:    rc.Ids()[0],
+				Context:  rc.Env().Context(),
+			}
+		})
+		partner.NewMethod("ActionClose", func(rc *models.RecordCollection) Action {
+			return Action{Type: ActionCloseWindow}
+		})
+		partner.NewMethod("ActionConfirm", func(rc *models.RecordCollection) bool {
+			return true
+		})
+		partner.NewMethod("ActionRename", func(rc *models.RecordCollection, name string) {})
 		models.BootStrap()
 	})
 	Convey("Creating Action 1", t, func() {
@@ -185,4 +204,48 @@ func TestActions(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(string(d), ShouldEqual, "false")
 	})
+	Convey("Testing button actions", t, func() {
+		Convey("Button methods signatures", func() {
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) {})), ShouldBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) bool { return true })), ShouldBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) *Action { return nil })), ShouldBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) Action { return Action{} })), ShouldBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection, i int) {})), ShouldNotBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) string { return "" })), ShouldNotBeNil)
+			So(checkButtonMethodType(reflect.TypeOf(func(rc *models.RecordCollection) (*Action, bool) { return nil, true })), ShouldNotBeNil)
+		})
+		Convey("Button methods results", func() {
+			So(buttonResult(nil), ShouldBeNil)
+			So(buttonResult(true), ShouldBeNil)
+			So(buttonResult((*Action)(nil)), ShouldBeNil)
+			So(buttonResult(Action{}), ShouldBeNil)
+			res := buttonResult(&Action{Type: ActionCloseWindow})
+			So(res, ShouldNotBeNil)
+			So(res.Type, ShouldEqual, ActionCloseWindow)
+			res = buttonResult(Action{Type: ActionURL, Name: "Hexya"})
+			So(res, ShouldNotBeNil)
+			So(res.Type, ShouldEqual, ActionURL)
+			So(res.Name, ShouldEqual, "Hexya")
+		})
+		Convey("Clicking buttons", func() {
+			env := models.NewDetachedEnvironment(security.SuperUserID)
+			partners := env.Pool("Partner").Detached(models.FieldMap{"id": int64(7), "name": "Hexya"}).
+				WithContext("active_test", false)
+			res := ButtonAction(partners, "ActionOpenUser")
+			So(res, ShouldNotBeNil)
+			So(res.Type, ShouldEqual, ActionActWindow)
+			So(res.Name, ShouldEqual, "Hexya")
+			So(res.ResID, ShouldEqual, 7)
+			So(res.Context.HasKey("active_test"), ShouldBeTrue)
+			So(res.Context.GetBool("active_test"), ShouldBeFalse)
+			So(res.Target, ShouldEqual, "current")
+			So(res.Views, ShouldContain, views.ViewTuple{ID: "my_id", Type: "form"})
+			res = ButtonAction(partners, "ActionClose")
+			So(res, ShouldNotBeNil)
+			So(res.Type, ShouldEqual, ActionCloseWindow)
+			So(ButtonAction(partners, "ActionConfirm"), ShouldBeNil)
+			So(func() { ButtonAction(partners, "ActionRename") }, ShouldPanic)
+			So(func() { ButtonAction(partners, "ActionUnknown") }, ShouldPanic)
+		})
+	})
 }
@@ -0,0 +1,81 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/hexya-erp/hexya/src/models"
+)
+
+var (
+	actionType    = reflect.TypeOf(Action{})
+	actionPtrType = reflect.TypeOf(&Action{})
+	boolType      = reflect.TypeOf(true)
+)
+
+// ButtonAction calls the given method on the records of rs, as a
+// view button of type "object" does, and returns the action that
+// the client must execute next.
+//
+// rs must hold the records on which the button has been clicked with
+// the context of the view. The method must take no argument and return
+// either nothing, a bool, an Action or a pointer to an Action. ButtonAction
+// returns nil if the method returns no action, meaning that the client
+// must reload the current view. Returned actions are sanitized.
+//
+// It panics if the method does not exist or does not have the above signature.
+func ButtonAction(rs models.RecordSet, method string) *Action {
+	meth, ok := rs.Collection().Model().Methods().Get(method)
+	if !ok {
+		log.Panic("Unknown button method", "model", rs.ModelName(), "method", method)
+	}
+	if err := checkButtonMethodType(meth.MethodType()); err != nil {
+		log.Panic("Method cannot be called by a button", "model", rs.ModelName(), "method", method, "error", err)
+	}
+	return buttonResult(rs.Collection().Call(method))
+}
+
+// checkButtonMethodType returns an error if the given method type
+// is not the type of a method that can be called by a button.
+func checkButtonMethodType(methType reflect.Type) error {
+	if methType.NumIn() != 1 {
+		return errors.New("button methods must not take arguments")
+	}
+	switch methType.NumOut() {
+	case 0:
+		return nil
+	case 1:
+		switch methType.Out(0) {
+		case actionType, actionPtrType, boolType:
+			return nil
+		}
+		return fmt.Errorf("button methods must return an action or a bool, not %s", methType.Out(0))
+	default:
+		return errors.New("button methods must have at most one return value")
+	}
+}
+
+// buttonResult returns the sanitized action to send to the client
+// from the given result of a button method, or nil if the client
+// must reload the current view.
+func buttonResult(res interface{}) *Action {
+	var action Action
+	switch r := res.(type) {
+	case *Action:
+		if r == nil {
+			return nil
+		}
+		action = *r
+	case Action:
+		action = r
+	}
+	if action.Type == "" {
+		return nil
+	}
+	action.Sanitize()
+	return &action
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package attachment provides the Attachment model which stores files
+// linked to any record of any model.
+//
+// Attachments are linked to their document by the name of its model (ResModel)
+// and its id (ResID). Their content is stored in a Binary field, encoded in base64,
+// and can be streamed to HTTP clients with Serve.
+//
+// Attachments have no access rights of their own: users can read the attachments
+// of the documents they can read and modify the attachments of the documents they
+// can modify, when attachments are accessed through the methods of the model.
+// Attachments of documents the user cannot read are not found by the searches
+// of attachments, and are filtered out when loading or reading attachments.
+package attachment
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// AttachmentModel is the name of the model storing the attachments
+const AttachmentModel = "Attachment"
+
+var log logging.Logger
+
+func init() {
+	log = logging.GetLogger("attachment")
+	declareAttachmentModel()
+}
+
+func declareAttachmentModel() {
+	attModel := models.NewModel(AttachmentModel)
+	attModel.AddFields(map[string]models.FieldDefinition{
+		"Name":     fields.Char{String: "File Name", Required: true},
+		"ResModel": fields.Char{String: "Related Document Model", Required: true, Index: true},
+		"ResID":    fields.Integer{String: "Related Document ID", Required: true, Index: true},
+		"Data":     fields.Binary{String: "File Content", Help: "Content of the file, encoded in base64"},
+		"MimeType": fields.Char{String: "Mime Type"},
+		"FileSize": fields.Integer{String: "File Size", ReadOnly: true, Help: "Size of the file in bytes"},
+	})
+	attModel.SetDefaultOrder("ID")
+	// Access rights are checked on the attached documents
+	attModel.Methods().AllowAllToGroup(security.GroupEveryone)
+
+	attModel.Methods().MustGet("Create").Extend(
+		func(rc *models.RecordCollection, data models.RecordData) *models.RecordCollection {
+			mdl := rc.Model()
+			resModel, _ := data.Underlying().Get(mdl.FieldName("ResModel")).(string)
+			checkDocumentAccess(rc.Env(), resModel, []int64{toInt64(data.Underlying().Get(mdl.FieldName("ResID")))}, "Write")
+			return rc.Super().Call("Create", addFileData(rc, data)).(models.RecordSet).Collection()
+		})
+	attModel.Methods().MustGet("Write").Extend(
+		func(rc *models.RecordCollection, data models.RecordData) bool {
+			checkDocumentsAccess(rc, "Write")
+			res := rc.Super().Call("Write", addFileData(rc, data)).(bool)
+			if data.Underlying().Has(rc.Model().FieldName("ResModel")) || data.Underlying().Has(rc.Model().FieldName("ResID")) {
+				// Attachments have been moved to other documents
+				checkDocumentsAccess(rc, "Write")
+			}
+			return res
+		})
+	attModel.Methods().MustGet("Unlink").Extend(
+		func(rc *models.RecordCollection) int64 {
+			checkDocumentsAccess(rc, "Write")
+			return rc.Super().Call("Unlink").(int64)
+		})
+	attModel.Methods().MustGet("Search").Extend(
+		func(rc *models.RecordCollection, cond models.Conditioner) *models.RecordCollection {
+			return readableAttachments(rc.Super().Call("Search", cond).(models.RecordSet).Collection())
+		})
+	attModel.Methods().MustGet("SearchCached").Extend(
+		func(rc *models.RecordCollection, cond models.Conditioner) *models.RecordCollection {
+			return readableAttachments(rc.Super().Call("SearchCached", cond).(models.RecordSet).Collection())
+		})
+	attModel.Methods().MustGet("SearchAll").Extend(
+		func(rc *models.RecordCollection) *models.RecordCollection {
+			return readableAttachments(rc.Super().Call("SearchAll").(models.RecordSet).Collection())
+		})
+	attModel.Methods().MustGet("Load").Extend(
+		func(rc *models.RecordCollection, fields ...models.FieldName) *models.RecordCollection {
+			return readableAttachments(rc.Super().Call("Load", fields).(models.RecordSet).Collection())
+		})
+	attModel.Methods().MustGet("Read").Extend(
+		func(rc *models.RecordCollection, fields models.FieldNames) []models.RecordData {
+			return readableAttachments(rc).Super().Call("Read", fields).([]models.RecordData)
+		})
+
+	attModel.NewMethod("Content", attachmentContent)
+}
+
+// Content returns the decoded content of this attachment.
+func attachmentContent(rc *models.RecordCollection) []byte {
+	rc.EnsureOne()
+	content, err := base64.StdEncoding.DecodeString(rc.Get(rc.Model().FieldName("Data")).(string))
+	if err != nil {
+		log.Panic("Unable to decode attachment content", "id", rc.Ids()[0], "error", err)
+	}
+	return content
+}
+
+// addFileData returns a copy of the given attachment data with the
+// FileSize and MimeType fields set from its Data, if it is modified.
+func addFileData(rc *models.RecordCollection, data models.RecordData) *models.ModelData {
+	mdl := rc.Model()
+	res := data.Underlying().Copy()
+	if !res.Has(mdl.FieldName("Data")) {
+		return res
+	}
+	encoded, _ := res.Get(mdl.FieldName("Data")).(string)
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		content = []byte(encoded)
+	}
+	res.Set(mdl.FieldName("FileSize"), int64(len(content)))
+	if mimeType, _ := res.Get(mdl.FieldName("MimeType")).(string); mimeType == "" {
+		name, _ := res.Get(mdl.FieldName("Name")).(string)
+		res.Set(mdl.FieldName("MimeType"), guessMimeType(name, content))
+	}
+	return res
+}
+
+// guessMimeType returns the mime type of a file with the given name and
+// content, from the extension of the name or else from the content.
+func guessMimeType(name string, content []byte) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+	if len(content) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(content)
+}
+
+// attachedDocuments returns the ids of the documents of the attachments
+// of rc, by model name.
+func attachedDocuments(rc *models.RecordCollection) map[string][]int64 {
+	mdl := rc.Model()
+	docs := make(map[string][]int64)
+	for _, att := range rc.Sudo().Records() {
+		resModel := att.Get(mdl.FieldName("ResModel")).(string)
+		docs[resModel] = append(docs[resModel], att.Get(mdl.FieldName("ResID")).(int64))
+	}
+	return docs
+}
+
+// checkDocumentsAccess panics with an AccessError if the current user cannot
+// execute the given method on the documents of the attachments of rc.
+func checkDocumentsAccess(rc *models.RecordCollection, method string) {
+	if rc.Env().Uid() == security.SuperUserID {
+		return
+	}
+	for resModel, ids := range attachedDocuments(rc) {
+		checkDocumentAccess(rc.Env(), resModel, ids, method)
+	}
+}
+
+// readableAttachments returns the attachments of rc whose document
+// can be read by the current user.
+//
+// For other users than the super user, the attachments of rc are fetched
+// and the returned RecordCollection is restricted to the ids of the readable
+// ones, so that searches only find attachments of visible documents.
+func readableAttachments(rc *models.RecordCollection) *models.RecordCollection {
+	if rc.Env().Uid() == security.SuperUserID || rc.IsEmpty() {
+		return rc
+	}
+	visible := make(map[string]map[int64]bool)
+	for resModel, ids := range attachedDocuments(rc) {
+		visible[resModel] = visibleDocuments(rc.Env(), resModel, ids, "Load")
+	}
+	mdl := rc.Model()
+	var ids []int64
+	for _, att := range rc.Sudo().Records() {
+		resModel := att.Get(mdl.FieldName("ResModel")).(string)
+		if visible[resModel][att.Get(mdl.FieldName("ResID")).(int64)] {
+			ids = append(ids, att.Ids()[0])
+		}
+	}
+	if len(ids) == len(rc.Ids()) {
+		return rc
+	}
+	return rc.Search(mdl.Field(models.ID).In(ids))
+}
+
+// visibleDocuments returns the ids of the records with the given ids of
+// resModel that the current user of env can see and on which they can
+// execute the given method.
+func visibleDocuments(env models.Environment, resModel string, ids []int64, method string) map[int64]bool {
+	model, ok := models.Registry.Get(resModel)
+	if !ok {
+		log.Panic("Unknown model of attached document", "model", resModel, "ids", ids)
+	}
+	visible := make(map[int64]bool)
+	docs := env.Pool(resModel).Search(model.Field(models.ID).In(ids))
+	if !docs.CheckExecutionPermission(model.Methods().MustGet(method), true) {
+		return visible
+	}
+	for _, id := range docs.ForceLoad(models.ID).Ids() {
+		visible[id] = true
+	}
+	return visible
+}
+
+// checkDocumentAccess panics with an AccessError if the current user of env cannot
+// execute the given method on the records with the given ids of resModel.
+//
+// The user must be allowed to execute the method and all the records must be
+// visible to the user through record rules.
+func checkDocumentAccess(env models.Environment, resModel string, ids []int64, method string) {
+	if env.Uid() == security.SuperUserID {
+		return
+	}
+	model, ok := models.Registry.Get(resModel)
+	if !ok {
+		log.Panic("Unknown model of attached document", "model", resModel, "ids", ids)
+	}
+	env.Pool(resModel).CheckExecutionPermission(model.Methods().MustGet(method))
+	visible := visibleDocuments(env, resModel, ids, method)
+	for _, id := range ids {
+		if !visible[id] {
+			panic(exceptions.AccessError{
+				Message: env.Pool(AttachmentModel).T("You are not allowed to access the document of this attachment"),
+				Debug:   fmt.Sprintf("model: %s, id: %d, method: %s, uid: %d", resModel, id, method, env.Uid()),
+			})
+		}
+	}
+}
+
+// toInt64 returns the given document id as an int64
+func toInt64(id interface{}) int64 {
+	switch val := id.(type) {
+	case int64:
+		return val
+	case int:
+		return int64(val)
+	case float64:
+		return int64(val)
+	}
+	return 0
+}
+
+// Attachments returns the attachments of the records of the given RecordSet
+// that the current user can read.
+func Attachments(rs models.RecordSet) *models.RecordCollection {
+	attRC := rs.Env().Pool(AttachmentModel)
+	mdl := attRC.Model()
+	return attRC.Call("Search", mdl.Field(mdl.FieldName("ResModel")).Equals(rs.ModelName()).
+		And().Field(mdl.FieldName("ResID")).In(rs.Ids())).(models.RecordSet).Collection()
+}
+
+// Attach creates an attachment with the given name and content for the
+// record of the given RecordSet, which must be a singleton.
+//
+// The mime type of the attachment is guessed from its name and content.
+func Attach(rs models.RecordSet, name string, content []byte) *models.RecordCollection {
+	rs.EnsureOne()
+	attRC := rs.Env().Pool(AttachmentModel)
+	mdl := attRC.Model()
+	return attRC.Call("Create", models.NewModelData(mdl).
+		Set(mdl.FieldName("Name"), name).
+		Set(mdl.FieldName("ResModel"), rs.ModelName()).
+		Set(mdl.FieldName("ResID"), rs.Ids()[0]).
+		Set(mdl.FieldName("Data"), base64.StdEncoding.EncodeToString(content))).(models.RecordSet).Collection()
+}
+
+// Serve writes the content of the given attachment as the response to req.
+//
+// The content is streamed from the database chunk by chunk, without loading
+// it into memory, and range requests are supported for resumable downloads.
+// Controllers serving attachments should use this function rather than the
+// Content method.
+//
+// It panics with an AccessError if the current user cannot read the document
+// of the attachment.
+func Serve(w http.ResponseWriter, req *http.Request, att models.RecordSet) {
+	rc := att.Collection()
+	rc.EnsureOne()
+	checkDocumentsAccess(rc, "Load")
+	mdl := rc.Model()
+	name := rc.Get(mdl.FieldName("Name")).(string)
+	if mimeType := rc.Get(mdl.FieldName("MimeType")).(string); mimeType != "" {
+		w.Header().Set("Content-Type", mimeType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	modTime := rc.Get(mdl.FieldName("WriteDate")).(dates.DateTime).Time
+	http.ServeContent(w, req, name, modTime, rc.BinaryReader(mdl.FieldName("Data")))
+}
+
+// RemoveAttachments deletes all the attachments of the records of the
+// given RecordSet and returns the number of deleted attachments.
+func RemoveAttachments(rs models.RecordSet) int64 {
+	attachments := Attachments(rs)
+	if attachments.IsEmpty() {
+		return 0
+	}
+	return attachments.Call("Unlink").(int64)
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package attachment
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testDocModel     = "AttachmentTestDocument"
+	testPrivateModel = "AttachmentTestPrivate"
+	testUserID       = 2
+)
+
+func init() {
+	docModel := models.NewModel(testDocModel)
+	docModel.AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{},
+	})
+	docModel.Methods().AllowAllToGroup(security.GroupEveryone)
+	docModel.AddRecordRule(&models.RecordRule{
+		Name:      "noSecretDocuments",
+		Group:     security.GroupEveryone,
+		Condition: docModel.Field(docModel.FieldName("Name")).NotEquals("Secret"),
+		Perms:     security.Read,
+	})
+	privateModel := models.NewModel(testPrivateModel)
+	privateModel.AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{},
+	})
+}
+
+func TestGuessMimeType(t *testing.T) {
+	Convey("Testing mime type guessing", t, func() {
+		Convey("Mime type is guessed from the file extension", func() {
+			So(guessMimeType("report.pdf", []byte("not really a pdf")), ShouldEqual, "application/pdf")
+		})
+		Convey("Mime type is guessed from the content without known extension", func() {
+			So(guessMimeType("notes", []byte("Some plain text")), ShouldEqual, "text/plain; charset=utf-8")
+			So(guessMimeType("logo", []byte("\x89PNG\r\n\x1a\n")), ShouldEqual, "image/png")
+		})
+		Convey("Empty files without extension are binary streams", func() {
+			So(guessMimeType("empty", nil), ShouldEqual, "application/octet-stream")
+		})
+	})
+}
+
+func TestAttachmentsAccess(t *testing.T) {
+	Convey("Testing attachments access rights", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			docModel := models.Registry.MustGet(testDocModel)
+			newDoc := func(model, name string) *models.RecordCollection {
+				return env.Pool(model).Call("Create", models.NewModelData(models.Registry.MustGet(model)).
+					Set(docModel.FieldName("Name"), name)).(models.RecordSet).Collection()
+			}
+			public := newDoc(testDocModel, "Public")
+			secret := newDoc(testDocModel, "Secret")
+			private := newDoc(testPrivateModel, "Private")
+			publicAtt := Attach(public, "public.txt", []byte("Public content"))
+			secretAtt := Attach(secret, "secret.txt", []byte("Secret content"))
+			privateAtt := Attach(private, "private.txt", []byte("Private content"))
+			attModel := models.Registry.MustGet(AttachmentModel)
+			allAtts := publicAtt.Union(secretAtt).Union(privateAtt)
+			Convey("The super user reads all attachments", func() {
+				So(allAtts.Call("Read", models.FieldNames{attModel.FieldName("Name")}), ShouldHaveLength, 3)
+				So(Attachments(secret).Len(), ShouldEqual, 1)
+			})
+			Convey("Attachments of unreadable documents are filtered out on load", func() {
+				userAtts := env.Pool(AttachmentModel).Sudo(testUserID).Call("Search",
+					attModel.Field(models.ID).In(allAtts.Ids())).(models.RecordSet).Collection()
+				loaded := userAtts.Call("Load", []models.FieldName{}).(models.RecordSet).Collection()
+				So(loaded.Ids(), ShouldResemble, publicAtt.Ids())
+				So(Attachments(public.Sudo(testUserID)).Ids(), ShouldResemble, publicAtt.Ids())
+				So(Attachments(secret.Sudo(testUserID)).IsEmpty(), ShouldBeTrue)
+				So(string(Attachments(public.Sudo(testUserID)).Call("Content").([]byte)), ShouldEqual, "Public content")
+			})
+			Convey("Attachments of unreadable documents are not found by searches", func() {
+				userAtts := env.Pool(AttachmentModel).Sudo(testUserID)
+				found := userAtts.Call("Search", attModel.Field(models.ID).In(allAtts.Ids())).(models.RecordSet).Collection()
+				So(found.Ids(), ShouldResemble, publicAtt.Ids())
+				So(found.Get(attModel.FieldName("Name")), ShouldEqual, "public.txt")
+				So(userAtts.Call("Search", attModel.Field(models.ID).Equals(secretAtt.Ids()[0])).(models.RecordSet).IsEmpty(), ShouldBeTrue)
+				So(userAtts.Call("Browse", privateAtt.Ids()).(models.RecordSet).IsEmpty(), ShouldBeTrue)
+				all := userAtts.Call("SearchAll").(models.RecordSet).Collection()
+				So(all.Intersect(allAtts).Ids(), ShouldResemble, publicAtt.Ids())
+				So(userAtts.Call("SearchCached", attModel.Field(models.ID).In(allAtts.Ids())).(models.RecordSet).Collection().Ids(),
+					ShouldResemble, publicAtt.Ids())
+			})
+			Convey("Attachments of unreadable documents are filtered out on read", func() {
+				data := allAtts.Sudo(testUserID).Call("Read", models.FieldNames{attModel.FieldName("Name")}).([]models.RecordData)
+				So(data, ShouldHaveLength, 1)
+				So(data[0].Underlying().Get(attModel.FieldName("Name")), ShouldEqual, "public.txt")
+			})
+			Convey("Attachments of unreadable documents cannot be modified", func() {
+				name := models.NewModelData(attModel).Set(attModel.FieldName("Name"), "renamed.txt")
+				So(func() { secretAtt.Sudo(testUserID).Call("Write", name) }, ShouldPanicWith, exceptions.AccessError{
+					Message: "You are not allowed to access the document of this attachment",
+					Debug:   fmt.Sprintf("model: %s, id: %d, method: Write, uid: %d", testDocModel, secret.Ids()[0], testUserID),
+				})
+				So(func() { privateAtt.Sudo(testUserID).Call("Unlink") }, ShouldPanic)
+				So(publicAtt.Sudo(testUserID).Call("Write", name), ShouldEqual, true)
+				So(publicAtt.Get(attModel.FieldName("Name")), ShouldEqual, "renamed.txt")
+			})
+			Convey("Attachments can be created with document ids from JSON clients", func() {
+				att := env.Pool(AttachmentModel).Sudo(testUserID).Call("Create", models.NewModelData(attModel).
+					Set(attModel.FieldName("Name"), "client.txt").
+					Set(attModel.FieldName("ResModel"), testDocModel).
+					Set(attModel.FieldName("ResID"), float64(public.Ids()[0])).
+					Set(attModel.FieldName("Data"), base64.StdEncoding.EncodeToString([]byte("From client")))).(models.RecordSet).Collection()
+				So(att.Get(attModel.FieldName("ResID")), ShouldEqual, public.Ids()[0])
+				So(att.Get(attModel.FieldName("FileSize")), ShouldEqual, 11)
+				So(func() {
+					env.Pool(AttachmentModel).Sudo(testUserID).Call("Create", models.NewModelData(attModel).
+						Set(attModel.FieldName("Name"), "secret2.txt").
+						Set(attModel.FieldName("ResModel"), testDocModel).
+						Set(attModel.FieldName("ResID"), secret.Ids()[0]))
+				}, ShouldPanic)
+			})
+			Convey("Attachments without document model cannot be created", func() {
+				So(func() {
+					env.Pool(AttachmentModel).Sudo(testUserID).Call("Create", models.NewModelData(attModel).
+						Set(attModel.FieldName("Name"), "orphan.txt"))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
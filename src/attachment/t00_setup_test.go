@@ -0,0 +1,15 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package attachment
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/tests"
+	_ "github.com/lib/pq"
+)
+
+func TestMain(m *testing.M) {
+	tests.RunTests(m, "attachment", nil)
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package bus delivers notifications to the subscribers of channels, once the
+// transaction that sent them has been committed.
+//
+// Each record has a channel on which a RecordChange notification is sent after
+// the transaction that created, modified or deleted it has been committed, so
+// that clients viewing the record can refresh it. Each model also has a channel
+// receiving the changes of all its records. Notifications are only sent to the
+// channels that have subscribers, so that views opt in by subscribing to the
+// records they display.
+//
+// Subscriptions are held in memory: only the subscribers of the server that
+// committed the transaction are notified.
+package bus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// SubscriptionBufferSize is the number of notifications that a subscription
+// holds until they are received. Further notifications are dropped.
+var SubscriptionBufferSize = 100
+
+var log logging.Logger
+
+// A Notification is a message sent on a channel
+type Notification struct {
+	Channel string      `json:"channel"`
+	Message interface{} `json:"message"`
+}
+
+// A RecordChange is the message of the notifications sent when a record
+// has been created, modified or deleted. Fields holds the JSON names of
+// the fields given to Create or Write.
+type RecordChange struct {
+	Model  string             `json:"model"`
+	ID     int64              `json:"id"`
+	Event  models.ChangeEvent `json:"event"`
+	Fields []string           `json:"fields,omitempty"`
+}
+
+// A Subscription receives the notifications sent on its channels in C,
+// until it is closed.
+type Subscription struct {
+	C        <-chan Notification
+	c        chan Notification
+	channels []string
+	closed   bool
+}
+
+// subscriptions holds the open subscriptions of each channel
+var subscriptions = struct {
+	sync.RWMutex
+	channels map[string]map[*Subscription]bool
+}{
+	channels: make(map[string]map[*Subscription]bool),
+}
+
+// Subscribe returns a new Subscription to the given channels.
+// It must be closed when the notifications are not needed anymore.
+func Subscribe(channels ...string) *Subscription {
+	c := make(chan Notification, SubscriptionBufferSize)
+	s := &Subscription{C: c, c: c, channels: channels}
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+	for _, channel := range channels {
+		if subscriptions.channels[channel] == nil {
+			subscriptions.channels[channel] = make(map[*Subscription]bool)
+		}
+		subscriptions.channels[channel][s] = true
+	}
+	return s
+}
+
+// Close ends this Subscription. Notifications are not received anymore
+// and C is closed.
+func (s *Subscription) Close() {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+	if s.closed {
+		return
+	}
+	for _, channel := range s.channels {
+		delete(subscriptions.channels[channel], s)
+		if len(subscriptions.channels[channel]) == 0 {
+			delete(subscriptions.channels, channel)
+		}
+	}
+	close(s.c)
+	s.closed = true
+}
+
+// HasSubscribers returns true if the given channel has at least one subscriber.
+func HasSubscribers(channel string) bool {
+	subscriptions.RLock()
+	defer subscriptions.RUnlock()
+	return len(subscriptions.channels[channel]) > 0
+}
+
+// Send sends the given message on the given channel once the transaction
+// of env has been committed. It is not sent if the transaction is rolled back.
+func Send(env models.Environment, channel string, message interface{}) {
+	env.AfterCommit(func() {
+		publish(Notification{Channel: channel, Message: message})
+	})
+}
+
+// publish delivers the given notification to the subscribers of its channel,
+// without waiting for them. The notification is dropped for subscribers whose
+// buffer is full.
+func publish(notif Notification) {
+	subscriptions.RLock()
+	defer subscriptions.RUnlock()
+	for s := range subscriptions.channels[notif.Channel] {
+		select {
+		case s.c <- notif:
+		default:
+			log.Debug("Dropping notification for full subscription", "channel", notif.Channel)
+		}
+	}
+}
+
+// RecordChannel returns the channel of the record of the given model with the given id.
+func RecordChannel(model string, id int64) string {
+	return fmt.Sprintf("%s,%d", model, id)
+}
+
+// ModelChannel returns the channel receiving the changes of all the records of the given model.
+func ModelChannel(model string) string {
+	return model
+}
+
+// sendRecordsChange sends a RecordChange notification for each record of
+// the given change on its channel and on the channel of its model, if they
+// have subscribers.
+func sendRecordsChange(env models.Environment, change models.RecordsChange) {
+	modelSubscribed := HasSubscribers(ModelChannel(change.Model))
+	for _, id := range change.IDs {
+		msg := RecordChange{
+			Model:  change.Model,
+			ID:     id,
+			Event:  change.Event,
+			Fields: change.Fields,
+		}
+		if channel := RecordChannel(change.Model, id); HasSubscribers(channel) {
+			Send(env, channel, msg)
+		}
+		if modelSubscribed {
+			Send(env, ModelChannel(change.Model), msg)
+		}
+	}
+}
+
+func init() {
+	log = logging.GetLogger("bus")
+	models.RegisterChangeListener(sendRecordsChange)
+}
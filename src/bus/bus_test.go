@@ -0,0 +1,102 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package bus
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testNoteModel = "BusTestNote"
+
+func init() {
+	noteModel := models.NewModel(testNoteModel)
+	noteModel.AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{},
+	})
+}
+
+func TestSubscriptions(t *testing.T) {
+	Convey("Testing bus subscriptions", t, func() {
+		channel := RecordChannel("Post", 12)
+		So(channel, ShouldEqual, "Post,12")
+		So(HasSubscribers(channel), ShouldBeFalse)
+		sub := Subscribe(channel, ModelChannel("Post"))
+		So(HasSubscribers(channel), ShouldBeTrue)
+		So(HasSubscribers(ModelChannel("Post")), ShouldBeTrue)
+		Convey("Notifications are delivered to the subscribers of their channel", func() {
+			publish(Notification{Channel: channel, Message: RecordChange{Model: "Post", ID: 12, Event: "write", Fields: []string{"title"}}})
+			publish(Notification{Channel: RecordChannel("Post", 13), Message: "ignored"})
+			notif := <-sub.C
+			So(notif.Channel, ShouldEqual, channel)
+			So(notif.Message, ShouldResemble, RecordChange{Model: "Post", ID: 12, Event: "write", Fields: []string{"title"}})
+			So(sub.C, ShouldBeEmpty)
+		})
+		Convey("Notifications are dropped when the subscription buffer is full", func() {
+			for i := 0; i < SubscriptionBufferSize+10; i++ {
+				publish(Notification{Channel: channel, Message: i})
+			}
+			So(len(sub.C), ShouldEqual, SubscriptionBufferSize)
+		})
+		Convey("Closed subscriptions do not receive notifications", func() {
+			sub.Close()
+			So(HasSubscribers(channel), ShouldBeFalse)
+			publish(Notification{Channel: channel, Message: "ignored"})
+			_, open := <-sub.C
+			So(open, ShouldBeFalse)
+			So(sub.Close, ShouldNotPanic)
+		})
+		Reset(sub.Close)
+	})
+}
+
+func TestRecordChanges(t *testing.T) {
+	Convey("Testing notifications of record changes", t, func() {
+		noteModel := models.Registry.MustGet(testNoteModel)
+		name := noteModel.FieldName("Name")
+		modelSub := Subscribe(ModelChannel(testNoteModel))
+		defer modelSub.Close()
+		received := func(sub *Subscription) []RecordChange {
+			var res []RecordChange
+			for len(sub.C) > 0 {
+				res = append(res, (<-sub.C).Message.(RecordChange))
+			}
+			return res
+		}
+		var id int64
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			id = noteModel.Create(env, models.NewModelData(noteModel).Set(name, "Note")).Ids()[0]
+			So(modelSub.C, ShouldBeEmpty)
+		}), ShouldBeNil)
+		changes := received(modelSub)
+		So(changes, ShouldHaveLength, 1)
+		So(changes[0].ID, ShouldEqual, id)
+		So(changes[0].Event, ShouldEqual, models.ChangeCreated)
+		So(changes[0].Fields, ShouldContain, "name")
+
+		recordSub := Subscribe(RecordChannel(testNoteModel, id))
+		defer recordSub.Close()
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			noteModel.Browse(env, []int64{id}).Set(name, "Rolled back note")
+		}), ShouldBeNil)
+		So(modelSub.C, ShouldBeEmpty)
+		So(recordSub.C, ShouldBeEmpty)
+
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			noteModel.Browse(env, []int64{id}).Set(name, "Modified note")
+		}), ShouldBeNil)
+		written := RecordChange{Model: testNoteModel, ID: id, Event: models.ChangeWritten, Fields: []string{"name"}}
+		So(received(recordSub), ShouldResemble, []RecordChange{written})
+		So(received(modelSub), ShouldResemble, []RecordChange{written})
+
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			noteModel.Browse(env, []int64{id}).Call("Unlink")
+		}), ShouldBeNil)
+		So(received(recordSub), ShouldResemble, []RecordChange{{Model: testNoteModel, ID: id, Event: models.ChangeUnlinked}})
+	})
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package jobs provides an asynchronous job queue to Hexya.
+//
+// Jobs are stored in the QueueJob model. They are created in the current
+// transaction with Environment.EnqueueJob or Enqueue and executed by a pool of
+// workers once the transaction has been committed. Each job is executed in its
+// own transaction and is retried with an increasing delay if it fails.
+package jobs
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+var log logging.Logger
+
+func init() {
+	log = logging.GetLogger("jobs")
+	declareJobModel()
+	models.RegisterJobEnqueuer(func(env models.Environment, modelName, methodName string, args []interface{}) models.RecordSet {
+		return Enqueue(env, modelName, methodName, args, Options{})
+	})
+	models.RegisterWorker(models.NewWorkerFunction(poll, PollPeriod))
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// JobModel is the name of the model storing the jobs
+const JobModel = "QueueJob"
+
+const (
+	// DefaultChannel is the channel of jobs enqueued without channel
+	DefaultChannel = "root"
+	// DefaultPriority is the priority of jobs enqueued without priority.
+	// Jobs with a lower priority value are executed first.
+	DefaultPriority int64 = 10
+	// DefaultMaxRetries is the number of times a failed job is tried
+	// before being set in the failed state, unless set otherwise.
+	DefaultMaxRetries int64 = 5
+	// RetryDelay is the delay before trying again a job that failed.
+	// It is multiplied by the square of the number of retries.
+	RetryDelay = time.Minute
+	// StaleJobDelay is the delay after which a started job that is not being
+	// executed by any worker, e.g. because its server crashed, is requeued.
+	StaleJobDelay = 10 * time.Minute
+)
+
+// Job states
+const (
+	// StatePending is the state of a job waiting to be executed
+	StatePending = "pending"
+	// StateStarted is the state of a job being executed
+	StateStarted = "started"
+	// StateDone is the state of a job that has been successfully executed
+	StateDone = "done"
+	// StateFailed is the state of a job that failed MaxRetries times
+	StateFailed = "failed"
+	// StateCancel is the state of a job that has been cancelled before being executed
+	StateCancel = "cancel"
+)
+
+// Options of a job given to Enqueue. Zero values are replaced by defaults.
+type Options struct {
+	// Description of the job. Defaults to "Model.Method".
+	Description string
+	// Channel of the job. Workers can be restricted to some channels
+	// with the Jobs.Channels configuration key.
+	Channel string
+	// Priority of the job. Jobs with a lower value are executed first.
+	Priority int64
+	// ETA is the date before which the job must not be executed
+	ETA dates.DateTime
+	// MaxRetries is the number of times the job is tried before failing.
+	MaxRetries int64
+}
+
+func declareJobModel() {
+	jobModel := models.NewModel(JobModel)
+	jobModel.AddFields(map[string]models.FieldDefinition{
+		"Name":       fields.Char{String: "Description"},
+		"ResModel":   fields.Char{String: "Model", Required: true, Index: true},
+		"MethodName": fields.Char{String: "Method", Required: true},
+		"Args":       fields.Text{String: "Arguments", Help: "JSON array of the arguments of the method"},
+		"UserID": fields.Integer{String: "User ID", Required: true,
+			Help: "ID of the user with whom the job is executed"},
+		"Channel": fields.Char{Required: true, Index: true, Default: models.DefaultValue(DefaultChannel)},
+		"Priority": fields.Integer{Default: models.DefaultValue(DefaultPriority),
+			Help: "Jobs with a lower priority are executed first"},
+		"ETA": fields.DateTime{String: "Execute Only After", NoCopy: true},
+		"State": fields.Selection{Selection: types.Selection{
+			StatePending: "Pending",
+			StateStarted: "Started",
+			StateDone:    "Done",
+			StateFailed:  "Failed",
+			StateCancel:  "Cancelled",
+		}, Required: true, Index: true, Default: models.DefaultValue(StatePending)},
+		"Retries":     fields.Integer{ReadOnly: true, NoCopy: true},
+		"MaxRetries":  fields.Integer{Default: models.DefaultValue(DefaultMaxRetries)},
+		"ExcInfo":     fields.Text{String: "Exception Info", ReadOnly: true, NoCopy: true},
+		"DateStarted": fields.DateTime{ReadOnly: true, NoCopy: true},
+		"DateDone":    fields.DateTime{ReadOnly: true, NoCopy: true},
+	})
+	jobModel.SetDefaultOrder("Priority", "ID")
+
+	jobModel.NewMethod("Requeue", jobRequeue)
+	jobModel.NewMethod("Cancel", jobCancel)
+}
+
+// Requeue sets back the jobs of this RecordSet in the pending state, so that they
+// are executed again by the workers.
+func jobRequeue(rc *models.RecordCollection) bool {
+	mdl := rc.Model()
+	res := rc.Call("Write", models.NewModelData(mdl).
+		Set(mdl.FieldName("State"), StatePending).
+		Set(mdl.FieldName("Retries"), int64(0)).
+		Set(mdl.FieldName("ExcInfo"), "").
+		Set(mdl.FieldName("ETA"), dates.DateTime{})).(bool)
+	rc.Env().AfterCommit(trigger)
+	return res
+}
+
+// Cancel the pending jobs of this RecordSet
+func jobCancel(rc *models.RecordCollection) bool {
+	mdl := rc.Model()
+	pending := rc.Search(mdl.Field(mdl.FieldName("State")).Equals(StatePending))
+	if pending.IsEmpty() {
+		return true
+	}
+	return pending.Call("Write", models.NewModelData(mdl).Set(mdl.FieldName("State"), StateCancel)).(bool)
+}
+
+// Enqueue creates in the given environment a job that calls the given method of
+// the given model with args and the given options, and returns the created job.
+//
+// The job will be executed by the workers after the transaction has been committed,
+// with the user of env. args must be JSON serializable and must be decodable into
+// the types of the method's parameters. RecordSets and Conditions cannot be used as
+// arguments, pass ids instead.
+func Enqueue(env models.Environment, modelName, methodName string, args []interface{}, opts Options) *models.RecordCollection {
+	model, ok := models.Registry.Get(modelName)
+	if !ok {
+		log.Panic("Unknown model for job", "model", modelName)
+	}
+	method, ok := model.Methods().Get(methodName)
+	if !ok {
+		log.Panic("Unknown method for job", "model", modelName, "method", methodName)
+	}
+	if err := checkArgsCount(method.MethodType(), len(args)); err != nil {
+		log.Panic("Invalid arguments for job", "model", modelName, "method", methodName, "error", err)
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		log.Panic("Unable to serialize job arguments", "model", modelName, "method", methodName, "error", err)
+	}
+	jobRC := env.Pool(JobModel)
+	mdl := jobRC.Model()
+	data := models.NewModelData(mdl).
+		Set(mdl.FieldName("Name"), fmt.Sprintf("%s.%s", model.Name(), methodName)).
+		Set(mdl.FieldName("ResModel"), model.Name()).
+		Set(mdl.FieldName("MethodName"), methodName).
+		Set(mdl.FieldName("Args"), string(argsJSON)).
+		Set(mdl.FieldName("UserID"), env.Uid())
+	if opts.Description != "" {
+		data.Set(mdl.FieldName("Name"), opts.Description)
+	}
+	if opts.Channel != "" {
+		data.Set(mdl.FieldName("Channel"), opts.Channel)
+	}
+	if opts.Priority != 0 {
+		data.Set(mdl.FieldName("Priority"), opts.Priority)
+	}
+	if !opts.ETA.IsZero() {
+		data.Set(mdl.FieldName("ETA"), opts.ETA)
+	}
+	if opts.MaxRetries != 0 {
+		data.Set(mdl.FieldName("MaxRetries"), opts.MaxRetries)
+	}
+	res := jobRC.Sudo().Call("Create", data).(models.RecordSet).Collection()
+	env.AfterCommit(trigger)
+	return res.WithEnv(env)
+}
+
+// checkArgsCount returns an error if the method with the given type
+// cannot be called with argsCount arguments.
+//
+// As with RecordCollection.Call, the variadic arguments of a method
+// must be given as a single slice, which may be omitted.
+func checkArgsCount(methType reflect.Type, argsCount int) error {
+	expected := methType.NumIn() - 1
+	if argsCount == expected || methType.IsVariadic() && argsCount == expected-1 {
+		return nil
+	}
+	return fmt.Errorf("method expects %d arguments, %d given", expected, argsCount)
+}
+
+// decodeArgs decodes the given JSON array of arguments into
+// the types of the parameters of the given method type.
+func decodeArgs(methType reflect.Type, data string) ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("unable to decode arguments: %s", err)
+	}
+	if err := checkArgsCount(methType, len(raw)); err != nil {
+		return nil, err
+	}
+	res := make([]interface{}, len(raw))
+	for i, r := range raw {
+		val := reflect.New(methType.In(i + 1))
+		if err := json.Unmarshal(r, val.Interface()); err != nil {
+			return nil, fmt.Errorf("unable to decode argument %d: %s", i, err)
+		}
+		res[i] = val.Elem().Interface()
+	}
+	return res, nil
+}
+
+// retryDelay returns the delay before executing again
+// a job that failed the given number of times.
+func retryDelay(retries int64) time.Duration {
+	return time.Duration(retries*retries) * RetryDelay
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package jobs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+type jobParams struct {
+	Name  string
+	Count int
+}
+
+func TestJobArguments(t *testing.T) {
+	Convey("Testing job arguments", t, func() {
+		methType := reflect.TypeOf(func(*models.RecordCollection, string, jobParams) {})
+		variadicType := reflect.TypeOf(func(*models.RecordCollection, int64, ...int64) {})
+		Convey("Arguments count is checked against the method", func() {
+			So(checkArgsCount(methType, 2), ShouldBeNil)
+			So(checkArgsCount(methType, 1), ShouldNotBeNil)
+			So(checkArgsCount(variadicType, 1), ShouldBeNil)
+			So(checkArgsCount(variadicType, 2), ShouldBeNil)
+			So(checkArgsCount(variadicType, 3), ShouldNotBeNil)
+		})
+		Convey("Arguments are decoded into the method's parameter types", func() {
+			args, err := decodeArgs(methType, `["foo", {"Name": "bar", "Count": 3}]`)
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{"foo", jobParams{Name: "bar", Count: 3}})
+			args, err = decodeArgs(variadicType, `[1, [2, 3]]`)
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{int64(1), []int64{2, 3}})
+		})
+		Convey("Invalid arguments return an error", func() {
+			_, err := decodeArgs(methType, `["foo"]`)
+			So(err, ShouldNotBeNil)
+			_, err = decodeArgs(methType, `["foo", 3]`)
+			So(err, ShouldNotBeNil)
+			_, err = decodeArgs(methType, `{}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestJobWorkers(t *testing.T) {
+	Convey("Testing job workers configuration", t, func() {
+		Convey("Retry delay increases with the number of retries", func() {
+			So(retryDelay(1), ShouldEqual, time.Minute)
+			So(retryDelay(3), ShouldEqual, 9*time.Minute)
+		})
+		Convey("Workers and channels are read from config", func() {
+			So(Workers(), ShouldEqual, DefaultWorkers)
+			So(Channels(), ShouldBeEmpty)
+			viper.Set("Jobs.Workers", 4)
+			viper.Set("Jobs.Channels", []string{"root", "mail"})
+			defer viper.Set("Jobs.Workers", 0)
+			defer viper.Set("Jobs.Channels", []string{})
+			So(Workers(), ShouldEqual, 4)
+			So(Channels(), ShouldResemble, []string{"root", "mail"})
+		})
+	})
+}
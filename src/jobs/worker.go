@@ -0,0 +1,235 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/spf13/viper"
+)
+
+const (
+	// PollPeriod is the time between two runs of the job workers
+	// when they are not triggered by newly enqueued jobs.
+	PollPeriod = 30 * time.Second
+	// DefaultWorkers is the number of jobs executed concurrently
+	// if the Jobs.Workers configuration key is not set.
+	DefaultWorkers = 2
+)
+
+var (
+	// started is set to 1 once the worker loop is running
+	started int32
+	// busy is set to 1 while the workers are executing jobs
+	busy int32
+	// pending is set to 1 when jobs have been enqueued while the
+	// workers were busy, so that they are run again.
+	pending int32
+)
+
+// Workers returns the number of jobs executed concurrently.
+// It is set by the Jobs.Workers configuration key.
+func Workers() int {
+	if workers := viper.GetInt("Jobs.Workers"); workers > 0 {
+		return workers
+	}
+	return DefaultWorkers
+}
+
+// Channels returns the channels of the jobs executed by this server.
+// It is set by the Jobs.Channels configuration key. An empty list
+// means that jobs of all channels are executed.
+func Channels() []string {
+	return viper.GetStringSlice("Jobs.Channels")
+}
+
+// poll is the worker function that requeues stale jobs
+// and executes the due jobs.
+func poll() {
+	atomic.StoreInt32(&started, 1)
+	recoverStaleJobs()
+	processJobs()
+}
+
+// trigger executes the due jobs in background if the
+// worker loop is running and not stopping. It is called
+// after commit when jobs have been enqueued.
+func trigger() {
+	if atomic.LoadInt32(&started) == 0 {
+		return
+	}
+	models.GoWorker(processJobs)
+}
+
+// processJobs executes all due jobs with Workers() concurrent workers.
+// If the workers are already busy, they are told to run again instead.
+// Workers return after their current job when the worker loop is stopping.
+func processJobs() {
+	if !atomic.CompareAndSwapInt32(&busy, 0, 1) {
+		atomic.StoreInt32(&pending, 1)
+		return
+	}
+	defer atomic.StoreInt32(&busy, 0)
+	for {
+		atomic.StoreInt32(&pending, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < Workers(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for !models.WorkerLoopStopping() && runNextJob() {
+				}
+			}()
+		}
+		wg.Wait()
+		if atomic.LoadInt32(&pending) == 0 || models.WorkerLoopStopping() {
+			return
+		}
+	}
+}
+
+// runNextJob executes the next due job and returns true,
+// or returns false if there is no due job.
+func runNextJob() bool {
+	id, uid, ok := acquireJob()
+	if !ok {
+		return false
+	}
+	if err := performJob(id, uid); err != nil {
+		log.Warn("Error while executing job", "id", id, "error", err)
+		recordFailure(id, err)
+	}
+	return true
+}
+
+// dueJobsCondition returns the condition on the job model
+// for the jobs that must be executed by this server.
+func dueJobsCondition(mdl *models.Model) *models.Condition {
+	eta := mdl.FieldName("ETA")
+	cond := mdl.Field(mdl.FieldName("State")).Equals(StatePending).
+		AndCond(mdl.Field(eta).IsNull().Or().Field(eta).LowerOrEqual(dates.Now()))
+	if channels := Channels(); len(channels) > 0 {
+		cond = cond.And().Field(mdl.FieldName("Channel")).In(channels)
+	}
+	return cond
+}
+
+// acquireJob sets the next due job in the started state and returns its
+// id and the id of its user. The job row is locked with SKIP LOCKED so
+// that concurrent workers never acquire the same job.
+//
+// ok is false if there is no due job.
+func acquireJob() (id int64, uid int64, ok bool) {
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		jobRC := env.Pool(JobModel)
+		mdl := jobRC.Model()
+		job := jobRC.Search(dueJobsCondition(mdl)).OrderBy("Priority", "ID").Limit(1).ForUpdateSkipLocked()
+		if job.IsEmpty() {
+			ok = false
+			return
+		}
+		id, uid, ok = job.Ids()[0], job.Get(mdl.FieldName("UserID")).(int64), true
+		job.Call("Write", models.NewModelData(mdl).
+			Set(mdl.FieldName("State"), StateStarted).
+			Set(mdl.FieldName("DateStarted"), dates.Now()))
+	})
+	if err != nil {
+		log.Warn("Error while acquiring job", "error", err)
+		return 0, 0, false
+	}
+	return
+}
+
+// performJob calls the method of the job with the given id as the given user, and
+// sets the job in the done state in the same transaction.
+//
+// The job row is locked during the whole execution, so that it is not taken
+// for a stale job by recoverStaleJobs. The context of the user (e.g. language
+// and timezone) is set in the environment of the method.
+func performJob(id, uid int64) error {
+	return models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		env = env.WithUser(uid)
+		jobRC := env.Pool(JobModel).Sudo()
+		mdl := jobRC.Model()
+		job := jobRC.Search(mdl.Field(models.ID).Equals(id)).ForUpdate()
+		if job.IsEmpty() {
+			panic(errors.New("job has been deleted"))
+		}
+		if job.Get(mdl.FieldName("State")).(string) != StateStarted {
+			// The job has been requeued or cancelled since it was acquired
+			log.Warn("Job is not started anymore and is not executed", "id", id)
+			return
+		}
+		modelName := job.Get(mdl.FieldName("ResModel")).(string)
+		methodName := job.Get(mdl.FieldName("MethodName")).(string)
+		method := models.Registry.MustGet(modelName).Methods().MustGet(methodName)
+		args, err := decodeArgs(method.MethodType(), job.Get(mdl.FieldName("Args")).(string))
+		if err != nil {
+			panic(err)
+		}
+		env.Pool(modelName).CallMulti(methodName, args...)
+		job.Call("Write", models.NewModelData(mdl).
+			Set(mdl.FieldName("State"), StateDone).
+			Set(mdl.FieldName("DateDone"), dates.Now()).
+			Set(mdl.FieldName("ExcInfo"), ""))
+	})
+}
+
+// recordFailure sets back the job with the given id in the pending state to be
+// retried later, or in the failed state if it has been tried MaxRetries times.
+func recordFailure(id int64, jobErr error) {
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		job := env.Pool(JobModel).Call("BrowseOne", id).(models.RecordSet).Collection()
+		if job.IsEmpty() {
+			return
+		}
+		failJob(job, jobErr)
+	})
+	if err != nil {
+		log.Warn("Error while recording job failure", "id", id, "error", err)
+	}
+}
+
+// failJob records the given error on the given job and sets it back in the
+// pending state, or in the failed state if it has been tried MaxRetries times.
+func failJob(job *models.RecordCollection, jobErr error) {
+	mdl := job.Model()
+	retries := job.Get(mdl.FieldName("Retries")).(int64) + 1
+	data := models.NewModelData(mdl).
+		Set(mdl.FieldName("Retries"), retries).
+		Set(mdl.FieldName("ExcInfo"), jobErr.Error()).
+		Set(mdl.FieldName("State"), StatePending).
+		Set(mdl.FieldName("ETA"), dates.Now().Add(retryDelay(retries)))
+	if retries >= job.Get(mdl.FieldName("MaxRetries")).(int64) {
+		data.Set(mdl.FieldName("State"), StateFailed)
+	}
+	job.Call("Write", data)
+}
+
+// recoverStaleJobs requeues as failed attempts the jobs that have been started
+// more than StaleJobDelay ago and are not being executed anymore, typically
+// because the server executing them has been stopped abruptly.
+//
+// Jobs being executed are locked by their worker and skipped.
+func recoverStaleJobs() {
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		jobRC := env.Pool(JobModel)
+		mdl := jobRC.Model()
+		stale := jobRC.Search(mdl.Field(mdl.FieldName("State")).Equals(StateStarted).
+			And().Field(mdl.FieldName("DateStarted")).LowerOrEqual(dates.Now().Add(-StaleJobDelay))).ForUpdateSkipLocked()
+		for _, job := range stale.Records() {
+			log.Warn("Requeuing stale job", "id", job.Ids()[0])
+			failJob(job, errors.New("job has been interrupted"))
+		}
+	})
+	if err != nil {
+		log.Warn("Error while recovering stale jobs", "error", err)
+	}
+}
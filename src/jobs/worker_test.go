@@ -0,0 +1,122 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package jobs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testModel = "JobTestModel"
+
+var (
+	blockStarted   = make(chan struct{})
+	blockRelease   = make(chan struct{})
+	blockCommitted int32
+)
+
+func init() {
+	testMdl := models.NewModel(testModel)
+	testMdl.NewMethod("Block", func(rc *models.RecordCollection) {
+		close(blockStarted)
+		<-blockRelease
+		rc.Env().AfterCommit(func() {
+			atomic.StoreInt32(&blockCommitted, 1)
+		})
+	})
+	testMdl.NewMethod("Noop", func(rc *models.RecordCollection) {})
+}
+
+func TestStaleJobs(t *testing.T) {
+	Convey("Testing the recovery of stale jobs", t, func() {
+		mdl := models.Registry.MustGet(JobModel)
+		state := mdl.FieldName("State")
+		var staleID, recentID, lockedID int64
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			newStartedJob := func(started dates.DateTime) int64 {
+				job := Enqueue(env, testModel, "Noop", nil, Options{ETA: dates.Now().AddDate(1, 0, 0)})
+				job.Call("Write", models.NewModelData(mdl).
+					Set(state, StateStarted).
+					Set(mdl.FieldName("DateStarted"), started))
+				return job.Ids()[0]
+			}
+			staleID = newStartedJob(dates.Now().Add(-2 * StaleJobDelay))
+			recentID = newStartedJob(dates.Now())
+			lockedID = newStartedJob(dates.Now().Add(-2 * StaleJobDelay))
+		}), ShouldBeNil)
+		checkJob := func(id int64, check func(job *models.RecordCollection)) {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				check(mdl.BrowseOne(env, id))
+			}), ShouldBeNil)
+		}
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			// Lock the job as a running worker would do
+			So(env.Pool(JobModel).Search(mdl.Field(models.ID).Equals(lockedID)).ForUpdate().Len(), ShouldEqual, 1)
+			recoverStaleJobs()
+		}), ShouldBeNil)
+		Convey("Stale jobs are requeued as failed attempts", func() {
+			checkJob(staleID, func(job *models.RecordCollection) {
+				So(job.Get(state), ShouldEqual, StatePending)
+				So(job.Get(mdl.FieldName("Retries")), ShouldEqual, 1)
+				So(job.Get(mdl.FieldName("ExcInfo")), ShouldEqual, "job has been interrupted")
+			})
+		})
+		Convey("Recently started and locked jobs are not requeued", func() {
+			checkJob(recentID, func(job *models.RecordCollection) {
+				So(job.Get(state), ShouldEqual, StateStarted)
+			})
+			checkJob(lockedID, func(job *models.RecordCollection) {
+				So(job.Get(state), ShouldEqual, StateStarted)
+				So(job.Get(mdl.FieldName("Retries")), ShouldEqual, 0)
+			})
+		})
+		Convey("Jobs that are not started anymore are not executed", func() {
+			So(performJob(staleID, security.SuperUserID), ShouldBeNil)
+			checkJob(staleID, func(job *models.RecordCollection) {
+				So(job.Get(state), ShouldEqual, StatePending)
+				So(job.Get(mdl.FieldName("DateDone")).(dates.DateTime).IsZero(), ShouldBeTrue)
+			})
+			So(performJob(recentID, security.SuperUserID), ShouldBeNil)
+			checkJob(recentID, func(job *models.RecordCollection) {
+				So(job.Get(state), ShouldEqual, StateDone)
+			})
+		})
+	})
+}
+
+// TestShutdownWaitsForJobs must be the last test of the package,
+// since shutting down the server closes the database.
+func TestShutdownWaitsForJobs(t *testing.T) {
+	Convey("Shutting down the server should wait for the running jobs", t, func() {
+		models.RunWorkerLoop()
+		atomic.StoreInt32(&started, 1)
+		So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			env.EnqueueJob(testModel, "Block")
+		}), ShouldBeNil)
+		<-blockStarted
+		shutdownDone := make(chan error)
+		go func() {
+			shutdownDone <- server.Shutdown(context.Background())
+		}()
+		var returnedEarly bool
+		select {
+		case <-shutdownDone:
+			returnedEarly = true
+		case <-time.After(200 * time.Millisecond):
+		}
+		So(returnedEarly, ShouldBeFalse)
+		So(models.WorkerLoopStopping(), ShouldBeTrue)
+		close(blockRelease)
+		So(<-shutdownDone, ShouldBeNil)
+		So(atomic.LoadInt32(&blockCommitted), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,23 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package mail provides email sending facilities to Hexya.
+//
+// Emails are not sent directly, but queued in the MailMail outbox model
+// in the current transaction. A worker then sends queued emails
+// through the configured MailServer once the transaction has been committed.
+package mail
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+var log logging.Logger
+
+func init() {
+	log = logging.GetLogger("mail")
+	declareMailModel()
+	declareTemplateModel()
+	models.RegisterWorker(models.NewWorkerFunction(processQueue, QueuePeriod))
+}
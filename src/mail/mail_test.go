@@ -0,0 +1,297 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+type testServer struct {
+	from  string
+	to    []string
+	msg   []byte
+	err   error
+	count int
+}
+
+func (ts *testServer) Send(from string, to []string, msg []byte) error {
+	ts.from, ts.to, ts.msg = from, to, msg
+	ts.count++
+	return ts.err
+}
+
+func TestMessage(t *testing.T) {
+	Convey("Testing mail messages", t, func() {
+		msg := Message{
+			From:    "hexya@example.com",
+			To:      []string{"john@example.com", " "},
+			Cc:      []string{"jane@example.com"},
+			Subject: "Hello",
+			Body:    "Hello John",
+		}
+		Convey("Recipients are To and Cc addresses without empty ones", func() {
+			So(msg.Recipients(), ShouldResemble, []string{"john@example.com", "jane@example.com"})
+		})
+		Convey("Plain text messages are correctly formatted", func() {
+			res := string(msg.Bytes())
+			So(res, ShouldStartWith, "From: hexya@example.com\r\n")
+			So(res, ShouldContainSubstring, "Cc: jane@example.com\r\n")
+			So(res, ShouldContainSubstring, "Subject: Hello\r\n")
+			So(res, ShouldContainSubstring, "Content-Type: text/plain; charset=\"utf-8\"\r\n")
+			So(res, ShouldEndWith, "\r\n\r\nHello John")
+		})
+		Convey("Messages are dated with the clock of the dates package", func() {
+			dates.SetClock(dates.NewFakeClock(time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)))
+			defer dates.SetClock(nil)
+			So(string(msg.Bytes()), ShouldContainSubstring, "Date: Sat, 14 Mar 2020 15:09:26 +0000\r\n")
+		})
+		Convey("HTML messages have the correct content type and encoded subject", func() {
+			msg.HTML = true
+			msg.Subject = "Héllo"
+			res := string(msg.Bytes())
+			So(res, ShouldContainSubstring, "Content-Type: text/html; charset=\"utf-8\"\r\n")
+			So(res, ShouldContainSubstring, "Subject: =?utf-8?q?H=C3=A9llo?=\r\n")
+		})
+		Convey("Addresses lists are correctly split", func() {
+			So(splitAddresses("a@example.com, b@example.com,,"), ShouldResemble, []string{"a@example.com", "b@example.com"})
+			So(splitAddresses(""), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMailServer(t *testing.T) {
+	Convey("Testing mail servers", t, func() {
+		Convey("Default server is configured from viper", func() {
+			viper.Set("Mail.Host", "smtp.example.com")
+			viper.Set("Mail.User", "hexya")
+			ms, ok := Server().(*SMTPServer)
+			So(ok, ShouldBeTrue)
+			So(ms.Host, ShouldEqual, "smtp.example.com")
+			So(ms.Port, ShouldEqual, "25")
+			So(ms.User, ShouldEqual, "hexya")
+		})
+		Convey("Custom server can be plugged in", func() {
+			ts := &testServer{err: errors.New("no route")}
+			SetServer(ts)
+			defer SetServer(nil)
+			msg := Message{From: "hexya@example.com", To: []string{"john@example.com"}, Body: "Hi"}
+			err := Server().Send(msg.From, msg.Recipients(), msg.Bytes())
+			So(err, ShouldNotBeNil)
+			So(ts.from, ShouldEqual, "hexya@example.com")
+			So(ts.to, ShouldResemble, []string{"john@example.com"})
+			So(strings.HasSuffix(string(ts.msg), "Hi"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	Convey("Testing the mail outbox", t, func() {
+		ts := new(testServer)
+		SetServer(ts)
+		defer SetServer(nil)
+		mdl := models.Registry.MustGet("MailMail")
+		inNewEnv := func(fnct func(env models.Environment)) {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, fnct), ShouldBeNil)
+		}
+		checkMail := func(id int64, check func(mail *models.RecordCollection)) {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				check(mdl.BrowseOne(env, id))
+			}), ShouldBeNil)
+		}
+		var id int64
+		inNewEnv(func(env models.Environment) {
+			// Cancel the mails queued by previous runs
+			env.Pool("MailMail").SearchAll().Call("Cancel")
+			mail := Queue(env, Message{
+				From:    "hexya@example.com",
+				To:      []string{"john@example.com", "jane@example.com"},
+				Subject: "Outbox",
+				Body:    "Queued mail",
+			}, nil)
+			id = mail.Ids()[0]
+		})
+		Convey("Queued mails are outgoing and not sent", func() {
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateOutgoing)
+				So(mail.Get(mdl.FieldName("EmailTo")), ShouldEqual, "john@example.com, jane@example.com")
+				So(mail.Get(mdl.FieldName("ResModel")), ShouldBeBlank)
+			})
+			So(ts.count, ShouldEqual, 0)
+		})
+		Convey("Mails are sent only once the transaction is committed", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Send")
+				So(ts.count, ShouldEqual, 0)
+			}), ShouldBeNil)
+			So(ts.count, ShouldEqual, 0)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateOutgoing)
+			})
+			inNewEnv(func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Send")
+				So(ts.count, ShouldEqual, 0)
+			})
+			So(ts.count, ShouldEqual, 1)
+			So(ts.from, ShouldEqual, "hexya@example.com")
+			So(ts.to, ShouldResemble, []string{"john@example.com", "jane@example.com"})
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateSent)
+				So(mail.Get(mdl.FieldName("SentDate")).(dates.DateTime).IsZero(), ShouldBeFalse)
+			})
+			inNewEnv(func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Send")
+			})
+			So(ts.count, ShouldEqual, 1)
+		})
+		Convey("Failed mails are retried until MaxRetries", func() {
+			ts.err = errors.New("no route")
+			inNewEnv(func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Send")
+			})
+			So(ts.count, ShouldEqual, 1)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateOutgoing)
+				So(mail.Get(mdl.FieldName("Retries")), ShouldEqual, 1)
+				So(mail.Get(mdl.FieldName("FailureReason")), ShouldEqual, "no route")
+				So(mail.Get(mdl.FieldName("SentDate")).(dates.DateTime).IsZero(), ShouldBeTrue)
+				So(mail.Get(mdl.FieldName("ScheduledDate")).(dates.DateTime).Greater(dates.Now()), ShouldBeTrue)
+			})
+			processQueue()
+			So(ts.count, ShouldEqual, 1)
+			inNewEnv(func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Write", models.NewModelData(mdl).
+					Set(mdl.FieldName("Retries"), MaxRetries-1).
+					Set(mdl.FieldName("ScheduledDate"), dates.Now().Add(-RetryDelay)))
+			})
+			processQueue()
+			So(ts.count, ShouldEqual, 2)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateException)
+				So(mail.Get(mdl.FieldName("Retries")), ShouldEqual, MaxRetries)
+			})
+			inNewEnv(func(env models.Environment) {
+				So(mdl.BrowseOne(env, id).Call("Retry"), ShouldBeTrue)
+			})
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateOutgoing)
+				So(mail.Get(mdl.FieldName("Retries")), ShouldEqual, 0)
+			})
+			ts.err = nil
+			processQueue()
+			So(ts.count, ShouldEqual, 3)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateSent)
+			})
+		})
+		Convey("Mails left in the sending state are requeued after StaleSendingDelay", func() {
+			setSending := func(date dates.DateTime) {
+				inNewEnv(func(env models.Environment) {
+					mdl.BrowseOne(env, id).Call("Write", models.NewModelData(mdl).
+						Set(mdl.FieldName("State"), StateSending).
+						Set(mdl.FieldName("SendingDate"), date))
+				})
+			}
+			setSending(dates.Now())
+			processQueue()
+			So(ts.count, ShouldEqual, 0)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateSending)
+			})
+			setSending(dates.Now().Add(-2 * StaleSendingDelay))
+			processQueue()
+			So(ts.count, ShouldEqual, 0)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateOutgoing)
+				So(mail.Get(mdl.FieldName("Retries")), ShouldEqual, 1)
+				So(mail.Get(mdl.FieldName("FailureReason")), ShouldEqual, "sending has been interrupted")
+			})
+			inNewEnv(func(env models.Environment) {
+				mdl.BrowseOne(env, id).Call("Write", models.NewModelData(mdl).
+					Set(mdl.FieldName("ScheduledDate"), dates.Now().Add(-RetryDelay)))
+			})
+			processQueue()
+			So(ts.count, ShouldEqual, 1)
+			checkMail(id, func(mail *models.RecordCollection) {
+				So(mail.Get(mdl.FieldName("State")), ShouldEqual, StateSent)
+			})
+		})
+	})
+}
+
+func TestMailTemplate(t *testing.T) {
+	Convey("Testing mail templates", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			mailModel := models.Registry.MustGet("MailMail")
+			tmplModel := models.Registry.MustGet("MailTemplate")
+			order1 := Queue(env, Message{
+				From:    "hexya@example.com",
+				To:      []string{"john@example.com"},
+				Subject: "Order 1",
+				Body:    "Tom & Jerry",
+			}, nil)
+			order2 := Queue(env, Message{
+				From:    "hexya@example.com",
+				To:      []string{"jane@example.com"},
+				Subject: "Q&A",
+				Body:    "Order 2",
+			}, nil)
+			orders := order1.Union(order2)
+			template := env.Pool("MailTemplate").Call("Create", models.NewModelData(tmplModel).
+				Set(tmplModel.FieldName("Name"), "Confirmation").
+				Set(tmplModel.FieldName("Model"), "MailMail").
+				Set(tmplModel.FieldName("EmailFrom"), "shop@example.com").
+				Set(tmplModel.FieldName("EmailTo"), `{{ get .Record "EmailTo" }}, sales@example.com`).
+				Set(tmplModel.FieldName("Subject"), `Re: {{ get .Record "Subject" }}`).
+				Set(tmplModel.FieldName("Body"), `<p>{{ get .Record "Body" }}</p>`).
+				Set(tmplModel.FieldName("IsHTML"), true)).(models.RecordSet).Collection()
+			Convey("Render returns a message per record", func() {
+				messages := template.Call("Render", orders).(map[int64]Message)
+				So(messages, ShouldHaveLength, 2)
+				msg1 := messages[order1.Ids()[0]]
+				So(msg1.From, ShouldEqual, "shop@example.com")
+				So(msg1.To, ShouldResemble, []string{"john@example.com", "sales@example.com"})
+				So(msg1.Cc, ShouldBeEmpty)
+				So(msg1.Subject, ShouldEqual, "Re: Order 1")
+				So(msg1.HTML, ShouldBeTrue)
+				So(messages[order2.Ids()[0]].To, ShouldResemble, []string{"jane@example.com", "sales@example.com"})
+			})
+			Convey("Only HTML bodies are escaped", func() {
+				messages := template.Call("Render", orders).(map[int64]Message)
+				So(messages[order1.Ids()[0]].Body, ShouldEqual, "<p>Tom &amp; Jerry</p>")
+				So(messages[order2.Ids()[0]].Subject, ShouldEqual, "Re: Q&A")
+				template.Set(tmplModel.FieldName("IsHTML"), false)
+				messages = template.Call("Render", orders).(map[int64]Message)
+				So(messages[order1.Ids()[0]].Body, ShouldEqual, "<p>Tom & Jerry</p>")
+				So(messages[order1.Ids()[0]].HTML, ShouldBeFalse)
+			})
+			Convey("Rendering on another model or with a bad template panics", func() {
+				So(func() { template.Call("Render", template) }, ShouldPanic)
+				template.Set(tmplModel.FieldName("Subject"), `{{ get .Record "Unknown" }}`)
+				So(func() { template.Call("Render", order1) }, ShouldPanic)
+			})
+			Convey("SendMail queues the rendered mails linked to their records", func() {
+				mails := template.Call("SendMail", orders).(models.RecordSet).Collection()
+				So(mails.Len(), ShouldEqual, 2)
+				for _, mail := range mails.Records() {
+					So(mail.Get(mailModel.FieldName("State")), ShouldEqual, StateOutgoing)
+					So(mail.Get(mailModel.FieldName("EmailFrom")), ShouldEqual, "shop@example.com")
+					So(mail.Get(mailModel.FieldName("ResModel")), ShouldEqual, "MailMail")
+					So(mail.Get(mailModel.FieldName("IsHTML")), ShouldBeTrue)
+					target := mailModel.BrowseOne(env, mail.Get(mailModel.FieldName("ResID")).(int64))
+					So(target.Intersect(orders).Len(), ShouldEqual, 1)
+					So(mail.Get(mailModel.FieldName("Subject")), ShouldEqual, "Re: "+target.Get(mailModel.FieldName("Subject")).(string))
+				}
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	html "html/template"
+	"mime"
+	"strings"
+	text "text/template"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// A Message is an email message ready to be sent.
+type Message struct {
+	From    string
+	To      []string
+	Cc      []string
+	Subject string
+	Body    string
+	HTML    bool
+}
+
+// Recipients returns all the addresses this message must be sent to.
+func (m Message) Recipients() []string {
+	var res []string
+	for _, addr := range append(m.To, m.Cc...) {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// Bytes returns the RFC 822 representation of this message.
+func (m Message) Bytes() []byte {
+	var buf bytes.Buffer
+	contentType := "text/plain"
+	if m.HTML {
+		contentType = "text/html"
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	if len(m.Cc) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", strings.Join(m.Cc, ", "))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", dates.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: %s; charset=\"utf-8\"\r\n", contentType)
+	buf.WriteString("\r\n")
+	buf.WriteString(m.Body)
+	return buf.Bytes()
+}
+
+// splitAddresses splits the given comma separated list of addresses
+func splitAddresses(addresses string) []string {
+	var res []string
+	for _, addr := range strings.Split(addresses, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// templateFuncs are the functions available inside mail templates.
+var templateFuncs = map[string]interface{}{
+	"get": func(rs models.RecordSet, path string) interface{} {
+		return rs.Get(rs.Collection().Model().FieldName(path))
+	},
+}
+
+// templateData returns the data passed to templates rendered for the given record.
+//
+// The User and Company keys are set only if the corresponding models exist.
+func templateData(record models.RecordSet) map[string]interface{} {
+	env := record.Env()
+	data := map[string]interface{}{
+		"Record": record,
+		"Env":    env,
+	}
+	if _, ok := models.Registry.Get("User"); ok {
+		user := env.Pool("User").Call("BrowseOne", env.Uid()).(models.RecordSet).Collection()
+		data["User"] = user
+		if _, ok := user.Model().Fields().Get("Company"); ok {
+			data["Company"] = user.Get(user.Model().FieldName("Company"))
+		}
+	}
+	return data
+}
+
+// RenderTemplate renders the given Go template with the given record as context.
+//
+// The record is accessible as .Record in the template and the 'get' function can
+// be used to retrieve field values, including related ones: {{ get .Record "User.Name" }}.
+// The current user and the user's company are available as .User and .Company.
+// If isHTML is true, the template is rendered with html/template, otherwise with text/template.
+func RenderTemplate(tmpl string, record models.RecordSet, isHTML bool) (string, error) {
+	data := templateData(record)
+	var res bytes.Buffer
+	switch isHTML {
+	case true:
+		t, err := html.New("").Funcs(templateFuncs).Parse(tmpl)
+		if err != nil {
+			return "", err
+		}
+		if err = t.Execute(&res, data); err != nil {
+			return "", err
+		}
+	case false:
+		t, err := text.New("").Funcs(templateFuncs).Parse(tmpl)
+		if err != nil {
+			return "", err
+		}
+		if err = t.Execute(&res, data); err != nil {
+			return "", err
+		}
+	}
+	return res.String(), nil
+}
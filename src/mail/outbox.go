@@ -0,0 +1,282 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+const (
+	// MaxRetries is the number of times we try to send a mail
+	// before setting it in the exception state.
+	MaxRetries int64 = 3
+	// RetryDelay is the delay before trying again to send a mail
+	// that failed. It is multiplied by the number of retries.
+	RetryDelay = 5 * time.Minute
+	// QueuePeriod is the time between two runs of the outbox worker.
+	QueuePeriod = time.Minute
+	// QueueBatchSize is the maximum number of mails sent at each run of the outbox worker.
+	QueueBatchSize = 100
+	// StaleSendingDelay is the delay after which a mail in the sending state that
+	// is not being sent anymore, e.g. because its server crashed, is requeued.
+	StaleSendingDelay = 10 * time.Minute
+)
+
+// Mail states
+const (
+	// StateOutgoing is the state of a mail waiting to be sent
+	StateOutgoing = "outgoing"
+	// StateSending is the state of a mail which is being sent after the commit of its transaction
+	StateSending = "sending"
+	// StateSent is the state of a mail that has been successfully sent
+	StateSent = "sent"
+	// StateException is the state of a mail that could not be sent after MaxRetries attempts
+	StateException = "exception"
+	// StateCancel is the state of a mail that has been cancelled before being sent
+	StateCancel = "cancel"
+)
+
+func declareMailModel() {
+	mailModel := models.NewModel("MailMail")
+	mailModel.AddFields(map[string]models.FieldDefinition{
+		"EmailFrom": fields.Char{String: "From", Required: true},
+		"EmailTo":   fields.Char{String: "To", Help: "Comma separated list of recipients addresses"},
+		"EmailCc":   fields.Char{String: "Cc", Help: "Comma separated list of carbon copy addresses"},
+		"Subject":   fields.Char{},
+		"Body":      fields.Text{},
+		"IsHTML":    fields.Boolean{String: "HTML Body"},
+		"ResModel":  fields.Char{String: "Related Document Model", Index: true},
+		"ResID":     fields.Integer{String: "Related Document ID", Index: true},
+		"State": fields.Selection{Selection: types.Selection{
+			StateOutgoing:  "Outgoing",
+			StateSending:   "Sending",
+			StateSent:      "Sent",
+			StateException: "Delivery Failed",
+			StateCancel:    "Cancelled",
+		}, Required: true, Index: true, Default: models.DefaultValue(StateOutgoing)},
+		"Retries": fields.Integer{ReadOnly: true, NoCopy: true},
+		"FailureReason": fields.Text{ReadOnly: true, NoCopy: true,
+			Help: "Error message of the last failed sending attempt"},
+		"ScheduledDate": fields.DateTime{String: "Scheduled Send Date", NoCopy: true,
+			Help: "If set, the mail will not be sent before this date"},
+		"SendingDate": fields.DateTime{String: "Sending Start Date", ReadOnly: true, NoCopy: true,
+			Help: "Date at which the last sending attempt started"},
+		"SentDate": fields.DateTime{ReadOnly: true, NoCopy: true},
+	})
+	mailModel.SetDefaultOrder("ID")
+
+	mailModel.NewMethod("Message", mailMessage)
+	mailModel.NewMethod("Send", mailSend)
+	mailModel.NewMethod("Retry", mailRetry)
+	mailModel.NewMethod("Cancel", mailCancel)
+	mailModel.NewMethod("ProcessQueue", mailProcessQueue)
+}
+
+// Message returns the Message to send for this mail record
+func mailMessage(rc *models.RecordCollection) Message {
+	rc.EnsureOne()
+	mdl := rc.Model()
+	return Message{
+		From:    rc.Get(mdl.FieldName("EmailFrom")).(string),
+		To:      splitAddresses(rc.Get(mdl.FieldName("EmailTo")).(string)),
+		Cc:      splitAddresses(rc.Get(mdl.FieldName("EmailCc")).(string)),
+		Subject: rc.Get(mdl.FieldName("Subject")).(string),
+		Body:    rc.Get(mdl.FieldName("Body")).(string),
+		HTML:    rc.Get(mdl.FieldName("IsHTML")).(bool),
+	}
+}
+
+// Send the outgoing mails of this RecordSet through the configured MailServer
+// once the current transaction has been committed.
+//
+// Mails are set in the sending state in the current transaction, so that they
+// are not queued twice, and nothing is sent if the transaction is rolled back.
+// After commit, each mail is locked and sent in a new transaction which records
+// the result before committing: sent mails are set in the sent state and failed
+// mails are set back in the outgoing state to be retried later, until MaxRetries
+// is reached, at which point they are set in the exception state.
+//
+// Mails left in the sending state, e.g. because the server has been stopped
+// before sending them, are requeued by the outbox worker after StaleSendingDelay.
+func mailSend(rc *models.RecordCollection) {
+	mdl := rc.Model()
+	server := Server()
+	for _, rec := range rc.Records() {
+		if rec.Get(mdl.FieldName("State")).(string) != StateOutgoing {
+			continue
+		}
+		id := rec.Ids()[0]
+		rec.Call("Write", models.NewModelData(mdl).
+			Set(mdl.FieldName("State"), StateSending).
+			Set(mdl.FieldName("SendingDate"), dates.Now()).
+			Set(mdl.FieldName("FailureReason"), ""))
+		rc.Env().AfterCommit(func() {
+			sendMessage(server, id)
+		})
+	}
+}
+
+// sendMessage sends the mail with the given id through server if it is still
+// in the sending state and records the result on the mail. The mail is locked
+// during sending, so that it is not requeued by recoverStaleMails meanwhile.
+func sendMessage(server MailServer, id int64) {
+	txErr := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		mailRC := env.Pool("MailMail")
+		mdl := mailRC.Model()
+		mail := mailRC.Search(mdl.Field(models.ID).Equals(id).
+			And().Field(mdl.FieldName("State")).Equals(StateSending)).ForUpdateSkipLocked()
+		if mail.IsEmpty() {
+			return
+		}
+		msg := mail.Call("Message").(Message)
+		if err := server.Send(msg.From, msg.Recipients(), msg.Bytes()); err != nil {
+			log.Warn("Error while sending email", "id", id, "to", strings.Join(msg.Recipients(), ","), "error", err)
+			failMail(mail, err)
+			return
+		}
+		mail.Call("Write", models.NewModelData(mdl).
+			Set(mdl.FieldName("State"), StateSent).
+			Set(mdl.FieldName("SentDate"), dates.Now()))
+	})
+	if txErr != nil {
+		log.Warn("Error while recording email sending", "id", id, "error", txErr)
+	}
+}
+
+// failMail records the given error on the given mail and sets it back in the
+// outgoing state, or in the exception state if it has been tried MaxRetries times.
+func failMail(mail *models.RecordCollection, sendErr error) {
+	mdl := mail.Model()
+	retries := mail.Get(mdl.FieldName("Retries")).(int64) + 1
+	state := StateOutgoing
+	if retries >= MaxRetries {
+		state = StateException
+	}
+	mail.Call("Write", models.NewModelData(mdl).
+		Set(mdl.FieldName("State"), state).
+		Set(mdl.FieldName("SentDate"), dates.DateTime{}).
+		Set(mdl.FieldName("Retries"), retries).
+		Set(mdl.FieldName("FailureReason"), sendErr.Error()).
+		Set(mdl.FieldName("ScheduledDate"), dates.Now().Add(time.Duration(retries)*RetryDelay)))
+}
+
+// recoverStaleMails requeues as failed attempts the mails that have been set
+// in the sending state more than StaleSendingDelay ago and are not being sent
+// anymore, typically because the server sending them has been stopped abruptly.
+//
+// Mails being sent are locked by sendMessage and skipped.
+func recoverStaleMails(env models.Environment) {
+	mailRC := env.Pool("MailMail")
+	mdl := mailRC.Model()
+	stale := mailRC.Search(mdl.Field(mdl.FieldName("State")).Equals(StateSending).
+		And().Field(mdl.FieldName("SendingDate")).LowerOrEqual(dates.Now().Add(-StaleSendingDelay))).ForUpdateSkipLocked()
+	for _, mail := range stale.Records() {
+		log.Warn("Requeuing stale email", "id", mail.Ids()[0])
+		failMail(mail, errors.New("sending has been interrupted"))
+	}
+}
+
+// Retry sets back the failed or cancelled mails of this RecordSet in the outgoing queue
+func mailRetry(rc *models.RecordCollection) bool {
+	mdl := rc.Model()
+	return rc.Call("Write", models.NewModelData(mdl).
+		Set(mdl.FieldName("State"), StateOutgoing).
+		Set(mdl.FieldName("Retries"), int64(0)).
+		Set(mdl.FieldName("ScheduledDate"), dates.DateTime{})).(bool)
+}
+
+// Cancel the outgoing mails of this RecordSet
+func mailCancel(rc *models.RecordCollection) bool {
+	mdl := rc.Model()
+	outgoing := rc.Search(mdl.Field(mdl.FieldName("State")).Equals(StateOutgoing))
+	if outgoing.IsEmpty() {
+		return true
+	}
+	return outgoing.Call("Write", models.NewModelData(mdl).Set(mdl.FieldName("State"), StateCancel)).(bool)
+}
+
+// ProcessQueue sends at most QueueBatchSize mails that are due
+func mailProcessQueue(rc *models.RecordCollection) {
+	mdl := rc.Model()
+	scheduledDate := mdl.FieldName("ScheduledDate")
+	cond := mdl.Field(mdl.FieldName("State")).Equals(StateOutgoing).
+		AndCond(mdl.Field(scheduledDate).IsNull().Or().Field(scheduledDate).LowerOrEqual(dates.Now()))
+	rc.Search(cond).Limit(QueueBatchSize).Call("Send")
+}
+
+// processQueue is the worker function that requeues stale mails
+// and sends due mails of the outbox
+func processQueue() {
+	if _, ok := models.Registry.Get("MailMail"); !ok {
+		return
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		recoverStaleMails(env)
+		env.Pool("MailMail").Call("ProcessQueue")
+	})
+	if err != nil {
+		log.Warn("Error while processing mail queue", "error", err)
+	}
+}
+
+// Queue adds the given message to the outbox in the given environment
+// and returns the created MailMail record.
+//
+// The message will be sent by the outbox worker after the transaction has been
+// committed. If record is not nil, the created mail is linked to this record.
+// If msg.From is empty, DefaultFrom() is used.
+func Queue(env models.Environment, msg Message, record models.RecordSet) *models.RecordCollection {
+	mailRC := env.Pool("MailMail")
+	mdl := mailRC.Model()
+	from := msg.From
+	if from == "" {
+		from = DefaultFrom()
+	}
+	data := models.NewModelData(mdl).
+		Set(mdl.FieldName("EmailFrom"), from).
+		Set(mdl.FieldName("EmailTo"), strings.Join(msg.To, ", ")).
+		Set(mdl.FieldName("EmailCc"), strings.Join(msg.Cc, ", ")).
+		Set(mdl.FieldName("Subject"), msg.Subject).
+		Set(mdl.FieldName("Body"), msg.Body).
+		Set(mdl.FieldName("IsHTML"), msg.HTML)
+	if record != nil && record.IsNotEmpty() {
+		record.EnsureOne()
+		data.Set(mdl.FieldName("ResModel"), record.ModelName()).
+			Set(mdl.FieldName("ResID"), record.Ids()[0])
+	}
+	return mailRC.Call("Create", data).(models.RecordSet).Collection()
+}
+
+// QueueFromTemplates renders the given subject and body templates for each record
+// of rs and queues a mail for each of them to the given recipients.
+//
+// Templates are rendered with RenderTemplate. It returns the created MailMail records.
+func QueueFromTemplates(rs models.RecordSet, subject, body string, isHTML bool, to ...string) *models.RecordCollection {
+	res := rs.Env().Pool("MailMail")
+	for _, rec := range rs.Collection().Records() {
+		subj, err := RenderTemplate(subject, rec, false)
+		if err != nil {
+			log.Panic("Error while rendering mail subject", "record", rec, "error", err)
+		}
+		bdy, err := RenderTemplate(body, rec, isHTML)
+		if err != nil {
+			log.Panic("Error while rendering mail body", "record", rec, "error", err)
+		}
+		res = res.Union(Queue(rs.Env(), Message{
+			To:      to,
+			Subject: subj,
+			Body:    bdy,
+			HTML:    isHTML,
+		}, rec))
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// A MailServer sends fully formed email messages to their recipients.
+type MailServer interface {
+	// Send the given msg from the given address to the given recipients.
+	Send(from string, to []string, msg []byte) error
+}
+
+// An SMTPServer is a MailServer that relays messages through an SMTP server.
+type SMTPServer struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+}
+
+// Send the given msg from the given address to the given recipients
+// through this SMTP server.
+func (s *SMTPServer) Send(from string, to []string, msg []byte) error {
+	var auth smtp.Auth
+	if s.User != "" {
+		auth = smtp.PlainAuth("", s.User, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, from, to, msg)
+}
+
+// SMTPServerFromConfig returns a new SMTPServer configured from
+// the 'Mail.*' configuration keys.
+func SMTPServerFromConfig() *SMTPServer {
+	port := viper.GetString("Mail.Port")
+	if port == "" {
+		port = "25"
+	}
+	host := viper.GetString("Mail.Host")
+	if host == "" {
+		host = "localhost"
+	}
+	return &SMTPServer{
+		Host:     host,
+		Port:     port,
+		User:     viper.GetString("Mail.User"),
+		Password: viper.GetString("Mail.Password"),
+	}
+}
+
+var (
+	serverMutex sync.RWMutex
+	mailServer  MailServer
+)
+
+// SetServer sets the MailServer that will be used to send emails.
+//
+// If no server is set, an SMTPServer configured with SMTPServerFromConfig is used.
+func SetServer(ms MailServer) {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	mailServer = ms
+}
+
+// Server returns the MailServer that is used to send emails.
+func Server() MailServer {
+	serverMutex.RLock()
+	ms := mailServer
+	serverMutex.RUnlock()
+	if ms == nil {
+		return SMTPServerFromConfig()
+	}
+	return ms
+}
+
+// DefaultFrom returns the sender address to use when none is given.
+func DefaultFrom() string {
+	return viper.GetString("Mail.From")
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+)
+
+func declareTemplateModel() {
+	templateModel := models.NewModel("MailTemplate")
+	templateModel.AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{Required: true},
+		"Model": fields.Char{String: "Applies To", Required: true, Index: true,
+			Help: "The name of the model this template applies to"},
+		"EmailFrom": fields.Char{String: "From",
+			Help: "Sender address template. If empty, the default sender address is used"},
+		"EmailTo": fields.Char{String: "To",
+			Help: "Comma separated list of recipients addresses. This field is rendered as a template"},
+		"EmailCc": fields.Char{String: "Cc",
+			Help: "Comma separated list of carbon copy addresses. This field is rendered as a template"},
+		"Subject": fields.Char{Help: "Subject template"},
+		"Body":    fields.Text{Help: "Body template"},
+		"IsHTML":  fields.Boolean{String: "HTML Body"},
+	})
+
+	templateModel.NewMethod("Render", mailTemplateRender)
+	templateModel.NewMethod("SendMail", mailTemplateSendMail)
+}
+
+// renderField renders the template held in the given field of this mail template for the given record.
+func renderField(rc *models.RecordCollection, field string, record models.RecordSet, isHTML bool) string {
+	tmpl := rc.Get(rc.Model().FieldName(field)).(string)
+	res, err := RenderTemplate(tmpl, record, isHTML)
+	if err != nil {
+		log.Panic("Error while rendering mail template", "template", rc, "field", field, "record", record, "error", err)
+	}
+	return res
+}
+
+// Render this mail template for each record of the given RecordSet.
+//
+// It returns a map of the rendered messages with the record id as key.
+// It panics if rs is not of the model this template applies to.
+func mailTemplateRender(rc *models.RecordCollection, rs models.RecordSet) map[int64]Message {
+	rc.EnsureOne()
+	if modelName := rc.Get(rc.Model().FieldName("Model")).(string); modelName != rs.ModelName() {
+		log.Panic("Mail template cannot be rendered on this model", "template", rc, "templateModel", modelName, "model", rs.ModelName())
+	}
+	isHTML := rc.Get(rc.Model().FieldName("IsHTML")).(bool)
+	res := make(map[int64]Message)
+	for _, rec := range rs.Collection().Records() {
+		res[rec.Ids()[0]] = Message{
+			From:    renderField(rc, "EmailFrom", rec, false),
+			To:      splitAddresses(renderField(rc, "EmailTo", rec, false)),
+			Cc:      splitAddresses(renderField(rc, "EmailCc", rec, false)),
+			Subject: renderField(rc, "Subject", rec, false),
+			Body:    renderField(rc, "Body", rec, isHTML),
+			HTML:    isHTML,
+		}
+	}
+	return res
+}
+
+// SendMail renders this template for each record of the given RecordSet and
+// queues the resulting mails in the outbox. It returns the queued MailMail records.
+func mailTemplateSendMail(rc *models.RecordCollection, rs models.RecordSet) *models.RecordCollection {
+	messages := rc.Call("Render", rs).(map[int64]Message)
+	res := rc.Env().Pool("MailMail")
+	for _, rec := range rs.Collection().Records() {
+		res = res.Union(Queue(rc.Env(), messages[rec.Ids()[0]], rec))
+	}
+	return res
+}
@@ -6,6 +6,7 @@ package menus
 import (
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/logging"
 )
 
@@ -13,8 +14,18 @@ var log logging.Logger
 
 // BootStrap the menus by linking parents and children
 // and populates the Registry
+//
+// It panics if a menu references an unknown parent menu or group.
 func BootStrap() {
 	for _, menu := range bootstrapMap {
+		// Set groups
+		for _, groupID := range menu.GroupIDs {
+			group := security.Registry.GetGroup(groupID)
+			if group == nil {
+				log.Panic("Unknown group in menu", "menu", menu.XMLID, "group", groupID)
+			}
+			menu.Groups = append(menu.Groups, group)
+		}
 		// Add parent
 		if menu.ParentID != "" {
 			parentMenu := bootstrapMap[menu.ParentID]
@@ -17,10 +17,13 @@ package menus
 import (
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
 )
 
 // Registry is the menu Collection of the application
@@ -125,9 +128,27 @@ type Menu struct {
 	HasChildren      bool
 	HasAction        bool
 	WebIcon          string
+	GroupIDs         []string
+	Groups           []*security.Group
 	names            map[string]string
 }
 
+// VisibleBy returns true if this menu can be seen by the user with the given uid,
+// that is if this menu has no groups or if the user belongs to one of them.
+//
+// Only the groups of this menu are checked, not those of its parents.
+func (m Menu) VisibleBy(uid int64) bool {
+	if len(m.Groups) == 0 || security.Registry.HasMembership(uid, security.GroupAdmin) {
+		return true
+	}
+	for _, group := range m.Groups {
+		if security.Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
+}
+
 // TranslatedName returns the translated name of this menu
 // in the given language
 func (m Menu) TranslatedName(lang string) string {
@@ -148,6 +169,12 @@ func LoadFromEtree(element *etree.Element) {
 // and adds it to the given map.
 func AddMenuToMapFromEtree(element *etree.Element, mMap map[string]*Menu) map[string]*Menu {
 	seq, _ := strconv.Atoi(element.SelectAttrValue("sequence", "10"))
+	var groupIDs []string
+	for _, groupID := range strings.Split(element.SelectAttrValue("groups", ""), ",") {
+		if groupID = strings.TrimSpace(groupID); groupID != "" {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
 	nextID := len(mMap) + 1
 	menu := Menu{
 		ID:       int64(nextID),
@@ -157,7 +184,59 @@ func AddMenuToMapFromEtree(element *etree.Element, mMap map[string]*Menu) map[st
 		ParentID: element.SelectAttrValue("parent", ""),
 		WebIcon:  element.SelectAttrValue("web_icon", ""),
 		Sequence: uint8(seq),
+		GroupIDs: groupIDs,
 	}
 	mMap[menu.XMLID] = &menu
 	return mMap
 }
+
+// A MenuItem is the JSON serializable representation
+// of a menu sent to the client.
+type MenuItem struct {
+	ID       int64                `json:"id"`
+	XMLID    string               `json:"xmlid"`
+	Name     string               `json:"name"`
+	Sequence uint8                `json:"sequence"`
+	Action   actions.ActionString `json:"action"`
+	WebIcon  string               `json:"web_icon"`
+	Children []*MenuItem          `json:"children"`
+}
+
+// LoadMenus returns the tree of the menus that are visible by the user of the given
+// environment, with names translated in the language of the environment's context.
+//
+// Menus the user cannot see are removed with all their children. Menus without
+// action are also removed if none of their children is visible.
+func LoadMenus(env models.Environment) []*MenuItem {
+	return Registry.menuItems(env.Uid(), env.Context().GetString("lang"))
+}
+
+// menuItems returns the MenuItem tree of the menus of this collection
+// that are visible by the user with the given uid in the given language.
+func (mc *Collection) menuItems(uid int64, lang string) []*MenuItem {
+	res := make([]*MenuItem, 0)
+	for _, menu := range mc.Menus {
+		if !menu.VisibleBy(uid) {
+			continue
+		}
+		item := MenuItem{
+			ID:       menu.ID,
+			XMLID:    menu.XMLID,
+			Name:     menu.TranslatedName(lang),
+			Sequence: menu.Sequence,
+			WebIcon:  menu.WebIcon,
+			Children: make([]*MenuItem, 0),
+		}
+		if menu.Action != nil {
+			item.Action = menu.Action.ActionString()
+		}
+		if menu.Children != nil {
+			item.Children = menu.Children.menuItems(uid, lang)
+		}
+		if menu.Action == nil && len(item.Children) == 0 {
+			continue
+		}
+		res = append(res, &item)
+	}
+	return res
+}
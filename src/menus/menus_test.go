@@ -0,0 +1,66 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package menus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/actions"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var menusDef = []string{
+	`<menuitem id="sales" name="Sales" sequence="2"/>`,
+	`<menuitem id="sales_config" name="Configuration" parent="sales" groups="sales_manager"/>`,
+	`<menuitem id="sales_config_empty" name="Empty" parent="sales_config"/>`,
+	`<menuitem id="settings" name="Settings" sequence="1" groups="sales_manager, base_admin"/>`,
+	`<menuitem id="settings_empty" name="Empty" parent="settings"/>`,
+}
+
+func TestMenus(t *testing.T) {
+	managerGroup := security.Registry.NewGroup("sales_manager", "Sales Manager")
+	security.Registry.NewGroup("base_admin", "Base Admin")
+	security.Registry.AddMembership(2, managerGroup)
+	for _, menuDef := range menusDef {
+		elt, err := xmlutils.XMLToElement(menuDef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		LoadFromEtree(elt)
+	}
+	BootStrap()
+	Convey("Testing menus visibility", t, func() {
+		Convey("Groups should be set on menus", func() {
+			So(Registry.GetByXMLID("sales").Groups, ShouldBeEmpty)
+			So(Registry.GetByXMLID("sales_config").Groups, ShouldHaveLength, 1)
+			So(Registry.GetByXMLID("settings").GroupIDs, ShouldResemble, []string{"sales_manager", "base_admin"})
+			So(Registry.GetByXMLID("settings").Groups, ShouldHaveLength, 2)
+		})
+		Convey("Menus should be visible only by the members of their groups", func() {
+			So(Registry.GetByXMLID("sales").VisibleBy(3), ShouldBeTrue)
+			So(Registry.GetByXMLID("sales_config").VisibleBy(2), ShouldBeTrue)
+			So(Registry.GetByXMLID("sales_config").VisibleBy(3), ShouldBeFalse)
+			So(Registry.GetByXMLID("sales_config").VisibleBy(security.SuperUserID), ShouldBeTrue)
+		})
+		Convey("Menus without action nor visible children should be pruned", func() {
+			So(Registry.menuItems(2, ""), ShouldBeEmpty)
+			So(Registry.menuItems(3, ""), ShouldBeEmpty)
+		})
+		Convey("Menu items should be JSON serializable", func() {
+			Registry.GetByXMLID("sales_config_empty").Action = &actions.Action{}
+			items := Registry.menuItems(2, "")
+			So(items, ShouldHaveLength, 1)
+			So(items[0].XMLID, ShouldEqual, "sales")
+			So(items[0].Children, ShouldHaveLength, 1)
+			So(items[0].Children[0].Children, ShouldHaveLength, 1)
+			So(Registry.menuItems(3, ""), ShouldBeEmpty)
+			data, err := json.Marshal(items[0].Children[0].Children[0])
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"id":3,"xmlid":"sales_config_empty","name":"Empty","sequence":10,"action":false,"web_icon":"","children":[]}`)
+		})
+	})
+}
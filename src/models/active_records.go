@@ -0,0 +1,81 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// SetActiveField sets the boolean field of this model which tells whether
+// a record is active. Records for which this field is false are archived:
+//
+// - They are not found by the Search, SearchAll and SearchCached methods, and
+// therefore by name searches, unless the condition of the search is on the
+// active field itself, e.g. to list archived records.
+//
+// - They are not returned when reading the one2many and many2many fields
+// pointing to this model, unless these fields have IncludeArchived set.
+//
+// Archived records are included everywhere when the context has the active_test
+// key set to false, whatever the IncludeArchived setting of the fields. Browse
+// and BrowseOne always find archived records.
+func (m *Model) SetActiveField(field FieldName) {
+	m.activeField = field
+}
+
+// checkActiveFields panics if the active field of a model
+// does not exist or is not a stored boolean field.
+//
+// It is called at bootstrap, after all fields have been declared.
+func checkActiveFields() {
+	for _, model := range Registry.registryByName {
+		if model.activeField == nil {
+			continue
+		}
+		fi, ok := model.fields.Get(model.activeField.JSON())
+		if !ok {
+			log.Panic("Unknown active field", "model", model.name, "field", model.activeField.Name())
+		}
+		if fi.fieldType != fieldtype.Boolean || !fi.isStored() {
+			log.Panic("Active field must be a stored boolean field", "model", model.name, "field", fi.name)
+		}
+	}
+}
+
+// activeTest returns true if the archived records of the model of this
+// RecordCollection must be left out of its searches and relation reads.
+func (rc *RecordCollection) activeTest() bool {
+	if rc.model.activeField == nil {
+		return false
+	}
+	return !rc.env.context.HasKey("active_test") || rc.env.context.GetBool("active_test")
+}
+
+// withActiveTest returns a new RecordCollection restricted to the active records
+// of its model, unless the archived records must be included or its condition
+// is already on the active field.
+func (rc *RecordCollection) withActiveTest() *RecordCollection {
+	if !rc.activeTest() {
+		return rc
+	}
+	activeFi := rc.model.fields.MustGet(rc.model.activeField.JSON())
+	if rc.query.cond.HasField(activeFi) {
+		return rc
+	}
+	return rc.Search(rc.model.Field(rc.model.activeField).Equals(true))
+}
+
+// withoutArchivedRecords returns the records of relRC, the value of the given
+// one2many or many2many field of this RecordCollection, without the archived
+// ones unless the field has IncludeArchived set or the context has active_test
+// set to false.
+func (rc *RecordCollection) withoutArchivedRecords(fi *Field, relRC *RecordCollection) *RecordCollection {
+	if fi.includeArchived || relRC.IsEmpty() || !relRC.activeTest() {
+		return relRC
+	}
+	activeField := relRC.model.activeField
+	return relRC.Filtered(func(rs RecordSet) bool {
+		return rs.Collection().Get(activeField).(bool)
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// An AggregateFunction is an SQL aggregate function that can be compared in
+// conditions built with ConditionStart.AggregateCondition.
+type AggregateFunction string
+
+// Available aggregate functions
+const (
+	AggregateCount AggregateFunction = "count"
+	AggregateSum   AggregateFunction = "sum"
+	AggregateAvg   AggregateFunction = "avg"
+	AggregateMin   AggregateFunction = "min"
+	AggregateMax   AggregateFunction = "max"
+)
+
+// isValid returns true if this AggregateFunction is one of the available functions.
+func (af AggregateFunction) isValid() bool {
+	switch af {
+	case AggregateCount, AggregateSum, AggregateAvg, AggregateMin, AggregateMax:
+		return true
+	}
+	return false
+}
+
+// An aggregateFilter is the aggregate of a field of the related records
+// matching cond that is compared in an aggregate condition.
+type aggregateFilter struct {
+	function AggregateFunction
+	field    FieldName
+	cond     *Condition
+}
+
+// AggregateCondition returns a ConditionField on the aggregate with function of
+// the aggregated field of the related records of the given one2many or many2many
+// field that match the given condition. It is compared with an operator method,
+// e.g. the partners whose invoices of this year total more than 10000:
+//
+//	cs.AggregateCondition(Invoices, models.AggregateSum, AmountTotal, thisYearCond).Greater(10000)
+//
+// The condition is expressed on the related model and may be nil to aggregate
+// all the related records. Record rules of the related model apply to the
+// aggregated records. Records without related records matching the condition
+// have no aggregate and never match, even when comparing a count to 0.
+//
+// Only the Equals, NotEquals, Greater, GreaterOrEqual, Lower and LowerOrEqual
+// operators can be used on the returned ConditionField.
+func (cs ConditionStart) AggregateCondition(field FieldName, function AggregateFunction, aggregated FieldName, condition *Condition) *ConditionField {
+	if !function.isValid() {
+		log.Panic("Unknown aggregate function", "function", function, "field", field)
+	}
+	if condition == nil {
+		condition = newCondition()
+	}
+	cf := cs.Field(field)
+	cf.aggregate = &aggregateFilter{
+		function: function,
+		field:    aggregated,
+		cond:     condition,
+	}
+	return cf
+}
+
+// addAggregateOperator adds an aggregate predicate comparing the aggregate of
+// this ConditionField to data with the given operator.
+func (c ConditionField) addAggregateOperator(op operator.Operator, data interface{}) *Condition {
+	switch op {
+	case operator.Equals, operator.NotEquals, operator.Greater, operator.GreaterOrEqual, operator.Lower, operator.LowerOrEqual:
+	default:
+		log.Panic("Aggregate conditions can only be compared with =, !=, >, >=, < and <= operators",
+			"field", c.Name(), "operator", op)
+	}
+	cond := c.cs.cond
+	cond.predicates = append(cond.predicates, predicate{
+		exprs:      c.exprs,
+		operator:   op,
+		arg:        sanitizeArgs(data, false),
+		quantifier: aggregateQuantifier,
+		subCond:    c.aggregate.cond,
+		aggregate:  c.aggregate,
+		isNot:      c.cs.nextIsNot,
+		isOr:       c.cs.nextIsOr,
+	})
+	return &cond
+}
+
+// aggregateSQLClause returns the sql WHERE clause and arguments for the given
+// predicate on an aggregate of the related records of a one2many or many2many field.
+//
+// It is translated as "id IN (holders of related records matching the condition,
+// grouped by holder HAVING aggregate op value)". Record rules of the related model
+// apply to the aggregated records.
+func (q *Query) aggregateSQLClause(p predicate) (string, SQLParams) {
+	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+	fromSQL, holderColumn, aggSQL, args := q.relationAggregateSQL(fi, p.aggregate.function, p.aggregate.field, p.subCond)
+	adapter := adapters[db.DriverName()]
+	opSQL, arg := adapter.operatorSQL(p.operator, q.evaluateConditionArgFunctions(p))
+	subQuery := fmt.Sprintf("SELECT %s FROM %s GROUP BY %s HAVING %s %s", holderColumn, fromSQL, holderColumn, aggSQL, opSQL)
+	field, _, _ := q.joinedFieldExpression(append(p.exprs[:len(p.exprs)-1:len(p.exprs)-1], ID), false, 0)
+	return fmt.Sprintf("%s IN (%s)", field, subQuery), append(args, arg)
+}
+
+// relationAggregateSQL returns the SQL FROM and WHERE clauses selecting the
+// related records of the given one2many or many2many field that match cond
+// and that the current user can read, with their holder column, the SQL
+// expression of their aggregate with function on the aggregated field, and
+// the arguments of the query.
+func (q *Query) relationAggregateSQL(fi *Field, function AggregateFunction, aggregated FieldName, cond *Condition) (string, string, string, SQLParams) {
+	if !fi.fieldType.Is2ManyRelationType() {
+		log.Panic("Aggregates can only be computed on one2many or many2many fields",
+			"model", fi.model.name, "field", fi.name)
+	}
+	relModel := fi.relatedModel
+	aggFi := relModel.fields.MustGet(aggregated.JSON())
+	if !aggFi.isStored() {
+		log.Panic("Aggregated fields must be stored", "model", relModel.name, "field", aggFi.name)
+	}
+	switch function {
+	case AggregateSum, AggregateAvg:
+		if aggFi.fieldType != fieldtype.Integer && aggFi.fieldType != fieldtype.Float {
+			log.Panic("Only integer and float fields can be summed or averaged", "model", relModel.name,
+				"field", aggFi.name, "function", function)
+		}
+	}
+	related := q.recordSet.env.Pool(relModel.name).Search(cond.deepCopy()).addRecordRuleConditions(q.recordSet.env.uid, security.Read)
+	addNameSearchesToCondition(related.model, related.query.cond)
+	related.query.ctxCond = related.conditionContextsCondition(false)
+	related = related.substituteRelatedInQuery()
+	idsQuery, args := related.query.selectColumnQuery(ID)
+
+	adapter := adapters[db.DriverName()]
+	relTable := adapter.quoteTableName(relModel.tableName)
+	var holderColumn, fromSQL string
+	switch fi.fieldType {
+	case fieldtype.One2Many:
+		holderColumn = fmt.Sprintf("hexya_agg.%s", relModel.fields.MustGet(fi.reverseFK).json)
+		fromSQL = fmt.Sprintf("%s hexya_agg", relTable)
+	case fieldtype.Many2Many:
+		holderColumn = fmt.Sprintf("hexya_rel.%s", fi.m2mOurField.json)
+		fromSQL = fmt.Sprintf("%s hexya_rel JOIN %s hexya_agg ON hexya_agg.id = hexya_rel.%s",
+			adapter.quoteTableName(fi.m2mRelModel.tableName), relTable, fi.m2mTheirField.json)
+	}
+	fromSQL = fmt.Sprintf("%s WHERE %s IS NOT NULL AND hexya_agg.id IN (%s)", fromSQL, holderColumn, idsQuery)
+	aggSQL := fmt.Sprintf("%s(hexya_agg.%s)", function, aggFi.json)
+	return fromSQL, holderColumn, aggSQL, args
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 )
 
@@ -46,6 +47,8 @@ const (
 	ManualModel
 	// SystemModel is a model that is used internally by the Hexya Framework
 	SystemModel
+	// UUIDModel is a model whose table has a uuid primary key
+	UUIDModel
 )
 
 //  declareCommonMixin creates the common mixin that is needed for all models
@@ -54,28 +57,51 @@ func declareCommonMixin() {
 	commonMixin.addMethod("New", commonMixinNew)
 	commonMixin.addMethod("Create", commonMixinCreate)
 	commonMixin.addMethod("Read", commonMixinRead)
+	commonMixin.addMethod("ReadNested", commonMixinReadNested)
 	commonMixin.addMethod("Load", commonMixinLoad)
+	commonMixin.addMethod("DebugSQL", commonMixinDebugSQL)
 	commonMixin.addMethod("Write", commonMixinWrite)
+	commonMixin.addMethod("WriteOrCreate", commonMixinWriteOrCreate)
 	commonMixin.addMethod("Unlink", commonMixinUnlink)
+	commonMixin.addMethod("UnlinkBatched", commonMixinUnlinkBatched)
 	commonMixin.addMethod("CopyData", commonMixinCopyData)
 	commonMixin.addMethod("Copy", commonMixinCopy)
 	commonMixin.addMethod("NameGet", commonMixinNameGet)
+	commonMixin.addMethod("DisplayNames", commonMixinDisplayNames)
+	commonMixin.addMethod("Modifiers", commonMixinModifiers)
 	commonMixin.addMethod("SearchByName", commonMixinSearchByName)
+	commonMixin.addMethod("NameCreate", commonMixinNameCreate)
 	commonMixin.addMethod("FieldsGet", commonMixinFieldsGet)
 	commonMixin.addMethod("FieldGet", commonMixinFieldGet)
 	commonMixin.addMethod("DefaultGet", commonMixinDefaultGet)
 	commonMixin.addMethod("CheckRecursion", commonMixinCheckRecursion)
 	commonMixin.addMethod("Onchange", commonMixinOnChange)
+	commonMixin.addMethod("OnchangeLine", commonMixinOnChangeLine)
 	commonMixin.addMethod("Search", commonMixinSearch)
+	commonMixin.addMethod("SearchCached", commonMixinSearchCached)
+	commonMixin.addMethod("ValidateDomain", commonMixinValidateDomain)
 	commonMixin.addMethod("Browse", commonMixinBrowse)
 	commonMixin.addMethod("BrowseOne", commonMixinBrowseOne)
+	commonMixin.addMethod("BrowseUUIDs", commonMixinBrowseUUIDs)
 	commonMixin.addMethod("SearchCount", commonMixinSearchCount)
+	commonMixin.addMethod("SearchWithCount", commonMixinSearchWithCount)
 	commonMixin.addMethod("Fetch", commonMixinFetch)
 	commonMixin.addMethod("SearchAll", commonMixinSearchAll)
 	commonMixin.addMethod("GroupBy", commonMixinGroupBy)
+	commonMixin.addMethod("DistinctOn", commonMixinDistinctOn)
+	commonMixin.addMethod("LimitPerPartition", commonMixinLimitPerPartition)
 	commonMixin.addMethod("Limit", commonMixinLimit)
+	commonMixin.addMethod("ForUpdate", commonMixinForUpdate)
+	commonMixin.addMethod("ForUpdateSkipLocked", commonMixinForUpdateSkipLocked)
+	commonMixin.addMethod("ForUpdateNoWait", commonMixinForUpdateNoWait)
 	commonMixin.addMethod("Offset", commonMixinOffset)
 	commonMixin.addMethod("OrderBy", commonMixinOrderBy)
+	commonMixin.addMethod("OnlyFields", commonMixinOnlyFields)
+	commonMixin.addMethod("OrderBySimilarity", commonMixinOrderBySimilarity)
+	commonMixin.addMethod("OrderByIds", commonMixinOrderByIds)
+	commonMixin.addMethod("PlannerHints", commonMixinPlannerHints)
+	commonMixin.addMethod("AllowMemoryOrder", commonMixinAllowMemoryOrder)
+	commonMixin.addMethod("SearchPage", commonMixinSearchPage)
 	commonMixin.addMethod("Union", commonMixinUnion)
 	commonMixin.addMethod("Subtract", commonMixinSubtract)
 	commonMixin.addMethod("Intersect", commonMixinIntersect)
@@ -86,6 +112,8 @@ func declareCommonMixin() {
 	commonMixin.addMethod("SortedByField", commonMixinSortedByField)
 	commonMixin.addMethod("Filtered", commonMixinFiltered)
 	commonMixin.addMethod("GetRecord", commonMixinGetRecord)
+	commonMixin.addMethod("ResolveExternalIDs", commonMixinResolveExternalIDs)
+	commonMixin.addMethod("ExportGraph", commonMixinExportGraph)
 	commonMixin.addMethod("CheckExecutionPermission", commonMixinCheckExecutionPermission)
 	commonMixin.addMethod("SQLFromCondition", commonMixinSQLFromCondition)
 	commonMixin.addMethod("WithEnv", commonMixinWithEnv)
@@ -124,6 +152,12 @@ func commonMixinRead(rc *RecordCollection, fields FieldNames) []RecordData {
 	return res
 }
 
+// ReadNested reads the fields of the given spec and returns a FieldMap per record,
+// in which relation fields with sub fields are given as nested FieldMaps.
+func commonMixinReadNested(rc *RecordCollection, spec FieldsSpec) []FieldMap {
+	return rc.ReadNested(spec)
+}
+
 // Load looks up cache for fields of the RecordCollection and
 // query database for missing values.
 // fields are the fields to retrieve in the expression format,
@@ -134,6 +168,13 @@ func commonMixinLoad(rc *RecordCollection, fields ...FieldName) *RecordCollectio
 	return rc.Load(fields...)
 }
 
+// DebugSQL returns the SQL query and arguments that would be executed to
+// load the given fields of this RecordSet, including record rules, without
+// executing it.
+func commonMixinDebugSQL(rc *RecordCollection, fields ...FieldName) (string, []interface{}) {
+	return rc.DebugSQL(fields...)
+}
+
 // Write is the base implementation of the 'Write' method which updates
 // records in the database with the given data.
 // Data can be either a struct pointer or a FieldMap.`,
@@ -141,14 +182,38 @@ func commonMixinWrite(rc *RecordCollection, data RecordData) bool {
 	return rc.update(data)
 }
 
+// WriteOrCreate updates the record matching all the values of match with the given values.
+// If no record matches, a new record is created with both match and values data.
+// It returns the resulting record and true if it has been created.
+//
+// When the match fields are those of a unique constraint of the model, the record
+// is inserted with an INSERT ... ON CONFLICT query without calling Create, so that
+// a record created concurrently is updated with Write instead of raising a
+// constraint violation. It panics if several records match or if match holds
+// records to create.
+func commonMixinWriteOrCreate(rc *RecordCollection, match, values RecordData) (*RecordCollection, bool) {
+	return rc.writeOrCreate(match, values)
+}
+
 // Unlink deletes the given records in the database.
 func commonMixinUnlink(rc *RecordCollection) int64 {
 	return rc.unlink()
 }
 
+// UnlinkBatched deletes the given records in the database by chunks of chunkSize
+// records, each chunk in its own transaction. It returns the number of deleted records.
+func commonMixinUnlinkBatched(rc *RecordCollection, chunkSize int, progress func(deleted, total int64)) int64 {
+	return rc.UnlinkBatched(chunkSize, progress)
+}
+
 // CopyData copies given record's data with all its fields values.
 //
 // overrides contains field values to override in the original values of the copied record.
+//
+// CopyData is called by Copy to get the values of the new record, after NoCopy fields have
+// been removed and overrides have been applied. Models that need to adjust these values, e.g.
+// to get a new reference from a sequence, should extend this method and modify the result
+// of Super.
 func commonMixinCopyData(rc *RecordCollection, overrides RecordData) *ModelData {
 	rc.EnsureOne()
 	// Handle case when overrides is nil
@@ -196,12 +261,24 @@ func commonMixinCopy(rc *RecordCollection, overrides RecordData) *RecordCollecti
 	return newRs
 }
 
-// NameGet retrieves the human readable name of this record.`,
+// NameGet retrieves the human readable name of this record.
+//
+// The name is given in the language specified by the 'lang' key
+// of the context, so that translatable Name fields, selection labels
+// and related records names are translated.`,
 func commonMixinNameGet(rc *RecordCollection) string {
-	if _, nameExists := rc.model.fields.Get("Name"); nameExists {
+	if fi, nameExists := rc.model.fields.Get("Name"); nameExists {
 		switch name := rc.Get(rc.model.FieldName("Name")).(type) {
 		case string:
+			if fi.fieldType == fieldtype.Selection {
+				return rc.SelectionLabel(rc.model.FieldName("Name"))
+			}
 			return name
+		case RecordSet:
+			if name.IsEmpty() {
+				return ""
+			}
+			return name.Collection().Call("NameGet").(string)
 		case fmt.Stringer:
 			return name.String()
 		default:
@@ -211,6 +288,20 @@ func commonMixinNameGet(rc *RecordCollection) string {
 	return rc.String()
 }
 
+// DisplayNames returns the display name given by NameGet of each record of this
+// RecordSet, keyed by id. Display names are cached until records are modified,
+// so that rendering the same related record in many rows only computes its name once.
+func commonMixinDisplayNames(rc *RecordCollection) map[int64]string {
+	return rc.DisplayNames()
+}
+
+// Modifiers returns whether the given fields are currently readonly, required
+// or invisible for each record of this RecordSet, keyed by record id and field
+// JSON name. All the fields of the model are returned if no field is given.
+func commonMixinModifiers(rc *RecordCollection, fields ...FieldName) map[int64]map[string]FieldModifiers {
+	return rc.Modifiers(fields...)
+}
+
 // SearchByName searches for records that have a display name matching the given
 // "name" pattern when compared with the given "op" operator, while also
 // matching the optional search condition ("additionalCond").
@@ -218,21 +309,60 @@ func commonMixinNameGet(rc *RecordCollection) string {
 // This is used for example to provide suggestions based on a partial
 // value for a relational field. Sometimes be seen as the inverse
 // function of NameGet but it is not guaranteed to be.
+//
+// The fields searched are given by SetNameSearchFields on the model, or
+// the Name field if unset. A zero or negative limit returns all the
+// matching records.
 func commonMixinSearchByName(rc *RecordCollection, name string, op operator.Operator, additionalCond Conditioner, limit int) *RecordCollection {
 	if op == "" {
 		op = operator.IContains
 	}
-	cond := rc.Model().Field(rc.model.FieldName("Name")).AddOperator(op, name)
+	cond := rc.nameSearchCondition(name, op)
 	if !additionalCond.Underlying().IsEmpty() {
 		cond = cond.AndCond(additionalCond.Underlying())
 	}
-	return rc.Model().Search(rc.Env(), cond).Limit(limit)
+	rSet := rc.Model().Search(rc.Env(), cond)
+	if limit > 0 {
+		rSet = rSet.Limit(limit)
+	}
+	return rSet
+}
+
+// NameCreate creates a new record with the given name as Name field and the
+// default values for the other fields, including the defaults of the context.
+//
+// This is used to quick create records from relational fields. It panics with
+// a UserError if the model has no Name field or if a required field would not
+// be set, so that the full creation form can be used instead. Models whose
+// records need more than a name to be created should override this method.
+func commonMixinNameCreate(rc *RecordCollection, name string) *RecordCollection {
+	nameField, ok := rc.model.fields.Get("Name")
+	if !ok || nameField.fieldType.DefaultGoType().Kind() != reflect.String {
+		panic(exceptions.UserError{
+			Message: rc.T("Records of this model cannot be created from a name only"),
+			Debug:   fmt.Sprintf("model: %s has no string Name field", rc.model.name),
+		})
+	}
+	data := NewModelData(rc.model).Set(rc.model.FieldName("Name"), name)
+	values := data.Copy()
+	rc.applyDefaults(values, true)
+	if missing, missingNames := rc.emptyRequiredFields(values, true); len(missing) > 0 {
+		panic(exceptions.UserError{
+			Message: rc.T("Records of this model cannot be created from a name only, the following fields are required: %s", strings.Join(missing, ", ")),
+			Debug:   fmt.Sprintf("model: %s, fields: %s", rc.model.name, strings.Join(missingNames, ", ")),
+		})
+	}
+	return rc.Call("Create", data).(RecordSet).Collection()
 }
 
 // FieldsGet returns the definition of each field.
 // The embedded fields are included.
 // The string, help, and selection (if present) attributes are translated.
 //
+// The readonly, required and invisible attributes are evaluated in the current
+// environment. If this RecordSet has records, the modifiers of each
+// field are also evaluated for each of them.
+//
 // The result map is indexed by the fields JSON names.
 func commonMixinFieldsGet(rc *RecordCollection, args FieldsGetArgs) map[string]*FieldInfo {
 	// Get the field informations
@@ -245,6 +375,28 @@ func commonMixinFieldsGet(rc *RecordCollection, args FieldsGetArgs) map[string]*
 		res[fName].String = i18n.Registry.TranslateFieldDescription(lang, rc.model.name, fInfo.Name, fInfo.String)
 		res[fName].Selection = i18n.Registry.TranslateFieldSelection(lang, rc.model.name, fInfo.Name, fInfo.Selection)
 	}
+
+	// Evaluate modifiers
+	var mods map[int64]map[string]FieldModifiers
+	if rc.IsNotEmpty() && !rc.hasNegIds {
+		fields := make([]FieldName, 0, len(res))
+		for _, fInfo := range res {
+			fields = append(fields, rc.model.FieldName(fInfo.Name))
+		}
+		mods = rc.Modifiers(fields...)
+	}
+	for fName, fInfo := range res {
+		fInfo.ReadOnly, _ = modifierValue(*rc.env, fInfo.ReadOnly, fInfo.ReadOnlyFunc)
+		fInfo.Required, _ = modifierValue(*rc.env, fInfo.Required, fInfo.RequiredFunc)
+		fInfo.Invisible, _ = modifierValue(*rc.env, false, fInfo.InvisibleFunc)
+		if mods == nil {
+			continue
+		}
+		fInfo.Modifiers = make(map[int64]FieldModifiers)
+		for id, recMods := range mods {
+			fInfo.Modifiers[id] = recMods[fName]
+		}
+	}
 	return res
 }
 
@@ -305,126 +457,210 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 	filters := make(map[FieldName]Conditioner)
 
 	err := SimulateInNewEnvironment(rc.Env().Uid(), func(env Environment) {
-		values := params.Values.Underlying().FieldMap
-		data := NewModelDataFromRS(rc.WithEnv(env), values)
-		if rc.IsNotEmpty() {
-			data.Set(ID, rc.ids[0])
+		rs := rc.WithEnv(env).onchangeRecord(params.Values)
+		warnings = rs.applyOnchanges(params.Fields, params.Onchange, filters)
+		retValues = rs.onchangeModifiedValues(params.Values.Underlying().FieldMap)
+	})
+	if err != nil {
+		panic(err)
+	}
+	retValues.Unset(ID)
+	return OnchangeResult{
+		Value:   retValues,
+		Warning: strings.Join(warnings, "\n\n"),
+		Filters: filters,
+	}
+}
+
+// OnchangeLine returns the values that must be modified according to each field's Onchange
+// method in the pseudo-line given as params.Line of the one2many field params.Field of the
+// pseudo-record given as params.Values, as well as the values of this pseudo-record that
+// change consequently.
+func commonMixinOnChangeLine(rc *RecordCollection, params OnchangeLineParams) OnchangeLineResult {
+	fi := rc.model.getRelatedFieldInfo(params.Field)
+	if fi.fieldType != fieldtype.One2Many {
+		log.Panic("OnchangeLine can only be called on a one2many field", "model", rc.model.name, "field", params.Field)
+	}
+	var lineValues, parentValues *ModelData
+	var warnings []string
+	filters := make(map[FieldName]Conditioner)
+
+	err := SimulateInNewEnvironment(rc.Env().Uid(), func(env Environment) {
+		rs := rc.WithEnv(env).onchangeRecord(params.Values)
+		lineData := NewModelDataFromRS(env.Pool(fi.relatedModelName), params.Line.Underlying().FieldMap)
+		for f, dd := range params.Line.Underlying().ToCreate {
+			lineData.ToCreate[f] = dd
 		}
-		retValues = NewModelDataFromRS(rc.WithEnv(env))
-		var rs *RecordCollection
-		if id, _ := nbutils.CastToInteger(data.Get(ID)); id != 0 {
-			rs = rc.WithEnv(env).withIds([]int64{id})
-			rs = rs.WithContext("hexya_onchange_origin", rs.First().Wrap())
-			rs.WithContext("hexya_force_compute_write", true).update(data)
-		} else {
-			rs = rc.WithEnv(env).WithContext("hexya_force_compute_write", true).create(data)
+		lineData.Set(fi.relatedModel.FieldName(fi.reverseFK), rs)
+		line := env.Pool(fi.relatedModelName)
+		if params.LineID != 0 {
+			line = line.withIds([]int64{params.LineID})
 		}
-		// Set inverse fields
-		for field := range values {
-			fName := rs.model.FieldName(field)
-			fi := rs.model.getRelatedFieldInfo(fName)
-			if fi.inverse != "" {
-				fVal := data.Get(fName)
-				rs.Call(fi.inverse, fVal)
-			}
+		line = line.onchangeRecord(lineData)
+		warnings = line.applyOnchanges(params.Fields, params.Onchange, filters)
+		warnings = append(warnings, rs.applyOnchanges(FieldNames{params.Field}, params.ParentOnchange, filters)...)
+		lineValues = line.onchangeModifiedValues(params.Line.Underlying().FieldMap)
+		lineValues.Unset(fi.relatedModel.FieldName(fi.reverseFK))
+		parentFields := params.Values.Underlying().FieldMap.Copy()
+		parentFields.Delete(params.Field)
+		parentValues = rs.onchangeModifiedValues(parentFields)
+	})
+	if err != nil {
+		panic(err)
+	}
+	lineValues.Unset(ID)
+	parentValues.Unset(ID)
+	return OnchangeLineResult{
+		Value:       lineValues,
+		ParentValue: parentValues,
+		Warning:     strings.Join(warnings, "\n\n"),
+		Filters:     filters,
+	}
+}
+
+// onchangeRecord creates or updates the pseudo-record with the given values on which
+// onchange methods are applied. It must be called in a simulated environment.
+func (rc *RecordCollection) onchangeRecord(values RecordData) *RecordCollection {
+	fMap := values.Underlying().FieldMap
+	data := NewModelDataFromRS(rc, fMap)
+	for f, dd := range values.Underlying().ToCreate {
+		data.ToCreate[f] = dd
+	}
+	if rc.IsNotEmpty() {
+		data.Set(ID, rc.ids[0])
+	}
+	var rs *RecordCollection
+	if id, _ := nbutils.CastToInteger(data.Get(ID)); id != 0 {
+		rs = rc.withIds([]int64{id})
+		rs = rs.WithContext("hexya_onchange_origin", rs.First().Wrap())
+		rs.WithContext("hexya_force_compute_write", true).update(data)
+	} else {
+		rs = rc.WithContext("hexya_force_compute_write", true).create(data)
+	}
+	// Set inverse fields
+	for field := range fMap {
+		fName := rs.model.FieldName(field)
+		fi := rs.model.getRelatedFieldInfo(fName)
+		if fi.inverse != "" {
+			fVal := data.Get(fName)
+			rs.Call(fi.inverse, fVal)
 		}
-		todo := params.Fields
-		done := make(map[string]bool)
-		// Apply onchanges or compute
-		for len(todo) > 0 {
-			field := todo[0]
-			todo = todo[1:]
-			if done[field.JSON()] {
-				continue
-			}
-			done[field.JSON()] = true
-			if params.Onchange[field.Name()] == "" && params.Onchange[field.JSON()] == "" {
-				continue
-			}
-			fi := rs.model.getRelatedFieldInfo(field)
-			fnct := fi.onChange
-			if fnct == "" {
-				fnct = fi.compute
-			}
-			rrs := rs
-			toks := splitFieldNames(field, ExprSep)
-			if len(toks) > 1 {
-				rrs = rs.Get(joinFieldNames(toks[:len(toks)-1], ExprSep)).(RecordSet).Collection()
-			}
-			// Values
-			if fnct != "" {
-				vals := rrs.Call(fnct).(RecordData)
-				for _, f := range vals.Underlying().FieldNames() {
-					if !done[f.JSON()] {
-						todo = append(todo, f)
-					}
+	}
+	return rs
+}
+
+// applyOnchanges applies the onchange methods, or the compute methods, of the given fields
+// and of the fields they modify in turn, if they are set in the given onchange spec.
+// Filters are added to the given filters map and warnings are returned.
+func (rc *RecordCollection) applyOnchanges(fields FieldNames, onchange map[string]string, filters map[FieldName]Conditioner) []string {
+	var warnings []string
+	todo := fields
+	done := make(map[string]bool)
+	// Apply onchanges or compute
+	for len(todo) > 0 {
+		field := todo[0]
+		todo = todo[1:]
+		if done[field.JSON()] {
+			continue
+		}
+		done[field.JSON()] = true
+		if onchange[field.Name()] == "" && onchange[field.JSON()] == "" {
+			continue
+		}
+		fi := rc.model.getRelatedFieldInfo(field)
+		fnct := fi.onChange
+		if fnct == "" {
+			fnct = fi.compute
+		}
+		rrs := rc
+		toks := splitFieldNames(field, ExprSep)
+		if len(toks) > 1 {
+			rrs = rc.Get(joinFieldNames(toks[:len(toks)-1], ExprSep)).(RecordSet).Collection()
+		}
+		// Values
+		if fnct != "" {
+			vals := rrs.Call(fnct).(RecordData)
+			for _, f := range vals.Underlying().FieldNames() {
+				if !done[f.JSON()] {
+					todo = append(todo, f)
 				}
-				rrs.WithContext("hexya_force_compute_write", true).Call("Write", vals)
 			}
-			// Warning
-			if fi.onChangeWarning != "" {
-				w := rrs.Call(fi.onChangeWarning).(string)
-				if w != "" {
-					warnings = append(warnings, w)
-				}
+			rrs.WithContext("hexya_force_compute_write", true).Call("Write", vals)
+		}
+		// Warning
+		if fi.onChangeWarning != "" {
+			w := rrs.Call(fi.onChangeWarning).(string)
+			if w != "" {
+				warnings = append(warnings, w)
 			}
-			// Filters
-			if fi.onChangeFilters != "" {
-				ff := rrs.Call(fi.onChangeFilters).(map[FieldName]Conditioner)
-				for k, v := range ff {
-					filters[k] = v
-				}
+		}
+		// Filters
+		if fi.onChangeFilters != "" {
+			ff := rrs.Call(fi.onChangeFilters).(map[FieldName]Conditioner)
+			for k, v := range ff {
+				filters[k] = v
 			}
 		}
-		// Collect modified values
-		for field, val := range values {
-			fName := rs.model.FieldName(field)
-			if fName.JSON() == "__last_update" {
-				continue
+	}
+	return warnings
+}
+
+// onchangeModifiedValues returns the values of the pseudo-record of an onchange that
+// are different from the given values.
+func (rc *RecordCollection) onchangeModifiedValues(values FieldMap) *ModelData {
+	retValues := NewModelDataFromRS(rc)
+	for field, val := range values {
+		fName := rc.model.FieldName(field)
+		if fName.JSON() == "__last_update" {
+			continue
+		}
+		fi := rc.Collection().Model().getRelatedFieldInfo(fName)
+		newVal := rc.Get(fName)
+		switch {
+		case fi.fieldType.IsRelationType():
+			v := rc.convertToRecordSet(val, fi.relatedModelName)
+			nv := rc.convertToRecordSet(newVal, fi.relatedModelName)
+			if !v.Equals(nv) {
+				retValues.Set(fName, newVal)
 			}
-			fi := rs.Collection().Model().getRelatedFieldInfo(fName)
-			newVal := rs.Get(fName)
-			switch {
-			case fi.fieldType.IsRelationType():
-				v := rs.convertToRecordSet(val, fi.relatedModelName)
-				nv := rs.convertToRecordSet(newVal, fi.relatedModelName)
-				if !v.Equals(nv) {
-					retValues.Set(fName, newVal)
-				}
-			default:
-				if val != newVal {
-					retValues.Set(fName, newVal)
-				}
+		default:
+			if val != newVal {
+				retValues.Set(fName, newVal)
 			}
 		}
-	})
-	if err != nil {
-		panic(err)
-	}
-	retValues.Unset(ID)
-	return OnchangeResult{
-		Value:   retValues,
-		Warning: strings.Join(warnings, "\n\n"),
-		Filters: filters,
 	}
+	return retValues
 }
 
 // Search returns a new RecordSet filtering on the current one with the
 // additional given Condition.
 func commonMixinSearch(rc *RecordCollection, cond Conditioner) *RecordCollection {
-	return rc.Search(cond.Underlying())
+	return rc.Search(cond.Underlying()).withSearchScope()
+}
+
+// SearchCached returns a new RecordSet filtering on the current one with the
+// additional given Condition, with its ids fetched and cached in the transaction.
+func commonMixinSearchCached(rc *RecordCollection, cond Conditioner) *RecordCollection {
+	return rc.Search(cond.Underlying()).withSearchScope().SearchCached(newCondition())
+}
+
+// ValidateDomain returns an error if the given condition has fields that do not
+// exist, cannot be searched or cannot be read by the current user. It is meant
+// to check conditions sent by clients before searching with them.
+func commonMixinValidateDomain(rc *RecordCollection, cond Conditioner) error {
+	return rc.ValidateDomain(cond.Underlying())
 }
 
 // Browse returns a new RecordSet with only the records with the given ids.
 // Note that this function is just a shorcut for Search on a list of ids.
 func commonMixinBrowse(rc *RecordCollection, ids []int64) *RecordCollection {
-	return rc.Call("Search", rc.Model().Field(ID).In(ids)).(RecordSet).Collection()
+	return rc.withoutSearchScope().Call("Search", rc.Model().Field(ID).In(ids)).(RecordSet).Collection()
 }
 
 // BrowseOne returns a new RecordSet with only the record with the given id.
 // Note that this function is just a shorcut for Search on a given id.
 func commonMixinBrowseOne(rc *RecordCollection, id int64) *RecordCollection {
-	return rc.Call("Search", rc.Model().Field(ID).Equals(id)).(RecordSet).Collection()
+	return rc.withoutSearchScope().Call("Search", rc.Model().Field(ID).Equals(id)).(RecordSet).Collection()
 }
 
 // SearchCount fetch from the database the number of records that match the RecordSet conditions.
@@ -432,6 +668,15 @@ func commonMixinSearchCount(rc *RecordCollection) int {
 	return rc.SearchCount()
 }
 
+// SearchWithCount fetches the records of this RecordSet within its limit and offset,
+// and returns them with the total number of matching records, in a single query when
+// possible, such as:
+//
+// orders, total := rs.Search(q.SaleOrder().State().Equals("sale")).Limit(80).Offset(160).SearchWithCount()
+func commonMixinSearchWithCount(rc *RecordCollection) (*RecordCollection, int) {
+	return rc.SearchWithCount()
+}
+
 // Fetch query the database with the current filter and returns a RecordSet
 // with the queries ids.
 //
@@ -443,7 +688,7 @@ func commonMixinFetch(rc *RecordCollection) *RecordCollection {
 // SearchAll returns a RecordSet with all items of the table, regardless of the
 // current RecordSet query. It is mainly meant to be used on an empty RecordSet.
 func commonMixinSearchAll(rc *RecordCollection) *RecordCollection {
-	return rc.SearchAll()
+	return rc.SearchAll().withSearchScope()
 }
 
 // GroupBy returns a new RecordSet grouped with the given GROUP BY expressions.
@@ -451,11 +696,51 @@ func commonMixinGroupBy(rc *RecordCollection, exprs ...FieldName) *RecordCollect
 	return rc.GroupBy(exprs...)
 }
 
+// DistinctOn returns a new RecordSet with only the first record of each set of records
+// having the same values for the given fields. The first record of each set is determined
+// by the order of the RecordSet, e.g. to get the last post of each user:
+//
+// rs.OrderBy("LastUpdate desc").DistinctOn(h.Post().Fields().User())
+func commonMixinDistinctOn(rc *RecordCollection, fields ...FieldName) *RecordCollection {
+	return rc.DistinctOn(fields...)
+}
+
+// LimitPerPartition returns a new RecordSet with at most limit records of each set of
+// records having the same values for the given fields, e.g. the last three posts of each user:
+//
+// rs.OrderBy("LastUpdate desc").LimitPerPartition(3, h.Post().Fields().User())
+func commonMixinLimitPerPartition(rc *RecordCollection, limit int, fields ...FieldName) *RecordCollection {
+	return rc.LimitPerPartition(limit, fields...)
+}
+
 // Limit returns a new RecordSet with only the first 'limit' records.
+//
+// A zero limit returns an empty RecordSet without querying the database and
+// a negative limit removes the limit. Use SearchCount to get the number of
+// matching records.
 func commonMixinLimit(rc *RecordCollection, limit int) *RecordCollection {
 	return rc.Limit(limit)
 }
 
+// ForUpdate returns a new RecordSet whose records are locked until the end of
+// the transaction when they are fetched. Concurrent transactions trying to lock
+// or update the same records wait for this transaction to finish.
+func commonMixinForUpdate(rc *RecordCollection) *RecordCollection {
+	return rc.ForUpdate()
+}
+
+// ForUpdateSkipLocked returns a new RecordSet which locks its records like ForUpdate,
+// but which ignores the records already locked by another transaction.
+func commonMixinForUpdateSkipLocked(rc *RecordCollection) *RecordCollection {
+	return rc.ForUpdateSkipLocked()
+}
+
+// ForUpdateNoWait returns a new RecordSet which locks its records like ForUpdate,
+// but which fails if one of the records is already locked by another transaction.
+func commonMixinForUpdateNoWait(rc *RecordCollection) *RecordCollection {
+	return rc.ForUpdateNoWait()
+}
+
 // Offset returns a new RecordSet with only the records starting at offset
 func commonMixinOffset(rc *RecordCollection, offset int) *RecordCollection {
 	return rc.Offset(offset)
@@ -469,6 +754,53 @@ func commonMixinOrderBy(rc *RecordCollection, exprs ...string) *RecordCollection
 	return rc.OrderBy(exprs...)
 }
 
+// OnlyFields returns a new RecordSet which only loads the given fields from the
+// database when reading a field value which is not in cache, such as:
+//
+// rs.OnlyFields(h.Partner().Fields().Name(), h.Partner().Fields().Email())
+func commonMixinOnlyFields(rc *RecordCollection, fields ...FieldName) *RecordCollection {
+	return rc.OnlyFields(fields...)
+}
+
+// OrderBySimilarity returns a new RecordSet ordered by decreasing trigram similarity
+// of the given field with text, before the other orders of this RecordSet, such as:
+//
+// rs.Search(q.Partner().Name().Similar("jonh")).OrderBySimilarity(h.Partner().Fields().Name(), "jonh")
+func commonMixinOrderBySimilarity(rc *RecordCollection, field FieldName, text string) *RecordCollection {
+	return rc.OrderBySimilarity(field, text)
+}
+
+// OrderByIds returns a new RecordSet whose records are in the order of the given ids,
+// before the other orders of this RecordSet. Records that are not in ids come last.
+func commonMixinOrderByIds(rc *RecordCollection, ids []int64) *RecordCollection {
+	return rc.OrderByIds(ids)
+}
+
+// PlannerHints returns a new RecordSet whose queries give the given hints to the
+// query planner, as a last resort for queries with a bad plan, such as:
+//
+// rs.Search(q.SaleOrder().Partner().Equals(partner)).PlannerHints("IndexScan(sale_order sale_order_partner_id_index)")
+func commonMixinPlannerHints(rc *RecordCollection, hints ...string) *RecordCollection {
+	return rc.PlannerHints(hints...)
+}
+
+// AllowMemoryOrder returns a new RecordSet which can be ordered by non stored fields
+// with OrderBy, by sorting in memory all the records matching the search condition.
+// It panics if more than maxRows records match, such as:
+//
+// rs.Search(q.Partner().IsCompany().Equals(true)).OrderBy("DisplayName").Limit(10).AllowMemoryOrder(500)
+func commonMixinAllowMemoryOrder(rc *RecordCollection, maxRows int) *RecordCollection {
+	return rc.AllowMemoryOrder(maxRows)
+}
+
+// SearchPage returns at most limit records of this RecordSet after the given opaque
+// cursor, and the cursor of the next page, which is empty after the last page, such as:
+//
+// orders, next := rs.Search(q.SaleOrder().State().Equals("sale")).OrderBy("DateOrder desc").SearchPage(50, cursor)
+func commonMixinSearchPage(rc *RecordCollection, limit int, cursor string) (*RecordCollection, string) {
+	return rc.SearchPage(limit, cursor)
+}
+
 // Union returns a new RecordSet that is the union of this RecordSet and the given
 // "other" RecordSet. The result is guaranteed to be a set of unique records.
 func commonMixinUnion(rc *RecordCollection, other RecordSet) *RecordCollection {
@@ -533,6 +865,18 @@ func commonMixinGetRecord(rc *RecordCollection, externalID string) *RecordCollec
 	return rc.GetRecord(externalID)
 }
 
+// ResolveExternalIDs returns the records with the given externalIDs in a map keyed by
+// external ID, and the external IDs that do not exist. It does not panic on unknown IDs.
+func commonMixinResolveExternalIDs(rc *RecordCollection, externalIDs []string) (map[string]*RecordCollection, []string) {
+	return rc.ResolveExternalIDs(externalIDs)
+}
+
+// ExportGraph returns these records and the records reached by following the given
+// relation paths from them as a self-contained graph keyed by external IDs.
+func commonMixinExportGraph(rc *RecordCollection, paths ...FieldName) *ExportedGraph {
+	return rc.ExportGraph(paths...)
+}
+
 // CheckExecutionPermission panics if the current user is not allowed to execute the given method.
 //
 // If dontPanic is false, this function will panic, otherwise it returns true
@@ -651,13 +995,15 @@ func baseMixinComputeLastUpdate(rc *RecordCollection) *ModelData {
 		res.Set(rc.model.FieldName("LastUpdate"), rc.Get(rc.model.FieldName("CreateDate")).(dates.DateTime))
 		return res
 	}
-	res.Set(rc.model.FieldName("LastUpdate"), dates.Now())
+	res.Set(rc.model.FieldName("LastUpdate"), rc.env.Now())
 	return res
 }
 
-// ComputeDisplayName updates the DisplayName field with the result of NameGet
+// ComputeDisplayName updates the DisplayName field with the result of NameGet,
+// through DisplayNames so that the name is read from the display names cache.
 func baseMixinComputeDisplayName(rc *RecordCollection) *ModelData {
-	res := NewModelData(rc.model).Set(rc.model.FieldName("DisplayName"), rc.Call("NameGet"))
+	names := rc.Call("DisplayNames").(map[int64]string)
+	res := NewModelData(rc.model).Set(rc.model.FieldName("DisplayName"), names[rc.ids[0]])
 	return res
 }
 
@@ -713,6 +1059,8 @@ type FieldInfo struct {
 	Required         bool                                  `json:"required"`
 	Manual           bool                                  `json:"manual"`
 	ReadOnly         bool                                  `json:"readonly"`
+	Invisible        bool                                  `json:"invisible"`
+	Modifiers        map[int64]FieldModifiers              `json:"modifiers,omitempty"`
 	Depends          []string                              `json:"depends"`
 	CompanyDependent bool                                  `json:"company_dependent"`
 	Sortable         bool                                  `json:"sortable"`
@@ -754,3 +1102,27 @@ type OnchangeResult struct {
 	Warning string                    `json:"warning"`
 	Filters map[FieldName]Conditioner `json:"domain"`
 }
+
+// OnchangeLineParams is the args struct of the OnchangeLine function
+//
+// Values holds the values of the parent record, including its other lines. Line holds
+// the values of the edited line of the one2many Field, which is an existing line if
+// LineID is set. Fields and Onchange are the modified fields and onchange spec of the
+// line and ParentOnchange is the onchange spec of the parent record.
+type OnchangeLineParams struct {
+	Values         RecordData        `json:"values"`
+	Field          FieldName         `json:"line_field"`
+	LineID         int64             `json:"line_id"`
+	Line           RecordData        `json:"line_values"`
+	Fields         FieldNames        `json:"field_name"`
+	Onchange       map[string]string `json:"field_onchange"`
+	ParentOnchange map[string]string `json:"parent_onchange"`
+}
+
+// OnchangeLineResult is the result struct type of the OnchangeLine function
+type OnchangeLineResult struct {
+	Value       RecordData                `json:"value"`
+	ParentValue RecordData                `json:"parent_value"`
+	Warning     string                    `json:"warning"`
+	Filters     map[FieldName]Conditioner `json:"domain"`
+}
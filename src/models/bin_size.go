@@ -0,0 +1,85 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// binSizeUnits are the units used to display the size of binary fields
+var binSizeUnits = []string{"bytes", "Kb", "Mb", "Gb", "Tb"}
+
+// binSizeMode returns true if binary fields must be read as their size
+// for this RecordCollection, i.e. if its context has bin_size set to true.
+func (rc *RecordCollection) binSizeMode(fi *Field) bool {
+	if fi.fieldType != fieldtype.Binary || fi.isContextedField() {
+		return false
+	}
+	return rc.env.context.GetBool("bin_size")
+}
+
+// binarySize returns the human readable size of the value of the given
+// binary field for the first record of this RecordCollection, such as
+// "12.40 Kb", or an empty string if the field has no value.
+//
+// Sizes are computed in the database without loading the binary values,
+// with a single query for this RecordCollection and its prefetch RecordSet.
+// If the value is already in cache, its size is computed from the cache.
+func (rc *RecordCollection) binarySize(fi *Field) string {
+	id := rc.ids[0]
+	if rc.env.cache.checkIfInCache(rc.model, []int64{id}, []string{fi.json}, rc.query.ctxArgsSlug(), true) {
+		val, _ := rc.env.cache.get(rc.model, id, fi.json, rc.query.ctxArgsSlug()).(string)
+		return humanSize(int64(len(val)))
+	}
+	if rc.hasNegIds {
+		return ""
+	}
+	size, ok := rc.env.cache.getBinarySize(fi, id)
+	if !ok {
+		rc.loadBinarySizes(fi)
+		size, _ = rc.env.cache.getBinarySize(fi, id)
+	}
+	return humanSize(size)
+}
+
+// loadBinarySizes queries the database for the sizes of the values of the
+// given binary field for the records of this RecordCollection and of its
+// prefetch RecordSet, and stores them in cache.
+func (rc *RecordCollection) loadBinarySizes(fi *Field) {
+	ids := rc.ids
+	if !rc.prefetchRC.IsEmpty() && !rc.prefetchRC.hasNegIds {
+		ids = rc.Union(rc.prefetchRC).ids
+	}
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`SELECT id, COALESCE(%s, 0) AS size FROM %s WHERE id IN (?)`,
+		adapter.binarySizeSQL(fi.json), adapter.quoteTableName(rc.model.tableName))
+	var sizes []struct {
+		ID   int64
+		Size int64
+	}
+	rc.env.cr.Select(&sizes, query, ids)
+	for _, id := range ids {
+		rc.env.cache.setBinarySize(fi, id, 0)
+	}
+	for _, s := range sizes {
+		rc.env.cache.setBinarySize(fi, s.ID, s.Size)
+	}
+}
+
+// humanSize returns the human readable size of a base64 encoded
+// value of the given length, or an empty string if length is 0.
+func humanSize(length int64) string {
+	if length == 0 {
+		return ""
+	}
+	size := float64(length) * 3 / 4
+	var i int
+	for size >= 1024 && i < len(binSizeUnits)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%0.2f %s", size, binSizeUnits[i])
+}
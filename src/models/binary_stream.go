@@ -0,0 +1,180 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// binaryStreamChunkSize is the number of decoded bytes of a binary field
+// that a BinaryReader reads from the database at once. It is a multiple of 3
+// so that chunks start and end on base64 quanta.
+const binaryStreamChunkSize = 3 << 18
+
+// A BinaryReader reads the decoded content of a binary field of a record
+// directly from the database, chunk by chunk, so that large values are never
+// fully held in memory. It implements io.ReadSeeker and io.WriterTo, so that
+// it can be given to http.ServeContent to serve range requests, or copied to
+// any io.Writer with io.Copy.
+//
+// A BinaryReader must only be used during the transaction of the RecordSet it
+// has been created from.
+type BinaryReader struct {
+	rc     *RecordCollection
+	field  *Field
+	size   int64
+	offset int64
+	inline []byte
+	buf    []byte
+}
+
+// BinaryReader returns a BinaryReader of the decoded content of the given
+// binary field for the single record of this RecordCollection.
+//
+// If the value of the field is already in cache, e.g. because it has just been
+// set, it is read from the cache. Otherwise only its size is queried, and its
+// content is read from the database when needed. The reader is empty if the
+// record is not visible to the current user through record rules.
+//
+// It panics if this RecordCollection is not a singleton or if field is not a
+// stored binary field of its model.
+func (rc *RecordCollection) BinaryReader(field FieldName) *BinaryReader {
+	rc.EnsureOne()
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	fi := rc.model.fields.MustGet(field.JSON())
+	if fi.fieldType != fieldtype.Binary || !fi.isStored() || fi.isContextedField() {
+		log.Panic("BinaryReader can only be used on stored binary fields", "model", rc.model.name, "field", field)
+	}
+	br := BinaryReader{rc: rc, field: fi}
+	id := rc.ids[0]
+	if rc.hasNegIds || rc.env.cache.checkIfInCache(rc.model, []int64{id}, []string{fi.json}, rc.query.ctxArgsSlug(), true) {
+		val, _ := rc.env.cache.get(rc.model, id, fi.json, rc.query.ctxArgsSlug()).(string)
+		content, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			log.Panic("Unable to decode binary field value", "model", rc.model.name, "field", field, "id", id, "error", err)
+		}
+		br.inline = content
+		br.size = int64(len(content))
+		return &br
+	}
+	if rc.env.Pool(rc.model.name).withIds(rc.ids).ForceLoad(ID).IsEmpty() {
+		return &br
+	}
+	br.size = br.loadSize()
+	return &br
+}
+
+// loadSize returns the size of the decoded content of the field of this
+// BinaryReader, from the length and the padding of its value in the database.
+func (br *BinaryReader) loadSize() int64 {
+	adapter := adapters[db.DriverName()]
+	var length int64
+	br.rc.env.cr.Get(&length, fmt.Sprintf(`SELECT COALESCE(%s, 0) FROM %s WHERE id = ?`,
+		adapter.binarySizeSQL(br.field.json), adapter.quoteTableName(br.rc.model.tableName)), br.rc.ids[0])
+	if length < 4 {
+		return 0
+	}
+	tail := br.selectChunk(length-1, 2)
+	return length/4*3 - int64(strings.Count(tail, "="))
+}
+
+// selectChunk returns the part of the base64 value of the field of this
+// BinaryReader of the given length starting at the given position, starting at 1.
+func (br *BinaryReader) selectChunk(position, length int64) string {
+	adapter := adapters[db.DriverName()]
+	var chunk string
+	br.rc.env.cr.Get(&chunk, fmt.Sprintf(`SELECT %s FROM %s WHERE id = ?`,
+		adapter.binaryChunkSQL(br.field.json), adapter.quoteTableName(br.rc.model.tableName)), position, length, br.rc.ids[0])
+	return chunk
+}
+
+// fetch fills the buffer of this BinaryReader with the content that
+// starts at its current offset.
+func (br *BinaryReader) fetch() error {
+	if br.inline != nil {
+		br.buf = br.inline[br.offset:]
+		return nil
+	}
+	start := br.offset / 3 * 3
+	end := start + binaryStreamChunkSize
+	if end > br.size {
+		end = br.size
+	}
+	chunk := br.selectChunk(start/3*4+1, (end-start+2)/3*4)
+	content, err := base64.StdEncoding.DecodeString(chunk)
+	if err != nil {
+		return fmt.Errorf("unable to decode value of field %s of %s(%d): %s", br.field.name, br.rc.model.name, br.rc.ids[0], err)
+	}
+	if int64(len(content)) != end-start {
+		return fmt.Errorf("value of field %s of %s(%d) has been modified while reading", br.field.name, br.rc.model.name, br.rc.ids[0])
+	}
+	br.buf = content[br.offset-start:]
+	return nil
+}
+
+// Size returns the size in bytes of the decoded content of this BinaryReader.
+func (br *BinaryReader) Size() int64 {
+	return br.size
+}
+
+// Read reads up to len(p) bytes of the content into p.
+func (br *BinaryReader) Read(p []byte) (int, error) {
+	if br.offset >= br.size {
+		return 0, io.EOF
+	}
+	if len(br.buf) == 0 {
+		if err := br.fetch(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.buf)
+	br.buf = br.buf[n:]
+	br.offset += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset of the next Read, interpreted according to whence
+// as defined by io.Seeker.
+func (br *BinaryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += br.offset
+	case io.SeekEnd:
+		offset += br.size
+	}
+	if offset < 0 {
+		return 0, errors.New("models.BinaryReader.Seek: negative position")
+	}
+	if offset != br.offset {
+		br.buf = nil
+	}
+	br.offset = offset
+	return offset, nil
+}
+
+// WriteTo writes the content from the current offset to w, one chunk at a time.
+func (br *BinaryReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for br.offset < br.size {
+		if len(br.buf) == 0 {
+			if err := br.fetch(); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.Write(br.buf)
+		written += int64(n)
+		br.offset += int64(n)
+		br.buf = br.buf[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -61,10 +61,17 @@ func BootStrap() {
 	updateDefaultOrder()
 	bootStrapMethods()
 	processDepends()
+	processRelatedDepends()
 	checkFieldMethodsExist()
+	checkCompanyFieldsExist()
+	checkLineNumbering()
+	checkNameSearchFields()
+	checkActiveFields()
+	setupTouchParents()
 	checkComputeMethodsSignature()
 	setupSecurity()
 	RegisterWorker(NewWorkerFunction(FreeTransientModels, freeTransientPeriod))
+	registerViewRefreshWorkers()
 
 	Registry.bootstrapped = true
 }
@@ -432,12 +439,23 @@ func updateRelatedPaths() {
 }
 
 // updateDefaultOrder sets defaultOrder from defaultOrderStr
+// and the orders of one2many fields from their order parameter.
 func updateDefaultOrder() {
 	for _, model := range Registry.registryByName {
 		if model.IsM2MLink() {
 			continue
 		}
 		model.defaultOrder = model.ordersFromStrings(model.defaultOrderStr)
+		for _, fi := range model.fields.registryByName {
+			fi.orders = nil
+			if fi.order == "" {
+				continue
+			}
+			if fi.fieldType != fieldtype.One2Many {
+				log.Panic("Order can only be set on one2many fields", "model", model.name, "field", fi.name)
+			}
+			fi.orders = fi.relatedModel.ordersFromStrings([]string{fi.order})
+		}
 	}
 }
 
@@ -474,6 +492,24 @@ func checkFieldMethodsExist() {
 	}
 }
 
+// checkCompanyFieldsExist checks that the models of fields with CheckCompany
+// and their related models have a Company field.
+func checkCompanyFieldsExist() {
+	for _, model := range Registry.registryByName {
+		for _, field := range model.fields.registryByName {
+			if !field.checkCompany {
+				continue
+			}
+			if _, ok := model.fields.Get("Company"); !ok {
+				log.Panic("CheckCompany field on a model without Company field", "model", model.name, "field", field.name)
+			}
+			if _, ok := field.relatedModel.fields.Get("Company"); !ok {
+				log.Panic("CheckCompany field related to a model without Company field", "model", model.name, "field", field.name, "relatedModel", field.relatedModelName)
+			}
+		}
+	}
+}
+
 // loadManualSequencesFromDB fetches manual sequences from DB and updates registry
 func loadManualSequencesFromDB() {
 	if db == nil {
@@ -29,6 +29,9 @@ type cache struct {
 	data       map[string]map[int64]FieldMap                    // cache data values by model and id
 	x2mRelated map[string]map[int64]map[string]map[string]int64 // o2m and r2m relations by model, id, field, context
 	m2mLinks   map[string]map[[2]int64]bool                     // many2many relations by relation model and ids
+	searches   map[string][]int64                               // ids of cached searches by key
+	names      map[string]map[int64]string                      // display names by model and language, and id
+	binSizes   map[string]map[int64]int64                       // sizes of binary fields by model and field, and id
 }
 
 // notInCacheError is returned when a request in cache returns no entry
@@ -261,6 +264,16 @@ func (c *cache) invalidateRecord(mi *Model, id int64) {
 	}
 }
 
+// invalidateModel removes all the records of the given model from the cache,
+// as well as all cached searches.
+func (c *cache) invalidateModel(mi *Model) {
+	c.Lock()
+	delete(c.data, mi.name)
+	delete(c.x2mRelated, mi.name)
+	c.Unlock()
+	c.invalidateSearches()
+}
+
 // removeEntry removes the given entry from cache
 func (c *cache) removeEntry(mi *Model, id int64, fieldName, ctxSlug string) {
 	if !c.checkIfInCache(mi, []int64{id}, []string{fieldName}, ctxSlug, true) {
@@ -388,12 +401,91 @@ func (c *cache) getRelatedRefCommon(mi *Model, id int64, path string, ctxSlug st
 	return mi, id, exprs[0], nil
 }
 
+// getSearch returns the ids of the cached search with the given key
+// and true, or false if this search is not in cache.
+func (c *cache) getSearch(key string) ([]int64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	ids, ok := c.searches[key]
+	if !ok {
+		return nil, false
+	}
+	res := make([]int64, len(ids))
+	copy(res, ids)
+	return res, true
+}
+
+// setSearch stores the given ids as the result of the search with the given key.
+func (c *cache) setSearch(key string, ids []int64) {
+	c.Lock()
+	defer c.Unlock()
+	res := make([]int64, len(ids))
+	copy(res, ids)
+	c.searches[key] = res
+}
+
+// invalidateSearches removes all cached searches, display names and binary sizes.
+//
+// It must be called each time records are modified in the database.
+func (c *cache) invalidateSearches() {
+	c.Lock()
+	defer c.Unlock()
+	c.searches = make(map[string][]int64)
+	c.names = make(map[string]map[int64]string)
+	c.binSizes = make(map[string]map[int64]int64)
+}
+
+// getDisplayName returns the cached display name of the record of the given
+// model and id in the given language and true, or false if it is not in cache.
+func (c *cache) getDisplayName(mi *Model, id int64, lang string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	name, ok := c.names[mi.name+"/"+lang][id]
+	return name, ok
+}
+
+// setDisplayName stores the display name of the record of the
+// given model and id in the given language.
+func (c *cache) setDisplayName(mi *Model, id int64, lang, name string) {
+	c.Lock()
+	defer c.Unlock()
+	key := mi.name + "/" + lang
+	if c.names[key] == nil {
+		c.names[key] = make(map[int64]string)
+	}
+	c.names[key][id] = name
+}
+
+// getBinarySize returns the cached size in bytes of the given binary field
+// of the record with the given id and true, or false if it is not in cache.
+func (c *cache) getBinarySize(fi *Field, id int64) (int64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	size, ok := c.binSizes[fi.model.name+"/"+fi.json][id]
+	return size, ok
+}
+
+// setBinarySize stores the size in bytes of the given binary
+// field of the record with the given id.
+func (c *cache) setBinarySize(fi *Field, id int64, size int64) {
+	c.Lock()
+	defer c.Unlock()
+	key := fi.model.name + "/" + fi.json
+	if c.binSizes[key] == nil {
+		c.binSizes[key] = make(map[int64]int64)
+	}
+	c.binSizes[key][id] = size
+}
+
 // newCache creates a pointer to a new cache instance.
 func newCache() *cache {
 	res := cache{
 		data:       make(map[string]map[int64]FieldMap),
 		x2mRelated: make(map[string]map[int64]map[string]map[string]int64),
 		m2mLinks:   make(map[string]map[[2]int64]bool),
+		searches:   make(map[string][]int64),
+		names:      make(map[string]map[int64]string),
+		binSizes:   make(map[string]map[int64]int64),
 	}
 	return &res
 }
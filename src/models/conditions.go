@@ -17,6 +17,7 @@ package models
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/hexya-erp/hexya/src/models/operator"
 )
@@ -28,15 +29,33 @@ const (
 	ContextSep = "|"
 )
 
+// RawSQLTable is the placeholder to use in raw SQL conditions to reference
+// the table of the model being searched. It is replaced by the quoted table name.
+const RawSQLTable = "{table}"
+
+// Quantifiers of the conditions on the records of a one2many or many2many field
+const (
+	anyQuantifier       = "any"
+	allQuantifier       = "all"
+	aggregateQuantifier = "aggregate"
+)
+
 // A predicate of a condition in the form 'Field = arg'
 type predicate struct {
-	exprs    []FieldName
-	operator operator.Operator
-	arg      interface{}
-	cond     *Condition
-	isOr     bool
-	isNot    bool
-	isCond   bool
+	exprs      []FieldName
+	operator   operator.Operator
+	arg        interface{}
+	cond       *Condition
+	isOr       bool
+	isNot      bool
+	isCond     bool
+	rawSQL     string
+	quantifier string
+	subCond    *Condition
+	datePart   DatePart
+	strict     bool
+	empty      bool
+	aggregate  *aggregateFilter
 }
 
 // Field returns the field name of this predicate
@@ -80,6 +99,20 @@ type Condition struct {
 	predicates []predicate
 }
 
+// RawCondition returns a new Condition made of the given raw SQL predicate,
+// which is inserted between brackets as is in the WHERE clause of the query.
+// Args are bound to the '?' placeholders of sql by the database driver.
+//
+// Columns of the searched model's table must be referenced with the RawSQLTable
+// placeholder, e.g. "length({table}.name) > ?". Joined tables are not available.
+//
+// This is an escape hatch for filters that cannot be expressed otherwise: the caller
+// is responsible for the correctness and the safety of the given SQL. Never build
+// sql from user input. Note that record rules still apply to the query.
+func RawCondition(sql string, args ...interface{}) *Condition {
+	return newCondition().And().RawSQL(sql, args...)
+}
+
 // newCondition returns a new condition struct
 func newCondition() *Condition {
 	c := &Condition{}
@@ -204,6 +237,23 @@ func (c Condition) String() string {
 			res += fmt.Sprintf("(\n%s\n)\n", p.cond.String())
 			continue
 		}
+		if p.rawSQL != "" {
+			res += fmt.Sprintf("RAW SQL (%s) %v\n", p.rawSQL, p.arg)
+			continue
+		}
+		if p.aggregate != nil {
+			res += fmt.Sprintf("%s(%s.%s) %s %v (\n%s\n)\n", p.aggregate.function, joinFieldNames(p.exprs, ExprSep).Name(),
+				p.aggregate.field.Name(), p.operator, p.arg, p.subCond.String())
+			continue
+		}
+		if p.quantifier != "" {
+			res += fmt.Sprintf("%s %s (\n%s\n)\n", joinFieldNames(p.exprs, ExprSep).Name(), p.quantifier, p.subCond.String())
+			continue
+		}
+		if p.datePart != "" {
+			res += fmt.Sprintf("%s.%s %s %v\n", joinFieldNames(p.exprs, ExprSep).Name(), p.datePart, p.operator, p.arg)
+			continue
+		}
 		res += fmt.Sprintf("%s %s %v\n", joinFieldNames(p.exprs, ExprSep).Name(), p.operator, p.arg)
 	}
 	return res
@@ -246,11 +296,103 @@ func (cs ConditionStart) FilteredOn(field FieldName, condition *Condition) *Cond
 	return &res
 }
 
+// AnyOf adds a condition on the given one2many or many2many field which
+// is true for the records that have at least one related record matching
+// the given condition. The condition is expressed on the related model.
+//
+// AnyOf can also be used on a many2one field, to get the records whose related
+// record matches the given condition. Unlike a condition on a path through the
+// field, record rules of the related model apply to the related record.
+func (cs ConditionStart) AnyOf(field FieldName, condition *Condition) *Condition {
+	return cs.quantified(anyQuantifier, field, condition)
+}
+
+// AllOf adds a condition on the given one2many or many2many field which
+// is true for the records whose related records all match the given condition,
+// i.e. that have no related record which does not match it. Records without
+// related records match an AllOf condition. The condition is expressed on the
+// related model.
+func (cs ConditionStart) AllOf(field FieldName, condition *Condition) *Condition {
+	return cs.quantified(allQuantifier, field, condition)
+}
+
+// quantified adds a predicate with the given quantifier on
+// the related records of field matching condition.
+func (cs ConditionStart) quantified(quantifier string, field FieldName, condition *Condition) *Condition {
+	res := cs.cond
+	res.predicates = append(res.predicates, predicate{
+		exprs:      splitFieldNames(field, ExprSep),
+		quantifier: quantifier,
+		subCond:    condition,
+		isNot:      cs.nextIsNot,
+		isOr:       cs.nextIsOr,
+	})
+	return &res
+}
+
+// InBoundingBox adds a condition which is true for the records whose point
+// given by the lat and lng float fields falls within the bounding box defined
+// by its south, west, north and east limits, in degrees. Limits are inclusive.
+//
+// If west is greater than east, the bounding box is considered to cross the
+// antimeridian, so that longitudes greater than west or lower than east match.
+func (cs ConditionStart) InBoundingBox(lat, lng FieldName, south, west, north, east float64) *Condition {
+	box := newCondition().And().Field(lat).GreaterOrEqual(south).And().Field(lat).LowerOrEqual(north)
+	if west <= east {
+		box = box.And().Field(lng).GreaterOrEqual(west).And().Field(lng).LowerOrEqual(east)
+	} else {
+		box = box.AndCond(newCondition().And().Field(lng).GreaterOrEqual(west).Or().Field(lng).LowerOrEqual(east))
+	}
+	res := cs.cond
+	res.predicates = append(res.predicates, predicate{
+		cond:   box,
+		isCond: true,
+		isNot:  cs.nextIsNot,
+		isOr:   cs.nextIsOr,
+	})
+	return &res
+}
+
+// CreatedBy adds a condition which is true for the records that have
+// been created by the user with the given id.
+func (cs ConditionStart) CreatedBy(uid int64) *Condition {
+	return cs.Field(NewFieldName("CreateUID", "create_uid")).Equals(uid)
+}
+
+// FollowedBy adds a condition which is true for the records that have at least
+// one related record through the given followers one2many or many2many field
+// whose follower field equals value, e.g.
+//
+//	cs.FollowedBy(h.SaleOrder().Fields().MessageFollowers(), h.MailFollower().Fields().Partner(), partner)
+//
+// This is a shortcut for an AnyOf condition on the followers field.
+func (cs ConditionStart) FollowedBy(followers, follower FieldName, value interface{}) *Condition {
+	return cs.AnyOf(followers, newCondition().And().Field(follower).Equals(value))
+}
+
+// RawSQL adds the given raw SQL predicate to this condition.
+// See RawCondition for details and precautions.
+func (cs ConditionStart) RawSQL(sql string, args ...interface{}) *Condition {
+	if sql == "" {
+		log.Panic("RawSQL must be called with a non empty SQL string")
+	}
+	res := cs.cond
+	res.predicates = append(res.predicates, predicate{
+		rawSQL: sql,
+		arg:    SQLParams(args),
+		isNot:  cs.nextIsNot,
+		isOr:   cs.nextIsOr,
+	})
+	return &res
+}
+
 // A ConditionField is a partial Condition when we have set
 // a field name in a predicate and are about to add an operator.
 type ConditionField struct {
-	cs    ConditionStart
-	exprs []FieldName
+	cs        ConditionStart
+	exprs     []FieldName
+	datePart  DatePart
+	aggregate *aggregateFilter
 }
 
 // JSON returns the json field name of this ConditionField
@@ -273,9 +415,40 @@ var _ FieldName = ConditionField{}
 // This method is low level and should be avoided. Use operator methods such as Equals()
 // instead.
 func (c ConditionField) AddOperator(op operator.Operator, data interface{}) *Condition {
+	if c.aggregate != nil {
+		return c.addAggregateOperator(op, data)
+	}
+	switch op {
+	case operator.IsEmpty:
+		return c.IsNull()
+	case operator.IsSet:
+		return c.IsSet()
+	}
 	cond := c.cs.cond
+	if rs, ok := data.(RecordSet); ok && (op == operator.In || op == operator.NotIn) && rs.Collection().isSubSearch() {
+		// Keep the search to execute it as a subquery
+		cond.predicates = append(cond.predicates, predicate{
+			exprs:    c.exprs,
+			operator: op,
+			arg:      rs.Collection().clone(),
+			isNot:    c.cs.nextIsNot,
+			isOr:     c.cs.nextIsOr,
+		})
+		return &cond
+	}
 	data = sanitizeArgs(data, op.IsMulti())
-	if data != nil && op.IsMulti() && reflect.ValueOf(data).Kind() == reflect.Slice && reflect.ValueOf(data).Len() == 0 {
+	if data != nil && op == operator.NotIn && reflect.ValueOf(data).Kind() == reflect.Slice && reflect.ValueOf(data).Len() == 0 {
+		// field not in [] => ID != -1
+		cond.predicates = append(cond.predicates, predicate{
+			exprs:    []FieldName{ID},
+			operator: operator.NotEquals,
+			arg:      -1,
+			isNot:    c.cs.nextIsNot,
+			isOr:     c.cs.nextIsOr,
+		})
+		return &cond
+	}
+	if data != nil && op.IsMulti() && op != operator.ContainsAll && reflect.ValueOf(data).Kind() == reflect.Slice && reflect.ValueOf(data).Len() == 0 {
 		// field in [] => ID = -1
 		cond.predicates = []predicate{{
 			exprs:    []FieldName{ID},
@@ -290,6 +463,7 @@ func (c ConditionField) AddOperator(op operator.Operator, data interface{}) *Con
 		arg:      data,
 		isNot:    c.cs.nextIsNot,
 		isOr:     c.cs.nextIsOr,
+		datePart: c.datePart,
 	})
 	return &cond
 }
@@ -370,17 +544,55 @@ func (c ConditionField) IContains(data interface{}) *Condition {
 	return c.AddOperator(operator.IContains, data)
 }
 
+// Similar appends a trigram similarity operator to the current Condition,
+// which is true for the records whose value is similar to data, even with typos.
+//
+// The field must have the Trigram option set.
+func (c ConditionField) Similar(data interface{}) *Condition {
+	return c.AddOperator(operator.Similar, data)
+}
+
+// JSONContains appends a JSON containment operator to the current Condition,
+// which is true for the records whose JSON value contains the JSON encoding
+// of data: an element of an array, or a subset of the keys of an object with
+// their values.
+//
+// The field must be a stored char or text field with the JSONIndex option.
+func (c ConditionField) JSONContains(data interface{}) *Condition {
+	return c.AddOperator(operator.JSONContains, data)
+}
+
+// JSONPathContains appends a JSON containment operator to the current Condition,
+// which is true for the records whose JSON object has the given value at the
+// given path. path is a dot separated list of keys, such as "theme.color".
+//
+// The field must be a stored char or text field with the JSONIndex option.
+func (c ConditionField) JSONPathContains(path string, value interface{}) *Condition {
+	if path == "" {
+		log.Panic("Empty JSON path", "field", joinFieldNames(c.exprs, ExprSep))
+	}
+	doc := make(map[string]interface{})
+	setJSONPath(doc, strings.Split(path, "."), value)
+	return c.AddOperator(operator.JSONContains, doc)
+}
+
 // NotIContains appends the 'NOT ILIKE %%' operator to the current Condition
 func (c ConditionField) NotIContains(data interface{}) *Condition {
 	return c.AddOperator(operator.NotIContains, data)
 }
 
 // In appends the 'IN' operator to the current Condition
+//
+// If data is a RecordSet resulting from a search that has not been fetched
+// yet, the search is executed as a subquery of the query instead of fetching
+// its ids first, when the field is a many2one or one2one field to its model
+// or the ID field of the searched model.
 func (c ConditionField) In(data interface{}) *Condition {
 	return c.AddOperator(operator.In, data)
 }
 
-// NotIn appends the 'NOT IN' operator to the current Condition
+// NotIn appends the 'NOT IN' operator to the current Condition.
+// RecordSets searches are executed as subqueries as for In.
 func (c ConditionField) NotIn(data interface{}) *Condition {
 	return c.AddOperator(operator.NotIn, data)
 }
@@ -390,14 +602,55 @@ func (c ConditionField) ChildOf(data interface{}) *Condition {
 	return c.AddOperator(operator.ChildOf, data)
 }
 
-// IsNull checks if the current condition field is null
+// ContainsAll appends the 'contains all' operator to the current Condition.
+//
+// It matches the records whose one2many or many2many field contains all the
+// records given as a RecordSet or a slice of ids.
+func (c ConditionField) ContainsAll(data interface{}) *Condition {
+	return c.AddOperator(operator.ContainsAll, data)
+}
+
+// ContainsAny appends the 'contains any' operator to the current Condition.
+//
+// It matches the records whose one2many or many2many field contains at least
+// one of the records given as a RecordSet or a slice of ids.
+func (c ConditionField) ContainsAny(data interface{}) *Condition {
+	return c.AddOperator(operator.ContainsAny, data)
+}
+
+// IsNull checks if the current condition field is empty, which depends on its type:
+//
+// - Char, Text, HTML, Selection and Binary fields are empty if they are NULL or an empty string
+// - Boolean fields are empty if they are NULL or false
+// - Many2One and One2One fields are empty if they are NULL
+// - One2Many and Many2Many fields are empty if they have no related records
+// - Other fields, such as Integer, Float, Date and DateTime fields, are empty only if they are NULL
+//
+// Unlike Equals with nil or false, IsNull never considers zero numbers or dates as empty.
 func (c ConditionField) IsNull() *Condition {
-	return c.AddOperator(operator.Equals, nil)
+	return c.emptyCondition(operator.Equals)
 }
 
-// IsNotNull checks if the current condition field is not null
+// IsNotNull checks if the current condition field is set. It is the same as IsSet.
 func (c ConditionField) IsNotNull() *Condition {
-	return c.AddOperator(operator.NotEquals, nil)
+	return c.emptyCondition(operator.NotEquals)
+}
+
+// IsSet checks if the current condition field is set, i.e. not empty as defined by IsNull.
+func (c ConditionField) IsSet() *Condition {
+	return c.emptyCondition(operator.NotEquals)
+}
+
+// emptyCondition appends a predicate testing whether the current condition field
+// is empty with the Equals operator, or set with the NotEquals operator.
+//
+// Such predicates are serialized with the is_empty and is_set operators, which
+// AddOperator turns back into IsNull and IsSet, since "= false" would also
+// match zero numbers.
+func (c ConditionField) emptyCondition(op operator.Operator) *Condition {
+	cond := c.AddOperator(op, nil)
+	cond.predicates[len(cond.predicates)-1].empty = true
+	return cond
 }
 
 // IsEmpty check the condition arguments are empty or not.
@@ -413,12 +666,42 @@ func (c *Condition) IsEmpty() bool {
 	return false
 }
 
+// isImpossible returns true if no record can match this condition, such as
+// a condition on a field being in an empty list, which is replaced by ID = -1.
+//
+// Only conditions in which the impossible predicate is AND-ed with all the
+// others are detected.
+func (c *Condition) isImpossible() bool {
+	if c == nil {
+		return false
+	}
+	var res bool
+	for _, p := range c.predicates {
+		if p.isOr {
+			return false
+		}
+		switch {
+		case p.isNot:
+		case p.cond != nil:
+			res = res || p.cond.isImpossible()
+		case len(p.exprs) == 1 && p.exprs[0].JSON() == ID.JSON() && p.operator == operator.Equals && p.arg == -1:
+			res = true
+		}
+	}
+	return res
+}
+
 // getAllExpressions returns a list of all exprs used in this condition,
 // and recursively in all subconditions.
 // Expressions are given in field json format
 func (c Condition) getAllExpressions(mi *Model) [][]FieldName {
 	var res [][]FieldName
 	for _, p := range c.predicates {
+		if p.quantifier != "" || p.operator.IsContainment() || p.isRelationMembership(mi) {
+			// Only the record holding the relation field needs to be joined
+			res = append(res, append(p.exprs[:len(p.exprs)-1:len(p.exprs)-1], ID))
+			continue
+		}
 		res = append(res, p.exprs)
 		if p.cond != nil {
 			res = append(res, p.cond.getAllExpressions(mi)...)
@@ -427,6 +710,39 @@ func (c Condition) getAllExpressions(mi *Model) [][]FieldName {
 	return res
 }
 
+// isRelationMembership returns true if this predicate tests the related
+// records of a one2many or many2many field, such as Tags = 3 or Tags IS NULL.
+//
+// Such predicates are translated into subqueries instead of joins.
+func (p predicate) isRelationMembership(mi *Model) bool {
+	if len(p.exprs) == 0 || p.quantifier != "" || p.rawSQL != "" {
+		return false
+	}
+	switch p.operator {
+	case operator.Equals, operator.NotEquals, operator.In, operator.NotIn:
+	default:
+		return false
+	}
+	return mi.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep)).fieldType.Is2ManyRelationType()
+}
+
+// deepCopy returns a copy of this condition that shares no predicate
+// with the original, so that it can be modified in place.
+func (c Condition) deepCopy() *Condition {
+	res := Condition{predicates: make([]predicate, len(c.predicates))}
+	for i, p := range c.predicates {
+		p.exprs = append([]FieldName(nil), p.exprs...)
+		if p.cond != nil {
+			p.cond = p.cond.deepCopy()
+		}
+		if p.subCond != nil {
+			p.subCond = p.subCond.deepCopy()
+		}
+		res.predicates[i] = p
+	}
+	return &res
+}
+
 // substituteExprs recursively replaces condition exprs that match substs keys
 // with the corresponding substs values.
 func (c *Condition) substituteExprs(mi *Model, substs map[FieldName][]FieldName) {
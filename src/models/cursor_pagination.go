@@ -0,0 +1,229 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/typesutils"
+)
+
+// A pageCursor is the decoded content of a pagination cursor token.
+type pageCursor struct {
+	Orders string        `json:"o"`
+	Values []interface{} `json:"v"`
+}
+
+// SearchPage returns at most limit records of this RecordSet that come after
+// the given cursor in the order of this RecordSet, and the cursor of the next
+// page, which is empty if there are no more records. An empty cursor returns
+// the first page.
+//
+// Pages are found by comparing the order keys of the records with those of the
+// last record of the previous page, which are encoded in the opaque cursor,
+// instead of skipping rows with an offset. Pagination is therefore stable when
+// records are inserted or deleted between pages, and deep pages are as fast as
+// the first one if the order keys are indexed.
+//
+// Records are ordered by the orders of this RecordSet, or by the default order
+// of the model, and finally by ID. Orders must be on stored fields, possibly
+// through many2one paths, but not through x2many fields. A cursor can only be
+// used with the model and orders it has been returned for.
+//
+// Order keys may be NULL. NULL values are sorted after all other values in
+// ascending orders and before them in descending orders, unless NULLS FIRST or
+// NULLS LAST is given, and they are told apart from empty values such as zero
+// or empty strings, so that no record is skipped or repeated around them.
+//
+// It panics if the cursor is invalid.
+func (rc *RecordCollection) SearchPage(limit int, cursor string) (*RecordCollection, string) {
+	if limit <= 0 {
+		log.Panic("SearchPage must be called with a positive limit", "model", rc.model.name, "limit", limit)
+	}
+	rSet := rc.clone()
+	rSet.applyDefaultOrder()
+	orders := rSet.query.orders
+	ordersKey := rSet.checkPageOrders()
+	if cursor != "" {
+		values := rSet.decodePageCursor(cursor, ordersKey)
+		rSet = rSet.Search(keysetCondition(orders, values))
+	}
+	rSet = rSet.Limit(limit + 1).Fetch()
+	if rSet.Len() <= limit {
+		return rSet, ""
+	}
+	page := newRecordCollection(rc.Env(), rc.model.name).withIds(rSet.ids[:limit])
+	last := page.Records()[limit-1]
+	values := make([]interface{}, len(orders))
+	for i, order := range orders {
+		values[i] = last.pageCursorValue(order.field)
+	}
+	return page, encodePageCursor(pageCursor{Orders: ordersKey, Values: values})
+}
+
+// checkPageOrders panics if the orders of this RecordCollection cannot be used
+// for cursor pagination, and returns a string that identifies them otherwise.
+func (rc *RecordCollection) checkPageOrders() string {
+	keys := []string{rc.model.name}
+	for _, order := range rc.query.orders {
+		if order.bySimilarity || order.byIds != nil {
+			log.Panic("Cursor pagination is not possible with similarity or ids orders", "model", rc.model.name)
+		}
+		exprs := splitFieldNames(order.field, ExprSep)
+		for i := range exprs {
+			fi := rc.model.getRelatedFieldInfo(joinFieldNames(exprs[:i+1], ExprSep))
+			if !fi.isStored() {
+				log.Panic("Cursor pagination orders must be on stored fields through many2one paths", "model", rc.model.name,
+					"field", order.field)
+			}
+		}
+		direction := "asc"
+		if order.desc {
+			direction = "desc"
+		}
+		if order.nullsLast() {
+			direction += " nulls last"
+		}
+		keys = append(keys, fmt.Sprintf("%s %s", order.field.JSON(), direction))
+	}
+	return strings.Join(keys, ",")
+}
+
+// nullsLast returns true if NULL values come after
+// all other values with this orderPredicate.
+func (o orderPredicate) nullsLast() bool {
+	if o.nulls != "" {
+		return o.nulls == "LAST"
+	}
+	return !o.desc
+}
+
+// keysetCondition returns the condition on the given orders which is true for
+// the records that come after a record with the given order key values, nil
+// values being NULL.
+func keysetCondition(orders []orderPredicate, values []interface{}) *Condition {
+	res := newCondition()
+	for i, order := range orders {
+		after := keysetAfterCondition(order, values[i])
+		if after == nil {
+			// Nothing comes after NULL
+			continue
+		}
+		cond := newCondition()
+		for j := 0; j < i; j++ {
+			cond = cond.AndCond(strictCondition(orders[j].field, operator.Equals, values[j]))
+		}
+		res = res.OrCond(cond.AndCond(after))
+	}
+	return res
+}
+
+// keysetAfterCondition returns the condition on the field of the given order
+// which is true for the values that come after the given value, or nil if no
+// value comes after it.
+func keysetAfterCondition(order orderPredicate, value interface{}) *Condition {
+	switch {
+	case value == nil && order.nullsLast():
+		return nil
+	case value == nil:
+		return strictCondition(order.field, operator.NotEquals, nil)
+	}
+	op := operator.Greater
+	if order.desc {
+		op = operator.Lower
+	}
+	res := strictCondition(order.field, op, value)
+	if order.nullsLast() {
+		res = res.OrCond(strictCondition(order.field, operator.Equals, nil))
+	}
+	return res
+}
+
+// strictCondition returns a condition comparing the given field with value as
+// is. Unlike other conditions, a nil value only matches NULL and empty values
+// such as zero or empty strings are regular values.
+func strictCondition(field FieldName, op operator.Operator, value interface{}) *Condition {
+	res := newCondition()
+	res.predicates = append(res.predicates, predicate{
+		exprs:    splitFieldNames(field, ExprSep),
+		operator: op,
+		arg:      value,
+		strict:   true,
+	})
+	return res
+}
+
+// pageCursorValue returns the value to encode in a cursor for the given
+// order key of this RecordCollection with a single record, which is nil
+// if the value of this record is NULL.
+func (rc *RecordCollection) pageCursorValue(field FieldName) interface{} {
+	value := rc.Get(field)
+	if typesutils.IsZero(value) && field.JSON() != ID.JSON() {
+		// Zero values are also read for NULL, so we check in the database
+		isNull := rc.Env().Pool(rc.model.name).Search(rc.model.Field(ID).Equals(rc.ids[0]).
+			AndCond(strictCondition(field, operator.Equals, nil))).SearchCount()
+		if isNull > 0 {
+			return nil
+		}
+	}
+	switch v := value.(type) {
+	case RecordSet:
+		if v.IsEmpty() {
+			return nil
+		}
+		return v.Ids()[0]
+	case dates.DateTime:
+		return v.UTC().Format(time.RFC3339Nano)
+	case dates.Date:
+		return v.Format(dates.DefaultServerDateFormat)
+	}
+	return value
+}
+
+// encodePageCursor returns the opaque token of the given cursor.
+func encodePageCursor(cursor pageCursor) string {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		log.Panic("Unable to encode pagination cursor", "error", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor returns the order key values of the given cursor token,
+// after checking that it has been returned for the given orders.
+func (rc *RecordCollection) decodePageCursor(token, ordersKey string) []interface{} {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		log.Panic("Invalid pagination cursor", "model", rc.model.name, "error", err)
+	}
+	var cursor pageCursor
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		log.Panic("Invalid pagination cursor", "model", rc.model.name, "error", err)
+	}
+	if cursor.Orders != ordersKey || len(cursor.Values) != len(rc.query.orders) {
+		log.Panic("Pagination cursor does not match the orders of the RecordSet", "model", rc.model.name,
+			"orders", ordersKey)
+	}
+	for i, value := range cursor.Values {
+		num, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		if intVal, err := num.Int64(); err == nil {
+			cursor.Values[i] = intVal
+			continue
+		}
+		cursor.Values[i], _ = num.Float64()
+	}
+	return cursor.Values
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/operator"
+)
+
+// An OperatorTranslator returns the SQL fragment and its arguments for a custom
+// operator applied to the given SQL column expression with the given value.
+// Arguments are referred to in the fragment with ? placeholders.
+type OperatorTranslator func(column string, value interface{}) (string, []interface{})
+
+// customOperators holds the translators of the operators registered with RegisterOperator
+var customOperators = make(map[operator.Operator]OperatorTranslator)
+
+// RegisterOperator registers a custom operator which is translated to SQL by the
+// given translator, so that conditions can use it with AddOperator, e.g.
+//
+//	models.RegisterOperator("within_distance", func(column string, value interface{}) (string, []interface{}) {
+//	    d := value.(Distance)
+//	    return fmt.Sprintf("ST_DWithin(%s, ST_MakePoint(?, ?), ?)", column), []interface{}{d.Lng, d.Lat, d.Meters}
+//	})
+//	q.Shop().Location().AddOperator("within_distance", Distance{Lng: 2.35, Lat: 48.85, Meters: 1000})
+//
+// It is meant to be called by modules in their init function. The fragment is
+// wrapped between brackets, and the column may be a joined column when the
+// condition is on a related path. Values are not converted: a RecordSet value
+// is given as its id.
+//
+// It panics if op is a built-in operator or if it has already been registered.
+func RegisterOperator(op operator.Operator, translator OperatorTranslator) {
+	if op.IsValid() {
+		log.Panic("Built-in operators cannot be overridden", "operator", op)
+	}
+	if _, exists := customOperators[op]; exists {
+		log.Panic("Operator is already registered", "operator", op)
+	}
+	customOperators[op] = translator
+}
+
+// customOperatorSQLClause returns the sql string and arguments of the given
+// custom operator translator applied to field with the given arg.
+func customOperatorSQLClause(translator OperatorTranslator, field string, arg interface{}) (string, SQLParams) {
+	sql, args := translator(field, arg)
+	return fmt.Sprintf("(%s)", sql), SQLParams(args)
+}
@@ -26,23 +26,7 @@ func LoadCSVDataFile(fileName string) {
 	}
 	defer csvFile.Close()
 
-	elements := strings.Split(filepath.Base(fileName), "_")
-	modelName := strings.Split(elements[0], ".")[0]
-	modelName = strings.TrimLeft(modelName, "01234567890-")
-	var (
-		update  bool
-		version int
-	)
-	if len(elements) == 2 {
-		mod := strings.Split(elements[1], ".")[0]
-		ver, err := strconv.Atoi(mod)
-		switch {
-		case strings.ToLower(mod) == "update":
-			update = true
-		case err == nil:
-			version = ver
-		}
-	}
+	modelName, update, version := parseDataFileName(fileName)
 
 	r := csv.NewReader(csvFile)
 	headers, err := r.Read()
@@ -92,6 +76,81 @@ func LoadCSVDataFile(fileName string) {
 	log.Debug("Data file imported successfully", "fileName", fileName)
 }
 
+// parseDataFileName returns the model name of the given CSV data file, whether
+// its records must update existing records, and its version.
+func parseDataFileName(fileName string) (string, bool, int) {
+	elements := strings.Split(filepath.Base(fileName), "_")
+	modelName := strings.Split(elements[0], ".")[0]
+	modelName = strings.TrimLeft(modelName, "01234567890-")
+	var (
+		update  bool
+		version int
+	)
+	if len(elements) == 2 {
+		mod := strings.Split(elements[1], ".")[0]
+		ver, err := strconv.Atoi(mod)
+		switch {
+		case strings.ToLower(mod) == "update":
+			update = true
+		case err == nil:
+			version = ver
+		}
+	}
+	return modelName, update, version
+}
+
+// UnlinkCSVDataRecords deletes from the database the records of the given
+// CSV data file, found by their external IDs, in the given Environment.
+// Records of the file that do not exist in the database are ignored.
+//
+// Update data files are ignored, since their records have been created by
+// the data files of other modules.
+//
+// It returns the number of deleted records.
+func UnlinkCSVDataRecords(env Environment, fileName string) int64 {
+	modelName, update, _ := parseDataFileName(fileName)
+	if update {
+		log.Info("Skipping update data file", "fileName", fileName)
+		return 0
+	}
+	log.Info("Removing data file records", "fileName", fileName)
+	csvFile, err := os.Open(fileName)
+	if err != nil {
+		log.Panic("Unable to open CSV data file", "error", err, "fileName", fileName)
+	}
+	defer csvFile.Close()
+
+	records, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		log.Panic("Unable to read CSV data file", "error", err, "fileName", fileName)
+	}
+	if len(records) == 0 {
+		return 0
+	}
+	rc := env.Pool(modelName)
+	idCol := -1
+	for i, header := range records[0] {
+		if rc.Model().JSONizeFieldName(header) == "id" {
+			idCol = i
+			break
+		}
+	}
+	if idCol < 0 {
+		log.Panic("No ID column in CSV data file", "fileName", fileName)
+	}
+	externalIDs := make([]string, len(records)-1)
+	for i, record := range records[1:] {
+		externalIDs[i] = record[idCol]
+	}
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	// such as "Active test".
+	recs := rc.Search(rc.Model().Field(rc.model.FieldName("HexyaExternalID")).In(externalIDs))
+	if recs.IsEmpty() {
+		return 0
+	}
+	return recs.Call("Unlink").(int64)
+}
+
 func getRecordValuesMap(headers []string, modelName string, record []string, env Environment, line int, fileName string) FieldMap {
 	values := make(map[string]interface{})
 	model := Registry.MustGet(modelName)
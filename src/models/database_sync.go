@@ -5,31 +5,75 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
 
 // SyncDatabase creates or updates database tables with the data in the model registry
+//
+// The database is initialized in the following stages, each stage applying to
+// all the models in their declaration order, so that the models of a module
+// come after those of the modules it depends on:
+// - tables and columns,
+// - foreign keys and table constraints,
+// - the Init method of manual models, which create their SQL views,
+// - indexes,
+// - the Init method of the other models,
+// - the backfill of new stored computed and related fields.
+//
+// Data files and the PostInit functions of modules are loaded afterwards by the
+// server, in the order of the modules.
 func SyncDatabase() {
 	log.Info("Updating database schema")
+	syncDatabaseSchema()
+	// Run init method on each model
+	for _, model := range Registry.orderedModels() {
+		if model.IsMixin() || model.IsManual() {
+			continue
+		}
+		runInit(model)
+	}
+	// Backfill the columns of new stored computed and related fields
+	recomputeFields(newStoredFields, inNewEnvironment)
+	newStoredFields = nil
+}
+
+// syncDatabaseSchema creates or updates the database sequences, tables, columns,
+// constraints and indexes with the data in the model registry. SQL views of
+// manual models are created after constraints, unless a migration is generated.
+func syncDatabaseSchema() {
 	adapter := adapters[db.DriverName()]
+	if renameDBSchema() && schemaMigration != nil {
+		// Other changes can only be computed once renames are applied
+		return
+	}
 	dbTables := adapter.tables()
 	// Create or update sequences
 	updateDBSequences()
+	// Create the functions used by indexes
+	updateDBUnaccentFunction()
+	updateDBTrigramExtension()
 	// Create or update existing tables
-	for tableName, model := range Registry.registryByTableName {
+	for _, model := range Registry.orderedModels() {
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
-		if _, ok := dbTables[tableName]; !ok {
+		if _, ok := dbTables[model.tableName]; !ok {
 			createDBTable(model)
+			if schemaMigration != nil {
+				// The table is not really created, but all its columns are in the
+				// recorded CREATE TABLE statement, so we must not add them again.
+				continue
+			}
 		}
 		updateDBColumns(model)
-		updateDBIndexes(model)
 	}
 	// Setup constraints
-	for _, model := range Registry.registryByTableName {
+	for _, model := range Registry.orderedModels() {
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
@@ -37,14 +81,24 @@ func SyncDatabase() {
 		updateDBForeignKeyConstraints(model)
 		updateDBConstraints(model)
 	}
-	// Run init method on each model
-	for _, model := range Registry.registryByTableName {
-		if model.IsMixin() {
+	// Create SQL views
+	if schemaMigration == nil {
+		for _, model := range Registry.orderedModels() {
+			if model.IsMaterializedView() {
+				createMaterializedView(model)
+			}
+			if model.IsManual() {
+				runInit(model)
+			}
+		}
+	}
+	// Create or update indexes
+	for _, model := range Registry.orderedModels() {
+		if model.IsMixin() || model.IsManual() {
 			continue
 		}
-		runInit(model)
+		updateDBIndexes(model)
 	}
-
 	// Drop DB tables that are not in the models
 	for dbTable := range adapter.tables() {
 		var modelExists bool
@@ -87,17 +141,26 @@ func updateDBSequences() {
 		if !sequence.boot {
 			continue
 		}
-		exists := false
-		for _, dbSeq := range adapter.sequences("%_bootseq") {
-			if sequence.JSON == dbSeq.Name {
+		var (
+			exists bool
+			dbSeq  seqData
+		)
+		for _, seq := range adapter.sequences("%_bootseq") {
+			if sequence.JSON == seq.Name {
 				exists = true
+				dbSeq = seq
 			}
 		}
 		if !exists {
-			adapter.createSequence(sequence.JSON, sequence.Increment, sequence.Start)
+			executeSchemaStatement(adapter.createSequenceSQL(sequence.JSON, sequence.Increment, sequence.Start),
+				adapter.dropSequenceSQL(sequence.JSON))
+			continue
+		}
+		if sequence.Increment == dbSeq.Increment && sequence.Start == dbSeq.StartValue {
 			continue
 		}
-		adapter.alterSequence(sequence.JSON, sequence.Increment, sequence.Start)
+		executeSchemaStatement(adapter.alterSequenceSQL(sequence.JSON, sequence.Increment, sequence.Start),
+			adapter.alterSequenceSQL(dbSeq.Name, dbSeq.Increment, dbSeq.StartValue))
 	}
 	// Drop unused boot sequences
 	for _, dbSeq := range adapter.sequences("%_bootseq") {
@@ -109,9 +172,237 @@ func updateDBSequences() {
 			}
 		}
 		if !sequenceExists {
-			adapter.dropSequence(dbSeq.Name)
+			executeSchemaStatement(adapter.dropSequenceSQL(dbSeq.Name),
+				adapter.createSequenceSQL(dbSeq.Name, dbSeq.Increment, dbSeq.StartValue))
+		}
+	}
+}
+
+// renameDBSchema renames the tables and columns declared with RenameModel
+// and RenameField, if they have not been renamed yet.
+//
+// It returns true if at least one table or column has been renamed.
+func renameDBSchema() bool {
+	adapter := adapters[db.DriverName()]
+	var renamed bool
+	mRenames, fRenames := schemaRenames()
+	// oldTables are the current names of the tables that are being renamed
+	oldTables := make(map[string]string)
+	for _, r := range mRenames {
+		model := Registry.MustGet(r.newName)
+		oldTable := strutils.SnakeCase(r.oldName)
+		dbTables := adapter.tables()
+		if !dbTables[oldTable] || dbTables[model.tableName] {
+			continue
+		}
+		renameDBTable(oldTable, model.tableName)
+		oldTables[model.tableName] = oldTable
+		renamed = true
+	}
+	for _, r := range fRenames {
+		model, ok := Registry.Get(r.model)
+		if !ok {
+			log.Panic("Unknown model in RenameField", "model", r.model, "oldName", r.oldName, "newName", r.newName)
+		}
+		fi, ok := model.fields.Get(r.newName)
+		if !ok {
+			log.Panic("Unknown field in RenameField", "model", r.model, "oldName", r.oldName, "newName", r.newName)
+		}
+		if !fi.isStored() || fi.fieldType.IsNonStoredRelationType() {
+			log.Panic("Only fields stored in a column can be renamed", "model", r.model, "field", r.newName)
+		}
+		tableName := model.tableName
+		if oldTable, ok := oldTables[tableName]; ok && schemaMigration != nil {
+			// The table has not really been renamed yet
+			tableName = oldTable
+		}
+		oldColumn := SnakeCaseFieldName(r.oldName, fi.fieldType)
+		dbColumns := adapter.columns(tableName)
+		if _, ok := dbColumns[oldColumn]; !ok {
+			continue
+		}
+		if _, ok := dbColumns[fi.json]; ok {
+			continue
+		}
+		renameDBColumn(model.tableName, tableName, oldColumn, fi.json)
+		renamed = true
+	}
+	return renamed
+}
+
+// schemaRenames returns the model and field renames to apply: those declared
+// with RenameModel and RenameField, and those of the many2many relations of
+// the renamed models.
+func schemaRenames() ([]schemaRename, []schemaRename) {
+	mRenames := append([]schemaRename{}, modelRenames...)
+	var fRenames []schemaRename
+	declared := make(map[string]bool)
+	for _, r := range modelRenames {
+		if _, ok := Registry.Get(r.newName); !ok {
+			log.Panic("Unknown model in RenameModel", "oldName", r.oldName, "newName", r.newName)
+		}
+		declared[r.newName] = true
+	}
+	for _, r := range modelRenames {
+		relModels, relFields := m2mRelationRenames(r)
+		for _, rm := range relModels {
+			if declared[rm.newName] {
+				continue
+			}
+			mRenames = append(mRenames, rm)
+			declared[rm.newName] = true
+		}
+		fRenames = append(fRenames, relFields...)
+	}
+	return mRenames, append(fRenames, fieldRenames...)
+}
+
+// m2mRelationRenames returns the renames of the many2many relation models of
+// the model renamed by r, if they have the default name made of the names of
+// the related models, and of their fields named after the model.
+func m2mRelationRenames(r schemaRename) ([]schemaRename, []schemaRename) {
+	relations := make(map[string]*Field)
+	for _, model := range Registry.registryByName {
+		if model.IsMixin() {
+			continue
+		}
+		for _, fi := range model.fields.registryByName {
+			if fi.fieldType != fieldtype.Many2Many || fi.m2mRelModel == nil || fi.m2mRelModel.IsMixin() {
+				continue
+			}
+			if model.name != r.newName && fi.relatedModelName != r.newName {
+				continue
+			}
+			relations[fi.m2mRelModel.name] = fi
+		}
+	}
+	relNames := make([]string, 0, len(relations))
+	for relName := range relations {
+		relNames = append(relNames, relName)
+	}
+	sort.Strings(relNames)
+	oldName := func(name string) string {
+		if name == r.newName {
+			return r.oldName
+		}
+		return name
+	}
+	var relModels, relFields []schemaRename
+	for _, relName := range relNames {
+		fi := relations[relName]
+		if relName == defaultM2MRelModelName(fi.model.name, fi.relatedModelName) {
+			relModels = append(relModels, schemaRename{
+				oldName: defaultM2MRelModelName(oldName(fi.model.name), oldName(fi.relatedModelName)),
+				newName: relName,
+			})
+		}
+		for _, f := range []*Field{fi.m2mOurField, fi.m2mTheirField} {
+			if f.name == r.newName {
+				relFields = append(relFields, schemaRename{model: relName, oldName: r.oldName, newName: f.name})
+			}
+		}
+	}
+	return relModels, relFields
+}
+
+// defaultM2MRelModelName returns the name of the relation model of a many2many
+// field between the given models when it is not given in the field definition.
+func defaultM2MRelModelName(model1, model2 string) string {
+	modelNames := []string{model1, model2}
+	sort.Strings(modelNames)
+	return fmt.Sprintf("%s%sRel", modelNames[0], modelNames[1])
+}
+
+// renameDBTable renames the table oldTable of the database to newTable, as well as
+// its id sequence and the indexes and constraints named after it.
+func renameDBTable(oldTable, newTable string) {
+	adapter := adapters[db.DriverName()]
+	indexes := adapter.tableIndexes(oldTable)
+	constraints := adapter.tableConstraints(oldTable)
+	executeSchemaStatement(renameDBObjectSQL("TABLE", adapter.quoteTableName(oldTable), adapter.quoteTableName(newTable)),
+		renameDBObjectSQL("TABLE", adapter.quoteTableName(newTable), adapter.quoteTableName(oldTable)))
+	for _, seq := range adapter.sequences(oldTable + "_id_seq") {
+		if seq.Name != oldTable+"_id_seq" {
+			continue
+		}
+		executeSchemaStatement(renameDBObjectSQL("SEQUENCE", seq.Name, newTable+"_id_seq"),
+			renameDBObjectSQL("SEQUENCE", newTable+"_id_seq", seq.Name))
+	}
+	renamedObject := func(name string) string {
+		switch {
+		case strings.HasSuffix(name, fmt.Sprintf("_%s_mancon", oldTable)):
+			return fmt.Sprintf("%s_%s_mancon", strings.TrimSuffix(name, fmt.Sprintf("_%s_mancon", oldTable)), newTable)
+		case strings.HasPrefix(name, oldTable+"_"):
+			return newTable + strings.TrimPrefix(name, oldTable)
 		}
+		return ""
 	}
+	renameDBIndexesAndConstraints(newTable, indexes, constraints, renamedObject)
+}
+
+// renameDBColumn renames the column oldColumn of the given table to newColumn, as well as
+// the indexes and constraints named after it. currentTable is the name of the table in the
+// database, which differs from tableName if the table is being renamed in a migration.
+func renameDBColumn(tableName, currentTable, oldColumn, newColumn string) {
+	adapter := adapters[db.DriverName()]
+	// Indexes and constraints have already been renamed with the table, if any
+	withTableName := func(names []string) []string {
+		res := make([]string, len(names))
+		for i, name := range names {
+			res[i] = name
+			if strings.HasPrefix(name, currentTable+"_") {
+				res[i] = tableName + strings.TrimPrefix(name, currentTable)
+			}
+		}
+		return res
+	}
+	indexes := withTableName(adapter.tableIndexes(currentTable))
+	constraints := withTableName(adapter.tableConstraints(currentTable))
+	query := `
+		ALTER TABLE %s
+		RENAME COLUMN %s TO %s
+	`
+	executeSchemaStatement(fmt.Sprintf(query, adapter.quoteTableName(tableName), oldColumn, newColumn),
+		fmt.Sprintf(query, adapter.quoteTableName(tableName), newColumn, oldColumn))
+	renamedObject := func(name string) string {
+		for _, suffix := range []string{"fkey", "key", "index", "unaccent_index", "trgm_index"} {
+			if name == fmt.Sprintf("%s_%s_%s", tableName, oldColumn, suffix) {
+				return fmt.Sprintf("%s_%s_%s", tableName, newColumn, suffix)
+			}
+		}
+		return ""
+	}
+	renameDBIndexesAndConstraints(tableName, indexes, constraints, renamedObject)
+}
+
+// renameDBIndexesAndConstraints renames the given indexes and constraints of the
+// given table to the name returned by renamedObject. Objects for which renamedObject
+// returns an empty string are not renamed.
+func renameDBIndexesAndConstraints(tableName string, indexes, constraints []string, renamedObject func(string) string) {
+	adapter := adapters[db.DriverName()]
+	for _, index := range indexes {
+		if newName := renamedObject(index); newName != "" {
+			executeSchemaStatement(renameDBObjectSQL("INDEX", index, newName), renameDBObjectSQL("INDEX", newName, index))
+		}
+	}
+	query := `
+		ALTER TABLE %s
+		RENAME CONSTRAINT %s TO %s
+	`
+	for _, constraint := range constraints {
+		if newName := renamedObject(constraint); newName != "" {
+			executeSchemaStatement(fmt.Sprintf(query, adapter.quoteTableName(tableName), constraint, newName),
+				fmt.Sprintf(query, adapter.quoteTableName(tableName), newName, constraint))
+		}
+	}
+}
+
+// renameDBObjectSQL returns the SQL query to rename the database object
+// of the given kind (e.g. TABLE or INDEX) from oldName to newName.
+func renameDBObjectSQL(kind, oldName, newName string) string {
+	return fmt.Sprintf(`
+		ALTER %s %s RENAME TO %s
+	`, kind, oldName, newName)
 }
 
 // createDBTable creates a table in the database from the given Model
@@ -126,22 +417,27 @@ func createDBTable(m *Model) {
 		col := fmt.Sprintf("%s %s", colName, adapter.columnSQLDefinition(fi, false))
 		columns = append(columns, col)
 	}
+	idKey := "PRIMARY KEY"
+	if m.HasUUIDKey() {
+		// The uuid column is the primary key
+		idKey = "UNIQUE"
+	}
 	query := fmt.Sprintf(`
 CREATE TABLE %s (
-	id serial NOT NULL PRIMARY KEY`,
-		adapter.quoteTableName(m.tableName))
+	id serial NOT NULL %s`,
+		adapter.quoteTableName(m.tableName), idKey)
 	if len(columns) > 0 {
 		query += ",\n\t" + strings.Join(columns, ",\n\t")
 	}
 	query += "\n)"
-	dbExecuteNoTx(query)
+	executeSchemaStatement(query, fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(m.tableName)))
 }
 
 // dropDBTable drops the given table in the database
 func dropDBTable(tableName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
-	dbExecuteNoTx(query)
+	executeSchemaStatement(query, "")
 }
 
 // updateDBColumns synchronizes the colums of the database with the
@@ -157,10 +453,13 @@ func updateDBColumns(mi *Model) {
 		dbColData, ok := dbColumns[colName]
 		if !ok {
 			createDBColumn(fi)
+			if fi.isRecomputable() && schemaMigration == nil {
+				newStoredFields = append(newStoredFields, fi)
+			}
 			continue
 		}
 		if dbColData.DataType != adapter.typeSQL(fi) {
-			updateDBColumnDataType(fi)
+			updateDBColumnDataType(fi, dbColData.DataType)
 		}
 		if (dbColData.IsNullable == "NO" && !adapter.fieldIsNotNull(fi)) ||
 			(dbColData.IsNullable == "YES" && adapter.fieldIsNotNull(fi)) {
@@ -168,9 +467,9 @@ func updateDBColumns(mi *Model) {
 		}
 	}
 	// drop columns that no longer exist
-	for colName := range dbColumns {
+	for colName, dbColData := range dbColumns {
 		if _, ok := mi.fields.registryByJSON[colName]; !ok {
-			dropDBColumn(mi.tableName, colName)
+			dropDBColumn(mi.tableName, colName, dbColData.DataType)
 		}
 	}
 }
@@ -186,7 +485,11 @@ func createDBColumn(fi *Field) {
 		ALTER TABLE %s
 		ADD COLUMN %s %s
 	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi, true))
-	dbExecuteNoTx(query)
+	down := fmt.Sprintf(`
+		ALTER TABLE %s
+		DROP COLUMN %s
+	`, adapter.quoteTableName(fi.model.tableName), fi.json)
+	executeSchemaStatement(query, down)
 	// Set default value if defined
 	if fi.defaultFunc != nil {
 		updateQuery := fmt.Sprintf(`
@@ -196,35 +499,40 @@ func createDBColumn(fi *Field) {
 		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
 			defaultValue = fi.defaultFunc(env)
 		})
-		dbExecuteNoTx(updateQuery, defaultValue)
+		executeSchemaStatement(updateQuery, "", defaultValue)
 	}
 	// Add not null if required
 	updateDBColumnNullable(fi)
 }
 
-// updateDBColumnDataType updates the data type in database for the given Field
-func updateDBColumnDataType(fi *Field) {
+// updateDBColumnDataType updates the data type in database for the given Field.
+// oldType is the data type of the column before the update.
+func updateDBColumnDataType(fi *Field, oldType string) {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
+	query := `
 		ALTER TABLE %s
 		ALTER COLUMN %s SET DATA TYPE %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi))
-	dbExecuteNoTx(query)
+	`
+	executeSchemaStatement(fmt.Sprintf(query, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi)),
+		fmt.Sprintf(query, adapter.quoteTableName(fi.model.tableName), fi.json, oldType))
 }
 
 // updateDBColumnNullable updates the NULL/NOT NULL data in database for the given Field
 func updateDBColumnNullable(fi *Field) {
 	adapter := adapters[db.DriverName()]
-	var verb string
+	verb, downVerb := "DROP", "SET"
 	if adapter.fieldIsNotNull(fi) {
-		verb = "SET"
-	} else {
-		verb = "DROP"
+		verb, downVerb = "SET", "DROP"
 	}
-	query := fmt.Sprintf(`
+	queryTmpl := `
 		ALTER TABLE %s
 		ALTER COLUMN %s %s NOT NULL
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
+	`
+	query := fmt.Sprintf(queryTmpl, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
+	if schemaMigration != nil {
+		executeSchemaStatement(query, fmt.Sprintf(queryTmpl, adapter.quoteTableName(fi.model.tableName), fi.json, downVerb))
+		return
+	}
 	query, _ = sanitizeQuery(query)
 	_, err := db.Exec(query)
 	if err != nil {
@@ -232,23 +540,31 @@ func updateDBColumnNullable(fi *Field) {
 	}
 }
 
-// dropDBColumn drops the column colName from table tableName in database
-func dropDBColumn(tableName, colName string) {
+// dropDBColumn drops the column colName from table tableName in database.
+// dataType is the data type of the column.
+func dropDBColumn(tableName, colName, dataType string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		DROP COLUMN %s
 	`, adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
+	down := fmt.Sprintf(`
+		ALTER TABLE %s
+		ADD COLUMN %s %s
+	`, adapter.quoteTableName(tableName), colName, dataType)
+	executeSchemaStatement(query, down)
 }
 
 // updateDBForeignKeyConstraints creates or updates fk constraints
-// based on the data of the given Model
+// based on the data of the given Model.
+//
+// Fields pointing to a manual model get no constraint, since its table
+// may be a SQL view which cannot be referenced by a foreign key.
 func updateDBForeignKeyConstraints(m *Model) {
 	adapter := adapters[db.DriverName()]
 	for colName, fi := range m.fields.registryByJSON {
 		fkContraintInDB := adapter.constraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
-		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored()
+		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored() && !fi.relatedModel.IsManual()
 		switch {
 		case fieldIsFK && !fkContraintInDB:
 			createFKConstraint(m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
@@ -281,7 +597,7 @@ dbConLoop:
 // createFKConstraint creates an FK constraint for the given column that references the given targetTable
 func createFKConstraint(tableName, colName, targetTable, ondelete string) {
 	adapter := adapters[db.DriverName()]
-	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
+	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (id) ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
 	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
 }
 
@@ -296,7 +612,10 @@ func createConstraint(tableName, constraintName, sql string) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s ADD CONSTRAINT %s %s
 	`, adapter.quoteTableName(tableName), constraintName, sql)
-	dbExecuteNoTx(query)
+	down := fmt.Sprintf(`
+		ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s
+	`, adapter.quoteTableName(tableName), constraintName)
+	executeSchemaStatement(query, down)
 }
 
 // dropConstraint drops a constraint with the given name
@@ -305,7 +624,13 @@ func dropConstraint(tableName, constraintName string) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s
 	`, adapter.quoteTableName(tableName), constraintName)
-	dbExecuteNoTx(query)
+	var down string
+	if schemaMigration != nil && adapter.constraintExists(constraintName) {
+		down = fmt.Sprintf(`
+		ALTER TABLE %s ADD CONSTRAINT %s %s
+	`, adapter.quoteTableName(tableName), constraintName, adapter.constraintDefinition(constraintName))
+	}
+	executeSchemaStatement(query, down)
 }
 
 // updateDBIndexes creates or updates indexes based on the data of
@@ -320,24 +645,126 @@ func updateDBIndexes(m *Model) {
 		case indexInDB && !fi.index:
 			dropColumnIndex(m.tableName, colName)
 		}
+		unaccent := fi.unaccent && fi.isStored()
+		unaccentInDB := adapter.indexExists(m.tableName, fmt.Sprintf("%s_%s_unaccent_index", m.tableName, colName))
+		switch {
+		case unaccent && !unaccentInDB:
+			executeSchemaStatement(createUnaccentIndexSQL(m.tableName, colName), dropUnaccentIndexSQL(m.tableName, colName))
+		case unaccentInDB && !unaccent:
+			executeSchemaStatement(dropUnaccentIndexSQL(m.tableName, colName), createUnaccentIndexSQL(m.tableName, colName))
+		}
+		trigram := fi.trigram && fi.isStored()
+		trigramIndex := fmt.Sprintf("%s_%s_trgm_index", m.tableName, colName)
+		trigramInDB := adapter.indexExists(m.tableName, trigramIndex)
+		switch {
+		case trigram && !trigramInDB:
+			executeSchemaStatement(adapter.createTrigramIndexSQL(trigramIndex, m.tableName, colName),
+				dropIndexSQL(trigramIndex))
+		case trigramInDB && !trigram:
+			executeSchemaStatement(dropIndexSQL(trigramIndex),
+				adapter.createTrigramIndexSQL(trigramIndex, m.tableName, colName))
+		}
+		jsonIndex := fi.jsonIndex && fi.isStored()
+		jsonIndexName := fmt.Sprintf("%s_%s_json_index", m.tableName, colName)
+		jsonIndexInDB := adapter.indexExists(m.tableName, jsonIndexName)
+		switch {
+		case jsonIndex && !jsonIndexInDB:
+			executeSchemaStatement(adapter.createJSONIndexSQL(jsonIndexName, m.tableName, colName),
+				dropIndexSQL(jsonIndexName))
+		case jsonIndexInDB && !jsonIndex:
+			executeSchemaStatement(dropIndexSQL(jsonIndexName),
+				adapter.createJSONIndexSQL(jsonIndexName, m.tableName, colName))
+		}
 	}
 }
 
+// updateDBUnaccentFunction creates the function used by unaccent indexes
+// in the database if a field of the registry needs it.
+func updateDBUnaccentFunction() {
+	adapter := adapters[db.DriverName()]
+	for _, model := range Registry.registryByTableName {
+		if model.IsMixin() || model.IsManual() {
+			continue
+		}
+		for _, fi := range model.fields.registryByJSON {
+			if !fi.unaccent || !fi.isStored() {
+				continue
+			}
+			if !adapter.unaccentFunctionExists() {
+				executeSchemaStatement(adapter.createUnaccentFunctionSQL(), "")
+			}
+			return
+		}
+	}
+}
+
+// updateDBTrigramExtension installs the extension used by trigram indexes
+// and similarity searches in the database if a field of the registry needs it.
+func updateDBTrigramExtension() {
+	adapter := adapters[db.DriverName()]
+	for _, model := range Registry.registryByTableName {
+		if model.IsMixin() || model.IsManual() {
+			continue
+		}
+		for _, fi := range model.fields.registryByJSON {
+			if !fi.trigram || !fi.isStored() {
+				continue
+			}
+			if !adapter.trigramExtensionExists() {
+				executeSchemaStatement(adapter.createTrigramExtensionSQL(), "")
+			}
+			return
+		}
+	}
+}
+
+// createUnaccentIndexSQL returns the SQL query to create an index on the
+// unaccented values of colName in the given table
+func createUnaccentIndexSQL(tableName, colName string) string {
+	adapter := adapters[db.DriverName()]
+	return fmt.Sprintf(`
+		CREATE INDEX %s ON %s (%s)
+	`, fmt.Sprintf("%s_%s_unaccent_index", tableName, colName), adapter.quoteTableName(tableName), adapter.unaccentSQL(colName))
+}
+
+// dropUnaccentIndexSQL returns the SQL query to drop the index on the
+// unaccented values of colName in the given table
+func dropUnaccentIndexSQL(tableName, colName string) string {
+	return fmt.Sprintf(`
+		DROP INDEX IF EXISTS %s
+	`, fmt.Sprintf("%s_%s_unaccent_index", tableName, colName))
+}
+
+// dropIndexSQL returns the SQL query to drop the given index
+func dropIndexSQL(indexName string) string {
+	return fmt.Sprintf(`
+		DROP INDEX IF EXISTS %s
+	`, indexName)
+}
+
 // createColumnIndex creates an column index for colName in the given table
 func createColumnIndex(tableName, colName string) {
+	executeSchemaStatement(createColumnIndexSQL(tableName, colName), dropColumnIndexSQL(tableName, colName))
+}
+
+// createColumnIndexSQL returns the SQL query to create a column index for colName in the given table
+func createColumnIndexSQL(tableName, colName string) string {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`
+	return fmt.Sprintf(`
 		CREATE INDEX %s ON %s (%s)
 	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
-	dbExecuteNoTx(query)
 }
 
 // dropColumnIndex drops a column index for colName in the given table
 func dropColumnIndex(tableName, colName string) {
-	query := fmt.Sprintf(`
+	executeSchemaStatement(dropColumnIndexSQL(tableName, colName), createColumnIndexSQL(tableName, colName))
+}
+
+// dropColumnIndexSQL returns the SQL query to drop a column index for colName in the given table
+func dropColumnIndexSQL(tableName, colName string) string {
+	return fmt.Sprintf(`
 		DROP INDEX IF EXISTS %s
 	`, fmt.Sprintf("%s_%s_index", tableName, colName))
-	dbExecuteNoTx(query)
 }
 
 // runInit runs the Init function of the given model if it exists
@@ -0,0 +1,68 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// A DatePart is a part of a date or datetime value, such as its month or its
+// weekday, which can be compared to integers in conditions with
+// ConditionField.DatePart.
+type DatePart string
+
+// Available date parts
+const (
+	DatePartYear    DatePart = "year"
+	DatePartQuarter DatePart = "quarter"
+	DatePartMonth   DatePart = "month"
+	DatePartDay     DatePart = "day"
+	// DatePartWeekday is the day of the week, from 0 for Sunday to 6 for
+	// Saturday, as time.Weekday.
+	DatePartWeekday DatePart = "weekday"
+	// DatePartHour can only be extracted from datetime fields.
+	DatePartHour DatePart = "hour"
+)
+
+// isValid returns true if this DatePart is one of the available date parts.
+func (dp DatePart) isValid() bool {
+	switch dp {
+	case DatePartYear, DatePartQuarter, DatePartMonth, DatePartDay, DatePartWeekday, DatePartHour:
+		return true
+	}
+	return false
+}
+
+// DatePart returns a ConditionField on the given part of the current date
+// or datetime field, to be compared to integers, e.g.
+// Field(CreateDate).DatePart(DatePartMonth).Equals(12).
+//
+// Parts of datetime fields are extracted in the timezone of the user of the
+// searching Environment (see Environment.Location).
+func (c ConditionField) DatePart(part DatePart) *ConditionField {
+	if !part.isValid() {
+		log.Panic("Unknown date part", "part", part, "field", c.Name())
+	}
+	c.datePart = part
+	return &c
+}
+
+// datePartSQL returns the SQL expression of the given part of the
+// given field SQL expression, after checking the type of the field.
+func (q *Query) datePartSQL(field string, fi *Field, part DatePart) string {
+	var tz string
+	switch {
+	case fi.fieldType == fieldtype.DateTime:
+		if loc := q.recordSet.Env().Location(); loc != time.UTC {
+			tz = loc.String()
+		}
+	case fi.fieldType == fieldtype.Date && part != DatePartHour:
+	default:
+		log.Panic("Date part cannot be extracted from this field", "model", fi.model.name,
+			"field", fi.name, "type", fi.fieldType, "part", part)
+	}
+	return adapters[db.DriverName()].datePartSQL(part, field, tz)
+}
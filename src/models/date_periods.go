@@ -0,0 +1,132 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// A DatePeriod is a range of days, such as "today" or "this month", to be
+// used in conditions on date and datetime fields with InPeriod, BeforePeriod
+// and AfterPeriod.
+//
+// The days of a DatePeriod are computed when the query is executed, in the
+// timezone of the user of the searching Environment (see Environment.Location).
+type DatePeriod struct {
+	// bounds returns the first day of the period and the day after
+	// its last day, given the searching Environment and the current day.
+	bounds func(env Environment, today time.Time) (time.Time, time.Time)
+}
+
+// DateToday returns the DatePeriod of the current day.
+func DateToday() DatePeriod {
+	return DatePeriod{bounds: func(_ Environment, today time.Time) (time.Time, time.Time) {
+		return today, today.AddDate(0, 0, 1)
+	}}
+}
+
+// DateThisWeek returns the DatePeriod of the current week.
+// Weeks start on Monday.
+func DateThisWeek() DatePeriod {
+	return DatePeriod{bounds: func(_ Environment, today time.Time) (time.Time, time.Time) {
+		start := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	}}
+}
+
+// DateThisMonth returns the DatePeriod of the current month.
+func DateThisMonth() DatePeriod {
+	return DatePeriod{bounds: func(_ Environment, today time.Time) (time.Time, time.Time) {
+		start := today.AddDate(0, 0, 1-today.Day())
+		return start, start.AddDate(0, 1, 0)
+	}}
+}
+
+// DateThisYear returns the DatePeriod of the current year.
+func DateThisYear() DatePeriod {
+	return DatePeriod{bounds: func(_ Environment, today time.Time) (time.Time, time.Time) {
+		start := today.AddDate(0, 1-int(today.Month()), 1-today.Day())
+		return start, start.AddDate(1, 0, 0)
+	}}
+}
+
+// DateRange returns the DatePeriod from the start date to
+// the end date, both included.
+func DateRange(start, end dates.Date) DatePeriod {
+	return DatePeriod{bounds: func(Environment, time.Time) (time.Time, time.Time) {
+		return calendarDay(start.Time), calendarDay(end.Time).AddDate(0, 0, 1)
+	}}
+}
+
+// calendarDay returns the UTC midnight time of the day of t.
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// boundArg returns a condition argument function that evaluates to the start
+// (or end if end is true) of this DatePeriod for the field at the given path.
+//
+// Date fields are compared to the days of the period. Datetime fields are
+// compared to the UTC times of the midnights of these days in the timezone
+// of the user.
+func (dp DatePeriod) boundArg(exprs []FieldName, end bool) func(RecordSet) interface{} {
+	return func(rs RecordSet) interface{} {
+		rc := rs.Collection()
+		loc := rc.Env().Location()
+		start, stop := dp.bounds(rc.Env(), calendarDay(rc.Env().Now().In(loc).Time))
+		day := start
+		if end {
+			day = stop
+		}
+		fi := rc.model.getRelatedFieldInfo(joinFieldNames(exprs, ExprSep))
+		switch fi.fieldType {
+		case fieldtype.Date:
+			return dates.Date{Time: day}
+		case fieldtype.DateTime:
+			return dates.DateTime{Time: time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)}.UTC()
+		}
+		log.Panic("Date periods can only be used on date or datetime fields", "model", rc.model.name,
+			"field", joinFieldNames(exprs, ExprSep))
+		return nil
+	}
+}
+
+// periodCondition returns a bracketed condition made of the given
+// condition on this ConditionField.
+func (c ConditionField) periodCondition(cond *Condition) *Condition {
+	res := c.cs.cond
+	res.predicates = append(res.predicates, predicate{
+		cond:   cond,
+		isCond: true,
+		isNot:  c.cs.nextIsNot,
+		isOr:   c.cs.nextIsOr,
+	})
+	return &res
+}
+
+// InPeriod appends a condition which is true if the current date or datetime
+// field is within the given DatePeriod, e.g. Field(CreateDate).InPeriod(DateToday()).
+func (c ConditionField) InPeriod(period DatePeriod) *Condition {
+	field := joinFieldNames(c.exprs, ExprSep)
+	return c.periodCondition(newCondition().And().Field(field).GreaterOrEqual(period.boundArg(c.exprs, false)).
+		And().Field(field).Lower(period.boundArg(c.exprs, true)))
+}
+
+// BeforePeriod appends a condition which is true if the current date or datetime
+// field is before the start of the given DatePeriod, e.g. Field(DueDate).BeforePeriod(DateToday())
+// for overdue records.
+func (c ConditionField) BeforePeriod(period DatePeriod) *Condition {
+	return c.periodCondition(newCondition().And().Field(joinFieldNames(c.exprs, ExprSep)).
+		Lower(period.boundArg(c.exprs, false)))
+}
+
+// AfterPeriod appends a condition which is true if the current date or datetime
+// field is after the end of the given DatePeriod.
+func (c ConditionField) AfterPeriod(period DatePeriod) *Condition {
+	return c.periodCondition(newCondition().And().Field(joinFieldNames(c.exprs, ExprSep)).
+		GreaterOrEqual(period.boundArg(c.exprs, true)))
+}
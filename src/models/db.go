@@ -88,17 +88,32 @@ type dbAdapter interface {
 	indexExists(table string, name string) bool
 	// constraintExists returns true if a constraint with the given name exists
 	constraintExists(name string) bool
+	// constraintDefinition returns the SQL definition of the constraint with the given name
+	constraintDefinition(name string) string
 	// constraints returns a list of all constraints matching the given SQL pattern
 	constraints(pattern string) []string
+	// tableConstraints returns the names of the constraints of the given table
+	// which are not backed by an index, such as foreign keys.
+	tableConstraints(table string) []string
+	// tableIndexes returns the names of the indexes of the given table
+	tableIndexes(table string) []string
+	// quoteLiteral returns the given value as an SQL literal to be inserted in a query
+	quoteLiteral(value interface{}) string
 	// setTransactionIsolation returns the SQL string to set the transaction isolation
-	// level to serializable
-	setTransactionIsolation() string
+	// level to the given level
+	setTransactionIsolation(level IsolationLevel) string
 	// createSequence creates a DB sequence with the given name
 	createSequence(name string, increment, start int64)
+	// createSequenceSQL returns the SQL query to create a DB sequence with the given name
+	createSequenceSQL(name string, increment, start int64) string
 	// dropSequence drop the DB sequence with the given name
 	dropSequence(name string)
+	// dropSequenceSQL returns the SQL query to drop the DB sequence with the given name
+	dropSequenceSQL(name string) string
 	// alterSequence modifies the DB sequence given by name
 	alterSequence(name string, increment, restart int64)
+	// alterSequenceSQL returns the SQL query to modify the DB sequence given by name
+	alterSequenceSQL(name string, increment, restart int64) string
 	// nextSequenceValue returns the next value of the given given sequence
 	nextSequenceValue(name string) int64
 	// sequences returns a list of all sequences matching the given SQL pattern
@@ -107,11 +122,90 @@ type dbAdapter interface {
 	// a record from table including itself. The query has a placeholder for the
 	// record's ID
 	childrenIdsQuery(table string) string
-	// substituteErrorMessage substitutes the given error's message by newMsg
-	substituteErrorMessage(err error, newMsg string) error
+	// tableSampleSQL returns the SQL clause that samples about percent % of the
+	// rows of a table with the given method. Adapters of databases that have no
+	// support for it return an empty string.
+	tableSampleSQL(method SampleMethod, percent float64) string
+	// plannerHintsSQL returns the prefix of a query that gives the given hints
+	// to the query planner. Adapters of databases that have no support for
+	// hints return an empty string.
+	plannerHintsSQL(hints []string) string
+	// createMaterializedViewSQL returns the SQL query to create the materialized
+	// view with the given name from query, with its data.
+	createMaterializedViewSQL(name, query string) string
+	// dropMaterializedViewSQL returns the SQL query to drop the materialized
+	// view with the given name if it exists.
+	dropMaterializedViewSQL(name string) string
+	// commentMaterializedViewSQL returns the SQL query to set the given
+	// comment on the materialized view with the given name.
+	commentMaterializedViewSQL(name, comment string) string
+	// materializedViewComment returns the comment of the materialized view
+	// with the given name and true, or false if there is no such view.
+	materializedViewComment(name string) (string, bool)
+	// dependentViews returns the names of the views and materialized views
+	// whose query reads the table or view with the given name.
+	dependentViews(name string) []string
+	// refreshMaterializedViewSQL returns the SQL query to refresh the data of
+	// the materialized view with the given name.
+	refreshMaterializedViewSQL(name string, concurrently bool) string
+	// hasUniqueIndex returns true if the given table or materialized view
+	// has at least one unique index.
+	hasUniqueIndex(table string) bool
 	// isSerializationError returns true if the given error is a serialization error
 	// and that the failed transaction should be retried.
 	isSerializationError(err error) bool
+	// readOnlyTransactionQuery returns the SQL query that returns
+	// true if the current transaction is read only.
+	readOnlyTransactionQuery() string
+	// unaccentSQL returns the given SQL expression with accents removed.
+	// Adapters of databases that have no support for it return expr unchanged.
+	unaccentSQL(expr string) string
+	// unaccentFunctionExists returns true if the function used by unaccentSQL
+	// exists in the database.
+	unaccentFunctionExists() bool
+	// createUnaccentFunctionSQL returns the SQL query that creates the function
+	// used by unaccentSQL.
+	createUnaccentFunctionSQL() string
+	// similaritySQL returns the SQL expression of the trigram similarity between
+	// the expr and arg SQL expressions, as a number between 0 and 1. Adapters of
+	// databases that have no support for it return an empty string.
+	similaritySQL(expr, arg string) string
+	// positionSQL returns the SQL expression of the position of expr in the
+	// given ids, starting at 1, or NULL if expr is not one of them.
+	positionSQL(expr string, ids []int64) string
+	// binarySizeSQL returns the SQL expression of the length in bytes
+	// of the value of the given binary column expression.
+	binarySizeSQL(expr string) string
+	// binaryChunkSQL returns the SQL expression of the part of the value of
+	// the given binary column expression starting at the position given as
+	// first argument, starting at 1, with the length given as second argument.
+	binaryChunkSQL(expr string) string
+	// totalCountSQL returns the SQL expression of the number of rows of a query
+	// before its LIMIT and OFFSET clauses are applied, to be selected along
+	// with its rows. Adapters of databases that have no support for it return
+	// an empty string.
+	totalCountSQL() string
+	// datePartSQL returns the SQL expression of the given part of the given
+	// date or timestamp expression. Timestamps are converted from UTC to the
+	// given timezone first, unless it is empty.
+	datePartSQL(part DatePart, expr, tz string) string
+	// trigramExtensionExists returns true if the extension used by
+	// similaritySQL and trigram indexes is installed in the database.
+	trigramExtensionExists() bool
+	// createTrigramExtensionSQL returns the SQL query that installs the
+	// extension used by similaritySQL and trigram indexes.
+	createTrigramExtensionSQL() string
+	// createTrigramIndexSQL returns the SQL query to create the given
+	// trigram index on colName in the given table.
+	createTrigramIndexSQL(indexName, tableName, colName string) string
+	// jsonContainsSQL returns the SQL condition that the JSON value of the
+	// expr text expression contains the JSON value of the arg SQL expression.
+	// Empty values contain nothing. Adapters of databases that have no support
+	// for it return an empty string.
+	jsonContainsSQL(expr, arg string) string
+	// createJSONIndexSQL returns the SQL query to create the given index
+	// for jsonContainsSQL conditions on colName in the given table.
+	createJSONIndexSQL(indexName, tableName, colName string) string
 }
 
 // registerDBAdapter adds a adapter to the adapters registry
@@ -121,36 +215,80 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 }
 
 // Cursor is a wrapper around a database transaction
+//
+// used is true once a query has been executed in the transaction,
+// after which its isolation level cannot be changed anymore.
+// readOnly caches whether the transaction is read only once it has been
+// queried by isReadOnly.
+// counter counts the executed queries against the query budget.
 type Cursor struct {
-	tx *sqlx.Tx
+	tx           *sqlx.Tx
+	isolation    IsolationLevel
+	readOnly     *bool
+	used         bool
+	postCommit   []func()
+	postRollback []func()
+	counter      *queryCounter
 }
 
 // Execute a query without returning any rows. It panics in case of error.
 // The args are for any placeholder parameters in the query.
 func (c *Cursor) Execute(query string, args ...interface{}) sql.Result {
+	c.used = true
+	c.counter.add(query)
 	return dbExecute(c.tx, query, args...)
 }
 
 // Get queries a row into the database and maps the result into dest.
 // The query must return only one row. Get panics on errors
 func (c *Cursor) Get(dest interface{}, query string, args ...interface{}) {
+	c.used = true
+	c.counter.add(query)
 	dbGet(c.tx, dest, query, args...)
 }
 
 // Select queries multiple rows and map the result into dest which must be a slice.
 // Select panics on errors.
 func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
+	c.used = true
+	c.counter.add(query)
 	dbSelect(c.tx, dest, query, args...)
 }
 
+// query executes the given query and returns the resulting rows.
+// It panics in case of error.
+func (c *Cursor) query(query string, args ...interface{}) *sqlx.Rows {
+	c.used = true
+	c.counter.add(query)
+	return dbQuery(c.tx, query, args...)
+}
+
+// setIsolation sets the isolation level of the transaction of this Cursor.
+func (c *Cursor) setIsolation(level IsolationLevel) {
+	dbExecute(c.tx, adapters[db.DriverName()].setTransactionIsolation(level))
+	c.isolation = level
+}
+
+// isReadOnly returns true if the transaction of this Cursor is read only,
+// for instance because the database is a hot standby. The database is
+// only queried the first time in the transaction.
+func (c *Cursor) isReadOnly() bool {
+	if c.readOnly == nil {
+		var readOnly bool
+		c.Get(&readOnly, adapters[db.DriverName()].readOnlyTransactionQuery())
+		c.readOnly = &readOnly
+	}
+	return *c.readOnly
+}
+
 // newCursor returns a new db cursor on the given database
 func newCursor(db *sqlx.DB) *Cursor {
-	adapter := adapters[db.DriverName()]
-	tx := db.MustBegin()
-	dbExecute(tx, adapter.setTransactionIsolation())
-	return &Cursor{
-		tx: tx,
+	cr := &Cursor{
+		tx:      db.MustBegin(),
+		counter: newQueryCounter(),
 	}
+	cr.setIsolation(Serializable)
+	return cr
 }
 
 // DBParams returns the DB connection parameters currently in use
@@ -15,7 +15,11 @@
 package models
 
 import (
+	"database/sql/driver"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
@@ -100,6 +104,9 @@ func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (st
 
 // typeSQL returns the sql type string for the given Field
 func (d *postgresAdapter) typeSQL(fi *Field) string {
+	if fi.uuidKey {
+		return "uuid"
+	}
 	typ, _ := pgTypes[fi.fieldType]
 	return typ
 }
@@ -108,6 +115,9 @@ func (d *postgresAdapter) typeSQL(fi *Field) string {
 //
 // If null is true, then the column will be nullable, whatever the field defines
 func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
+	if fi.uuidKey {
+		return d.uuidKeySQLDefinition(null)
+	}
 	var res string
 	typ, ok := pgTypes[fi.fieldType]
 	res = typ
@@ -135,6 +145,17 @@ func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
 	return res
 }
 
+// uuidKeySQLDefinition returns the SQL definition of the uuid primary key
+// column of a UUID model, with a generated default value. If null is true,
+// the column is only unique and nullable, since it is added to an existing
+// table which already has a primary key.
+func (d *postgresAdapter) uuidKeySQLDefinition(null bool) string {
+	if null {
+		return "uuid DEFAULT gen_random_uuid() UNIQUE"
+	}
+	return "uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY"
+}
+
 // fieldIsNull returns true if the given Field results in a
 // NOT NULL column in database.
 func (d *postgresAdapter) fieldIsNotNull(fi *Field) bool {
@@ -197,6 +218,56 @@ func (d *postgresAdapter) constraintExists(name string) bool {
 	return cnt > 0
 }
 
+// constraintDefinition returns the SQL definition of the constraint with the given name
+func (d *postgresAdapter) constraintDefinition(name string) string {
+	query := "SELECT pg_get_constraintdef(oid) FROM pg_constraint WHERE conname = ?"
+	var res string
+	dbGetNoTx(&res, query, name)
+	return res
+}
+
+// quoteLiteral returns the given value as an SQL literal to be inserted in a query
+func (d *postgresAdapter) quoteLiteral(value interface{}) string {
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		value, err = valuer.Value()
+		if err != nil {
+			log.Panic("Unable to get SQL value", "value", value, "error", err)
+		}
+	}
+	switch val := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quoteStringLiteral(val)
+	case []byte:
+		return quoteStringLiteral(string(val))
+	case bool:
+		if val {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return pq.QuoteLiteral(val.Format(time.RFC3339Nano))
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// quoteStringLiteral returns the given string as an SQL string literal.
+//
+// Question marks are escaped in an escape string literal so that the result can be
+// inserted in a query which is later rebound without adding placeholders.
+func quoteStringLiteral(val string) string {
+	if !strings.Contains(val, "?") {
+		return pq.QuoteLiteral(val)
+	}
+	val = strings.Replace(val, `\`, `\\`, -1)
+	val = strings.Replace(val, `'`, `''`, -1)
+	val = strings.Replace(val, `?`, `\x3F`, -1)
+	return fmt.Sprintf("E'%s'", val)
+}
+
 // constraints returns a list of all constraints matching the given SQL pattern
 func (d *postgresAdapter) constraints(pattern string) []string {
 	query := "SELECT conname FROM pg_constraint WHERE conname ILIKE ?"
@@ -205,28 +276,64 @@ func (d *postgresAdapter) constraints(pattern string) []string {
 	return res
 }
 
+// tableConstraints returns the names of the constraints of the given table
+// which are not backed by an index, such as foreign keys.
+func (d *postgresAdapter) tableConstraints(table string) []string {
+	query := `
+		SELECT c.conname FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		WHERE t.relname = ? AND c.contype NOT IN ('p', 'u', 'x')
+	`
+	var res []string
+	dbSelectNoTx(&res, query, table)
+	return res
+}
+
+// tableIndexes returns the names of the indexes of the given table
+func (d *postgresAdapter) tableIndexes(table string) []string {
+	query := "SELECT indexname FROM pg_indexes WHERE tablename = ?"
+	var res []string
+	dbSelectNoTx(&res, query, table)
+	return res
+}
+
 // createSequence creates a DB sequence with the given name
 func (d *postgresAdapter) createSequence(name string, increment, start int64) {
-	query := fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.createSequenceSQL(name, increment, start))
+}
+
+// createSequenceSQL returns the SQL query to create a DB sequence with the given name
+func (d *postgresAdapter) createSequenceSQL(name string, increment, start int64) string {
+	return fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
 }
 
 // dropSequence drops the DB sequence with the given name
 func (d *postgresAdapter) dropSequence(name string) {
-	query := fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
-	dbExecuteNoTx(query)
+	dbExecuteNoTx(d.dropSequenceSQL(name))
+}
+
+// dropSequenceSQL returns the SQL query to drop the DB sequence with the given name
+func (d *postgresAdapter) dropSequenceSQL(name string) string {
+	return fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
 }
 
 // alterSequence modifies the DB sequence given by name
 func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
+	dbExecuteNoTx(d.alterSequenceSQL(name, increment, restart))
+}
+
+// alterSequenceSQL returns the SQL query to modify the DB sequence given by name
+func (d *postgresAdapter) alterSequenceSQL(name string, increment, restart int64) string {
 	query := fmt.Sprintf(`ALTER SEQUENCE %s`, name)
 	if increment != 0 {
 		query += fmt.Sprintf(` INCREMENT BY %d`, increment)
 	}
 	if restart != 0 {
-		query += fmt.Sprintf(` RESTART WITH %d`, restart)
+		// START WITH is also set so that the start value of the sequence
+		// can be compared with the registry at the next synchronization.
+		query += fmt.Sprintf(` START WITH %d RESTART WITH %d`, restart, restart)
 	}
-	dbExecuteNoTx(query)
+	return query
 }
 
 // nextSequenceValue returns the next value of the given given sequence
@@ -246,9 +353,9 @@ func (d *postgresAdapter) sequences(pattern string) []seqData {
 }
 
 // setTransactionIsolation returns the SQL string to set the
-// transaction isolation level to serializable
-func (d *postgresAdapter) setTransactionIsolation() string {
-	return "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
+// transaction isolation level to the given level
+func (d *postgresAdapter) setTransactionIsolation(level IsolationLevel) string {
+	return fmt.Sprintf("SET TRANSACTION ISOLATION LEVEL %s", level)
 }
 
 // childrenIdsQuery returns a query that finds all descendant of the given
@@ -272,14 +379,225 @@ FROM    recursive_query_children_ids`, d.quoteTableName(table), d.quoteTableName
 	return res
 }
 
-// substituteErrorMessage substitutes the given error's message by newMsg
-func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error {
-	pgError, ok := err.(*pq.Error)
-	if !ok {
-		return err
+// readOnlyTransactionQuery returns the SQL query that returns
+// true if the current transaction is read only.
+func (d *postgresAdapter) readOnlyTransactionQuery() string {
+	return "SELECT current_setting('transaction_read_only') = 'on'"
+}
+
+// unaccentSQL returns the given SQL expression with accents removed.
+func (d *postgresAdapter) unaccentSQL(expr string) string {
+	return fmt.Sprintf("hexya_unaccent(%s)", expr)
+}
+
+// unaccentFunctionExists returns true if the function used by unaccentSQL
+// exists in the database.
+func (d *postgresAdapter) unaccentFunctionExists() bool {
+	var cnt int
+	dbGetNoTx(&cnt, "SELECT COUNT(*) FROM pg_proc WHERE proname = 'hexya_unaccent'")
+	return cnt > 0
+}
+
+// createUnaccentFunctionSQL returns the SQL query that creates the function
+// used by unaccentSQL.
+//
+// unaccent is wrapped in an immutable function so that it can be used in indexes.
+func (d *postgresAdapter) createUnaccentFunctionSQL() string {
+	return `
+		CREATE EXTENSION IF NOT EXISTS unaccent;
+		CREATE OR REPLACE FUNCTION hexya_unaccent(text) RETURNS text
+			LANGUAGE sql IMMUTABLE STRICT
+			AS $$ SELECT public.unaccent('public.unaccent', $1) $$
+	`
+}
+
+// similaritySQL returns the SQL expression of the trigram similarity between
+// the expr and arg SQL expressions.
+func (d *postgresAdapter) similaritySQL(expr, arg string) string {
+	return fmt.Sprintf("similarity(%s, %s)", expr, arg)
+}
+
+// positionSQL returns the SQL expression of the position of expr in the
+// given ids, starting at 1, or NULL if expr is not one of them.
+func (d *postgresAdapter) positionSQL(expr string, ids []int64) string {
+	idsStr := make([]string, len(ids))
+	for i, id := range ids {
+		idsStr[i] = strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("array_position(ARRAY[%s]::bigint[], %s)", strings.Join(idsStr, ", "), expr)
+}
+
+// binarySizeSQL returns the SQL expression of the length in bytes
+// of the value of the given binary column expression.
+func (d *postgresAdapter) binarySizeSQL(expr string) string {
+	return fmt.Sprintf("octet_length(%s)", expr)
+}
+
+// binaryChunkSQL returns the SQL expression of the part of the value of
+// the given binary column expression starting at the position given as
+// first argument, starting at 1, with the length given as second argument.
+func (d *postgresAdapter) binaryChunkSQL(expr string) string {
+	return fmt.Sprintf("substring(%s from ? for ?)", expr)
+}
+
+// totalCountSQL returns the SQL expression of the number of rows of a query
+// before its LIMIT and OFFSET clauses are applied, as a window function.
+func (d *postgresAdapter) totalCountSQL() string {
+	return "count(*) OVER ()"
+}
+
+// pgDateParts are the Postgres EXTRACT fields of the date parts
+var pgDateParts = map[DatePart]string{
+	DatePartYear:    "YEAR",
+	DatePartQuarter: "QUARTER",
+	DatePartMonth:   "MONTH",
+	DatePartDay:     "DAY",
+	DatePartWeekday: "DOW",
+	DatePartHour:    "HOUR",
+}
+
+// datePartSQL returns the SQL expression of the given part of the given
+// date or timestamp expression. Timestamps are converted from UTC to the
+// given timezone first, unless it is empty.
+func (d *postgresAdapter) datePartSQL(part DatePart, expr, tz string) string {
+	if tz != "" {
+		expr = fmt.Sprintf("(%s AT TIME ZONE 'UTC' AT TIME ZONE %s)", expr, d.quoteLiteral(tz))
 	}
-	pgError.Message = newMsg
-	return pgError
+	return fmt.Sprintf("EXTRACT(%s FROM %s)", pgDateParts[part], expr)
+}
+
+// trigramExtensionExists returns true if the pg_trgm extension
+// is installed in the database.
+func (d *postgresAdapter) trigramExtensionExists() bool {
+	var cnt int
+	dbGetNoTx(&cnt, "SELECT COUNT(*) FROM pg_extension WHERE extname = 'pg_trgm'")
+	return cnt > 0
+}
+
+// createTrigramExtensionSQL returns the SQL query that installs the pg_trgm extension.
+func (d *postgresAdapter) createTrigramExtensionSQL() string {
+	return `
+		CREATE EXTENSION IF NOT EXISTS pg_trgm
+	`
+}
+
+// createTrigramIndexSQL returns the SQL query to create the given
+// GIN trigram index on colName in the given table.
+func (d *postgresAdapter) createTrigramIndexSQL(indexName, tableName, colName string) string {
+	return fmt.Sprintf(`
+		CREATE INDEX %s ON %s USING gin (%s gin_trgm_ops)
+	`, indexName, d.quoteTableName(tableName), colName)
+}
+
+// jsonbSQL returns the SQL expression of the given text expression cast
+// to jsonb, empty strings being NULL.
+func (d *postgresAdapter) jsonbSQL(expr string) string {
+	return fmt.Sprintf("CAST(NULLIF(%s, '') AS jsonb)", expr)
+}
+
+// jsonContainsSQL returns the SQL condition that the JSON value of the
+// expr text expression contains the JSON value of the arg SQL expression.
+//
+// The @> operator is used rather than ?, which would be taken for a placeholder.
+func (d *postgresAdapter) jsonContainsSQL(expr, arg string) string {
+	return fmt.Sprintf("%s @> CAST(%s AS jsonb)", d.jsonbSQL(expr), arg)
+}
+
+// createJSONIndexSQL returns the SQL query to create the given GIN
+// index for jsonContainsSQL conditions on colName in the given table.
+func (d *postgresAdapter) createJSONIndexSQL(indexName, tableName, colName string) string {
+	return fmt.Sprintf(`
+		CREATE INDEX %s ON %s USING gin ((%s) jsonb_path_ops)
+	`, indexName, d.quoteTableName(tableName), d.jsonbSQL(colName))
+}
+
+// tableSampleSQL returns the SQL clause that samples about percent % of the
+// rows of a table with the given method.
+func (d *postgresAdapter) tableSampleSQL(method SampleMethod, percent float64) string {
+	return fmt.Sprintf("TABLESAMPLE %s (%g) ", method, percent)
+}
+
+// plannerHintsSQL returns the prefix of a query that gives the given
+// hints to the query planner, in the format of the pg_hint_plan extension.
+func (d *postgresAdapter) plannerHintsSQL(hints []string) string {
+	return fmt.Sprintf("/*+ %s */ ", strings.Join(hints, " "))
+}
+
+// createMaterializedViewSQL returns the SQL query to create the materialized
+// view with the given name from query, with its data.
+func (d *postgresAdapter) createMaterializedViewSQL(name, query string) string {
+	return fmt.Sprintf(`
+		CREATE MATERIALIZED VIEW %s AS (%s) WITH DATA
+	`, d.quoteTableName(name), query)
+}
+
+// dropMaterializedViewSQL returns the SQL query to drop the materialized
+// view with the given name if it exists.
+func (d *postgresAdapter) dropMaterializedViewSQL(name string) string {
+	return fmt.Sprintf(`
+		DROP MATERIALIZED VIEW IF EXISTS %s
+	`, d.quoteTableName(name))
+}
+
+// commentMaterializedViewSQL returns the SQL query to set the given
+// comment on the materialized view with the given name.
+func (d *postgresAdapter) commentMaterializedViewSQL(name, comment string) string {
+	return fmt.Sprintf(`
+		COMMENT ON MATERIALIZED VIEW %s IS %s
+	`, d.quoteTableName(name), d.quoteLiteral(comment))
+}
+
+// materializedViewComment returns the comment of the materialized view with
+// the given name in the current schema and true, or false if there is no such view.
+func (d *postgresAdapter) materializedViewComment(name string) (string, bool) {
+	query := `
+		SELECT COALESCE(obj_description(c.oid, 'pg_class'), '') FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relname = ? AND c.relkind = 'm'
+	`
+	var res []string
+	dbSelectNoTx(&res, query, name)
+	if len(res) == 0 {
+		return "", false
+	}
+	return res[0], true
+}
+
+// dependentViews returns the names of the views and materialized views
+// whose query reads the table or view with the given name in the current schema.
+func (d *postgresAdapter) dependentViews(name string) []string {
+	query := `
+		SELECT DISTINCT v.relname FROM pg_depend dep
+		JOIN pg_rewrite r ON r.oid = dep.objid
+		JOIN pg_class v ON v.oid = r.ev_class
+		JOIN pg_class t ON t.oid = dep.refobjid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE dep.classid = 'pg_rewrite'::regclass AND dep.refclassid = 'pg_class'::regclass
+			AND n.nspname = current_schema() AND t.relname = ? AND v.oid <> t.oid
+		ORDER BY v.relname
+	`
+	var res []string
+	dbSelectNoTx(&res, query, name)
+	return res
+}
+
+// refreshMaterializedViewSQL returns the SQL query to refresh the data of
+// the materialized view with the given name.
+func (d *postgresAdapter) refreshMaterializedViewSQL(name string, concurrently bool) string {
+	if concurrently {
+		return fmt.Sprintf("REFRESH MATERIALIZED VIEW CONCURRENTLY %s", d.quoteTableName(name))
+	}
+	return fmt.Sprintf("REFRESH MATERIALIZED VIEW %s", d.quoteTableName(name))
+}
+
+// hasUniqueIndex returns true if the given table or materialized view
+// of the current schema has at least one unique index.
+func (d *postgresAdapter) hasUniqueIndex(table string) bool {
+	query := `SELECT COUNT(*) FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = ? AND indexdef LIKE 'CREATE UNIQUE INDEX%'`
+	var cnt int
+	dbGetNoTx(&cnt, query, table)
+	return cnt > 0
 }
 
 // isSerializationError returns true if the given error is a serialization error
@@ -0,0 +1,122 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// recomputeSearchSize is the maximum number of ids searched in a single query
+// when applying deferred recomputes, to stay well below the maximum number of
+// parameters of a query.
+const recomputeSearchSize = 10000
+
+// A recomputeQueue holds the stored fields to recompute at the
+// end of a WithDeferredRecompute scope, in the order they were
+// first triggered.
+//
+// A queue is closed when its scope ends. Environments and RecordSets copied
+// within the scope still point to it, but they must then recompute directly.
+type recomputeQueue struct {
+	keys    []string
+	entries map[string]*deferredRecompute
+	closed  bool
+}
+
+// A deferredRecompute is a method or stored related field to apply
+// on all the records it has been triggered on.
+type deferredRecompute struct {
+	pair recomputePair
+	ids  []int64
+	seen map[int64]bool
+}
+
+// newRecomputeQueue returns a pointer to a new empty recomputeQueue.
+func newRecomputeQueue() *recomputeQueue {
+	return &recomputeQueue{
+		entries: make(map[string]*deferredRecompute),
+	}
+}
+
+// add queues the given recompute pairs, merging the records
+// of the pairs with the same model and method or related field.
+func (q *recomputeQueue) add(compPairs []recomputePair) {
+	for _, rp := range compPairs {
+		if rp.recs.IsEmpty() {
+			continue
+		}
+		key := rp.recs.model.name + "/" + rp.method
+		if rp.related != nil {
+			key = rp.recs.model.name + "/related/" + rp.related.name
+		}
+		entry, ok := q.entries[key]
+		if !ok {
+			entry = &deferredRecompute{pair: rp, seen: make(map[int64]bool)}
+			q.entries[key] = entry
+			q.keys = append(q.keys, key)
+		}
+		for _, id := range rp.recs.Ids() {
+			if entry.seen[id] {
+				continue
+			}
+			entry.seen[id] = true
+			entry.ids = append(entry.ids, id)
+		}
+	}
+}
+
+// WithDeferredRecompute executes fnct with an Environment in which the
+// recomputation of stored computed and stored related fields is deferred
+// until fnct returns, instead of being executed after each Create, Write
+// or Unlink.
+//
+// All the recomputations of the same field are then coalesced, so that each
+// field is computed only once per record, with its final dependencies. This
+// is meant for loops writing records one by one. Recomputations triggered by
+// the deferred ones are coalesced the same way until there are no more.
+//
+// Stored computed fields read within fnct may hold outdated values. Calls to
+// WithDeferredRecompute within fnct are merged into the outermost one. If
+// fnct panics, no recomputation is executed.
+//
+// Environments and RecordSets of fnct that are used after it returns
+// recompute stored fields directly again.
+func (env Environment) WithDeferredRecompute(fnct func(Environment)) {
+	if env.recomputes.isOpen() {
+		fnct(env)
+		return
+	}
+	env.recomputes = newRecomputeQueue()
+	defer func() {
+		env.recomputes.closed = true
+	}()
+	fnct(env)
+	env.flushRecomputes()
+}
+
+// isOpen returns true if this queue is not nil and not closed, that is if
+// recomputes must be queued in it.
+func (q *recomputeQueue) isOpen() bool {
+	return q != nil && !q.closed
+}
+
+// flushRecomputes executes the recomputations queued in this Environment,
+// which must be in a WithDeferredRecompute scope, until there are no more.
+func (env Environment) flushRecomputes() {
+	for len(env.recomputes.keys) > 0 {
+		pending := *env.recomputes
+		*env.recomputes = *newRecomputeQueue()
+		var compPairs []recomputePair
+		for _, key := range pending.keys {
+			entry := pending.entries[key]
+			// Records are searched again to skip those which have been unlinked
+			recs := entry.pair.recs
+			for start := 0; start < len(entry.ids); start += recomputeSearchSize {
+				end := start + recomputeSearchSize
+				if end > len(entry.ids) {
+					end = len(entry.ids)
+				}
+				chunk := recs.Env().Pool(recs.model.name).Search(recs.model.Field(ID).In(entry.ids[start:end])).Fetch()
+				compPairs = append(compPairs, recomputePair{recs: chunk, method: entry.pair.method, related: entry.pair.related})
+			}
+		}
+		applyRecomputePairs(compPairs)
+	}
+}
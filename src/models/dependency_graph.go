@@ -0,0 +1,171 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expensiveDependencyHops is the number of relations from which
+// following the path of a dependency is considered expensive.
+const expensiveDependencyHops = 2
+
+// A FieldDependencies describes when a stored computed or stored related field
+// is recomputed:
+// - TriggeredBy holds the fields whose modification recomputes this field
+// - Triggers holds the stored fields recomputed when this field is recomputed
+// - InCycle is true if recomputing this field eventually triggers itself
+// - Expensive is true if one of its triggers is an expensive dependency
+type FieldDependencies struct {
+	Model       string            `json:"model"`
+	Field       string            `json:"field"`
+	Related     bool              `json:"related"`
+	TriggeredBy []FieldDependency `json:"triggered_by"`
+	Triggers    []FieldDependency `json:"triggers"`
+	InCycle     bool              `json:"in_cycle"`
+	Expensive   bool              `json:"expensive"`
+}
+
+// A FieldDependency is an edge of the dependency graph of stored fields.
+//
+// Path is the path of field names from the model of the recomputed field to the
+// model of the triggering field, which is empty if both fields are on the same
+// record. A dependency is Expensive if finding the records to recompute follows
+// a one2many or many2many field, or at least two relations.
+type FieldDependency struct {
+	Model     string `json:"model"`
+	Field     string `json:"field"`
+	Path      string `json:"path,omitempty"`
+	Expensive bool   `json:"expensive,omitempty"`
+}
+
+// String returns the dependency as Model.Field, followed by its path if any.
+func (fd FieldDependency) String() string {
+	res := fmt.Sprintf("%s.%s", fd.Model, fd.Field)
+	if fd.Path != "" {
+		res += fmt.Sprintf(" (via %s)", fd.Path)
+	}
+	if fd.Expensive {
+		res += " [expensive]"
+	}
+	return res
+}
+
+// dependencyNode is a field of the dependency graph
+type dependencyNode struct {
+	model string
+	field string
+}
+
+// DependencyGraph returns the dependencies of all the stored computed and stored
+// related fields of the registry, sorted by model and field names.
+//
+// It is meant to understand why a write cascades into many recomputations and
+// must be called after bootstrap.
+func DependencyGraph() []FieldDependencies {
+	nodes := make(map[dependencyNode]*FieldDependencies)
+	edges := make(map[dependencyNode][]dependencyNode)
+	for _, model := range Registry.registryByTableName {
+		for _, fi := range model.fields.registryByJSON {
+			if !fi.stored || (!fi.isComputedField() && !fi.isStoredRelatedField()) {
+				continue
+			}
+			nodes[dependencyNode{model: model.name, field: fi.name}] = &FieldDependencies{
+				Model:   model.name,
+				Field:   fi.name,
+				Related: fi.isStoredRelatedField(),
+			}
+		}
+	}
+	for _, model := range Registry.registryByTableName {
+		for _, fi := range model.fields.registryByJSON {
+			source := dependencyNode{model: model.name, field: fi.name}
+			for _, dep := range fi.dependencies {
+				target := dependencyNode{model: dep.model.name, field: dep.fieldName}
+				targetDeps, ok := nodes[target]
+				if !ok {
+					continue
+				}
+				path, expensive := dependencyPath(dep.model, dep.path)
+				targetDeps.TriggeredBy = append(targetDeps.TriggeredBy, FieldDependency{
+					Model:     model.name,
+					Field:     fi.name,
+					Path:      path,
+					Expensive: expensive,
+				})
+				targetDeps.Expensive = targetDeps.Expensive || expensive
+				if sourceDeps, ok := nodes[source]; ok {
+					sourceDeps.Triggers = append(sourceDeps.Triggers, FieldDependency{
+						Model:     target.model,
+						Field:     target.field,
+						Path:      path,
+						Expensive: expensive,
+					})
+					edges[source] = append(edges[source], target)
+				}
+			}
+		}
+	}
+	res := make([]FieldDependencies, 0, len(nodes))
+	for node, deps := range nodes {
+		deps.InCycle = dependencyReaches(edges, node, node, make(map[dependencyNode]bool))
+		sortFieldDependencies(deps.TriggeredBy)
+		sortFieldDependencies(deps.Triggers)
+		res = append(res, *deps)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Model != res[j].Model {
+			return res[i].Model < res[j].Model
+		}
+		return res[i].Field < res[j].Field
+	})
+	return res
+}
+
+// dependencyPath returns the given JSON path from the given model with field
+// names, and true if following it is expensive.
+func dependencyPath(model *Model, jsonPath string) (string, bool) {
+	if jsonPath == "" {
+		return "", false
+	}
+	tokens := strings.Split(jsonPath, ExprSep)
+	names := make([]string, len(tokens))
+	expensive := len(tokens) >= expensiveDependencyHops
+	for i, token := range tokens {
+		fi := model.fields.MustGet(token)
+		names[i] = fi.name
+		if fi.fieldType.IsNonStoredRelationType() {
+			expensive = true
+		}
+		model = fi.relatedModel
+	}
+	return strings.Join(names, ExprSep), expensive
+}
+
+// dependencyReaches returns true if target can be reached from source by
+// following the given edges, visiting each node at most once.
+func dependencyReaches(edges map[dependencyNode][]dependencyNode, source, target dependencyNode, visited map[dependencyNode]bool) bool {
+	for _, next := range edges[source] {
+		if next == target {
+			return true
+		}
+		if visited[next] {
+			continue
+		}
+		visited[next] = true
+		if dependencyReaches(edges, next, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortFieldDependencies sorts the given dependencies by model, field and path.
+func sortFieldDependencies(deps []FieldDependency) {
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].String() < deps[j].String()
+	})
+}
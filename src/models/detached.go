@@ -0,0 +1,71 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+// NewDetachedEnvironment returns a new Environment for the given user
+// which is not bound to the database. It is meant to hold detached
+// RecordSets built from in-memory data with RecordCollection.Detached.
+//
+// Detached environments have no Cursor and cannot be committed.
+func NewDetachedEnvironment(uid int64) Environment {
+	return Environment{
+		uid:      uid,
+		context:  types.NewContext(),
+		cache:    newCache(),
+		detached: true,
+	}
+}
+
+// Detached returns a RecordSet of the model of this RecordCollection with
+// the records given by data, which must belong to a detached Environment
+// (see NewDetachedEnvironment). data keys may be field names or JSON names.
+// Records are given the id of their "id" key, or a negative id if it is not
+// set.
+//
+// The following operations are supported on detached RecordSets:
+//
+// - Reading field values with Get or First. Fields not given in data have
+// their zero value, and non stored computed fields are computed from the
+// detached records. Relation fields return the detached records with the
+// given ids, so that related records must be added to the same Environment
+// with Detached to be read.
+//
+// - Set operations, such as Union, Filtered, Sorted or Records, and
+// calling methods that only read fields.
+//
+// Searching, counting, creating, writing and deleting records panic.
+func (rc *RecordCollection) Detached(data ...FieldMap) *RecordCollection {
+	if !rc.env.detached {
+		log.Panic("Detached RecordSets can only be created in a detached Environment", "model", rc.model.name)
+	}
+	ids := make([]int64, len(data))
+	for i, fm := range data {
+		md := NewModelData(rc.model, fm)
+		rc.checkDataFields(md)
+		fMap := md.FieldMap
+		id, _ := nbutils.CastToInteger(fMap["id"])
+		if id == 0 {
+			rc.env.nextNegativeID--
+			id = rc.env.nextNegativeID
+		}
+		fMap["id"] = id
+		rc.model.convertValuesToFieldType(&fMap, false)
+		rc.env.cache.addRecord(rc.model, id, fMap, rc.query.ctxArgsSlug())
+		ids[i] = id
+	}
+	return newRecordCollection(rc.Env(), rc.model.name).withIds(ids)
+}
+
+// checkNotDetached panics if this RecordCollection belongs to a detached
+// Environment, in which the given operation cannot be executed.
+func (rc *RecordCollection) checkNotDetached(operation string) {
+	if rc.env.detached {
+		log.Panic("Operation not supported on detached RecordSets", "model", rc.model.name, "operation", operation)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// DisplayNames returns the display name given by NameGet of each record of
+// this RecordCollection, keyed by id.
+//
+// Display names are cached in the Environment by model, id and language of
+// the context, so that the name of a record referenced many times, such as
+// the partner of hundreds of order lines, is computed once per transaction.
+// The Name field of the records that are not in cache yet is loaded in a single
+// query before calling NameGet on each of them. The cache is cleared each time
+// records are modified in the database and when the Environment ends.
+func (rc *RecordCollection) DisplayNames() map[int64]string {
+	res := make(map[int64]string)
+	lang := rc.env.context.GetString("lang")
+	var missing []int64
+	for _, id := range rc.Ids() {
+		if name, ok := rc.env.cache.getDisplayName(rc.model, id, lang); ok {
+			res[id] = name
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return res
+	}
+	recs := rc.env.Pool(rc.model.name).withIds(missing)
+	if _, ok := rc.model.fields.Get("Name"); ok && !recs.hasNegIds {
+		recs.Load(rc.model.FieldName("Name"))
+	}
+	for _, rec := range recs.Records() {
+		id := rec.ids[0]
+		res[id] = rec.Call("NameGet").(string)
+		if id > 0 {
+			// Memory records are not cached since their modifications
+			// do not clear the cache.
+			rc.env.cache.setDisplayName(rc.model, id, lang, res[id])
+		}
+	}
+	return res
+}
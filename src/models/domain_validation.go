@@ -0,0 +1,128 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// ValidateDomain returns an error if the given condition, typically built from
+// a domain sent by a client, cannot be searched on the model of this
+// RecordCollection. It is meant to be called before running an untrusted
+// search, so that clients cannot probe restricted fields or make the query
+// translator panic with bad field names.
+//
+// Each field path of the condition, including those of the conditions on
+// related records, is checked:
+//
+// - All the fields of the path must exist and must be searchable, that is
+// stored, related to a searchable field, or one2many or many2many fields
+// which are not computed. All but the last must be relation fields.
+//
+// - The json_contains operator is only allowed on stored char or text fields
+// with the JSONIndex option.
+//
+// - The current user must be allowed to load the records of each model of the
+// path. Otherwise, an exceptions.AccessError is returned.
+//
+// Other errors are exceptions.ValidationError.
+func (rc *RecordCollection) ValidateDomain(cond *Condition) error {
+	return rc.validateCondition(cond, true)
+}
+
+// validateCondition returns an error if the given condition cannot be searched
+// on the model of this RecordCollection. The read access of the current user
+// to the models of the field paths is only checked if checkAccess is true.
+func (rc *RecordCollection) validateCondition(cond *Condition, checkAccess bool) error {
+	if cond == nil {
+		return nil
+	}
+	for _, p := range cond.predicates {
+		if p.isCond {
+			if err := rc.validateCondition(p.cond, checkAccess); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(p.exprs) == 0 {
+			continue
+		}
+		fi, err := rc.validateFieldPath(p.exprs, checkAccess)
+		if err != nil {
+			return err
+		}
+		if p.operator == operator.JSONContains && !fi.holdsJSON() {
+			return exceptions.ValidationError{
+				Message: rc.T("Operator %s cannot be used on field %s", p.operator, joinFieldNames(p.exprs, ExprSep).Name()),
+				Debug:   fmt.Sprintf("model: %s, field: %s, type: %s", fi.model.name, fi.name, fi.fieldType),
+			}
+		}
+		if p.subCond == nil {
+			continue
+		}
+		relRS := rc.env.Pool(fi.relatedModel.name)
+		if err := relRS.validateCondition(p.subCond, checkAccess); err != nil {
+			return err
+		}
+		if p.aggregate == nil {
+			continue
+		}
+		if aggFi, ok := fi.relatedModel.fields.Get(p.aggregate.field.JSON()); !ok || !aggFi.isStored() {
+			return rc.invalidFieldError(fi.relatedModel, p.aggregate.field.Name())
+		}
+	}
+	return nil
+}
+
+// validateFieldPath checks that the given field path can be searched from the
+// model of this RecordCollection and returns the Field of its last element.
+func (rc *RecordCollection) validateFieldPath(exprs []FieldName, checkAccess bool) (*Field, error) {
+	var fi *Field
+	model := rc.model
+	for i, expr := range exprs {
+		if checkAccess && !rc.env.Pool(model.name).CheckExecutionPermission(model.methods.MustGet("Load"), true) {
+			return nil, exceptions.AccessError{
+				Message: rc.T("You are not allowed to search on field %s", joinFieldNames(exprs, ExprSep).Name()),
+				Debug:   fmt.Sprintf("model: %s, path: %s, uid: %d", model.name, joinFieldNames(exprs, ExprSep).Name(), rc.env.uid),
+			}
+		}
+		var ok bool
+		fi, ok = model.fields.Get(expr.JSON())
+		if !ok || !fi.isSearchable() {
+			return nil, rc.invalidFieldError(model, expr.Name())
+		}
+		if i == len(exprs)-1 {
+			break
+		}
+		if fi.relatedModel == nil {
+			return nil, rc.invalidFieldError(model, joinFieldNames(exprs[:i+2], ExprSep).Name())
+		}
+		model = fi.relatedModel
+	}
+	return fi, nil
+}
+
+// invalidFieldError returns the ValidationError of a search on the given
+// field of model which does not exist or is not searchable.
+func (rc *RecordCollection) invalidFieldError(model *Model, field string) error {
+	return exceptions.ValidationError{
+		Message: rc.T("Invalid field %s in search: it does not exist or cannot be searched", field),
+		Debug:   fmt.Sprintf("model: %s, field: %s", model.name, field),
+	}
+}
+
+// isSearchable returns true if records can be searched on this field
+// in the database.
+func (f *Field) isSearchable() bool {
+	switch {
+	case f.isRelatedField() && !f.stored:
+		return f.model.getRelatedFieldInfo(f.relatedPath).isSearchable()
+	case f.isComputedField() && !f.stored:
+		return false
+	}
+	return true
+}
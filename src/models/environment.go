@@ -16,8 +16,13 @@ package models
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/logging"
 )
 
@@ -45,6 +50,8 @@ type Environment struct {
 	previousMethod *Method
 	recursions     uint8
 	nextNegativeID int64
+	detached       bool
+	recomputes     *recomputeQueue
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -62,22 +69,104 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
-// commit the transaction of this environment.
+// Now returns the current date and time with UTC timezone.
+//
+// Business code should read the current time with Now or Today, so that
+// it can be tested with a fake clock set with dates.SetClock.
+func (env Environment) Now() dates.DateTime {
+	return dates.Now()
+}
+
+// Today returns the current date.
+func (env Environment) Today() dates.Date {
+	return dates.Today()
+}
+
+// Location returns the timezone of the user of this Environment, as given
+// by the "tz" key of its context. It returns UTC if the context has no
+// timezone or if it is not a valid IANA timezone identifier.
+func (env Environment) Location() *time.Location {
+	tz := env.context.GetString("tz")
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := dates.LoadLocation(tz)
+	if err != nil {
+		log.Warn("Invalid timezone in context, using UTC", "tz", tz, "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// HasGroup returns true if the user of the Environment is a member of the group
+// with the given ID, either directly or through implied groups.
+//
+// It panics if there is no group with this ID.
+func (env Environment) HasGroup(groupID string) bool {
+	return security.Registry.HasGroup(env.uid, groupID)
+}
+
+// AfterCommit registers the given fnct to be executed once the transaction
+// of this Environment has been successfully committed.
+//
+// fnct is not executed if the transaction is rolled back, including when
+// it is retried after a serialization error. It must not access the
+// database through this Environment.
+func (env Environment) AfterCommit(fnct func()) {
+	env.cr.postCommit = append(env.cr.postCommit, fnct)
+}
+
+// AfterRollback registers the given fnct to be executed once the transaction
+// of this Environment has been rolled back.
+//
+// It must not access the database through this Environment.
+func (env Environment) AfterRollback(fnct func()) {
+	env.cr.postRollback = append(env.cr.postRollback, fnct)
+}
+
+// commit the transaction of this environment and executes
+// the functions registered with AfterCommit.
+//
+// If the transaction cannot be committed, the functions registered
+// with AfterRollback are executed instead and the error is returned.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
-func (env Environment) commit() {
-	env.Cr().tx.Commit()
+func (env Environment) commit() error {
+	if err := env.Cr().tx.Commit(); err != nil {
+		runTransactionHooks(env.cr.postRollback)
+		return err
+	}
+	runTransactionHooks(env.cr.postCommit)
+	return nil
 }
 
-// rollback the transaction of this environment.
+// rollback the transaction of this environment and executes
+// the functions registered with AfterRollback.
 //
 // WARNING: Do NOT call Rollback on Environment instances that you
 // did not create yourself with NewEnvironment. Just panic instead
 // for the framework to roll back automatically for you.
 func (env Environment) rollback() {
 	env.Cr().tx.Rollback()
+	runTransactionHooks(env.cr.postRollback)
+}
+
+// runTransactionHooks executes the given functions registered with
+// AfterCommit or AfterRollback. Panics in these functions are logged
+// and do not prevent the other functions from being executed.
+func runTransactionHooks(hooks []func()) {
+	for _, fnct := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Warn("Error in transaction hook", "error", r)
+				}
+			}()
+			fnct()
+		}()
+	}
 }
 
 // checkRecursion panics if the recursion depth limit is reached
@@ -135,8 +224,9 @@ func newEnvironment(uid int64) Environment {
 //
 // This function commits the transaction if everything went right or
 // rolls it back otherwise, returning an arror. Database serialization
-// errors are automatically retried several times before returning an
-// error if they still occur.
+// errors and deadlocks are automatically retried several times, after a
+// jittered exponential backoff, before returning an error if they still
+// occur. fnct must therefore be idempotent, as with WithRetry.
 func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
 	return doExecuteInNewEnvironment(uid, 0, fnct)
 }
@@ -144,22 +234,32 @@ func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
 func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
 	env := newEnvironment(uid)
 	defer func() {
-		if r := recover(); r != nil {
+		r := recover()
+		if r != nil {
 			env.rollback()
-			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
-				// Transaction error
-				retries++
-				if retries < DBSerializationMaxRetries {
-					if doExecuteInNewEnvironment(uid, retries, fnct) == nil {
-						rError = nil
-						return
-					}
+		} else if err := env.commit(); err != nil {
+			// Serialization failures may only be detected at commit
+			r = err
+		}
+		if r == nil {
+			return
+		}
+		if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			// Transaction error
+			retries++
+			if retries < DBSerializationMaxRetries {
+				time.Sleep(serializationRetryDelay(int(retries) - 1))
+				if doExecuteInNewEnvironment(uid, retries, fnct) == nil {
+					rError = nil
+					return
 				}
 			}
-			rError = logging.LogPanicData(r)
-			return
+			r = exceptions.ConcurrencyError{
+				Message: i18n.TranslateCode(env.context.GetString("lang"), "", exceptions.ConcurrencyErrorMessage),
+				Debug:   err.Error(),
+			}
 		}
-		env.commit()
+		rError = logging.LogPanicData(r)
 	}()
 	fnct(env)
 	return nil
@@ -184,11 +284,16 @@ func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)
 				// to be as close as ExecuteInNewEnvironment as possible
 				retries++
 				if retries < DBSerializationMaxRetries {
+					time.Sleep(serializationRetryDelay(int(retries) - 1))
 					if doSimulateInNewEnvironment(uid, retries, fnct) == nil {
 						rError = nil
 						return
 					}
 				}
+				r = exceptions.ConcurrencyError{
+					Message: i18n.TranslateCode(env.context.GetString("lang"), "", exceptions.ConcurrencyErrorMessage),
+					Debug:   err.Error(),
+				}
 			}
 			rError = logging.LogPanicData(r)
 			return
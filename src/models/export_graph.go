@@ -0,0 +1,318 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// An ExportedGraph is a self-contained set of records exported with
+// ExportGraph, to be imported into another database with ImportGraph.
+//
+// Records are referenced by their external ID instead of their id and are
+// sorted so that the records they reference come first, as far as the
+// references do not form a cycle.
+type ExportedGraph struct {
+	Records []ExportedRecord `json:"records"`
+}
+
+// An ExportedRecord is a record of an ExportedGraph.
+//
+// Values are keyed by field JSON name. The values of many2one and one2one
+// fields are the external ID of the referenced record, or nil. Those of
+// many2many fields are the list of the external IDs of the referenced records.
+type ExportedRecord struct {
+	Model      string                 `json:"model"`
+	ExternalID string                 `json:"id"`
+	Values     map[string]interface{} `json:"values"`
+}
+
+// graphExcludedFields are the fields that are never exported in a graph,
+// since they are set by the importing database.
+var graphExcludedFields = map[string]bool{
+	"id":                true,
+	"hexya_external_id": true,
+	"hexya_version":     true,
+	"create_date":       true,
+	"create_uid":        true,
+	"write_date":        true,
+	"write_uid":         true,
+}
+
+// A graphExporter collects the records of an ExportedGraph
+type graphExporter struct {
+	records map[*Model]*RecordCollection
+}
+
+// follow adds the records of rs to the graph and follows the given
+// relation paths from them.
+func (ge *graphExporter) follow(rs *RecordCollection, paths []FieldName) {
+	if rs.IsEmpty() {
+		return
+	}
+	if _, ok := rs.model.fields.Get("HexyaExternalID"); !ok {
+		log.Panic("Only models with external IDs can be exported", "model", rs.model.name)
+	}
+	if existing, ok := ge.records[rs.model]; ok {
+		rs = existing.Union(rs)
+	}
+	ge.records[rs.model] = rs
+	var (
+		firsts []*Field
+		rests  = make(map[*Field][]FieldName)
+	)
+	for _, path := range paths {
+		exprs := splitFieldNames(path, ExprSep)
+		fi := rs.model.fields.MustGet(exprs[0].JSON())
+		if !fi.fieldType.IsRelationType() {
+			log.Panic("Exported paths must only contain relation fields", "model", rs.model.name, "path", path.Name())
+		}
+		if _, exists := rests[fi]; !exists {
+			firsts = append(firsts, fi)
+			rests[fi] = []FieldName{}
+		}
+		if len(exprs) > 1 {
+			rests[fi] = append(rests[fi], joinFieldNames(exprs[1:], ExprSep))
+		}
+	}
+	for _, first := range firsts {
+		related := rs.env.Pool(first.relatedModelName)
+		for _, rec := range rs.Records() {
+			related = related.Union(rec.Get(first).(RecordSet).Collection())
+		}
+		ge.follow(related, rests[first])
+	}
+}
+
+// graphFields returns the fields of the given model that are exported in graphs.
+func graphFields(model *Model) []*Field {
+	var res []*Field
+	for json, fi := range model.fields.registryByJSON {
+		switch {
+		case graphExcludedFields[json], fi.isComputedField(), fi.isRelatedField():
+			continue
+		case fi.fieldType == fieldtype.Many2Many, fi.isStored():
+			res = append(res, fi)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].json < res[j].json
+	})
+	return res
+}
+
+// exportRecord returns the ExportedRecord of the given record
+func exportRecord(rec *RecordCollection) ExportedRecord {
+	res := ExportedRecord{
+		Model:      rec.model.name,
+		ExternalID: rec.externalID(),
+		Values:     make(map[string]interface{}),
+	}
+	for _, fi := range graphFields(rec.model) {
+		val := rec.Get(fi)
+		switch {
+		case fi.fieldType.IsFKRelationType():
+			target := val.(RecordSet).Collection()
+			if target.IsEmpty() {
+				res.Values[fi.json] = nil
+				continue
+			}
+			res.Values[fi.json] = target.externalID()
+		case fi.fieldType == fieldtype.Many2Many:
+			extIDs := []string{}
+			for _, target := range val.(RecordSet).Collection().Records() {
+				extIDs = append(extIDs, target.externalID())
+			}
+			res.Values[fi.json] = extIDs
+		default:
+			res.Values[fi.json] = val
+		}
+	}
+	return res
+}
+
+// externalID returns the external ID of this record
+func (rc *RecordCollection) externalID() string {
+	return rc.Get(rc.model.fields.MustGet("HexyaExternalID")).(string)
+}
+
+// graphKey returns the key of the record with the given external ID
+// of the given model in an ExportedGraph.
+func graphKey(model, externalID string) string {
+	return fmt.Sprintf("%s/%s", model, externalID)
+}
+
+// ExportGraph returns the records of this RecordCollection and the records
+// reached by following the given relation paths from them (e.g. "Lines" or
+// "Lines.Product") as a self-contained ExportedGraph.
+//
+// Each record is exported once, even if it is reached several times, so that
+// cycles are exported as references. Relation fields of exported records that
+// point to records outside the graph reference them by external ID too: they
+// must exist in the importing database. Computed, related and one2many fields
+// as well as access fields are not exported.
+func (rc *RecordCollection) ExportGraph(paths ...FieldName) *ExportedGraph {
+	ge := graphExporter{records: make(map[*Model]*RecordCollection)}
+	ge.follow(rc, paths)
+	var (
+		modelNames []string
+		ordered    []ExportedRecord
+	)
+	exported := make(map[string]ExportedRecord)
+	keysByModel := make(map[string][]string)
+	for model, recs := range ge.records {
+		modelNames = append(modelNames, model.name)
+		for _, rec := range recs.Records() {
+			exp := exportRecord(rec)
+			key := graphKey(exp.Model, exp.ExternalID)
+			exported[key] = exp
+			keysByModel[model.name] = append(keysByModel[model.name], key)
+		}
+	}
+	sort.Strings(modelNames)
+	// Sort records so that referenced records come first
+	visited := make(map[string]bool)
+	var visit func(key string)
+	visit = func(key string) {
+		if visited[key] {
+			return
+		}
+		visited[key] = true
+		exp := exported[key]
+		model := Registry.MustGet(exp.Model)
+		for _, fi := range graphFields(model) {
+			if !fi.fieldType.IsFKRelationType() || exp.Values[fi.json] == nil {
+				continue
+			}
+			if target := graphKey(fi.relatedModelName, exp.Values[fi.json].(string)); exported[target].Model != "" {
+				visit(target)
+			}
+		}
+		ordered = append(ordered, exp)
+	}
+	for _, modelName := range modelNames {
+		for _, key := range keysByModel[modelName] {
+			visit(key)
+		}
+	}
+	return &ExportedGraph{Records: ordered}
+}
+
+// ImportGraph creates or updates in the database of the given Environment
+// the records of the given ExportedGraph, matching them by external ID.
+//
+// Records are imported in the order of the graph. References to records of the
+// graph that are not imported yet, which only happens with cycles, are set
+// once all the records are created. References to records outside the graph
+// are resolved in the database. It panics if a referenced external ID cannot
+// be found, or if a required reference is part of a cycle.
+func ImportGraph(env Environment, graph *ExportedGraph) {
+	inGraph := make(map[string]bool)
+	for _, exp := range graph.Records {
+		inGraph[graphKey(exp.Model, exp.ExternalID)] = true
+	}
+	imported := make(map[string]*RecordCollection)
+	// resolve returns the record with the given external ID or false
+	// if it is a record of the graph that is not imported yet.
+	resolve := func(modelName, externalID string) (*RecordCollection, bool) {
+		key := graphKey(modelName, externalID)
+		if rec, ok := imported[key]; ok {
+			return rec, true
+		}
+		if inGraph[key] {
+			return nil, false
+		}
+		return env.Pool(modelName).GetRecord(externalID), true
+	}
+	type deferredValues struct {
+		rec    *RecordCollection
+		values map[string]interface{}
+	}
+	var deferred []deferredValues
+	for _, exp := range graph.Records {
+		model := Registry.MustGet(exp.Model)
+		values := make(FieldMap)
+		later := make(map[string]interface{})
+		for json, val := range exp.Values {
+			fi := model.fields.MustGet(json)
+			switch {
+			case fi.fieldType.IsFKRelationType():
+				if val == nil {
+					values[json] = env.Pool(fi.relatedModelName)
+					continue
+				}
+				target, ok := resolve(fi.relatedModelName, val.(string))
+				if !ok {
+					later[json] = val
+					continue
+				}
+				values[json] = target
+			case fi.fieldType == fieldtype.Many2Many:
+				targets := env.Pool(fi.relatedModelName)
+				var pending bool
+				for _, extID := range graphExternalIDs(val) {
+					target, ok := resolve(fi.relatedModelName, extID)
+					if !ok {
+						pending = true
+						break
+					}
+					targets = targets.Union(target)
+				}
+				if pending {
+					later[json] = val
+					continue
+				}
+				values[json] = targets
+			default:
+				values[json] = val
+			}
+		}
+		rec := env.Pool(model.name).Search(model.Field(model.fields.MustGet("HexyaExternalID")).Equals(exp.ExternalID))
+		switch {
+		case rec.IsEmpty():
+			values["hexya_external_id"] = exp.ExternalID
+			rec = env.Pool(model.name).Call("Create", NewModelData(model, values)).(RecordSet).Collection()
+		default:
+			rec.Call("Write", NewModelData(model, values))
+		}
+		imported[graphKey(exp.Model, exp.ExternalID)] = rec
+		if len(later) > 0 {
+			deferred = append(deferred, deferredValues{rec: rec, values: later})
+		}
+	}
+	for _, d := range deferred {
+		values := make(FieldMap)
+		for json, val := range d.values {
+			fi := d.rec.model.fields.MustGet(json)
+			targets := env.Pool(fi.relatedModelName)
+			for _, extID := range graphExternalIDs(val) {
+				target, _ := resolve(fi.relatedModelName, extID)
+				targets = targets.Union(target)
+			}
+			values[json] = targets
+		}
+		d.rec.Call("Write", NewModelData(d.rec.model, values))
+	}
+}
+
+// graphExternalIDs returns the external IDs of the given value of an
+// ExportedRecord, whether it has been decoded from JSON or not.
+func graphExternalIDs(val interface{}) []string {
+	switch v := val.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		res := make([]string, len(v))
+		for i, extID := range v {
+			res[i] = extID.(string)
+		}
+		return res
+	}
+	return nil
+}
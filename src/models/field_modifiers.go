@@ -0,0 +1,83 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// FieldModifiers tells whether a field is currently readonly,
+// required or invisible for a given record.
+type FieldModifiers struct {
+	ReadOnly  bool `json:"readonly"`
+	Required  bool `json:"required"`
+	Invisible bool `json:"invisible"`
+}
+
+// Modifiers returns the modifiers of the given fields for each record of this
+// RecordCollection, keyed by record id and field JSON name. All the fields of
+// the model are returned if no field is given.
+//
+// Modifiers are evaluated from the ReadOnly and Required parameters of the
+// fields and from their ReadOnlyFunc, RequiredFunc and InvisibleFunc. When one
+// of these functions returns a Condition, the modifier is set for the records
+// matching this condition, which is evaluated in the database with a single
+// query for all the records. It panics if this RecordCollection has records
+// that are not stored in the database yet.
+func (rc *RecordCollection) Modifiers(fields ...FieldName) map[int64]map[string]FieldModifiers {
+	if rc.hasNegIds {
+		log.Panic("Modifiers can only be evaluated on records stored in the database", "model", rc.model.name)
+	}
+	fInfos := make([]*Field, len(fields))
+	for i, f := range fields {
+		fInfos[i] = rc.model.fields.MustGet(f.Name())
+	}
+	if len(fields) == 0 {
+		for _, fi := range rc.model.fields.registryByJSON {
+			fInfos = append(fInfos, fi)
+		}
+	}
+	recs := rc.Fetch()
+	res := make(map[int64]map[string]FieldModifiers)
+	for _, id := range recs.ids {
+		res[id] = make(map[string]FieldModifiers)
+	}
+	for _, fi := range fInfos {
+		readOnly := recs.evaluateModifier(fi.isReadOnly(), fi.readOnlyFunc)
+		required := recs.evaluateModifier(fi.required, fi.requiredFunc)
+		invisible := recs.evaluateModifier(false, fi.invisibleFunc)
+		for _, id := range recs.ids {
+			res[id][fi.json] = FieldModifiers{
+				ReadOnly:  readOnly[id],
+				Required:  required[id],
+				Invisible: invisible[id],
+			}
+		}
+	}
+	return res
+}
+
+// evaluateModifier returns the set of ids of the records of this RecordCollection
+// for which the modifier with the given static value and function is set.
+func (rc *RecordCollection) evaluateModifier(static bool, modFunc func(Environment) (bool, Conditioner)) map[int64]bool {
+	res := make(map[int64]bool)
+	val, cond := modifierValue(*rc.env, static, modFunc)
+	if val || cond == nil || cond.Underlying().IsEmpty() || len(rc.ids) == 0 {
+		for _, id := range rc.ids {
+			res[id] = val
+		}
+		return res
+	}
+	for _, id := range rc.env.Pool(rc.model.name).withIds(rc.ids).Search(cond.Underlying()).Ids() {
+		res[id] = true
+	}
+	return res
+}
+
+// modifierValue returns the value of the modifier with the given static value
+// and function in the given Environment, and the Condition that the records
+// must match for the modifier to be set, if any.
+func modifierValue(env Environment, static bool, modFunc func(Environment) (bool, Conditioner)) (bool, Conditioner) {
+	if modFunc == nil {
+		return static, nil
+	}
+	funcVal, cond := modFunc(env)
+	return static || funcVal, cond
+}
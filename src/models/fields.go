@@ -56,9 +56,12 @@ const (
 // - path is the search string that will be used to find records to update
 // (e.g. path = "Profile.BestPost").
 // - stored is true if the computed field is stored
+// - related is true if fieldName is a stored related field to update
+// instead of a computed field.
 type computeData struct {
 	model     *Model
 	stored    bool
+	related   bool
 	fieldName string
 	compute   string
 	path      string
@@ -169,6 +172,10 @@ func (fc *FieldsCollection) add(fInfo *Field) {
 	if _, exists := fc.registryByName[fInfo.name]; exists {
 		log.Panic("Trying to add already existing field", "model", fInfo.model.name, "field", fInfo.name)
 	}
+	if other, exists := fc.registryByJSON[fInfo.json]; exists {
+		log.Panic("Column name is already used by another field", "model", fInfo.model.name, "field", fInfo.name,
+			"column", fInfo.json, "other", other.name)
+	}
 	fc.register(fInfo)
 }
 
@@ -209,6 +216,10 @@ type Field struct {
 	invisibleFunc    func(Environment) (bool, Conditioner)
 	unique           bool
 	index            bool
+	unaccent         bool
+	trigram          bool
+	jsonIndex        bool
+	uuidKey          bool
 	compute          string
 	depends          []string
 	relatedModelName string
@@ -224,11 +235,17 @@ type Field struct {
 	groupOperator    string
 	size             int
 	digits           nbutils.Digits
+	precision        string
 	structField      reflect.StructField
 	relatedPathStr   string
 	relatedPath      FieldName
 	dependencies     []computeData
 	embed            bool
+	touchParent      bool
+	includeArchived  bool
+	order            string
+	orders           []orderPredicate
+	orderAggregate   *OrderAggregate
 	noCopy           bool
 	defaultFunc      func(Environment) interface{}
 	onDelete         OnDeleteAction
@@ -238,6 +255,7 @@ type Field struct {
 	constraint       string
 	inverse          string
 	filter           *Condition
+	checkCompany     bool
 	contexts         FieldContexts
 	ctxType          ctxType
 	updates          []map[string]interface{}
@@ -100,6 +100,20 @@ func (bf Boolean) DeclareField(fc *models.FieldsCollection, name string) *models
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle TypeChar fields as single line inputs.
+//
+// If Translate is set, a value is stored for each language given by the
+// 'lang' key of the context. Reading a record in a language without
+// translation returns the value of the default language.
+//
+// If Unaccent is set, case insensitive searches on this field also ignore
+// accents, and an index for such searches is created in the database.
+//
+// If Trigram is set, this field can be searched with the Similar operator and
+// records can be ordered by similarity, backed by a trigram index.
+//
+// If JSONIndex is set, this field holds JSON values, which can be searched
+// with JSONContains conditions backed by a GIN index. Its values are checked
+// to be valid JSON on Create and Write.
 type Char struct {
 	JSON            string
 	String          string
@@ -119,6 +133,9 @@ type Char struct {
 	Size            int
 	GoType          interface{}
 	Translate       bool
+	Unaccent        bool
+	Trigram         bool
+	JSONIndex       bool
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
@@ -210,6 +227,13 @@ func (df DateTime) DeclareField(fc *models.FieldsCollection, name string) *model
 }
 
 // A Float is a field for storing decimal numbers.
+//
+// Values are rounded on write to the given Digits scale. If Precision is set
+// to a precision usage name (e.g. "Product Price"), values are rounded to
+// the digits configured for this usage instead.
+//
+// If OrderAggregate is set on a non stored computed field, records can be ordered
+// by this field in the database. See models.OrderAggregate.
 type Float struct {
 	JSON            string
 	String          string
@@ -228,6 +252,7 @@ type Float struct {
 	GroupOperator   string
 	NoCopy          bool
 	Digits          nbutils.Digits
+	Precision       string
 	GoType          interface{}
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
@@ -236,6 +261,7 @@ type Float struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	OrderAggregate  *models.OrderAggregate
 }
 
 // DeclareField adds this datetime field for the given models.FieldsCollection with the given name.
@@ -246,6 +272,8 @@ func (ff Float) DeclareField(fc *models.FieldsCollection, name string) *models.F
 	fInfo := models.CreateFieldFromStruct(fc, &ff, name, fieldtype.Float, new(float64))
 	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(ff.GroupOperator, "sum"))
 	fInfo.SetProperty("digits", ff.Digits)
+	fInfo.SetProperty("precision", ff.Precision)
+	fInfo.SetProperty("orderAggregate", ff.OrderAggregate)
 	return fInfo
 }
 
@@ -288,6 +316,9 @@ func (tf HTML) DeclareField(fc *models.FieldsCollection, name string) *models.Fi
 }
 
 // An Integer is a field for storing non decimal numbers.
+//
+// If OrderAggregate is set on a non stored computed field, records can be ordered
+// by this field in the database. See models.OrderAggregate.
 type Integer struct {
 	JSON            string
 	String          string
@@ -313,6 +344,7 @@ type Integer struct {
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
+	OrderAggregate  *models.OrderAggregate
 }
 
 // DeclareField creates a datetime field for the given models.FieldsCollection with the given name.
@@ -322,12 +354,19 @@ func (i Integer) DeclareField(fc *models.FieldsCollection, name string) *models.
 	}
 	fInfo := models.CreateFieldFromStruct(fc, &i, name, fieldtype.Integer, new(int64))
 	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(i.GroupOperator, "sum"))
+	fInfo.SetProperty("orderAggregate", i.OrderAggregate)
 	return fInfo
 }
 
 // A Many2Many is a field for storing many-to-many relations.
 //
 // Clients are expected to handle many2many fields with a table or with tags.
+//
+// If CheckCompany is set, the related records must belong to the same company as
+// the record. See Many2One for details.
+//
+// Archived records of a relation model with an active field are not read,
+// unless IncludeArchived is set or the context has active_test set to false.
 type Many2Many struct {
 	JSON             string
 	String           string
@@ -352,6 +391,8 @@ type Many2Many struct {
 	OnChangeFilters  models.Methoder
 	Constraint       models.Methoder
 	Filter           models.Conditioner
+	CheckCompany     bool
+	IncludeArchived  bool
 	Inverse          models.Methoder
 	Default          func(models.Environment) interface{}
 }
@@ -387,6 +428,8 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 	fInfo.SetProperty("m2mRelModel", m2mRelModel)
 	fInfo.SetProperty("m2mOurField", m2mOurField)
 	fInfo.SetProperty("m2mTheirField", m2mTheirField)
+	fInfo.SetProperty("checkCompany", mf.CheckCompany)
+	fInfo.SetProperty("includeArchived", mf.IncludeArchived)
 	return fInfo
 }
 
@@ -394,6 +437,12 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 // i.e. the FK to another model.
 //
 // Clients are expected to handle many2one fields with a combo-box.
+//
+// If CheckCompany is set, the related record must either have no company (i.e. be
+// shared between companies) or belong to the same company as the record. If the
+// record itself has no company, the related record's company must be one of
+// the 'allowed_company_ids' of the context, or the company of the current user
+// if there are none. Both models must have a Company field.
 type Many2One struct {
 	JSON            string
 	String          string
@@ -417,6 +466,7 @@ type Many2One struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Filter          models.Conditioner
+	CheckCompany    bool
 	Inverse         models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
@@ -444,12 +494,22 @@ func (mf Many2One) DeclareField(fc *models.FieldsCollection, name string) *model
 	fInfo.SetProperty("noCopy", noCopy)
 	fInfo.SetProperty("required", required)
 	fInfo.SetProperty("embed", mf.Embed)
+	fInfo.SetProperty("checkCompany", mf.CheckCompany)
 	return fInfo
 }
 
 // A One2Many is a field for storing one-to-many relations.
 //
 // Clients are expected to handle one2many fields with a table.
+//
+// If TouchParent is set, creating, modifying or deleting a record of the
+// relation model updates the WriteDate and WriteUID fields of its parent.
+//
+// Records are read in the default order of the relation model, unless Order
+// is set, e.g. "Sequence, Date desc".
+//
+// Archived records of a relation model with an active field are not read,
+// unless IncludeArchived is set or the context has active_test set to false.
 type One2Many struct {
 	JSON            string
 	String          string
@@ -474,6 +534,9 @@ type One2Many struct {
 	Filter          models.Conditioner
 	Inverse         models.Methoder
 	Default         func(models.Environment) interface{}
+	TouchParent     bool
+	IncludeArchived bool
+	Order           string
 }
 
 // DeclareField creates a one2many field for the given models.FieldsCollection with the given name.
@@ -484,6 +547,9 @@ func (of One2Many) DeclareField(fc *models.FieldsCollection, name string) *model
 	}
 	fInfo.SetProperty("relationModel", of.RelationModel.Underlying())
 	fInfo.SetProperty("reverseFK", of.ReverseFK)
+	fInfo.SetProperty("touchParent", of.TouchParent)
+	fInfo.SetProperty("includeArchived", of.IncludeArchived)
+	fInfo.SetProperty("order", of.Order)
 	if !of.Copy {
 		fInfo.SetProperty("noCopy", true)
 	}
@@ -633,6 +699,16 @@ func (sf Selection) DeclareField(fc *models.FieldsCollection, name string) *mode
 // default max size, but it can be forced by setting the Size value.
 //
 // Clients are expected to handle text fields as multi-line inputs.
+//
+// If Unaccent is set, case insensitive searches on this field also ignore
+// accents, and an index for such searches is created in the database.
+//
+// If Trigram is set, this field can be searched with the Similar operator and
+// records can be ordered by similarity, backed by a trigram index.
+//
+// If JSONIndex is set, this field holds JSON values, which can be searched
+// with JSONContains conditions backed by a GIN index. Its values are checked
+// to be valid JSON on Create and Write.
 type Text struct {
 	JSON            string
 	String          string
@@ -652,6 +728,9 @@ type Text struct {
 	Size            int
 	GoType          interface{}
 	Translate       bool
+	Unaccent        bool
+	Trigram         bool
+	JSONIndex       bool
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
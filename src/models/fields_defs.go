@@ -82,6 +82,18 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 	if noc := val.FieldByName("NoCopy"); noc.IsValid() {
 		noCopy = noc.Bool()
 	}
+	var unaccent bool
+	if una := val.FieldByName("Unaccent"); una.IsValid() {
+		unaccent = una.Bool()
+	}
+	var trigram bool
+	if tri := val.FieldByName("Trigram"); tri.IsValid() {
+		trigram = tri.Bool()
+	}
+	var jsonIndex bool
+	if jsi := val.FieldByName("JSONIndex"); jsi.IsValid() {
+		jsonIndex = jsi.Bool()
+	}
 	fInfo := &Field{
 		model:           fc.model,
 		name:            name,
@@ -96,6 +108,9 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 		invisibleFunc:   val.FieldByName("InvisibleFunc").Interface().(func(Environment) (bool, Conditioner)),
 		unique:          unique,
 		index:           val.FieldByName("Index").Bool(),
+		unaccent:        unaccent,
+		trigram:         trigram,
+		jsonIndex:       jsonIndex,
 		compute:         compute,
 		inverse:         inverse,
 		depends:         val.FieldByName("Depends").Interface().([]string),
@@ -186,6 +201,12 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.unique = value.(bool)
 	case "index":
 		f.index = value.(bool)
+	case "unaccent":
+		f.unaccent = value.(bool)
+	case "trigram":
+		f.trigram = value.(bool)
+	case "json_index":
+		f.jsonIndex = value.(bool)
 	case "compute":
 		f.compute = value.(string)
 	case "depends":
@@ -200,10 +221,20 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.size = value.(int)
 	case "digits":
 		f.digits = value.(nbutils.Digits)
+	case "precision":
+		f.precision = value.(string)
 	case "relatedPathStr":
 		f.relatedPathStr = value.(string)
 	case "embed":
 		f.embed = value.(bool)
+	case "touchParent":
+		f.touchParent = value.(bool)
+	case "includeArchived":
+		f.includeArchived = value.(bool)
+	case "order":
+		f.order = value.(string)
+	case "orderAggregate":
+		f.orderAggregate = value.(*OrderAggregate)
 	case "noCopy":
 		f.noCopy = value.(bool)
 	case "defaultFunc":
@@ -222,6 +253,8 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.inverse = value.(string)
 	case "filter":
 		f.filter = value.(*Condition)
+	case "checkCompany":
+		f.checkCompany = value.(bool)
 	case "relationModel":
 		f.relatedModelName = value.(*Model).Name()
 	case "m2mRelModel":
@@ -357,12 +390,54 @@ func (f *Field) SetIndex(value bool) *Field {
 	return f
 }
 
+// SetUnaccent overrides the value of the Unaccent parameter of this Field
+func (f *Field) SetUnaccent(value bool) *Field {
+	f.addUpdate("unaccent", value)
+	return f
+}
+
+// SetTrigram overrides the value of the Trigram parameter of this Field
+func (f *Field) SetTrigram(value bool) *Field {
+	f.addUpdate("trigram", value)
+	return f
+}
+
+// SetJSONIndex overrides the value of the JSONIndex parameter of this Field
+func (f *Field) SetJSONIndex(value bool) *Field {
+	f.addUpdate("json_index", value)
+	return f
+}
+
 // SetEmbed overrides the value of the Embed parameter of this Field
 func (f *Field) SetEmbed(value bool) *Field {
 	f.addUpdate("embed", value)
 	return f
 }
 
+// SetTouchParent overrides the value of the TouchParent parameter of this Field
+func (f *Field) SetTouchParent(value bool) *Field {
+	f.addUpdate("touchParent", value)
+	return f
+}
+
+// SetIncludeArchived overrides the value of the IncludeArchived parameter of this Field
+func (f *Field) SetIncludeArchived(value bool) *Field {
+	f.addUpdate("includeArchived", value)
+	return f
+}
+
+// SetOrder overrides the value of the Order parameter of this Field
+func (f *Field) SetOrder(value string) *Field {
+	f.addUpdate("order", value)
+	return f
+}
+
+// SetOrderAggregate overrides the value of the OrderAggregate parameter of this Field
+func (f *Field) SetOrderAggregate(value *OrderAggregate) *Field {
+	f.addUpdate("orderAggregate", value)
+	return f
+}
+
 // SetSize overrides the value of the Size parameter of this Field
 func (f *Field) SetSize(value int) *Field {
 	f.addUpdate("size", value)
@@ -375,6 +450,12 @@ func (f *Field) SetDigits(value nbutils.Digits) *Field {
 	return f
 }
 
+// SetPrecision overrides the value of the Precision parameter of this Field
+func (f *Field) SetPrecision(value string) *Field {
+	f.addUpdate("precision", value)
+	return f
+}
+
 // SetNoCopy overrides the value of the NoCopy parameter of this Field
 func (f *Field) SetNoCopy(value bool) *Field {
 	f.addUpdate("noCopy", value)
@@ -474,6 +555,12 @@ func (f *Field) SetFilter(value Conditioner) *Field {
 	return f
 }
 
+// SetCheckCompany overrides the value of the CheckCompany parameter of this Field
+func (f *Field) SetCheckCompany(value bool) *Field {
+	f.addUpdate("checkCompany", value)
+	return f
+}
+
 // SetRelationModel overrides the value of the Filter parameter of this Field
 func (f *Field) SetRelationModel(value Modeler) *Field {
 	f.addUpdate("relationModel", value.Underlying())
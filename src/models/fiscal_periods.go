@@ -0,0 +1,149 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// A FiscalCalendar defines the model of the fiscal periods, such as the
+// accounting periods of companies, on which fiscal DatePeriods are computed.
+type FiscalCalendar struct {
+	// Model is the name of the model of the fiscal periods
+	Model string
+	// DateStart is the date field of the first day of a period
+	DateStart FieldName
+	// DateStop is the date field of the last day of a period
+	DateStop FieldName
+	// Condition returns the condition on Model that the current fiscal period
+	// must match in the given Environment, typically to select the periods of
+	// the current company. All periods are used if it is nil or returns nil.
+	Condition func(env Environment) Conditioner
+	// YearStart returns the month and the day of the first day of the fiscal
+	// year in the given Environment, typically from the configuration of the
+	// current company. Fiscal years start on January 1st if it is nil.
+	YearStart func(env Environment) (time.Month, int)
+}
+
+// fiscalCalendar is the FiscalCalendar registered with RegisterFiscalCalendar
+var fiscalCalendar *FiscalCalendar
+
+// RegisterFiscalCalendar registers the FiscalCalendar used by FiscalPeriod,
+// DateThisFiscalPeriod and DateThisFiscalYear. It is meant to be called by the
+// module defining the fiscal periods model in its init function.
+//
+// It panics if a FiscalCalendar has already been registered.
+func RegisterFiscalCalendar(calendar *FiscalCalendar) {
+	if fiscalCalendar != nil {
+		log.Panic("A fiscal calendar is already registered", "model", fiscalCalendar.Model)
+	}
+	fiscalCalendar = calendar
+}
+
+// mustGetFiscalCalendar returns the registered FiscalCalendar.
+// It panics if there is none or if its date fields are not date fields.
+func mustGetFiscalCalendar() *FiscalCalendar {
+	if fiscalCalendar == nil {
+		log.Panic("No fiscal calendar has been registered")
+	}
+	model := Registry.MustGet(fiscalCalendar.Model)
+	for _, field := range []FieldName{fiscalCalendar.DateStart, fiscalCalendar.DateStop} {
+		if model.getRelatedFieldInfo(field).fieldType != fieldtype.Date {
+			log.Panic("Fiscal periods bounds must be date fields", "model", model.name, "field", field)
+		}
+	}
+	return fiscalCalendar
+}
+
+// periodBounds returns the first day of the given fiscal period
+// and the day after its last day.
+func (fc *FiscalCalendar) periodBounds(period *RecordCollection) (time.Time, time.Time) {
+	period.EnsureOne()
+	start := period.Get(fc.DateStart).(dates.Date)
+	stop := period.Get(fc.DateStop).(dates.Date)
+	if start.IsZero() || stop.IsZero() {
+		log.Panic("Fiscal period without start or stop date", "model", period.ModelName(), "id", period.ids[0])
+	}
+	return calendarDay(start.Time), calendarDay(stop.Time).AddDate(0, 0, 1)
+}
+
+// FiscalPeriod returns the DatePeriod of the given record of the fiscal
+// periods model of the registered FiscalCalendar, from its start date to
+// its stop date, both included.
+//
+// The dates of the period are read in the searching Environment when the
+// query is executed.
+func FiscalPeriod(period RecordSet) DatePeriod {
+	return DatePeriod{bounds: func(env Environment, _ time.Time) (time.Time, time.Time) {
+		fc := mustGetFiscalCalendar()
+		if period.ModelName() != fc.Model {
+			log.Panic("Fiscal periods must be records of the fiscal calendar model", "model", period.ModelName(),
+				"calendarModel", fc.Model)
+		}
+		return fc.periodBounds(Registry.MustGet(fc.Model).Browse(env, period.Ids()))
+	}}
+}
+
+// DateThisFiscalPeriod returns the DatePeriod of the fiscal period of the
+// registered FiscalCalendar which contains the current day and matches its
+// Condition. If several periods match, the one which starts first is used.
+//
+// Searching with it panics with a UserError if there is no such period.
+func DateThisFiscalPeriod() DatePeriod {
+	return DatePeriod{bounds: func(env Environment, today time.Time) (time.Time, time.Time) {
+		fc := mustGetFiscalCalendar()
+		rs := env.Pool(fc.Model)
+		cond := newCondition()
+		if fc.Condition != nil {
+			if c := fc.Condition(env); c != nil {
+				cond = c.Underlying()
+			}
+		}
+		day := dates.Date{Time: today}
+		periods := rs.Search(rs.Model().Field(fc.DateStart).LowerOrEqual(day).
+			And().Field(fc.DateStop).GreaterOrEqual(day).
+			AndCond(cond)).OrderBy(fc.DateStart.Name()).Limit(1).Fetch()
+		if periods.IsEmpty() {
+			panic(exceptions.UserError{
+				Message: rs.T("There is no fiscal period for %s", day.String()),
+				Debug:   fmt.Sprintf("model: %s, day: %s", fc.Model, day),
+			})
+		}
+		return fc.periodBounds(periods)
+	}}
+}
+
+// DateThisFiscalYear returns the DatePeriod of the current fiscal year,
+// which starts on the day given by the YearStart function of the registered
+// FiscalCalendar, or on January 1st if there is none.
+//
+// Fiscal years starting on a day that some months do not have, such as
+// the 29th of February, start on the last day of the month these years.
+func DateThisFiscalYear() DatePeriod {
+	return DatePeriod{bounds: func(env Environment, today time.Time) (time.Time, time.Time) {
+		month, day := time.January, 1
+		if fiscalCalendar != nil && fiscalCalendar.YearStart != nil {
+			month, day = fiscalCalendar.YearStart(env)
+		}
+		start := fiscalYearStart(today.Year(), month, day)
+		if start.After(today) {
+			start = fiscalYearStart(today.Year()-1, month, day)
+		}
+		return start, fiscalYearStart(start.Year()+1, month, day)
+	}}
+}
+
+// fiscalYearStart returns the UTC midnight of the given day of month in the
+// given year, or of the last day of the month if it has less days.
+func fiscalYearStart(year int, month time.Month, day int) time.Time {
+	if lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// A RecordsGroup is a group of records returned by SearchGrouped
+// - GroupAggregateRow holds the value of the group field, the aggregated values
+// and the number of records of the whole group, and its condition
+// - Records holds the first records of the group in the RecordSet order
+type RecordsGroup struct {
+	GroupAggregateRow
+	Records *RecordCollection
+}
+
+// SearchGrouped returns the records of this RecordSet grouped by the given
+// field, with the aggregated values of the given fields for each group and
+// at most limit records of each group, in the order of the RecordSet.
+//
+// This is meant for grouped list and kanban views, which display each group
+// header with its aggregates and the first records of the group. Groups are
+// ordered by the group field. Only two queries are executed whatever the
+// number of groups: one for the groups aggregates and one for the records of
+// all groups, which uses LimitPerPartition. Record rules apply to both.
+//
+// Groups by a many2one field, such as the stage of kanban views, are ordered
+// by the default order of the related model instead, e.g. the sequence of the
+// stages, and empty groups are returned with the group_expand context key, as
+// with Aggregates.
+//
+// The limit and offset of this RecordSet are ignored. Use the Condition of a
+// group to fetch more of its records. It panics if limit is not positive or if
+// groupBy is not stored in the database.
+func (rc *RecordCollection) SearchGrouped(groupBy FieldName, limit int, fields ...FieldName) []RecordsGroup {
+	rSet := rc.Limit(-1)
+	rSet.query.offset = 0
+	recs := rSet.LimitPerPartition(limit, groupBy).ForceLoad(groupBy)
+	aggFields := []FieldName{groupBy}
+	for _, f := range fields {
+		if f.JSON() != groupBy.JSON() {
+			aggFields = append(aggFields, f)
+		}
+	}
+	aggSet := rSet.clone()
+	aggSet.query.orders = nil
+	rows := aggSet.GroupBy(groupBy).Aggregates(aggFields...)
+
+	fi := rc.model.getRelatedFieldInfo(groupBy)
+	recIds := make(map[string][]int64)
+	for _, rec := range recs.Records() {
+		key := groupKey(fi, rec.Get(groupBy))
+		recIds[key] = append(recIds[key], rec.ids[0])
+	}
+	res := make([]RecordsGroup, len(rows))
+	for i, row := range rows {
+		res[i] = RecordsGroup{
+			GroupAggregateRow: row,
+			Records:           newRecordCollection(rc.Env(), rc.model.name).withIds(recIds[groupKey(fi, row.Values.Get(groupBy))]),
+		}
+	}
+	return res
+}
+
+// relationGroupOrders returns the orders of the groups by the given field if it
+// is a many2one field, that is the default order of the related model with the
+// group of the records without related record last. It returns nil otherwise.
+func (rc *RecordCollection) relationGroupOrders(groupBy FieldName) []orderPredicate {
+	fi := rc.model.getRelatedFieldInfo(groupBy)
+	if fi.fieldType != fieldtype.Many2One {
+		return nil
+	}
+	res := make([]orderPredicate, len(fi.relatedModel.defaultOrder))
+	for i, o := range fi.relatedModel.defaultOrder {
+		res[i] = orderPredicate{
+			field: rc.model.FieldName(groupBy.Name() + ExprSep + o.field.Name()),
+			desc:  o.desc,
+			nulls: "LAST",
+		}
+	}
+	return res
+}
+
+// expandRelationGroups returns the given rows of the given groups with an empty
+// row for each related record that has no records in this RecordSet, if this
+// RecordSet is grouped by a single many2one field. Only the related records that
+// match the conditions of this RecordSet on the group field are added.
+//
+// Empty rows are added in the default order of the related model, at their place
+// if rows are in this order, and after the other rows otherwise.
+func (rc *RecordCollection) expandRelationGroups(groups []FieldName, rows []GroupAggregateRow) []GroupAggregateRow {
+	if len(groups) != 1 {
+		return rows
+	}
+	groupBy := groups[0]
+	fi := rc.model.getRelatedFieldInfo(groupBy)
+	if fi.fieldType != fieldtype.Many2One {
+		return rows
+	}
+	byKey := make(map[string]GroupAggregateRow)
+	for _, row := range rows {
+		byKey[groupKey(fi, row.Values.Get(groupBy))] = row
+	}
+	inOrder := len(rc.query.orders) == 0
+	var res []GroupAggregateRow
+	if !inOrder {
+		res = append(res, rows...)
+	}
+	for _, relRec := range rc.env.Pool(fi.relatedModelName).Search(rc.groupExpandCondition(groupBy)).Records() {
+		key := groupKey(fi, relRec.ids[0])
+		row, ok := byKey[key]
+		switch {
+		case ok && inOrder:
+			delete(byKey, key)
+		case ok:
+			continue
+		default:
+			row = GroupAggregateRow{
+				Values:    NewModelData(rc.model).Set(groupBy, relRec),
+				Condition: getGroupCondition(groups, FieldMap{groupBy.JSON(): relRec.ids[0]}, rc.query.cond),
+			}
+		}
+		res = append(res, row)
+	}
+	if !inOrder {
+		return res
+	}
+	for _, row := range rows {
+		// Rows without related record or with a related record that the user cannot see
+		if _, ok := byKey[groupKey(fi, row.Values.Get(groupBy))]; ok {
+			res = append(res, row)
+		}
+	}
+	return res
+}
+
+// groupExpandCondition returns the condition on the related model of the given
+// many2one field made of the predicates of the condition of this RecordSet on
+// this field. It is empty if the condition of this RecordSet has top level OR
+// predicates.
+func (rc *RecordCollection) groupExpandCondition(groupBy FieldName) *Condition {
+	res := newCondition()
+	for _, p := range rc.query.cond.predicates {
+		if p.isOr {
+			return newCondition()
+		}
+	}
+	for _, p := range rc.query.cond.predicates {
+		if p.isCond || len(p.exprs) == 0 || p.exprs[0].JSON() != groupBy.JSON() || p.subCond != nil || p.aggregate != nil {
+			continue
+		}
+		relPred := p
+		relPred.exprs = p.exprs[1:]
+		if len(relPred.exprs) == 0 {
+			relPred.exprs = []FieldName{ID}
+		}
+		res.predicates = append(res.predicates, relPred)
+	}
+	return res
+}
+
+// groupKey returns a string that identifies the group of the given value
+// of the given field, so that a NULL value and the zero value of the field
+// have the same key.
+func groupKey(fi *Field, value interface{}) string {
+	switch {
+	case value == nil && fi.fieldType.IsFKRelationType():
+		value = int64(0)
+	case value == nil:
+		value = reflect.Zero(fi.structField.Type).Interface()
+	}
+	if rs, ok := value.(RecordSet); ok {
+		var id int64
+		if !rs.IsEmpty() {
+			id = rs.Ids()[0]
+		}
+		value = id
+	}
+	return fmt.Sprint(value)
+}
@@ -44,4 +44,5 @@ func init() {
 	declareCommonMixin()
 	declareBaseMixin()
 	declareModelMixin()
+	declareLineNumberMixin()
 }
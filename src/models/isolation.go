@@ -0,0 +1,127 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// An IsolationLevel is the isolation level of a database transaction
+type IsolationLevel string
+
+// Available isolation levels. New transactions are Serializable.
+const (
+	ReadCommitted  IsolationLevel = "READ COMMITTED"
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	Serializable   IsolationLevel = "SERIALIZABLE"
+)
+
+var isolationLevels = map[IsolationLevel]bool{
+	ReadCommitted:  true,
+	RepeatableRead: true,
+	Serializable:   true,
+}
+
+// DBSerializationRetryBackoff is the time WithRetry and ExecuteInNewEnvironment
+// wait at most before retrying a transaction after its first serialization
+// failure or deadlock. This time doubles at each new retry.
+var DBSerializationRetryBackoff = 20 * time.Millisecond
+
+// Isolation returns the isolation level of the transaction of this Environment.
+func (env Environment) Isolation() IsolationLevel {
+	return env.cr.isolation
+}
+
+// WithIsolation sets the isolation level of the transaction of this Environment
+// to the given level and returns the Environment.
+//
+// The isolation level applies to the whole transaction, that is to all the
+// Environments sharing it. It can only be changed before the first query of
+// the transaction, typically at the beginning of the function given to
+// ExecuteInNewEnvironment or WithRetry. WithIsolation panics otherwise.
+func (env Environment) WithIsolation(level IsolationLevel) Environment {
+	if !isolationLevels[level] {
+		log.Panic("Unknown transaction isolation level", "level", level)
+	}
+	if env.cr.isolation == level {
+		return env
+	}
+	if env.cr.used {
+		log.Panic("Transaction isolation level can only be changed before the first query of the transaction",
+			"current", env.cr.isolation, "requested", level)
+	}
+	env.cr.setIsolation(level)
+	return env
+}
+
+// WithRetry executes fnct in a new transaction with the user, the context and
+// the isolation level of this Environment. The transaction is committed if
+// fnct returns nil and rolled back if it returns an error or panics.
+//
+// If the transaction fails because of a serialization failure or a deadlock
+// with a concurrent transaction, it is rolled back and fnct is executed again
+// in a new transaction, at most retries times, after a jittered exponential
+// backoff starting at DBSerializationRetryBackoff. The error of the last
+// execution is returned.
+//
+// Since fnct may be executed several times, it must be idempotent: all its
+// side effects outside the database must be registered with AfterCommit, and
+// it must not depend on values computed by a previous execution. Data of the
+// transaction of this Environment which is not committed yet is not visible
+// to fnct.
+func (env Environment) WithRetry(retries int, fnct func(Environment) error) error {
+	adapter := adapters[db.DriverName()]
+	var err error
+	for i := 0; ; i++ {
+		err = env.executeInNewTransaction(fnct)
+		if err == nil || !adapter.isSerializationError(err) || i >= retries {
+			return err
+		}
+		log.Debug("Retrying transaction after serialization failure", "retry", i+1, "error", err)
+		time.Sleep(serializationRetryDelay(i))
+	}
+}
+
+// serializationRetryDelay returns the time to wait before the given retry,
+// starting at 0, of a transaction that failed because of a serialization
+// failure or a deadlock. The delay doubles at each retry from
+// DBSerializationRetryBackoff and is randomized between half and all of it,
+// so that the transactions that conflicted do not conflict again.
+func serializationRetryDelay(retry int) time.Duration {
+	delay := DBSerializationRetryBackoff << uint(retry)
+	if delay < 2 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// executeInNewTransaction executes fnct once in a new transaction with the
+// user, the context and the isolation level of this Environment.
+//
+// Serialization errors are returned as is, so that the caller can retry.
+func (env Environment) executeInNewTransaction(fnct func(Environment) error) (rError error) {
+	newEnv := newEnvironment(env.uid)
+	newEnv.context = env.context
+	newEnv = newEnv.WithIsolation(env.cr.isolation)
+	defer func() {
+		if r := recover(); r != nil {
+			newEnv.rollback()
+			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+				rError = err
+				return
+			}
+			rError = logging.LogPanicData(r)
+			return
+		}
+		if rError != nil {
+			newEnv.rollback()
+			return
+		}
+		rError = newEnv.commit()
+	}()
+	return fnct(newEnv)
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// A JobEnqueuer creates in the given Environment a job that calls
+// asynchronously the given method of the given model with args,
+// and returns the created job record.
+type JobEnqueuer func(env Environment, modelName, methodName string, args []interface{}) RecordSet
+
+// jobEnqueuer is the JobEnqueuer used by Environment.EnqueueJob
+var jobEnqueuer JobEnqueuer
+
+// RegisterJobEnqueuer sets the JobEnqueuer used by Environment.EnqueueJob.
+//
+// It is meant to be called by the package implementing the job queue,
+// typically in its init function.
+func RegisterJobEnqueuer(enqueuer JobEnqueuer) {
+	jobEnqueuer = enqueuer
+}
+
+// EnqueueJob creates a job that calls asynchronously the given method of the
+// given model with args, and returns the created job record.
+//
+// The job is created in the transaction of this Environment, so that it will
+// only be executed after this transaction has been committed. The method is
+// called on an empty RecordSet of the model, in a new Environment with the
+// user of this Environment. args must be JSON serializable.
+//
+// It panics if no job queue has been registered with RegisterJobEnqueuer.
+func (env Environment) EnqueueJob(modelName, methodName string, args ...interface{}) RecordSet {
+	if jobEnqueuer == nil {
+		log.Panic("No job queue registered, did you import the jobs package ?", "model", modelName, "method", methodName)
+	}
+	return jobEnqueuer(env, modelName, methodName, args)
+}
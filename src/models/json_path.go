@@ -0,0 +1,223 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// WriteJSONPath sets the value at the given path of the JSON objects stored in
+// the given field of the records of this RecordCollection, keeping the other
+// keys of these objects. path is a dot separated list of keys, such as
+// "theme.color". Missing intermediate objects along the path are created.
+//
+// field must be a stored Char or Text field holding JSON objects, empty values
+// being empty objects.
+//
+// This is a read-modify-write fallback rather than an atomic jsonb_set update,
+// since such fields are not stored as jsonb: the records are locked and their
+// values read again from the database, and the whole modified field is then
+// written with Write, so that concurrent updates of other keys are not lost.
+// As with any Write, computed fields depending on field are recomputed and
+// constraints are checked again.
+//
+// It panics if the value of a record is not a JSON object or if a value along
+// the path is not an object.
+func (rc *RecordCollection) WriteJSONPath(field FieldName, path string, value interface{}) bool {
+	fi := rc.model.fields.MustGet(field.Name())
+	if !fi.isJSONField() {
+		log.Panic("JSON paths can only be written in stored char or text fields", "model", rc.model.name, "field", field)
+	}
+	if path == "" {
+		log.Panic("Empty JSON path", "model", rc.model.name, "field", field)
+	}
+	if rc.hasNegIds {
+		log.Panic("JSON paths can only be written on records stored in the database", "model", rc.model.name)
+	}
+	if rc.IsEmpty() {
+		return true
+	}
+	keys := strings.Split(path, ".")
+	locked := rc.env.Pool(rc.model.name).Search(rc.model.Field(ID).In(rc.ids)).ForUpdate()
+	locked.ForceLoad(ID, field)
+	for _, rec := range locked.Records() {
+		doc := make(map[string]interface{})
+		if raw := rec.Get(field).(string); raw != "" {
+			if err := decodeJSON([]byte(raw), &doc); err != nil {
+				log.Panic("Field value is not a JSON object", "model", rc.model.name, "field", field, "id", rec.ids[0], "error", err)
+			}
+		}
+		if !setJSONPath(doc, keys, value) {
+			log.Panic("JSON path goes through a value which is not an object", "model", rc.model.name, "field", field,
+				"id", rec.ids[0], "path", path)
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(doc); err != nil {
+			log.Panic("Unable to marshal JSON value", "model", rc.model.name, "field", field, "path", path, "error", err)
+		}
+		rec.Call("Write", NewModelData(rc.model).Set(field, strings.TrimSuffix(buf.String(), "\n")))
+	}
+	return true
+}
+
+// setJSONPath sets the given value at the path of the given keys in doc,
+// creating the missing intermediate objects. It returns false if a value
+// along the path is not an object.
+func setJSONPath(doc map[string]interface{}, keys []string, value interface{}) bool {
+	for _, key := range keys[:len(keys)-1] {
+		next, exists := doc[key]
+		if !exists || next == nil {
+			obj := make(map[string]interface{})
+			doc[key] = obj
+			doc = obj
+			continue
+		}
+		obj, ok := next.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		doc = obj
+	}
+	doc[keys[len(keys)-1]] = value
+	return true
+}
+
+// isJSONField returns true if this field can hold JSON values
+// which are written by path.
+func (f *Field) isJSONField() bool {
+	return (f.fieldType == fieldtype.Char || f.fieldType == fieldtype.Text) && f.isStored()
+}
+
+// holdsJSON returns true if this field has the JSONIndex option. The values
+// of such fields are checked to be valid JSON on Create and Write, so that
+// they can all be cast to jsonb by JSONContains searches and the GIN index.
+func (f *Field) holdsJSON() bool {
+	return f.jsonIndex && f.isJSONField()
+}
+
+// checkJSONValues panics with a ValidationError if the given data sets a
+// field with the JSONIndex option to a value which is not valid JSON. Empty
+// values are always allowed, since they unset the field.
+func (rc *RecordCollection) checkJSONValues(data *ModelData) {
+	for field, value := range data.FieldMap {
+		fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(field))
+		if !fi.holdsJSON() || isEmptyValue(fi, value) {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			str = fmt.Sprintf("%v", value)
+		}
+		if json.Valid([]byte(str)) {
+			continue
+		}
+		panic(exceptions.ValidationError{
+			Message: rc.T("Invalid JSON value for field %s", fi.description),
+			Debug:   fmt.Sprintf("model: %s, field: %s, value: %v", rc.model.name, fi.name, value),
+		})
+	}
+}
+
+// JSONContains returns true if the JSON value doc contains the JSON encoding
+// of value, with the semantics of JSONContains conditions. It is meant to
+// filter records in memory, for instance with Filtered on detached RecordSets.
+//
+// An object contains another object if it has all its keys with values that
+// contain their values. An array contains another array if each element of the
+// latter is contained in an element of the former. Besides, a top level array
+// contains the scalar values that are one of its elements. Other values only
+// contain equal values.
+//
+// Empty or invalid JSON values contain nothing.
+func JSONContains(doc string, value interface{}) bool {
+	if doc == "" {
+		return false
+	}
+	var docVal interface{}
+	if err := decodeJSON([]byte(doc), &docVal); err != nil {
+		return false
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to marshal JSON containment value", "value", value, "error", err)
+	}
+	var val interface{}
+	if err := decodeJSON(data, &val); err != nil {
+		log.Panic("Unable to decode JSON containment value", "value", value, "error", err)
+	}
+	if arr, ok := docVal.([]interface{}); ok && isJSONScalar(val) {
+		for _, elem := range arr {
+			if jsonValueContains(elem, val) {
+				return true
+			}
+		}
+	}
+	return jsonValueContains(docVal, val)
+}
+
+// decodeJSON decodes the given JSON data into v, keeping numbers as json.Number.
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// isJSONScalar returns true if the given decoded JSON value is neither
+// an object nor an array.
+func isJSONScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+// jsonValueContains returns true if the decoded JSON value a contains b.
+func jsonValueContains(a, b interface{}) bool {
+	switch bv := b.(type) {
+	case map[string]interface{}:
+		av, ok := a.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, val := range bv {
+			if sub, exists := av[key]; !exists || !jsonValueContains(sub, val) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		av, ok := a.([]interface{})
+		if !ok {
+			return false
+		}
+	bElems:
+		for _, val := range bv {
+			for _, elem := range av {
+				if jsonValueContains(elem, val) {
+					continue bElems
+				}
+			}
+			return false
+		}
+		return true
+	case json.Number:
+		an, ok := a.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aErr := an.Float64()
+		bf, bErr := bv.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	}
+	return a == b
+}
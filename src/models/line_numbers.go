@@ -0,0 +1,135 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// declareLineNumberMixin declares the LineNumberMixin that holds the
+// LineNumber field of the models numbered with SetLineNumbering.
+func declareLineNumberMixin() {
+	lineNumberMixin := NewMixinModel("LineNumberMixin")
+	lineNumberMixin.fields.add(&Field{
+		model:       lineNumberMixin,
+		name:        "LineNumber",
+		description: "Line Number",
+		json:        "line_number",
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Type: reflect.TypeOf(0)},
+		noCopy:      true,
+		readOnly:    true,
+		defaultFunc: DefaultValue(0),
+	})
+}
+
+// SetLineNumbering makes the LineNumber field of this model hold contiguous
+// numbers from 1 to N among the records that have the same parentField value,
+// following the default order of the model.
+//
+// The model must inherit LineNumberMixin and parentField must be a many2one
+// field. Line numbers are updated in a single query each time records are
+// created, deleted, moved to another parent or reordered, before constraints
+// are checked.
+func (m *Model) SetLineNumbering(parentField FieldName) {
+	m.lineNumberParent = parentField
+}
+
+// checkLineNumbering checks that line numbered models have a
+// LineNumber field and a many2one parent field.
+func checkLineNumbering() {
+	for _, model := range Registry.registryByName {
+		if model.lineNumberParent == nil {
+			continue
+		}
+		if _, ok := model.fields.Get("LineNumber"); !ok {
+			log.Panic("Line numbered model does not inherit LineNumberMixin", "model", model.name)
+		}
+		fi, ok := model.fields.Get(model.lineNumberParent.JSON())
+		if !ok || fi.fieldType != fieldtype.Many2One {
+			log.Panic("Line numbering parent field must be a many2one field", "model", model.name,
+				"field", model.lineNumberParent.Name())
+		}
+	}
+}
+
+// lineNumbersDependOn returns true if the line numbers of this RecordCollection
+// may change when the given fields are modified.
+func (rc *RecordCollection) lineNumbersDependOn(fields FieldNames) bool {
+	if rc.model.lineNumberParent == nil {
+		return false
+	}
+	for _, f := range fields {
+		if f.JSON() == rc.model.lineNumberParent.JSON() {
+			return true
+		}
+		for _, order := range rc.model.defaultOrder {
+			if splitFieldNames(order.field, ExprSep)[0].JSON() == f.JSON() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lineNumberParentIds returns the ids of the parents of the records
+// of this RecordCollection, if its model is line numbered.
+func (rc *RecordCollection) lineNumberParentIds() []int64 {
+	if rc.model.lineNumberParent == nil || rc.hasNegIds {
+		return nil
+	}
+	var res []int64
+	for _, rec := range rc.Records() {
+		res = append(res, rec.Get(rc.model.lineNumberParent).(RecordSet).Ids()...)
+	}
+	return res
+}
+
+// updateLineNumbers renumbers the lines of the given parents from 1 to N,
+// in the default order of the model.
+//
+// Only the lines whose number changes are updated, with a single query.
+func (rc *RecordCollection) updateLineNumbers(parentIds []int64) {
+	if rc.model.lineNumberParent == nil || len(parentIds) == 0 {
+		return
+	}
+	parent := rc.model.lineNumberParent
+	lineNumber := rc.model.FieldName("LineNumber")
+	lines := rc.env.Pool(rc.model.name).Sudo().Search(rc.model.Field(parent).In(parentIds))
+	lines.ForceLoad(ID, parent, lineNumber)
+	var (
+		cases []string
+		args  SQLParams
+		ids   []int64
+	)
+	numbers := make(map[int64]int)
+	for _, line := range lines.Records() {
+		parentID := line.Get(parent).(RecordSet).Ids()[0]
+		numbers[parentID]++
+		if line.Get(lineNumber).(int) == numbers[parentID] {
+			continue
+		}
+		cases = append(cases, "WHEN ? THEN ?")
+		args = append(args, line.ids[0], numbers[parentID])
+		ids = append(ids, line.ids[0])
+	}
+	if len(ids) == 0 {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf("UPDATE %s SET %s = CAST(CASE id %s END AS INTEGER) WHERE id IN (?)",
+		adapter.quoteTableName(rc.model.tableName), lineNumber.JSON(), strings.Join(cases, " "))
+	rc.env.cr.Execute(query, append(args, ids)...)
+	rc.env.cache.invalidateSearches()
+	for _, id := range ids {
+		rc.env.cache.invalidateRecord(rc.model, id)
+	}
+	renumbered := rc.env.Pool(rc.model.name).withIds(ids)
+	renumbered.processTriggers(FieldNames{lineNumber})
+	renumbered.notifyChange(ChangeWritten, ids, FieldNames{lineNumber})
+}
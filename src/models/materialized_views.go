@@ -0,0 +1,157 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// NewMaterializedViewModel creates a manual model backed by a materialized
+// SQL view with the given SELECT query. query must return an "id" column with
+// unique values and a column for each stored field of the model.
+//
+// The materialized view is created with its data when the database is
+// synchronised, with a unique index on its id column so that it can be
+// refreshed concurrently. It is only created again, with the materialized
+// view models depending on it, when query has changed. Its data is otherwise
+// only updated by RefreshView, or periodically if a period is set with
+// SetViewRefreshPeriod.
+//
+// As other manual models, materialized view models are read only.
+func NewMaterializedViewModel(name, query string) *Model {
+	model := NewManualModel(name)
+	model.viewQuery = query
+	return model
+}
+
+// IsMaterializedView returns true if this model is backed by a materialized SQL view.
+func (m *Model) IsMaterializedView() bool {
+	return m.viewQuery != ""
+}
+
+// SetViewRefreshPeriod sets the period at which the materialized view of this
+// model is refreshed concurrently by the worker loop. A zero period, which is
+// the default, disables periodic refreshes.
+//
+// It panics if this model is not a materialized view model.
+func (m *Model) SetViewRefreshPeriod(period time.Duration) {
+	if !m.IsMaterializedView() {
+		log.Panic("Refresh period can only be set on materialized view models", "model", m.name)
+	}
+	m.viewRefreshPeriod = period
+}
+
+// RefreshView updates the data of the materialized view of this RecordCollection's
+// model by executing its query again, and invalidates the cached records of the model.
+//
+// If concurrently is true, the view is refreshed without locking out concurrent
+// reads, which requires a unique index on the view. Otherwise, reads are blocked
+// until the refresh is committed.
+//
+// It panics if the model is not a materialized view model, or if concurrently
+// is true and the view has no unique index.
+func (rc *RecordCollection) RefreshView(concurrently bool) {
+	if !rc.model.IsMaterializedView() {
+		log.Panic("RefreshView can only be called on materialized view models", "model", rc.model.name)
+	}
+	adapter := adapters[db.DriverName()]
+	if concurrently && !adapter.hasUniqueIndex(rc.model.tableName) {
+		log.Panic("Materialized view must have a unique index to be refreshed concurrently", "model", rc.model.name,
+			"view", rc.model.tableName)
+	}
+	rc.env.cr.Execute(adapter.refreshMaterializedViewSQL(rc.model.tableName, concurrently))
+	rc.env.cache.invalidateModel(rc.model)
+}
+
+// createMaterializedView creates the materialized view of the given model with
+// its data and the unique index on its id column, unless it already exists with
+// the same query.
+//
+// The hash of the query is stored in the comment of the view to detect changes.
+// When the view is created again, the materialized views of other models which
+// depend on it are dropped with it and created again after it. It panics if
+// other views, which are not managed by Hexya, depend on it.
+func createMaterializedView(model *Model) {
+	adapter := adapters[db.DriverName()]
+	hash := viewQueryHash(model.viewQuery)
+	if comment, ok := adapter.materializedViewComment(model.tableName); ok && comment == hash {
+		return
+	}
+	dependents := dependentViewModels(model, make(map[string]bool))
+	for _, dep := range dependents {
+		executeSchemaStatement(adapter.dropMaterializedViewSQL(dep.tableName), "")
+	}
+	executeSchemaStatement(adapter.dropMaterializedViewSQL(model.tableName), "")
+	doCreateMaterializedView(model)
+	for i := len(dependents) - 1; i >= 0; i-- {
+		doCreateMaterializedView(dependents[i])
+	}
+}
+
+// doCreateMaterializedView creates the materialized view of the given model with
+// its data, the unique index on its id column and the hash of its query as comment.
+func doCreateMaterializedView(model *Model) {
+	adapter := adapters[db.DriverName()]
+	dropSQL := adapter.dropMaterializedViewSQL(model.tableName)
+	executeSchemaStatement(adapter.createMaterializedViewSQL(model.tableName, model.viewQuery), dropSQL)
+	executeSchemaStatement(adapter.commentMaterializedViewSQL(model.tableName, viewQueryHash(model.viewQuery)), "")
+	executeSchemaStatement(fmt.Sprintf(`
+		CREATE UNIQUE INDEX %s ON %s (id)
+	`, fmt.Sprintf("%s_id_index", model.tableName), adapter.quoteTableName(model.tableName)), "")
+}
+
+// dependentViewModels returns the materialized view models whose views depend,
+// directly or not, on the view of the given model, each model being returned
+// before the models whose views it depends on, so that they can be dropped in
+// this order. visited holds the table names of the views already returned.
+//
+// It panics if a dependent view is not the view of a materialized view model.
+func dependentViewModels(model *Model, visited map[string]bool) []*Model {
+	var res []*Model
+	for _, view := range adapters[db.DriverName()].dependentViews(model.tableName) {
+		if visited[view] {
+			continue
+		}
+		visited[view] = true
+		depModel, ok := Registry.registryByTableName[view]
+		if !ok || !depModel.IsMaterializedView() {
+			log.Panic("Materialized view cannot be created again because a view which is not a materialized view model depends on it",
+				"model", model.name, "view", model.tableName, "dependentView", view)
+		}
+		res = append(res, dependentViewModels(depModel, visited)...)
+		res = append(res, depModel)
+	}
+	return res
+}
+
+// viewQueryHash returns the hash of the given materialized view query
+// which is stored in the comment of the view.
+func viewQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return "hexya:" + hex.EncodeToString(sum[:])
+}
+
+// registerViewRefreshWorkers registers a worker function for each materialized
+// view model with a refresh period, which refreshes its view concurrently.
+func registerViewRefreshWorkers() {
+	for _, model := range Registry.registryByName {
+		if !model.IsMaterializedView() || model.viewRefreshPeriod == 0 {
+			continue
+		}
+		modelName := model.name
+		RegisterWorker(NewWorkerFunction(func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool(modelName).RefreshView(true)
+			})
+			if err != nil {
+				log.Warn("Error while refreshing materialized view", "model", modelName, "error", err)
+			}
+		}, model.viewRefreshPeriod))
+	}
+}
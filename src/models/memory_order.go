@@ -0,0 +1,83 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/typesutils"
+)
+
+// AllowMemoryOrder returns a new RecordSet which can be ordered by non stored
+// fields, such as non stored computed fields, with OrderBy.
+//
+// Since such fields cannot be sorted by the database, all the records matching
+// the search condition are loaded and their order fields computed in memory
+// before being sorted, so that limit and offset are applied afterwards. This is
+// much slower than a database order and must be kept for small sets: it panics
+// if more than maxRows records match the search condition.
+//
+// Orders on stored fields only are still executed by the database.
+func (rc *RecordCollection) AllowMemoryOrder(maxRows int) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.memOrderMaxRows = maxRows
+	return &rSet
+}
+
+// hasMemoryOrders returns true if this query is ordered by at least one
+// field which is not stored in the database and has no OrderAggregate.
+func (q *Query) hasMemoryOrders() bool {
+	for _, order := range q.orders {
+		if !q.recordSet.model.getRelatedFieldInfo(order.field).isStored() && !q.isAggregateOrder(order) {
+			return true
+		}
+	}
+	return false
+}
+
+// forceLoadWithMemoryOrders loads the given fields of the records of this
+// RecordCollection, sorting them in memory because its orders include non
+// stored fields. See AllowMemoryOrder.
+func (rc *RecordCollection) forceLoadWithMemoryOrders(fieldNames ...FieldName) *RecordCollection {
+	maxRows := rc.query.memOrderMaxRows
+	if maxRows <= 0 {
+		log.Panic("Ordering by non stored fields must be allowed with AllowMemoryOrder", "model", rc.model.name, "orders", rc.query.orders)
+	}
+	orders, limit, offset := rc.query.orders, rc.query.limit, rc.query.offset
+	for _, order := range orders {
+		if order.bySimilarity || order.byIds != nil {
+			log.Panic("Similarity and ids orders cannot be mixed with orders on non stored fields", "model", rc.model.name)
+		}
+	}
+	all := rc.clone().addRecordRuleConditions(rc.env.uid, security.Read)
+	all.query.orders = nil
+	all.query.limit = 0
+	all.query.offset = 0
+	if count := all.SearchCount(); count > maxRows {
+		log.Panic("Too many records to order in memory", "model", rc.model.name, "records", count, "maxRows", maxRows)
+	}
+	sorted := all.ForceLoad(fieldNames...).Sorted(func(rs1, rs2 RecordSet) bool {
+		for _, order := range orders {
+			val1, val2 := rs1.Collection().Get(order.field), rs2.Collection().Get(order.field)
+			if eq, _ := typesutils.AreEqual(val1, val2); eq {
+				continue
+			}
+			lt, err := typesutils.IsLessThan(val1, val2)
+			if err != nil {
+				log.Panic("Unable to order records in memory", "model", rc.model.name, "field", order.field, "error", err)
+			}
+			return lt != order.desc
+		}
+		return false
+	})
+	ids := sorted.ids
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+	return rc.withIds(ids)
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MigrationsTable is the name of the table in which
+// the versions of the applied migrations are stored.
+const MigrationsTable = "schema_migrations"
+
+// A Migration holds the SQL statements that bring the database schema
+// in line with the models registry (Up) and the statements that revert them (Down).
+type Migration struct {
+	Up   []string
+	Down []string
+}
+
+// IsEmpty returns true if this Migration has no statement to apply
+func (m Migration) IsEmpty() bool {
+	return len(m.Up) == 0
+}
+
+// A schemaRename is a model or field rename declared with RenameModel or RenameField.
+type schemaRename struct {
+	model   string
+	oldName string
+	newName string
+}
+
+// modelRenames and fieldRenames are the renames to apply when synchronizing the database
+var modelRenames, fieldRenames []schemaRename
+
+// RenameModel declares that the model oldName has been renamed newName, so that the
+// database synchronization renames the table of the model, its id sequence, indexes and
+// constraints instead of dropping the old table and creating a new one. Data and external
+// IDs of the records are therefore kept.
+//
+// The old table is the snake cased oldName. The rename only occurs if this table exists and
+// the table of newName does not. The many2many relation tables of the model whose name is
+// made of the names of the related models, such as "post_tag_rel", are renamed too, as well
+// as their columns named after the model (e.g. "tag_id"). Call RenameModel on relation
+// models with a custom name and RenameField on their fields if needed.
+//
+// RenameModel is meant to be called before bootstrap, typically in the PreInit
+// function of a module.
+func RenameModel(oldName, newName string) {
+	modelRenames = append(modelRenames, schemaRename{oldName: oldName, newName: newName})
+}
+
+// RenameField declares that the field oldName of the given model has been renamed newName,
+// so that the database synchronization renames its column, indexes and constraints instead
+// of dropping the old column and creating a new one.
+//
+// The old column is the snake cased oldName, with the suffix of the type of newName
+// (e.g. "_id" for many2one fields). The rename only occurs if this column exists and the
+// column of newName does not. Renames of models are applied before renames of fields,
+// so that model must be the new name if the model has been renamed too.
+//
+// RenameField is meant to be called before bootstrap, typically in the PreInit
+// function of a module.
+func RenameField(model, oldName, newName string) {
+	fieldRenames = append(fieldRenames, schemaRename{model: model, oldName: oldName, newName: newName})
+}
+
+// schemaMigration is the Migration being generated, if any.
+// When set, schema statements are recorded in it instead of being executed.
+var schemaMigration *Migration
+
+// GenerateMigration returns the Migration with the SQL statements that SyncDatabase
+// would run to synchronize the database schema with the models registry.
+// The database is not modified.
+//
+// Down statements are returned in the order in which they must be run.
+// Statements that cannot be reverted, such as dropping a table, have no Down statement.
+// Init methods of the models are not part of the migration.
+//
+// If tables or columns must be renamed (see RenameModel and RenameField), the returned
+// migration only holds the rename statements, since the other differences can only be
+// computed once they are applied. Generate a new migration after applying it.
+func GenerateMigration() Migration {
+	schemaMigration = new(Migration)
+	defer func() {
+		schemaMigration = nil
+	}()
+	syncDatabaseSchema()
+	res := *schemaMigration
+	for i, j := 0, len(res.Down)-1; i < j; i, j = i+1, j-1 {
+		res.Down[i], res.Down[j] = res.Down[j], res.Down[i]
+	}
+	return res
+}
+
+// executeSchemaStatement executes the given schema query with the given args.
+//
+// If a migration is being generated, the query is not executed but recorded in the
+// migration with its args inlined, and down is recorded as the statement that reverts it.
+// down may be empty if the query cannot be reverted.
+func executeSchemaStatement(query, down string, args ...interface{}) {
+	if schemaMigration == nil {
+		dbExecuteNoTx(query, args...)
+		return
+	}
+	schemaMigration.Up = append(schemaMigration.Up, formatSchemaStatement(inlineSchemaArgs(query, args)))
+	if down != "" {
+		schemaMigration.Down = append(schemaMigration.Down, formatSchemaStatement(down))
+	}
+}
+
+// inlineSchemaArgs returns the given query with its placeholders replaced
+// in order by the given args as SQL literals. Inserted literals are not
+// scanned for placeholders.
+func inlineSchemaArgs(query string, args []interface{}) string {
+	adapter := adapters[db.DriverName()]
+	var res strings.Builder
+	for _, arg := range args {
+		i := strings.Index(query, "?")
+		if i < 0 {
+			log.Panic("Too many arguments for schema statement", "query", query, "args", args)
+		}
+		res.WriteString(query[:i])
+		res.WriteString(adapter.quoteLiteral(arg))
+		query = query[i+1:]
+	}
+	res.WriteString(query)
+	return res.String()
+}
+
+// formatSchemaStatement removes the indentation and empty lines of the given query.
+func formatSchemaStatement(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// AppliedMigrations returns the versions of the migrations that have
+// already been applied to the database.
+//
+// The migrations table is created if it does not exist.
+func AppliedMigrations() map[string]bool {
+	adapter := adapters[db.DriverName()]
+	dbExecuteNoTx(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version varchar NOT NULL PRIMARY KEY,
+			applied_at timestamp without time zone NOT NULL
+		)
+	`, adapter.quoteTableName(MigrationsTable)))
+	var versions []string
+	dbSelectNoTx(&versions, fmt.Sprintf("SELECT version FROM %s", adapter.quoteTableName(MigrationsTable)))
+	res := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		res[v] = true
+	}
+	return res
+}
+
+// ApplyMigration runs the given SQL script and stores the given version
+// in the migrations table, both in a single transaction.
+//
+// It panics and rolls back the transaction if the script fails.
+func ApplyMigration(version, script string) {
+	adapter := adapters[db.DriverName()]
+	tx := db.MustBegin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	t := time.Now()
+	_, err := tx.Exec(script)
+	logSQLResult(err, t, script)
+	dbExecute(tx, fmt.Sprintf("INSERT INTO %s (version, applied_at) VALUES (?, ?)", adapter.quoteTableName(MigrationsTable)),
+		version, time.Now().UTC())
+	if err := tx.Commit(); err != nil {
+		log.Panic("Unable to commit migration", "version", version, "error", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/operator"
+)
+
+// SetNameSearchFields sets the fields of this model that are searched by
+// SearchByName instead of the Name field only, such as the name, email and
+// reference of a partner.
+//
+// With the contains operators, the searched string is split into words and
+// each word must match at least one of the fields, so that "John Paris"
+// matches a record with name "John Smith" and city "Paris". With the other
+// operators the whole string must match at least one of the fields, or none
+// of them for negative operators.
+func (m *Model) SetNameSearchFields(fields ...FieldName) {
+	m.nameSearchFields = fields
+}
+
+// checkNameSearchFields checks that the name search fields
+// of all models exist.
+func checkNameSearchFields() {
+	for _, model := range Registry.registryByName {
+		for _, field := range model.nameSearchFields {
+			if _, ok := model.fields.Get(field.JSON()); !ok {
+				log.Panic("Unknown name search field", "model", model.name, "field", field.Name())
+			}
+		}
+	}
+}
+
+// nameSearchCondition returns the condition used by SearchByName
+// to search records matching name with the given operator.
+func (rc *RecordCollection) nameSearchCondition(name string, op operator.Operator) *Condition {
+	fields := rc.model.nameSearchFields
+	if len(fields) == 0 {
+		return rc.Model().Field(rc.model.FieldName("Name")).AddOperator(op, name)
+	}
+	words := []string{name}
+	if (op == operator.Contains || op == operator.IContains) && len(strings.Fields(name)) > 0 {
+		words = strings.Fields(name)
+	}
+	var cond *Condition
+	for _, word := range words {
+		var wordCond *Condition
+		for _, field := range fields {
+			fCond := rc.Model().Field(field).AddOperator(op, word)
+			switch {
+			case wordCond == nil:
+				wordCond = fCond
+			case op.IsNegative():
+				wordCond = wordCond.AndCond(fCond)
+			default:
+				wordCond = wordCond.OrCond(fCond)
+			}
+		}
+		if cond == nil {
+			cond = wordCond
+			continue
+		}
+		cond = cond.AndCond(wordCond)
+	}
+	return cond
+}
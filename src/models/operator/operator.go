@@ -23,6 +23,12 @@ const (
 	In             Operator = "in"
 	NotIn          Operator = "not in"
 	ChildOf        Operator = "child_of"
+	ContainsAll    Operator = "contains_all"
+	ContainsAny    Operator = "contains_any"
+	Similar        Operator = "similar"
+	JSONContains   Operator = "json_contains"
+	IsEmpty        Operator = "is_empty"
+	IsSet          Operator = "is_set"
 )
 
 var allowedOperators = map[Operator]bool{
@@ -41,6 +47,12 @@ var allowedOperators = map[Operator]bool{
 	In:             true,
 	NotIn:          true,
 	ChildOf:        true,
+	ContainsAll:    true,
+	ContainsAny:    true,
+	Similar:        true,
+	JSONContains:   true,
+	IsEmpty:        true,
+	IsSet:          true,
 }
 
 var negativeOperators = map[Operator]bool{
@@ -60,8 +72,15 @@ var positiveOperators = map[Operator]bool{
 }
 
 var multiOperator = map[Operator]bool{
-	In:    true,
-	NotIn: true,
+	In:          true,
+	NotIn:       true,
+	ContainsAll: true,
+	ContainsAny: true,
+}
+
+var containmentOperators = map[Operator]bool{
+	ContainsAll: true,
+	ContainsAny: true,
 }
 
 // IsMulti returns true if the operator expects a array as arguments
@@ -69,6 +88,12 @@ func (o Operator) IsMulti() bool {
 	return multiOperator[o]
 }
 
+// IsContainment returns true if the operator checks which records
+// a one2many or many2many field contains.
+func (o Operator) IsContainment() bool {
+	return containmentOperators[o]
+}
+
 // IsValid returns true if o is a known operator.
 func (o Operator) IsValid() bool {
 	_, res := allowedOperators[o]
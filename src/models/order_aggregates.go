@@ -0,0 +1,90 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aggregateOrderAliasPrefix is the prefix of the column aliases of the
+// aggregates selected to order records by fields with an OrderAggregate.
+const aggregateOrderAliasPrefix = "hexya_order_"
+
+// An OrderAggregate declares that a non stored field, typically a non stored
+// computed field, is an aggregate of the related records of a one2many or
+// many2many field of its model, such as the number of open tasks of a project.
+//
+// Records can then be ordered by this field in the database, with a subquery
+// computing the aggregate for each record, instead of in memory with
+// AllowMemoryOrder. The aggregate must therefore give the same result as the
+// field's compute method.
+type OrderAggregate struct {
+	// Field is the one2many or many2many field whose related records are aggregated
+	Field FieldName
+	// Function is the aggregate function, such as AggregateCount
+	Function AggregateFunction
+	// Aggregated is the stored field of the related model that is aggregated.
+	// It defaults to ID.
+	Aggregated FieldName
+	// Condition filters the related records that are aggregated. All related
+	// records are aggregated if it is nil.
+	Condition Conditioner
+}
+
+// isAggregateOrder returns true if the given order is on a non stored field
+// of this query's model that has an OrderAggregate.
+//
+// Stored fields, such as stored computed fields, are always ordered by their
+// column which is much faster.
+func (q *Query) isAggregateOrder(order orderPredicate) bool {
+	if order.bySimilarity || order.byIds != nil || strings.Contains(order.field.JSON(), ExprSep) {
+		return false
+	}
+	fi := q.recordSet.model.getRelatedFieldInfo(order.field)
+	return !fi.isStored() && fi.orderAggregate != nil
+}
+
+// aggregateOrdersSQL returns the SQL select expressions of the aggregates of
+// the orders of this query on fields with an OrderAggregate, each aliased with
+// aggregateOrderAlias, and their arguments.
+//
+// Each aggregate is a correlated subquery on the related records the current
+// user can read. Sums of records without related records are 0.
+func (q *Query) aggregateOrdersSQL() (string, SQLParams) {
+	var (
+		resSlice []string
+		args     SQLParams
+	)
+	for _, order := range q.orders {
+		if !q.isAggregateOrder(order) {
+			continue
+		}
+		fi := q.recordSet.model.getRelatedFieldInfo(order.field)
+		oa := fi.orderAggregate
+		aggregated := oa.Aggregated
+		if aggregated == nil {
+			aggregated = ID
+		}
+		cond := newCondition()
+		if oa.Condition != nil {
+			cond = oa.Condition.Underlying()
+		}
+		relFi := q.recordSet.model.fields.MustGet(oa.Field.JSON())
+		fromSQL, holderColumn, aggSQL, aggArgs := q.relationAggregateSQL(relFi, oa.Function, aggregated, cond)
+		if oa.Function == AggregateSum {
+			aggSQL = fmt.Sprintf("COALESCE(%s, 0)", aggSQL)
+		}
+		resSlice = append(resSlice, fmt.Sprintf("(SELECT %s FROM %s AND %s = %s.id) AS %s",
+			aggSQL, fromSQL, holderColumn, q.thisTable(), aggregateOrderAlias(order)))
+		args = args.Extend(aggArgs)
+	}
+	return strings.Join(resSlice, ", "), args
+}
+
+// aggregateOrderAlias returns the column alias of the aggregate
+// selected to order records by the given order.
+func aggregateOrderAlias(order orderPredicate) string {
+	return aggregateOrderAliasPrefix + order.field.JSON()
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strings"
+)
+
+// PlannerHints returns a new RecordSet whose queries are prefixed with the
+// given query planner hints, such as "IndexScan(sale_order sale_order_partner_id_index)".
+// Tables are referred to by their table name, which is also their alias in
+// the queries.
+//
+// This is a last resort, backend specific escape hatch for queries whose plan
+// has been profiled to be wrong. On PostgreSQL, hints are given in a comment
+// that is read by the pg_hint_plan extension and ignored without it. Hints
+// are ignored by databases that do not support them. They apply to the
+// queries of this RecordSet only, including counts and aggregates.
+//
+// It panics if a hint contains a comment delimiter.
+func (rc *RecordCollection) PlannerHints(hints ...string) *RecordCollection {
+	for _, hint := range hints {
+		if strings.Contains(hint, "/*") || strings.Contains(hint, "*/") {
+			log.Panic("Planner hints cannot contain comment delimiters", "model", rc.model.name, "hint", hint)
+		}
+	}
+	rSet := rc.clone()
+	rSet.query.plannerHints = append(append([]string(nil), rc.query.plannerHints...), hints...)
+	return rSet
+}
+
+// withPlannerHints returns the given SQL query prefixed
+// with the planner hints of this Query.
+func (q *Query) withPlannerHints(sql string) string {
+	if len(q.plannerHints) == 0 {
+		return sql
+	}
+	return adapters[db.DriverName()].plannerHintsSQL(q.plannerHints) + sql
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"math"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+// A PrecisionGetter returns the number of digits after the decimal point to use
+// for the given precision usage (e.g. "Product Price" or "Account").
+//
+// The second returned value must be false if the usage is unknown.
+type PrecisionGetter func(env Environment, usage string) (int8, bool)
+
+var precisionGetter PrecisionGetter
+
+// RegisterPrecisionGetter sets the function used by the ORM to resolve
+// the precision usages of float fields.
+//
+// Only one PrecisionGetter can be registered. Registering a new one replaces the previous one.
+func RegisterPrecisionGetter(pg PrecisionGetter) {
+	precisionGetter = pg
+}
+
+// roundingPrecision returns the precision at which values of the given
+// float field must be rounded (e.g. 0.01) in the given environment.
+//
+// The second returned value is false if values of this field must not be rounded.
+func roundingPrecision(env Environment, fi *Field) (float64, bool) {
+	if fi.fieldType != fieldtype.Float {
+		return 0, false
+	}
+	if fi.precision != "" {
+		if precisionGetter == nil {
+			log.Panic("Field has a precision usage but no precision getter is registered", "model", fi.model.name, "field", fi.name, "precision", fi.precision)
+		}
+		digits, ok := precisionGetter(env, fi.precision)
+		if !ok {
+			log.Panic("Unknown precision usage", "model", fi.model.name, "field", fi.name, "precision", fi.precision)
+		}
+		return math.Pow10(int(-digits)), true
+	}
+	if fi.digits != (nbutils.Digits{}) {
+		return fi.digits.ToPrecision(), true
+	}
+	return 0, false
+}
+
+// roundFloatValues rounds in place the float values of the given FieldMap
+// according to the precision of their field.
+func (rc *RecordCollection) roundFloatValues(fMap FieldMap) {
+	for f, v := range fMap {
+		fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(f))
+		prec, ok := roundingPrecision(*rc.env, fi)
+		if !ok {
+			continue
+		}
+		switch val := v.(type) {
+		case float64:
+			fMap[f] = nbutils.Round(val, prec)
+		case float32:
+			fMap[f] = float32(nbutils.Round(float64(val), prec))
+		}
+	}
+}
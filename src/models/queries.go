@@ -15,6 +15,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
@@ -22,12 +23,22 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
 
 const maxSQLidentifierLength = 63
 
+// zeroLimit is the limit of a Query whose RecordSet has been limited to 0
+// records with Limit(0). Such a Query is never executed. The limit field
+// of a Query which has no limit is 0.
+const zeroLimit = -1
+
+// SimilarityThreshold is the minimum trigram similarity, between 0 and 1,
+// of the values matching a Similar condition with the searched text.
+var SimilarityThreshold = 0.3
+
 // An SQLParams is a list of parameters that are passed to the
 // DB server with the query string and that will be used in the
 // placeholders.
@@ -43,24 +54,91 @@ func (p SQLParams) Extend(p2 SQLParams) SQLParams {
 }
 
 // An orderPredicate in a query. e.g. "name ASC".
+//
+// If bySimilarity is true, records are ordered by the trigram
+// similarity of field with the similarTo text. If byIds is set,
+// records are ordered by the position of field in byIds.
 type orderPredicate struct {
-	field FieldName
-	desc  bool
+	field        FieldName
+	desc         bool
+	nulls        string
+	bySimilarity bool
+	similarTo    string
+	byIds        []int64
+}
+
+// sqlExpression returns the SQL expression of this orderPredicate
+// and its parameters given the SQL expression of its field.
+func (o orderPredicate) sqlExpression(fieldSQL string) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	switch {
+	case o.bySimilarity:
+		return adapter.similaritySQL(fieldSQL, "?"), SQLParams{o.similarTo}
+	case o.byIds != nil:
+		return adapter.positionSQL(fieldSQL, o.byIds), nil
+	}
+	return fieldSQL, nil
+}
+
+// sqlDirection returns the SQL direction of this orderPredicate
+// to be appended to its field expression, e.g. " DESC NULLS LAST".
+func (o orderPredicate) sqlDirection() string {
+	var res string
+	if o.desc {
+		res += " DESC"
+	}
+	if o.nulls != "" {
+		res += " NULLS " + o.nulls
+	}
+	return res
+}
+
+// A lockMode defines how the rows selected by a query are locked
+type lockMode int8
+
+// Available lock modes
+const (
+	noLock lockMode = iota
+	lockForUpdate
+	lockForUpdateSkipLocked
+	lockForUpdateNoWait
+)
+
+// sqlClause returns the locking clause of this lockMode
+// for the table with the given alias.
+func (l lockMode) sqlClause(alias string) string {
+	switch l {
+	case lockForUpdate:
+		return fmt.Sprintf("FOR UPDATE OF %s", alias)
+	case lockForUpdateSkipLocked:
+		return fmt.Sprintf("FOR UPDATE OF %s SKIP LOCKED", alias)
+	case lockForUpdateNoWait:
+		return fmt.Sprintf("FOR UPDATE OF %s NOWAIT", alias)
+	}
+	return ""
 }
 
 // A Query defines the common part an SQL Query, i.e. all that come
 // after the FROM keyword.
 type Query struct {
-	recordSet *RecordCollection
-	cond      *Condition
-	ctxCond   *Condition
-	fetchAll  bool
-	limit     int
-	offset    int
-	groups    []FieldName
-	ctxGroups []FieldName
-	orders    []orderPredicate
-	ctxOrders []orderPredicate
+	recordSet       *RecordCollection
+	cond            *Condition
+	ctxCond         *Condition
+	fetchAll        bool
+	limit           int
+	offset          int
+	groups          []FieldName
+	ctxGroups       []FieldName
+	orders          []orderPredicate
+	ctxOrders       []orderPredicate
+	distinctOn      []FieldName
+	partitionBy     []FieldName
+	partitionLimit  int
+	onConflict      []FieldName
+	lock            lockMode
+	memOrderMaxRows int
+	sample          tableSample
+	plannerHints    []string
 }
 
 // clone returns a pointer to a deep copy of this Query
@@ -126,7 +204,10 @@ func (q *Query) conditionSQLClause(c *Condition) (string, SQLParams) {
 		switch {
 		case first:
 			sql = vSQL
-			if p.isNot {
+			switch {
+			case p.isNot && p.isCond:
+				sql = fmt.Sprintf("NOT (%s)", sql)
+			case p.isNot:
 				sql = "NOT " + sql
 			}
 		case p.isCond:
@@ -145,8 +226,29 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 	if p.isCond {
 		return q.conditionSQLClause(p.cond)
 	}
+	if p.rawSQL != "" {
+		return fmt.Sprintf("(%s)", strings.Replace(p.rawSQL, RawSQLTable, q.thisTable(), -1)), p.arg.(SQLParams)
+	}
+	if p.aggregate != nil {
+		return q.aggregateSQLClause(p)
+	}
+	if p.quantifier != "" {
+		return q.quantifiedSQLClause(p)
+	}
+	if p.operator.IsContainment() {
+		return q.containmentSQLClause(p)
+	}
+	if p.isRelationMembership(q.recordSet.model) {
+		return q.membershipSQLClause(p)
+	}
 
 	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+	if sub, ok := p.arg.(*RecordCollection); ok && sub.isSubSearchOf(fi) {
+		return q.subSearchSQLClause(p, sub)
+	}
+	if uuids, ok := uuidKeyArg(fi, p.operator, p.arg); ok {
+		return q.uuidKeySQLClause(p, fi, uuids)
+	}
 	if fi.fieldType.IsFKRelationType() {
 		// If we have a relation type with a 0 as foreign key, we substitute for nil
 		if valInt, err := nbutils.CastToInteger(p.arg); err == nil && valInt == 0 {
@@ -159,10 +261,29 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		args SQLParams
 	)
 	field, _, _ := q.joinedFieldExpression(p.exprs, false, 0)
+	if p.datePart != "" {
+		field = q.datePartSQL(field, fi, p.datePart)
+	}
+	if p.empty {
+		return emptySQLClause(field, p.operator, fi, p.datePart != "")
+	}
 
 	adapter := adapters[db.DriverName()]
 	arg := q.evaluateConditionArgFunctions(p)
+	if translator, ok := customOperators[p.operator]; ok {
+		return customOperatorSQLClause(translator, field, arg)
+	}
+	if p.operator == operator.JSONContains {
+		return jsonContainsSQLClause(field, fi, arg)
+	}
 	opSql, arg := adapter.operatorSQL(p.operator, arg)
+	if p.operator == operator.Similar {
+		return similarSQLClause(field, fi, arg)
+	}
+
+	if p.strict {
+		return strictSQLClause(field, opSql, p.operator, arg)
+	}
 
 	var isNull bool
 	switch v := arg.(type) {
@@ -181,6 +302,14 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		return nullSQLClause(field, p.operator, fi)
 	}
 
+	if fi.unaccent {
+		switch p.operator {
+		case operator.IContains, operator.NotIContains, operator.ILike:
+			// Accents are ignored both in the column and in the searched value
+			field = adapter.unaccentSQL(field)
+			opSql = strings.Replace(opSql, "?", adapter.unaccentSQL("?"), 1)
+		}
+	}
 	sql = fmt.Sprintf(`%s %s`, field, opSql)
 	if p.operator.IsNegative() {
 		sql = fmt.Sprintf(`(%s IS NULL OR %s)`, field, sql)
@@ -190,6 +319,282 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 	return sql, args
 }
 
+// quantifiedSQLClause returns the sql WHERE clause and arguments for the given
+// predicate with an AnyOf or AllOf quantifier.
+//
+// AnyOf predicates are translated as "id IN (related records matching the condition)"
+// and AllOf predicates as "id NOT IN (related records not matching the condition)".
+// AnyOf predicates on a many2one field are translated as "fk IN (related records
+// matching the condition)". Record rules of the related model apply to the related records.
+func (q *Query) quantifiedSQLClause(p predicate) (string, SQLParams) {
+	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+	switch {
+	case fi.fieldType.Is2ManyRelationType():
+	case fi.fieldType == fieldtype.Many2One:
+		if p.quantifier == allQuantifier {
+			log.Panic("AllOf conditions can only be used on one2many or many2many fields",
+				"model", q.recordSet.model.name, "field", joinFieldNames(p.exprs, ExprSep))
+		}
+	default:
+		log.Panic("AnyOf conditions can only be used on one2many, many2many or many2one fields",
+			"model", q.recordSet.model.name, "field", joinFieldNames(p.exprs, ExprSep))
+	}
+	if p.quantifier == allQuantifier && p.subCond.IsEmpty() {
+		return "TRUE", SQLParams{}
+	}
+	field, _, _ := q.joinedFieldExpression(append(p.exprs[:len(p.exprs)-1:len(p.exprs)-1], ID), false, 0)
+	if fi.fieldType == fieldtype.Many2One {
+		field, _, _ = q.joinedFieldExpression(p.exprs, false, 0)
+	}
+	relModel := fi.relatedModel
+	var column FieldName = ID
+	cond := newCondition()
+	if fi.fieldType == fieldtype.One2Many {
+		// NOT IN never matches if the subquery returns NULL values
+		column = relModel.FieldName(fi.reverseFK)
+		cond = relModel.Field(column).IsNotNull()
+	}
+	sqlOp := "IN"
+	if p.quantifier == allQuantifier {
+		sqlOp = "NOT IN"
+		cond = cond.AndNotCond(p.subCond)
+	} else {
+		cond = cond.AndCond(p.subCond)
+	}
+	related := q.recordSet.env.Pool(relModel.name).Search(cond).addRecordRuleConditions(q.recordSet.env.uid, security.Read)
+	addNameSearchesToCondition(related.model, related.query.cond)
+	related.query.ctxCond = related.conditionContextsCondition(false)
+	related = related.substituteRelatedInQuery()
+	subQuery, args := related.query.selectColumnQuery(column)
+	if fi.fieldType == fieldtype.Many2Many {
+		adapter := adapters[db.DriverName()]
+		subQuery = fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)", fi.m2mOurField.json,
+			adapter.quoteTableName(fi.m2mRelModel.tableName), fi.m2mTheirField.json, subQuery)
+	}
+	return fmt.Sprintf("%s %s (%s)", field, sqlOp, subQuery), args
+}
+
+// containmentSQLClause returns the sql WHERE clause and arguments for the given
+// predicate with a ContainsAll or ContainsAny operator.
+//
+// Both are translated as "id IN (records holding the given related records)".
+// For ContainsAll, the holding records are grouped and only kept if they hold as
+// many distinct related records as given.
+func (q *Query) containmentSQLClause(p predicate) (string, SQLParams) {
+	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+	if !fi.fieldType.Is2ManyRelationType() {
+		log.Panic("ContainsAll and ContainsAny conditions can only be used on one2many or many2many fields",
+			"model", q.recordSet.model.name, "field", joinFieldNames(p.exprs, ExprSep))
+	}
+	ids := distinctIds(q.evaluateConditionArgFunctions(p))
+	if len(ids) == 0 {
+		if p.operator == operator.ContainsAll {
+			return "TRUE", SQLParams{}
+		}
+		return "FALSE", SQLParams{}
+	}
+	adapter := adapters[db.DriverName()]
+	field, _, _ := q.joinedFieldExpression(append(p.exprs[:len(p.exprs)-1:len(p.exprs)-1], ID), false, 0)
+	var ourColumn, theirColumn, tableName string
+	switch fi.fieldType {
+	case fieldtype.Many2Many:
+		ourColumn, theirColumn, tableName = fi.m2mOurField.json, fi.m2mTheirField.json, fi.m2mRelModel.tableName
+	case fieldtype.One2Many:
+		ourColumn, theirColumn, tableName = fi.relatedModel.fields.MustGet(fi.reverseFK).json, "id", fi.relatedModel.tableName
+	}
+	subQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (?)", ourColumn, adapter.quoteTableName(tableName), theirColumn)
+	args := SQLParams{ids}
+	if p.operator == operator.ContainsAll {
+		subQuery = fmt.Sprintf("%s GROUP BY %s HAVING COUNT(DISTINCT %s) = ?", subQuery, ourColumn, theirColumn)
+		args = append(args, len(ids))
+	}
+	return fmt.Sprintf("%s IN (%s)", field, subQuery), args
+}
+
+// membershipSQLClause returns the sql WHERE clause and arguments for the
+// given predicate on the related records of a one2many or many2many field.
+//
+// Equals and In predicates are translated as "id IN (records holding one of
+// the given related records)" and NotEquals and NotIn predicates as "id NOT IN
+// (records holding one of the given related records)". A null argument stands
+// for any related record, so that "Tags = nil" gives the records without tags.
+//
+// Subqueries never return NULL values so that NOT IN and negated conditions
+// behave as expected. If the relation field is reached through a many2one
+// that is not set, the predicate is true for negative operators only.
+func (q *Query) membershipSQLClause(p predicate) (string, SQLParams) {
+	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+	adapter := adapters[db.DriverName()]
+	field, _, _ := q.joinedFieldExpression(append(p.exprs[:len(p.exprs)-1:len(p.exprs)-1], ID), false, 0)
+	var ourColumn, theirColumn, tableName string
+	switch fi.fieldType {
+	case fieldtype.Many2Many:
+		ourColumn, theirColumn, tableName = fi.m2mOurField.json, fi.m2mTheirField.json, fi.m2mRelModel.tableName
+	case fieldtype.One2Many:
+		ourColumn, theirColumn, tableName = fi.relatedModel.fields.MustGet(fi.reverseFK).json, "id", fi.relatedModel.tableName
+	}
+	subQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL", ourColumn, adapter.quoteTableName(tableName), ourColumn)
+	var ids []int64
+	switch arg := q.evaluateConditionArgFunctions(p).(type) {
+	case bool:
+		if arg {
+			log.Panic("Only false can be used as a boolean argument on a relation field",
+				"model", q.recordSet.model.name, "field", joinFieldNames(p.exprs, ExprSep))
+		}
+	default:
+		if valInt, err := nbutils.CastToInteger(arg); err != nil || valInt != 0 {
+			ids = distinctIds(arg)
+		}
+	}
+	var args SQLParams
+	isNull := len(ids) == 0
+	if !isNull {
+		subQuery = fmt.Sprintf("%s AND %s IN (?)", subQuery, theirColumn)
+		args = SQLParams{ids}
+	}
+	var negative bool
+	switch p.operator {
+	case operator.NotEquals, operator.NotIn:
+		negative = true
+	}
+	if isNull {
+		// field = nil => no related records at all
+		negative = !negative
+	}
+	switch {
+	case negative && len(p.exprs) > 1:
+		return fmt.Sprintf("(%s IS NULL OR %s NOT IN (%s))", field, field, subQuery), args
+	case negative:
+		return fmt.Sprintf("%s NOT IN (%s)", field, subQuery), args
+	}
+	return fmt.Sprintf("%s IN (%s)", field, subQuery), args
+}
+
+// subSearchSQLClause returns the sql WHERE clause and arguments for the given
+// In or NotIn predicate whose argument is the given search RecordCollection.
+//
+// It is translated as "field IN (ids of the records matching the search)".
+// Record rules of the search environment's user apply to the searched records.
+func (q *Query) subSearchSQLClause(p predicate, sub *RecordCollection) (string, SQLParams) {
+	related := sub.addRecordRuleConditions(sub.env.uid, security.Read)
+	addNameSearchesToCondition(related.model, related.query.cond)
+	related.query.ctxCond = related.conditionContextsCondition(false)
+	related = related.substituteRelatedInQuery()
+	subQuery, args := related.query.selectColumnQuery(ID)
+	field, _, _ := q.joinedFieldExpression(p.exprs, false, 0)
+	if p.operator == operator.NotIn {
+		return fmt.Sprintf("(%s IS NULL OR %s NOT IN (%s))", field, field, subQuery), args
+	}
+	return fmt.Sprintf("%s IN (%s)", field, subQuery), args
+}
+
+// distinctIds returns the distinct ids of the given slice of ids
+// or single id, in their original order.
+func distinctIds(arg interface{}) []int64 {
+	var ids []int64
+	switch a := arg.(type) {
+	case nil:
+	case []int64:
+		ids = a
+	default:
+		val := reflect.ValueOf(arg)
+		if val.Kind() != reflect.Slice {
+			val = reflect.ValueOf([]interface{}{arg})
+		}
+		for i := 0; i < val.Len(); i++ {
+			id, err := nbutils.CastToInteger(val.Index(i).Interface())
+			if err != nil {
+				log.Panic("Invalid id in containment condition", "value", val.Index(i).Interface(), "error", err)
+			}
+			ids = append(ids, id)
+		}
+	}
+	res := make([]int64, 0, len(ids))
+	seen := make(map[int64]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		res = append(res, id)
+	}
+	return res
+}
+
+// similarSQLClause returns the sql string and arguments for searching
+// the given field with the Similar operator.
+func similarSQLClause(field string, fi *Field, arg interface{}) (string, SQLParams) {
+	checkSimilarityField(fi)
+	sql := fmt.Sprintf("%s > %g", adapters[db.DriverName()].similaritySQL(field, "?"), SimilarityThreshold)
+	return sql, SQLParams{arg}
+}
+
+// checkSimilarityField panics if the given field cannot be
+// searched or ordered by trigram similarity.
+func checkSimilarityField(fi *Field) {
+	if adapters[db.DriverName()].similaritySQL("", "") == "" {
+		log.Panic("Similarity searches are not supported by this database", "driver", db.DriverName())
+	}
+	if !fi.trigram || !fi.isStored() {
+		log.Panic("Similarity searches are only allowed on stored fields with the Trigram option",
+			"model", fi.model.name, "field", fi.name)
+	}
+}
+
+// jsonContainsSQLClause returns the sql string and arguments for searching
+// the given field with the JSONContains operator.
+func jsonContainsSQLClause(field string, fi *Field, arg interface{}) (string, SQLParams) {
+	if !fi.holdsJSON() {
+		log.Panic("JSON containment searches are only allowed on stored char or text fields with the JSONIndex option",
+			"model", fi.model.name, "field", fi.name, "type", fi.fieldType)
+	}
+	sql := adapters[db.DriverName()].jsonContainsSQL(field, "?")
+	if sql == "" {
+		log.Panic("JSON containment searches are not supported by this database", "driver", db.DriverName())
+	}
+	if arg == nil {
+		log.Panic("JSON containment searches need a value", "model", fi.model.name, "field", fi.name)
+	}
+	data, err := json.Marshal(arg)
+	if err != nil {
+		log.Panic("Unable to marshal JSON containment value", "model", fi.model.name, "field", fi.name, "error", err)
+	}
+	return sql, SQLParams{string(data)}
+}
+
+// strictSQLClause returns the sql string and arguments for comparing the given
+// field with arg as is, without considering empty values as NULL.
+func strictSQLClause(field, opSQL string, op operator.Operator, arg interface{}) (string, SQLParams) {
+	if arg != nil {
+		return fmt.Sprintf(`%s %s`, field, opSQL), SQLParams{arg}
+	}
+	switch op {
+	case operator.Equals:
+		return fmt.Sprintf(`%s IS NULL`, field), nil
+	case operator.NotEquals:
+		return fmt.Sprintf(`%s IS NOT NULL`, field), nil
+	}
+	log.Panic("Null argument can only be used with = and != operators", "operator", op)
+	return "", nil
+}
+
+// emptySQLClause returns the sql string and arguments for testing whether the given field
+// is empty with the Equals operator, or set with the NotEquals operator. Empty strings and
+// false booleans are empty values, but zero numbers and dates, as well as date parts, are not.
+func emptySQLClause(field string, op operator.Operator, fi *Field, datePart bool) (string, SQLParams) {
+	switch {
+	case datePart:
+	case fi.isRelationField():
+		return nullSQLClause(field, op, fi)
+	default:
+		switch fi.fieldType {
+		case fieldtype.Char, fieldtype.Text, fieldtype.HTML, fieldtype.Selection, fieldtype.Binary, fieldtype.Boolean:
+			return nullSQLClause(field, op, fi)
+		}
+	}
+	return strictSQLClause(field, "", op, nil)
+}
+
 //nullSQLClause returns the sql string and arguments for searching the given field with an empty argument
 func nullSQLClause(field string, op operator.Operator, fi *Field) (string, SQLParams) {
 	var (
@@ -219,7 +624,10 @@ func nullSQLClause(field string, op operator.Operator, fi *Field) (string, SQLPa
 // of this Query
 func (q *Query) sqlLimitOffsetClause() string {
 	var res string
-	if q.limit > 0 {
+	switch {
+	case q.limit == zeroLimit:
+		res = `LIMIT 0 `
+	case q.limit > 0:
 		res = fmt.Sprintf(`LIMIT %d `, q.limit)
 	}
 	if q.offset > 0 {
@@ -228,20 +636,35 @@ func (q *Query) sqlLimitOffsetClause() string {
 	return res
 }
 
-// sqlOrderByClause returns the sql string for the ORDER BY clause
+// sqlOrderByClause returns the sql string and parameters for the ORDER BY clause
 // of this Query
-func (q *Query) sqlOrderByClause() string {
+func (q *Query) sqlOrderByClause() (string, SQLParams) {
+	return q.sqlQualifiedOrderByClause("")
+}
+
+// sqlQualifiedOrderByClause returns the sql string and parameters for the ORDER BY
+// clause of this Query, with the field aliases qualified by the given table alias
+// if it is not empty.
+func (q *Query) sqlQualifiedOrderByClause(table string) (string, SQLParams) {
 	resSlice := make([]string, len(q.orders))
+	var args SQLParams
 	for i, order := range q.orders {
-		_, _, resSlice[i] = q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
-		if order.desc {
-			resSlice[i] += " DESC"
+		if q.isAggregateOrder(order) {
+			resSlice[i] = aggregateOrderAlias(order)
+		} else {
+			_, _, resSlice[i] = q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
+		}
+		if table != "" {
+			resSlice[i] = fmt.Sprintf("%s.%s", table, resSlice[i])
 		}
+		expr, exprArgs := order.sqlExpression(resSlice[i])
+		resSlice[i] = expr + order.sqlDirection()
+		args = args.Extend(exprArgs)
 	}
 	if len(resSlice) == 0 {
-		return ""
+		return "", nil
 	}
-	return fmt.Sprintf("ORDER BY %s", strings.Join(resSlice, ", "))
+	return fmt.Sprintf("ORDER BY %s", strings.Join(resSlice, ", ")), args
 }
 
 // sqlCtxOrderByClause returns the sql string for the ORDER BY clause of the ctx fields
@@ -250,9 +673,7 @@ func (q *Query) sqlCtxOrderBy() string {
 	resSlice := make([]string, len(q.ctxOrders))
 	for i, order := range q.ctxOrders {
 		resSlice[i], _, _ = q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), false, 0)
-		if order.desc {
-			resSlice[i] += " DESC"
-		}
+		resSlice[i] += order.sqlDirection()
 	}
 	if len(resSlice) == 0 {
 		return ""
@@ -260,30 +681,68 @@ func (q *Query) sqlCtxOrderBy() string {
 	return fmt.Sprintf("%s", strings.Join(resSlice, ", "))
 }
 
-// sqlOrderByClauseForGroupBy returns the sql string for the ORDER BY clause
-// of this Query, which should be a group by clause.
-func (q *Query) sqlOrderByClauseForGroupBy(aggFncts map[string]string) string {
-	resSlice := make([]string, len(q.orders))
+// sqlFanOutOrderBy returns the sql string for ordering the joined rows of each
+// record by the orders of this Query that go through a x2many relation.
+//
+// Since only one row is kept for each record, this makes sure that the row
+// holding the first related value in the requested order is the one kept.
+func (q *Query) sqlFanOutOrderBy() (string, SQLParams) {
+	var (
+		resSlice []string
+		args     SQLParams
+	)
+	for _, order := range q.orders {
+		exprs := splitFieldNames(order.field, ExprSep)
+		if !q.isFanOutPath(exprs) {
+			continue
+		}
+		fieldSQL, _, _ := q.joinedFieldExpression(exprs, false, 0)
+		expr, exprArgs := order.sqlExpression(fieldSQL)
+		resSlice = append(resSlice, expr+order.sqlDirection())
+		args = args.Extend(exprArgs)
+	}
+	return strings.Join(resSlice, ", "), args
+}
+
+// isFanOutPath returns true if the given path goes through a x2many
+// relation, so that it may match several rows for a single record.
+func (q *Query) isFanOutPath(exprs []FieldName) bool {
+	for i := range exprs[:len(exprs)-1] {
+		fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(exprs[:i+1], ExprSep))
+		if fi.fieldType.Is2ManyRelationType() {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlOrderByClauseForGroupBy returns the sql string and parameters for the
+// ORDER BY clause of this Query, which should be a group by clause.
+func (q *Query) sqlOrderByClauseForGroupBy(aggFncts map[string]string) (string, SQLParams) {
+	var (
+		resSlice []string
+		args     SQLParams
+	)
 	for i, order := range q.orders {
+		if q.isAggregateOrder(order) {
+			// Aggregates of related records cannot order groups
+			continue
+		}
 		aggFnct := aggFncts[order.field.JSON()]
 		if aggFnct == "" {
 			_, _, jfe := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
-			if order.desc {
-				jfe += " DESC"
-			}
-			resSlice[i] = jfe
+			expr, exprArgs := order.sqlExpression(jfe)
+			resSlice = append(resSlice, expr+order.sqlDirection())
+			args = args.Extend(exprArgs)
 			continue
 		}
 		_, _, jfe := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
-		resSlice[i] = fmt.Sprintf("%s(%s)", aggFnct, jfe)
-		if order.desc {
-			resSlice[i] += " DESC"
-		}
+		resSlice = append(resSlice, fmt.Sprintf("%s(%s)", aggFnct, jfe)+order.sqlDirection())
 	}
 	if len(resSlice) == 0 {
-		return ""
+		return "", nil
 	}
-	return fmt.Sprintf("ORDER BY %s", strings.Join(resSlice, ", "))
+	return fmt.Sprintf("ORDER BY %s", strings.Join(resSlice, ", ")), args
 }
 
 // sqlGroupByClause returns the sql string for the GROUP BY clause
@@ -358,16 +817,37 @@ func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
 	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
 	fields := strings.Join(cols, ", ")
 	values := "?" + strings.Repeat(", ?", i-1)
-	sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", tableName, fields, values)
+	sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)%s", tableName, fields, values, q.sqlOnConflictClause())
 	return sql, vals
 }
 
+// sqlOnConflictClause returns the ON CONFLICT and RETURNING clauses of an
+// insert query. If this Query has no conflict target, only the id of the
+// inserted row is returned.
+//
+// Otherwise, the conflicting row is left unchanged but locked, and its id is
+// returned with inserted set to false, so that it can be updated with Write.
+func (q *Query) sqlOnConflictClause() string {
+	if len(q.onConflict) == 0 {
+		return " RETURNING id"
+	}
+	target := make([]string, len(q.onConflict))
+	for i, f := range q.onConflict {
+		target[i] = f.JSON()
+	}
+	// DO NOTHING would not return the id of the existing row
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s = EXCLUDED.%s RETURNING id, (xmax = 0) AS inserted",
+		strings.Join(target, ", "), target[0], target[0])
+}
+
 // countQuery returns the SQL query string and parameters to count
 // the rows pointed at by this Query object.
 func (q *Query) countQuery() (string, SQLParams) {
-	sql, args, _ := q.selectQuery([]FieldName{ID})
+	unhinted := *q
+	unhinted.plannerHints = nil
+	sql, args, _ := unhinted.selectQuery([]FieldName{ID})
 	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM (%s) foo`, sql)
-	return countQuery, args
+	return q.withPlannerHints(countQuery), args
 }
 
 // selectCommonQuery returns the SQL query string and parameters to retrieve
@@ -387,16 +867,41 @@ func (q *Query) selectCommonQuery(fields []FieldName) (string, SQLParams, map[st
 	tablesSQL, joinsMap := q.tablesSQL(allExprs)
 	// Where clause and args
 	whereSQL, args := q.sqlWhereClause(true)
+	if aggOrdersSQL, aggArgs := q.aggregateOrdersSQL(); aggOrdersSQL != "" {
+		// Aggregates are selected before the where clause
+		fieldsSQL += ", " + aggOrdersSQL
+		args = aggArgs.Extend(args)
+	}
 	ctxOrderSQL := q.sqlCtxOrderBy()
 	if ctxOrderSQL != "" {
 		ctxOrderSQL = fmt.Sprintf(", %s", ctxOrderSQL)
 	}
+	if fanOutOrderSQL, fanOutArgs := q.sqlFanOutOrderBy(); fanOutOrderSQL != "" {
+		ctxOrderSQL += fmt.Sprintf(", %s", fanOutOrderSQL)
+		args = args.Extend(fanOutArgs)
+	}
 	selQuery := fmt.Sprintf(`SELECT DISTINCT ON (%s.id) %s FROM %s %s ORDER BY %s.id %s`,
 		q.thisTable(), fieldsSQL, tablesSQL, whereSQL, q.thisTable(), ctxOrderSQL)
 	selQuery = strutils.Substitute(selQuery, joinsMap)
 	return selQuery, args, fieldSubsts
 }
 
+// selectColumnQuery returns the SQL query string and parameters to retrieve
+// the given field of the rows pointed at by this Query object, with neither
+// order nor limit. It is meant to be used as subquery.
+//
+// The context condition of the query is applied, but is expected to only
+// filter the contexted fields used in the query condition.
+func (q *Query) selectColumnQuery(field FieldName) (string, SQLParams) {
+	fieldExprs, allExprs := q.selectData([]FieldName{field}, false)
+	allExprs = append(allExprs, q.ctxCond.getAllExpressions(q.recordSet.model)...)
+	fieldSQL, _, _ := q.joinedFieldExpression(fieldExprs[0], false, 0)
+	tablesSQL, joinsMap := q.tablesSQL(allExprs)
+	whereSQL, args := q.sqlWhereClause(true)
+	selQuery := fmt.Sprintf(`SELECT %s FROM %s %s`, fieldSQL, tablesSQL, whereSQL)
+	return strutils.Substitute(selQuery, joinsMap), args
+}
+
 // selectQuery returns the SQL query string and parameters to retrieve
 // the rows pointed at by this Query object.
 // fields is the list of fields to retrieve.
@@ -410,12 +915,77 @@ func (q *Query) selectQuery(fields []FieldName) (string, SQLParams, map[string]s
 	if len(q.groups) > 0 {
 		log.Panic("Calling selectQuery on a Group By query")
 	}
-	subQuery, args, substs := q.selectCommonQuery(fields)
-	orderSQL := q.sqlOrderByClause()
+	subQuery, args, substs := q.selectSubQuery(fields)
 	limitSQL := q.sqlLimitOffsetClause()
+	if q.lock != noLock {
+		// Rows are locked in a join on the table, since
+		// locking clauses are not allowed with DISTINCT.
+		orderSQL, orderArgs := q.sqlQualifiedOrderByClause("foo")
+		selQuery := fmt.Sprintf(`SELECT foo.* FROM (%s) foo JOIN %s hexya_lock ON hexya_lock.id = foo.id %s %s %s`,
+			subQuery, q.thisTable(), orderSQL, limitSQL, q.lock.sqlClause("hexya_lock"))
+		return q.withPlannerHints(selQuery), args.Extend(orderArgs), substs
+	}
+	orderSQL, orderArgs := q.sqlOrderByClause()
 	selQuery := fmt.Sprintf(`SELECT * FROM (%s) foo %s %s`,
 		subQuery, orderSQL, limitSQL)
-	return selQuery, args, substs
+	return q.withPlannerHints(selQuery), args.Extend(orderArgs), substs
+}
+
+// selectSubQuery returns the SQL query string and parameters of the rows of
+// this Query with the given fields, before they are ordered and limited.
+func (q *Query) selectSubQuery(fields []FieldName) (string, SQLParams, map[string]string) {
+	if len(q.partitionBy) > 0 {
+		// Rows within the limit of their partition are selected by id
+		fields = append([]FieldName{ID}, fields...)
+	}
+	subQuery, args, substs := q.selectCommonQuery(fields)
+	if len(q.distinctOn) > 0 {
+		subQuery, args = q.sqlDistinctOnQuery(subQuery, args)
+	}
+	if len(q.partitionBy) > 0 {
+		subQuery, args = q.sqlPartitionLimitQuery(subQuery, args)
+	}
+	return subQuery, args, substs
+}
+
+// sqlDistinctOnQuery wraps the given subQuery with the given args so that it only returns
+// the first row of each set of rows having the same values for the distinctOn expressions
+// of this Query. It returns the wrapping query and its args.
+//
+// Rows of each set are ordered with this Query's orders, so that the first row is the one
+// that would have come first in the result without distinctOn.
+func (q *Query) sqlDistinctOnQuery(subQuery string, args SQLParams) (string, SQLParams) {
+	distinctSlice := make([]string, len(q.distinctOn))
+	for i, field := range q.distinctOn {
+		_, _, distinctSlice[i] = q.joinedFieldExpression(splitFieldNames(field, ExprSep), true, i)
+	}
+	distinctSQL := strings.Join(distinctSlice, ", ")
+	orderSQL, orderArgs := q.sqlOrderByClause()
+	orderSQL = strings.TrimPrefix(orderSQL, "ORDER BY ")
+	if orderSQL != "" {
+		orderSQL = fmt.Sprintf(", %s", orderSQL)
+	}
+	return fmt.Sprintf(`SELECT DISTINCT ON (%s) * FROM (%s) foo ORDER BY %s%s`,
+		distinctSQL, subQuery, distinctSQL, orderSQL), args.Extend(orderArgs)
+}
+
+// sqlPartitionLimitQuery wraps the given subQuery with the given args so that it only
+// returns the first partitionLimit rows of each set of rows having the same values for
+// the partitionBy expressions of this Query. It returns the wrapping query and its args.
+//
+// Rows of each set are ordered with this Query's orders and numbered with the
+// ROW_NUMBER() window function.
+func (q *Query) sqlPartitionLimitQuery(subQuery string, args SQLParams) (string, SQLParams) {
+	partitionSlice := make([]string, len(q.partitionBy))
+	for i, field := range q.partitionBy {
+		_, _, partitionSlice[i] = q.joinedFieldExpression(splitFieldNames(field, ExprSep), true, i)
+	}
+	orderSQL, orderArgs := q.sqlOrderByClause()
+	if orderSQL != "" {
+		orderSQL = fmt.Sprintf(" %s", orderSQL)
+	}
+	return fmt.Sprintf(`WITH hexya_partition AS (%s) SELECT * FROM hexya_partition WHERE id IN (SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY %s%s) AS hexya_row FROM hexya_partition) foo WHERE hexya_row <= %d)`,
+		subQuery, strings.Join(partitionSlice, ", "), orderSQL, q.partitionLimit), args.Extend(orderArgs)
 }
 
 // selectGroupQuery returns the SQL query string and parameters to retrieve
@@ -440,11 +1010,11 @@ func (q *Query) selectGroupQuery(fieldsList []FieldName, aggFncts map[string]str
 	fieldsSQL := q.fieldsGroupSQL(fieldExprs, aggFncts)
 	// Group by clause
 	groupSQL := q.sqlGroupByClause()
-	orderSQL := q.sqlOrderByClauseForGroupBy(aggFncts)
+	orderSQL, orderArgs := q.sqlOrderByClauseForGroupBy(aggFncts)
 	limitSQL := q.sqlLimitOffsetClause()
 	selQuery := fmt.Sprintf(`SELECT %s, count(1) AS __count FROM (%s) base GROUP BY %s %s %s`,
 		fieldsSQL, baseQuery, groupSQL, orderSQL, limitSQL)
-	return selQuery, baseArgs
+	return q.withPlannerHints(selQuery), baseArgs.Extend(orderArgs)
 }
 
 // selectData returns for this query:
@@ -475,10 +1045,21 @@ func (q *Query) selectData(fields []FieldName, withCtx bool) ([][]FieldName, [][
 	for _, gExpr := range gExprs {
 		if _, ok := fieldsExprsMap[joinFieldNames(gExpr, ExprSep).JSON()]; !ok {
 			fieldExprs = append(fieldExprs, gExpr)
+			fieldsExprsMap[joinFieldNames(gExpr, ExprSep).JSON()] = gExpr
+		}
+	}
+	// Add 'distinct on' and 'partition by' exprs removing duplicates
+	for _, dExpr := range append(q.getDistinctOnExpressions(), q.getPartitionByExpressions()...) {
+		if _, ok := fieldsExprsMap[joinFieldNames(dExpr, ExprSep).JSON()]; !ok {
+			fieldExprs = append(fieldExprs, dExpr)
+			fieldsExprsMap[joinFieldNames(dExpr, ExprSep).JSON()] = dExpr
 		}
 	}
 	// Then given by condition
 	allExprs := append(fieldExprs, q.cond.getAllExpressions(q.recordSet.model)...)
+	if withCtx {
+		allExprs = append(allExprs, q.ctxCond.getAllExpressions(q.recordSet.model)...)
+	}
 	return fieldExprs, allExprs
 }
 
@@ -544,7 +1125,11 @@ func (q *Query) fieldsGroupSQL(fieldExprs [][]FieldName, aggFncts map[string]str
 			fStr[i] = joinFieldNames(exprs, sqlSep).JSON()
 			continue
 		}
-		fStr[i] = fmt.Sprintf("%s(%s) AS %s", aggFnct, joinFieldNames(exprs, sqlSep).JSON(), joinFieldNames(exprs, sqlSep).JSON())
+		aggSQL := fmt.Sprintf("%s(%s)", aggFnct, joinFieldNames(exprs, sqlSep).JSON())
+		if q.sample.isSet() && strings.ToLower(aggFnct) == "sum" {
+			aggSQL = q.sampledSumSQL(aggSQL, exprs)
+		}
+		fStr[i] = fmt.Sprintf("%s AS %s", aggSQL, joinFieldNames(exprs, sqlSep).JSON())
 	}
 	return strings.Join(fStr, ", ")
 }
@@ -679,6 +1264,9 @@ func (q *Query) tablesSQL(fExprs [][]FieldName) (string, map[string]string) {
 				}
 				aliasIndex++
 				res += j.sqlString()
+				if !j.joined && q.sample.isSet() {
+					res += q.sample.sqlClause()
+				}
 			}
 		}
 	}
@@ -741,6 +1329,22 @@ func (q *Query) substituteConditionExprs(substMap map[FieldName][]FieldName) {
 			}
 		}
 	}
+	for i, distinct := range q.distinctOn {
+		for k, v := range substMap {
+			if distinct.JSON() == k.JSON() {
+				q.distinctOn[i] = joinFieldNames(v, ExprSep)
+				break
+			}
+		}
+	}
+	for i, partition := range q.partitionBy {
+		for k, v := range substMap {
+			if partition.JSON() == k.JSON() {
+				q.partitionBy[i] = joinFieldNames(v, ExprSep)
+				break
+			}
+		}
+	}
 }
 
 // evaluateConditionArgFunctions evaluates all args in the queries that are functions and
@@ -748,6 +1352,10 @@ func (q *Query) substituteConditionExprs(substMap map[FieldName][]FieldName) {
 //
 // multi should be true if the operator of the predicate is IN
 func (q *Query) evaluateConditionArgFunctions(p predicate) interface{} {
+	if sub, ok := p.arg.(*RecordCollection); ok {
+		// Search that could not be executed as a subquery
+		return sub.Ids()
+	}
 	fnctVal := reflect.ValueOf(p.arg)
 	if fnctVal.Kind() != reflect.Func {
 		return p.arg
@@ -764,8 +1372,10 @@ func (q *Query) evaluateConditionArgFunctions(p predicate) interface{} {
 // getAllExpressions returns all expressions used in this query,
 // both in the condition and the order by clause.
 func (q *Query) getAllExpressions() [][]FieldName {
-	return append(q.getOrderByExpressions(true),
-		append(q.getGroupByExpressions(), q.cond.getAllExpressions(q.recordSet.model)...)...)
+	res := append(q.getOrderByExpressions(true), q.getGroupByExpressions()...)
+	res = append(res, q.getDistinctOnExpressions()...)
+	res = append(res, q.getPartitionByExpressions()...)
+	return append(res, q.cond.getAllExpressions(q.recordSet.model)...)
 }
 
 // getOrderByExpressions returns all expressions used in order by clause of this query.
@@ -774,6 +1384,10 @@ func (q *Query) getAllExpressions() [][]FieldName {
 func (q *Query) getOrderByExpressions(withCtx bool) [][]FieldName {
 	var exprs [][]FieldName
 	for _, order := range q.orders {
+		if q.isAggregateOrder(order) {
+			// Aggregates are selected in a subquery
+			continue
+		}
 		oExprs := splitFieldNames(order.field, ExprSep)
 		exprs = append(exprs, oExprs)
 	}
@@ -793,6 +1407,24 @@ func (q *Query) getCtxOrderByExpressions() [][]FieldName {
 	return exprs
 }
 
+// getDistinctOnExpressions returns all expressions used in the distinct on clause of this query.
+func (q *Query) getDistinctOnExpressions() [][]FieldName {
+	var exprs [][]FieldName
+	for _, distinct := range q.distinctOn {
+		exprs = append(exprs, splitFieldNames(distinct, ExprSep))
+	}
+	return exprs
+}
+
+// getPartitionByExpressions returns all expressions used in the partition by clause of this query.
+func (q *Query) getPartitionByExpressions() [][]FieldName {
+	var exprs [][]FieldName
+	for _, partition := range q.partitionBy {
+		exprs = append(exprs, splitFieldNames(partition, ExprSep))
+	}
+	return exprs
+}
+
 // getGroupByExpressions returns all expressions used in group by clause of this query.
 func (q *Query) getGroupByExpressions() [][]FieldName {
 	var exprs [][]FieldName
@@ -0,0 +1,106 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// QueryBudget is the default maximum number of queries that can be executed
+// in the transaction of an Environment. 0 means no limit.
+//
+// It is meant as a safety valve against N+1 loops and buggy computed fields.
+// It can be changed for a single transaction with Cursor.SetQueryBudget.
+var QueryBudget = 0
+
+// QueryBudgetStrict makes transactions panic when their query budget is
+// exceeded. Otherwise, a warning with the most repeated queries is logged
+// once per transaction. It is typically set in development mode only.
+var QueryBudgetStrict = false
+
+// queryBudgetSampleSize is the number of repeated queries reported
+// when a query budget is exceeded.
+const queryBudgetSampleSize = 5
+
+// A queryCounter counts the queries executed in a Cursor's transaction
+// and checks them against its budget.
+type queryCounter struct {
+	count    int
+	budget   int
+	exceeded bool
+	queries  map[string]int
+}
+
+// newQueryCounter returns a new queryCounter with the default QueryBudget.
+func newQueryCounter() *queryCounter {
+	return &queryCounter{
+		budget:  QueryBudget,
+		queries: make(map[string]int),
+	}
+}
+
+// add counts the given query and checks the budget.
+func (qc *queryCounter) add(query string) {
+	qc.count++
+	if qc.budget <= 0 {
+		return
+	}
+	qc.queries[query]++
+	if qc.count <= qc.budget || qc.exceeded {
+		return
+	}
+	qc.exceeded = true
+	if QueryBudgetStrict {
+		log.Panic("Query budget exceeded", "budget", qc.budget, "repeated", qc.sample())
+	}
+	log.Warn("Query budget exceeded", "budget", qc.budget, "repeated", qc.sample())
+}
+
+// sample returns the most repeated queries, with their number of executions.
+func (qc *queryCounter) sample() []string {
+	queries := make([]string, 0, len(qc.queries))
+	for q := range qc.queries {
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if qc.queries[queries[i]] != qc.queries[queries[j]] {
+			return qc.queries[queries[i]] > qc.queries[queries[j]]
+		}
+		return queries[i] < queries[j]
+	})
+	if len(queries) > queryBudgetSampleSize {
+		queries = queries[:queryBudgetSampleSize]
+	}
+	res := make([]string, len(queries))
+	for i, q := range queries {
+		res[i] = fmt.Sprintf("%dx %s", qc.queries[q], q)
+	}
+	return res
+}
+
+// QueryCount returns the number of queries executed so far in the
+// transaction of this Cursor.
+//
+// It is meant to assert in tests that an operation runs at most a given
+// number of queries.
+func (c *Cursor) QueryCount() int {
+	if c == nil {
+		// Detached environments have no cursor
+		return 0
+	}
+	return c.counter.count
+}
+
+// SetQueryBudget sets the maximum number of queries that can be executed in
+// the transaction of this Cursor, including those already executed.
+// 0 means no limit. It overrides QueryBudget for this transaction.
+func (c *Cursor) SetQueryBudget(budget int) {
+	if c == nil {
+		// Detached environments have no cursor and execute no query
+		return
+	}
+	c.counter.budget = budget
+	c.counter.exceeded = false
+}
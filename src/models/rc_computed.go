@@ -16,15 +16,20 @@ package models
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 
 	"github.com/hexya-erp/hexya/src/tools/typesutils"
 )
 
 // A recomputePair gives a method to apply on a record collection.
+//
+// If related is set, the column of this stored related field
+// is updated instead.
 type recomputePair struct {
-	recs   *RecordCollection
-	method string
+	recs    *RecordCollection
+	method  string
+	related *Field
 }
 
 // computeFieldValues updates the given params with the given computed (non stored) fields
@@ -69,7 +74,11 @@ func (rc *RecordCollection) retrieveComputeData(fields FieldNames) []recomputePa
 			continue
 		}
 		for _, dep := range refFieldInfo.dependencies {
-			key := fmt.Sprintf("%s-%s-%s-%t", dep.model.name, dep.path, dep.compute, dep.stored)
+			method := dep.compute
+			if dep.related {
+				method = dep.fieldName
+			}
+			key := fmt.Sprintf("%s-%s-%s-%t", dep.model.name, dep.path, method, dep.stored)
 			if _, exists := toUpdateData[key]; !exists {
 				toUpdateKeys = append(toUpdateKeys, key)
 				toUpdateData[key] = dep
@@ -86,6 +95,11 @@ func (rc *RecordCollection) retrieveComputeData(fields FieldNames) []recomputePa
 			cPath := cData.model.FieldName(cData.path)
 			recs = rc.Env().Pool(cData.model.name).Search(rc.Model().Field(cPath).In(rc.Ids()))
 		}
+		if cData.related {
+			// Stored related fields must be updated whatever the access rights of the user
+			res = append(res, recomputePair{recs: recs.Sudo().Fetch(), related: cData.model.fields.MustGet(cData.fieldName)})
+			continue
+		}
 		if !cData.stored {
 			// Field is not stored, just invalidating cache
 			for _, id := range recs.Ids() {
@@ -99,14 +113,28 @@ func (rc *RecordCollection) retrieveComputeData(fields FieldNames) []recomputePa
 	return res
 }
 
-// updateStoredFields applies each method on each record defined by compPairs
+// updateStoredFields applies each method on each record defined by compPairs,
+// or queues them if this RecordCollection is in a WithDeferredRecompute scope.
 func (rc *RecordCollection) updateStoredFields(compPairs []recomputePair) {
+	if rc.env.recomputes.isOpen() {
+		rc.env.recomputes.add(compPairs)
+		return
+	}
+	applyRecomputePairs(compPairs)
+}
+
+// applyRecomputePairs applies each method on each record defined by compPairs
+func applyRecomputePairs(compPairs []recomputePair) {
 	for _, rp := range compPairs {
 		if rp.recs.IsEmpty() {
 			// recs have been fetched in retrieveComputeData
 			// if it is empty now, it must be because the records have been unlinked in between
 			continue
 		}
+		if rp.related != nil {
+			rp.recs.updateStoredRelatedField(rp.related)
+			continue
+		}
 		rp.recs.applyMethod(rp.method)
 	}
 }
@@ -161,6 +189,23 @@ func (rc *RecordCollection) processInverseMethods(data RecordData) {
 			}
 			log.Panic("Trying to write a computed field without inverse method", "model", rc.model.name, "field", fieldName)
 		}
-		rc.Call(fi.inverse, val)
+		rc.Call(fi.inverse, rc.convertInverseValue(fi, val))
+	}
+}
+
+// convertInverseValue converts the given value of the computed field fi
+// to the argument type of its inverse method.
+//
+// It panics if the value cannot be converted.
+func (rc *RecordCollection) convertInverseValue(fi *Field, val interface{}) interface{} {
+	argType := rc.model.methods.MustGet(fi.inverse).methodType.In(1)
+	if val == nil || fi.isRelationField() || reflect.TypeOf(val) == argType {
+		return val
+	}
+	target := reflect.New(argType)
+	if err := typesutils.Convert(val, target.Interface(), false); err != nil {
+		log.Panic("Unable to convert value for inverse method", "model", rc.model.name, "field", fi.name,
+			"method", fi.inverse, "value", val, "error", err)
 	}
+	return target.Elem().Interface()
 }
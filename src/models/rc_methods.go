@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
 
@@ -165,9 +166,9 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 	if caller != nil {
 		methodCaller = fmt.Sprintf("%s.%s()", caller.model.name, caller.name)
 	}
-	log.Panic("You are not allowed to execute this method", "model", rc.ModelName(),
-		"method", fmt.Sprintf("%s.%s()", method.model.name, method.name), "uid", rc.env.uid,
-		"methodCaller", methodCaller)
-	// Unreachable
-	return false
+	panic(exceptions.AccessError{
+		Message: rc.T("You are not allowed to execute this method"),
+		Debug: fmt.Sprintf("model: %s, method: %s.%s(), uid: %d, methodCaller: %s", rc.ModelName(),
+			method.model.name, method.name, rc.env.uid, methodCaller),
+	})
 }
@@ -0,0 +1,158 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxReadDepth is the maximum depth of the nested field specs
+// accepted by ReadNested. A depth of 1 means that only the fields
+// of the records themselves can be read.
+var MaxReadDepth = 3
+
+// A FieldSpec is a field to read with ReadNested.
+//
+// SubFields are the fields to read on the related records
+// if Field is a relation field.
+type FieldSpec struct {
+	Field     FieldName
+	SubFields FieldsSpec
+}
+
+// FieldsSpec is a list of FieldSpec
+type FieldsSpec []FieldSpec
+
+// depth returns the depth of this FieldsSpec
+func (fs FieldsSpec) depth() int {
+	var res int
+	for _, spec := range fs {
+		if d := spec.SubFields.depth(); d > res {
+			res = d
+		}
+	}
+	return res + 1
+}
+
+// fieldNames returns the names of the fields of this FieldsSpec
+func (fs FieldsSpec) fieldNames() FieldNames {
+	res := make(FieldNames, len(fs))
+	for i, spec := range fs {
+		res[i] = spec.Field
+	}
+	return res
+}
+
+// ParseFieldsSpec returns the FieldsSpec of the given model from
+// its JSON representation, once unmarshalled.
+//
+// The JSON representation is an array of field names, in which
+// relation fields can be given as an object with the field name as
+// key and the array of fields to read on the related records as value:
+//
+//	["name", {"partner_id": ["name", "email"]}]
+func ParseFieldsSpec(model *Model, data []interface{}) (FieldsSpec, error) {
+	var res FieldsSpec
+	for _, item := range data {
+		switch it := item.(type) {
+		case string:
+			fi, ok := model.fields.Get(it)
+			if !ok {
+				return nil, fmt.Errorf("unknown field '%s' in model %s", it, model.name)
+			}
+			res = append(res, FieldSpec{Field: fi})
+		case map[string]interface{}:
+			for _, fName := range FieldMap(it).OrderedKeys() {
+				fi, ok := model.fields.Get(fName)
+				if !ok {
+					return nil, fmt.Errorf("unknown field '%s' in model %s", fName, model.name)
+				}
+				if !fi.fieldType.IsRelationType() {
+					return nil, fmt.Errorf("field '%s' of model %s is not a relation field", fName, model.name)
+				}
+				subData, ok := it[fName].([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("fields of relation field '%s' of model %s must be an array", fName, model.name)
+				}
+				subSpec, err := ParseFieldsSpec(fi.relatedModel, subData)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, FieldSpec{Field: fi, SubFields: subSpec})
+			}
+		default:
+			return nil, errors.New("fields spec items must be strings or objects")
+		}
+	}
+	return res, nil
+}
+
+// ReadNested reads the fields of the given spec for all the records
+// of this RecordCollection and returns a FieldMap per record.
+//
+// Relation fields with SubFields are returned as FieldMap (or nil) for
+// many2one and one2one fields, and as slices of FieldMap for one2many and
+// many2many fields. The related records are loaded with a single query per
+// relation field and level. Related records that the user is not allowed
+// to read are left out.
+//
+// It panics if the depth of the spec is greater than MaxReadDepth.
+func (rc *RecordCollection) ReadNested(spec FieldsSpec) []FieldMap {
+	if spec.depth() > MaxReadDepth {
+		log.Panic("Fields spec is too deep", "model", rc.model, "depth", spec.depth(), "max", MaxReadDepth)
+	}
+	return rc.readNested(spec)
+}
+
+// readNested is the recursive implementation of ReadNested
+func (rc *RecordCollection) readNested(spec FieldsSpec) []FieldMap {
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Read"))
+	fields := addIDIfNotPresent(spec.fieldNames())
+	rc.Load(fields...)
+	records := rc.Records()
+	res := make([]FieldMap, len(records))
+	for i, rec := range records {
+		res[i] = make(FieldMap)
+		for _, fName := range fields {
+			res[i][fName.JSON()] = rec.Get(fName)
+		}
+	}
+	for _, fSpec := range spec {
+		if len(fSpec.SubFields) == 0 {
+			continue
+		}
+		fi := rc.model.fields.MustGet(fSpec.Field.JSON())
+		related := rc.env.Pool(fi.relatedModel.name)
+		for _, rec := range records {
+			related = related.Union(rec.Get(fSpec.Field).(RecordSet).Collection())
+		}
+		relatedData := make(map[int64]FieldMap)
+		if !related.IsEmpty() {
+			visible := related.Search(related.model.Field(ID).In(related.Ids()))
+			for _, data := range visible.readNested(fSpec.SubFields) {
+				relatedData[data["id"].(int64)] = data
+			}
+		}
+		for i, rec := range records {
+			relRecs := rec.Get(fSpec.Field).(RecordSet).Collection()
+			if fi.fieldType.Is2OneRelationType() {
+				var val FieldMap
+				if !relRecs.IsEmpty() {
+					val = relatedData[relRecs.Ids()[0]]
+				}
+				res[i][fi.json] = val
+				continue
+			}
+			vals := make([]FieldMap, 0, relRecs.Len())
+			for _, id := range relRecs.Ids() {
+				if data, ok := relatedData[id]; ok {
+					vals = append(vals, data)
+				}
+			}
+			res[i][fi.json] = vals
+		}
+	}
+	return res
+}
@@ -14,7 +14,11 @@
 
 package models
 
-import "github.com/hexya-erp/hexya/src/models/security"
+import (
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
 
 // addRecordRuleConditions adds the RecordRule conditions on the query of this
 // RecordSet for the user with the given uid and for the given perm Permission.
@@ -23,26 +27,64 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 		return rc
 	}
 	rSet := rc
-	// Add global rules
-	for _, rule := range rSet.model.rulesRegistry.globalRules {
+	if cond := rSet.model.recordRulesCondition(uid, perm); !cond.IsEmpty() {
+		rSet = rSet.Search(cond)
+	}
+	rSet.filtered = true
+	*rc = *rSet
+	return rc
+}
+
+// recordRulesCondition returns the condition that restricts the records of
+// this model that the user with the given uid can access with the given perm
+// Permission. It returns an empty condition if no restriction applies.
+//
+// Record rules are combined as follows:
+// - Global rules restrict access and are AND-ed together.
+// - Rules of the groups of the user grant access and are OR-ed together,
+// whatever their group. A group rule without condition grants access to all
+// records. This group block is then AND-ed with the global rules.
+//
+// Since the returned condition is AND-ed with the search condition, record
+// rules can only restrict the records found by a search.
+//
+// Rules are taken in the order of their names so that the resulting condition
+// is always the same.
+func (m *Model) recordRulesCondition(uid int64, perm security.Permission) *Condition {
+	m.rulesRegistry.RLock()
+	defer m.rulesRegistry.RUnlock()
+	var globalRules, groupRules []*RecordRule
+	for _, rule := range m.rulesRegistry.globalRules {
 		if perm&rule.Perms > 0 {
-			rSet = rSet.Search(rule.Condition)
+			globalRules = append(globalRules, rule)
 		}
 	}
-	// Add groups rules
-	userGroups := security.Registry.UserGroups(uid)
-	groupCondition := newCondition()
-	for group := range userGroups {
-		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[group.ID()] {
+	for group := range security.Registry.UserGroups(uid) {
+		for _, rule := range m.rulesRegistry.rulesByGroup[group.ID()] {
 			if perm&rule.Perms > 0 {
-				groupCondition = groupCondition.OrCond(rule.Condition)
+				groupRules = append(groupRules, rule)
 			}
 		}
 	}
-	if !groupCondition.IsEmpty() {
-		rSet = rSet.Search(groupCondition)
+	sortRecordRules(globalRules)
+	sortRecordRules(groupRules)
+	cond := newCondition()
+	for _, rule := range globalRules {
+		cond = cond.AndCond(rule.Condition)
 	}
-	rSet.filtered = true
-	*rc = *rSet
-	return rc
+	groupCondition := newCondition()
+	for _, rule := range groupRules {
+		if rule.Condition.IsEmpty() {
+			return cond
+		}
+		groupCondition = groupCondition.OrCond(rule.Condition)
+	}
+	return cond.AndCond(groupCondition)
+}
+
+// sortRecordRules sorts the given rules by name.
+func sortRecordRules(rules []*RecordRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
 }
@@ -0,0 +1,168 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// RecomputeBatchSize is the number of records whose stored computed
+// fields are recomputed in a single transaction by RecomputeStoredFields.
+var RecomputeBatchSize = 1000
+
+// newStoredFields are the stored computed and stored related fields whose
+// column has been created by the last database synchronization.
+var newStoredFields []*Field
+
+// RecomputeStoredFields recomputes and stores the given stored computed or
+// stored related fields of all the records of the model with the given name,
+// by batches of RecomputeBatchSize records, each batch in its own transaction.
+//
+// If no field is given, all the stored computed and stored related fields of
+// the model are recomputed. If modelName is empty, all those of all the models
+// are recomputed.
+//
+// Only the records whose computed values change are written, which in turn
+// recomputes the fields that depend on them. It panics if a field is neither
+// a stored computed field nor a stored related field of the model.
+func RecomputeStoredFields(modelName string, fieldNames ...string) {
+	recomputeFields(storedFieldsToRecompute(modelName, fieldNames...), inNewEnvironment)
+}
+
+// storedFieldsToRecompute returns the fields to recompute by
+// RecomputeStoredFields with the given arguments.
+func storedFieldsToRecompute(modelName string, fieldNames ...string) []*Field {
+	if modelName == "" {
+		if len(fieldNames) > 0 {
+			log.Panic("A model must be given to recompute fields", "fields", fieldNames)
+		}
+		var fields []*Field
+		for _, model := range Registry.registryByName {
+			fields = append(fields, model.storedComputedFields()...)
+		}
+		return fields
+	}
+	model := Registry.MustGet(modelName)
+	if len(fieldNames) == 0 {
+		return model.storedComputedFields()
+	}
+	fields := make([]*Field, len(fieldNames))
+	for i, fName := range fieldNames {
+		fi := model.fields.MustGet(fName)
+		if !fi.isRecomputable() {
+			log.Panic("Only stored computed or related fields can be recomputed", "model", model.name, "field", fName)
+		}
+		fields[i] = fi
+	}
+	return fields
+}
+
+// isRecomputable returns true if this field is a stored computed field
+// or a stored related field whose column can be updated in a single query.
+func (f *Field) isRecomputable() bool {
+	if f.isComputedField() && f.isStored() {
+		return true
+	}
+	if !f.isStoredRelatedField() {
+		return false
+	}
+	_, ok := storedRelatedPath(f)
+	return ok
+}
+
+// storedComputedFields returns the stored computed and stored
+// related fields of this model.
+func (m *Model) storedComputedFields() []*Field {
+	if m.IsMixin() || m.IsManual() {
+		return nil
+	}
+	var res []*Field
+	for _, fi := range m.fields.registryByName {
+		if fi.isRecomputable() {
+			res = append(res, fi)
+		}
+	}
+	return res
+}
+
+// inNewEnvironment executes the given function in a new Environment
+// of the super user, within its own transaction.
+func inNewEnvironment(fnct func(Environment)) error {
+	return ExecuteInNewEnvironment(security.SuperUserID, fnct)
+}
+
+// recomputeFields recomputes the given stored computed or related fields on
+// all the records of their model, by batches of RecomputeBatchSize records.
+// Each batch and the search of the records are executed by calling run.
+//
+// Computed fields sharing the same compute method are recomputed at once,
+// and stored related fields in a single query for each batch.
+func recomputeFields(fields []*Field, run func(func(Environment)) error) {
+	methodsByModel := make(map[*Model][]string)
+	relatedByModel := make(map[*Model][]*Field)
+	modelsToRecompute := make(map[*Model]bool)
+	for _, fi := range fields {
+		modelsToRecompute[fi.model] = true
+		if !fi.isComputedField() {
+			relatedByModel[fi.model] = append(relatedByModel[fi.model], fi)
+			continue
+		}
+		methods := methodsByModel[fi.model]
+		var exists bool
+		for _, meth := range methods {
+			if meth == fi.compute {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			methodsByModel[fi.model] = append(methods, fi.compute)
+		}
+	}
+	// Sort models, methods and fields to have deterministic recomputation
+	sortedModels := make([]*Model, 0, len(modelsToRecompute))
+	for model := range modelsToRecompute {
+		sort.Strings(methodsByModel[model])
+		related := relatedByModel[model]
+		sort.Slice(related, func(i, j int) bool {
+			return related[i].name < related[j].name
+		})
+		sortedModels = append(sortedModels, model)
+	}
+	sort.Slice(sortedModels, func(i, j int) bool {
+		return sortedModels[i].name < sortedModels[j].name
+	})
+	for _, model := range sortedModels {
+		var ids []int64
+		err := run(func(env Environment) {
+			ids = env.Pool(model.name).SearchAll().OrderBy("ID").Ids()
+		})
+		if err != nil {
+			log.Panic("Error while fetching records to recompute", "model", model.name, "error", err)
+		}
+		log.Info("Recomputing stored fields", "model", model.name, "methods", methodsByModel[model],
+			"related", len(relatedByModel[model]), "records", len(ids))
+		for start := 0; start < len(ids); start += RecomputeBatchSize {
+			end := start + RecomputeBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			err := run(func(env Environment) {
+				recs := env.Pool(model.name).withIds(ids[start:end])
+				for _, fi := range relatedByModel[model] {
+					recs.updateStoredRelatedField(fi)
+				}
+				recs.Fetch()
+				for _, meth := range methodsByModel[model] {
+					recs.applyMethod(meth)
+				}
+			})
+			if err != nil {
+				log.Panic("Error while recomputing stored fields", "model", model.name, "error", err)
+			}
+		}
+	}
+}
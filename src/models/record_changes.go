@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "sort"
+
+// A ChangeEvent is the kind of change of the records of a RecordsChange
+type ChangeEvent string
+
+// Available change events
+const (
+	ChangeCreated  ChangeEvent = "create"
+	ChangeWritten  ChangeEvent = "write"
+	ChangeUnlinked ChangeEvent = "unlink"
+)
+
+// A RecordsChange describes records of a model that have been created,
+// written or deleted. Fields holds the JSON names of the fields given to
+// Create or Write, sorted alphabetically.
+type RecordsChange struct {
+	Model  string
+	Event  ChangeEvent
+	IDs    []int64
+	Fields []string
+}
+
+// A ChangeListener is called in the transaction of env each time records are
+// created, written or deleted in the database.
+type ChangeListener func(env Environment, change RecordsChange)
+
+// changeListeners are the listeners registered with RegisterChangeListener
+var changeListeners []ChangeListener
+
+// RegisterChangeListener adds the given listener to the functions called
+// each time records are created, written or deleted in the database.
+//
+// Listeners are called synchronously at the end of Create, Write and Unlink,
+// before the transaction is committed, and must therefore be fast. They
+// typically defer their work with Environment.AfterCommit. They are also
+// called for the fields that the framework updates directly in the database,
+// that is stored related fields, line numbers and touched parents.
+//
+// It is meant to be called by packages that track changes, typically in
+// their init function.
+func RegisterChangeListener(listener ChangeListener) {
+	changeListeners = append(changeListeners, listener)
+}
+
+// notifyChange calls the registered change listeners for the given
+// event on the given ids of this RecordCollection's model, with the given
+// fields. Records that are only in memory are not notified.
+func (rc *RecordCollection) notifyChange(event ChangeEvent, ids []int64, fields []FieldName) {
+	if len(changeListeners) == 0 || rc.hasNegIds || len(ids) == 0 {
+		return
+	}
+	change := RecordsChange{
+		Model: rc.model.name,
+		Event: event,
+		IDs:   ids,
+	}
+	for _, f := range fields {
+		change.Fields = append(change.Fields, f.JSON())
+	}
+	sort.Strings(change.Fields)
+	for _, listener := range changeListeners {
+		listener(rc.Env(), change)
+	}
+}
@@ -24,20 +24,25 @@ import (
 	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
-	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/jmoiron/sqlx"
 )
 
 // RecordCollection is a generic struct representing several
 // records of a model.
+//
+// onlyFields are the fields loaded from the database when a field value
+// is not in cache. If nil, all the stored fields of the model are loaded.
 type RecordCollection struct {
 	model      *Model
 	query      *Query
 	env        *Environment
 	prefetchRC *RecordCollection
+	onlyFields []FieldName
 	ids        []int64
 	fetched    bool
 	filtered   bool
+	scoped     bool
 	hasNegIds  bool
 }
 
@@ -118,6 +123,30 @@ func (rc *RecordCollection) Ids() []int64 {
 	return rc.ids
 }
 
+// isSubSearch returns true if this RecordCollection is a search that has not
+// been fetched yet and that can be executed as a subquery of another query,
+// i.e. it has neither limit, offset, grouping, distinct clause nor lock.
+func (rc *RecordCollection) isSubSearch() bool {
+	q := rc.query
+	switch {
+	case rc.fetched, rc.filtered, rc.hasNegIds, q.isEmpty():
+		return false
+	case q.limit != 0, q.offset != 0, len(q.groups) > 0, len(q.distinctOn) > 0, len(q.partitionBy) > 0, q.lock != noLock:
+		return false
+	}
+	return true
+}
+
+// isSubSearchOf returns true if the ids of this search RecordCollection can be
+// compared to the given field in a subquery, that is if fi is a many2one or
+// one2one field to the model of this RecordCollection or its ID field.
+func (rc *RecordCollection) isSubSearchOf(fi *Field) bool {
+	if fi.fieldType.IsFKRelationType() {
+		return fi.relatedModel == rc.model
+	}
+	return fi.json == "id" && fi.model == rc.model
+}
+
 // clone returns a pointer to a new RecordCollection identical to this one.
 func (rc *RecordCollection) clone() *RecordCollection {
 	rSet := *rc
@@ -131,6 +160,7 @@ func (rc *RecordCollection) clone() *RecordCollection {
 //
 // Note that new doesn't work with embedded records
 func (rc *RecordCollection) new(data RecordData) *RecordCollection {
+	rc.checkDataFields(data)
 	rc.InvalidateCache()
 	rc.env.nextNegativeID--
 	id := rc.env.nextNegativeID
@@ -143,27 +173,59 @@ func (rc *RecordCollection) new(data RecordData) *RecordCollection {
 	return rSet
 }
 
+// checkDataFields panics if the given data holds values or records to create
+// for fields that do not exist in the model of this RecordCollection, so that
+// misspelled keys of data built from maps are reported before any query.
+func (rc *RecordCollection) checkDataFields(data RecordData) {
+	md := data.Underlying()
+	for field := range md.FieldMap {
+		rc.model.checkFieldPath(field)
+	}
+	for field := range md.ToCreate {
+		rc.model.checkFieldPath(field)
+	}
+}
+
 // create inserts a new record in the database with the given data.
 // data can be either a FieldMap or a struct pointer of the same model as rs.
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Create")
 func (rc *RecordCollection) create(data RecordData) *RecordCollection {
+	rSet, _, _ := rc.createUnlessConflict(data)
+	return rSet
+}
+
+// createUnlessConflict is the implementation of create. If the query of this
+// RecordCollection has onConflict fields and the new record conflicts with an
+// existing one on these fields, nothing is inserted and the remaining steps of
+// create are not run. In this case, it returns the id of the existing record
+// and data in which the records to create of FK relation fields have been
+// replaced by the already created records, so that they can be written on the
+// existing record instead of being left orphaned.
+func (rc *RecordCollection) createUnlessConflict(data RecordData) (*RecordCollection, int64, *ModelData) {
 	defer func() {
 		if r := recover(); r != nil {
 			panic(rc.substituteSQLErrorMessage(r))
 		}
 	}()
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	rc.checkNotDetached("Create")
+	rc.checkDataFields(data)
 	// process create data for FK relations if any
-	data = rc.createFKRelationRecords(data)
+	fkData := rc.createFKRelationRecords(data)
+	data = fkData
 
 	newData := data.Underlying().Copy()
 	rc.applyDefaults(newData, true)
+	rc.checkRequiredFields(newData, true)
+	rc.checkSelectionValues(newData)
+	rc.checkJSONValues(newData)
 	fMap := newData.Underlying().FieldMap
 	rc.applyContexts()
 	rc.addAccessFieldsCreateData(&fMap)
 	fMap = rc.addEmbeddedfields(fMap)
 	rc.model.convertValuesToFieldType(&fMap, true)
+	rc.roundFloatValues(fMap)
 	fMap = rc.addContextsFieldsValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
@@ -171,8 +233,20 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	// insert in DB
 	var createdId int64
 	query, args := rc.query.insertQuery(storedFieldMap)
-	rc.env.cr.Get(&createdId, query, args...)
-
+	if len(rc.query.onConflict) > 0 {
+		var row struct {
+			ID       int64 `db:"id"`
+			Inserted bool  `db:"inserted"`
+		}
+		rc.env.cr.Get(&row, query, args...)
+		if !row.Inserted {
+			return nil, row.ID, fkData
+		}
+		createdId = row.ID
+	} else {
+		rc.env.cr.Get(&createdId, query, args...)
+	}
+	rc.env.cache.invalidateSearches()
 	rc.env.cache.addRecord(rc.model, createdId, storedFieldMap, rc.query.ctxArgsSlug())
 	rSet := rc.withIds([]int64{createdId})
 	// update reverse relation fields
@@ -181,11 +255,15 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	rSet.updateRelatedFields(fMap)
 	// process create data for reverse relations if any
 	rSet.createReverseRelationRecords(data)
+	rSet.updateLineNumbers(rSet.lineNumberParentIds())
+	rSet.touchParents(rSet.touchedParentIds())
 	// compute stored fields
 	rSet.processInverseMethods(data)
 	rSet.processTriggers(fMap.FieldNames(rSet.model))
+	rSet.checkCompany(data.Underlying().FieldNames())
 	rSet.CheckConstraints(data.Underlying().FieldNames())
-	return rSet
+	rSet.notifyChange(ChangeCreated, rSet.ids, data.Underlying().FieldNames())
+	return rSet, 0, nil
 }
 
 // createReverseRelationRecords creates the reverse records of relation fields when
@@ -305,18 +383,57 @@ func (rc *RecordCollection) applyContexts() *RecordCollection {
 		for ctxName, ctxFunc := range fi.contexts {
 			path := rc.model.FieldName(fmt.Sprintf("%sHexyaContexts%s%s", fi.name, ExprSep, ctxName))
 			ctxOrders = append(ctxOrders, orderPredicate{field: path, desc: true})
-			cond := rc.model.Field(path).IsNull()
-			if !rc.env.context.GetBool("hexya_default_contexts") {
-				cond = cond.Or().Field(path).Equals(ctxFunc)
-			}
-			ctxCond = ctxCond.AndCond(cond)
+			ctxCond = ctxCond.AndCond(rc.contextCondition(path, ctxFunc))
 		}
 	}
-	rc.query.ctxCond = ctxCond
+	rc.query.ctxCond = ctxCond.AndCond(rc.conditionContextsCondition(true))
 	rc.query.ctxOrders = ctxOrders
 	return rc
 }
 
+// conditionContextsCondition returns the condition to apply so that the
+// contexted fields used in this RecordSet query condition match either their
+// default value or their value for the current context.
+//
+// If relatedOnly is true, only the contexted fields of related models are taken
+// into account.
+func (rc *RecordCollection) conditionContextsCondition(relatedOnly bool) *Condition {
+	res := newCondition()
+	ctxPaths := make(map[string]bool)
+	for _, exprs := range rc.query.cond.getAllExpressions(rc.model) {
+		if len(exprs) == 0 || (relatedOnly && len(exprs) == 1) {
+			continue
+		}
+		fi := rc.model.getRelatedFieldInfo(joinFieldNames(exprs, ExprSep))
+		if fi.contexts == nil {
+			continue
+		}
+		ctxField := fmt.Sprintf("%sHexyaContexts", fi.name)
+		if len(exprs) > 1 {
+			ctxField = fmt.Sprintf("%s%s%s", joinFieldNames(exprs[:len(exprs)-1], ExprSep).Name(), ExprSep, ctxField)
+		}
+		for ctxName, ctxFunc := range fi.contexts {
+			path := rc.model.FieldName(fmt.Sprintf("%s%s%s", ctxField, ExprSep, ctxName))
+			if ctxPaths[path.Name()] {
+				continue
+			}
+			ctxPaths[path.Name()] = true
+			res = res.AndCond(rc.contextCondition(path, ctxFunc))
+		}
+	}
+	return res
+}
+
+// contextCondition returns the condition on the given contexts path so
+// that it matches either the default value or the value for ctxFunc.
+func (rc *RecordCollection) contextCondition(path FieldName, ctxFunc func(RecordSet) string) *Condition {
+	cond := rc.model.Field(path).IsNull()
+	if !rc.env.context.GetBool("hexya_default_contexts") {
+		cond = cond.Or().Field(path).Equals(ctxFunc)
+	}
+	return cond
+}
+
 // addContextsFieldsValues adds the contexts to the given fMap so that the resulting set can be filtered
 func (rc *RecordCollection) addContextsFieldsValues(fMap FieldMap) FieldMap {
 	res := make(FieldMap)
@@ -355,11 +472,101 @@ func (rc *RecordCollection) CheckConstraints(fields FieldNames) {
 	}
 }
 
+// checkCompany panics if a record of this RecordCollection is linked through
+// one of the given CheckCompany fields to a record of an incompatible company.
+//
+// If the Company field is among the given fields, all CheckCompany fields are checked.
+func (rc *RecordCollection) checkCompany(fields FieldNames) {
+	changed := make(map[string]bool)
+	for _, f := range fields {
+		changed[f.Name()] = true
+	}
+	var toCheck []*Field
+	for _, fi := range rc.model.fields.registryByName {
+		if !fi.checkCompany {
+			continue
+		}
+		if changed[fi.name] || changed["Company"] {
+			toCheck = append(toCheck, fi)
+		}
+	}
+	if len(toCheck) == 0 {
+		return
+	}
+	allowedCompanies := rc.allowedCompanies()
+	// Related records and their companies may not be readable by the user
+	for _, rec := range rc.Sudo().Records() {
+		company := rec.Get(rc.model.FieldName("Company")).(RecordSet).Collection()
+		var companyID int64
+		if !company.IsEmpty() {
+			companyID = company.ids[0]
+		}
+		for _, fi := range toCheck {
+			related := rec.Get(rc.model.FieldName(fi.name)).(RecordSet).Collection()
+			for _, relRec := range related.Records() {
+				relCompanyIds := relRec.Get(relRec.model.FieldName("Company")).(RecordSet).Ids()
+				if len(relCompanyIds) == 0 || companiesAreCompatible(companyID, relCompanyIds[0], allowedCompanies) {
+					continue
+				}
+				panic(exceptions.ValidationError{
+					Message: rc.T("Incompatible companies on records"),
+					Debug: fmt.Sprintf("model: %s, record: %s, company: %s, field: %s, relatedRecord: %s, relatedCompany: %d",
+						rc.model.name, rec, company, fi.name, relRec, relCompanyIds[0]),
+				})
+			}
+		}
+	}
+}
+
+// allowedCompanies returns the 'allowed_company_ids' of the context or, if there
+// are none, the company of the current user if the User model has a Company field.
+func (rc *RecordCollection) allowedCompanies() []int64 {
+	if companies := rc.env.context.GetIntegerSlice("allowed_company_ids"); len(companies) > 0 {
+		return companies
+	}
+	userModel, ok := Registry.Get("User")
+	if !ok {
+		return nil
+	}
+	if _, ok := userModel.fields.Get("Company"); !ok {
+		return nil
+	}
+	companyField := userModel.FieldName("Company")
+	user := rc.env.Pool(userModel.name).Sudo()
+	// The company of the user is read from the database once per transaction,
+	// and then from the cache, which is kept up to date when it is written.
+	if rc.env.cache.checkIfInCache(userModel, []int64{rc.env.uid}, []string{companyField.JSON()}, user.query.ctxArgsSlug(), true) {
+		return user.withIds([]int64{rc.env.uid}).Get(companyField).(RecordSet).Ids()
+	}
+	user = user.Search(userModel.Field(ID).Equals(rc.env.uid))
+	if user.IsEmpty() {
+		return nil
+	}
+	return user.Get(companyField).(RecordSet).Ids()
+}
+
+// companiesAreCompatible returns true if a record of the
+// relatedCompany can be linked to a record of recordCompany.
+//
+// A record without company (recordCompany is 0) can be linked to records
+// of any company of allowedCompanies.
+func companiesAreCompatible(recordCompany, relatedCompany int64, allowedCompanies []int64) bool {
+	if recordCompany != 0 {
+		return relatedCompany == recordCompany
+	}
+	for _, c := range allowedCompanies {
+		if c == relatedCompany {
+			return true
+		}
+	}
+	return false
+}
+
 // addAccessFieldsCreateData adds appropriate CreateDate and CreateUID fields to
 // the given FieldMap.
 func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
-	if !rc.model.isSystem() {
-		(*fMap)["CreateDate"] = dates.Now()
+	if rc.model.logAccess() {
+		(*fMap)["CreateDate"] = rc.env.Now()
 		(*fMap)["CreateUID"] = rc.env.uid
 	}
 }
@@ -370,12 +577,17 @@ func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Write")
 func (rc *RecordCollection) update(data RecordData) bool {
+	rc.checkNotDetached("Write")
+	rc.checkDataFields(data)
 	if !rc.hasNegIds && rc.ForceLoad(ID).IsEmpty() {
 		return true
 	}
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write)
 	// process create data for FK relations if any
 	data = rc.createFKRelationRecords(data)
+	rc.checkRequiredFields(data.Underlying(), false)
+	rc.checkSelectionValues(data.Underlying())
+	rc.checkJSONValues(data.Underlying())
 	fMap := data.Underlying().Copy().FieldMap
 	rSet.addAccessFieldsUpdateData(&fMap)
 	rSet.applyContexts()
@@ -383,9 +595,19 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	// We process inverse method before we convert RecordSets to ids
 	rSet.processInverseMethods(data)
 	rSet.model.convertValuesToFieldType(&fMap, true)
+	rSet.roundFloatValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePK()
 	storedFieldMap := rSet.filterMapOnStoredFields(fMap)
+	var lineParents []int64
+	renumber := rSet.lineNumbersDependOn(data.Underlying().FieldNames())
+	if renumber {
+		lineParents = rSet.lineNumberParentIds()
+	}
+	var touchedParents map[*Model][]int64
+	if rSet.touchParentsDependOn(data.Underlying().FieldNames()) {
+		touchedParents = rSet.touchedParentIds()
+	}
 	rSet.doUpdate(storedFieldMap)
 	// Let's fetch once for all
 	rSet.Fetch()
@@ -395,17 +617,83 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	rSet.updateRelatedFields(fMap)
 	// process create data for reverse relations if any
 	rSet.createReverseRelationRecords(data)
+	if renumber {
+		rSet.updateLineNumbers(append(lineParents, rSet.lineNumberParentIds()...))
+	}
+	rSet.touchParents(touchedParents, rSet.touchedParentIds())
 	// compute stored fields
 	rSet.processTriggers(fMap.FieldNames(rSet.model))
+	rSet.checkCompany(data.Underlying().FieldNames())
 	rSet.CheckConstraints(data.Underlying().FieldNames())
+	rSet.notifyChange(ChangeWritten, rSet.ids, data.Underlying().FieldNames())
 	return true
 }
 
+// writeOrCreate updates the record matching the given match data with
+// the given values or creates a new record with both match data and values
+// if no such record exists.
+//
+// It returns the updated or created record and true if it has been created.
+// It panics if several records match or if match holds records to create.
+func (rc *RecordCollection) writeOrCreate(match, values RecordData) (*RecordCollection, bool) {
+	rc.checkDataFields(match)
+	rc.checkDataFields(values)
+	if len(match.Underlying().ToCreate) > 0 {
+		log.Panic("Records to create cannot be matched in WriteOrCreate", "model", rc.model, "match", match)
+	}
+	matchFields := match.Underlying().FieldNames()
+	sort.Slice(matchFields, func(i, j int) bool {
+		return matchFields[i].JSON() < matchFields[j].JSON()
+	})
+	cond := newCondition()
+	for _, f := range matchFields {
+		cond = cond.AndCond(rc.model.Field(f).Equals(match.Underlying().Get(f)))
+	}
+	existing := rc.Search(cond).Fetch()
+	switch existing.Len() {
+	case 0:
+	case 1:
+		existing.Call("Write", values)
+		return existing, false
+	default:
+		log.Panic("Several records match in WriteOrCreate", "model", rc.model, "match", match, "records", existing)
+	}
+	data := values.Underlying().Copy()
+	for _, f := range matchFields {
+		data.Set(f, match.Underlying().Get(f))
+	}
+	if !rc.model.hasUniqueConstraintOn(matchFields) {
+		return rc.Call("Create", data).(RecordSet).Collection(), true
+	}
+	return rc.insertOrWrite(matchFields, data)
+}
+
+// insertOrWrite inserts a new record with the given data, unless a record
+// with the same values for the given matchFields, which must be those of a
+// unique constraint, has been created concurrently since our search. In this
+// case, the other values of data are written on this record.
+//
+// It returns the resulting record and true if it has been created.
+func (rc *RecordCollection) insertOrWrite(matchFields []FieldName, data *ModelData) (*RecordCollection, bool) {
+	rSet := rc.clone()
+	rSet.query.onConflict = matchFields
+	created, conflictID, fkData := rSet.createUnlessConflict(data)
+	if conflictID == 0 {
+		return created, true
+	}
+	for _, f := range matchFields {
+		fkData.Unset(f)
+	}
+	existing := rc.withIds([]int64{conflictID})
+	existing.Call("Write", fkData)
+	return existing, false
+}
+
 // addAccessFieldsUpdateData adds appropriate WriteDate and WriteUID fields to
 // the given FieldMap.
 func (rc *RecordCollection) addAccessFieldsUpdateData(fMap *FieldMap) {
-	if !rc.model.isSystem() {
-		(*fMap)["WriteDate"] = dates.Now()
+	if rc.model.logAccess() {
+		(*fMap)["WriteDate"] = rc.env.Now()
 		(*fMap)["WriteUID"] = rc.env.uid
 	}
 }
@@ -454,8 +742,12 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 	if !rc.hasNegIds {
 		query, args := rc.query.updateQuery(fMap)
 		res := rc.env.cr.Execute(query, args...)
+		rc.env.cache.invalidateSearches()
 		if num, _ := res.RowsAffected(); num == 0 {
-			log.Panic("Unexpected noop on update (num = 0)", "model", rc.ModelName(), "values", fMap, "query", query, "args", args)
+			panic(exceptions.MissingError{
+				Message: rc.T("The records to update do not exist or have been deleted"),
+				Debug:   fmt.Sprintf("model: %s, values: %v, query: %s, args: %v", rc.ModelName(), fMap, query, args),
+			})
 		}
 	}
 	for _, rec := range rc.Records() {
@@ -495,6 +787,7 @@ func (rc *RecordCollection) updateRelationFields(fMap FieldMap) {
 		case fieldtype.Many2Many:
 			delQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s IN (?)`, fi.m2mRelModel.tableName, fi.m2mOurField.json)
 			rc.env.cr.Execute(delQuery, rc.ids)
+			rc.env.cache.invalidateSearches()
 			for _, id := range rc.ids {
 				rc.env.cache.removeM2MLinks(fi, id)
 				query := fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES (?, ?)`, fi.m2mRelModel.tableName,
@@ -645,8 +938,9 @@ func (rc *RecordCollection) relatedFieldMap(fMap FieldMap, field FieldName) (Fie
 	return res, prefix
 }
 
-// substituteSQLErrorMessage changes the message from the given recover data
-// if it comes from the database with the message defined in this model
+// substituteSQLErrorMessage returns a ValidationError with the message defined
+// in this model if the given recover data is the violation of an SQL constraint
+// of this model. Otherwise, r is returned as is.
 func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{} {
 	err, ok := r.(error)
 	if !ok {
@@ -654,8 +948,10 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 	}
 	for constraintName, constraint := range rc.model.sqlConstraints {
 		if strings.Contains(err.Error(), constraintName) {
-			res := adapters[db.DriverName()].substituteErrorMessage(err, constraint.errorString)
-			return res
+			return exceptions.ValidationError{
+				Message: rc.T(constraint.errorString),
+				Debug:   err.Error(),
+			}
 		}
 	}
 	return r
@@ -666,6 +962,7 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 // Instead use rs.Unlink() or rs.Call("Unlink")
 func (rc *RecordCollection) unlink() int64 {
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Unlink"))
+	rc.checkNotDetached("Unlink")
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Unlink)
 	ids := rSet.Ids()
 	if rSet.IsEmpty() {
@@ -673,23 +970,36 @@ func (rc *RecordCollection) unlink() int64 {
 	}
 	// get recomputate data to update after unlinking
 	compData := rc.retrieveComputeData(rc.model.fields.allFieldNames())
+	lineParents := rSet.lineNumberParentIds()
+	touchedParents := rSet.touchedParentIds()
 	var num int64
 	if !rSet.hasNegIds {
 		query, args := rSet.query.deleteQuery()
 		res := rSet.env.cr.Execute(query, args...)
+		rSet.env.cache.invalidateSearches()
 		num, _ = res.RowsAffected()
 	}
 	for _, id := range ids {
 		rc.env.cache.invalidateRecord(rc.model, id)
 	}
+	rc.updateLineNumbers(lineParents)
+	rc.touchParents(touchedParents)
 	// Update stored fields that referenced this recordset
 	rc.updateStoredFields(compData)
+	rSet.notifyChange(ChangeUnlinked, ids, nil)
 	return num
 }
 
 // Search returns a new RecordSet filtering on the current one with the
-// additional given Condition
+// additional given Condition.
+//
+// It panics with an exceptions.ValidationError if a field of the condition
+// does not exist or cannot be searched. Use ValidateDomain first to check
+// untrusted conditions, including the read access of the current user.
 func (rc *RecordCollection) Search(cond *Condition) *RecordCollection {
+	if err := rc.validateCondition(cond, false); err != nil {
+		panic(err)
+	}
 	rSetVal := *rc
 	rSetVal.query = rc.query.clone(&rSetVal)
 	rSetVal.query.cond = rSetVal.query.cond.AndCond(cond)
@@ -697,10 +1007,21 @@ func (rc *RecordCollection) Search(cond *Condition) *RecordCollection {
 }
 
 // Limit returns a new RecordSet with only the first 'limit' records.
+//
+// A zero limit returns an empty RecordSet without querying the database,
+// whatever the condition. Use SearchCount to get the number of matching
+// records instead. A negative limit removes the limit of this RecordSet.
 func (rc *RecordCollection) Limit(limit int) *RecordCollection {
 	rSet := *rc
 	rSet.query = rSet.query.clone(&rSet)
-	rSet.query.limit = limit
+	switch {
+	case limit < 0:
+		rSet.query.limit = 0
+	case limit == 0:
+		rSet.query.limit = zeroLimit
+	default:
+		rSet.query.limit = limit
+	}
 	return &rSet
 }
 
@@ -712,7 +1033,66 @@ func (rc *RecordCollection) Offset(offset int) *RecordCollection {
 	return &rSet
 }
 
-// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions
+// ForUpdate returns a new RecordSet whose records are locked when fetched,
+// until the end of the transaction. Concurrent transactions trying to lock
+// or update the same records wait for this transaction to finish.
+//
+// Transactions run by default with the serializable isolation level, which
+// WithIsolation can lower. At this level, a concurrent transaction that updated
+// a locked record makes this transaction fail with a serialization error, which
+// is retried by ExecuteInNewEnvironment.
+// Locks are only taken when the records are fetched from the database, which
+// ForUpdate forces even if they are in cache.
+//
+// It panics when the records are fetched if the transaction is read only.
+func (rc *RecordCollection) ForUpdate() *RecordCollection {
+	return rc.withLock(lockForUpdate)
+}
+
+// ForUpdateSkipLocked returns a new RecordSet which locks its records like
+// ForUpdate, but which ignores the records already locked by another transaction
+// instead of waiting for them. This is typically used to implement queues: each
+// worker gets, for instance with Limit, the next records not processed by another worker.
+func (rc *RecordCollection) ForUpdateSkipLocked() *RecordCollection {
+	return rc.withLock(lockForUpdateSkipLocked)
+}
+
+// ForUpdateNoWait returns a new RecordSet which locks its records like
+// ForUpdate, but which fails immediately instead of waiting if one of the
+// records is already locked by another transaction.
+func (rc *RecordCollection) ForUpdateNoWait() *RecordCollection {
+	return rc.withLock(lockForUpdateNoWait)
+}
+
+// withLock returns a new RecordSet that locks its records
+// with the given lockMode when fetched.
+func (rc *RecordCollection) withLock(lock lockMode) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.lock = lock
+	rSet.fetched = false
+	return &rSet
+}
+
+// checkLockAllowed panics if the records of this RecordCollection
+// cannot be locked in the current transaction.
+func (rc *RecordCollection) checkLockAllowed() {
+	if rc.hasNegIds {
+		log.Panic("Memory RecordSets cannot be locked", "model", rc.model.name)
+	}
+	if rc.env.cr.isReadOnly() {
+		log.Panic("Records can only be locked within a write transaction", "model", rc.model.name)
+	}
+}
+
+// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions.
+//
+// Each expression is a field name or a dot separated path to a field of a related
+// model (e.g. "Partner.Name"), optionally followed by "asc" or "desc" and by
+// "nulls first" or "nulls last", such as "DateDue desc nulls last".
+// Several expressions can also be given in a single comma separated string,
+// such as "Sequence, DateDue desc".
+// Records are always finally ordered by ID, so that the order is deterministic.
 func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
 	rSet := *rc
 	rSet.query = rSet.query.clone(&rSet)
@@ -720,6 +1100,31 @@ func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
 	return &rSet
 }
 
+// OrderBySimilarity returns a new RecordSet ordered by decreasing trigram
+// similarity of the given field with text, so that the records closest to
+// text come first. The orders of this RecordSet apply afterwards.
+//
+// field must be a stored field with the Trigram option.
+func (rc *RecordCollection) OrderBySimilarity(field FieldName, text string) *RecordCollection {
+	checkSimilarityField(rc.model.getRelatedFieldInfo(field))
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.orders = append([]orderPredicate{{field: field, desc: true, bySimilarity: true, similarTo: text}},
+		rc.query.orders...)
+	return &rSet
+}
+
+// OrderByIds returns a new RecordSet whose records are in the order of the
+// given ids, such as a list drag-ordered by the user in the client. Records
+// that are not in ids come last. The orders of this RecordSet apply afterwards.
+func (rc *RecordCollection) OrderByIds(ids []int64) *RecordCollection {
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.orders = append([]orderPredicate{{field: ID, byIds: append([]int64{}, ids...)}},
+		rc.query.orders...)
+	return &rSet
+}
+
 // GroupBy returns a new RecordSet grouped with the given GROUP BY expressions
 func (rc *RecordCollection) GroupBy(fields ...FieldName) *RecordCollection {
 	rSet := *rc
@@ -732,6 +1137,54 @@ func (rc *RecordCollection) GroupBy(fields ...FieldName) *RecordCollection {
 	return &rSet
 }
 
+// DistinctOn returns a new RecordSet with only the first record of each set of records
+// having the same values for the given fields. The first record of each set is determined
+// by the order of the RecordSet.
+//
+// The given fields do not need to appear in the order: they are automatically
+// prepended to it when selecting the first record of each set. The final result is
+// then sorted with the RecordSet order only.
+//
+// It panics if one of the given fields is not stored in the database.
+func (rc *RecordCollection) DistinctOn(fields ...FieldName) *RecordCollection {
+	for _, f := range fields {
+		fi := rc.model.getRelatedFieldInfo(f)
+		if fi.fieldType.IsNonStoredRelationType() || (fi.isComputedField() && !fi.stored) {
+			log.Panic("DistinctOn can only be used with fields stored in the database", "model", rc.model, "field", f)
+		}
+	}
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.distinctOn = make([]FieldName, len(fields))
+	copy(rSet.query.distinctOn, fields)
+	return &rSet
+}
+
+// LimitPerPartition returns a new RecordSet with at most limit records of each set of
+// records having the same values for the given fields, e.g. the last three orders of each
+// customer. The records kept in each set are the first ones in the order of the RecordSet.
+//
+// The records of all sets are returned in a single query, sorted with the RecordSet order.
+// Limit and Offset apply to the whole result. It panics if one of the given fields is not
+// stored in the database or if limit is not positive.
+func (rc *RecordCollection) LimitPerPartition(limit int, fields ...FieldName) *RecordCollection {
+	if limit <= 0 {
+		log.Panic("LimitPerPartition limit must be positive", "model", rc.model, "limit", limit)
+	}
+	for _, f := range fields {
+		fi := rc.model.getRelatedFieldInfo(f)
+		if fi.fieldType.IsNonStoredRelationType() || (fi.isComputedField() && !fi.stored) {
+			log.Panic("LimitPerPartition can only be used with fields stored in the database", "model", rc.model, "field", f)
+		}
+	}
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.partitionBy = make([]FieldName, len(fields))
+	copy(rSet.query.partitionBy, fields)
+	rSet.query.partitionLimit = limit
+	return &rSet
+}
+
 // Fetch query the database with the current filter and returns a RecordSet
 // with the queries ids.
 //
@@ -760,7 +1213,15 @@ func (rc *RecordCollection) SearchAll() *RecordCollection {
 // SearchCount fetch from the database the number of records that match the RecordSet conditions
 // It panics in case of error
 func (rc *RecordCollection) SearchCount() int {
-	rSet := rc.Limit(0)
+	if rc.query.cond.isImpossible() {
+		return 0
+	}
+	rc.checkNotDetached("SearchCount")
+	rSet := rc.Limit(-1)
+	if rSet.query.hasMemoryOrders() {
+		// Orders do not change the count and cannot be executed in database
+		rSet.query.orders = nil
+	}
 	rSet.applyDefaultOrder()
 	rSet.applyContexts()
 	addNameSearchesToCondition(rSet.model, rSet.query.cond)
@@ -768,25 +1229,71 @@ func (rc *RecordCollection) SearchCount() int {
 	query, args := rSet.query.countQuery()
 	var res int
 	rSet.env.cr.Get(&res, query, args...)
-	return res
+	return rSet.query.sample.scaleCount(res)
 }
 
 // Load look up fields of the RecordCollection in cache and query the database
 // for missing values which are then stored in cache.
+//
+// If no fields are given, the fields given to OnlyFields are loaded, or all
+// the stored fields of the model if OnlyFields has not been called.
 func (rc *RecordCollection) Load(fields ...FieldName) *RecordCollection {
 	if len(fields) == 0 {
-		fields = rc.model.fields.storedFieldNames()
+		fields = rc.loadedFieldNames()
 	}
 	cacheFields := make([]string, len(fields))
 	for i, v := range fields {
 		cacheFields[i] = v.JSON()
 	}
-	if rc.env.cache.checkIfInCache(rc.model, rc.ids, cacheFields, rc.query.ctxArgsSlug(), true) {
+	if rc.query.lock == noLock && rc.env.cache.checkIfInCache(rc.model, rc.ids, cacheFields, rc.query.ctxArgsSlug(), true) {
 		return rc
 	}
 	return rc.ForceLoad(fields...)
 }
 
+// OnlyFields returns a new RecordSet which only loads the given fields
+// from the database when reading a field value which is not in cache,
+// instead of all the stored fields of the model.
+//
+// The read strategy of RecordSets is the following: Search only fetches
+// the ids of the records. The first time a field value that is not in
+// cache is read, this field and the fields given to OnlyFields (or all the
+// stored fields by default) are loaded with a single query for all the
+// records of the RecordSet, including the records obtained from it with
+// Records. Other fields are loaded the same way later, when first read.
+//
+// This is useful to reduce the data transferred when only a few fields of
+// a model with many columns are needed.
+func (rc *RecordCollection) OnlyFields(fields ...FieldName) *RecordCollection {
+	rSet := *rc
+	rSet.onlyFields = append([]FieldName{ID}, fields...)
+	return &rSet
+}
+
+// loadedFieldNames returns the fields to load from the database
+// when a field value is not in cache.
+//
+// Binary fields are not loaded by default in bin_size mode.
+func (rc *RecordCollection) loadedFieldNames() []FieldName {
+	if rc.onlyFields == nil {
+		fields := rc.model.fields.storedFieldNames()
+		if !rc.env.context.GetBool("bin_size") {
+			return fields
+		}
+		res := make([]FieldName, 0, len(fields))
+		for _, f := range fields {
+			if rc.model.fields.MustGet(f.JSON()).fieldType == fieldtype.Binary {
+				continue
+			}
+			res = append(res, f)
+		}
+		return res
+	}
+	res := make([]FieldName, len(rc.onlyFields))
+	copy(res, rc.onlyFields)
+	return res
+}
+
 // ForceLoad query all data of the RecordCollection and store in cache.
 // fields are the fields to retrieve in the path format,
 // i.e. "User.Profile.Age" or "user_id.profile_id.age".
@@ -800,15 +1307,29 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 		// Never load RecordSets without query.
 		return rc
 	}
+	if rc.env.detached && rc.fetched {
+		// Detached records are only in cache
+		return rc
+	}
+	rc.checkNotDetached("Search")
 	if rc.hasNegIds {
 		log.Panic("Trying to load a memory RecordSet created by New", "model", rc.model, "ids", rc.ids)
 	}
 	if len(rc.query.groups) > 0 {
 		log.Panic("Trying to load a grouped query", "model", rc.model, "groups", rc.query.groups)
 	}
+	if rc.query.limit == zeroLimit || rc.query.cond.isImpossible() {
+		return rc.withIds(nil)
+	}
+	if rc.query.hasMemoryOrders() {
+		return rc.forceLoadWithMemoryOrders(fieldNames...)
+	}
 	rSet := rc
+	if rc.query.lock != noLock {
+		rc.checkLockAllowed()
+	}
 	var prefetch bool
-	if !rc.prefetchRC.IsEmpty() && len(rc.ids) > 0 {
+	if !rc.prefetchRC.IsEmpty() && len(rc.ids) > 0 && rc.query.lock == noLock {
 		// We have a prefetch recordSet and our ids are already fetched
 		prefetch = true
 		rSet = rc.Union(rc.prefetchRC).WithEnv(rc.Env())
@@ -827,7 +1348,7 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 	rSet = rSet.substituteRelatedInQuery()
 	dbFields := filterOnDBFields(rSet.model, subFields)
 	query, args, substs := rSet.query.selectQuery(dbFields)
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := rSet.env.cr.query(query, args...)
 	defer rows.Close()
 	var ids []int64
 	for rows.Next() {
@@ -849,11 +1370,51 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 	return rSet
 }
 
-// applyDefaultOrder adds the model's default order if this query has no specific order defined
+// DebugSQL returns the SQL query and arguments that would be executed to load the
+// given fields of this RecordCollection, without executing it. If no fields are given,
+// all DB columns of the model are selected, as in Load.
+//
+// The query includes the record rules of the current user, the default order and
+// the contexts conditions. The RecordCollection itself is not modified.
+func (rc *RecordCollection) DebugSQL(fieldNames ...FieldName) (string, []interface{}) {
+	rSet := rc.clone()
+	rSet.query.cond = rc.query.cond.deepCopy()
+	rSet = rSet.addRecordRuleConditions(rc.env.uid, security.Read)
+	rSet.applyDefaultOrder()
+	fields := make([]FieldName, len(fieldNames))
+	copy(fields, fieldNames)
+	if len(fields) == 0 {
+		fields = rSet.model.fields.storedFieldNames()
+	}
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	rSet.applyContexts()
+	subFields, _ := rSet.substituteRelatedFields(fields)
+	rSet = rSet.substituteRelatedInQuery()
+	dbFields := filterOnDBFields(rSet.model, subFields)
+	query, args, _ := rSet.query.selectQuery(dbFields)
+	return sanitizeQuery(query, args...)
+}
+
+// applyDefaultOrder adds the model's default order if this query has no specific order defined.
+//
+// It also adds an ID order if the query is not already ordered by ID,
+// so that records with the same values in the ordered fields are always returned in the same order.
 func (rc *RecordCollection) applyDefaultOrder() {
-	if len(rc.query.orders) == 0 {
-		rc.query.orders = make([]orderPredicate, len(rc.model.defaultOrder))
-		copy(rc.query.orders, rc.model.defaultOrder)
+	orders := rc.query.orders
+	if len(orders) == 0 {
+		orders = rc.model.defaultOrder
+	}
+	var hasID bool
+	for _, order := range orders {
+		if order.field.JSON() == ID.JSON() && order.byIds == nil {
+			hasID = true
+			break
+		}
+	}
+	rc.query.orders = make([]orderPredicate, len(orders), len(orders)+1)
+	copy(rc.query.orders, orders)
+	if !hasID {
+		rc.query.orders = append(rc.query.orders, orderPredicate{field: ID})
 	}
 }
 
@@ -885,7 +1446,11 @@ func (rc *RecordCollection) loadRelationFields(fields FieldNames) {
 			case fieldtype.One2Many:
 				relRC := rc.env.Pool(fi.relatedModelName)
 				// We do not call "Fetch" directly to have caller method properly set
-				relRC = relRC.Search(relRC.Model().Field(relRC.Model().FieldName(fi.reverseFK)).Equals(thisRC)).Call("Fetch").(RecordSet).Collection()
+				relRC = relRC.Search(relRC.Model().Field(relRC.Model().FieldName(fi.reverseFK)).Equals(thisRC))
+				if len(fi.orders) > 0 {
+					relRC.query.orders = fi.orders
+				}
+				relRC = relRC.Call("Fetch").(RecordSet).Collection()
 				rc.env.cache.updateEntry(rc.model, id, fName.JSON(), relRC.ids, rc.query.ctxArgsSlug())
 			case fieldtype.Many2Many:
 				query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, fi.m2mTheirField.json,
@@ -940,6 +1505,15 @@ func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 		res = fMap[fi.json]
 	case fi.isRelatedField():
 		res = rc.Get(rc.substituteRelatedInPath(fieldName))
+	case rc.binSizeMode(fi):
+		relRC := rc
+		if len(exprs) > 1 {
+			relRC = rc.Get(joinFieldNames(exprs[:len(exprs)-1], ExprSep)).(RecordSet).Collection()
+		}
+		res = ""
+		if relRC.IsNotEmpty() {
+			res = relRC.binarySize(fi)
+		}
 	default:
 		if rc.hasNegIds && len(exprs) > 1 {
 			// We have a negative ID, but we fetch a related field
@@ -962,7 +1536,11 @@ func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 	}
 
 	if fi.isRelationField() {
-		res = rc.convertToRecordSet(res, fi.relatedModelName)
+		relRC := rc.convertToRecordSet(res, fi.relatedModelName)
+		if fi.fieldType.Is2ManyRelationType() && !fi.isComputedField() && !fi.isRelatedField() {
+			relRC = rc.withoutArchivedRecords(fi, relRC)
+		}
+		res = relRC
 	}
 	return res
 }
@@ -992,7 +1570,7 @@ func (rc *RecordCollection) get(field FieldName, all bool) (interface{}, bool) {
 	if !rc.hasNegIds && !isInCache {
 		fields := []FieldName{field}
 		if all {
-			fields = append(fields, rc.model.fields.storedFieldNames()...)
+			fields = append(fields, rc.loadedFieldNames()...)
 		}
 		rc.Load(fields...)
 		if rc.IsEmpty() {
@@ -1049,10 +1627,18 @@ func (rc *RecordCollection) All() []*ModelData {
 }
 
 // Aggregates returns the result of this RecordCollection query, which must by a grouped query.
+//
+// Groups by a many2one field are ordered by the default order of the related
+// model if this RecordCollection has no order. If the context has the
+// group_expand key set to true, empty groups are added for the related
+// records without records when grouping by a single many2one field.
 func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregateRow {
 	if len(rc.query.groups) == 0 {
 		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
 	}
+	if rc.query.limit == zeroLimit {
+		return nil
+	}
 	groups := make([]FieldName, len(rc.query.groups))
 	copy(groups, rc.query.groups)
 
@@ -1067,7 +1653,7 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 
 	query, args := rSet.query.selectGroupQuery(rSet.fieldsGroupOperators(dbFields))
 	var res []GroupAggregateRow
-	rows := dbQuery(rSet.env.cr.tx, query, args...)
+	rows := rSet.env.cr.query(query, args...)
 	defer rows.Close()
 
 	for rows.Next() {
@@ -1079,13 +1665,18 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 		cnt := vals["__count"].(int64)
 		delete(vals, "__count")
 		vals = substituteKeys(vals, substMap)
+		values := NewModelDataFromRS(rc, vals)
+		rSet.roundFloatValues(values.FieldMap)
 		line := GroupAggregateRow{
-			Values:    NewModelDataFromRS(rc, vals),
-			Count:     int(cnt),
+			Values:    values,
+			Count:     rSet.query.sample.scaleCount(int(cnt)),
 			Condition: getGroupCondition(groups, vals, rc.query.cond),
 		}
 		res = append(res, line)
 	}
+	if rc.env.context.GetBool("group_expand") {
+		res = rc.expandRelationGroups(groups, res)
+	}
 	return res
 }
 
@@ -1119,11 +1710,20 @@ func (rc *RecordCollection) fixGroupByOrders(fieldNames ...FieldName) *RecordCol
 		}
 	}
 	if len(rc.query.orders) == 0 {
-		orders := make([]string, len(rSet.query.groups))
-		for i, g := range rSet.query.groups {
-			orders[i] = g.JSON()
+		var (
+			orders   []orderPredicate
+			relOrder []FieldName
+		)
+		for _, g := range rSet.query.groups {
+			for _, o := range rc.relationGroupOrders(g) {
+				orders = append(orders, o)
+				relOrder = append(relOrder, o.field)
+			}
+			orders = append(orders, orderPredicate{field: g})
 		}
-		rSet = rSet.OrderBy(orders...)
+		// Fields of the related records must be grouped to order by them
+		rSet = rSet.GroupBy(relOrder...)
+		rSet.query.orders = orders
 	}
 	return rSet
 }
@@ -1172,6 +1772,7 @@ func (rc *RecordCollection) Records() []*RecordCollection {
 		newRC := newRecordCollection(rc.Env(), rc.ModelName())
 		res[i] = newRC.withIds([]int64{id})
 		res[i].prefetchRC = rc
+		res[i].onlyFields = rc.onlyFields
 	}
 	return res
 }
@@ -1230,6 +1831,35 @@ func (rc *RecordCollection) GetRecord(externalID string) *RecordCollection {
 	return res
 }
 
+// ResolveExternalIDs returns the records of this model with the given externalIDs
+// in a map keyed by external ID, as well as the external IDs that do not exist
+// in the order they are given. All external IDs are resolved in a single query.
+//
+// Contrary to GetRecord, it does not panic on unknown external IDs, so that all
+// broken references can be reported at once, e.g. when validating data files.
+func (rc *RecordCollection) ResolveExternalIDs(externalIDs []string) (map[string]*RecordCollection, []string) {
+	extIDField := rc.model.FieldName("HexyaExternalID")
+	found := make(map[string]*RecordCollection)
+	var missing []string
+	if len(externalIDs) == 0 {
+		return found, missing
+	}
+	records := rc.Search(rc.model.Field(extIDField).In(externalIDs))
+	records.ForceLoad(ID, extIDField)
+	for _, rec := range records.Records() {
+		found[rec.Get(extIDField).(string)] = rec
+	}
+	seen := make(map[string]bool)
+	for _, extID := range externalIDs {
+		if _, ok := found[extID]; ok || seen[extID] {
+			continue
+		}
+		seen[extID] = true
+		missing = append(missing, extID)
+	}
+	return found, missing
+}
+
 // withIdMap adds the given ids to this RecordCollection and returns it too.
 //
 // It removes duplicates and overrides the current query with ("ID", "in", ids).
@@ -1266,6 +1896,8 @@ func (rc *RecordCollection) withIds(ids []int64) *RecordCollection {
 		rc.query.fetchAll = false
 		rc.query.limit = 0
 		rc.query.offset = 0
+		// Records with ids have already been locked if needed
+		rc.query.lock = noLock
 	}
 	return rc
 }
@@ -1284,6 +1916,25 @@ func (rc *RecordCollection) T(src string, args ...interface{}) string {
 	return fmt.Sprintf(transCode, args...)
 }
 
+// SelectionLabel returns the label of the value of the given selection field
+// for this record, translated to the language specified by the 'lang' key of
+// rc.Env().Context(). It returns the empty string if the field is not set.
+//
+// It panics if field is not a selection field.
+func (rc *RecordCollection) SelectionLabel(field FieldName) string {
+	rc.EnsureOne()
+	fi := rc.model.getRelatedFieldInfo(field)
+	if fi.fieldType != fieldtype.Selection {
+		log.Panic("SelectionLabel called on a non selection field", "model", rc.model, "field", field)
+	}
+	value := reflect.ValueOf(rc.Get(field)).String()
+	if value == "" {
+		return ""
+	}
+	lang := rc.Env().Context().GetString("lang")
+	return i18n.Registry.TranslateFieldSelection(lang, fi.model.name, fi.name, fi.selection)[value]
+}
+
 // Collection returns the underlying RecordCollection instance
 // i.e. itself
 func (rc *RecordCollection) Collection() *RecordCollection {
@@ -23,7 +23,6 @@ import (
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
-	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 	"github.com/hexya-erp/hexya/src/tools/typesutils"
 	"github.com/jmoiron/sqlx"
@@ -44,6 +43,7 @@ type modelCollection struct {
 	bootstrapped        bool
 	registryByName      map[string]*Model
 	registryByTableName map[string]*Model
+	ordered             []*Model
 	sequences           map[string]*Sequence
 }
 
@@ -95,12 +95,26 @@ func (mc *modelCollection) add(mi *Model) {
 	if _, exists := mc.Get(mi.name); exists {
 		log.Panic("Trying to add already existing model", "model", mi.name)
 	}
+	if other, exists := mc.registryByTableName[mi.tableName]; exists {
+		log.Panic("Table name is already used by another model", "model", mi.name, "table", mi.tableName, "other", other.name)
+	}
 	mc.registryByName[mi.name] = mi
 	mc.registryByTableName[mi.tableName] = mi
+	mc.ordered = append(mc.ordered, mi)
 	mi.methods.model = mi
 	mi.fields.model = mi
 }
 
+// orderedModels returns all the models of this modelCollection in the order
+// in which they have been declared.
+//
+// Since models are declared in the init function of their module and Go runs
+// the init functions of imported packages first, models of a module come
+// after the models of the modules it depends on.
+func (mc *modelCollection) orderedModels() []*Model {
+	return mc.ordered
+}
+
 // add the given Model to the modelCollection
 func (mc *modelCollection) addSequence(s *Sequence) {
 	if _, exists := mc.GetSequence(s.JSON); exists {
@@ -123,18 +137,26 @@ func newModelCollection() *modelCollection {
 // A Model is the definition of a business object (e.g. a partner, a sale order, etc.)
 // including fields and methods.
 type Model struct {
-	name            string
-	options         Option
-	rulesRegistry   *recordRuleRegistry
-	tableName       string
-	fields          *FieldsCollection
-	methods         *MethodsCollection
-	mixins          []*Model
-	sqlConstraints  map[string]sqlConstraint
-	sqlErrors       map[string]string
-	defaultOrderStr []string
-	defaultOrder    []orderPredicate
-	created         bool
+	name              string
+	options           Option
+	rulesRegistry     *recordRuleRegistry
+	tableName         string
+	fields            *FieldsCollection
+	methods           *MethodsCollection
+	mixins            []*Model
+	sqlConstraints    map[string]sqlConstraint
+	sqlErrors         map[string]string
+	defaultOrderStr   []string
+	defaultOrder      []orderPredicate
+	lineNumberParent  FieldName
+	touchParentFields []FieldName
+	nameSearchFields  []FieldName
+	searchScope       SearchScope
+	activeField       FieldName
+	noLogAccess       bool
+	created           bool
+	viewQuery         string
+	viewRefreshPeriod time.Duration
 }
 
 // An sqlConstraint holds the data needed to create a table constraint in the database
@@ -219,6 +241,10 @@ func (m *Model) scanToFieldMap(r sqlx.ColScanner, dest *FieldMap, substs map[str
 	// Step 2: We populate our dest FieldMap with these values
 	for i, dbValue := range dbValues {
 		colName := columns[i]
+		if strings.HasPrefix(colName, aggregateOrderAliasPrefix) {
+			// Aggregates selected for ordering are not field values
+			continue
+		}
 		if s, ok := substs[colName]; ok {
 			colName = s
 		}
@@ -303,6 +329,28 @@ func (m *Model) isSystem() bool {
 	return false
 }
 
+// SetLogAccess sets whether the CreateDate, CreateUID, WriteDate and WriteUID
+// fields of this model are maintained automatically. This is the default.
+//
+// Opting out saves these updates on tables with a high write rate. The fields
+// are still declared, so that they can be read, searched and ordered by, but
+// they are left empty, unless set explicitly.
+//
+// Transient models always log access, since their records are freed
+// after their CreateDate.
+func (m *Model) SetLogAccess(value bool) {
+	if !value && m.IsTransient() {
+		log.Panic("Transient models must log access", "model", m.name)
+	}
+	m.noLogAccess = !value
+}
+
+// logAccess returns true if the access fields of this model are
+// maintained automatically.
+func (m *Model) logAccess() bool {
+	return !m.isSystem() && !m.noLogAccess
+}
+
 // isContext returns true if this is a context model.
 func (m *Model) isContext() bool {
 	if m.options&ContextsModel > 0 {
@@ -350,21 +398,48 @@ func (m *Model) Methods() *MethodsCollection {
 // default order is 'id asc'.
 //
 // Give the order fields in separate strings, such as
-// model.SetDefaultOrder("Name desc", "date asc", "id")
+// model.SetDefaultOrder("Name desc", "date asc", "id"), or in a
+// single comma separated string, such as "Name desc, date asc, id".
+// See RecordCollection.OrderBy for the syntax of each order.
 func (m *Model) SetDefaultOrder(orders ...string) {
 	m.defaultOrderStr = orders
 }
 
-// ordersFromStrings returns the given order by exprs as a slice of order structs
+// ordersFromStrings returns the given order by exprs as a slice of order structs.
+// Each expr may hold several comma separated order by expressions.
+//
+// It panics if one of the exprs is not a valid order by expression.
 func (m *Model) ordersFromStrings(exprs []string) []orderPredicate {
-	res := make([]orderPredicate, len(exprs))
-	for i, o := range exprs {
-		toks := strings.Split(o, " ")
-		var desc bool
-		if len(toks) > 1 && strings.ToLower(toks[1]) == "desc" {
-			desc = true
+	var orders []string
+	for _, expr := range exprs {
+		orders = append(orders, strings.Split(expr, ",")...)
+	}
+	res := make([]orderPredicate, len(orders))
+	for i, o := range orders {
+		toks := strings.Fields(o)
+		if len(toks) == 0 {
+			log.Panic("Empty order by expression", "model", m.name)
+		}
+		order := orderPredicate{field: m.FieldName(toks[0])}
+		toks = toks[1:]
+		if len(toks) > 0 {
+			switch strings.ToUpper(toks[0]) {
+			case "DESC":
+				order.desc = true
+				toks = toks[1:]
+			case "ASC":
+				toks = toks[1:]
+			}
+		}
+		switch {
+		case len(toks) == 0:
+		case len(toks) == 2 && strings.ToUpper(toks[0]) == "NULLS" &&
+			(strings.ToUpper(toks[1]) == "FIRST" || strings.ToUpper(toks[1]) == "LAST"):
+			order.nulls = strings.ToUpper(toks[1])
+		default:
+			log.Panic("Invalid order by expression", "model", m.name, "expr", o)
 		}
-		res[i] = orderPredicate{field: m.FieldName(toks[0]), desc: desc}
+		res[i] = order
 	}
 	return res
 }
@@ -462,6 +537,47 @@ func (m *Model) FilteredOn(field FieldName, condition *Condition) *Condition {
 	return &res
 }
 
+// AnyOf returns a condition on the given one2many, many2many or many2one field which
+// is true for the records that have at least one related record matching
+// the given condition. See ConditionStart.AnyOf.
+func (m *Model) AnyOf(field FieldName, condition *Condition) *Condition {
+	return newCondition().And().AnyOf(field, condition)
+}
+
+// AllOf returns a condition on the given one2many or many2many field which
+// is true for the records whose related records all match the given condition.
+// See ConditionStart.AllOf.
+func (m *Model) AllOf(field FieldName, condition *Condition) *Condition {
+	return newCondition().And().AllOf(field, condition)
+}
+
+// AggregateCondition returns a ConditionField on the aggregate of the given
+// field of the related records of a one2many or many2many field which match
+// the given condition. See ConditionStart.AggregateCondition.
+func (m *Model) AggregateCondition(field FieldName, function AggregateFunction, aggregated FieldName, condition *Condition) *ConditionField {
+	return newCondition().And().AggregateCondition(field, function, aggregated, condition)
+}
+
+// InBoundingBox returns a condition which is true for the records whose
+// lat and lng fields fall within the given bounding box.
+// See ConditionStart.InBoundingBox.
+func (m *Model) InBoundingBox(lat, lng FieldName, south, west, north, east float64) *Condition {
+	return newCondition().And().InBoundingBox(lat, lng, south, west, north, east)
+}
+
+// CreatedBy returns a condition which is true for the records that have
+// been created by the user with the given id. See ConditionStart.CreatedBy.
+func (m *Model) CreatedBy(uid int64) *Condition {
+	return newCondition().And().CreatedBy(uid)
+}
+
+// FollowedBy returns a condition which is true for the records that have a
+// followers record whose follower field equals value.
+// See ConditionStart.FollowedBy.
+func (m *Model) FollowedBy(followers, follower FieldName, value interface{}) *Condition {
+	return newCondition().And().FollowedBy(followers, follower, value)
+}
+
 // Create creates a new record in this model with the given data.
 func (m *Model) Create(env Environment, data interface{}) *RecordCollection {
 	return env.Pool(m.name).Call("Create", data).(RecordSet).Collection()
@@ -499,6 +615,47 @@ func (m *Model) AddSQLConstraint(name, sql, errorString string) {
 	}
 }
 
+// hasUniqueConstraintOn returns true if the given fields are exactly the fields
+// of a unique constraint of this model. This constraint can be either a unique field,
+// or an SQL constraint defined as "UNIQUE(col1, col2, ...)".
+func (m *Model) hasUniqueConstraintOn(fields []FieldName) bool {
+	cols := make(map[string]bool)
+	for _, f := range fields {
+		fi, ok := m.fields.Get(f.JSON())
+		if !ok || !fi.isStored() || fi.isContextedField() {
+			return false
+		}
+		cols[fi.json] = true
+	}
+	if len(fields) == 1 {
+		fi := m.fields.MustGet(fields[0].JSON())
+		if fi.unique || fi.fieldType == fieldtype.One2One {
+			return true
+		}
+	}
+	for _, constraint := range m.sqlConstraints {
+		sql := strings.ToLower(strings.Replace(constraint.sql, " ", "", -1))
+		if !strings.HasPrefix(sql, "unique(") || !strings.HasSuffix(sql, ")") {
+			continue
+		}
+		constraintCols := strings.Split(strings.TrimSuffix(strings.TrimPrefix(sql, "unique("), ")"), ",")
+		if len(constraintCols) != len(cols) {
+			continue
+		}
+		match := true
+		for _, col := range constraintCols {
+			if !cols[col] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
 // RemoveSQLConstraint removes the sql constraint with the given name from the database.
 func (m *Model) RemoveSQLConstraint(name string) {
 	delete(m.sqlConstraints, fmt.Sprintf("%s_mancon", name))
@@ -509,6 +666,29 @@ func (m *Model) TableName() string {
 	return m.tableName
 }
 
+// SetTableName sets the name of the database table of this Model.
+//
+// By default, the table name is the snake case of the model's name. Setting
+// another name allows to map a model on an existing table. The column names of
+// the fields can be set likewise with their JSON parameter.
+//
+// It panics if another model already uses this table name or if the models
+// have already been bootstrapped.
+func (m *Model) SetTableName(name string) {
+	if Registry.bootstrapped {
+		log.Panic("Table names must not be modified after bootstrap", "model", m.name, "table", name)
+	}
+	if name == m.tableName {
+		return
+	}
+	if other, exists := Registry.registryByTableName[name]; exists {
+		log.Panic("Table name is already used by another model", "model", m.name, "table", name, "other", other.name)
+	}
+	delete(Registry.registryByTableName, m.tableName)
+	m.tableName = name
+	Registry.registryByTableName[name] = m
+}
+
 // Underlying returns the underlying Model data object, i.e. itself
 func (m *Model) Underlying() *Model {
 	return m
@@ -543,6 +723,14 @@ func NewMixinModel(name string) *Model {
 	return model
 }
 
+// NewUUIDModel creates a new model with the given name whose table has a
+// uuid primary key, generated at creation in its UUID field. See HasUUIDKey.
+func NewUUIDModel(name string) *Model {
+	model := getOrCreateModel(name, UUIDModel)
+	model.InheritModel(Registry.MustGet("ModelMixin"))
+	return model
+}
+
 // NewTransientModel creates a new mixin model with the given name.
 func NewTransientModel(name string) *Model {
 	model := getOrCreateModel(name, TransientModel)
@@ -593,6 +781,9 @@ func CreateModel(name string, options Option) *Model {
 		).Field(0),
 	}
 	mi.fields.add(pk)
+	if options&UUIDModel > 0 {
+		mi.fields.add(newUUIDKeyField(mi))
+	}
 	Registry.add(mi)
 	return mi
 }
@@ -683,7 +874,7 @@ func FreeTransientModels() {
 		if model.IsTransient() {
 			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
 				createDate := model.FieldName("CreateDate")
-				model.Search(env, model.Field(createDate).Lower(dates.Now().Add(-transientModelTimeout))).Call("Unlink")
+				model.Search(env, model.Field(createDate).Lower(env.Now().Add(-transientModelTimeout))).Call("Unlink")
 			})
 		}
 	}
@@ -0,0 +1,83 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+// emptyRequiredFields returns the descriptions and the names of the required
+// fields of this RecordCollection's model which have an empty value in data,
+// both sorted alphabetically.
+//
+// If all is true, required fields that are not set in data are also returned.
+// Otherwise, only the fields set in data are checked, so that a partial write
+// does not need to give the values of required fields it does not change.
+func (rc *RecordCollection) emptyRequiredFields(data *ModelData, all bool) ([]string, []string) {
+	var missing, missingNames []string
+	for _, fi := range rc.model.fields.registryByJSON {
+		if !fi.required || fi.json == "id" || !fi.isStored() || fi.isComputedField() || fi.isRelatedField() || fi.embed {
+			continue
+		}
+		val, exists := data.FieldMap.Get(rc.model.FieldName(fi.name))
+		if !exists && !all {
+			continue
+		}
+		if isEmptyValue(fi, val) {
+			missing = append(missing, fi.description)
+			missingNames = append(missingNames, fi.name)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(missingNames)
+	return missing, missingNames
+}
+
+// checkRequiredFields panics with a UserError if a required field has an empty
+// value in the given data. If create is true, all required fields must be set
+// in data, otherwise only the fields set in data are checked.
+func (rc *RecordCollection) checkRequiredFields(data *ModelData, create bool) {
+	missing, missingNames := rc.emptyRequiredFields(data, create)
+	if len(missing) == 0 {
+		return
+	}
+	panic(exceptions.UserError{
+		Message: rc.T("The following fields are required: %s", strings.Join(missing, ", ")),
+		Debug:   fmt.Sprintf("model: %s, fields: %s", rc.model.name, strings.Join(missingNames, ", ")),
+	})
+}
+
+// isEmptyValue returns true if the given value of the given field would not
+// set a value to a required field, i.e. if it would be NULL in the database
+// or if it is an empty string.
+//
+// Numbers and booleans are never empty, since their zero value is a value.
+func isEmptyValue(fi *Field, value interface{}) bool {
+	switch val := value.(type) {
+	case nil:
+		return true
+	case bool:
+		// Clients give false for empty values
+		return !val && fi.fieldType != fieldtype.Boolean
+	case *interface{}:
+		return val == nil || *val == nil
+	case RecordSet:
+		return val.IsEmpty()
+	case string:
+		return val == ""
+	case interface{ IsZero() bool }:
+		return (fi.fieldType == fieldtype.Date || fi.fieldType == fieldtype.DateTime) && val.IsZero()
+	}
+	if fi.fieldType.IsFKRelationType() {
+		id, err := nbutils.CastToInteger(value)
+		return err == nil && id == 0
+	}
+	return false
+}
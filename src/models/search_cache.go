@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// SearchCached returns a new RecordSet filtering on the current one with the
+// additional given Condition, like Search, with its ids already fetched.
+//
+// The ids found are kept in the cache of the transaction so that running the
+// same search again does not query the database. Cached ids are keyed by the
+// SQL query and by a signature of the record rules that apply to the current
+// user, so that they are never shared between users with different visibility,
+// even with Sudo or WithUser on the same transaction.
+//
+// Cached searches are cleared each time records are created, updated or deleted
+// in the transaction. Searches locking their records are never cached.
+func (rc *RecordCollection) SearchCached(cond *Condition) *RecordCollection {
+	rSet := rc.Search(cond)
+	if rSet.hasNegIds || rSet.query.lock != noLock || rSet.query.isEmpty() {
+		return rSet.Fetch()
+	}
+	key := rSet.searchCacheKey()
+	if ids, ok := rc.env.cache.getSearch(key); ok {
+		return rSet.withIds(ids)
+	}
+	rSet = rSet.ForceLoad(ID)
+	rc.env.cache.setSearch(key, rSet.ids)
+	return rSet
+}
+
+// searchCacheKey returns the key of the ids of this RecordCollection
+// in the searches cache.
+func (rc *RecordCollection) searchCacheKey() string {
+	query, args := rc.DebugSQL(ID)
+	return fmt.Sprintf("%s|%s|%s|%s|%v", rc.model.name, rc.recordRulesSignature(security.Read),
+		rc.query.ctxArgsSlug(), query, args)
+}
+
+// recordRulesSignature returns a string identifying the record rules
+// that apply to the user of this RecordCollection for the given permission.
+//
+// Two users with the same signature see the same records of this model.
+func (rc *RecordCollection) recordRulesSignature(perm security.Permission) string {
+	if rc.filtered {
+		return "filtered"
+	}
+	rc.model.rulesRegistry.RLock()
+	defer rc.model.rulesRegistry.RUnlock()
+	var rules []string
+	for name, rule := range rc.model.rulesRegistry.globalRules {
+		if perm&rule.Perms > 0 {
+			rules = append(rules, name)
+		}
+	}
+	for group := range security.Registry.UserGroups(rc.env.uid) {
+		for _, rule := range rc.model.rulesRegistry.rulesByGroup[group.ID()] {
+			if perm&rule.Perms > 0 {
+				rules = append(rules, fmt.Sprintf("%s/%s", group.ID(), rule.Name))
+			}
+		}
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ",")
+}
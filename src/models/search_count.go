@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// SearchWithCount fetches the records of this RecordCollection within its limit
+// and offset, and returns them with the total number of records matching its
+// condition, whatever its limit and offset.
+//
+// When possible, the total is selected with the records in a single query,
+// so that paginated lists need a single round trip to the database instead
+// of a Fetch and a SearchCount. It falls back to a separate count query if
+// the database has no support for it, if the page is empty, or if this
+// RecordCollection is ordered in memory, locked or detached.
+//
+// Both the records and the total take into account the record rules of the
+// current user.
+func (rc *RecordCollection) SearchWithCount() (*RecordCollection, int) {
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	if rc.query.isEmpty() {
+		// We do not load empty queries to keep empty record sets empty
+		return rc, 0
+	}
+	if !rc.canSelectTotalCount() {
+		count := rc.visibleCount()
+		return rc.Fetch(), count
+	}
+	rSet := rc.clone()
+	rSet.query.cond = rc.query.cond.deepCopy()
+	rSet = rSet.addRecordRuleConditions(rc.env.uid, security.Read)
+	rSet.applyDefaultOrder()
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	rSet.applyContexts()
+	rSet = rSet.substituteRelatedInQuery()
+	query, args := rSet.query.selectWithTotalCountQuery()
+	rows := rSet.env.cr.query(query, args...)
+	defer rows.Close()
+	var (
+		ids   []int64
+		total int
+	)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id, &total); err != nil {
+			log.Panic(err.Error(), "model", rSet.ModelName())
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		// The offset may be after the last record
+		return rSet.withIds(nil), rc.visibleCount()
+	}
+	return rSet.withIds(ids), rSet.query.sample.scaleCount(total)
+}
+
+// canSelectTotalCount returns true if the total count of this RecordCollection
+// can be selected in the same query as its records.
+func (rc *RecordCollection) canSelectTotalCount() bool {
+	switch {
+	case adapters[db.DriverName()].totalCountSQL() == "":
+		return false
+	case rc.env.detached, rc.hasNegIds:
+		return false
+	case len(rc.query.groups) > 0, rc.query.lock != noLock, rc.query.hasMemoryOrders():
+		return false
+	case rc.query.limit == zeroLimit, rc.query.cond.isImpossible():
+		return false
+	}
+	return true
+}
+
+// visibleCount returns the number of records matching the condition of this
+// RecordCollection that the current user can read, whatever its limit and offset.
+func (rc *RecordCollection) visibleCount() int {
+	rSet := rc.clone()
+	rSet.query.cond = rc.query.cond.deepCopy()
+	return rSet.addRecordRuleConditions(rc.env.uid, security.Read).Offset(0).SearchCount()
+}
+
+// selectWithTotalCountQuery returns the SQL query string and parameters to
+// retrieve the ids of the rows of this Query, with in each row the number of
+// rows of this Query without its limit and offset.
+func (q *Query) selectWithTotalCountQuery() (string, SQLParams) {
+	subQuery, args, _ := q.selectSubQuery([]FieldName{ID})
+	orderSQL, orderArgs := q.sqlOrderByClause()
+	selQuery := fmt.Sprintf(`SELECT foo.id, %s FROM (%s) foo %s %s`,
+		adapters[db.DriverName()].totalCountSQL(), subQuery, orderSQL, q.sqlLimitOffsetClause())
+	return q.withPlannerHints(selQuery), args.Extend(orderArgs)
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// A SearchScope returns the condition that the records of a model must match
+// to be found by the searches made in the given Environment.
+type SearchScope func(env Environment) Conditioner
+
+// SetSearchScope sets the default search scope of this model, which is AND-ed
+// with the condition of the Search, SearchAll and SearchCached methods, and
+// therefore of name searches, such as:
+//
+//	h.BlogPost().SetSearchScope(func(env models.Environment) models.Conditioner {
+//	    return q.BlogPost().Published().Equals(true)
+//	})
+//
+// Unlike record rules, search scopes are a business default and not a security
+// feature: Browse and BrowseOne find records outside the scope, as well as
+// relation fields, and the scope is not applied when the context has the
+// no_search_scope key set to true, e.g. for backend access.
+//
+// The scope is evaluated when the search is made. Record rules still apply to
+// the records of the scope.
+func (m *Model) SetSearchScope(scope SearchScope) {
+	m.searchScope = scope
+}
+
+// withSearchScope returns a new RecordCollection restricted to the search
+// scope of its model, unless it has already been applied to this RecordCollection
+// or the context has no_search_scope set to true.
+//
+// Archived records of models with an active field are also left out, unless
+// the context has active_test set to false.
+func (rc *RecordCollection) withSearchScope() *RecordCollection {
+	if rc.scoped {
+		return rc
+	}
+	rSet := rc.withActiveTest()
+	if rc.model.searchScope != nil && !rc.env.context.GetBool("no_search_scope") {
+		rSet = rSet.Search(rc.model.searchScope(rc.Env()).Underlying())
+	}
+	if rSet != rc {
+		rSet.scoped = true
+	}
+	return rSet
+}
+
+// withoutSearchScope returns a copy of this RecordCollection to which
+// the search scope of its model will not be applied.
+func (rc *RecordCollection) withoutSearchScope() *RecordCollection {
+	rSet := *rc
+	rSet.scoped = true
+	return &rSet
+}
@@ -16,7 +16,10 @@ package security
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+
+	"github.com/beevik/etree"
 )
 
 const (
@@ -82,9 +85,35 @@ func (g *Group) ImpliedGroups() []*Group {
 	return res
 }
 
-// Implies returns true if this Group implies other Group.
+// AllImpliedGroups returns a slice of all Groups implied by this Group,
+// either directly or through other implied groups.
+func (g *Group) AllImpliedGroups() []*Group {
+	visited := make(map[*Group]bool)
+	g.addImpliedGroups(visited)
+	res := make([]*Group, 0, len(visited))
+	for group := range visited {
+		res = append(res, group)
+	}
+	return res
+}
+
+// addImpliedGroups recursively adds all the groups implied by this Group to the given set.
+func (g *Group) addImpliedGroups(set map[*Group]bool) {
+	for group, ok := range g.inherits {
+		if !ok || set[group] {
+			continue
+		}
+		set[group] = true
+		group.addImpliedGroups(set)
+	}
+}
+
+// Implies returns true if this Group implies other Group,
+// either directly or through other implied groups.
 func (g *Group) Implies(other *Group) bool {
-	return g.inherits[other]
+	visited := make(map[*Group]bool)
+	g.addImpliedGroups(visited)
+	return visited[other]
 }
 
 // A GroupCollection keeps a list of groups
@@ -201,7 +230,7 @@ func (gc *GroupCollection) doRemoveMembership(uid int64, group *Group) {
 	// Remove our group
 	delete(gc.memberships[uid], group)
 	// Remove all inherited groups
-	for _, grp := range group.ImpliedGroups() {
+	for _, grp := range group.AllImpliedGroups() {
 		if gc.memberships[uid][grp] == InheritedGroup {
 			delete(gc.memberships[uid], grp)
 		}
@@ -232,6 +261,18 @@ func (gc *GroupCollection) HasMembership(uid int64, group *Group) bool {
 	return ok
 }
 
+// HasGroup returns true if the given uid is a member of the group with
+// the given groupID, either directly or through implied groups.
+//
+// It panics if there is no group with this ID.
+func (gc *GroupCollection) HasGroup(uid int64, groupID string) bool {
+	group := gc.GetGroup(groupID)
+	if group == nil {
+		log.Panic("Unknown group", "group", groupID)
+	}
+	return gc.HasMembership(uid, group)
+}
+
 // UserGroups returns the slice of groups the user with the given
 // uid belongs to, including inherited groups.
 func (gc *GroupCollection) UserGroups(uid int64) map[*Group]InheritanceInfo {
@@ -262,3 +303,36 @@ func NewGroupCollection() *GroupCollection {
 	}
 	return &gc
 }
+
+// LoadFromEtree reads the group given as etree.Element and registers it in
+// the given GroupCollection. The group element is of the form:
+//
+//	<group id="sales_manager" name="Sales Manager" implies="sales_user,base_employee"/>
+//
+// where implies is an optional comma separated list of the IDs of the groups implied
+// by this group. Implied groups must have been registered before.
+func (gc *GroupCollection) LoadFromEtree(element *etree.Element) *Group {
+	id := element.SelectAttrValue("id", "")
+	if id == "" {
+		log.Panic("Group definition without id")
+	}
+	var implied []*Group
+	for _, impliedID := range strings.Split(element.SelectAttrValue("implies", ""), ",") {
+		impliedID = strings.TrimSpace(impliedID)
+		if impliedID == "" {
+			continue
+		}
+		group := gc.GetGroup(impliedID)
+		if group == nil {
+			log.Panic("Unknown implied group", "group", id, "implied", impliedID)
+		}
+		implied = append(implied, group)
+	}
+	return gc.NewGroup(id, element.SelectAttrValue("name", id), implied...)
+}
+
+// LoadFromEtree reads the group given as etree.Element and
+// registers it in the groups Registry.
+func LoadFromEtree(element *etree.Element) {
+	Registry.LoadFromEtree(element)
+}
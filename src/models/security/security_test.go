@@ -17,6 +17,7 @@ package security
 import (
 	"testing"
 
+	"github.com/beevik/etree"
 	"github.com/hexya-erp/hexya/src/models/types"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -161,6 +162,54 @@ func TestGroupRegistry(t *testing.T) {
 	})
 }
 
+func TestImpliedGroups(t *testing.T) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(`<data>
+	<group id="implied_user_test" name="User"/>
+	<group id="implied_manager_test" name="Manager" implies="implied_user_test"/>
+	<group id="implied_admin_test" implies="implied_manager_test"/>
+</data>`); err != nil {
+		t.Fatal(err)
+	}
+	for _, elt := range doc.Root().ChildElements() {
+		LoadFromEtree(elt)
+	}
+	user := Registry.GetGroup("implied_user_test")
+	manager := Registry.GetGroup("implied_manager_test")
+	admin := Registry.GetGroup("implied_admin_test")
+	Convey("Testing implied groups closure", t, func() {
+		Convey("Groups should be loaded from XML", func() {
+			So(user, ShouldNotBeNil)
+			So(manager, ShouldNotBeNil)
+			So(admin, ShouldNotBeNil)
+			So(manager.Name(), ShouldEqual, "Manager")
+			So(admin.Name(), ShouldEqual, "implied_admin_test")
+			So(func() {
+				elt := etree.NewElement("group")
+				elt.CreateAttr("id", "implied_unknown_test")
+				elt.CreateAttr("implies", "no_such_group")
+				LoadFromEtree(elt)
+			}, ShouldPanic)
+		})
+		Convey("Implied groups should be transitive", func() {
+			So(admin.Implies(manager), ShouldBeTrue)
+			So(admin.Implies(user), ShouldBeTrue)
+			So(user.Implies(admin), ShouldBeFalse)
+			So(admin.ImpliedGroups(), ShouldHaveLength, 1)
+			So(admin.AllImpliedGroups(), ShouldHaveLength, 2)
+		})
+		Convey("HasGroup should check the closure of the user groups", func() {
+			Registry.AddMembership(10, admin)
+			So(Registry.HasGroup(10, "implied_admin_test"), ShouldBeTrue)
+			So(Registry.HasGroup(10, "implied_user_test"), ShouldBeTrue)
+			So(func() { Registry.HasGroup(10, "no_such_group") }, ShouldPanic)
+			Registry.RemoveMembership(10, admin)
+			So(Registry.HasGroup(10, "implied_manager_test"), ShouldBeFalse)
+			So(Registry.HasGroup(10, "implied_user_test"), ShouldBeFalse)
+		})
+	})
+}
+
 type simpleAuthBackend struct{}
 
 func (a simpleAuthBackend) Authenticate(login, secret string, _ *types.Context) (int64, error) {
@@ -46,6 +46,9 @@ type recordRuleRegistry struct {
 func (rrr *recordRuleRegistry) addRule(rule *RecordRule) {
 	rrr.Lock()
 	defer rrr.Unlock()
+	if _, exists := rrr.rulesByName[rule.Name]; exists {
+		rrr.deleteRule(rule.Name)
+	}
 	rrr.rulesByName[rule.Name] = rule
 	if rule.Global {
 		rrr.globalRules[rule.Name] = rule
@@ -59,11 +62,17 @@ func (rrr *recordRuleRegistry) addRule(rule *RecordRule) {
 func (rrr *recordRuleRegistry) removeRule(name string) {
 	rrr.Lock()
 	defer rrr.Unlock()
-	rule, exists := rrr.rulesByName[name]
-	if !exists {
+	if _, exists := rrr.rulesByName[name]; !exists {
 		log.Warn("Trying to remove non-existent record rule", "name", name)
 		return
 	}
+	rrr.deleteRule(name)
+}
+
+// deleteRule removes the existing RecordRule with the given name
+// from the rule registry. The registry must be locked by the caller.
+func (rrr *recordRuleRegistry) deleteRule(name string) {
+	rule := rrr.rulesByName[name]
 	delete(rrr.rulesByName, name)
 	if rule.Global {
 		delete(rrr.globalRules, name)
@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// currentSelection returns the options of this selection field. If the field
+// has a selection function, it is evaluated each time, so that options added
+// since bootstrap are taken into account. Selection functions take no
+// environment, so the options cannot depend on the context of the write.
+func (f *Field) currentSelection() types.Selection {
+	if f.selectionFunc != nil {
+		return f.selectionFunc()
+	}
+	return f.selection
+}
+
+// checkSelectionValues panics with a ValidationError if the given data sets
+// a selection field to a value that is not one of its options. Empty values
+// are always allowed, since they unset the field.
+func (rc *RecordCollection) checkSelectionValues(data *ModelData) {
+	for field, value := range data.FieldMap {
+		fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(field))
+		if fi.fieldType != fieldtype.Selection || isEmptyValue(fi, value) {
+			continue
+		}
+		var key string
+		switch val := reflect.ValueOf(value); val.Kind() {
+		case reflect.String:
+			key = val.String()
+		default:
+			key = fmt.Sprintf("%v", value)
+		}
+		selection := fi.currentSelection()
+		if _, ok := selection[key]; ok {
+			continue
+		}
+		allowed := make([]string, 0, len(selection))
+		for k := range selection {
+			allowed = append(allowed, k)
+		}
+		sort.Strings(allowed)
+		panic(exceptions.ValidationError{
+			Message: rc.T("Invalid value '%s' for field %s. Allowed values are: %s", key, fi.description, strings.Join(allowed, ", ")),
+			Debug:   fmt.Sprintf("model: %s, field: %s, value: %v", rc.model.name, fi.name, value),
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isStoredRelatedField returns true if this field is a related field whose
+// column must be kept in sync with the value at the end of its path.
+//
+// Related fields created for embedded models are excluded since they are
+// always read or written through the embedded record, as well as fields
+// with contexts whose related path goes through their contexts records.
+func (f *Field) isStoredRelatedField() bool {
+	if !f.isRelatedField() || !f.isStored() || f.isContextedField() {
+		return false
+	}
+	exprs := splitFieldNames(f.relatedPath, ExprSep)
+	if len(exprs) == 2 && f.model.fields.MustGet(exprs[0].JSON()).embed {
+		return false
+	}
+	return true
+}
+
+// storedRelatedPath returns the JSON tokens of the path of the given stored
+// related field, where the related fields on the path are substituted by
+// their own path, so that it only goes through actual relations.
+//
+// It returns false if the path goes through a relation which is not a
+// many2one or one2one, since the related value is not unique then.
+func storedRelatedPath(fi *Field) ([]string, bool) {
+	exprs := strings.Split(fi.relatedPath.JSON(), ExprSep)
+	model := fi.model
+	var res []string
+	for i := 0; i < len(exprs); i++ {
+		tokenFI := model.fields.MustGet(exprs[i])
+		if tokenFI.isRelatedField() && !tokenFI.isContextedField() {
+			subExprs := strings.Split(tokenFI.relatedPath.JSON(), ExprSep)
+			exprs = append(append(append([]string{}, exprs[:i]...), subExprs...), exprs[i+1:]...)
+			i--
+			continue
+		}
+		res = append(res, tokenFI.json)
+		if i == len(exprs)-1 {
+			break
+		}
+		if !tokenFI.fieldType.IsFKRelationType() {
+			return nil, false
+		}
+		model = tokenFI.relatedModel
+	}
+	return res, true
+}
+
+// processRelatedDepends adds to each field on the path of a stored related
+// field the computeData to update the column of the related field when the
+// value of this field changes.
+func processRelatedDepends() {
+	for _, mi := range Registry.registryByTableName {
+		for _, fInfo := range mi.fields.registryByJSON {
+			if !fInfo.isStoredRelatedField() {
+				continue
+			}
+			tokens, ok := storedRelatedPath(fInfo)
+			if !ok {
+				log.Warn("Stored related field does not follow many2one or one2one relations and will not be updated",
+					"model", mi.name, "field", fInfo.name, "path", fInfo.relatedPathStr)
+				continue
+			}
+			for i, token := range tokens {
+				path := strings.Join(tokens[:i], ExprSep)
+				refModelInfo := mi
+				if path != "" {
+					refModelInfo = mi.getRelatedModelInfo(mi.FieldName(path))
+				}
+				refField := refModelInfo.fields.MustGet(token)
+				refField.dependencies = append(refField.dependencies, computeData{
+					model:     mi,
+					stored:    true,
+					related:   true,
+					fieldName: fInfo.name,
+					path:      path,
+				})
+			}
+		}
+	}
+}
+
+// updateStoredRelatedField sets the column of the given stored related field
+// of the records of this RecordCollection to the value at the end of its path,
+// in a single query for all the records.
+func (rc *RecordCollection) updateStoredRelatedField(fi *Field) {
+	if rc.IsEmpty() {
+		return
+	}
+	tokens, _ := storedRelatedPath(fi)
+	path := rc.model.FieldName(strings.Join(tokens, ExprSep))
+	rSet := rc.env.Pool(rc.model.name).withIds(rc.ids)
+	subQuery, args, substs := rSet.query.selectQuery([]FieldName{ID, path})
+	alias := strings.Join(tokens, sqlSep)
+	for realAlias, natAlias := range substs {
+		if natAlias == alias {
+			alias = realAlias
+		}
+	}
+	tableName := adapters[db.DriverName()].quoteTableName(rc.model.tableName)
+	query := fmt.Sprintf(`UPDATE %s SET %s = foo.%s FROM (%s) foo WHERE %s.id = foo.id`,
+		tableName, fi.json, alias, subQuery, tableName)
+	rc.env.cr.Execute(query, args...)
+	rSet.notifyChange(ChangeWritten, rSet.ids, FieldNames{rc.model.FieldName(fi.name)})
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -26,6 +27,9 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// initStages records the models whose Init method has been called
+var initStages []string
+
 func testPrefixdUser(rc *RecordCollection, prefix string) []string {
 	var res []string
 	for _, u := range rc.Records() {
@@ -45,7 +49,27 @@ func TestModelDeclaration(t *testing.T) {
 		addressMI := NewMixinModel("AddressMixIn")
 		activeMI := NewMixinModel("ActiveMixIn")
 		viewModel := NewManualModel("UserView")
+		statsModel := NewMaterializedViewModel("UserPostStats", `
+			SELECT u.id, COUNT(p.id) AS nb_posts
+			FROM "user" u
+				LEFT JOIN "post" p ON p.user_id = u.id
+			GROUP BY u.id`)
 		wizard := NewTransientModel("Wizard")
+		fiscalPeriod := NewModel("FiscalPeriod")
+		company := NewModel("Company")
+		project := NewModel("Project")
+		device := NewUUIDModel("Device")
+		sensor := NewModel("Sensor")
+		checklist := NewModel("Checklist")
+		checklistItem := NewModel("ChecklistItem")
+		badge := NewModel("Badge")
+		medal := NewModel("Medal")
+		award := NewModel("Award")
+		memo := NewModel("Memo")
+		invoice := NewModel("Invoice")
+		invoiceLine := NewModel("InvoiceLine")
+		note := NewModel("Note")
+		office := NewModel("Office")
 
 		userModel.NewMethod("PrefixedUser", testPrefixdUser)
 
@@ -275,6 +299,16 @@ func TestModelDeclaration(t *testing.T) {
 				return fmt.Sprintf("[%s]", res)
 			})
 
+		profileModel.Methods().MustGet("CopyData").Extend(
+			func(rc *RecordCollection, overrides RecordData) *ModelData {
+				res := rc.Super().Call("CopyData", overrides).(RecordData).Underlying()
+				moneyField := rc.Model().FieldName("Money")
+				if overrides == nil || !overrides.Underlying().Has(moneyField) {
+					res.Set(moneyField, 0.0)
+				}
+				return res
+			})
+
 		post.NewMethod("ComputeRead",
 			func(rc *RecordCollection) *ModelData {
 				var read bool
@@ -312,15 +346,38 @@ func TestModelDeclaration(t *testing.T) {
 				return NewModelData(rc.Model()).Set(rc.Model().FieldName("TagsNames"), res)
 			})
 
+		post.NewMethod("ComputeCommentsCount",
+			func(rc *RecordCollection) *ModelData {
+				return NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("CommentsCount"), int64(rc.Get(rc.Model().FieldName("Comments")).(RecordSet).Len()))
+			})
+
 		post.NewMethod("ComputeWriterAge",
 			func(rc *RecordCollection) *ModelData {
+				if computes, ok := rc.Env().Context().Get("writer_age_computes").(*int); ok {
+					// Tests count the calls to this method
+					*computes++
+				}
 				return NewModelData(rc.Model()).
 					Set(rc.Model().FieldName("WriterAge"),
 						rc.Get(rc.Model().FieldName("User")).(RecordSet).Collection().Get(Registry.MustGet("User").FieldName("Age")).(int16))
 			})
 
 		post.NewMethod("Init",
-			func(rc *RecordCollection) {})
+			func(rc *RecordCollection) {
+				initStages = append(initStages, "Post")
+			})
+
+		viewModel.NewMethod("Init",
+			func(rc *RecordCollection) {
+				initStages = append(initStages, "UserView")
+				rc.Env().Cr().Execute(`DROP VIEW IF EXISTS user_view;
+					CREATE VIEW user_view AS (
+						SELECT u.id, u.name, p.city, u.active
+						FROM "user" u
+							LEFT JOIN "profile" p ON p.id = u.profile_id
+					)`)
+			})
 
 		tag.NewMethod("CheckRate",
 			func(rc *RecordCollection) {
@@ -551,6 +608,19 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 			description: "Educational Background",
 		})
+		userModel.fields.add(&Field{
+			model:       userModel,
+			name:        "Motto",
+			json:        "motto",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			readOnlyFunc: func(env Environment) (bool, Conditioner) {
+				return false, userModel.Field(userModel.FieldName("IsPremium")).Equals(true)
+			},
+			invisibleFunc: func(env Environment) (bool, Conditioner) {
+				return env.Context().GetBool("hide_motto"), nil
+			},
+		})
 		userModel.AddSQLConstraint("nums_premium", "CHECK((is_premium = TRUE AND nums IS NOT NULL AND nums > 0) OR (IS_PREMIUM = false))",
 			"Premium users must have positive nums")
 
@@ -603,6 +673,7 @@ func TestModelDeclaration(t *testing.T) {
 			json:        "city",
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			unaccent:    true,
 		})
 		profileModel.fields.add(&Field{
 			model:       profileModel,
@@ -610,6 +681,7 @@ func TestModelDeclaration(t *testing.T) {
 			json:        "country",
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			trigram:     true,
 		})
 		profileModel.fields.add(&Field{
 			model:          profileModel,
@@ -663,6 +735,14 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.Text,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
+		post.fields.add(&Field{
+			model:       post,
+			name:        "Labels",
+			json:        "labels",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			jsonIndex:   true,
+		})
 		post.fields.add(&Field{
 			model:       post,
 			name:        "Attachment",
@@ -707,6 +787,18 @@ func TestModelDeclaration(t *testing.T) {
 			reverseFK:        "Post",
 			noCopy:           true,
 		})
+		post.fields.add(&Field{
+			model:       post,
+			name:        "CommentsCount",
+			json:        "comments_count",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			compute:     "ComputeCommentsCount",
+			orderAggregate: &OrderAggregate{
+				Field:    post.FieldName("Comments"),
+				Function: AggregateCount,
+			},
+		})
 		post.fields.add(&Field{
 			model:          post,
 			name:           "LastCommentText",
@@ -751,6 +843,15 @@ func TestModelDeclaration(t *testing.T) {
 			relatedPathStr: "User.PMoney",
 			defaultFunc:    DefaultValue(0),
 		})
+		post.fields.add(&Field{
+			model:          post,
+			name:           "WriterEmail",
+			json:           "writer_email",
+			fieldType:      fieldtype.Char,
+			structField:    reflect.StructField{Type: reflect.TypeOf("")},
+			relatedPathStr: "User.Email",
+			stored:         true,
+		})
 		post.SetDefaultOrder("Title")
 
 		comment.fields.add(&Field{
@@ -781,6 +882,15 @@ func TestModelDeclaration(t *testing.T) {
 			relatedPathStr: "PostWriter.PMoney",
 			defaultFunc:    DefaultValue(0),
 		})
+		comment.fields.add(&Field{
+			model:          comment,
+			name:           "WriterEmail",
+			json:           "writer_email",
+			fieldType:      fieldtype.Char,
+			structField:    reflect.StructField{Type: reflect.TypeOf("")},
+			relatedPathStr: "PostWriter.Email",
+			stored:         true,
+		})
 		comment.fields.add(&Field{
 			model:       comment,
 			name:        "Text",
@@ -826,6 +936,15 @@ func TestModelDeclaration(t *testing.T) {
 			onDelete:         SetNull,
 			relatedModelName: "Tag",
 		})
+		tag.fields.add(&Field{
+			model:            tag,
+			name:             "UserView",
+			json:             "user_view_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         SetNull,
+			relatedModelName: "UserView",
+		})
 		tag.fields.add(&Field{
 			model:       tag,
 			name:        "Description",
@@ -944,6 +1063,14 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
 
+		statsModel.fields.add(&Field{
+			model:       statsModel,
+			name:        "NbPosts",
+			json:        "nb_posts",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		})
+
 		wizard.fields.add(&Field{
 			model:       wizard,
 			name:        "Name",
@@ -959,5 +1086,301 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
 			defaultFunc: DefaultValue(0),
 		})
+
+		fiscalPeriod.fields.add(&Field{
+			model:       fiscalPeriod,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		fiscalPeriod.fields.add(&Field{
+			model:       fiscalPeriod,
+			name:        "DateStart",
+			json:        "date_start",
+			fieldType:   fieldtype.Date,
+			structField: reflect.StructField{Type: reflect.TypeOf(dates.Date{})},
+		})
+		fiscalPeriod.fields.add(&Field{
+			model:       fiscalPeriod,
+			name:        "DateStop",
+			json:        "date_stop",
+			fieldType:   fieldtype.Date,
+			structField: reflect.StructField{Type: reflect.TypeOf(dates.Date{})},
+		})
+		RegisterFiscalCalendar(&FiscalCalendar{
+			Model:     "FiscalPeriod",
+			DateStart: fiscalPeriod.FieldName("DateStart"),
+			DateStop:  fiscalPeriod.FieldName("DateStop"),
+			Condition: func(env Environment) Conditioner {
+				if !env.Context().HasKey("fiscal_company") {
+					return nil
+				}
+				return fiscalPeriod.Field(fiscalPeriod.FieldName("Name")).Contains(env.Context().GetString("fiscal_company"))
+			},
+			YearStart: func(env Environment) (time.Month, int) {
+				if !env.Context().HasKey("fiscal_year_month") {
+					return time.January, 1
+				}
+				return time.Month(env.Context().GetInteger("fiscal_year_month")), int(env.Context().GetInteger("fiscal_year_day"))
+			},
+		})
+		So(func() { RegisterFiscalCalendar(&FiscalCalendar{Model: "FiscalPeriod"}) }, ShouldPanic)
+
+		company.fields.add(&Field{
+			model:       company,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		userModel.fields.add(&Field{
+			model:            userModel,
+			name:             "Company",
+			json:             "company_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Company",
+			onDelete:         SetNull,
+		})
+		project.fields.add(&Field{
+			model:       project,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		project.fields.add(&Field{
+			model:            project,
+			name:             "Company",
+			json:             "company_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Company",
+			onDelete:         SetNull,
+		})
+		project.fields.add(&Field{
+			model:            project,
+			name:             "Parent",
+			json:             "parent_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Project",
+			onDelete:         SetNull,
+			checkCompany:     true,
+		})
+		project.Methods().AllowAllToGroup(security.GroupEveryone)
+
+		device.fields.add(&Field{
+			model:       device,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		sensor.fields.add(&Field{
+			model:       sensor,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		sensor.fields.add(&Field{
+			model:            sensor,
+			name:             "Device",
+			json:             "device_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Device",
+			onDelete:         Cascade,
+		})
+
+		checklist.fields.add(&Field{
+			model:       checklist,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		checklist.fields.add(&Field{
+			model:            checklist,
+			name:             "Items",
+			json:             "items_ids",
+			fieldType:        fieldtype.One2Many,
+			structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+			relatedModelName: "ChecklistItem",
+			reverseFK:        "Checklist",
+		})
+		checklist.fields.add(&Field{
+			model:            checklist,
+			name:             "AllItems",
+			json:             "all_items_ids",
+			fieldType:        fieldtype.One2Many,
+			structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+			relatedModelName: "ChecklistItem",
+			reverseFK:        "Checklist",
+			includeArchived:  true,
+		})
+		checklistItem.fields.add(&Field{
+			model:       checklistItem,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		checklistItem.fields.add(&Field{
+			model:            checklistItem,
+			name:             "Checklist",
+			json:             "checklist_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Checklist",
+			onDelete:         Cascade,
+		})
+		checklistItem.SetActiveField(fieldName{name: "Active", json: "active"})
+
+		badge.fields.add(&Field{
+			model:       badge,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Selection,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			selection:   types.Selection{"gold": "Gold", "silver": "Silver"},
+		})
+		medal.fields.add(&Field{
+			model:       medal,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			contexts: FieldContexts{"lang": func(rs RecordSet) string {
+				return rs.Env().Context().GetString("lang")
+			}},
+		})
+		award.fields.add(&Field{
+			model:            award,
+			name:             "Name",
+			json:             "name_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			relatedModelName: "Medal",
+			onDelete:         Restrict,
+		})
+
+		memo.SetTableName("blog_memo")
+		So(func() { memo.SetTableName("post") }, ShouldPanic)
+		memo.fields.add(&Field{
+			model:       memo,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+
+		invoice.fields.add(&Field{
+			model:       invoice,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		invoice.fields.add(&Field{
+			model:            invoice,
+			name:             "Customer",
+			json:             "customer_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         SetNull,
+			relatedModelName: "User",
+		})
+		invoice.fields.add(&Field{
+			model:            invoice,
+			name:             "Lines",
+			json:             "lines_ids",
+			fieldType:        fieldtype.One2Many,
+			structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+			relatedModelName: "InvoiceLine",
+			reverseFK:        "Invoice",
+			noCopy:           true,
+			touchParent:      true,
+		})
+		invoice.fields.add(&Field{
+			model:          invoice,
+			name:           "LastLineText",
+			json:           "last_line_text",
+			fieldType:      fieldtype.Text,
+			structField:    reflect.StructField{Type: reflect.TypeOf("")},
+			relatedPathStr: "Lines.Text",
+		})
+
+		invoiceLine.fields.add(&Field{
+			model:            invoiceLine,
+			name:             "Invoice",
+			json:             "invoice_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         Cascade,
+			relatedModelName: "Invoice",
+		})
+		invoiceLine.fields.add(&Field{
+			model:            invoiceLine,
+			name:             "Customer",
+			json:             "customer_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         SetNull,
+			relatedModelName: "User",
+			relatedPathStr:   "Invoice.Customer",
+		})
+		invoiceLine.fields.add(&Field{
+			model:          invoiceLine,
+			name:           "CustomerEmail",
+			json:           "customer_email",
+			fieldType:      fieldtype.Char,
+			structField:    reflect.StructField{Type: reflect.TypeOf("")},
+			relatedPathStr: "Customer.Email",
+			stored:         true,
+		})
+		invoiceLine.fields.add(&Field{
+			model:       invoiceLine,
+			name:        "Text",
+			json:        "text",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		invoiceLine.InheritModel(Registry.MustGet("LineNumberMixin"))
+		invoiceLine.SetLineNumbering(invoiceLine.FieldName("Invoice"))
+
+		note.fields.add(&Field{
+			model:       note,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		note.fields.add(&Field{
+			model:       note,
+			name:        "Description",
+			json:        "description",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		note.SetLogAccess(false)
+
+		office.fields.add(&Field{
+			model:       office,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		office.fields.add(&Field{
+			model:       office,
+			name:        "Team",
+			json:        "team",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		office.SetNameSearchFields(office.FieldName("Name"), office.FieldName("Team"))
 	})
 }
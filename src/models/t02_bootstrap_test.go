@@ -151,6 +151,9 @@ func TestFieldModification(t *testing.T) {
 		sizeField := Registry.MustGet("User").Fields().MustGet("Size")
 		sizeField.SetDigits(nbutils.Digits{Precision: 6, Scale: 2})
 		lastUpdateShouldResemble(sizeField, "digits", nbutils.Digits{Precision: 6, Scale: 2})
+		sizeField.SetPrecision("User Size")
+		lastUpdateShouldResemble(sizeField, "precision", "User Size")
+		sizeField.SetPrecision("")
 		userField := Registry.MustGet("Post").Fields().MustGet("User")
 		userField.SetOnDelete(Cascade)
 		checkUpdates(userField, "onDelete", Cascade)
@@ -207,6 +210,26 @@ func TestMiscellaneous(t *testing.T) {
 		So(Registry.MustGet("User").Fields().MustGet("Name").JSON(), ShouldEqual, "name")
 		So(Registry.MustGet("User").Fields().MustGet("Name").Name(), ShouldEqual, "Name")
 	})
+	Convey("Check models with custom table names", t, func() {
+		memoModel := Registry.MustGet("Memo")
+		So(memoModel.TableName(), ShouldEqual, "blog_memo")
+		So(Registry.MustGet("blog_memo"), ShouldEqual, memoModel)
+		_, exists := Registry.Get("memo")
+		So(exists, ShouldBeFalse)
+		So(func() { memoModel.SetTableName("memo") }, ShouldPanic)
+	})
+	Convey("Check that fields cannot share a column", t, func() {
+		tagModel := Registry.MustGet("Tag")
+		So(func() {
+			tagModel.fields.add(&Field{
+				model:       tagModel,
+				name:        "OtherName",
+				json:        "name",
+				fieldType:   fieldtype.Char,
+				structField: reflect.StructField{Type: reflect.TypeOf("")},
+			})
+		}, ShouldPanic)
+	})
 }
 
 func TestSequences(t *testing.T) {
@@ -374,11 +397,47 @@ func TestBootStrap(t *testing.T) {
 		Convey("Bootstrap should not panic", func() {
 			BootStrap()
 			SyncDatabase()
+			So(initStages, ShouldResemble, []string{"UserView", "Post"})
+		})
+		Convey("Models should be initialized in their declaration order", func() {
+			var names []string
+			for _, model := range Registry.orderedModels() {
+				names = append(names, model.name)
+			}
+			So(names, ShouldContain, "UserView")
+			userIndex, profileIndex, postIndex := -1, -1, -1
+			for i, name := range names {
+				switch name {
+				case "User":
+					userIndex = i
+				case "Profile":
+					profileIndex = i
+				case "Post":
+					postIndex = i
+				}
+			}
+			So(userIndex, ShouldBeGreaterThanOrEqualTo, 0)
+			So(profileIndex, ShouldBeGreaterThan, userIndex)
+			So(postIndex, ShouldBeGreaterThan, profileIndex)
 		})
 		Convey("Boostrapping twice should panic", func() {
 			So(BootStrapped(), ShouldBeTrue)
 			So(BootStrap, ShouldPanic)
 		})
+		Convey("Generating a migration after synchronization should be empty", func() {
+			So(GenerateMigration().IsEmpty(), ShouldBeTrue)
+		})
+		Convey("Generating a migration should alter modified boot sequences", func() {
+			seq := Registry.MustGetSequence("TestSequence")
+			increment := seq.Increment
+			seq.Increment = increment + 1
+			defer func() { seq.Increment = increment }()
+			migration := GenerateMigration()
+			So(migration.Up, ShouldResemble, []string{fmt.Sprintf("ALTER SEQUENCE test_sequence_bootseq INCREMENT BY %d START WITH %d RESTART WITH %d",
+				increment+1, seq.Start, seq.Start)})
+			So(migration.Down, ShouldResemble, []string{fmt.Sprintf("ALTER SEQUENCE test_sequence_bootseq INCREMENT BY %d START WITH %d RESTART WITH %d",
+				increment, seq.Start, seq.Start)})
+		})
 		Convey("Creating methods after bootstrap should panic", func() {
 			So(func() {
 				Registry.MustGet("User").NewMethod("NewMethod", func(rc *RecordCollection) {})
@@ -451,6 +510,41 @@ func TestBootStrap(t *testing.T) {
 			So(numsField.index, ShouldBeFalse)
 			So(SyncDatabase, ShouldNotPanic)
 		})
+		Convey("Renaming models and fields should keep their data", func() {
+			dbExecuteNoTx(`ALTER TABLE "tag" RENAME TO "label"`)
+			dbExecuteNoTx(`ALTER TABLE "label" RENAME COLUMN best_post_id TO favorite_post_id`)
+			dbExecuteNoTx(`ALTER TABLE "label" RENAME CONSTRAINT tag_best_post_id_fkey TO label_favorite_post_id_fkey`)
+			dbExecuteNoTx(`INSERT INTO "label" (name, description) VALUES ('Renamed', 'Kept')`)
+			dbExecuteNoTx(`ALTER TABLE "post_tag_rel" RENAME TO "label_post_rel"`)
+			dbExecuteNoTx(`ALTER TABLE "label_post_rel" RENAME COLUMN tag_id TO label_id`)
+			var links int
+			dbGetNoTx(&links, `SELECT COUNT(*) FROM "label_post_rel"`)
+			RenameModel("Label", "Tag")
+			RenameField("Tag", "FavoritePost", "BestPost")
+			migration := GenerateMigration()
+			So(migration.Up[0], ShouldEqual, `ALTER TABLE "label" RENAME TO "tag"`)
+			So(migration.Up, ShouldContain, "ALTER TABLE \"tag\"\nRENAME CONSTRAINT label_favorite_post_id_fkey TO tag_favorite_post_id_fkey")
+			So(migration.Up, ShouldContain, `ALTER TABLE "label_post_rel" RENAME TO "post_tag_rel"`)
+			So(migration.Up, ShouldContain, "ALTER TABLE \"post_tag_rel\"\nRENAME COLUMN label_id TO tag_id")
+			So(migration.Up, ShouldContain, "ALTER TABLE \"tag\"\nRENAME COLUMN favorite_post_id TO best_post_id")
+			So(migration.Up[len(migration.Up)-1], ShouldEqual, "ALTER TABLE \"tag\"\nRENAME CONSTRAINT tag_favorite_post_id_fkey TO tag_best_post_id_fkey")
+			So(migration.Down[0], ShouldEqual, "ALTER TABLE \"tag\"\nRENAME CONSTRAINT tag_best_post_id_fkey TO tag_favorite_post_id_fkey")
+			So(SyncDatabase, ShouldNotPanic)
+			So(TestAdapter.tables(), ShouldContainKey, "tag")
+			So(TestAdapter.tables(), ShouldNotContainKey, "label")
+			So(TestAdapter.columns("tag"), ShouldContainKey, "best_post_id")
+			So(TestAdapter.constraintExists("tag_best_post_id_fkey"), ShouldBeTrue)
+			So(TestAdapter.tables(), ShouldNotContainKey, "label_post_rel")
+			So(TestAdapter.columns("post_tag_rel"), ShouldContainKey, "tag_id")
+			var keptLinks int
+			dbGetNoTx(&keptLinks, `SELECT COUNT(*) FROM "post_tag_rel"`)
+			So(keptLinks, ShouldEqual, links)
+			var count int
+			dbGetNoTx(&count, `SELECT COUNT(*) FROM "tag" WHERE name = 'Renamed' AND description = 'Kept'`)
+			So(count, ShouldEqual, 1)
+			So(GenerateMigration().Up, ShouldBeEmpty)
+			modelRenames, fieldRenames = nil, nil
+		})
 	})
 
 	Convey("Post testing models modifications", t, func() {
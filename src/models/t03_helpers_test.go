@@ -255,4 +255,38 @@ func TestTypes(t *testing.T) {
 			So(jsons[1], ShouldEqual, "user_id")
 		})
 	})
+	Convey("Testing companies compatibility", t, func() {
+		Convey("Records with a company can only be linked to records of the same company", func() {
+			So(companiesAreCompatible(1, 1, nil), ShouldBeTrue)
+			So(companiesAreCompatible(1, 2, nil), ShouldBeFalse)
+			So(companiesAreCompatible(1, 2, []int64{1, 2}), ShouldBeFalse)
+		})
+		Convey("Records without company can be linked to records of allowed companies", func() {
+			So(companiesAreCompatible(0, 2, []int64{1, 2}), ShouldBeTrue)
+			So(companiesAreCompatible(0, 3, []int64{1, 2}), ShouldBeFalse)
+			So(companiesAreCompatible(0, 2, nil), ShouldBeFalse)
+		})
+	})
+	Convey("Testing migration statements helpers", t, func() {
+		Convey("Formatting schema statements", func() {
+			So(formatSchemaStatement(`
+		ALTER TABLE "user"
+		ADD COLUMN name varchar
+	`), ShouldEqual, "ALTER TABLE \"user\"\nADD COLUMN name varchar")
+		})
+		Convey("Quoting literals", func() {
+			adapter := adapters["postgres"]
+			So(adapter.quoteLiteral(nil), ShouldEqual, "NULL")
+			So(adapter.quoteLiteral("it's"), ShouldEqual, "'it''s'")
+			So(adapter.quoteLiteral(true), ShouldEqual, "TRUE")
+			So(adapter.quoteLiteral(int64(12)), ShouldEqual, "12")
+			So(adapter.quoteLiteral(1.5), ShouldEqual, "1.5")
+			So(adapter.quoteLiteral(`it's a \\ or a ?`), ShouldEqual, `E'it''s a \\\\ or a \\x3F'`)
+		})
+		Convey("Inlining schema statements args", func() {
+			So(inlineSchemaArgs("UPDATE t SET a = ?, b = ?", []interface{}{"why?", int64(2)}), ShouldEqual,
+				`UPDATE t SET a = E'why\\x3F', b = 2`)
+			So(func() { inlineSchemaArgs("UPDATE t SET a = ?", []interface{}{1, 2}) }, ShouldPanic)
+		})
+	})
 }
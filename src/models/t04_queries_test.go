@@ -17,8 +17,11 @@ package models
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -66,6 +69,8 @@ var (
 	experience               = fieldName{name: "Experience", json: "experience"}
 	leisure                  = fieldName{name: "Leisure", json: "leisure"}
 	education                = fieldName{name: "Education", json: "education"}
+	motto                    = fieldName{name: "Motto", json: "motto"}
+	labels                   = fieldName{name: "Labels", json: "labels"}
 	lastPost                 = fieldName{name: "LastPost", json: "last_post_id"}
 	lastTagName              = fieldName{name: "LastTagName", json: "last_tag_name"}
 	lastCommentText          = fieldName{name: "LastCommentText", json: "last_comment_text"}
@@ -79,12 +84,19 @@ var (
 	lastupdate               = fieldName{name: "LastUpdate", json: "__last_update"}
 	createDate               = fieldName{name: "CreateDate", json: "create_date"}
 	writeDate                = fieldName{name: "WriteDate", json: "write_date"}
+	createUID                = fieldName{name: "CreateUID", json: "create_uid"}
+	writeUID                 = fieldName{name: "WriteUID", json: "write_uid"}
 	parent                   = fieldName{name: "Parent", json: "parent_id"}
 	value                    = fieldName{name: "Value", json: "value"}
 	password                 = fieldName{name: "Password", json: "password"}
 	size                     = fieldName{name: "Size", json: "size"}
 	hexyaVersion             = fieldName{name: "HexyaVersion", json: "hexya_version"}
 	hexyaExternalID          = fieldName{name: "HexyaExternalID", json: "hexya_external_id"}
+	userView                 = fieldName{name: "UserView", json: "user_view_id"}
+	userViewCity             = fieldName{name: "UserView.City", json: "user_view_id.city"}
+	lastRead                 = fieldName{name: "LastRead", json: "last_read"}
+	dateStart                = fieldName{name: "DateStart", json: "date_start"}
+	dateStop                 = fieldName{name: "DateStop", json: "date_stop"}
 )
 
 func TestConditions(t *testing.T) {
@@ -173,6 +185,13 @@ func TestConditions(t *testing.T) {
 					sql, _, _ := rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY id LIMIT 1 `)
 				})
+				Convey("Testing query with a zero LIMIT", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com"))
+					sql, _ := rs.Limit(0).DebugSQL(Name)
+					So(sql, ShouldContainSubstring, `LIMIT 0 `)
+					unlimited, _ := rs.Limit(-1).DebugSQL(Name)
+					So(unlimited, ShouldNotContainSubstring, `LIMIT`)
+				})
 				Convey("Testing query with LIMIT and OFFSET clauses", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).Call("Limit", 1).(RecordSet).Collection().Call("Offset", 2).(RecordSet).Collection().Load()
 					fields = []FieldName{Name}
@@ -185,6 +204,113 @@ func TestConditions(t *testing.T) {
 					sql, _, _ := rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".email AS email, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY email, id `)
 				})
+				Convey("Testing query with ORDER BY related field and NULLS clauses", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Profile.Age desc nulls last", "Name NULLS FIRST")
+					fields = []FieldName{Name}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "T1".age AS profile_id__age FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY profile_id__age DESC NULLS LAST, name NULLS FIRST `)
+				})
+				Convey("Testing query with ORDER BY x2many related field", func() {
+					rs := env.Pool("Post").Search(env.Pool("Post").Model().Field(title).IContains("post")).OrderBy("Tags.Name desc")
+					fields = []FieldName{title}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldStartWith, `SELECT * FROM (SELECT DISTINCT ON ("post".id) "post".title AS title,`)
+					So(sql, ShouldContainSubstring, `ORDER BY "post".id , "T2".name DESC) foo ORDER BY tags_ids__name DESC `)
+				})
+				Convey("Testing debug SQL query", func() {
+					rs := env.Pool("User").Search(env.Pool("User").Model().Field(email).IContains("jane").
+						And().Field(nums).In([]int{1, 2})).Limit(2)
+					sql, args := rs.DebugSQL(Name)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".id AS id FROM "user" "user"  WHERE ("user".email ILIKE $1) AND ("user".nums IN ($2, $3)) ORDER BY "user".id ) foo ORDER BY id LIMIT 2 `)
+					So(args, ShouldResemble, []interface{}{"%jane%", 1, 2})
+					So(rs.query.orders, ShouldBeEmpty)
+					So(rs.query.cond.predicates, ShouldHaveLength, 2)
+				})
+				Convey("Testing planner hints", func() {
+					rs := env.Pool("User").Search(env.Pool("User").Model().Field(email).IContains("jane")).
+						PlannerHints("IndexScan(user user_email_index)", "Leap(user)")
+					sql, _ := rs.DebugSQL(Name)
+					So(sql, ShouldStartWith, `/*+ IndexScan(user user_email_index) Leap(user) */ SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name`)
+					sql, _ = rs.query.countQuery()
+					So(sql, ShouldStartWith, `/*+ IndexScan(user user_email_index) Leap(user) */ SELECT COUNT(*) FROM (SELECT * FROM (SELECT DISTINCT ON`)
+					sql, _ = rs.GroupBy(isStaff).query.selectGroupQuery([]FieldName{isStaff}, nil)
+					So(sql, ShouldStartWith, `/*+ IndexScan(user user_email_index) Leap(user) */ SELECT`)
+					sql, _ = env.Pool("User").Search(rs.query.cond).DebugSQL(Name)
+					So(sql, ShouldStartWith, `SELECT * FROM`)
+					So(func() { rs.PlannerHints("SeqScan(user) */ DROP TABLE user; /*") }, ShouldPanic)
+				})
+				Convey("Testing invalid ORDER BY clauses", func() {
+					So(func() { env.Pool("User").OrderBy("Name nulls") }, ShouldPanic)
+					So(func() { env.Pool("User").OrderBy("Name desc nulls middle") }, ShouldPanic)
+					So(func() { env.Pool("User").OrderBy("Name up") }, ShouldPanic)
+				})
+				Convey("Testing default order with ID tiebreaker", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Email desc")
+					rs.applyDefaultOrder()
+					So(rs.query.orders, ShouldHaveLength, 2)
+					So(rs.query.orders[1].field, ShouldEqual, ID)
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("ID desc", "Email")
+					rs.applyDefaultOrder()
+					So(rs.query.orders, ShouldHaveLength, 2)
+				})
+				Convey("Testing comma separated ORDER BY clauses", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Profile.Age desc nulls last, Name", "Email")
+					fields = []FieldName{Name}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldEndWith, `ORDER BY profile_id__age DESC NULLS LAST, name, email `)
+					So(func() { env.Pool("User").OrderBy("Name,") }, ShouldPanic)
+					tagOrders := Registry.MustGet("Tag").ordersFromStrings([]string{"Name DESC, ID ASC"})
+					So(tagOrders, ShouldResemble, Registry.MustGet("Tag").defaultOrder)
+				})
+				Convey("Testing ORDER BY a relation aggregate", func() {
+					rsPost := env.Pool("Post").Search(env.Pool("Post").Model().Field(title).Equals("1st post")).OrderBy("CommentsCount desc")
+					So(rsPost.query.hasMemoryOrders(), ShouldBeFalse)
+					sql, args, _ := rsPost.query.selectQuery([]FieldName{title})
+					So(sql, ShouldContainSubstring, `"post".title AS title, (SELECT count(hexya_agg.id) FROM "comment" hexya_agg WHERE hexya_agg.post_id IS NOT NULL AND hexya_agg.id IN (SELECT "comment".id FROM "comment" "comment"  ) AND hexya_agg.post_id = "post".id) AS hexya_order_comments_count FROM "post" "post"`)
+					So(sql, ShouldEndWith, `ORDER BY hexya_order_comments_count DESC `)
+					So(args, ShouldResemble, SQLParams{"1st post"})
+				})
+				Convey("Testing query with DISTINCT ON clause", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Email desc").DistinctOn(isStaff)
+					fields = []FieldName{Name}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON (is_staff) * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".email AS email, "user".is_staff AS is_staff FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY is_staff, email DESC) foo ORDER BY email DESC `)
+				})
+				Convey("Testing query with a limit per partition", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Email desc").LimitPerPartition(1, isStaff)
+					fields = []FieldName{Name}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldEqual, `SELECT * FROM (WITH hexya_partition AS (SELECT DISTINCT ON ("user".id) "user".id AS id, "user".name AS name, "user".email AS email, "user".is_staff AS is_staff FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) SELECT * FROM hexya_partition WHERE id IN (SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY is_staff ORDER BY email DESC) AS hexya_row FROM hexya_partition) foo WHERE hexya_row <= 1)) foo ORDER BY email DESC `)
+				})
+				Convey("Testing query with FOR UPDATE clause", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).OrderBy("Name").Limit(2).ForUpdateSkipLocked()
+					fields = []FieldName{ID, Name}
+					sql, _, _ := rs.query.selectQuery(fields)
+					So(sql, ShouldStartWith, `SELECT foo.* FROM (SELECT DISTINCT ON ("user".id) "user".id AS id, "user".name AS name FROM "user" "user"`)
+					So(sql, ShouldEndWith, `) foo JOIN "user" hexya_lock ON hexya_lock.id = foo.id ORDER BY foo.name LIMIT 2  FOR UPDATE OF hexya_lock SKIP LOCKED`)
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane")).ForUpdateNoWait()
+					sql, _, _ = rs.query.selectQuery(fields)
+					So(sql, ShouldEndWith, `FOR UPDATE OF hexya_lock NOWAIT`)
+					So(rs.withIds([]int64{1}).query.lock, ShouldEqual, noLock)
+				})
+				Convey("Testing insert query with ON CONFLICT clause", func() {
+					rs = env.Pool("User")
+					rs.query.onConflict = []FieldName{Name}
+					sql, args := rs.query.insertQuery(FieldMap{"name": "John Smith", "email": "jsmith@example.com"})
+					So(sql, ShouldStartWith, `INSERT INTO "user" (`)
+					So(sql, ShouldEndWith, `) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id, (xmax = 0) AS inserted`)
+					So(args, ShouldContain, "John Smith")
+				})
+				Convey("Testing raw SQL conditions", func() {
+					rs = env.Pool("User").Search(RawCondition("length({table}.name) > ?", 8).
+						And().Field(Name).IContains("Smith").
+						OrNot().RawSQL("{table}.nums % ? = 0", 2))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE (length("user".name) > ?) AND "user".name ILIKE ? OR NOT ("user".nums % ? = 0)`)
+					So(args, ShouldResemble, SQLParams{8, "%Smith%", 2})
+					So(func() { RawCondition("") }, ShouldPanic)
+					So(func() { RawCondition("{table}.nums > ?", 2).Serialize() }, ShouldPanic)
+				})
 				Convey("Testing complex conditions", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(profileAge).GreaterOrEqual(12).
 						AndNot().Field(Name).IContains("Jane").
@@ -206,6 +332,388 @@ func TestConditions(t *testing.T) {
 					So(args, ShouldContain, "%Jane%")
 					So(args, ShouldContain, "%John%")
 				})
+				Convey("Testing created by and followed by conditions", func() {
+					rs = env.Pool("User").Search(rs.Model().CreatedBy(security.SuperUserID))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".create_uid = ?`)
+					So(args, ShouldResemble, SQLParams{security.SuperUserID})
+					rs = env.Pool("User").Search(rs.Model().Field(Name).IContains("John").
+						Or().FollowedBy(posts, title, "1st post"))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".name ILIKE ? OR "user".id IN (SELECT "post".user_id FROM "post" "post"  WHERE ("post".user_id IS NOT NULL) AND ("post".title = ?))`)
+					So(args, ShouldResemble, SQLParams{"%John%", "1st post"})
+				})
+				Convey("Testing bounding box conditions", func() {
+					rs = env.Pool("User").Search(rs.Model().InBoundingBox(size, mana, 10, -5, 20, 5))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".size >= ? AND "user".size <= ? AND "user".mana >= ? AND "user".mana <= ?`)
+					So(args, ShouldHaveLength, 4)
+					So(args, ShouldContain, float64(10))
+					So(args, ShouldContain, float64(-5))
+					rs = env.Pool("User").Search(rs.Model().Field(Name).IContains("John").
+						And().InBoundingBox(size, mana, 10, 170, 20, -170))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("user".name ILIKE ?) AND (("user".size >= ? AND "user".size <= ?) AND ("user".mana >= ? OR "user".mana <= ?))`)
+					So(args, ShouldHaveLength, 5)
+					So(args, ShouldContain, float64(170))
+					So(args, ShouldContain, float64(-170))
+				})
+				Convey("Testing custom operators", func() {
+					RegisterOperator("within_range", func(column string, value interface{}) (string, []interface{}) {
+						bounds := value.([]float64)
+						return fmt.Sprintf("%s BETWEEN ? AND ?", column), []interface{}{bounds[0], bounds[1]}
+					})
+					defer delete(customOperators, "within_range")
+					rs = env.Pool("User").Search(rs.Model().Field(Name).IContains("John").
+						And().Field(profileMoney).AddOperator("within_range", []float64{10, 20}))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".name ILIKE ? AND ("user__profile".money BETWEEN ? AND ?)`)
+					So(args, ShouldResemble, SQLParams{"%John%", float64(10), float64(20)})
+					So(func() {
+						RegisterOperator("within_range", func(string, interface{}) (string, []interface{}) { return "", nil })
+					}, ShouldPanic)
+					So(func() {
+						RegisterOperator(operator.Equals, func(string, interface{}) (string, []interface{}) { return "", nil })
+					}, ShouldPanic)
+				})
+				Convey("Testing relative date period conditions", func() {
+					// 2020-03-29 00:30 in Paris, the day of the switch to summer time
+					dates.SetClock(dates.NewFakeClock(time.Date(2020, 3, 28, 23, 30, 0, 0, time.UTC)))
+					defer dates.SetClock(nil)
+					parisUsers := env.Pool("User").WithContext("tz", "Europe/Paris")
+					rs = parisUsers.Search(parisUsers.Model().Field(createDate).InPeriod(DateToday()))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".create_date >= ? AND "user".create_date < ?`)
+					So(args, ShouldResemble, SQLParams{
+						dates.DateTime{Time: time.Date(2020, 3, 28, 23, 0, 0, 0, time.UTC)},
+						dates.DateTime{Time: time.Date(2020, 3, 29, 22, 0, 0, 0, time.UTC)},
+					})
+					rs = env.Pool("User").Search(env.Pool("User").Model().Field(createDate).InPeriod(DateToday()))
+					_, args = rs.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{
+						dates.DateTime{Time: time.Date(2020, 3, 28, 0, 0, 0, 0, time.UTC)},
+						dates.DateTime{Time: time.Date(2020, 3, 29, 0, 0, 0, 0, time.UTC)},
+					})
+					parisPosts := env.Pool("Post").WithContext("tz", "Europe/Paris")
+					rsPost := parisPosts.Search(parisPosts.Model().Field(lastRead).InPeriod(DateThisWeek()).
+						Or().Field(lastRead).BeforePeriod(DateThisMonth()))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("post".last_read >= ? AND "post".last_read < ?) OR ("post".last_read < ?)`)
+					So(args, ShouldResemble, SQLParams{
+						dates.ParseDate("2020-03-23"),
+						dates.ParseDate("2020-03-30"),
+						dates.ParseDate("2020-03-01"),
+					})
+					rsPost = parisPosts.Search(parisPosts.Model().Field(lastRead).InPeriod(DateRange(dates.ParseDate("2020-01-10"), dates.ParseDate("2020-01-20"))).
+						And().Field(lastRead).AfterPeriod(DateThisYear()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{
+						dates.ParseDate("2020-01-10"),
+						dates.ParseDate("2020-01-21"),
+						dates.ParseDate("2021-01-01"),
+					})
+					So(func() {
+						rs := env.Pool("User").Search(env.Pool("User").Model().Field(Name).InPeriod(DateToday()))
+						rs.query.sqlWhereClause(true)
+					}, ShouldPanic)
+				})
+				Convey("Testing fiscal period conditions", func() {
+					// 2020-03-29 00:30 in Paris, the day of the switch to summer time
+					dates.SetClock(dates.NewFakeClock(time.Date(2020, 3, 28, 23, 30, 0, 0, time.UTC)))
+					defer dates.SetClock(nil)
+					periodModel := Registry.MustGet("FiscalPeriod")
+					newPeriod := func(name, start, stop string) RecordSet {
+						return env.Pool("FiscalPeriod").Call("Create", NewModelData(periodModel).
+							Set(Name, name).
+							Set(dateStart, dates.ParseDate(start)).
+							Set(dateStop, dates.ParseDate(stop))).(RecordSet)
+					}
+					periodA03 := newPeriod("Company A 2020-03", "2020-02-26", "2020-03-25")
+					periodA04 := newPeriod("Company A 2020-04", "2020-03-26", "2020-04-25")
+					newPeriod("Company B 2020-03", "2020-03-01", "2020-03-31")
+
+					parisPosts := env.Pool("Post").WithContext("tz", "Europe/Paris")
+					rsPost := parisPosts.Search(parisPosts.Model().Field(lastRead).InPeriod(FiscalPeriod(periodA03)))
+					sql, args := rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".last_read >= ? AND "post".last_read < ?`)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2020-02-26"), dates.ParseDate("2020-03-26")})
+					parisUsers := env.Pool("User").WithContext("tz", "Europe/Paris")
+					rs = parisUsers.Search(parisUsers.Model().Field(createDate).InPeriod(FiscalPeriod(periodA04)))
+					_, args = rs.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{
+						dates.DateTime{Time: time.Date(2020, 3, 25, 23, 0, 0, 0, time.UTC)},
+						dates.DateTime{Time: time.Date(2020, 4, 25, 22, 0, 0, 0, time.UTC)},
+					})
+
+					companyPosts := parisPosts.WithContext("fiscal_company", "Company A")
+					rsPost = companyPosts.Search(companyPosts.Model().Field(lastRead).InPeriod(DateThisFiscalPeriod()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2020-03-26"), dates.ParseDate("2020-04-26")})
+					rsPost = parisPosts.Search(parisPosts.Model().Field(lastRead).BeforePeriod(DateThisFiscalPeriod()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2020-03-01")})
+					So(func() {
+						noPeriodPosts := parisPosts.WithContext("fiscal_company", "Company C")
+						rsPost := noPeriodPosts.Search(noPeriodPosts.Model().Field(lastRead).InPeriod(DateThisFiscalPeriod()))
+						rsPost.query.sqlWhereClause(true)
+					}, ShouldPanic)
+
+					rsPost = parisPosts.Search(parisPosts.Model().Field(lastRead).InPeriod(DateThisFiscalYear()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2020-01-01"), dates.ParseDate("2021-01-01")})
+					aprilPosts := parisPosts.WithContext("fiscal_year_month", 4).WithContext("fiscal_year_day", 1)
+					rsPost = aprilPosts.Search(aprilPosts.Model().Field(lastRead).InPeriod(DateThisFiscalYear()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2019-04-01"), dates.ParseDate("2020-04-01")})
+					februaryPosts := parisPosts.WithContext("fiscal_year_month", 2).WithContext("fiscal_year_day", 30)
+					rsPost = februaryPosts.Search(februaryPosts.Model().Field(lastRead).InPeriod(DateThisFiscalYear()))
+					_, args = rsPost.query.sqlWhereClause(true)
+					So(args, ShouldResemble, SQLParams{dates.ParseDate("2020-02-29"), dates.ParseDate("2021-02-28")})
+
+					So(func() {
+						notPeriod := parisPosts.Search(parisPosts.Model().Field(lastRead).InPeriod(FiscalPeriod(rsPost)))
+						notPeriod.query.sqlWhereClause(true)
+					}, ShouldPanic)
+				})
+				Convey("Testing date part conditions", func() {
+					parisUsers := env.Pool("User").WithContext("tz", "Europe/Paris")
+					rs = parisUsers.Search(parisUsers.Model().Field(createDate).DatePart(DatePartMonth).Equals(12))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE EXTRACT(MONTH FROM ("user".create_date AT TIME ZONE 'UTC' AT TIME ZONE 'Europe/Paris')) = ?`)
+					So(args, ShouldResemble, SQLParams{12})
+					rs = env.Pool("User").Search(env.Pool("User").Model().Field(createDate).DatePart(DatePartHour).GreaterOrEqual(18).
+						And().Field(Name).Equals("John"))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE EXTRACT(HOUR FROM "user".create_date) >= ? AND "user".name = ?`)
+					So(args, ShouldResemble, SQLParams{18, "John"})
+					parisPosts := env.Pool("Post").WithContext("tz", "Europe/Paris")
+					cond := parisPosts.Model().Field(lastRead).DatePart(DatePartQuarter).In([]int{2, 3})
+					rsPost := parisPosts.Search(cond)
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE EXTRACT(QUARTER FROM "post".last_read) IN (?)`)
+					So(args, ShouldResemble, SQLParams{[]int{2, 3}})
+					So(cond.Serialize()[0].([]interface{})[0], ShouldEqual, "last_read.quarter")
+					So(func() {
+						rsPost := parisPosts.Search(parisPosts.Model().Field(lastRead).DatePart(DatePartHour).Equals(10))
+						rsPost.query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() {
+						rs := env.Pool("User").Search(env.Pool("User").Model().Field(Name).DatePart(DatePartYear).Equals(2020))
+						rs.query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() { env.Pool("User").Model().Field(createDate).DatePart("week") }, ShouldPanic)
+				})
+				Convey("Testing IN conditions with a search as subquery", func() {
+					profiles := env.Pool("Profile").Search(env.Pool("Profile").Model().Field(age).Greater(20))
+					rs = env.Pool("User").Search(rs.Model().Field(profile).In(profiles))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".profile_id IN (SELECT "profile".id FROM "profile" "profile"  WHERE "profile".age > ?)`)
+					So(args, ShouldResemble, SQLParams{20})
+					rs = env.Pool("User").Search(rs.Model().Field(Name).Equals("John").
+						And().Field(profile).NotIn(profiles))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".name = ? AND ("user".profile_id IS NULL OR "user".profile_id NOT IN (SELECT "profile".id FROM "profile" "profile"  WHERE "profile".age > ?))`)
+					So(args, ShouldResemble, SQLParams{"John", 20})
+					users := env.Pool("User").Search(env.Pool("User").Model().Field(Name).IContains("jane"))
+					rs = env.Pool("User").Search(rs.Model().Field(ID).In(users))
+					sql, _ = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id IN (SELECT "user".id FROM "user" "user"  WHERE "user".name ILIKE ?)`)
+					Convey("Searches with a limit or fetched are given as ids", func() {
+						rs = env.Pool("User").Search(rs.Model().Field(profile).In(profiles.Limit(2)))
+						sql, _ = rs.query.sqlWhereClause(true)
+						So(sql, ShouldNotContainSubstring, "SELECT")
+						rs = env.Pool("User").Search(rs.Model().Field(profile).In(profiles.Fetch()))
+						sql, _ = rs.query.sqlWhereClause(true)
+						So(sql, ShouldNotContainSubstring, "SELECT")
+					})
+				})
+				Convey("Testing any/all quantifiers", func() {
+					postCond := env.Pool("Post").Model().Field(title).Equals("1st post")
+					rs = env.Pool("User").Search(rs.Model().AnyOf(posts, postCond))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id IN (SELECT "post".user_id FROM "post" "post"  WHERE ("post".user_id IS NOT NULL) AND ("post".title = ?))`)
+					So(args, ShouldResemble, SQLParams{"1st post"})
+					rs = env.Pool("User").Search(rs.Model().AllOf(posts, postCond))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id NOT IN (SELECT "post".user_id FROM "post" "post"  WHERE ("post".user_id IS NOT NULL) AND NOT ("post".title = ?))`)
+					So(args, ShouldResemble, SQLParams{"1st post"})
+					tagCond := env.Pool("Tag").Model().Field(Name).Equals("Books")
+					rsPost := env.Pool("Post").Search(env.Pool("Post").Model().AllOf(tags, tagCond))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id NOT IN (SELECT post_id FROM "post_tag_rel" WHERE tag_id IN (SELECT "tag".id FROM "tag" "tag"  WHERE NOT ("tag".name = ?)))`)
+					So(args, ShouldResemble, SQLParams{"Books"})
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(title).Equals("1st post").
+						Or().AnyOf(tags, tagCond))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".title = ? OR "post".id IN (SELECT post_id FROM "post_tag_rel" WHERE tag_id IN (SELECT "tag".id FROM "tag" "tag"  WHERE "tag".name = ?))`)
+					So(args, ShouldResemble, SQLParams{"1st post", "Books"})
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAll([]int64{1, 2, 1}))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id IN (SELECT post_id FROM "post_tag_rel" WHERE tag_id IN (?) GROUP BY post_id HAVING COUNT(DISTINCT tag_id) = ?)`)
+					So(args, ShouldResemble, SQLParams{[]int64{1, 2}, 2})
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAny([]int64{1, 2}))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id IN (SELECT post_id FROM "post_tag_rel" WHERE tag_id IN (?))`)
+					So(args, ShouldResemble, SQLParams{[]int64{1, 2}})
+					rs = env.Pool("User").Search(rs.Model().Field(posts).ContainsAny([]int64{3}))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id IN (SELECT user_id FROM "post" WHERE id IN (?))`)
+					So(args, ShouldResemble, SQLParams{[]int64{3}})
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAll([]int64{}))
+					sql, _ = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE TRUE`)
+					So(func() {
+						env.Pool("User").Search(rs.Model().Field(profile).ContainsAll([]int64{1})).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					rs = env.Pool("User").Search(rs.Model().AllOf(posts, newCondition()))
+					sql, _ = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE TRUE`)
+					So(func() {
+						env.Pool("User").Search(rs.Model().AnyOf(profile, env.Pool("Profile").Model().Field(age).Equals(20))).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					rsTag := env.Pool("Tag").Search(env.Pool("Tag").Model().AnyOf(userView, env.Pool("UserView").Model().Field(city).Equals("New York")))
+					sql, args = rsTag.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "tag".user_view_id IN (SELECT "user_view".id FROM "user_view" "user_view"  WHERE "user_view".city = ?)`)
+					So(args, ShouldResemble, SQLParams{"New York"})
+					So(func() {
+						env.Pool("Tag").Search(env.Pool("Tag").Model().AllOf(userView, env.Pool("UserView").Model().Field(city).Equals("New York"))).query.sqlWhereClause(true)
+					}, ShouldPanic)
+				})
+				Convey("Testing aggregate conditions", func() {
+					postCond := env.Pool("Post").Model().Field(title).IContains("post")
+					rs = env.Pool("User").Search(rs.Model().AggregateCondition(posts, AggregateCount, ID, postCond).GreaterOrEqual(2))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id IN (SELECT hexya_agg.user_id FROM "post" hexya_agg WHERE hexya_agg.user_id IS NOT NULL AND hexya_agg.id IN (SELECT "post".id FROM "post" "post"  WHERE "post".title ILIKE ?) GROUP BY hexya_agg.user_id HAVING count(hexya_agg.id) >= ?)`)
+					So(args, ShouldResemble, SQLParams{"%post%", 2})
+					rsPost := env.Pool("Post").Search(env.Pool("Post").Model().Field(title).Equals("1st post").
+						And().AggregateCondition(tags, AggregateSum, rate, nil).Greater(10))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".title = ? AND "post".id IN (SELECT hexya_rel.post_id FROM "post_tag_rel" hexya_rel JOIN "tag" hexya_agg ON hexya_agg.id = hexya_rel.tag_id WHERE hexya_rel.post_id IS NOT NULL AND hexya_agg.id IN (SELECT "tag".id FROM "tag" "tag"  ) GROUP BY hexya_rel.post_id HAVING sum(hexya_agg.rate) > ?)`)
+					So(args, ShouldResemble, SQLParams{"1st post", 10})
+					So(rsPost.Condition().String(), ShouldContainSubstring, "sum(Tags.Rate) > 10")
+					So(func() { rs.Model().AggregateCondition(posts, AggregateFunction("median"), ID, nil) }, ShouldPanic)
+					So(func() { rs.Model().AggregateCondition(posts, AggregateCount, ID, nil).In([]int64{1, 2}) }, ShouldPanic)
+					So(func() {
+						env.Pool("User").Search(rs.Model().AggregateCondition(posts, AggregateSum, title, nil).Greater(1)).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() {
+						env.Pool("User").Search(rs.Model().AggregateCondition(profile, AggregateCount, ID, nil).Greater(1)).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() {
+						rs.Model().AggregateCondition(posts, AggregateCount, ID, nil).Greater(1).Serialize()
+					}, ShouldPanic)
+				})
+				Convey("Testing accent insensitive conditions", func() {
+					rsProfile := env.Pool("Profile").Search(env.Pool("Profile").Model().Field(city).IContains("Montréal"))
+					sql, args := rsProfile.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE hexya_unaccent("profile".city) ILIKE hexya_unaccent(?)`)
+					So(args, ShouldResemble, SQLParams{"%Montréal%"})
+					rsProfile = env.Pool("Profile").Search(env.Pool("Profile").Model().Field(city).NotIContains("Montréal"))
+					sql, _ = rsProfile.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE (hexya_unaccent("profile".city) IS NULL OR hexya_unaccent("profile".city) NOT ILIKE hexya_unaccent(?))`)
+					rsProfile = env.Pool("Profile").Search(env.Pool("Profile").Model().Field(city).Contains("Montréal"))
+					sql, _ = rsProfile.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "profile".city LIKE ?`)
+				})
+				Convey("Testing conditions on embedded fields", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(leisure).Equals("Music").Or().Field(Name).Equals("John"))
+					rs = rs.substituteRelatedInQuery()
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user__resume".leisure = ? OR "user".name = ?`)
+					So(args, ShouldResemble, SQLParams{"Music", "John"})
+				})
+				Convey("Testing similarity conditions", func() {
+					rsProfile := env.Pool("Profile").Search(env.Pool("Profile").Model().Field(country).Similar("Germny")).
+						OrderBySimilarity(country, "Germny")
+					sql, args := rsProfile.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE similarity("profile".country, ?) > 0.3`)
+					So(args, ShouldResemble, SQLParams{"Germny"})
+					orderSQL, orderArgs := rsProfile.query.sqlOrderByClause()
+					So(orderSQL, ShouldEqual, `ORDER BY similarity(country, ?) DESC`)
+					So(orderArgs, ShouldResemble, SQLParams{"Germny"})
+					sql, args = env.Pool("Profile").Search(env.Pool("Profile").Model().Field(country).Similar("Germny")).
+						OrderBySimilarity(country, "Germ?ny").DebugSQL(country)
+					So(sql, ShouldContainSubstring, `similarity("profile".country, $1) > 0.3`)
+					So(sql, ShouldContainSubstring, `ORDER BY similarity(country, $2) DESC`)
+					So(args, ShouldResemble, SQLParams{"Germny", "Germ?ny"})
+					So(func() {
+						env.Pool("Profile").Search(env.Pool("Profile").Model().Field(city).Similar("Paris")).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() { env.Pool("Profile").SearchAll().OrderBySimilarity(city, "Paris") }, ShouldPanic)
+				})
+				Convey("Testing JSON containment conditions", func() {
+					postModel := Registry.MustGet("Post")
+					sql, args := env.Pool("Post").Search(postModel.Field(labels).JSONContains("admin")).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE CAST(NULLIF("post".labels, '') AS jsonb) @> CAST(? AS jsonb)`)
+					So(args, ShouldResemble, SQLParams{`"admin"`})
+					sql, args = env.Pool("Post").Search(postModel.Field(labels).JSONPathContains("owner.team", "sales").
+						And().Field(labels).JSONContains([]string{"admin", "editor"})).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE CAST(NULLIF("post".labels, '') AS jsonb) @> CAST(? AS jsonb) AND CAST(NULLIF("post".labels, '') AS jsonb) @> CAST(? AS jsonb)`)
+					So(args, ShouldResemble, SQLParams{`{"owner":{"team":"sales"}}`, `["admin","editor"]`})
+					So(func() {
+						env.Pool("Post").Search(postModel.Field(labels).JSONContains(nil)).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() {
+						env.Pool("Post").Search(postModel.Field(postModel.FieldName("Visibility")).JSONContains("admin")).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() {
+						env.Pool("Post").Search(postModel.Field(title).JSONContains("admin")).query.sqlWhereClause(true)
+					}, ShouldPanic)
+					So(func() { postModel.Field(labels).JSONPathContains("", "admin") }, ShouldPanic)
+				})
+				Convey("Testing conditions with uuid primary keys", func() {
+					deviceModel := Registry.MustGet("Device")
+					sensorModel := Registry.MustGet("Sensor")
+					device := sensorModel.FieldName("Device")
+					key := "6f1c2a8e-93d4-4b7e-a1f0-2c5d9e8b7a61"
+					sql, args := env.Pool("Device").Search(deviceModel.Field(ID).Equals(key)).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "device".uuid = ?`)
+					So(args, ShouldResemble, SQLParams{key})
+					sql, args = env.Pool("Sensor").Search(sensorModel.Field(device).In([]string{key})).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "sensor".device_id IN (SELECT id FROM "device" WHERE uuid IN (?))`)
+					So(args, ShouldResemble, SQLParams{[]string{key}})
+					sql, _ = env.Pool("Sensor").Search(sensorModel.Field(device).NotEquals(key)).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("sensor".device_id IS NULL OR NOT ("sensor".device_id IN (SELECT id FROM "device" WHERE uuid = ?)))`)
+					sql, _ = env.Pool("Sensor").Search(sensorModel.Field(fieldName{name: "Device.ID", json: "device_id.id"}).
+						Equals(key)).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "sensor__device".uuid = ?`)
+					sql, args = env.Pool("Sensor").Search(sensorModel.Field(device).Equals(3)).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "sensor".device_id = ?`)
+					So(args, ShouldResemble, SQLParams{3})
+				})
+				Convey("Testing orders by a list of ids", func() {
+					rsUsers := env.Pool("User").SearchAll().OrderBy("Name").OrderByIds([]int64{3, 1, 2})
+					orderSQL, _ := rsUsers.query.sqlOrderByClause()
+					So(orderSQL, ShouldEqual, `ORDER BY array_position(ARRAY[3, 1, 2]::bigint[], id), name`)
+					rsUsers.applyDefaultOrder()
+					orderSQL, _ = rsUsers.query.sqlOrderByClause()
+					So(orderSQL, ShouldEqual, `ORDER BY array_position(ARRAY[3, 1, 2]::bigint[], id), name, id`)
+				})
+				Convey("Testing conditions on x2many relations", func() {
+					rsPost := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).IsNull())
+					sql, args := rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id NOT IN (SELECT post_id FROM "post_tag_rel" WHERE post_id IS NOT NULL)`)
+					So(args, ShouldBeEmpty)
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).Equals(false))
+					sql, _ = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id NOT IN (SELECT post_id FROM "post_tag_rel" WHERE post_id IS NOT NULL)`)
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).NotEquals(nil))
+					sql, _ = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".id IN (SELECT post_id FROM "post_tag_rel" WHERE post_id IS NOT NULL)`)
+					rsPost = env.Pool("Post").Search(env.Pool("Post").Model().Field(title).Equals("1st post").
+						AndNot().Field(tags).Equals(1))
+					sql, args = rsPost.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "post".title = ? AND NOT "post".id IN (SELECT post_id FROM "post_tag_rel" WHERE post_id IS NOT NULL AND tag_id IN (?))`)
+					So(args, ShouldResemble, SQLParams{"1st post", []int64{1}})
+					rs = env.Pool("User").Search(rs.Model().Field(posts).NotIn([]int64{3, 4}))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user".id NOT IN (SELECT user_id FROM "post" WHERE user_id IS NOT NULL AND id IN (?))`)
+					So(args, ShouldResemble, SQLParams{[]int64{3, 4}})
+					rs = env.Pool("User").Search(rs.Model().Field(posts).NotIn([]int64{}))
+					sql, args = rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("user".id IS NULL OR "user".id != ?)`)
+					So(args, ShouldResemble, SQLParams{-1})
+				})
 			}), ShouldBeNil)
 		}
 	})
@@ -324,6 +832,31 @@ func TestConditions(t *testing.T) {
 					So(sql, ShouldEqual, `WHERE ("user".is_staff IS NULL OR "user".is_staff = ?)`)
 					So(args, ShouldContain, false)
 				})
+				Convey("Empty and set fields", func() {
+					users := env.Pool("User")
+					matrix := []struct {
+						field      FieldName
+						empty, set string
+					}{
+						{Name, `("user".name IS NULL OR "user".name = ?)`, `("user".name IS NOT NULL AND "user".name != ?)`},
+						{isStaff, `("user".is_staff IS NULL OR "user".is_staff = ?)`, `("user".is_staff IS NOT NULL AND "user".is_staff != ?)`},
+						{nums, `"user".nums IS NULL`, `"user".nums IS NOT NULL`},
+						{mana, `"user".mana IS NULL`, `"user".mana IS NOT NULL`},
+						{profile, `"user".profile_id IS NULL`, `"user".profile_id IS NOT NULL`},
+						{posts, `"user".id NOT IN (SELECT user_id FROM "post" WHERE user_id IS NOT NULL)`, `"user".id IN (SELECT user_id FROM "post" WHERE user_id IS NOT NULL)`},
+					}
+					for _, c := range matrix {
+						sql, _ := users.Search(users.Model().Field(c.field).IsNull()).query.sqlWhereClause(true)
+						So(sql, ShouldEqual, "WHERE "+c.empty)
+						sql, _ = users.Search(users.Model().Field(c.field).IsSet()).query.sqlWhereClause(true)
+						So(sql, ShouldEqual, "WHERE "+c.set)
+						sql, _ = users.Search(users.Model().Field(c.field).IsNotNull()).query.sqlWhereClause(true)
+						So(sql, ShouldEqual, "WHERE "+c.set)
+					}
+					sql, args := users.Search(users.Model().Field(nums).Equals(false)).query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("user".nums IS NULL OR "user".nums = ?)`)
+					So(args, ShouldContain, int64(0))
+				})
 				Convey("Child Of without parent field", func() {
 					rs = rs.Search(rs.Model().Field(ID).ChildOf(101))
 					sql, args, _ := rs.query.selectQuery([]FieldName{Name})
@@ -394,5 +927,19 @@ func TestConditionSerialization(t *testing.T) {
 			dom := cond.Serialize()
 			So(fmt.Sprint(dom), ShouldEqual, "[& | [C = C Value] | [B = B Value] [A = A Value] [D = D Value]]")
 		})
+		Convey("Testing A AND ANY(B) condition", func() {
+			cond := newCondition().And().Field(a).Equals("A Value").And().AnyOf(b, newCondition().And().Field(c).Equals("C Value"))
+			dom := cond.Serialize()
+			So(fmt.Sprint(dom), ShouldEqual, "[& [A = A Value] [B any [[C = C Value]]]]")
+		})
+		Convey("Testing IsNull and IsSet conditions", func() {
+			cond := newCondition().And().Field(age).IsNull().Or().Field(Name).IsSet()
+			dom := cond.Serialize()
+			So(fmt.Sprint(dom), ShouldEqual, "[| [name is_set <nil>] [age is_empty <nil>]]")
+			So(fmt.Sprint(newCondition().And().Field(age).Equals(nil).Serialize()), ShouldEqual, "[[age = <nil>]]")
+			parsed := newCondition().And().Field(age).AddOperator(operator.IsEmpty, nil).
+				Or().Field(Name).AddOperator(operator.IsSet, nil)
+			So(parsed.predicates, ShouldResemble, cond.predicates)
+		})
 	})
 }
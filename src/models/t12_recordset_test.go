@@ -15,9 +15,21 @@
 package models
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -182,8 +194,9 @@ func TestCreateRecordSet(t *testing.T) {
 			})
 			env.Pool("User").Call("Create", userRobData)
 		})
-		So(err, ShouldNotBeNil)
-		So(err.Error(), ShouldStartWith, "pq: Premium users must have positive nums")
+		So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+		So(err.(exceptions.Error).UserMessage(), ShouldEqual, "Premium users must have positive nums")
+		So(err.(exceptions.Error).DebugInfo(), ShouldStartWith, "pq: ")
 	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	Convey("Testing access control list on creation (create only)", t, func() {
@@ -199,6 +212,11 @@ func TestCreateRecordSet(t *testing.T) {
 					"Email": "tsmith@example.com",
 				})
 				So(func() { env.Pool("User").Call("Create", userTomData) }, ShouldPanic)
+				err := SimulateInNewEnvironment(2, func(env Environment) {
+					env.Pool("User").Call("Create", userTomData)
+				})
+				So(err, ShouldHaveSameTypeAs, exceptions.AccessError{})
+				So(err.(exceptions.Error).UserMessage(), ShouldEqual, "You are not allowed to execute this method")
 			})
 			Convey("Adding model access rights to user 2 and check failure again", func() {
 				userModel.methods.MustGet("Create").AllowGroup(group1)
@@ -310,6 +328,24 @@ func TestSearchRecordSet(t *testing.T) {
 					So(usersAll.Get(Name), ShouldEqual, "Jane Smith")
 					So(usersAll.Get(email), ShouldEqual, "jane.smith@example.com")
 				})
+				Convey("Limiting the search of all users", func() {
+					users := env.Pool("User").SearchAll()
+					So(users.Limit(2).Len(), ShouldEqual, 2)
+					So(users.Limit(0).IsEmpty(), ShouldBeTrue)
+					So(users.Limit(0).SearchCount(), ShouldEqual, 3)
+					So(users.Limit(0).Limit(-1).Len(), ShouldEqual, 3)
+				})
+				Convey("Ordering users by a list of ids", func() {
+					ids := env.Pool("User").SearchAll().OrderBy("Name").Ids()
+					So(ids, ShouldHaveLength, 3)
+					wanted := []int64{ids[2], ids[0], ids[1]}
+					So(env.Pool("User").SearchAll().OrderByIds(wanted).Ids(), ShouldResemble, wanted)
+					So(env.Pool("User").SearchAll().OrderBy("Name desc").OrderByIds([]int64{ids[0]}).Ids(),
+						ShouldResemble, []int64{ids[0], ids[2], ids[1]})
+					So(func() {
+						env.Pool("User").SearchAll().OrderBy("DecoratedName").AllowMemoryOrder(10).OrderByIds(ids).Fetch()
+					}, ShouldPanic)
+				})
 				Convey("Reading all users with Records and Get", func() {
 					recs := usersAll.Records()
 					So(len(recs), ShouldEqual, 3)
@@ -341,6 +377,107 @@ func TestSearchRecordSet(t *testing.T) {
 				So(recs[1].Get(city), ShouldEqual, "")
 				So(recs[2].Get(city), ShouldEqual, "")
 			})
+			Convey("Testing cursor pagination", func() {
+				users := env.Pool("User").SearchAll().OrderBy("Name")
+				page1, cursor := users.SearchPage(2, "")
+				So(page1.Len(), ShouldEqual, 2)
+				So(page1.Records()[0].Get(Name), ShouldEqual, "Jane Smith")
+				So(page1.Records()[1].Get(Name), ShouldEqual, "John Smith")
+				So(cursor, ShouldNotBeBlank)
+				page2, cursor2 := users.SearchPage(2, cursor)
+				So(page2.Len(), ShouldEqual, 1)
+				So(page2.Get(Name), ShouldEqual, "Will Smith")
+				So(cursor2, ShouldBeBlank)
+				usersDesc := env.Pool("User").SearchAll().OrderBy("IsStaff desc", "Name desc")
+				var names []string
+				var next string
+				for {
+					var page *RecordCollection
+					page, next = usersDesc.SearchPage(1, next)
+					for _, rec := range page.Records() {
+						names = append(names, rec.Get(Name).(string))
+					}
+					if next == "" {
+						break
+					}
+				}
+				var expected []string
+				for _, rec := range usersDesc.Records() {
+					expected = append(expected, rec.Get(Name).(string))
+				}
+				So(names, ShouldHaveLength, 3)
+				So(names, ShouldResemble, expected)
+				So(func() { users.SearchPage(2, "invalid cursor") }, ShouldPanic)
+				So(func() { usersDesc.SearchPage(2, cursor) }, ShouldPanic)
+				So(func() { users.SearchPage(0, "") }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().OrderBy("Posts.Title").SearchPage(2, "") }, ShouldPanic)
+			})
+			Convey("Testing cursor pagination with NULL order keys", func() {
+				userJane := env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("jane.smith@example.com"))
+				postModel := Registry.MustGet("Post")
+				for i, lastReadDate := range []string{"2020-03-01", "", "2020-01-01", "", "2020-03-01"} {
+					data := NewModelData(postModel).
+						Set(title, fmt.Sprintf("Due Post %d", i)).
+						Set(user, userJane)
+					if lastReadDate != "" {
+						data.Set(lastRead, dates.ParseDate(lastReadDate))
+					}
+					env.Pool("Post").Call("Create", data)
+				}
+				duePosts := env.Pool("Post").Search(postModel.Field(title).Contains("Due Post"))
+				for _, order := range []string{"LastRead", "LastRead desc", "LastRead nulls first", "LastRead desc nulls last"} {
+					posts := duePosts.OrderBy(order)
+					var titles []string
+					var next string
+					for {
+						var page *RecordCollection
+						page, next = posts.SearchPage(2, next)
+						for _, rec := range page.Records() {
+							titles = append(titles, rec.Get(title).(string))
+						}
+						if next == "" {
+							break
+						}
+					}
+					var expected []string
+					for _, rec := range posts.Records() {
+						expected = append(expected, rec.Get(title).(string))
+					}
+					So(titles, ShouldHaveLength, 5)
+					So(titles, ShouldResemble, expected)
+				}
+				firstPage, cursor := duePosts.OrderBy("LastRead").SearchPage(3, "")
+				So(firstPage.Records()[2].Get(lastRead).(dates.Date).IsZero(), ShouldBeFalse)
+				lastPage, _ := duePosts.OrderBy("LastRead").SearchPage(3, cursor)
+				So(lastPage.Len(), ShouldEqual, 2)
+				for _, rec := range lastPage.Records() {
+					So(rec.Get(lastRead).(dates.Date).IsZero(), ShouldBeTrue)
+				}
+			})
+			Convey("Testing materialized view model", func() {
+				statsModel := Registry.MustGet("UserPostStats")
+				nbPosts := statsModel.FieldName("NbPosts")
+				userJane := env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("jane.smith@example.com"))
+				statsModel.BrowseOne(env, userJane.Ids()[0]).RefreshView(false)
+				janeStats := statsModel.BrowseOne(env, userJane.Ids()[0])
+				nb := janeStats.Get(nbPosts).(int64)
+				env.Pool("Post").Call("Create", NewModelData(Registry.MustGet("Post")).
+					Set(title, "Materialized Post").
+					Set(user, userJane))
+				janeStats.InvalidateCache()
+				So(statsModel.BrowseOne(env, userJane.Ids()[0]).ForceLoad(nbPosts).Get(nbPosts), ShouldEqual, nb)
+				So(statsModel.Search(env, statsModel.Field(ID).Equals(userJane.Ids()[0]).
+					And().Field(nbPosts).Equals(nb)).IsNotEmpty(), ShouldBeTrue)
+				janeStats.RefreshView(true)
+				So(statsModel.BrowseOne(env, userJane.Ids()[0]).Get(nbPosts), ShouldEqual, nb+1)
+				So(func() { env.Pool("UserView").RefreshView(false) }, ShouldPanic)
+				So(func() { Registry.MustGet("UserView").SetViewRefreshPeriod(time.Minute) }, ShouldPanic)
+				comment, ok := adapters[db.DriverName()].materializedViewComment(statsModel.tableName)
+				So(ok, ShouldBeTrue)
+				So(comment, ShouldEqual, viewQueryHash(statsModel.viewQuery))
+				So(viewQueryHash(statsModel.viewQuery+" "), ShouldNotEqual, comment)
+				So(dependentViewModels(statsModel, make(map[string]bool)), ShouldBeEmpty)
+			})
 			Convey("Testing browse with empty ids", func() {
 				var ids []int64
 				users := env.Pool("User").Model().Browse(env, ids)
@@ -378,6 +515,25 @@ func TestSearchRecordSet(t *testing.T) {
 				So(func() { userJane.Load() }, ShouldNotPanic)
 				So(func() { userJane.Get(profile).(RecordSet).Collection().Get(age) }, ShouldPanic)
 			})
+			Convey("Validating domains before searching", func() {
+				users := env.Pool("User")
+				So(users.ValidateDomain(userModel.Field(Name).Equals("Jane Smith")), ShouldBeNil)
+				So(users.ValidateDomain(nil), ShouldBeNil)
+				err := users.ValidateDomain(userModel.Field(profileAge).Equals(23))
+				So(err, ShouldHaveSameTypeAs, exceptions.AccessError{})
+				err = users.ValidateDomain(userModel.AnyOf(posts, Registry.MustGet("Post").Field(title).Equals("1st Post")))
+				So(err, ShouldHaveSameTypeAs, exceptions.AccessError{})
+				err = users.ValidateDomain(userModel.Field(decoratedName).Equals("User: Jane Smith"))
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				unknown := fieldName{name: "Unknown", json: "unknown"}
+				err = users.ValidateDomain(userModel.Field(Name).Equals("Jane Smith").Or().Field(unknown).Equals(1))
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				So(err.Error(), ShouldContainSubstring, "Unknown")
+				err = users.ValidateDomain(userModel.Field(fieldName{name: "Name.Age", json: "name.age"}).Equals(1))
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				So(func() { users.Search(userModel.Field(unknown).Equals(1)) }, ShouldPanic)
+				So(func() { users.Search(userModel.Field(decoratedName).Equals("User: Jane Smith")) }, ShouldPanic)
+			})
 			Convey("Checking record rules", func() {
 				users := env.Pool("User").SearchAll()
 				So(users.Len(), ShouldEqual, 3)
@@ -401,9 +557,148 @@ func TestSearchRecordSet(t *testing.T) {
 				users = env.Pool("User").SearchAll()
 				So(users.Len(), ShouldEqual, 2)
 				So(users.Records()[0].Get(Name), ShouldBeIn, []string{"Jane Smith", "John Smith"})
+				query, args := env.Pool("User").SearchAll().DebugSQL(Name)
+				So(query, ShouldContainSubstring, `"user".name ILIKE $1`)
+				So(args, ShouldResemble, []interface{}{"%j%"})
+
+				group3 := security.Registry.NewGroup("group3", "Group 3")
+				security.Registry.AddMembership(3, group3)
+				userModel.methods.MustGet("Load").AllowGroup(group3)
+				janeRule := RecordRule{
+					Name:      "janeOnly",
+					Group:     group3,
+					Condition: users.Model().Field(Name).IContains("jane"),
+					Perms:     security.Read,
+				}
+				userModel.AddRecordRule(&janeRule)
+				smiths := users.Model().Field(Name).IContains("smith")
+				So(env.Pool("User").SearchCached(smiths).Len(), ShouldEqual, 2)
+				So(env.Pool("User").recordRulesSignature(security.Read), ShouldNotEqual,
+					env.Pool("User").Sudo(3).recordRulesSignature(security.Read))
+				So(env.Pool("User").Sudo(3).SearchCached(smiths).Len(), ShouldEqual, 1)
+				So(env.Pool("User").SearchCached(smiths).Len(), ShouldEqual, 2)
+				So(env.Pool("User").Sudo().SearchCached(smiths).Len(), ShouldEqual, 3)
+				env.Pool("User").Sudo().Call("Create", NewModelData(userModel).
+					Set(Name, "Jim Smith").
+					Set(email, "jim.smith@example.com"))
+				So(env.Pool("User").SearchCached(smiths).Len(), ShouldEqual, 3)
+				So(env.Pool("User").Sudo(3).SearchCached(smiths).Len(), ShouldEqual, 1)
+				So(env.Pool("User").Sudo().SearchCached(smiths).Len(), ShouldEqual, 4)
+				userModel.RemoveRecordRule("janeOnly")
+				userModel.methods.MustGet("Load").RevokeGroup(group3)
+				security.Registry.RemoveMembership(3, group3)
+				security.Registry.UnregisterGroup(group3)
+				So(env.Pool("User").Sudo().Limit(0).SearchCached(smiths).Len(), ShouldEqual, 0)
+				So(env.Pool("User").Sudo().SearchCached(smiths).Len(), ShouldEqual, 4)
 				userModel.RemoveRecordRule("jOnly")
 				userModel.RemoveRecordRule("writeRule")
 			})
+			Convey("Checking record rules combination", func() {
+				group2 := security.Registry.NewGroup("group2", "Group 2")
+				security.Registry.AddMembership(2, group2)
+				names := func(rs *RecordCollection) []string {
+					var res []string
+					for _, rec := range rs.OrderBy("Name").Records() {
+						res = append(res, rec.Get(Name).(string))
+					}
+					return res
+				}
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "janeOnly",
+					Group:     group1,
+					Condition: userModel.Field(Name).IContains("jane"),
+					Perms:     security.Read,
+				})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "willOnly",
+					Group:     group1,
+					Condition: userModel.Field(Name).IContains("will"),
+					Perms:     security.Read,
+				})
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"Jane Smith", "Will Smith"})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "johnOnly",
+					Group:     group2,
+					Condition: userModel.Field(Name).IContains("john"),
+					Perms:     security.Read,
+				})
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"Jane Smith", "John Smith", "Will Smith"})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "johnOnly",
+					Group:     group2,
+					Condition: userModel.Field(Name).IContains("nobody"),
+					Perms:     security.Read,
+				})
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"Jane Smith", "Will Smith"})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "johnOnly",
+					Group:     group2,
+					Condition: userModel.Field(Name).IContains("john"),
+					Perms:     security.Read,
+				})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "notJane",
+					Global:    true,
+					Condition: userModel.Field(Name).NotIContains("jane"),
+					Perms:     security.Read,
+				})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "notWill",
+					Global:    true,
+					Condition: userModel.Field(Name).NotIContains("will"),
+					Perms:     security.Read,
+				})
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"John Smith"})
+				So(names(env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith").
+					Or().Field(Name).Equals("John Smith").
+					Or().Field(Name).Equals("Will Smith"))), ShouldResemble, []string{"John Smith"})
+				So(names(env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))), ShouldBeEmpty)
+				So(names(env.Pool("User").Sudo().SearchAll()), ShouldResemble, []string{"Jane Smith", "John Smith", "Will Smith"})
+				query, _ := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith").
+					Or().Field(Name).Equals("Will Smith")).DebugSQL(Name)
+				So(query, ShouldContainSubstring, `("user".name = $1 OR "user".name = $2) AND ((("user".name NOT ILIKE $3) AND ("user".name NOT ILIKE $4)) AND ((("user".name ILIKE $5) OR ("user".name ILIKE $6)) OR ("user".name ILIKE $7)))`)
+				userModel.AddRecordRule(&RecordRule{
+					Name:  "allForGroup2",
+					Group: group2,
+					Perms: security.Read,
+				})
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"John Smith"})
+				userModel.RemoveRecordRule("notJane")
+				userModel.RemoveRecordRule("notWill")
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"Jane Smith", "John Smith", "Will Smith"})
+				security.Registry.RemoveMembership(2, group2)
+				So(names(env.Pool("User").SearchAll()), ShouldResemble, []string{"Jane Smith", "Will Smith"})
+				userModel.RemoveRecordRule("janeOnly")
+				userModel.RemoveRecordRule("willOnly")
+				userModel.RemoveRecordRule("johnOnly")
+				userModel.RemoveRecordRule("allForGroup2")
+				security.Registry.UnregisterGroup(group2)
+			})
+			Convey("Checking record rules through a many2one to a SQL view", func() {
+				tagModel := Registry.MustGet("Tag")
+				viewModel := Registry.MustGet("UserView")
+				janeView := env.Pool("UserView").Sudo().Search(viewModel.Field(Name).Equals("Jane Smith"))
+				So(janeView.Len(), ShouldEqual, 1)
+				viewTag := env.Pool("Tag").Sudo().Call("Create", NewModelData(tagModel).
+					Set(Name, "View Tag").
+					Set(description, "Tag of Jane's view").
+					Set(userView, janeView)).(RecordSet).Collection()
+				inNewYork := tagModel.Field(userViewCity).Equals("New York")
+				anyInNewYork := tagModel.AnyOf(userView, viewModel.Field(city).Equals("New York"))
+				So(env.Pool("Tag").Search(inNewYork).Ids(), ShouldResemble, viewTag.Ids())
+				So(env.Pool("Tag").Search(anyInNewYork).Ids(), ShouldResemble, viewTag.Ids())
+				So(env.Pool("Tag").Search(tagModel.Field(ID).Equals(viewTag.Ids()[0])).OrderBy("UserView.Name desc").Ids(), ShouldResemble, viewTag.Ids())
+				viewModel.AddRecordRule(&RecordRule{
+					Name:      "johnViewOnly",
+					Group:     group1,
+					Condition: viewModel.Field(Name).IContains("john"),
+					Perms:     security.Read,
+				})
+				So(env.Pool("Tag").Search(inNewYork).Ids(), ShouldResemble, viewTag.Ids())
+				So(env.Pool("Tag").Search(anyInNewYork).IsEmpty(), ShouldBeTrue)
+				So(env.Pool("Tag").Sudo().Search(anyInNewYork).Ids(), ShouldResemble, viewTag.Ids())
+				viewModel.RemoveRecordRule("johnViewOnly")
+			})
 		}), ShouldBeNil)
 	})
 	security.Registry.UnregisterGroup(group1)
@@ -480,6 +775,24 @@ func TestAdvancedQueries(t *testing.T) {
 				So(users.Len(), ShouldEqual, 1)
 				So(users.Get(ID).(int64), ShouldEqual, jane.Get(ID).(int64))
 			})
+			Convey("Reading o2m relation in target model or field order", func() {
+				janePosts := jane.Get(posts).(RecordSet).Collection()
+				So(janePosts.Len(), ShouldBeGreaterThanOrEqualTo, 2)
+				var titles []string
+				for _, post := range janePosts.Records() {
+					titles = append(titles, post.Get(title).(string))
+				}
+				So(titles[0], ShouldEqual, "1st Post")
+				postsField := jane.model.fields.MustGet("Posts")
+				postsField.orders = Registry.MustGet("Post").ordersFromStrings([]string{"Title desc, ID"})
+				defer func() { postsField.orders = nil }()
+				jane.InvalidateCache()
+				janePosts = jane.Get(posts).(RecordSet).Collection()
+				So(janePosts.Len(), ShouldEqual, len(titles))
+				for i, post := range janePosts.Records() {
+					So(post.Get(title), ShouldEqual, titles[len(titles)-1-i])
+				}
+			})
 			Convey("Conditions on o2m relation with null", func() {
 				users := env.Pool("User").Search(env.Pool("User").Model().Field(posts).IsNull())
 				So(users.Len(), ShouldEqual, 2)
@@ -487,6 +800,27 @@ func TestAdvancedQueries(t *testing.T) {
 				So(userRecs[0].Get(Name), ShouldEqual, "John Smith")
 				So(userRecs[1].Get(Name), ShouldEqual, "Will Smith")
 			})
+			Convey("Conditions on o2m relation with not null", func() {
+				users := env.Pool("User").Search(env.Pool("User").Model().Field(posts).IsNotNull())
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get(ID).(int64), ShouldEqual, jane.Get(ID).(int64))
+				users = env.Pool("User").Search(env.Pool("User").Model().Field(posts).NotEquals(false))
+				So(users.Len(), ShouldEqual, 1)
+			})
+			Convey("Negated conditions on o2m relation", func() {
+				janePosts := jane.Get(posts).(RecordSet).Collection()
+				users := env.Pool("User").Search(env.Pool("User").Model().Field(posts).NotEquals(janePosts.Ids()[0]))
+				So(users.Len(), ShouldEqual, 2)
+				So(users.Intersect(jane).IsEmpty(), ShouldBeTrue)
+				users = env.Pool("User").Search(env.Pool("User").Model().Field(posts).NotIn(janePosts))
+				So(users.Len(), ShouldEqual, 2)
+				users = env.Pool("User").Search(env.Pool("User").Model().Field(Name).IsNotNull().
+					AndNot().Field(posts).IsNull())
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get(ID).(int64), ShouldEqual, jane.Get(ID).(int64))
+				users = env.Pool("User").Search(env.Pool("User").Model().Field(posts).NotIn([]int64{}))
+				So(users.Len(), ShouldEqual, 3)
+			})
 			Convey("Condition on o2m relation with IN operator and slice of ids", func() {
 				postIds := jane.Get(posts).(RecordSet).Collection().Ids()
 				users := env.Pool("User").Search(env.Pool("User").Model().Field(posts).In(postIds))
@@ -504,6 +838,24 @@ func TestAdvancedQueries(t *testing.T) {
 				So(users.Len(), ShouldEqual, 1)
 				So(users.Get(ID).(int64), ShouldEqual, jane.Get(ID).(int64))
 			})
+			Convey("Condition on an aggregate of o2m relation records", func() {
+				userModel := env.Pool("User").Model()
+				postModel := env.Pool("Post").Model()
+				nbPosts := jane.Get(posts).(RecordSet).Collection().Len()
+				So(nbPosts, ShouldBeGreaterThan, 0)
+				users := env.Pool("User").Search(userModel.AggregateCondition(posts, AggregateCount, ID, nil).GreaterOrEqual(nbPosts).
+					And().Field(Name).Equals("Jane Smith"))
+				So(users.Ids(), ShouldResemble, jane.Ids())
+				users = env.Pool("User").Search(userModel.AggregateCondition(posts, AggregateCount, ID, nil).Greater(nbPosts).
+					And().Field(Name).Equals("Jane Smith"))
+				So(users.IsEmpty(), ShouldBeTrue)
+				users = env.Pool("User").Search(userModel.AggregateCondition(posts, AggregateCount, ID,
+					postModel.Field(title).Equals("1st Post")).Equals(1))
+				So(users.Ids(), ShouldResemble, jane.Ids())
+				users = env.Pool("User").Search(userModel.AggregateCondition(posts, AggregateCount, ID,
+					postModel.Field(title).Equals("No such post")).Equals(0))
+				So(users.IsEmpty(), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 	Convey("Testing advanced queries on M2M relations", t, func() {
@@ -529,6 +881,19 @@ func TestAdvancedQueries(t *testing.T) {
 				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).IsNull())
 				So(rPosts.Len(), ShouldEqual, 0)
 			})
+			Convey("Condition on m2m relation with not null", func() {
+				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).IsNotNull())
+				So(rPosts.Len(), ShouldEqual, env.Pool("Post").SearchAll().Len())
+			})
+			Convey("Negated conditions on m2m relation", func() {
+				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).NotEquals(tag1))
+				So(rPosts.Len(), ShouldEqual, env.Pool("Post").SearchAll().Len()-1)
+				So(rPosts.Intersect(post1).IsEmpty(), ShouldBeTrue)
+				rPosts = env.Pool("Post").Search(env.Pool("Post").Model().Field(ID).IsNotNull().
+					AndNotCond(env.Pool("Post").Model().Field(tags).Equals(tag1)))
+				So(rPosts.Len(), ShouldEqual, env.Pool("Post").SearchAll().Len()-1)
+				So(rPosts.Intersect(post1).IsEmpty(), ShouldBeTrue)
+			})
 			Convey("Condition on m2m relation with IN operator and ids", func() {
 				tags12 := tag1.Union(tag2)
 				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).In(tags12.Ids()))
@@ -554,6 +919,40 @@ func TestAdvancedQueries(t *testing.T) {
 				So(rPosts.Len(), ShouldEqual, 1)
 				So(rPosts.Get(ID).(int64), ShouldEqual, post1.Get(ID).(int64))
 			})
+			Convey("Condition on m2m relation with contains all operator", func() {
+				tag3 := env.Pool("Tag").Search(env.Pool("Tag").Model().Field(Name).Equals("Jane's"))
+				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAll(tag1.Union(tag3)))
+				So(rPosts.Len(), ShouldEqual, 1)
+				So(rPosts.Equals(post1), ShouldBeTrue)
+				rPosts = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAll(tag3))
+				So(rPosts.Len(), ShouldEqual, 2)
+				rPosts = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAll(tag1.Union(tag2)))
+				So(rPosts.Len(), ShouldEqual, 0)
+			})
+			Convey("Condition on m2m relation with contains any operator", func() {
+				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAny(tag1.Union(tag2)))
+				So(rPosts.Len(), ShouldEqual, 2)
+				rPosts = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAny(tag1))
+				So(rPosts.Len(), ShouldEqual, 1)
+				So(rPosts.Equals(post1), ShouldBeTrue)
+				rPosts = env.Pool("Post").Search(env.Pool("Post").Model().Field(tags).ContainsAny(env.Pool("Tag")))
+				So(rPosts.Len(), ShouldEqual, 0)
+			})
+			Convey("Records matching through several related records should not be duplicated", func() {
+				rPosts := env.Pool("Post").Search(env.Pool("Post").Model().Field(tagsName).In([]string{"Trending", "Books", "Jane's"}))
+				So(rPosts.Len(), ShouldEqual, 2)
+				So(rPosts.SearchCount(), ShouldEqual, 2)
+				So(rPosts.Limit(1).Len(), ShouldEqual, 1)
+				So(rPosts.Limit(1).Offset(1).Len(), ShouldEqual, 1)
+				So(rPosts.Limit(2).Offset(2).Len(), ShouldEqual, 0)
+				descPosts := rPosts.OrderBy("Tags.Name desc")
+				So(descPosts.Len(), ShouldEqual, 2)
+				So(descPosts.Records()[0].Equals(post1), ShouldBeTrue)
+				So(descPosts.Limit(1).Offset(1).Equals(post2), ShouldBeTrue)
+				ascPosts := rPosts.OrderBy("Tags.Name")
+				So(ascPosts.Records()[0].Equals(post2), ShouldBeTrue)
+				So(ascPosts.Limit(1).Equals(post2), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 	Convey("Testing advanced queries with multiple joins", t, func() {
@@ -574,6 +973,520 @@ func TestAdvancedQueries(t *testing.T) {
 	})
 }
 
+func TestRawConditionQueries(t *testing.T) {
+	Convey("Testing queries with raw SQL conditions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			Convey("Raw conditions are combined with other predicates", func() {
+				res := users.Search(RawCondition("{table}.nums % ? = 1", 2).And().Field(isStaff).Equals(true)).OrderBy("Nums")
+				So(res.Len(), ShouldEqual, 2)
+				So(res.Records()[0].Get(Name), ShouldEqual, "John Smith")
+				So(res.Records()[1].Get(Name), ShouldEqual, "Will Smith")
+				So(res.Limit(1).Offset(1).Get(Name), ShouldEqual, "Will Smith")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestEmptyRecordSets(t *testing.T) {
+	Convey("Testing CRUD methods on empty RecordSets", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userModel := users.Model()
+			Convey("Fetched empty RecordSets are searched again when loaded or counted", func() {
+				nobody := users.Search(userModel.Field(Name).Equals("Nobody"))
+				nobody.Fetch()
+				So(nobody.IsEmpty(), ShouldBeTrue)
+				users.Call("Create", NewModelData(userModel).
+					Set(Name, "Nobody").
+					Set(email, "nobody@example.com"))
+				So(nobody.ForceLoad().Len(), ShouldEqual, 1)
+				So(nobody.SearchCount(), ShouldEqual, 1)
+			})
+			Convey("CRUD methods on empty RecordSets do nothing", func() {
+				nobody := users.Search(userModel.Field(Name).Equals("Nobody"))
+				nobody.Fetch()
+				So(nobody.IsEmpty(), ShouldBeTrue)
+				So(nobody.Call("Write", NewModelData(userModel).Set(email, "somebody@example.com")).(bool), ShouldBeTrue)
+				So(nobody.Call("Read", FieldNames{Name, email}), ShouldBeEmpty)
+				So(nobody.Call("Unlink").(int64), ShouldEqual, 0)
+				So(users.Search(userModel.Field(email).Equals("somebody@example.com")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Searches with impossible conditions are not executed", func() {
+				impossible := users.Search(userModel.Field(Name).In([]string{}).And().Field(isStaff).Equals(true))
+				So(impossible.query.cond.isImpossible(), ShouldBeTrue)
+				So(impossible.SearchCount(), ShouldEqual, 0)
+				So(impossible.IsEmpty(), ShouldBeTrue)
+				So(impossible.Call("Write", NewModelData(userModel).Set(email, "nobody@example.com")).(bool), ShouldBeTrue)
+				So(impossible.Call("Unlink").(int64), ShouldEqual, 0)
+				possible := users.Search(userModel.Field(ID).In([]int64{}).Or().Field(isStaff).Equals(true))
+				So(possible.query.cond.isImpossible(), ShouldBeFalse)
+				So(possible.IsEmpty(), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestUnknownFieldsInData(t *testing.T) {
+	Convey("Testing data with unknown fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userModel := users.Model()
+			userJane := users.Search(userModel.Field(email).Equals("jane.smith@example.com"))
+			Convey("Data cannot be built from a FieldMap with a misspelled field", func() {
+				So(func() { NewModelData(userModel, FieldMap{"Nmae": "Jane"}) }, ShouldPanic)
+			})
+			Convey("Creating records with a misspelled field panics before any query", func() {
+				data := NewModelData(userModel).Set(Name, "Typo User").Set(email, "typo@example.com")
+				data.FieldMap["emial"] = "other@example.com"
+				So(func() { users.Call("Create", data) }, ShouldPanic)
+				So(users.Search(userModel.Field(Name).Equals("Typo User")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Writing an unknown field panics, even on empty RecordSets", func() {
+				data := NewModelData(userModel).Set(Name, "Jane")
+				data.FieldMap["unknown_field"] = 12
+				So(func() { userJane.Call("Write", data) }, ShouldPanic)
+				So(func() { users.Search(userModel.Field(Name).Equals("Nobody")).Call("Write", data) }, ShouldPanic)
+				pathData := NewModelData(userModel)
+				pathData.FieldMap["profile_id.agee"] = 12
+				So(func() { userJane.Call("Write", pathData) }, ShouldPanic)
+				So(userJane.Get(Name), ShouldEqual, "Jane Smith")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestRequiredFields(t *testing.T) {
+	Convey("Testing required fields on create and write", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			posts := env.Pool("Post")
+			postModel := posts.Model()
+			userJane := env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("jane.smith@example.com"))
+			Convey("Creating a record without a required field panics with a UserError", func() {
+				var err interface{}
+				func() {
+					defer func() { err = recover() }()
+					posts.Call("Create", NewModelData(postModel).Set(user, userJane))
+				}()
+				So(err, ShouldHaveSameTypeAs, exceptions.UserError{})
+				So(err.(exceptions.UserError).Debug, ShouldEqual, "model: Post, fields: Title")
+				So(func() { posts.Call("Create", NewModelData(postModel).Set(title, "").Set(user, userJane)) }, ShouldPanic)
+			})
+			Convey("Clearing a required field on write panics", func() {
+				post := posts.Call("Create", NewModelData(postModel).Set(title, "Required Post")).(RecordSet).Collection()
+				So(func() { post.Call("Write", NewModelData(postModel).Set(title, "")) }, ShouldPanic)
+				So(func() { post.Call("Write", NewModelData(postModel).Set(title, false)) }, ShouldPanic)
+				So(post.Get(title), ShouldEqual, "Required Post")
+			})
+			Convey("Writing other fields does not check untouched required fields", func() {
+				post := posts.Call("Create", NewModelData(postModel).Set(title, "Required Post")).(RecordSet).Collection()
+				So(func() { post.Call("Write", NewModelData(postModel).Set(user, userJane)) }, ShouldNotPanic)
+				So(post.Get(user).(RecordSet).Collection().Equals(userJane), ShouldBeTrue)
+				So(func() { post.Call("Write", NewModelData(postModel).Set(title, "New Title")) }, ShouldNotPanic)
+				So(post.Get(title), ShouldEqual, "New Title")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestSelectionValues(t *testing.T) {
+	Convey("Testing selection values on create and write", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profileModel := Registry.MustGet("Profile")
+			gender := profileModel.FieldName("Gender")
+			Convey("Static selection values are checked on create and write", func() {
+				var err interface{}
+				func() {
+					defer func() { err = recover() }()
+					env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "unknown"))
+				}()
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				So(err.(exceptions.ValidationError).Message, ShouldContainSubstring, "f, m")
+				prof := env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "f")).(RecordSet).Collection()
+				So(prof.Get(gender), ShouldEqual, "f")
+				So(func() { prof.Call("Write", NewModelData(profileModel).Set(gender, "Male")) }, ShouldPanic)
+				So(func() { prof.Call("Write", NewModelData(profileModel).Set(gender, "m")) }, ShouldNotPanic)
+				So(func() { prof.Call("Write", NewModelData(profileModel).Set(gender, false)) }, ShouldNotPanic)
+				So(prof.Get(gender), ShouldEqual, "")
+			})
+			Convey("Dynamic selection values are evaluated when writing", func() {
+				postModel := Registry.MustGet("Post")
+				visibility := postModel.FieldName("Visibility")
+				fi := postModel.fields.MustGet("Visibility")
+				defer func(selFunc func() types.Selection) { fi.selectionFunc = selFunc }(fi.selectionFunc)
+				options := types.Selection{"visible": "Visible"}
+				fi.selectionFunc = func() types.Selection { return options }
+				post := env.Pool("Post").Call("Create", NewModelData(postModel).
+					Set(title, "Selection Post").
+					Set(visibility, "visible")).(RecordSet).Collection()
+				So(func() { post.Call("Write", NewModelData(postModel).Set(visibility, "logged_in")) }, ShouldPanic)
+				options["logged_in"] = "Logged in users"
+				So(func() { post.Call("Write", NewModelData(postModel).Set(visibility, "logged_in")) }, ShouldNotPanic)
+				So(post.Get(visibility), ShouldEqual, "logged_in")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestBinSizeMode(t *testing.T) {
+	Convey("Testing bin_size mode of binary fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			attachment := postModel.FieldName("Attachment")
+			content := strings.Repeat("A", 2048)
+			post1 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Bin Size Post 1").
+				Set(attachment, content)).(RecordSet).Collection()
+			post2 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Bin Size Post 2")).(RecordSet).Collection()
+			env.cache.invalidateRecord(postModel, post1.Ids()[0])
+			env.cache.invalidateRecord(postModel, post2.Ids()[0])
+			posts := env.Pool("Post").WithContext("bin_size", true).
+				Search(postModel.Field(title).Contains("Bin Size Post")).OrderBy("Title")
+			Convey("Binary fields are read as their size without loading them", func() {
+				recs := posts.Records()
+				So(recs, ShouldHaveLength, 2)
+				So(recs[0].Get(title), ShouldEqual, "Bin Size Post 1")
+				So(recs[0].Get(attachment), ShouldEqual, "1.50 Kb")
+				So(recs[1].Get(attachment), ShouldEqual, "")
+				So(env.cache.checkIfInCache(postModel, posts.Ids(), []string{"attachment"}, posts.query.ctxArgsSlug(), true), ShouldBeFalse)
+			})
+			Convey("Binary fields are read as their content without bin_size", func() {
+				So(post1.WithContext("bin_size", false).Get(attachment), ShouldEqual, content)
+				So(post1.Get(attachment), ShouldEqual, content)
+			})
+			Convey("Sizes of values in cache are computed from the cache", func() {
+				So(post1.Get(attachment), ShouldEqual, content)
+				So(post1.WithContext("bin_size", true).Get(attachment), ShouldEqual, "1.50 Kb")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestSearchScope(t *testing.T) {
+	Convey("Testing search scopes", t, func() {
+		tagModel := Registry.MustGet("Tag")
+		tagModel.SetSearchScope(func(env Environment) Conditioner {
+			return tagModel.Field(Name).NotContains("Hidden")
+		})
+		defer tagModel.SetSearchScope(nil)
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			visible := env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Scoped Tag")).(RecordSet).Collection()
+			hidden := env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Scoped Hidden Tag")).(RecordSet).Collection()
+			Convey("Searches only find records of the scope", func() {
+				tags := env.Pool("Tag").Call("Search", tagModel.Field(Name).Contains("Scoped")).(RecordSet).Collection()
+				So(tags.Equals(visible), ShouldBeTrue)
+				all := env.Pool("Tag").Call("SearchAll").(RecordSet).Collection()
+				So(all.Intersect(hidden).IsEmpty(), ShouldBeTrue)
+				So(all.Intersect(visible).IsEmpty(), ShouldBeFalse)
+				byName := env.Pool("Tag").Call("SearchByName", "Scoped", operator.IContains, newCondition(), 10).(RecordSet).Collection()
+				So(byName.Equals(visible), ShouldBeTrue)
+			})
+			Convey("Browsing ignores the scope", func() {
+				So(env.Pool("Tag").Call("BrowseOne", hidden.Ids()[0]).(RecordSet).Collection().Get(Name), ShouldEqual, "Scoped Hidden Tag")
+				So(tagModel.Browse(env, hidden.Ids()).Len(), ShouldEqual, 1)
+			})
+			Convey("The scope is not applied with no_search_scope in the context", func() {
+				tags := env.Pool("Tag").WithContext("no_search_scope", true).
+					Call("Search", tagModel.Field(Name).Contains("Scoped")).(RecordSet).Collection()
+				So(tags.Len(), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestChangeListeners(t *testing.T) {
+	Convey("Testing change listeners", t, func() {
+		var changes []RecordsChange
+		RegisterChangeListener(func(env Environment, change RecordsChange) {
+			if change.Model == "Tag" {
+				changes = append(changes, change)
+			}
+		})
+		defer func() {
+			changeListeners = changeListeners[:len(changeListeners)-1]
+		}()
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tag := env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Listened Tag")).(RecordSet).Collection()
+			tag.Call("Write", NewModelData(tagModel).Set(Name, "Listened Tag Renamed"))
+			tag.Call("Unlink")
+			So(changes, ShouldHaveLength, 3)
+			So(changes[0].Model, ShouldEqual, "Tag")
+			So(changes[0].Event, ShouldEqual, ChangeCreated)
+			So(changes[0].IDs, ShouldResemble, tag.Ids())
+			So(changes[0].Fields, ShouldContain, "name")
+			So(changes[1].Event, ShouldEqual, ChangeWritten)
+			So(changes[1].IDs, ShouldResemble, tag.Ids())
+			So(changes[1].Fields, ShouldResemble, []string{"name"})
+			So(changes[2].Event, ShouldEqual, ChangeUnlinked)
+			So(changes[2].IDs, ShouldResemble, tag.Ids())
+			So(changes[2].Fields, ShouldBeEmpty)
+		}), ShouldBeNil)
+	})
+}
+
+func TestRawSQLChangeListeners(t *testing.T) {
+	Convey("Testing change listeners of fields updated in the database", t, func() {
+		var changes []RecordsChange
+		RegisterChangeListener(func(env Environment, change RecordsChange) {
+			changes = append(changes, change)
+		})
+		defer func() {
+			changeListeners = changeListeners[:len(changeListeners)-1]
+		}()
+		changedIds := func(model, field string) []int64 {
+			var res []int64
+			for _, change := range changes {
+				if change.Model != model || change.Event != ChangeWritten {
+					continue
+				}
+				for _, f := range change.Fields {
+					if f == field {
+						res = append(res, change.IDs...)
+					}
+				}
+			}
+			return res
+		}
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			postModel := Registry.MustGet("Post")
+			invoiceModel := Registry.MustGet("Invoice")
+			lineModel := Registry.MustGet("InvoiceLine")
+			invoice := lineModel.FieldName("Invoice")
+			writer := userModel.Create(env, NewModelData(userModel).
+				Set(Name, "Notified Writer").
+				Set(email, "notified.writer@example.com"))
+			notifiedPost := postModel.Create(env, NewModelData(postModel).
+				Set(title, "Notified Post").
+				Set(content, "Content").
+				Set(user, writer))
+			notifiedInvoice := invoiceModel.Create(env, NewModelData(invoiceModel).Set(Name, "Notified Invoice"))
+			firstLine := lineModel.Create(env, NewModelData(lineModel).Set(invoice, notifiedInvoice).Set(text, "First"))
+			secondLine := lineModel.Create(env, NewModelData(lineModel).Set(invoice, notifiedInvoice).Set(text, "Second"))
+			Convey("Touched parents are notified", func() {
+				changes = nil
+				secondLine.Set(text, "Second edited")
+				So(changedIds("Invoice", "write_date"), ShouldResemble, notifiedInvoice.Ids())
+				So(changedIds("Invoice", "write_uid"), ShouldResemble, notifiedInvoice.Ids())
+			})
+			Convey("Renumbered lines are notified", func() {
+				changes = nil
+				firstLine.Call("Unlink")
+				So(changedIds("InvoiceLine", "line_number"), ShouldResemble, secondLine.Ids())
+			})
+			Convey("Stored related fields are notified", func() {
+				changes = nil
+				writer.Set(email, "notified.writer2@example.com")
+				So(changedIds("Post", "writer_email"), ShouldResemble, notifiedPost.Ids())
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestWriteJSONPath(t *testing.T) {
+	Convey("Testing writing JSON paths", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			abstract := postModel.FieldName("Abstract")
+			newPost := func(name, abs string) *RecordCollection {
+				return postModel.Create(env, NewModelData(postModel).
+					Set(title, name).
+					Set(content, "Content").
+					Set(abstract, abs))
+			}
+			settings := newPost("JSON Post", `{"theme": {"font": "serif"}, "count": 12345678901234567}`)
+			empty := newPost("Empty JSON Post", "")
+			Convey("Values are set at their path keeping other keys", func() {
+				So(settings.WriteJSONPath(abstract, "theme.color", "blue"), ShouldBeTrue)
+				So(settings.Get(abstract), ShouldEqual, `{"count":12345678901234567,"theme":{"color":"blue","font":"serif"}}`)
+			})
+			Convey("Missing intermediate objects are created", func() {
+				settings.Union(empty).WriteJSONPath(abstract, "editor.keys.save", "<ctrl>S")
+				So(settings.Get(abstract), ShouldEqual,
+					`{"count":12345678901234567,"editor":{"keys":{"save":"<ctrl>S"}},"theme":{"font":"serif"}}`)
+				So(empty.Get(abstract), ShouldEqual, `{"editor":{"keys":{"save":"<ctrl>S"}}}`)
+			})
+			Convey("Values are read again from the database", func() {
+				So(settings.Get(abstract), ShouldContainSubstring, "serif")
+				env.Cr().Execute(`UPDATE post SET abstract = '{"language": "fr"}' WHERE id = ?`, settings.Ids()[0])
+				settings.WriteJSONPath(abstract, "theme", "dark")
+				So(settings.Get(abstract), ShouldEqual, `{"language":"fr","theme":"dark"}`)
+			})
+			Convey("Invalid values and paths panic", func() {
+				So(func() { settings.WriteJSONPath(abstract, "count.digits", 17) }, ShouldPanic)
+				So(func() { settings.WriteJSONPath(abstract, "", 17) }, ShouldPanic)
+				So(func() { newPost("Not JSON Post", "Some text").WriteJSONPath(abstract, "theme", "dark") }, ShouldPanic)
+				So(func() { settings.WriteJSONPath(postModel.FieldName("Visibility"), "theme", "dark") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestBinaryReader(t *testing.T) {
+	Convey("Testing streaming of binary fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			attachment := postModel.FieldName("Attachment")
+			content := make([]byte, binaryStreamChunkSize+1000)
+			for i := range content {
+				content[i] = byte(i % 251)
+			}
+			post := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Streamed Post").
+				Set(attachment, base64.StdEncoding.EncodeToString(content))).(RecordSet).Collection()
+			emptyPost := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Empty Streamed Post")).(RecordSet).Collection()
+			env.cache.invalidateRecord(postModel, post.Ids()[0])
+			env.cache.invalidateRecord(postModel, emptyPost.Ids()[0])
+			Convey("The whole content is streamed from the database in chunks", func() {
+				br := post.BinaryReader(attachment)
+				So(br.Size(), ShouldEqual, len(content))
+				var buf bytes.Buffer
+				n, err := io.Copy(&buf, br)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, len(content))
+				So(bytes.Equal(buf.Bytes(), content), ShouldBeTrue)
+				So(env.cache.checkIfInCache(postModel, post.Ids(), []string{"attachment"}, post.query.ctxArgsSlug(), true), ShouldBeFalse)
+			})
+			Convey("Ranges can be read across chunks", func() {
+				br := post.BinaryReader(attachment)
+				offset := int64(binaryStreamChunkSize - 10)
+				pos, err := br.Seek(offset, io.SeekStart)
+				So(err, ShouldBeNil)
+				So(pos, ShouldEqual, offset)
+				part, err := ioutil.ReadAll(io.LimitReader(br, 20))
+				So(err, ShouldBeNil)
+				So(bytes.Equal(part, content[offset:offset+20]), ShouldBeTrue)
+				pos, _ = br.Seek(-5, io.SeekEnd)
+				So(pos, ShouldEqual, len(content)-5)
+				part, err = ioutil.ReadAll(br)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(part, content[len(content)-5:]), ShouldBeTrue)
+			})
+			Convey("Values in cache are read from the cache", func() {
+				post.Set(attachment, base64.StdEncoding.EncodeToString([]byte("Small file")))
+				part, err := ioutil.ReadAll(post.BinaryReader(attachment))
+				So(err, ShouldBeNil)
+				So(string(part), ShouldEqual, "Small file")
+			})
+			Convey("Empty binary fields have an empty reader", func() {
+				br := emptyPost.BinaryReader(attachment)
+				So(br.Size(), ShouldEqual, 0)
+				part, err := ioutil.ReadAll(br)
+				So(err, ShouldBeNil)
+				So(part, ShouldBeEmpty)
+			})
+			Convey("Readers can only be created on binary fields", func() {
+				So(func() { post.BinaryReader(title) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestUUIDKeys(t *testing.T) {
+	Convey("Testing models with uuid primary keys", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			deviceModel := Registry.MustGet("Device")
+			sensorModel := Registry.MustGet("Sensor")
+			device := sensorModel.FieldName("Device")
+			thermo := deviceModel.Create(env, NewModelData(deviceModel).Set(Name, "Thermometer"))
+			key := "6f1c2a8e-93d4-4b7e-a1f0-2c5d9e8b7a61"
+			hygro := deviceModel.Create(env, NewModelData(deviceModel).Set(Name, "Hygrometer").Set(UUID, key))
+			sensor := sensorModel.Create(env, NewModelData(sensorModel).Set(Name, "Kitchen").Set(device, hygro))
+			Convey("The uuid column is the primary key of the table", func() {
+				var pkColumn string
+				env.Cr().Get(&pkColumn, `
+					SELECT a.attname FROM pg_index i
+					JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+					WHERE i.indrelid = '"device"'::regclass AND i.indisprimary`)
+				So(pkColumn, ShouldEqual, "uuid")
+				So(deviceModel.HasUUIDKey(), ShouldBeTrue)
+				So(sensorModel.HasUUIDKey(), ShouldBeFalse)
+			})
+			Convey("UUIDs are generated at creation unless given", func() {
+				So(thermo.UUIDs(), ShouldHaveLength, 1)
+				So(thermo.UUIDs()[0], ShouldHaveLength, 36)
+				So(hygro.UUIDs(), ShouldResemble, []string{key})
+				So(thermo.Call("Copy", NewModelData(deviceModel)).(RecordSet).Collection().UUIDs()[0], ShouldNotEqual, thermo.UUIDs()[0])
+				var dbKey string
+				env.Cr().Get(&dbKey, `INSERT INTO "device" (name, hexya_external_id) VALUES ('Barometer', 'barometer') RETURNING uuid`)
+				So(dbKey, ShouldHaveLength, 36)
+			})
+			Convey("Records can be browsed and searched by uuid", func() {
+				devices := deviceModel.BrowseUUIDs(env, []string{key, thermo.UUIDs()[0]})
+				So(devices.Len(), ShouldEqual, 2)
+				So(devices.Intersect(thermo.Union(hygro)).Len(), ShouldEqual, 2)
+				So(deviceModel.Search(env, deviceModel.Field(ID).Equals(key)).Equals(hygro), ShouldBeTrue)
+				So(deviceModel.Search(env, deviceModel.Field(ID).In([]interface{}{key})).Equals(hygro), ShouldBeTrue)
+				So(deviceModel.Search(env, deviceModel.Field(ID).Equals(thermo.Ids()[0])).Equals(thermo), ShouldBeTrue)
+				So(sensorModel.Search(env, sensorModel.Field(device).Equals(key)).Equals(sensor), ShouldBeTrue)
+				So(sensorModel.Search(env, sensorModel.Field(device).NotIn([]string{key})).Intersect(sensor).IsEmpty(), ShouldBeTrue)
+				So(sensor.Get(device).(RecordSet).Collection().UUIDs(), ShouldResemble, []string{key})
+			})
+			Convey("UUID methods panic on models without uuid primary keys", func() {
+				So(func() { sensor.UUIDs() }, ShouldPanic)
+				So(func() { sensorModel.BrowseUUIDs(env, []string{key}) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestActiveField(t *testing.T) {
+	Convey("Testing archived records of models with an active field", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			checklistModel := Registry.MustGet("Checklist")
+			itemModel := Registry.MustGet("ChecklistItem")
+			items := checklistModel.FieldName("Items")
+			allItems := checklistModel.FieldName("AllItems")
+			active := itemModel.FieldName("Active")
+			checklist := checklistModel.Create(env, NewModelData(checklistModel).Set(Name, "Packing"))
+			tent := itemModel.Create(env, NewModelData(itemModel).
+				Set(Name, "Tent").
+				Set(itemModel.FieldName("Checklist"), checklist))
+			stove := itemModel.Create(env, NewModelData(itemModel).
+				Set(Name, "Stove").
+				Set(itemModel.FieldName("Checklist"), checklist).
+				Set(active, false))
+			Convey("Searches leave archived records out", func() {
+				found := itemModel.Search(env, itemModel.Field(Name).In([]string{"Tent", "Stove"}))
+				So(found.Equals(tent), ShouldBeTrue)
+				So(itemModel.Search(env, itemModel.Field(Name).Equals("Stove")).IsEmpty(), ShouldBeTrue)
+				So(env.Pool("ChecklistItem").Call("SearchCached", itemModel.Field(Name).Equals("Stove")).(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(env.Pool("ChecklistItem").Call("SearchAll").(RecordSet).Collection().Intersect(tent.Union(stove)).Equals(tent), ShouldBeTrue)
+			})
+			Convey("Searches on the active field find archived records", func() {
+				So(itemModel.Search(env, itemModel.Field(active).Equals(false)).Equals(stove), ShouldBeTrue)
+				So(env.Pool("ChecklistItem").Call("SearchCached", itemModel.Field(active).Equals(false)).(RecordSet).Collection().Equals(stove), ShouldBeTrue)
+			})
+			Convey("Searches with active_test set to false find archived records", func() {
+				found := env.Pool("ChecklistItem").WithContext("active_test", false).
+					Call("Search", itemModel.Field(Name).In([]string{"Tent", "Stove"})).(RecordSet).Collection()
+				So(found.Len(), ShouldEqual, 2)
+			})
+			Convey("Browse finds archived records", func() {
+				So(itemModel.Browse(env, stove.Ids()).Len(), ShouldEqual, 1)
+			})
+			Convey("Relation fields leave archived records out unless IncludeArchived is set", func() {
+				So(checklist.Get(items).(RecordSet).Collection().Equals(tent), ShouldBeTrue)
+				So(checklist.Get(allItems).(RecordSet).Len(), ShouldEqual, 2)
+			})
+			Convey("Relation fields read with active_test set to false include archived records", func() {
+				lines := checklist.WithContext("active_test", false).Get(items).(RecordSet).Collection()
+				So(lines.Len(), ShouldEqual, 2)
+				So(lines.Intersect(tent.Union(stove)).Len(), ShouldEqual, 2)
+				So(checklist.WithContext("active_test", true).Get(allItems).(RecordSet).Len(), ShouldEqual, 2)
+			})
+			Convey("Archiving a record removes it from relation fields", func() {
+				tent.Set(active, false)
+				So(checklist.Get(items).(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(checklist.Get(allItems).(RecordSet).Len(), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestGroupedQueries(t *testing.T) {
 	Convey("Testing grouped queries", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -591,6 +1504,364 @@ func TestGroupedQueries(t *testing.T) {
 				So(groupedUsers[1].Values.Get(nums), ShouldEqual, 4)
 				So(groupedUsers[1].Count, ShouldEqual, 2)
 			})
+			Convey("Grouped query on a full table sample", func() {
+				sampled := env.Pool("User").SearchAll().TableSample(SampleBernoulli, 100)
+				query, _ := sampled.DebugSQL(Name)
+				So(query, ShouldContainSubstring, "TABLESAMPLE BERNOULLI (100)")
+				So(sampled.SearchCount(), ShouldEqual, 3)
+				groupedUsers := sampled.GroupBy(isStaff).Aggregates(isStaff, nums)
+				So(len(groupedUsers), ShouldEqual, 2)
+				So(groupedUsers[0].Values.Get(nums), ShouldEqual, 2)
+				So(groupedUsers[0].Count, ShouldEqual, 1)
+				So(groupedUsers[1].Values.Get(nums), ShouldEqual, 4)
+				So(groupedUsers[1].Count, ShouldEqual, 2)
+			})
+			Convey("Counts on a partial table sample are estimates of the whole table", func() {
+				sampled := env.Pool("User").SearchAll().TableSample(SampleSystem, 50)
+				So(sampled.SearchCount()%2, ShouldEqual, 0)
+				So(sampled.SearchCount(), ShouldBeLessThanOrEqualTo, 6)
+			})
+			Convey("Invalid table samples should panic", func() {
+				So(func() { env.Pool("User").SearchAll().TableSample(SampleSystem, 0) }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().TableSample(SampleSystem, 101) }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().TableSample("RANDOM", 10) }, ShouldPanic)
+			})
+			Convey("Grouped search with the first records of each group", func() {
+				groups := env.Pool("User").SearchAll().OrderBy("Nums DESC").SearchGrouped(isStaff, 1, nums)
+				So(len(groups), ShouldEqual, 2)
+				So(groups[0].Values.Get(isStaff), ShouldBeFalse)
+				So(groups[0].Values.Get(nums), ShouldEqual, 2)
+				So(groups[0].Count, ShouldEqual, 1)
+				So(groups[0].Records.Len(), ShouldEqual, 1)
+				So(groups[0].Records.Get(isStaff), ShouldBeFalse)
+				So(groups[1].Values.Get(isStaff), ShouldBeTrue)
+				So(groups[1].Values.Get(nums), ShouldEqual, 4)
+				So(groups[1].Count, ShouldEqual, 2)
+				So(groups[1].Records.Len(), ShouldEqual, 1)
+				So(groups[1].Records.Get(isStaff), ShouldBeTrue)
+				others := env.Pool("User").Search(groups[1].Condition).Subtract(groups[1].Records)
+				So(others.Len(), ShouldEqual, 1)
+				So(others.Get(nums).(int), ShouldBeLessThanOrEqualTo, groups[1].Records.Get(nums).(int))
+				So(func() { env.Pool("User").SearchAll().SearchGrouped(isStaff, 0) }, ShouldPanic)
+			})
+			Convey("Groups by a many2one are ordered by the related model order", func() {
+				tagModel := Registry.MustGet("Tag")
+				parent := tagModel.FieldName("Parent")
+				stages := make(map[string]*RecordCollection)
+				for _, name := range []string{"Kanban Stage A", "Kanban Stage B", "Kanban Stage C"} {
+					stages[name] = env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, name)).(RecordSet).Collection()
+				}
+				for i, stage := range []string{"Kanban Stage A", "Kanban Stage C", "Kanban Stage A"} {
+					env.Pool("Tag").Call("Create", NewModelData(tagModel).
+						Set(Name, fmt.Sprintf("Kanban Card %d", i)).
+						Set(parent, stages[stage]))
+				}
+				env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Kanban Card Without Stage"))
+				cards := env.Pool("Tag").Search(tagModel.Field(Name).Contains("Kanban Card"))
+				groups := cards.SearchGrouped(parent, 10)
+				So(groups, ShouldHaveLength, 3)
+				So(groups[0].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage C"]), ShouldBeTrue)
+				So(groups[0].Count, ShouldEqual, 1)
+				So(groups[1].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage A"]), ShouldBeTrue)
+				So(groups[1].Count, ShouldEqual, 2)
+				So(groups[1].Records.Len(), ShouldEqual, 2)
+				So(groups[2].Values.Get(parent).(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(groups[2].Count, ShouldEqual, 1)
+				Convey("Empty stages are returned with group_expand", func() {
+					expanded := cards.WithContext("group_expand", true).SearchGrouped(parent, 10)
+					var names []string
+					for _, group := range expanded {
+						stage := group.Values.Get(parent).(RecordSet).Collection()
+						if stage.IsEmpty() || !strings.HasPrefix(stage.Get(Name).(string), "Kanban Stage") {
+							continue
+						}
+						names = append(names, stage.Get(Name).(string))
+						if stage.Equals(stages["Kanban Stage B"]) {
+							So(group.Count, ShouldEqual, 0)
+							So(group.Records.IsEmpty(), ShouldBeTrue)
+							So(env.Pool("Tag").Search(group.Condition).IsEmpty(), ShouldBeTrue)
+						}
+					}
+					So(names, ShouldResemble, []string{"Kanban Stage C", "Kanban Stage B", "Kanban Stage A"})
+					So(expanded[len(expanded)-1].Values.Get(parent).(RecordSet).IsEmpty(), ShouldBeTrue)
+				})
+				Convey("Aggregates are ordered by the related model order", func() {
+					rows := cards.GroupBy(parent).Aggregates(parent)
+					So(rows, ShouldHaveLength, 3)
+					So(rows[0].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage C"]), ShouldBeTrue)
+					So(rows[1].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage A"]), ShouldBeTrue)
+					So(rows[1].Count, ShouldEqual, 2)
+					So(rows[2].Values.Get(parent).(RecordSet).IsEmpty(), ShouldBeTrue)
+				})
+				Convey("Only stages matching the condition on the group field are expanded", func() {
+					stagesAB := stages["Kanban Stage A"].Union(stages["Kanban Stage B"])
+					rows := env.Pool("Tag").Search(tagModel.Field(Name).Contains("Kanban Card").And().Field(parent).In(stagesAB)).
+						WithContext("group_expand", true).GroupBy(parent).Aggregates(parent)
+					So(rows, ShouldHaveLength, 2)
+					So(rows[0].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage B"]), ShouldBeTrue)
+					So(rows[0].Count, ShouldEqual, 0)
+					So(env.Pool("Tag").Search(rows[0].Condition).IsEmpty(), ShouldBeTrue)
+					So(rows[1].Values.Get(parent).(RecordSet).Collection().Equals(stages["Kanban Stage A"]), ShouldBeTrue)
+					So(rows[1].Count, ShouldEqual, 2)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestDistinctOnQueries(t *testing.T) {
+	Convey("Testing distinct on queries", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Getting the user with the highest nums for staff and non staff", func() {
+				users := env.Pool("User").SearchAll().OrderBy("Nums desc").DistinctOn(isStaff).Fetch()
+				So(users.Len(), ShouldEqual, 2)
+				So(users.Records()[0].Get(Name), ShouldEqual, "Will Smith")
+				So(users.Records()[1].Get(Name), ShouldEqual, "Jane Smith")
+			})
+			Convey("Distinct on is applied before limit and count", func() {
+				users := env.Pool("User").SearchAll().OrderBy("Nums").DistinctOn(isStaff)
+				So(users.SearchCount(), ShouldEqual, 2)
+				first := users.Limit(1).Fetch()
+				So(first.Len(), ShouldEqual, 1)
+				So(first.Get(Name), ShouldEqual, "John Smith")
+			})
+			Convey("Distinct on a non stored field should panic", func() {
+				So(func() { env.Pool("User").SearchAll().DistinctOn(posts) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing limit per partition queries", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Getting the user with the highest nums for staff and non staff", func() {
+				users := env.Pool("User").SearchAll().OrderBy("Nums desc").LimitPerPartition(1, isStaff).Fetch()
+				So(users.Len(), ShouldEqual, 2)
+				So(users.Records()[0].Get(Name), ShouldEqual, "Will Smith")
+				So(users.Records()[1].Get(Name), ShouldEqual, "Jane Smith")
+			})
+			Convey("Getting the two users with the highest nums for staff and non staff", func() {
+				users := env.Pool("User").SearchAll().OrderBy("Nums desc").LimitPerPartition(2, isStaff)
+				So(users.SearchCount(), ShouldEqual, 3)
+				So(users.Limit(2).Fetch().Len(), ShouldEqual, 2)
+			})
+			Convey("Limiting per partition on a non stored field or without limit should panic", func() {
+				So(func() { env.Pool("User").SearchAll().LimitPerPartition(1, posts) }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().LimitPerPartition(0, isStaff) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing orders on non stored fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").SearchAll()
+			Convey("Ordering by a non stored computed field should sort in memory", func() {
+				byName := users.OrderBy("Name desc").Fetch()
+				byDecoratedName := users.OrderBy("DecoratedName desc").AllowMemoryOrder(100)
+				So(byDecoratedName.Ids(), ShouldResemble, byName.Ids())
+				So(byDecoratedName.Offset(1).Limit(2).Ids(), ShouldResemble, byName.Ids()[1:3])
+				So(users.OrderBy("DecoratedName").SearchCount(), ShouldEqual, byName.Len())
+			})
+			Convey("Ordering by a non stored field should panic without AllowMemoryOrder", func() {
+				So(func() { users.OrderBy("DecoratedName").Fetch() }, ShouldPanic)
+			})
+			Convey("Ordering in memory more than maxRows records should panic", func() {
+				So(func() { users.OrderBy("DecoratedName").AllowMemoryOrder(1).Fetch() }, ShouldPanic)
+			})
+			Convey("Ordering by a non stored field with an order aggregate should sort in database", func() {
+				commentsCount := env.Pool("Post").Model().FieldName("CommentsCount")
+				posts := env.Pool("Post").SearchAll()
+				expected := posts.Fetch().Sorted(func(rs1, rs2 RecordSet) bool {
+					c1, c2 := rs1.Collection().Get(commentsCount).(int64), rs2.Collection().Get(commentsCount).(int64)
+					if c1 != c2 {
+						return c1 > c2
+					}
+					return rs1.Ids()[0] < rs2.Ids()[0]
+				})
+				So(expected.Records()[0].Get(commentsCount), ShouldBeGreaterThan, 0)
+				So(posts.OrderBy("CommentsCount desc").Ids(), ShouldResemble, expected.Ids())
+				So(posts.OrderBy("CommentsCount desc").Limit(1).Ids(), ShouldResemble, expected.Ids()[:1])
+				page, total := posts.OrderBy("CommentsCount desc").Limit(1).SearchWithCount()
+				So(page.Ids(), ShouldResemble, expected.Ids()[:1])
+				So(total, ShouldEqual, posts.SearchCount())
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestSearchWithCount(t *testing.T) {
+	Convey("Testing searches with count", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").SearchAll().OrderBy("Name")
+			Convey("The page and the total should be fetched together", func() {
+				page, total := users.Limit(2).Offset(1).SearchWithCount()
+				So(total, ShouldEqual, 3)
+				So(page.Ids(), ShouldResemble, users.Limit(2).Offset(1).Fetch().Ids())
+			})
+			Convey("The total should be returned after the last page", func() {
+				page, total := users.Limit(2).Offset(5).SearchWithCount()
+				So(page.IsEmpty(), ShouldBeTrue)
+				So(total, ShouldEqual, 3)
+			})
+			Convey("Distinct on should be applied before counting", func() {
+				page, total := users.DistinctOn(isStaff).Limit(1).SearchWithCount()
+				So(page.Len(), ShouldEqual, 1)
+				So(total, ShouldEqual, 2)
+			})
+			Convey("Memory orders and zero limits should count separately", func() {
+				page, total := users.OrderBy("DecoratedName").AllowMemoryOrder(100).Limit(1).SearchWithCount()
+				So(page.Len(), ShouldEqual, 1)
+				So(total, ShouldEqual, 3)
+				page, total = users.Limit(0).SearchWithCount()
+				So(page.IsEmpty(), ShouldBeTrue)
+				So(total, ShouldEqual, 3)
+			})
+			Convey("Impossible conditions should return no records", func() {
+				page, total := users.Search(users.Model().Field(ID).In([]int64{})).SearchWithCount()
+				So(page.IsEmpty(), ShouldBeTrue)
+				So(total, ShouldEqual, 0)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+// BenchmarkSearchWithCount compares fetching a page of 80 records out of
+// 10000 with its total count in a single query and with a separate count.
+func BenchmarkSearchWithCount(b *testing.B) {
+	for _, combined := range []bool{false, true} {
+		name := "Separate"
+		if combined {
+			name = "Combined"
+		}
+		b.Run(name, func(b *testing.B) {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tagModel := Registry.MustGet("Tag")
+				for i := 0; i < 10000; i++ {
+					env.Pool("Tag").Call("Create", NewModelData(tagModel).
+						Set(Name, fmt.Sprintf("Benchmark Tag %05d", i)))
+				}
+				cond := tagModel.Field(Name).Like("Benchmark Tag %")
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					tags := env.Pool("Tag").Search(cond).Limit(80).Offset(4000)
+					if combined {
+						tags.SearchWithCount()
+						continue
+					}
+					tags.SearchCount()
+					tags.Fetch()
+				}
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRoundedFloatFields(t *testing.T) {
+	Convey("Testing rounding of float fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			john := users.Search(users.Model().Field(Name).Equals("John Smith"))
+			Convey("Values are rounded to the field digits on write", func() {
+				john.Set(size, 1.236)
+				So(john.Get(size), ShouldEqual, 1.24)
+			})
+			Convey("Values are rounded to the field precision usage on write", func() {
+				sizeField := users.Model().Fields().MustGet("Size")
+				sizeField.precision = "User Size"
+				RegisterPrecisionGetter(func(env Environment, usage string) (int8, bool) {
+					if usage == "User Size" {
+						return 1, true
+					}
+					return 0, false
+				})
+				defer func() {
+					sizeField.precision = ""
+					RegisterPrecisionGetter(nil)
+				}()
+				john.Set(size, 1.26)
+				So(john.Get(size), ShouldEqual, 1.3)
+				grouped := users.SearchAll().GroupBy(isStaff).Aggregates(size)
+				for _, line := range grouped {
+					sum := line.Values.Get(size).(float64)
+					So(sum, ShouldEqual, nbutils.Round(sum, 0.1))
+				}
+				sizeField.precision = "Unknown"
+				So(func() { john.Set(size, 1.26) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestWriteOrCreate(t *testing.T) {
+	Convey("Testing WriteOrCreate", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userModel := users.Model()
+			Convey("Existing record matched by a unique field is updated", func() {
+				res := users.CallMulti("WriteOrCreate",
+					NewModelData(userModel).Set(Name, "John Smith"),
+					NewModelData(userModel).Set(nums, 13))
+				john := res[0].(RecordSet).Collection()
+				So(res[1], ShouldBeFalse)
+				So(john.Len(), ShouldEqual, 1)
+				So(john.Get(email), ShouldEqual, "jsmith@example.com")
+				So(john.Get(nums), ShouldEqual, 13)
+			})
+			Convey("Record is created if no record matches", func() {
+				res := users.CallMulti("WriteOrCreate",
+					NewModelData(userModel).Set(Name, "Jack Smith"),
+					NewModelData(userModel).Set(email, "jack@example.com"))
+				jack := res[0].(RecordSet).Collection()
+				So(res[1], ShouldBeTrue)
+				So(jack.Get(Name), ShouldEqual, "Jack Smith")
+				So(jack.Get(email), ShouldEqual, "jack@example.com")
+				So(users.Search(userModel.Field(Name).Equals("Jack Smith")).Len(), ShouldEqual, 1)
+			})
+			Convey("Records can be matched on non unique fields", func() {
+				res := users.CallMulti("WriteOrCreate",
+					NewModelData(userModel).Set(email, "jsmith@example.com").Set(isStaff, true),
+					NewModelData(userModel).Set(nums, 14))
+				So(res[1], ShouldBeFalse)
+				So(res[0].(RecordSet).Collection().Get(Name), ShouldEqual, "John Smith")
+			})
+			Convey("A conflicting record is updated instead of being created", func() {
+				john := users.Search(userModel.Field(Name).Equals("John Smith"))
+				profileModel := Registry.MustGet("Profile")
+				profileCount := env.Pool("Profile").SearchCount()
+				res, created := users.insertOrWrite([]FieldName{Name}, NewModelData(userModel).
+					Set(Name, "John Smith").
+					Set(email, "other@example.com").
+					Create(profile, NewModelData(profileModel).Set(age, 45)))
+				So(created, ShouldBeFalse)
+				So(res.Ids(), ShouldResemble, john.Ids())
+				So(john.ForceLoad(email).Get(email), ShouldEqual, "other@example.com")
+				So(users.Search(userModel.Field(Name).Equals("John Smith")).SearchCount(), ShouldEqual, 1)
+				Convey("Records created for its FK fields are linked to it", func() {
+					So(env.Pool("Profile").SearchCount(), ShouldEqual, profileCount+1)
+					So(john.Get(profile).(RecordSet).Collection().Get(age), ShouldEqual, 45)
+				})
+			})
+			Convey("Records to create cannot be matched", func() {
+				profileModel := Registry.MustGet("Profile")
+				profileCount := env.Pool("Profile").SearchCount()
+				So(func() {
+					users.CallMulti("WriteOrCreate",
+						NewModelData(userModel).Set(Name, "John Smith").
+							Create(profile, NewModelData(profileModel).Set(age, 45)),
+						NewModelData(userModel).Set(nums, 15))
+				}, ShouldPanic)
+				So(env.Pool("Profile").SearchCount(), ShouldEqual, profileCount)
+			})
+			Convey("Unique constraints are detected", func() {
+				So(userModel.hasUniqueConstraintOn([]FieldName{Name}), ShouldBeTrue)
+				So(userModel.hasUniqueConstraintOn([]FieldName{email}), ShouldBeFalse)
+				So(userModel.hasUniqueConstraintOn([]FieldName{Name, email}), ShouldBeFalse)
+			})
+			Convey("Matching several records should panic", func() {
+				So(func() {
+					users.CallMulti("WriteOrCreate", NewModelData(userModel).Set(isStaff, true), NewModelData(userModel))
+				}, ShouldPanic)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -752,7 +2023,7 @@ func TestUpdateRecordSet(t *testing.T) {
 			userModel := Registry.MustGet("User")
 			userWill := env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("will.smith@example.com"))
 			userWill.Call("Write", NewModelData(userModel).Set(nums, 0).Set(isPremium, true))
-		}).Error(), ShouldStartWith, "pq: Premium users must have positive nums")
+		}).Error(), ShouldStartWith, "Premium users must have positive nums")
 	})
 
 	group1 := security.Registry.NewGroup("group1", "Group 1")
@@ -836,6 +2107,137 @@ func TestUpdateRecordSet(t *testing.T) {
 	security.Registry.UnregisterGroup(group1)
 }
 
+func TestCheckCompany(t *testing.T) {
+	Convey("Testing company consistency checks", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			companyModel := Registry.MustGet("Company")
+			projectModel := Registry.MustGet("Project")
+			userModel := Registry.MustGet("User")
+			company := projectModel.FieldName("Company")
+			parent := projectModel.FieldName("Parent")
+			newCompany := func(name string) *RecordCollection {
+				return env.Pool("Company").Call("Create", NewModelData(companyModel).
+					Set(Name, name)).(RecordSet).Collection()
+			}
+			companyA := newCompany("Company A")
+			companyB := newCompany("Company B")
+			newProject := func(rc *RecordCollection, name string, comp, par RecordSet) *RecordCollection {
+				return rc.Call("Create", NewModelData(projectModel).
+					Set(Name, name).
+					Set(company, comp).
+					Set(parent, par)).(RecordSet).Collection()
+			}
+			projects := env.Pool("Project")
+			projectA := newProject(projects, "Project A", companyA, projects)
+			projectB := newProject(projects, "Project B", companyB, projects)
+			shared := newProject(projects, "Shared Project", env.Pool("Company"), projects)
+			Convey("Related records must be shared or of the same company", func() {
+				So(func() { newProject(projects, "Child A", companyA, projectA) }, ShouldNotPanic)
+				So(func() { newProject(projects, "Child Shared", companyA, shared) }, ShouldNotPanic)
+				So(func() { newProject(projects, "Child B", companyA, projectB) }, ShouldPanic)
+				child := newProject(projects, "Child", companyA, projectA)
+				So(func() { child.Set(parent, projectB) }, ShouldPanic)
+				So(func() { child.Set(company, companyB) }, ShouldPanic)
+				So(func() { child.Set(parent, shared) }, ShouldNotPanic)
+			})
+			Convey("Records without company can only be linked to the allowed companies", func() {
+				allowedA := projects.WithContext("allowed_company_ids", []int64{companyA.Ids()[0]})
+				So(func() { newProject(allowedA, "Orphan A", env.Pool("Company"), projectA) }, ShouldNotPanic)
+				So(func() { newProject(allowedA, "Orphan B", env.Pool("Company"), projectB) }, ShouldPanic)
+			})
+			Convey("The company of the user is allowed when there are no allowed companies", func() {
+				user := env.Pool("User").Call("Create", NewModelData(userModel).
+					Set(Name, "Company User").
+					Set(email, "company.user@example.com").
+					Set(userModel.FieldName("Company"), companyB)).(RecordSet).Collection()
+				userProjects := projects.Sudo(user.Ids()[0])
+				So(userProjects.allowedCompanies(), ShouldResemble, companyB.Ids())
+				So(func() { newProject(userProjects, "User Orphan B", env.Pool("Company"), projectB) }, ShouldNotPanic)
+				So(func() { newProject(userProjects, "User Orphan A", env.Pool("Company"), projectA) }, ShouldPanic)
+				orphan := newProject(projects.Sudo(user.Ids()[0]), "User Orphan", env.Pool("Company"), shared)
+				So(func() { orphan.Set(parent, projectA) }, ShouldPanic)
+				So(func() { orphan.Set(parent, projectB) }, ShouldNotPanic)
+			})
+			Convey("The companies of the user are read once and companies are checked in sudo mode", func() {
+				user := env.Pool("User").Call("Create", NewModelData(userModel).
+					Set(Name, "Restricted User").
+					Set(email, "restricted.user@example.com").
+					Set(userModel.FieldName("Company"), companyB)).(RecordSet).Collection()
+				userProjects := projects.Sudo(user.Ids()[0])
+				So(userProjects.allowedCompanies(), ShouldResemble, companyB.Ids())
+				count := env.Cr().QueryCount()
+				So(userProjects.allowedCompanies(), ShouldResemble, companyB.Ids())
+				So(env.Cr().QueryCount(), ShouldEqual, count)
+				rule := RecordRule{
+					Name:      "hideCompanyA",
+					Global:    true,
+					Condition: companyModel.Field(Name).NotEquals("Company A"),
+					Perms:     security.Read,
+				}
+				companyModel.AddRecordRule(&rule)
+				defer companyModel.RemoveRecordRule("hideCompanyA")
+				So(func() { newProject(userProjects, "Hidden Company Child", env.Pool("Company"), projectA) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestStoredRelatedFields(t *testing.T) {
+	Convey("Testing stored related fields propagation", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			postModel := Registry.MustGet("Post")
+			commentModel := Registry.MustGet("Comment")
+			post := commentModel.FieldName("Post")
+			storedEmail := func(table string, rc *RecordCollection) string {
+				var res string
+				env.Cr().Get(&res, "SELECT COALESCE(writer_email, '') FROM "+table+" WHERE id = ?", rc.Ids()[0])
+				return res
+			}
+			userA := env.Pool("User").Call("Create", NewModelData(userModel).
+				Set(Name, "Stored Related A").
+				Set(email, "a@example.com")).(RecordSet).Collection()
+			userB := env.Pool("User").Call("Create", NewModelData(userModel).
+				Set(Name, "Stored Related B").
+				Set(email, "b@example.com")).(RecordSet).Collection()
+			post1 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Stored Related Post 1").
+				Set(user, userA)).(RecordSet).Collection()
+			post2 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Stored Related Post 2").
+				Set(user, userA)).(RecordSet).Collection()
+			comment1 := env.Pool("Comment").Call("Create", NewModelData(commentModel).
+				Set(post, post1).
+				Set(text, "Stored Related Comment")).(RecordSet).Collection()
+			Convey("Stored related fields are set on creation", func() {
+				So(storedEmail("post", post1), ShouldEqual, "a@example.com")
+				So(storedEmail("post", post2), ShouldEqual, "a@example.com")
+				So(storedEmail("comment", comment1), ShouldEqual, "a@example.com")
+			})
+			Convey("Writing the target field updates one hop and two hops stored related fields", func() {
+				userA.Set(email, "a2@example.com")
+				So(storedEmail("post", post1), ShouldEqual, "a2@example.com")
+				So(storedEmail("post", post2), ShouldEqual, "a2@example.com")
+				So(storedEmail("comment", comment1), ShouldEqual, "a2@example.com")
+				userB.Set(email, "b2@example.com")
+				So(storedEmail("post", post1), ShouldEqual, "a2@example.com")
+			})
+			Convey("Changing the middle relation updates stored related fields", func() {
+				post1.Set(user, userB)
+				So(storedEmail("post", post1), ShouldEqual, "b@example.com")
+				So(storedEmail("post", post2), ShouldEqual, "a@example.com")
+				So(storedEmail("comment", comment1), ShouldEqual, "b@example.com")
+				post2.Set(user, env.Pool("User"))
+				So(storedEmail("post", post2), ShouldBeBlank)
+			})
+			Convey("Changing the first relation updates stored related fields", func() {
+				comment1.Set(post, post2)
+				So(storedEmail("comment", comment1), ShouldEqual, "a@example.com")
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestDeleteRecordSet(t *testing.T) {
 	Convey("Checking unlink method", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
@@ -877,6 +2279,26 @@ func TestDeleteRecordSet(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Checking batched unlink", t, func() {
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			for _, name := range []string{"Batch Tag A", "Batch Tag B", "Batch Tag C", "Batch Tag D", "Batch Tag E"} {
+				env.Pool("Tag").Call("Create", NewModelData(env.Pool("Tag").model).Set(Name, name))
+			}
+		}), ShouldBeNil)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tags := env.Pool("Tag").Search(env.Pool("Tag").Model().Field(Name).Like("Batch Tag %"))
+			So(tags.Len(), ShouldEqual, 5)
+			var progress [][2]int64
+			num := tags.Call("UnlinkBatched", 2, func(deleted, total int64) {
+				progress = append(progress, [2]int64{deleted, total})
+			})
+			So(num, ShouldEqual, 5)
+			So(progress, ShouldResemble, [][2]int64{{2, 5}, {4, 5}, {5, 5}})
+		}), ShouldBeNil)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			So(env.Pool("Tag").Search(env.Pool("Tag").Model().Field(Name).Like("Batch Tag %")).SearchCount(), ShouldEqual, 0)
+		}), ShouldBeNil)
+	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	security.Registry.AddMembership(2, group1)
 	Convey("Checking unlink access permissions", t, func() {
@@ -935,3 +2357,72 @@ func TestDeleteRecordSet(t *testing.T) {
 	})
 	security.Registry.UnregisterGroup(group1)
 }
+
+func TestJSONContains(t *testing.T) {
+	Convey("Testing JSON containment conditions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			newPost := func(name, lbls string) *RecordCollection {
+				return postModel.Create(env, NewModelData(postModel).
+					Set(title, name).
+					Set(content, "Content").
+					Set(labels, lbls))
+			}
+			admin := newPost("Admin Post", `["admin", "editor", 3]`)
+			editor := newPost("Editor Post", `["editor"]`)
+			owned := newPost("Owned Post", `{"owner": {"team": "sales", "level": 2}, "tags": ["urgent"]}`)
+			newPost("Unlabelled Post", "")
+			search := func(cond *Condition) []int64 {
+				return env.Pool("Post").Search(cond).OrderBy("ID").Ids()
+			}
+			Convey("A GIN index is created on the field", func() {
+				So(adapters[db.DriverName()].indexExists("post", "post_labels_json_index"), ShouldBeTrue)
+			})
+			Convey("Array elements are matched", func() {
+				So(search(postModel.Field(labels).JSONContains("admin")), ShouldResemble, admin.Ids())
+				So(search(postModel.Field(labels).JSONContains("editor")), ShouldResemble, append(admin.Ids(), editor.Ids()...))
+				So(search(postModel.Field(labels).JSONContains(3)), ShouldResemble, admin.Ids())
+				So(search(postModel.Field(labels).JSONContains([]string{"editor", "admin"})), ShouldResemble, admin.Ids())
+				So(search(postModel.Field(labels).JSONContains("viewer")), ShouldBeEmpty)
+			})
+			Convey("Nested keys are matched", func() {
+				So(search(postModel.Field(labels).JSONPathContains("owner.team", "sales")), ShouldResemble, owned.Ids())
+				So(search(postModel.Field(labels).JSONPathContains("owner.level", 2)), ShouldResemble, owned.Ids())
+				So(search(postModel.Field(labels).JSONPathContains("owner.team", "support")), ShouldBeEmpty)
+				So(search(postModel.Field(labels).JSONContains(map[string]interface{}{"tags": []string{"urgent"}})),
+					ShouldResemble, owned.Ids())
+			})
+			Convey("Domains with the json_contains operator are validated", func() {
+				posts := env.Pool("Post")
+				So(posts.ValidateDomain(postModel.Field(labels).AddOperator(operator.JSONContains, "admin")), ShouldBeNil)
+				err := posts.ValidateDomain(postModel.Field(postModel.FieldName("Visibility")).AddOperator(operator.JSONContains, "admin"))
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				err = posts.ValidateDomain(postModel.Field(title).AddOperator(operator.JSONContains, "admin"))
+				So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				So(func() {
+					posts.Search(postModel.Field(postModel.FieldName("Attachment")).JSONContains("admin")).Fetch()
+				}, ShouldPanic)
+			})
+			Convey("Values which are not valid JSON are rejected", func() {
+				So(func() { newPost("Broken Post", "admin, editor") }, ShouldPanic)
+				So(func() { editor.Set(labels, `{"owner": `) }, ShouldPanic)
+				So(editor.Get(labels), ShouldEqual, `["editor"]`)
+				editor.Set(labels, "")
+				So(search(postModel.Field(labels).JSONContains("editor")), ShouldResemble, admin.Ids())
+			})
+			Convey("Records can be filtered in memory with JSONContains", func() {
+				filtered := env.Pool("Post").SearchAll().Filtered(func(rs RecordSet) bool {
+					return JSONContains(rs.Collection().Get(labels).(string), "editor")
+				})
+				So(filtered.OrderBy("ID").Ids(), ShouldResemble, append(admin.Ids(), editor.Ids()...))
+				So(JSONContains(`["admin", "editor", 3]`, []interface{}{3.0, "admin"}), ShouldBeTrue)
+				So(JSONContains(`{"owner": {"team": "sales", "level": 2}}`, map[string]interface{}{"owner": map[string]int{"level": 2}}),
+					ShouldBeTrue)
+				So(JSONContains(`{"tags": ["urgent"]}`, map[string]string{"tags": "urgent"}), ShouldBeFalse)
+				So(JSONContains(`"admin"`, "admin"), ShouldBeTrue)
+				So(JSONContains("", "admin"), ShouldBeFalse)
+				So(JSONContains("Some text", "admin"), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
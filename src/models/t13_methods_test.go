@@ -15,10 +15,14 @@
 package models
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -135,6 +139,13 @@ func TestComputedStoredFields(t *testing.T) {
 				userWill.Load()
 				So(userWill.Get(age), ShouldEqual, 34)
 			})
+			Convey("Checking that values are converted to the inverse method's argument type", func() {
+				userWill := users.Search(users.Model().Field(email).Equals("will.smith@example.com"))
+				userWill.Set(age, 35)
+				So(userWill.Get(age), ShouldEqual, 35)
+				userWill.Call("Write", NewModelData(users.Model()).Set(age, 34.0))
+				So(userWill.Get(age), ShouldEqual, 34)
+			})
 			Convey("Checking that unlinking a record recomputes their dependencies", func() {
 				userWill := users.Search(users.Model().Field(email).Equals("will.smith@example.com"))
 				userWill.Get(profile).(RecordSet).Collection().Call("Unlink")
@@ -165,6 +176,134 @@ func TestComputedStoredFields(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing stored computed fields backfilling", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.Cr().Execute(`UPDATE "user" SET age = 0`)
+			env.Cr().Execute(`UPDATE "post" SET writer_age = 0, writer_email = NULL`)
+			batchSize := RecomputeBatchSize
+			RecomputeBatchSize = 1
+			defer func() { RecomputeBatchSize = batchSize }()
+			inEnv := func(fnct func(Environment)) error {
+				fnct(env)
+				return nil
+			}
+			recomputeFields(append(storedFieldsToRecompute("User", "Age"), storedFieldsToRecompute("Post", "WriterEmail")...), inEnv)
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			So(jane.Get(age), ShouldEqual, 24)
+			janePost := jane.Get(posts).(RecordSet).Collection().Records()[0]
+			So(janePost.Get(writerAge), ShouldEqual, 24)
+			var writerEmail string
+			env.Cr().Get(&writerEmail, `SELECT COALESCE(writer_email, '') FROM "post" WHERE id = ?`, janePost.Ids()[0])
+			So(writerEmail, ShouldEqual, "jane.smith@example.com")
+			userWill := users.Search(users.Model().Field(email).Equals("will.smith@example.com"))
+			So(userWill.Get(age), ShouldEqual, 36)
+		}), ShouldBeNil)
+		So(func() { storedFieldsToRecompute("User", "Name") }, ShouldPanic)
+		So(func() { storedFieldsToRecompute("", "Age") }, ShouldPanic)
+		So(storedFieldsToRecompute("Post"), ShouldContain, Registry.MustGet("Post").fields.MustGet("WriterEmail"))
+		So(func() { RecomputeStoredFields("User") }, ShouldNotPanic)
+	})
+	Convey("Testing deferred recompute of stored computed fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			computes := new(int)
+			env.context = env.context.Copy().WithKey("writer_age_computes", computes)
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			janeProfile := jane.Get(profile).(RecordSet).Collection()
+			janePosts := jane.Get(posts).(RecordSet).Collection()
+			nPosts := janePosts.Len()
+			So(nPosts, ShouldBeGreaterThan, 0)
+			Convey("Recomputes are executed after each write without deferring", func() {
+				*computes = 0
+				for _, a := range []int16{30, 31, 32} {
+					janeProfile.Set(age, a)
+				}
+				So(*computes, ShouldEqual, 3*nPosts)
+				So(janePosts.Records()[0].Get(writerAge), ShouldEqual, 32)
+			})
+			Convey("Deferred recomputes are coalesced with identical results", func() {
+				*computes = 0
+				env.WithDeferredRecompute(func(env Environment) {
+					for _, a := range []int16{30, 31, 32} {
+						janeProfile.WithEnv(env).Set(age, a)
+					}
+					So(*computes, ShouldEqual, 0)
+				})
+				So(*computes, ShouldEqual, nPosts)
+				So(jane.Get(age), ShouldEqual, 32)
+				for _, post := range janePosts.Records() {
+					So(post.Get(writerAge), ShouldEqual, 32)
+				}
+			})
+			Convey("Environments used after the deferred scope recompute directly", func() {
+				var escaped *RecordCollection
+				env.WithDeferredRecompute(func(env Environment) {
+					escaped = janeProfile.WithEnv(env)
+					escaped.Set(age, 30)
+				})
+				*computes = 0
+				escaped.Set(age, 40)
+				So(*computes, ShouldEqual, nPosts)
+				for _, post := range janePosts.Records() {
+					So(post.Get(writerAge), ShouldEqual, 40)
+				}
+				*computes = 0
+				env.WithDeferredRecompute(func(env Environment) {
+					janeProfile.WithEnv(env).Set(age, 41)
+					So(*computes, ShouldEqual, 0)
+				})
+				So(*computes, ShouldEqual, nPosts)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+// BenchmarkDeferredRecompute compares the compute calls of a loop writing
+// 10000 records one by one, with and without deferred recompute.
+func BenchmarkDeferredRecompute(b *testing.B) {
+	for _, deferred := range []bool{false, true} {
+		name := "Immediate"
+		if deferred {
+			name = "Deferred"
+		}
+		b.Run(name, func(b *testing.B) {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				computes := new(int)
+				env.context = env.context.Copy().WithKey("writer_age_computes", computes)
+				users := env.Pool("User")
+				postModel := Registry.MustGet("Post")
+				jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+				will := users.Search(users.Model().Field(email).Equals("will.smith@example.com"))
+				postRecs := make([]*RecordCollection, 10000)
+				for i := range postRecs {
+					postRecs[i] = env.Pool("Post").Call("Create", NewModelData(postModel).
+						Set(title, fmt.Sprintf("Benchmark Post %d", i)).
+						Set(user, jane)).(RecordSet).Collection()
+				}
+				loop := func(env Environment) {
+					for _, post := range postRecs {
+						post.WithEnv(env).Set(user, will)
+						post.WithEnv(env).Set(user, jane)
+					}
+				}
+				*computes = 0
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if deferred {
+						env.WithDeferredRecompute(loop)
+						continue
+					}
+					loop(env)
+				}
+				b.StopTimer()
+				b.ReportMetric(float64(*computes)/float64(b.N), "computes/op")
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
 }
 
 func TestRelatedNonStoredFields(t *testing.T) {
@@ -264,6 +403,37 @@ func TestEmbeddedModels(t *testing.T) {
 				So(userJane.Get(resume).(RecordSet).Collection().Get(leisure), ShouldEqual, "Music, Sports")
 				So(userJane.Get(resume).(RecordSet).Collection().Get(education), ShouldEqual, "MIT")
 			})
+			Convey("Searching users on the fields of their resume", func() {
+				res := users.Search(users.Model().Field(experience).Contains("Hexya developer"))
+				So(res.Equals(userJane), ShouldBeTrue)
+				res = users.Search(users.Model().Field(leisure).Equals("Music, Sports").And().Field(email).IContains("jane"))
+				So(res.Equals(userJane), ShouldBeTrue)
+				So(users.Search(users.Model().Field(education).Equals("MIT")).IsEmpty(), ShouldBeTrue)
+				So(users.Search(users.Model().Field(education).Equals("Berkeley")).Equals(userJane), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing writes through embedded models", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userBob := users.Call("Create", NewModelData(users.model).
+				Set(Name, "Bob Embedded").
+				Set(leisure, "Chess").
+				Set(education, "Harvard")).(RecordSet).Collection()
+			bobResume := userBob.Get(resume).(RecordSet).Collection()
+			So(bobResume.IsEmpty(), ShouldBeFalse)
+			So(bobResume.Get(leisure), ShouldEqual, "Chess")
+			So(bobResume.Get(education), ShouldEqual, "")
+			So(bobResume.Get(other), ShouldEqual, "Other information")
+			So(userBob.Get(education), ShouldEqual, "Harvard")
+
+			userBob.Call("Write", NewModelData(users.model).
+				Set(Name, "Bob Delegated").
+				Set(leisure, "Go, Chess"))
+			So(userBob.Get(Name), ShouldEqual, "Bob Delegated")
+			So(userBob.Get(leisure), ShouldEqual, "Go, Chess")
+			So(userBob.Get(resume).(RecordSet).Collection().Equals(bobResume), ShouldBeTrue)
+			So(env.Pool("Resume").Search(env.Pool("Resume").Model().Field(leisure).Equals("Go, Chess")).Equals(bobResume), ShouldBeTrue)
 		}), ShouldBeNil)
 	})
 }
@@ -333,6 +503,11 @@ func TestContextedFields(t *testing.T) {
 				So(tagc.WithContext("lang", "de_DE").Get(description), ShouldEqual, "übersetzte Beschreibung")
 				So(tagc.WithContext("lang", "es_ES").Get(description), ShouldEqual, "descripción traducida")
 				So(tagc.WithContext("lang", "it_IT").Get(description), ShouldEqual, "Translated description")
+
+				descCond := mTags.Model().Field(description).Equals("Nouvelle traduction")
+				So(mTags.WithContext("lang", "fr_FR").Search(descCond).Len(), ShouldEqual, 1)
+				So(mTags.WithContext("lang", "it_IT").Search(descCond).Len(), ShouldEqual, 0)
+				So(mTags.WithContext("lang", "it_IT").Search(mTags.Model().Field(description).Equals("Translated description")).Len(), ShouldEqual, 1)
 			})
 			Convey("Creating a record with a contexted field should also create for default context", func() {
 				mTags.WithContext("lang", "fr_FR").Call("Create", NewModelData(mTags.model).
@@ -388,6 +563,165 @@ func TestContextedFields(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing search on related contexted fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mTags := env.Pool("Tag")
+			mPosts := env.Pool("Post")
+			tag := mTags.Call("Create", NewModelData(mTags.model).
+				Set(Name, "Searched contexted tag").
+				Set(description, "Base description")).(RecordSet).Collection()
+			tag.WithContext("lang", "fr_FR").Set(description, "Description française")
+			tag.WithContext("lang", "de_DE").Set(description, "Deutsche Beschreibung")
+			mPosts.Call("Create", NewModelData(mPosts.model).
+				Set(title, "Post with contexted tag").
+				Set(content, "Content").
+				Set(tags, tag))
+			tagsDesc := mPosts.Model().FieldName("Tags.Description")
+			Convey("Related contexted fields should match the current context translation", func() {
+				cond := mPosts.Model().Field(tagsDesc).IContains("française")
+				So(mPosts.WithContext("lang", "fr_FR").Search(cond).Len(), ShouldEqual, 1)
+				So(mPosts.WithContext("lang", "de_DE").Search(cond).Len(), ShouldEqual, 0)
+				So(mPosts.WithContext("lang", "de_DE").Search(mPosts.Model().Field(tagsDesc).IContains("deutsche")).Len(), ShouldEqual, 1)
+			})
+			Convey("Related contexted fields should match the default value in all contexts", func() {
+				cond := mPosts.Model().Field(tagsDesc).IContains("base")
+				So(mPosts.WithContext("lang", "fr_FR").Search(cond).Len(), ShouldEqual, 1)
+				So(mPosts.WithContext("lang", "it_IT").Search(cond).Len(), ShouldEqual, 1)
+				So(mPosts.Search(cond).Len(), ShouldEqual, 1)
+			})
+			Convey("Quantified conditions should match the current context translation", func() {
+				cond := mPosts.Model().AnyOf(tags, mTags.Model().Field(description).IContains("française"))
+				So(mPosts.WithContext("lang", "fr_FR").Search(cond).Len(), ShouldEqual, 1)
+				So(mPosts.WithContext("lang", "de_DE").Search(cond).Len(), ShouldEqual, 0)
+			})
+			Convey("Search on related contexted fields should work with pagination", func() {
+				cond := mPosts.Model().Field(tagsDesc).IContains("française").
+					Or().Field(title).Equals("1st Post")
+				posts := mPosts.WithContext("lang", "fr_FR").Search(cond).OrderBy("Title")
+				So(posts.SearchCount(), ShouldEqual, 2)
+				So(posts.Limit(1).Len(), ShouldEqual, 1)
+				So(posts.Limit(1).Offset(1).Get(title), ShouldEqual, "Post with contexted tag")
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing line numbering", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mInvoices := env.Pool("Invoice")
+			mLines := env.Pool("InvoiceLine")
+			lineNumber := mLines.model.FieldName("LineNumber")
+			invoice := mLines.model.FieldName("Invoice")
+			invoice1 := mInvoices.Call("Create", NewModelData(mInvoices.model).
+				Set(Name, "Invoice with numbered lines")).(RecordSet).Collection()
+			invoice2 := mInvoices.Call("Create", NewModelData(mInvoices.model).
+				Set(Name, "Other invoice with numbered lines")).(RecordSet).Collection()
+			var lines []*RecordCollection
+			for _, txt := range []string{"A", "B", "C", "D"} {
+				lines = append(lines, mLines.Call("Create", NewModelData(mLines.model).
+					Set(invoice, invoice1).
+					Set(text, txt)).(RecordSet).Collection())
+			}
+			numbers := func(i *RecordCollection) []int {
+				var res []int
+				for _, l := range mLines.Search(mLines.Model().Field(invoice).Equals(i)).Records() {
+					res = append(res, l.Get(lineNumber).(int))
+				}
+				return res
+			}
+			Convey("Lines are numbered in their order at creation", func() {
+				So(numbers(invoice1), ShouldResemble, []int{1, 2, 3, 4})
+				So(lines[3].Get(lineNumber), ShouldEqual, 4)
+			})
+			Convey("Lines are renumbered when a line is deleted", func() {
+				lines[1].Call("Unlink")
+				So(numbers(invoice1), ShouldResemble, []int{1, 2, 3})
+				So(lines[2].Get(lineNumber), ShouldEqual, 2)
+			})
+			Convey("Lines are renumbered when moved to another parent", func() {
+				lines[0].Set(invoice, invoice2)
+				So(numbers(invoice1), ShouldResemble, []int{1, 2, 3})
+				So(numbers(invoice2), ShouldResemble, []int{1})
+				So(lines[1].Get(lineNumber), ShouldEqual, 1)
+			})
+			Convey("Lines are numbered when created through their parent", func() {
+				invoice2.Call("Write", NewModelData(mInvoices.model).
+					Create(mInvoices.model.FieldName("Lines"), NewModelData(mLines.model).Set(text, "E")).
+					Create(mInvoices.model.FieldName("Lines"), NewModelData(mLines.model).Set(text, "F")))
+				So(numbers(invoice2), ShouldResemble, []int{1, 2})
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing parent touching", t, func() {
+		start := time.Date(2020, 3, 14, 15, 0, 0, 0, time.UTC)
+		clock := dates.NewFakeClock(start)
+		dates.SetClock(clock)
+		defer dates.SetClock(nil)
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mInvoices := env.Pool("Invoice")
+			mLines := env.Pool("InvoiceLine")
+			invoice := mLines.model.FieldName("Invoice")
+			invoice1 := mInvoices.Call("Create", NewModelData(mInvoices.model).
+				Set(Name, "Invoice with touching lines")).(RecordSet).Collection()
+			invoice2 := mInvoices.Call("Create", NewModelData(mInvoices.model).
+				Set(Name, "Other invoice with touching lines")).(RecordSet).Collection()
+			touchedAt := func(p *RecordCollection) time.Duration {
+				return p.Get(writeDate).(dates.DateTime).Sub(dates.DateTime{Time: start})
+			}
+			clock.Advance(time.Hour)
+			line := mLines.Call("Create", NewModelData(mLines.model).
+				Set(invoice, invoice1).
+				Set(text, "A")).(RecordSet).Collection()
+			So(touchedAt(invoice1), ShouldEqual, time.Hour)
+			So(touchedAt(invoice2), ShouldBeLessThan, time.Hour)
+			clock.Advance(time.Hour)
+			line.Set(text, "B")
+			So(touchedAt(invoice1), ShouldEqual, 2*time.Hour)
+			changed := mInvoices.Search(mInvoices.Model().Field(writeDate).Greater(dates.DateTime{Time: start.Add(90 * time.Minute)}))
+			So(changed.Ids(), ShouldContain, invoice1.Ids()[0])
+			So(changed.Ids(), ShouldNotContain, invoice2.Ids()[0])
+			clock.Advance(time.Hour)
+			line.Set(invoice, invoice2)
+			So(touchedAt(invoice1), ShouldEqual, 3*time.Hour)
+			So(touchedAt(invoice2), ShouldEqual, 3*time.Hour)
+			clock.Advance(time.Hour)
+			line.Call("Unlink")
+			So(touchedAt(invoice1), ShouldEqual, 3*time.Hour)
+			So(touchedAt(invoice2), ShouldEqual, 4*time.Hour)
+			So(invoice2.Get(writeUID).(RecordSet).Collection().Ids(), ShouldResemble, []int64{security.SuperUserID})
+		}), ShouldBeNil)
+	})
+	Convey("Testing accent insensitive search", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mProfiles := env.Pool("Profile")
+			profile := mProfiles.Call("Create", NewModelData(mProfiles.model).
+				Set(city, "Montréal")).(RecordSet).Collection()
+			for _, search := range []string{"montreal", "MONTRÉAL", "tréal"} {
+				res := mProfiles.Search(mProfiles.Model().Field(city).IContains(search))
+				So(res.Len(), ShouldEqual, 1)
+				So(res.Equals(profile), ShouldBeTrue)
+			}
+			res := mProfiles.Search(mProfiles.Model().Field(city).ILike("montreal"))
+			So(res.Equals(profile), ShouldBeTrue)
+			res = mProfiles.Search(mProfiles.Model().Field(city).Contains("montreal"))
+			So(res.IsEmpty(), ShouldBeTrue)
+			So(adapters[db.DriverName()].indexExists("profile", "profile_city_unaccent_index"), ShouldBeTrue)
+		}), ShouldBeNil)
+	})
+	Convey("Testing similarity search", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			mProfiles := env.Pool("Profile")
+			germany := mProfiles.Call("Create", NewModelData(mProfiles.model).
+				Set(country, "Germany")).(RecordSet).Collection()
+			germania := mProfiles.Call("Create", NewModelData(mProfiles.model).
+				Set(country, "Germania")).(RecordSet).Collection()
+			mProfiles.Call("Create", NewModelData(mProfiles.model).Set(country, "Norway"))
+			res := mProfiles.Search(mProfiles.Model().Field(country).Similar("Germny")).
+				OrderBySimilarity(country, "Germny")
+			So(res.Len(), ShouldEqual, 2)
+			So(res.Records()[0].Equals(germany), ShouldBeTrue)
+			So(res.Records()[1].Equals(germania), ShouldBeTrue)
+			So(adapters[db.DriverName()].indexExists("profile", "profile_country_trgm_index"), ShouldBeTrue)
+		}), ShouldBeNil)
+	})
 	Convey("Testing contexted group by queries", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
 			mTags := env.Pool("Tag")
@@ -527,3 +861,50 @@ func TestInvalidRecordSets(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestDependencyGraph(t *testing.T) {
+	Convey("Testing the dependency graph of stored fields", t, func() {
+		graph := DependencyGraph()
+		deps := make(map[string]FieldDependencies)
+		for _, fd := range graph {
+			deps[fd.Model+"."+fd.Field] = fd
+		}
+		Convey("Stored computed fields list what triggers them and what they trigger", func() {
+			age, ok := deps["User.Age"]
+			So(ok, ShouldBeTrue)
+			So(age.Related, ShouldBeFalse)
+			So(age.TriggeredBy, ShouldContain, FieldDependency{Model: "Profile", Field: "Age", Path: "Profile"})
+			So(age.TriggeredBy, ShouldContain, FieldDependency{Model: "User", Field: "Profile"})
+			So(age.Triggers, ShouldContain, FieldDependency{Model: "Post", Field: "WriterAge", Path: "User"})
+			So(age.InCycle, ShouldBeFalse)
+			So(deps["Post.WriterAge"].TriggeredBy, ShouldContain, FieldDependency{Model: "User", Field: "Age", Path: "User"})
+		})
+		Convey("Non stored computed fields are not part of the graph", func() {
+			_, ok := deps["User.DecoratedName"]
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Fields are sorted by model and field", func() {
+			So(sort.SliceIsSorted(graph, func(i, j int) bool {
+				return graph[i].Model < graph[j].Model || (graph[i].Model == graph[j].Model && graph[i].Field < graph[j].Field)
+			}), ShouldBeTrue)
+		})
+		Convey("Cycles are detected", func() {
+			a := dependencyNode{model: "A", field: "X"}
+			b := dependencyNode{model: "B", field: "Y"}
+			c := dependencyNode{model: "C", field: "Z"}
+			edges := map[dependencyNode][]dependencyNode{a: {b}, b: {c, a}}
+			So(dependencyReaches(edges, a, a, make(map[dependencyNode]bool)), ShouldBeTrue)
+			So(dependencyReaches(edges, c, c, make(map[dependencyNode]bool)), ShouldBeFalse)
+		})
+		Convey("Dependencies through many relations or x2many fields are expensive", func() {
+			path, expensive := dependencyPath(Registry.MustGet("Post"), "user_id")
+			So(path, ShouldEqual, "User")
+			So(expensive, ShouldBeFalse)
+			path, expensive = dependencyPath(Registry.MustGet("Post"), "user_id.profile_id")
+			So(path, ShouldEqual, "User.Profile")
+			So(expensive, ShouldBeTrue)
+			_, expensive = dependencyPath(Registry.MustGet("User"), "posts_ids")
+			So(expensive, ShouldBeTrue)
+		})
+	})
+}
@@ -15,10 +15,13 @@
 package models
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -111,6 +114,19 @@ func TestEnvironment(t *testing.T) {
 				So(janeEntry["email"], ShouldEqual, "jane.smith@example.com")
 				So(env.cache.checkIfInCache(users.model, userJane.ids, []string{"id", "name", "email"}, "", false), ShouldBeTrue)
 			})
+			Convey("Loading a RecordSet with OnlyFields should only load the given fields", func() {
+				allUsers := users.SearchAll().OnlyFields(Name)
+				So(allUsers.Len(), ShouldBeGreaterThan, 1)
+				records := allUsers.Records()
+				So(records[0].Get(Name), ShouldNotBeEmpty)
+				So(env.cache.checkIfInCache(users.model, allUsers.ids, []string{"id", "name"}, "", false), ShouldBeTrue)
+				So(env.cache.checkIfInCache(users.model, allUsers.ids, []string{"email"}, "", false), ShouldBeFalse)
+				records[1].Get(email)
+				So(env.cache.checkIfInCache(users.model, allUsers.ids, []string{"email"}, "", false), ShouldBeTrue)
+				So(env.cache.checkIfInCache(users.model, allUsers.ids, []string{"nums"}, "", false), ShouldBeFalse)
+				_, dbCalled := records[2%len(records)].get(Name, true)
+				So(dbCalled, ShouldBeFalse)
+			})
 			Convey("Calling values already in cache should not call the DB", func() {
 				userJane.Load()
 				id, dbCalled := userJane.get(ID, true)
@@ -258,6 +274,24 @@ X2M Links
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing row locks", t, func() {
+		Convey("The read only state is queried once per transaction", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User").SearchAll()
+				start := env.Cr().QueryCount()
+				So(users.ForUpdate().Fetch().IsNotEmpty(), ShouldBeTrue)
+				first := env.Cr().QueryCount() - start
+				So(users.ForUpdate().Fetch().IsNotEmpty(), ShouldBeTrue)
+				So(env.Cr().QueryCount()-start-first, ShouldEqual, first-1)
+			}), ShouldBeNil)
+		})
+		Convey("Records cannot be locked in read only transactions", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Cr().Execute("SET TRANSACTION READ ONLY")
+				So(func() { env.Pool("User").SearchAll().ForUpdate().Fetch() }, ShouldPanic)
+			}), ShouldBeNil)
+		})
+	})
 	Convey("Checking error types", t, func() {
 		nice := new(notInCacheError)
 		So(nice.Error(), ShouldEqual, "requested value not in cache")
@@ -302,4 +336,229 @@ X2M Links
 			So(retries, ShouldEqual, 3)
 		})
 	})
+	Convey("Testing after commit functions", t, func() {
+		Convey("After commit functions should be called only once on commit", func() {
+			var calls, retries int
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.AfterCommit(func() { calls++ })
+				retries++
+				if retries < 2 {
+					panic(&pq.Error{Code: "40001"})
+				}
+			}), ShouldBeNil)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("After commit functions should not be called on rollback", func() {
+			var calls, rollbacks int
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.AfterCommit(func() { calls++ })
+				env.AfterRollback(func() { rollbacks++ })
+			}), ShouldBeNil)
+			So(calls, ShouldEqual, 0)
+			So(rollbacks, ShouldEqual, 1)
+		})
+		Convey("Enqueuing a job without job queue should panic", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.EnqueueJob("User", "PrefixedUser", "foo")
+			}), ShouldNotBeNil)
+		})
+	})
+	Convey("Testing transaction isolation levels", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			So(env.Isolation(), ShouldEqual, Serializable)
+			env = env.WithIsolation(RepeatableRead)
+			So(env.Isolation(), ShouldEqual, RepeatableRead)
+			var level string
+			env.Cr().Get(&level, "SHOW transaction_isolation")
+			So(level, ShouldEqual, "repeatable read")
+			So(func() { env.WithIsolation(RepeatableRead) }, ShouldNotPanic)
+			So(func() { env.WithIsolation(ReadCommitted) }, ShouldPanic)
+			So(func() { env.WithIsolation(IsolationLevel("foo")) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Testing query budget", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Query count should be incremented by each query", func() {
+				start := env.Cr().QueryCount()
+				for _, user := range env.Pool("User").SearchAll().Records() {
+					user.Get(Name)
+				}
+				So(env.Cr().QueryCount()-start, ShouldBeBetweenOrEqual, 1, 2)
+			})
+			Convey("Exceeding the budget in strict mode should panic", func() {
+				QueryBudgetStrict = true
+				defer func() { QueryBudgetStrict = false }()
+				env.Cr().SetQueryBudget(env.Cr().QueryCount() + 1)
+				So(func() { env.Cr().Execute("SELECT 1") }, ShouldNotPanic)
+				So(func() { env.Cr().Execute("SELECT 1") }, ShouldPanic)
+			})
+			Convey("Exceeding the budget in non strict mode should only warn", func() {
+				env.Cr().SetQueryBudget(env.Cr().QueryCount())
+				So(func() { env.Cr().Execute("SELECT 1") }, ShouldNotPanic)
+				So(func() { env.Cr().Execute("SELECT 1") }, ShouldNotPanic)
+				So(env.Cr().counter.exceeded, ShouldBeTrue)
+				So(env.Cr().counter.sample(), ShouldResemble, []string{"2x SELECT 1"})
+				env.Cr().SetQueryBudget(0)
+			})
+			Convey("Detached environments have no query budget", func() {
+				detached := NewDetachedEnvironment(security.SuperUserID)
+				So(func() { detached.Cr().SetQueryBudget(10) }, ShouldNotPanic)
+				So(detached.Cr().QueryCount(), ShouldEqual, 0)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing transaction retries", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			env.context = types.NewContext().WithKey("key", "value")
+			env = env.WithIsolation(ReadCommitted)
+			Convey("Serialization failures should be retried", func() {
+				var calls int
+				err := env.WithRetry(3, func(newEnv Environment) error {
+					calls++
+					So(newEnv.Cr(), ShouldNotEqual, env.Cr())
+					So(newEnv.Isolation(), ShouldEqual, ReadCommitted)
+					So(newEnv.Context().GetString("key"), ShouldEqual, "value")
+					if calls < 3 {
+						return &pq.Error{Code: "40001"}
+					}
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(calls, ShouldEqual, 3)
+			})
+			Convey("Deadlocks should be retried", func() {
+				var calls int
+				err := env.WithRetry(3, func(newEnv Environment) error {
+					calls++
+					if calls < 2 {
+						panic(&pq.Error{Code: "40P01"})
+					}
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(calls, ShouldEqual, 2)
+			})
+			Convey("Retry delays should double with jitter", func() {
+				for i := 0; i < 4; i++ {
+					max := DBSerializationRetryBackoff << uint(i)
+					for j := 0; j < 10; j++ {
+						delay := serializationRetryDelay(i)
+						So(delay, ShouldBeGreaterThanOrEqualTo, max/2)
+						So(delay, ShouldBeLessThan, max)
+					}
+				}
+			})
+			Convey("Serialization failures should be returned after the last retry", func() {
+				var calls int
+				err := env.WithRetry(1, func(newEnv Environment) error {
+					calls++
+					panic(&pq.Error{Code: "40001"})
+				})
+				So(err, ShouldNotBeNil)
+				So(calls, ShouldEqual, 2)
+			})
+			Convey("Other errors should not be retried", func() {
+				var calls, commits int
+				err := env.WithRetry(3, func(newEnv Environment) error {
+					calls++
+					newEnv.AfterCommit(func() { commits++ })
+					return errors.New("not a serialization failure")
+				})
+				So(err, ShouldNotBeNil)
+				So(calls, ShouldEqual, 1)
+				So(commits, ShouldEqual, 0)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing serialization failures at commit", t, func() {
+		var calls int
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			calls++
+			if calls > 1 {
+				return
+			}
+			// A deferred constraint trigger fails when the transaction commits
+			env.Cr().Execute(`CREATE TEMPORARY TABLE commit_failure (id integer)`)
+			env.Cr().Execute(`CREATE FUNCTION pg_temp.fail_commit() RETURNS trigger AS $$
+				BEGIN RAISE EXCEPTION 'serialization failure' USING ERRCODE = '40001'; END $$ LANGUAGE plpgsql`)
+			env.Cr().Execute(`CREATE CONSTRAINT TRIGGER commit_failure AFTER INSERT ON commit_failure
+				DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE PROCEDURE pg_temp.fail_commit()`)
+			env.Cr().Execute(`INSERT INTO commit_failure VALUES (1)`)
+		})
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 2)
+	})
+	RegisterUserContextGetter(func(env Environment, uid int64) *types.Context {
+		return types.NewContext().WithKey("lang", "fr_FR").WithKey("tz", "Europe/Paris")
+	})
+	defer RegisterUserContextGetter(nil)
+	Convey("Testing environment bound to another user", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userEnv := env.WithUser(2)
+			Convey("Environment should be bound to the user on the same transaction", func() {
+				So(userEnv.Uid(), ShouldEqual, 2)
+				So(userEnv.Cr(), ShouldEqual, env.Cr())
+				So(env.Uid(), ShouldEqual, security.SuperUserID)
+			})
+			Convey("User context values should be set in the context", func() {
+				So(userEnv.Context().GetString("lang"), ShouldEqual, "fr_FR")
+				So(userEnv.Context().GetString("tz"), ShouldEqual, "Europe/Paris")
+				So(env.Context().HasKey("lang"), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing environment clock", t, func() {
+		clock := dates.NewFakeClock(time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC))
+		dates.SetClock(clock)
+		defer dates.SetClock(nil)
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			So(env.Now().Equal(dates.ParseDateTime("2020-03-14 15:09:26")), ShouldBeTrue)
+			So(env.Today().String(), ShouldEqual, "2020-03-14")
+			user := userModel.Create(env, NewModelData(userModel).
+				Set(Name, "Clock User").
+				Set(email, "clock@example.com"))
+			clock.Advance(2 * time.Hour)
+			user.Set(Name, "Clock User 2")
+			user.InvalidateCache()
+			So(user.Get(createDate).(dates.DateTime).Equal(dates.ParseDateTime("2020-03-14 15:09:26")), ShouldBeTrue)
+			So(user.Get(writeDate).(dates.DateTime).Equal(dates.ParseDateTime("2020-03-14 17:09:26")), ShouldBeTrue)
+		}), ShouldBeNil)
+	})
+	Convey("Testing detached environments", t, func() {
+		env := NewDetachedEnvironment(security.SuperUserID)
+		userModel := Registry.MustGet("User")
+		profiles := env.Pool("Profile").Detached(FieldMap{"id": 10, "Age": 30})
+		users := env.Pool("User").Detached(
+			FieldMap{"id": 1, "Name": "Will Detached", "Email": "will@example.com", "Profile": 10},
+			FieldMap{"Name": "Ann Detached", "email": "ann@example.com"})
+		So(env.Cr(), ShouldBeNil)
+		Convey("Fields are read from the detached data", func() {
+			So(users.Len(), ShouldEqual, 2)
+			So(users.Ids()[0], ShouldEqual, 1)
+			So(users.Ids()[1], ShouldBeLessThan, 0)
+			So(users.Get(Name), ShouldEqual, "Will Detached")
+			So(users.Get(decoratedName), ShouldEqual, "User: Will Detached [<will@example.com>]")
+			So(users.Get(nums), ShouldEqual, 0)
+			So(users.Get(profile).(RecordSet).Collection().Equals(profiles), ShouldBeTrue)
+			So(users.Get(profile).(RecordSet).Collection().Get(age), ShouldEqual, 30)
+		})
+		Convey("Set operations work on detached records", func() {
+			sorted := users.SortedByField(Name, false)
+			So(sorted.Records()[0].Get(Name), ShouldEqual, "Ann Detached")
+			filtered := users.Filtered(func(rs RecordSet) bool {
+				return rs.Collection().Get(email) == "ann@example.com"
+			})
+			So(filtered.Len(), ShouldEqual, 1)
+			So(filtered.Get(Name), ShouldEqual, "Ann Detached")
+		})
+		Convey("Searching and modifying detached records panics", func() {
+			So(func() { users.Search(userModel.Field(Name).Equals("Will Detached")).Len() }, ShouldPanic)
+			So(func() { users.SearchCount() }, ShouldPanic)
+			So(func() { users.Set(Name, "Other Name") }, ShouldPanic)
+			So(func() { users.Call("Unlink") }, ShouldPanic)
+			So(func() { env.Pool("User").Call("Create", NewModelData(userModel).Set(Name, "New User")) }, ShouldPanic)
+			So(users.Get(Name), ShouldEqual, "Will Detached")
+		})
+	})
 }
@@ -4,10 +4,14 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -31,6 +35,26 @@ func TestBaseModelMethods(t *testing.T) {
 				time.Sleep(1*time.Second + 100*time.Millisecond)
 				So(newComment.Get(lastupdate).(dates.DateTime).Sub(newComment.Get(createDate).(dates.DateTime)), ShouldBeLessThanOrEqualTo, 1*time.Second)
 			})
+			Convey("Log access fields", func() {
+				newComment := commentModel.Create(env, NewModelData(commentModel).
+					Set(text, "MyComment"))
+				So(newComment.Get(createUID).(RecordSet).Collection().Ids(), ShouldResemble, []int64{security.SuperUserID})
+				So(newComment.Get(writeDate).(dates.DateTime).IsZero(), ShouldBeTrue)
+				newComment.Set(text, "MyComment 2")
+				So(newComment.Get(writeUID).(RecordSet).Collection().Ids(), ShouldResemble, []int64{security.SuperUserID})
+				So(newComment.Get(writeDate).(dates.DateTime).IsZero(), ShouldBeFalse)
+				comments := commentModel.Search(env, commentModel.Field(createDate).GreaterOrEqual(newComment.Get(createDate))).
+					OrderBy("CreateDate DESC")
+				So(comments.Ids(), ShouldContain, newComment.Ids()[0])
+				noteModel := Registry.MustGet("Note")
+				newNote := noteModel.Create(env, NewModelData(noteModel).
+					Set(Name, "Untracked"))
+				newNote.Set(description, "Not tracked")
+				So(newNote.Get(createDate).(dates.DateTime).IsZero(), ShouldBeTrue)
+				So(newNote.Get(createUID).(RecordSet).IsEmpty(), ShouldBeTrue)
+				So(newNote.Get(writeDate).(dates.DateTime).IsZero(), ShouldBeTrue)
+				So(newNote.Get(writeUID).(RecordSet).IsEmpty(), ShouldBeTrue)
+			})
 			Convey("Load and Read", func() {
 				userJane = userJane.Call("Load", []FieldName{ID, Name, age, posts, profile}).(RecordSet).Collection()
 				res := userJane.Call("Read", []FieldName{Name, age, posts, profile})
@@ -48,6 +72,30 @@ func TestBaseModelMethods(t *testing.T) {
 				So(fMap, ShouldContainKey, "id")
 				So(fMap["id"], ShouldEqual, userJane.Ids()[0])
 			})
+			Convey("ReadNested", func() {
+				spec, err := ParseFieldsSpec(userModel, []interface{}{"name", map[string]interface{}{
+					"posts_ids":  []interface{}{"title", map[string]interface{}{"tags_ids": []interface{}{"name"}}},
+					"profile_id": []interface{}{"Age"},
+				}})
+				So(err, ShouldBeNil)
+				So(spec, ShouldHaveLength, 3)
+				res := userJane.Call("ReadNested", spec).([]FieldMap)
+				So(res, ShouldHaveLength, 1)
+				So(res[0]["name"], ShouldEqual, "Jane A. Smith")
+				So(res[0]["id"], ShouldEqual, userJane.Ids()[0])
+				So(res[0]["profile_id"].(FieldMap)["age"], ShouldEqual, 24)
+				So(res[0]["posts_ids"], ShouldHaveLength, 2)
+				for _, post := range res[0]["posts_ids"].([]FieldMap) {
+					So(post, ShouldContainKey, "title")
+					So(post, ShouldContainKey, "tags_ids")
+				}
+				_, err = ParseFieldsSpec(userModel, []interface{}{map[string]interface{}{"name": []interface{}{"id"}}})
+				So(err, ShouldNotBeNil)
+				_, err = ParseFieldsSpec(userModel, []interface{}{"unknown_field"})
+				So(err, ShouldNotBeNil)
+				deepSpec := FieldsSpec{{Field: posts, SubFields: FieldsSpec{{Field: user, SubFields: FieldsSpec{{Field: posts, SubFields: FieldsSpec{{Field: Name}}}}}}}}
+				So(func() { userJane.ReadNested(deepSpec) }, ShouldPanic)
+			})
 			Convey("Browse and BrowseOne", func() {
 				jid := userJane.Ids()[0]
 				j2 := userModel.Browse(env, []int64{jid})
@@ -71,6 +119,11 @@ func TestBaseModelMethods(t *testing.T) {
 			Convey("Copy", func() {
 				newProfile := userJane.Get(profile).(RecordSet).Collection().Call("Copy", NewModelData(profileModel)).(RecordSet).Collection()
 				So(newProfile.Equals(userJane.Get(profile).(RecordSet).Collection()), ShouldBeFalse)
+				So(newProfile.Get(age), ShouldEqual, 24)
+				So(newProfile.Get(money), ShouldEqual, 0)
+				moneyProfile := userJane.Get(profile).(RecordSet).Collection().Call("Copy", NewModelData(profileModel).
+					Set(money, 100)).(RecordSet).Collection()
+				So(moneyProfile.Get(money), ShouldEqual, 100)
 				userJane.Call("Write", NewModelData(userModel).Set(password, "Jane's Password"))
 				userJaneCopy := userJane.Call("Copy", NewModelData(userModel).
 					Set(Name, "Jane's Copy").
@@ -83,6 +136,7 @@ func TestBaseModelMethods(t *testing.T) {
 				So(userJaneCopy.Get(password), ShouldBeBlank)
 				So(userJaneCopy.Get(profile).(RecordSet).Collection().Equals(userJane.Get(profile).(RecordSet)), ShouldBeFalse)
 				So(userJaneCopy.Get(profileAge), ShouldEqual, 24)
+				So(userJaneCopy.Get(profile).(RecordSet).Collection().Get(money), ShouldEqual, 0)
 				So(userJaneCopy.Get(age), ShouldEqual, 24)
 				So(userJaneCopy.Get(nums), ShouldEqual, 2)
 				So(userJaneCopy.Get(posts).(RecordSet).Collection().Len(), ShouldEqual, 2)
@@ -95,13 +149,82 @@ func TestBaseModelMethods(t *testing.T) {
 				So(fInfo.Help, ShouldEqual, "The user's username")
 				So(fInfo.Type, ShouldEqual, fieldtype.Char)
 				fInfos := userJane.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
-				So(fInfos, ShouldHaveLength, 35)
+				So(fInfos, ShouldHaveLength, 37)
 			})
 			Convey("NameGet", func() {
 				So(userJane.Get(displayName), ShouldEqual, "Jane A. Smith")
 				janeProfile := userJane.Get(profile).(RecordSet).Collection()
 				So(janeProfile.Get(displayName), ShouldEqual, fmt.Sprintf("Profile(%d)", janeProfile.Get(ID)))
 			})
+			Convey("DisplayNames", func() {
+				users := env.Pool("User").SearchAll()
+				names := users.Call("DisplayNames").(map[int64]string)
+				So(names, ShouldHaveLength, users.Len())
+				janeID := userJane.Ids()[0]
+				So(names[janeID], ShouldEqual, "Jane A. Smith")
+				cached, ok := env.cache.getDisplayName(userModel, janeID, env.Context().GetString("lang"))
+				So(ok, ShouldBeTrue)
+				So(cached, ShouldEqual, "Jane A. Smith")
+				So(userJane.DisplayNames(), ShouldResemble, map[int64]string{janeID: "Jane A. Smith"})
+				userJane.Set(Name, "Jane D. Smith")
+				_, ok = env.cache.getDisplayName(userModel, janeID, env.Context().GetString("lang"))
+				So(ok, ShouldBeFalse)
+				So(userJane.DisplayNames()[janeID], ShouldEqual, "Jane D. Smith")
+			})
+			Convey("DisplayName is computed through DisplayNames", func() {
+				janeID := userJane.Ids()[0]
+				lang := env.Context().GetString("lang")
+				env.cache.invalidateSearches()
+				So(userJane.Get(displayName), ShouldEqual, "Jane A. Smith")
+				cached, ok := env.cache.getDisplayName(userModel, janeID, lang)
+				So(ok, ShouldBeTrue)
+				So(cached, ShouldEqual, "Jane A. Smith")
+				env.cache.setDisplayName(userModel, janeID, lang, "Cached Jane")
+				So(userJane.Get(displayName), ShouldEqual, "Cached Jane")
+			})
+			Convey("Modifiers", func() {
+				userJane.Set(isPremium, false)
+				mods := userJane.Call("Modifiers", motto, Name).(map[int64]map[string]FieldModifiers)
+				janeID := userJane.Ids()[0]
+				So(mods, ShouldHaveLength, 1)
+				So(mods[janeID], ShouldHaveLength, 2)
+				So(mods[janeID]["motto"], ShouldResemble, FieldModifiers{})
+				So(mods[janeID]["name"], ShouldResemble, FieldModifiers{})
+				userJane.Set(nums, 1)
+				userJane.Set(isPremium, true)
+				So(userJane.Modifiers(motto)[janeID]["motto"].ReadOnly, ShouldBeTrue)
+				So(userJane.WithContext("hide_motto", true).Modifiers(motto)[janeID]["motto"], ShouldResemble,
+					FieldModifiers{ReadOnly: true, Invisible: true})
+				So(userJane.Modifiers()[janeID], ShouldContainKey, "is_premium")
+				So(func() { env.Pool("User").Call("New", NewModelData(userModel)).(RecordSet).Collection().Modifiers() }, ShouldPanic)
+			})
+			Convey("Modifiers in FieldsGet", func() {
+				userJane.Set(isPremium, false)
+				fInfos := env.Pool("User").Call("FieldsGet", FieldsGetArgs{Fields: FieldNames{motto}}).(map[string]*FieldInfo)
+				So(fInfos["motto"].ReadOnly, ShouldBeFalse)
+				So(fInfos["motto"].Invisible, ShouldBeFalse)
+				So(fInfos["motto"].Modifiers, ShouldBeNil)
+				fInfos = env.Pool("User").WithContext("hide_motto", true).
+					Call("FieldsGet", FieldsGetArgs{Fields: FieldNames{motto}}).(map[string]*FieldInfo)
+				So(fInfos["motto"].Invisible, ShouldBeTrue)
+				janeID := userJane.Ids()[0]
+				fInfos = userJane.Call("FieldsGet", FieldsGetArgs{Fields: FieldNames{motto}}).(map[string]*FieldInfo)
+				So(fInfos["motto"].Modifiers, ShouldResemble, map[int64]FieldModifiers{janeID: {}})
+				userJane.Set(nums, 1)
+				userJane.Set(isPremium, true)
+				fInfos = userJane.Call("FieldsGet", FieldsGetArgs{Fields: FieldNames{motto}}).(map[string]*FieldInfo)
+				So(fInfos["motto"].ReadOnly, ShouldBeFalse)
+				So(fInfos["motto"].Modifiers, ShouldResemble, map[int64]FieldModifiers{janeID: {ReadOnly: true}})
+				So(userJane.Call("FieldGet", motto).(*FieldInfo).Modifiers[janeID].ReadOnly, ShouldBeTrue)
+			})
+			Convey("SelectionLabel in another language", func() {
+				i18n.Registry.LoadPOFile("testdata/fr_FR.po")
+				userJane.Set(coolType, "cool")
+				So(userJane.SelectionLabel(coolType), ShouldEqual, "Yes, its a cool user")
+				So(userJane.WithContext("lang", "fr_FR").SelectionLabel(coolType), ShouldEqual, "Oui, c'est un utilisateur cool")
+				So(userJane.WithContext("lang", "de_DE").SelectionLabel(coolType), ShouldEqual, "Yes, its a cool user")
+				So(func() { userJane.SelectionLabel(Name) }, ShouldPanic)
+			})
 			Convey("DefaultGet", func() {
 				defaults := userJane.Call("DefaultGet").(*ModelData)
 				So(defaults.FieldMap, ShouldHaveLength, 14)
@@ -197,6 +320,75 @@ func TestBaseModelMethods(t *testing.T) {
 					So(fMap, ShouldContainKey, "best_profile_post_id")
 					So(fMap["best_profile_post_id"].(RecordSet).Collection().Equals(post), ShouldBeTrue)
 				})
+				Convey("Testing a new line of a new parent record", func() {
+					invoiceModel := Registry.MustGet("Invoice")
+					lineModel := Registry.MustGet("InvoiceLine")
+					lines := invoiceModel.FieldName("Lines")
+					lastLineText := invoiceModel.FieldName("LastLineText")
+					lineNumber := lineModel.FieldName("LineNumber")
+					customerEmail := lineModel.FieldName("CustomerEmail")
+					res := env.Pool("Invoice").Call("OnchangeLine", OnchangeLineParams{
+						Values: NewModelData(invoiceModel, FieldMap{"Name": "Onchange Invoice", "Customer": userJane, "LastLineText": ""}).
+							Create(lines, NewModelData(lineModel).Set(text, "First line")),
+						Field:          lines,
+						Line:           NewModelData(lineModel, FieldMap{"Text": "Second line", "LineNumber": 0, "CustomerEmail": ""}),
+						Fields:         []FieldName{text},
+						Onchange:       map[string]string{"Text": "1"},
+						ParentOnchange: map[string]string{"Lines": "1"},
+					}).(OnchangeLineResult)
+					lineMap := res.Value.Underlying().FieldMap
+					So(lineMap, ShouldHaveLength, 2)
+					So(lineMap, ShouldContainKey, lineNumber.JSON())
+					So(lineMap[lineNumber.JSON()], ShouldEqual, 2)
+					So(lineMap, ShouldContainKey, customerEmail.JSON())
+					So(lineMap[customerEmail.JSON()], ShouldEqual, "jane.smith@example.com")
+					parentMap := res.ParentValue.Underlying().FieldMap
+					So(parentMap, ShouldHaveLength, 1)
+					So(parentMap, ShouldContainKey, lastLineText.JSON())
+					So(env.Pool("Invoice").Search(invoiceModel.Field(Name).Equals("Onchange Invoice")).IsEmpty(), ShouldBeTrue)
+				})
+				Convey("Testing an existing line of an existing parent record", func() {
+					invoiceModel := Registry.MustGet("Invoice")
+					lineModel := Registry.MustGet("InvoiceLine")
+					lines := invoiceModel.FieldName("Lines")
+					lineNumber := lineModel.FieldName("LineNumber")
+					customerEmail := lineModel.FieldName("CustomerEmail")
+					invoice := env.Pool("Invoice").Call("Create", NewModelData(invoiceModel).
+						Set(Name, "Existing Onchange Invoice").
+						Set(invoiceModel.FieldName("Customer"), userJane).
+						Create(lines, NewModelData(lineModel).Set(text, "First existing line")).
+						Create(lines, NewModelData(lineModel).Set(text, "Second existing line"))).(RecordSet).Collection()
+					second := lineModel.Search(env, lineModel.Field(text).Equals("Second existing line"))
+					So(second.Len(), ShouldEqual, 1)
+					So(second.Get(lineNumber), ShouldEqual, 2)
+					res := invoice.Call("OnchangeLine", OnchangeLineParams{
+						Values:         NewModelData(invoiceModel, FieldMap{"Name": "Existing Onchange Invoice", "Customer": userJane}),
+						Field:          lines,
+						LineID:         second.Ids()[0],
+						Line:           NewModelData(lineModel, FieldMap{"Text": "Edited line", "LineNumber": 2, "CustomerEmail": ""}),
+						Fields:         []FieldName{text},
+						Onchange:       map[string]string{"Text": "1"},
+						ParentOnchange: map[string]string{"Lines": "1"},
+					}).(OnchangeLineResult)
+					lineMap := res.Value.Underlying().FieldMap
+					So(lineMap, ShouldHaveLength, 1)
+					So(lineMap, ShouldNotContainKey, lineNumber.JSON())
+					So(lineMap, ShouldContainKey, customerEmail.JSON())
+					So(lineMap[customerEmail.JSON()], ShouldEqual, "jane.smith@example.com")
+					So(res.ParentValue.Underlying().FieldMap, ShouldNotContainKey, Name.JSON())
+					So(second.Get(text), ShouldEqual, "Second existing line")
+					So(second.Get(lineNumber), ShouldEqual, 2)
+					So(invoice.Get(lines).(RecordSet).Len(), ShouldEqual, 2)
+				})
+				Convey("Testing OnchangeLine on a non one2many field should panic", func() {
+					So(func() {
+						env.Pool("Post").Call("OnchangeLine", OnchangeLineParams{
+							Values: NewModelData(postModel),
+							Field:  title,
+							Line:   NewModelData(commentModel),
+						})
+					}, ShouldPanic)
+				})
 			})
 			Convey("CheckRecursion", func() {
 				So(userJane.Call("CheckRecursion").(bool), ShouldBeTrue)
@@ -503,9 +695,129 @@ func TestBaseModelMethods(t *testing.T) {
 			Convey("GetRecord", func() {
 				So(env.Pool("User").Call("GetRecord", userJane.Get(hexyaExternalID)).(RecordSet).Collection().Equals(userJane), ShouldBeTrue)
 			})
+			Convey("ResolveExternalIDs", func() {
+				janeID := userJane.Get(hexyaExternalID).(string)
+				found, missing := env.Pool("User").ResolveExternalIDs([]string{"unknown_1", janeID, "unknown_2", "unknown_1"})
+				So(found, ShouldHaveLength, 1)
+				So(found[janeID].Equals(userJane), ShouldBeTrue)
+				So(missing, ShouldResemble, []string{"unknown_1", "unknown_2"})
+				found, missing = env.Pool("User").ResolveExternalIDs(nil)
+				So(found, ShouldBeEmpty)
+				So(missing, ShouldBeEmpty)
+			})
+			Convey("ExportGraph and ImportGraph", func() {
+				janeName := userJane.Get(Name).(string)
+				janeProfile := userJane.Get(profile).(RecordSet).Collection()
+				firstPost := userJane.Get(posts).(RecordSet).Collection().OrderBy("ID").Limit(1)
+				So(firstPost.Len(), ShouldEqual, 1)
+				newTag := tagModel.Create(env, NewModelData(tagModel).
+					Set(Name, "Exported Tag").
+					Set(description, "Exported Tag Description").
+					Set(posts, firstPost))
+				tagExtID := newTag.Get(hexyaExternalID).(string)
+				graph := userJane.ExportGraph(profile, postsTags)
+				positions := make(map[string]int)
+				for i, exp := range graph.Records {
+					key := graphKey(exp.Model, exp.ExternalID)
+					_, exists := positions[key]
+					So(exists, ShouldBeFalse)
+					positions[key] = i
+					So(exp.Values, ShouldNotContainKey, "id")
+					So(exp.Values, ShouldNotContainKey, "hexya_external_id")
+				}
+				janeKey := graphKey("User", userJane.Get(hexyaExternalID).(string))
+				profileKey := graphKey("Profile", janeProfile.Get(hexyaExternalID).(string))
+				So(positions, ShouldContainKey, janeKey)
+				So(positions, ShouldContainKey, profileKey)
+				So(positions, ShouldContainKey, graphKey("Tag", tagExtID))
+				So(positions[profileKey], ShouldBeLessThan, positions[janeKey])
+				exportedJane := graph.Records[positions[janeKey]]
+				So(exportedJane.Values["profile_id"], ShouldEqual, janeProfile.Get(hexyaExternalID))
+				data, err := json.Marshal(graph)
+				So(err, ShouldBeNil)
+				var decoded ExportedGraph
+				So(json.Unmarshal(data, &decoded), ShouldBeNil)
+				userJane.Set(Name, "Jane Changed")
+				newTag.Call("Unlink")
+				So(env.Pool("Tag").Search(tagModel.Field(hexyaExternalID).Equals(tagExtID)).IsEmpty(), ShouldBeTrue)
+				ImportGraph(env, &decoded)
+				So(userJane.Get(Name), ShouldEqual, janeName)
+				importedTag := env.Pool("Tag").GetRecord(tagExtID)
+				So(importedTag.Get(Name), ShouldEqual, "Exported Tag")
+				So(importedTag.Get(posts).(RecordSet).Collection().Equals(firstPost), ShouldBeTrue)
+				So(firstPost.Get(tags).(RecordSet).Collection().Intersect(importedTag).Equals(importedTag), ShouldBeTrue)
+				So(func() { userJane.ExportGraph(Name) }, ShouldPanic)
+				So(func() {
+					ImportGraph(env, &ExportedGraph{Records: []ExportedRecord{{
+						Model:      "Tag",
+						ExternalID: "graph_tag",
+						Values:     map[string]interface{}{"name": "Graph Tag", "parent_id": "unknown_tag"},
+					}}})
+				}, ShouldPanic)
+			})
 			Convey("SearchByName", func() {
 				j := env.Pool("User").Call("SearchByName", "Jane A. Smith", operator.Operator(""), userModel.Field(isStaff).Equals(false), 10).(RecordSet).Collection()
 				So(j.Equals(userJane), ShouldBeTrue)
+				smiths := env.Pool("User").Call("SearchByName", "Smith", operator.Operator(""), Condition{}, 0).(RecordSet).Collection()
+				So(smiths.Len(), ShouldBeGreaterThan, 1)
+			})
+			Convey("SearchByName on several fields", func() {
+				officeModel := Registry.MustGet("Office")
+				officeSet := env.Pool("Office")
+				office := officeSet.Call("Create", NewModelData(officeModel).
+					Set(Name, "Paris Office").
+					Set(officeModel.FieldName("Team"), "John's team")).(RecordSet).Collection()
+				byName := func(name string, op operator.Operator) *RecordCollection {
+					return officeSet.Call("SearchByName", name, op, Condition{}, 10).(RecordSet).Collection()
+				}
+				So(byName("john paris", "").Equals(office), ShouldBeTrue)
+				So(byName("paris", "").Equals(office), ShouldBeTrue)
+				So(byName("john london", "").IsEmpty(), ShouldBeTrue)
+				So(byName("John's team", operator.Equals).Equals(office), ShouldBeTrue)
+				So(byName("Paris", operator.NotIContains).Intersect(office).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("NameCreate", func() {
+				tag := env.Pool("Tag").WithContext("default_description", "Quick description").
+					Call("NameCreate", "Quick Tag").(RecordSet).Collection()
+				So(tag.Len(), ShouldEqual, 1)
+				So(tag.Get(Name), ShouldEqual, "Quick Tag")
+				So(tag.Get(description), ShouldEqual, "Quick description")
+				So(func() { env.Pool("User").Call("NameCreate", "Quick User") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestNameGet(t *testing.T) {
+	Convey("Testing NameGet in the context language", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			i18n.Registry.LoadPOFile("testdata/fr_FR.po")
+			badgeModel := Registry.MustGet("Badge")
+			medalModel := Registry.MustGet("Medal")
+			awardModel := Registry.MustGet("Award")
+			badge := badgeModel.Create(env, NewModelData(badgeModel).Set(Name, "gold"))
+			medal := medalModel.Create(env, NewModelData(medalModel).Set(Name, "Gold medal"))
+			medal.WithContext("lang", "fr_FR").Set(Name, "Médaille d'or")
+			award := awardModel.Create(env, NewModelData(awardModel).Set(awardModel.FieldName("Name"), medal))
+			Convey("Selection names are given by their translated label", func() {
+				So(badge.Call("NameGet"), ShouldEqual, "Gold")
+				So(badge.WithContext("lang", "fr_FR").Call("NameGet"), ShouldEqual, "Or")
+				So(badge.WithContext("lang", "de_DE").Call("NameGet"), ShouldEqual, "Gold")
+				So(badge.WithContext("lang", "fr_FR").Get(displayName), ShouldEqual, "Or")
+				badge.Set(Name, "")
+				So(badge.Call("NameGet"), ShouldBeBlank)
+			})
+			Convey("Translated names are read in the context language", func() {
+				So(medal.Call("NameGet"), ShouldEqual, "Gold medal")
+				So(medal.WithContext("lang", "fr_FR").Call("NameGet"), ShouldEqual, "Médaille d'or")
+				So(medal.WithContext("lang", "fr_FR").Get(displayName), ShouldEqual, "Médaille d'or")
+			})
+			Convey("Names that are relations are given by the related record NameGet", func() {
+				So(award.Call("NameGet"), ShouldEqual, "Gold medal")
+				So(award.WithContext("lang", "fr_FR").Call("NameGet"), ShouldEqual, "Médaille d'or")
+				So(award.WithContext("lang", "fr_FR").Get(displayName), ShouldEqual, "Médaille d'or")
+				emptyAward := awardModel.Create(env, NewModelData(awardModel))
+				So(emptyAward.Call("NameGet"), ShouldBeBlank)
 			})
 		}), ShouldBeNil)
 	})
@@ -570,3 +882,57 @@ func TestFreeTransientModels(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWorkerLoopStopping(t *testing.T) {
+	oldWorkerFunctions := workerFunctions
+	defer func() { workerFunctions = oldWorkerFunctions }()
+	var (
+		once        sync.Once
+		sawStopping bool
+	)
+	started := make(chan struct{})
+	workerFunctions = []WorkerFunction{NewWorkerFunction(func() {
+		once.Do(func() { close(started) })
+		for !WorkerLoopStopping() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		sawStopping = true
+	}, 50*time.Millisecond)}
+	Convey("Stopping the worker loop should wait for running worker functions", t, func() {
+		RunWorkerLoop()
+		<-started
+		So(WorkerLoopStopping(), ShouldBeFalse)
+		StopWorkerLoop()
+		So(sawStopping, ShouldBeTrue)
+		So(WorkerLoopStopping(), ShouldBeTrue)
+	})
+}
+
+func TestGoWorker(t *testing.T) {
+	oldWorkerFunctions := workerFunctions
+	defer func() { workerFunctions = oldWorkerFunctions }()
+	workerFunctions = nil
+	Convey("Testing goroutines started with GoWorker", t, func() {
+		Convey("GoWorker should refuse to run when the worker loop is not running", func() {
+			So(GoWorker(func() {}), ShouldBeFalse)
+		})
+		Convey("Stopping the worker loop should wait for GoWorker goroutines", func() {
+			RunWorkerLoop()
+			So(WorkerLoopStopping(), ShouldBeFalse)
+			var finished int32
+			started := make(chan struct{})
+			So(GoWorker(func() {
+				close(started)
+				for !WorkerLoopStopping() {
+					time.Sleep(10 * time.Millisecond)
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.StoreInt32(&finished, 1)
+			}), ShouldBeTrue)
+			<-started
+			StopWorkerLoop()
+			So(atomic.LoadInt32(&finished), ShouldEqual, 1)
+			So(GoWorker(func() {}), ShouldBeFalse)
+		})
+	})
+}
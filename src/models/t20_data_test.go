@@ -128,4 +128,21 @@ func TestDataLoading(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing CSV data records removal from database", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userObj := env.Pool("User")
+			postObj := env.Pool("Post")
+			userPeter := userObj.Search(userObj.Model().Field(Name).Equals("Peter"))
+			nbPosts := postObj.SearchCount()
+			So(UnlinkCSVDataRecords(env, "testdata/Post.csv"), ShouldEqual, 2)
+			So(postObj.SearchCount(), ShouldEqual, nbPosts-2)
+			So(userPeter.Get(posts).(RecordSet).IsEmpty(), ShouldBeTrue)
+			So(UnlinkCSVDataRecords(env, "testdata/Post.csv"), ShouldEqual, 0)
+			So(userPeter.IsEmpty(), ShouldBeFalse)
+			So(func() { UnlinkCSVDataRecords(env, "testdata/NoFile.csv") }, ShouldPanic)
+			nbUsers := userObj.SearchCount()
+			So(UnlinkCSVDataRecords(env, "testdata/200User_update.csv"), ShouldEqual, 0)
+			So(userObj.SearchCount(), ShouldEqual, nbUsers)
+		}), ShouldBeNil)
+	})
 }
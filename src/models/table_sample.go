@@ -0,0 +1,97 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// A SampleMethod defines how the rows of a table sample are selected.
+type SampleMethod string
+
+const (
+	// SampleSystem selects whole table blocks with the given probability.
+	// It is very fast but rows stored together are selected together.
+	SampleSystem SampleMethod = "SYSTEM"
+	// SampleBernoulli selects each row with the given probability.
+	// It is more random than SampleSystem but scans the whole table.
+	SampleBernoulli SampleMethod = "BERNOULLI"
+)
+
+// A tableSample defines the sampling of the table of a Query.
+// A zero tableSample means no sampling.
+type tableSample struct {
+	method  SampleMethod
+	percent float64
+}
+
+// isSet returns true if this tableSample samples the table.
+func (ts tableSample) isSet() bool {
+	return ts.percent > 0
+}
+
+// scale returns the factor to apply to the counts and sums computed
+// on the sample to estimate those of the whole table.
+func (ts tableSample) scale() float64 {
+	return 100 / ts.percent
+}
+
+// scaleCount returns the estimate on the whole table of the
+// given count computed on the sample.
+func (ts tableSample) scaleCount(count int) int {
+	if !ts.isSet() {
+		return count
+	}
+	return int(math.Round(float64(count) * ts.scale()))
+}
+
+// sqlClause returns the TABLESAMPLE clause of this tableSample.
+//
+// It panics if the database does not support table samples.
+func (ts tableSample) sqlClause() string {
+	res := adapters[db.DriverName()].tableSampleSQL(ts.method, ts.percent)
+	if res == "" {
+		log.Panic("Table samples are not supported by the database", "driver", db.DriverName())
+	}
+	return res
+}
+
+// TableSample returns a new RecordSet which only searches in a random sample
+// of about percent % of the rows of its table, selected with the given method.
+//
+// This is meant for approximate reporting on huge tables: SearchCount and the
+// counts and sums of Aggregates are scaled up to estimate the values on the
+// whole table, while other aggregates such as averages are computed on the
+// sample. Fetched records are the matching records of the sample only. Results
+// change at each query and are not exact, even with a 100 percent sample.
+//
+// It panics if percent is not in ]0, 100] or if the method is unknown, and when
+// the RecordSet is queried if the database does not support table samples.
+func (rc *RecordCollection) TableSample(method SampleMethod, percent float64) *RecordCollection {
+	switch method {
+	case SampleSystem, SampleBernoulli:
+	default:
+		log.Panic("Unknown table sample method", "model", rc.model.name, "method", method)
+	}
+	if percent <= 0 || percent > 100 {
+		log.Panic("Table sample percentage must be in ]0, 100]", "model", rc.model.name, "percent", percent)
+	}
+	rSet := *rc
+	rSet.query = rSet.query.clone(&rSet)
+	rSet.query.sample = tableSample{method: method, percent: percent}
+	return &rSet
+}
+
+// sampledSumSQL returns the SQL expression of the given sum aggregate of
+// the field with the given expressions, scaled up to the whole table.
+func (q *Query) sampledSumSQL(aggSQL string, exprs []FieldName) string {
+	res := fmt.Sprintf("%s * %g", aggSQL, q.sample.scale())
+	if q.recordSet.model.getRelatedFieldInfo(joinFieldNames(exprs, ExprSep)).fieldType == fieldtype.Integer {
+		res = fmt.Sprintf("CAST(ROUND(%s) AS bigint)", res)
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+)
+
+// setupTouchParents registers on the related model of each one2many field
+// with the TouchParent option the many2one field pointing to the parent.
+func setupTouchParents() {
+	for _, model := range Registry.registryByName {
+		model.touchParentFields = nil
+	}
+	for _, model := range Registry.registryByName {
+		for _, fi := range model.fields.registryByName {
+			if !fi.touchParent {
+				continue
+			}
+			if fi.fieldType != fieldtype.One2Many {
+				log.Panic("TouchParent can only be set on one2many fields", "model", model.name, "field", fi.name)
+			}
+			if !model.logAccess() {
+				log.Panic("TouchParent requires the parent model to log access", "model", model.name, "field", fi.name)
+			}
+			child := fi.relatedModel
+			child.touchParentFields = append(child.touchParentFields, child.FieldName(fi.reverseFK))
+		}
+	}
+}
+
+// touchParentsDependOn returns true if the parents to touch of the
+// records of this RecordCollection change when the given fields are modified.
+func (rc *RecordCollection) touchParentsDependOn(fields FieldNames) bool {
+	for _, f := range fields {
+		for _, parentField := range rc.model.touchParentFields {
+			if f.JSON() == parentField.JSON() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// touchedParentIds returns the ids of the parents to touch when the records
+// of this RecordCollection are modified, grouped by parent model.
+func (rc *RecordCollection) touchedParentIds() map[*Model][]int64 {
+	if len(rc.model.touchParentFields) == 0 || rc.hasNegIds {
+		return nil
+	}
+	res := make(map[*Model][]int64)
+	rc.ForceLoad(append(FieldNames{ID}, rc.model.touchParentFields...)...)
+	for _, rec := range rc.Records() {
+		for _, parentField := range rc.model.touchParentFields {
+			parentModel := rc.model.fields.MustGet(parentField.JSON()).relatedModel
+			res[parentModel] = append(res[parentModel], rec.Get(parentField).(RecordSet).Ids()...)
+		}
+	}
+	return res
+}
+
+// touchParents sets the WriteDate and WriteUID fields of the given parents,
+// with a single query per parent model.
+func (rc *RecordCollection) touchParents(parents ...map[*Model][]int64) {
+	idsByModel := make(map[*Model][]int64)
+	for _, p := range parents {
+		for model, ids := range p {
+			idsByModel[model] = append(idsByModel[model], ids...)
+		}
+	}
+	adapter := adapters[db.DriverName()]
+	for model, ids := range idsByModel {
+		parentSet := rc.env.Pool(model.name).withIds(ids)
+		if len(parentSet.ids) == 0 {
+			continue
+		}
+		query := fmt.Sprintf("UPDATE %s SET write_date = ?, write_uid = ? WHERE id IN (?)",
+			adapter.quoteTableName(model.tableName))
+		rc.env.cr.Execute(query, rc.env.Now(), rc.env.uid, parentSet.ids)
+		rc.env.cache.invalidateSearches()
+		for _, id := range parentSet.ids {
+			rc.env.cache.invalidateRecord(model, id)
+		}
+		touchedFields := FieldNames{model.FieldName("WriteDate"), model.FieldName("WriteUID")}
+		parentSet.processTriggers(touchedFields)
+		parentSet.notifyChange(ChangeWritten, parentSet.ids, touchedFields)
+	}
+}
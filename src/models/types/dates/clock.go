@@ -0,0 +1,80 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package dates
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock gives the current time to Now and Today.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock that reads the system time.
+type systemClock struct{}
+
+// Now returns the current system time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	clock   Clock = systemClock{}
+	clockMu sync.RWMutex
+)
+
+// SetClock sets the Clock used by Now and Today, and thus by all the code
+// reading the current time through this package, such as audit fields,
+// scheduled jobs and the mail outbox.
+//
+// This is meant for tests. Setting a nil Clock restores the system clock.
+func SetClock(c Clock) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	if c == nil {
+		c = systemClock{}
+	}
+	clock = c
+}
+
+// currentClock returns the Clock set with SetClock
+func currentClock() Clock {
+	clockMu.RLock()
+	defer clockMu.RUnlock()
+	return clock
+}
+
+// A FakeClock is a Clock whose time only changes when it is set or advanced.
+type FakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a new FakeClock set at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of this FakeClock
+func (fc *FakeClock) Now() time.Time {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.now
+}
+
+// Set sets the time of this FakeClock
+func (fc *FakeClock) Set(now time.Time) {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.now = now
+}
+
+// Advance moves the time of this FakeClock forward by d
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.now = fc.now.Add(d)
+}
@@ -143,9 +143,9 @@ func (d Date) SetDay(day int) Date {
 	return d
 }
 
-// Today returns the current date
+// Today returns the current date, as given by the Clock set with SetClock
 func Today() Date {
-	return Date{time.Now()}
+	return Date{currentClock().Now()}
 }
 
 // ParseDate returns a date from the given string value
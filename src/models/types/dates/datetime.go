@@ -69,9 +69,10 @@ func (d *DateTime) Scan(src interface{}) error {
 	return fmt.Errorf("DateTime data is not time.Time but %T", src)
 }
 
-// Now returns the current date/time with UTC timezone
+// Now returns the current date/time with UTC timezone, as given
+// by the Clock set with SetClock
 func Now() DateTime {
-	return DateTime{time.Now().UTC()}
+	return DateTime{currentClock().Now().UTC()}
 }
 
 // ParseDateTime returns a datetime from the given string value
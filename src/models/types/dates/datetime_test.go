@@ -119,5 +119,19 @@ func TestDateTime(t *testing.T) {
 			So(dateCpy.StartOfYear().Equal(ParseDateTime("2017-01-01 00:00:00")), ShouldBeTrue)
 			So(dateCpy.SetUnix(123456789).Equal(ParseDateTime("1973-11-29 21:33:09")), ShouldBeTrue)
 		})
+		Convey("Fake clock", func() {
+			clock := NewFakeClock(time.Date(2017, 8, 1, 10, 34, 23, 0, time.FixedZone("UTC+2", 2*3600)))
+			SetClock(clock)
+			defer SetClock(nil)
+			So(Now().Equal(ParseDateTime("2017-08-01 08:34:23")), ShouldBeTrue)
+			So(Now().Location(), ShouldEqual, time.UTC)
+			checkDate(Today())
+			clock.Advance(36 * time.Hour)
+			So(Now().Equal(ParseDateTime("2017-08-02 20:34:23")), ShouldBeTrue)
+			clock.Set(dateTime1.Time)
+			So(Now().Equal(dateTime1), ShouldBeTrue)
+			SetClock(nil)
+			So(Now().Sub(DateTime{time.Now()}), ShouldBeLessThan, time.Second)
+		})
 	})
 }
@@ -0,0 +1,51 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// UnlinkBatchSize is the number of records deleted in a single transaction
+// by UnlinkBatched when no chunk size is given.
+var UnlinkBatchSize = 1000
+
+// UnlinkBatched deletes the records of this RecordCollection by chunks of
+// chunkSize records (or UnlinkBatchSize if chunkSize is not positive), each
+// chunk in its own transaction, and returns the total number of deleted records.
+//
+// Each chunk is deleted with the Unlink method, so that access rights, record
+// rules and ondelete policies apply as for a single Unlink. If progress is not
+// nil, it is called after each chunk with the number of records deleted so far
+// and the number of records to delete.
+//
+// Since chunks are committed independently, an error in a chunk panics
+// without rolling back the previous chunks. UnlinkBatched must not be called
+// on records modified or locked by the transaction of this RecordCollection,
+// which is kept open and does not see the deletions until it commits.
+func (rc *RecordCollection) UnlinkBatched(chunkSize int, progress func(deleted, total int64)) int64 {
+	if chunkSize <= 0 {
+		chunkSize = UnlinkBatchSize
+	}
+	ids := rc.Ids()
+	var deleted int64
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var num int64
+		err := ExecuteInNewEnvironment(rc.env.uid, func(env Environment) {
+			num = env.Pool(rc.model.name).WithNewContext(rc.env.context).withIds(ids[start:end]).Call("Unlink").(int64)
+		})
+		if err != nil {
+			log.Panic("Error while unlinking records", "model", rc.model.name, "deleted", deleted, "error", err)
+		}
+		deleted += num
+		for _, id := range ids[start:end] {
+			rc.env.cache.invalidateRecord(rc.model, id)
+		}
+		rc.env.cache.invalidateSearches()
+		if progress != nil {
+			progress(deleted, int64(len(ids)))
+		}
+	}
+	return deleted
+}
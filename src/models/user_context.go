@@ -0,0 +1,46 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "github.com/hexya-erp/hexya/src/models/types"
+
+// A UserContextGetter returns the context values of the user with
+// the given id, such as "lang" and "tz".
+type UserContextGetter func(env Environment, uid int64) *types.Context
+
+// userContextGetter is the UserContextGetter used by Environment.WithUser
+var userContextGetter UserContextGetter
+
+// RegisterUserContextGetter sets the function used by Environment.WithUser
+// to resolve the context of a user.
+//
+// It is meant to be called by the module defining the users model.
+// Only one UserContextGetter can be registered. Registering a new one
+// replaces the previous one.
+func RegisterUserContextGetter(ucg UserContextGetter) {
+	userContextGetter = ucg
+}
+
+// WithUser returns a copy of this Environment bound to the user with
+// the given id, on the same transaction.
+//
+// The context values of the user (e.g. "lang" and "tz") are set into the
+// context of the returned Environment, if a UserContextGetter has been
+// registered. Contrary to Sudo, access rights and record rules of this
+// user apply.
+func (env Environment) WithUser(uid int64) Environment {
+	newEnv := env
+	newEnv.uid = uid
+	ctx := env.context.Copy()
+	if userContextGetter != nil {
+		userCtx := userContextGetter(env, uid)
+		if userCtx != nil {
+			for k, v := range userCtx.ToMap() {
+				ctx = ctx.WithKey(k, v)
+			}
+		}
+	}
+	newEnv.context = ctx
+	return newEnv
+}
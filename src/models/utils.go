@@ -16,6 +16,8 @@ package models
 
 import (
 	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/operator"
 )
 
 var (
@@ -23,6 +25,8 @@ var (
 	Testing bool
 	// ID is a FieldName that represents the PK of a model
 	ID = fieldName{name: "ID", json: "id"}
+	// UUID is a FieldName that represents the uuid primary key of UUID models
+	UUID = fieldName{name: "UUID", json: "uuid"}
 	// Name is a fieldName that represents the Name field of a model
 	Name = fieldName{name: "Name", json: "name"}
 )
@@ -94,6 +98,22 @@ func jsonizePath(mi *Model, path string) string {
 	return strings.Join(exprs, ExprSep)
 }
 
+// checkFieldPath panics if the given path of field names or JSON names
+// does not exist from this model.
+func (m *Model) checkFieldPath(path string) {
+	mi := m
+	for _, expr := range strings.Split(path, ExprSep) {
+		if mi == nil {
+			log.Panic("Field is not a relation in model", "model", m.name, "field", path)
+		}
+		fi, ok := mi.fields.Get(expr)
+		if !ok {
+			log.Panic("Unknown field in data", "model", m.name, "field", path)
+		}
+		mi = fi.relatedModel
+	}
+}
+
 // filterOnDBFields returns the given fields slice with only stored fields.
 // This function also adds the "id" field to the list if not present unless dontAddID is true
 func filterOnDBFields(mi *Model, fields []FieldName, dontAddID ...bool) []FieldName {
@@ -220,10 +240,32 @@ func consumeAndPredicates(i int, predicates []predicate, res []interface{}) ([]i
 // appendPredicateToSerial appends the given predicate to the given serialized
 // predicate list and returns the result.
 func appendPredicateToSerial(res []interface{}, predicate predicate) []interface{} {
-	if predicate.isCond {
+	switch {
+	case predicate.isCond:
 		res = append(res, serializePredicates(predicate.cond.predicates)...)
-	} else {
-		res = append(res, []interface{}{joinFieldNames(predicate.exprs, ExprSep).JSON(), predicate.operator, predicate.arg})
+	case predicate.rawSQL != "":
+		log.Panic("Raw SQL conditions cannot be serialized", "sql", predicate.rawSQL)
+	case predicate.aggregate != nil:
+		log.Panic("Aggregate conditions cannot be serialized", "field", joinFieldNames(predicate.exprs, ExprSep))
+	case predicate.quantifier != "":
+		res = append(res, []interface{}{joinFieldNames(predicate.exprs, ExprSep).JSON(), predicate.quantifier, predicate.subCond.Serialize()})
+	default:
+		arg := predicate.arg
+		if sub, ok := arg.(*RecordCollection); ok {
+			arg = sub.Ids()
+		}
+		field := joinFieldNames(predicate.exprs, ExprSep).JSON()
+		if predicate.datePart != "" {
+			field += ExprSep + string(predicate.datePart)
+		}
+		op := predicate.operator
+		if predicate.empty {
+			op = operator.IsSet
+			if predicate.operator == operator.Equals {
+				op = operator.IsEmpty
+			}
+		}
+		res = append(res, []interface{}{field, op, arg})
 	}
 	return res
 }
@@ -0,0 +1,138 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+)
+
+// newUUIDKeyField returns the UUID field holding the uuid primary key
+// of the given UUID model.
+func newUUIDKeyField(m *Model) *Field {
+	return &Field{
+		model:       m,
+		name:        "UUID",
+		description: "UUID",
+		json:        "uuid",
+		fieldType:   fieldtype.Char,
+		structField: reflect.StructField{Type: reflect.TypeOf("")},
+		noCopy:      true,
+		required:    true,
+		readOnly:    true,
+		uuidKey:     true,
+		defaultFunc: func(env Environment) interface{} {
+			return uuid.New().String()
+		},
+	}
+}
+
+// HasUUIDKey returns true if this model has been created with NewUUIDModel.
+//
+// The primary key of the table of such a model is its uuid column, which is
+// generated by the database if it is not given at creation. The int64 ids of
+// the records remain a unique key of the table, which is used by RecordSets,
+// the cache and the foreign keys of relation fields.
+func (m *Model) HasUUIDKey() bool {
+	return m.options&UUIDModel > 0
+}
+
+// checkUUIDKey panics if the model of this RecordCollection has no uuid primary key.
+func (rc *RecordCollection) checkUUIDKey() {
+	if !rc.model.HasUUIDKey() {
+		log.Panic("Model has no uuid primary key", "model", rc.model.name)
+	}
+}
+
+// UUIDs returns the uuid primary keys of the records of this RecordCollection,
+// in the same order as Ids. It panics if its model has no uuid primary key.
+func (rc *RecordCollection) UUIDs() []string {
+	rc.checkUUIDKey()
+	records := rc.Records()
+	res := make([]string, len(records))
+	for i, rec := range records {
+		res[i] = rec.Get(UUID).(string)
+	}
+	return res
+}
+
+// BrowseUUIDs returns a new RecordSet with the records with the given
+// uuid primary keys. It panics if this model has no uuid primary key.
+func (m *Model) BrowseUUIDs(env Environment, uuids []string) *RecordCollection {
+	return env.Pool(m.name).Call("BrowseUUIDs", uuids).(RecordSet).Collection()
+}
+
+// BrowseUUIDs returns a new RecordSet with the records with the given
+// uuid primary keys. It panics if this model has no uuid primary key.
+func commonMixinBrowseUUIDs(rc *RecordCollection, uuids []string) *RecordCollection {
+	rc.checkUUIDKey()
+	return rc.withoutSearchScope().Call("Search", rc.Model().Field(UUID).In(uuids)).(RecordSet).Collection()
+}
+
+// uuidKeyArg returns the uuid or the list of uuids given as argument of the given
+// predicate on the id of a UUID model or on a foreign key to a UUID model.
+// It returns false if the argument is not made of strings or if the operator
+// does not compare the field with its argument.
+func uuidKeyArg(fi *Field, op operator.Operator, arg interface{}) (interface{}, bool) {
+	switch {
+	case fi.name == ID.name && fi.model.HasUUIDKey():
+	case fi.fieldType.IsFKRelationType() && fi.relatedModel.HasUUIDKey():
+	default:
+		return nil, false
+	}
+	switch op {
+	case operator.Equals, operator.NotEquals:
+		str, ok := arg.(string)
+		return str, ok && str != ""
+	case operator.In, operator.NotIn:
+		switch val := arg.(type) {
+		case []string:
+			return val, len(val) > 0
+		case []interface{}:
+			res := make([]string, len(val))
+			for i, v := range val {
+				str, ok := v.(string)
+				if !ok {
+					return nil, false
+				}
+				res[i] = str
+			}
+			return res, len(res) > 0
+		}
+	}
+	return nil, false
+}
+
+// uuidKeySQLClause returns the sql WHERE clause and arguments for the given
+// predicate on the id of a UUID model or on a foreign key to a UUID model,
+// whose argument is the given uuid or list of uuids.
+//
+// Ids are compared with the uuid column of their table, and foreign keys with
+// the ids of the related records with the given uuids.
+func (q *Query) uuidKeySQLClause(p predicate, fi *Field, arg interface{}) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	op := operator.Equals
+	if p.operator == operator.In || p.operator == operator.NotIn {
+		op = operator.In
+	}
+	opSQL, _ := adapter.operatorSQL(op, arg)
+	var sql, field string
+	if fi.name == ID.name {
+		exprs := append(append([]FieldName{}, p.exprs[:len(p.exprs)-1]...), UUID)
+		field, _, _ = q.joinedFieldExpression(exprs, false, 0)
+		sql = fmt.Sprintf("%s %s", field, opSQL)
+	} else {
+		field, _, _ = q.joinedFieldExpression(p.exprs, false, 0)
+		sql = fmt.Sprintf("%s IN (SELECT id FROM %s WHERE uuid %s)", field,
+			adapter.quoteTableName(fi.relatedModel.tableName), opSQL)
+	}
+	if p.operator.IsNegative() {
+		sql = fmt.Sprintf("(%s IS NULL OR NOT (%s))", field, sql)
+	}
+	return sql, SQLParams{arg}
+}
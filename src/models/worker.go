@@ -5,6 +5,7 @@ package models
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -44,6 +45,10 @@ var (
 	workerFunctions []WorkerFunction
 	workerStop      chan struct{}
 	workerGroup     sync.WaitGroup
+	workerStopping  int32
+	// workerMu protects workerStop and workerStopping changes
+	// against goroutines added with GoWorker
+	workerMu sync.Mutex
 )
 
 // RegisterWorker registers a WorkerFunction so that it will be called by the core loop.
@@ -55,21 +60,25 @@ func RegisterWorker(wf WorkerFunction) {
 //
 // This function must be called only once or it will panic
 func RunWorkerLoop() {
+	workerMu.Lock()
+	defer workerMu.Unlock()
 	if workerStop != nil {
 		log.Panic("RunWorkerLoop must be called only once.")
 	}
-	workerStop = make(chan struct{})
+	stop := make(chan struct{})
+	workerStop = stop
+	atomic.StoreInt32(&workerStopping, 0)
 	for _, workerFunc := range workerFunctions {
 		workerGroup.Add(1)
 		go func(wf WorkerFunction) {
+			defer workerGroup.Done()
 			ticker := time.NewTicker(wf.LoopPeriod())
 			defer ticker.Stop()
 			for {
 				select {
 				case <-ticker.C:
 					wf.Run()
-				case <-workerStop:
-					workerGroup.Done()
+				case <-stop:
 					return
 				}
 			}
@@ -77,11 +86,50 @@ func RunWorkerLoop() {
 	}
 }
 
+// GoWorker executes fnct in a new goroutine that StopWorkerLoop waits for,
+// like a worker function run by the core loop. It is meant for work triggered
+// outside of the loop, such as jobs executed right after being enqueued.
+//
+// It returns false without executing fnct if the core worker loop is not
+// running or is stopping.
+func GoWorker(fnct func()) bool {
+	workerMu.Lock()
+	defer workerMu.Unlock()
+	if workerStop == nil || WorkerLoopStopping() {
+		return false
+	}
+	workerGroup.Add(1)
+	go func() {
+		defer workerGroup.Done()
+		fnct()
+	}()
+	return true
+}
+
 // StopWorkerLoop stops the hexya core worker loop.
 //
-// Calling this method if the core worker loop is not running will cause panic.
+// Worker functions are not run anymore, but the ones that are running,
+// including those started with GoWorker, are waited for. Calling this method
+// if the core worker loop is not running will cause panic.
 func StopWorkerLoop() {
+	workerMu.Lock()
+	if workerStop == nil {
+		workerMu.Unlock()
+		log.Panic("StopWorkerLoop called while the worker loop is not running.")
+	}
+	atomic.StoreInt32(&workerStopping, 1)
 	close(workerStop)
-	workerGroup.Wait()
 	workerStop = nil
+	workerMu.Unlock()
+	workerGroup.Wait()
+}
+
+// WorkerLoopStopping returns true if StopWorkerLoop has been called, until
+// the core worker loop is run again.
+//
+// Worker functions that process several items in a single run, such as
+// a job queue, should check it between items and return as soon as it is
+// true, so that the current item is finished but no new one is started.
+func WorkerLoopStopping() bool {
+	return atomic.LoadInt32(&workerStopping) == 1
 }
@@ -0,0 +1,206 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package params provides a global key/value store of configuration
+// parameters to Hexya.
+//
+// Parameters are stored in the ConfigParameter model and can be seeded
+// from modules data files (e.g. ConfigParameter.csv with Key and Value
+// columns). They are read with GetParam through an in-memory cache which
+// is invalidated each time a parameter is modified.
+package params
+
+import (
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+)
+
+// ParamModel is the name of the model storing the parameters
+const ParamModel = "ConfigParameter"
+
+var log logging.Logger
+
+// A param is a parameter value in cache
+type param struct {
+	value     string
+	protected bool
+}
+
+// paramsCache is the in-memory cache of the parameters.
+//
+// dirty holds the cursors of the transactions that modified parameters
+// and which are not terminated yet. These transactions read parameters
+// directly from the database since they may differ from the cache.
+var paramsCache = struct {
+	sync.RWMutex
+	data    map[string]param
+	version int
+	dirty   map[*models.Cursor]bool
+}{
+	dirty: make(map[*models.Cursor]bool),
+}
+
+func init() {
+	log = logging.GetLogger("params")
+	declareParamModel()
+}
+
+func declareParamModel() {
+	paramModel := models.NewModel(ParamModel)
+	paramModel.AddFields(map[string]models.FieldDefinition{
+		"Key":   fields.Char{Required: true, Unique: true, Index: true},
+		"Value": fields.Text{},
+		"Protected": fields.Boolean{
+			Help: "Protected parameters can only be read by the members of the admin group. Use it for secrets."},
+	})
+	paramModel.SetDefaultOrder("Key")
+
+	paramModel.Methods().MustGet("Create").Extend(
+		func(rc *models.RecordCollection, data models.RecordData) *models.RecordCollection {
+			invalidateCache(rc.Env())
+			return rc.Super().Call("Create", data).(models.RecordSet).Collection()
+		})
+	paramModel.Methods().MustGet("Write").Extend(
+		func(rc *models.RecordCollection, data models.RecordData) bool {
+			invalidateCache(rc.Env())
+			return rc.Super().Call("Write", data).(bool)
+		})
+	paramModel.Methods().MustGet("Unlink").Extend(
+		func(rc *models.RecordCollection) int64 {
+			invalidateCache(rc.Env())
+			return rc.Super().Call("Unlink").(int64)
+		})
+
+	paramModel.AddRecordRule(&models.RecordRule{
+		Name:      "params_public",
+		Group:     security.GroupEveryone,
+		Condition: paramModel.Field(paramModel.FieldName("Protected")).Equals(false),
+		Perms:     security.All,
+	})
+	paramModel.AddRecordRule(&models.RecordRule{
+		Name:      "params_admin",
+		Group:     security.GroupAdmin,
+		Condition: paramModel.Field(models.ID).IsNotNull(),
+		Perms:     security.All,
+	})
+
+	paramModel.NewMethod("GetParam", paramGetParam)
+	paramModel.NewMethod("SetParam", paramSetParam)
+}
+
+// GetParam returns the value of the parameter with the given key,
+// or defaultValue if it does not exist or if it is protected, and the
+// current user is not a member of the admin group.
+func paramGetParam(rc *models.RecordCollection, key, defaultValue string) string {
+	return GetParam(rc.Env(), key, defaultValue)
+}
+
+// SetParam sets the value of the parameter with the given key, creating it if needed.
+func paramSetParam(rc *models.RecordCollection, key, value string) {
+	SetParam(rc.Env(), key, value)
+}
+
+// invalidateCache clears the parameters cache and marks the transaction
+// of env as dirty until it is committed or rolled back.
+func invalidateCache(env models.Environment) {
+	paramsCache.Lock()
+	defer paramsCache.Unlock()
+	cr := env.Cr()
+	if !paramsCache.dirty[cr] {
+		paramsCache.dirty[cr] = true
+		env.AfterCommit(func() { endTransaction(cr) })
+		env.AfterRollback(func() { endTransaction(cr) })
+	}
+	paramsCache.data = nil
+	paramsCache.version++
+}
+
+// endTransaction clears the parameters cache once the given
+// dirty transaction has been terminated.
+func endTransaction(cr *models.Cursor) {
+	paramsCache.Lock()
+	defer paramsCache.Unlock()
+	delete(paramsCache.dirty, cr)
+	paramsCache.data = nil
+	paramsCache.version++
+}
+
+// readParams reads all parameters from the database in the given environment.
+func readParams(env models.Environment) map[string]param {
+	res := make(map[string]param)
+	paramRC := env.Pool(ParamModel).Sudo()
+	mdl := paramRC.Model()
+	for _, rec := range paramRC.SearchAll().Records() {
+		res[rec.Get(mdl.FieldName("Key")).(string)] = param{
+			value:     rec.Get(mdl.FieldName("Value")).(string),
+			protected: rec.Get(mdl.FieldName("Protected")).(bool),
+		}
+	}
+	return res
+}
+
+// getParams returns all the parameters as seen by the transaction of env.
+//
+// Parameters are read from the cache, which is loaded in a new transaction
+// if needed so that it only holds committed values. Transactions that
+// modified parameters read them directly instead.
+func getParams(env models.Environment) map[string]param {
+	paramsCache.RLock()
+	data, version, dirty := paramsCache.data, paramsCache.version, paramsCache.dirty[env.Cr()]
+	paramsCache.RUnlock()
+	switch {
+	case dirty:
+		return readParams(env)
+	case data != nil:
+		return data
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(newEnv models.Environment) {
+		data = readParams(newEnv)
+	})
+	if err != nil {
+		log.Panic("Unable to load configuration parameters", "error", err)
+	}
+	paramsCache.Lock()
+	defer paramsCache.Unlock()
+	if paramsCache.version == version {
+		// Parameters have not been modified while we were loading them
+		paramsCache.data = data
+	}
+	return data
+}
+
+// GetParam returns the value of the parameter with the given key, or
+// defaultValue if it does not exist.
+//
+// Protected parameters are only returned to the members of the admin group,
+// defaultValue is returned to other users.
+func GetParam(env models.Environment, key, defaultValue string) string {
+	p, ok := getParams(env)[key]
+	if !ok {
+		return defaultValue
+	}
+	if p.protected && !env.HasGroup(security.GroupAdminID) {
+		log.Debug("Protected parameter requested by non admin user", "key", key, "uid", env.Uid())
+		return defaultValue
+	}
+	return p.value
+}
+
+// SetParam sets the value of the parameter with the given key in the
+// given environment, creating the parameter if it does not exist.
+//
+// Access rights of the user of env apply, so that only the members of
+// the admin group can modify protected parameters.
+func SetParam(env models.Environment, key, value string) {
+	paramRC := env.Pool(ParamModel)
+	mdl := paramRC.Model()
+	// WriteOrCreate may insert the parameter without calling Create
+	invalidateCache(env)
+	paramRC.Call("WriteOrCreate",
+		models.NewModelData(mdl).Set(mdl.FieldName("Key"), key),
+		models.NewModelData(mdl).Set(mdl.FieldName("Value"), value))
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package params
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetParam(t *testing.T) {
+	paramsCache.data = map[string]param{
+		"web.base.url": {value: "https://example.com"},
+		"api.secret":   {value: "s3cr3t", protected: true},
+	}
+	Convey("Testing parameters cache", t, func() {
+		env := models.Environment{}
+		Convey("Cached parameters are returned", func() {
+			So(GetParam(env, "web.base.url", ""), ShouldEqual, "https://example.com")
+		})
+		Convey("Missing parameters return the default value", func() {
+			So(GetParam(env, "mail.from", "hexya@example.com"), ShouldEqual, "hexya@example.com")
+		})
+		Convey("Protected parameters are hidden to non admin users", func() {
+			So(env.HasGroup(security.GroupAdminID), ShouldBeFalse)
+			So(GetParam(env, "api.secret", "hidden"), ShouldEqual, "hidden")
+		})
+		Convey("Terminating a dirty transaction clears the cache", func() {
+			version := paramsCache.version
+			paramsCache.dirty[nil] = true
+			endTransaction(nil)
+			So(paramsCache.data, ShouldBeNil)
+			So(paramsCache.dirty, ShouldBeEmpty)
+			So(paramsCache.version, ShouldEqual, version+1)
+		})
+	})
+}
+
+func TestParamsDatabase(t *testing.T) {
+	getParam := func(uid int64, key string) (value string) {
+		So(models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			value = GetParam(env, key, "default")
+		}), ShouldBeNil)
+		return
+	}
+	Convey("Testing parameters stored in the database", t, func() {
+		endTransaction(nil)
+		Convey("Committed parameters are read and cached", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				SetParam(env, "test.base.url", "https://hexya.example.com")
+				So(GetParam(env, "test.base.url", ""), ShouldEqual, "https://hexya.example.com")
+			}), ShouldBeNil)
+			So(paramsCache.dirty, ShouldBeEmpty)
+			So(paramsCache.data, ShouldBeNil)
+			So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "https://hexya.example.com")
+			So(paramsCache.data, ShouldContainKey, "test.base.url")
+			So(getParam(security.SuperUserID, "test.missing"), ShouldEqual, "default")
+		})
+		Convey("Setting an existing parameter updates it", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				env.Pool(ParamModel).Call("SetParam", "test.base.url", "https://www.example.com")
+				So(env.Pool(ParamModel).Call("GetParam", "test.base.url", "").(string), ShouldEqual, "https://www.example.com")
+			}), ShouldBeNil)
+			So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "https://www.example.com")
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rc := env.Pool(ParamModel)
+				So(rc.Search(rc.Model().Field(rc.Model().FieldName("Key")).Equals("test.base.url")).SearchCount(), ShouldEqual, 1)
+			}), ShouldBeNil)
+		})
+		Convey("Uncommitted parameters are only seen by their transaction", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				SetParam(env, "test.base.url", "https://draft.example.com")
+				So(GetParam(env, "test.base.url", ""), ShouldEqual, "https://draft.example.com")
+				So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "https://www.example.com")
+				So(paramsCache.dirty, ShouldHaveLength, 1)
+			}), ShouldBeNil)
+			So(paramsCache.dirty, ShouldBeEmpty)
+			So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "https://www.example.com")
+		})
+		Convey("Deleting a parameter clears the cache", func() {
+			So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "https://www.example.com")
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rc := env.Pool(ParamModel)
+				rc.Search(rc.Model().Field(rc.Model().FieldName("Key")).Equals("test.base.url")).Call("Unlink")
+			}), ShouldBeNil)
+			So(getParam(security.SuperUserID, "test.base.url"), ShouldEqual, "default")
+		})
+		Convey("Protected parameters are only returned to admins", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rc := env.Pool(ParamModel)
+				rc.Call("Create", models.NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("Key"), "test.api.secret").
+					Set(rc.Model().FieldName("Value"), "s3cr3t").
+					Set(rc.Model().FieldName("Protected"), true))
+			}), ShouldBeNil)
+			So(getParam(security.SuperUserID, "test.api.secret"), ShouldEqual, "s3cr3t")
+			So(getParam(2, "test.api.secret"), ShouldEqual, "default")
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rc := env.Pool(ParamModel)
+				rc.Search(rc.Model().Field(rc.Model().FieldName("Key")).Equals("test.api.secret")).Call("Unlink")
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package precision provides configurable decimal precisions to Hexya.
+//
+// A decimal precision maps a usage name such as "Product Price" or "Account"
+// to a number of digits after the decimal point. Float fields reference a usage
+// through their Precision parameter and the ORM rounds their values accordingly
+// on write and in aggregations.
+//
+// Precisions are records of the DecimalPrecision model. They are typically loaded
+// by modules from a DecimalPrecision.csv data file, so that they can be changed
+// afterwards without modifying the code.
+package precision
+
+import (
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/tools/logging"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+var log logging.Logger
+
+// DefaultDigits is the number of digits of a new DecimalPrecision
+// if none is given.
+const DefaultDigits = 2
+
+// cache holds the digits of the precision usages that have already been read from the database.
+//
+// modified holds the cursors of the transactions that have modified precisions. These
+// transactions do not use the cache, which is cleared once they are committed.
+var cache struct {
+	sync.RWMutex
+	digits   map[string]int8
+	modified map[*models.Cursor]bool
+}
+
+// clearCache empties the precisions cache.
+func clearCache() {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.digits = make(map[string]int8)
+}
+
+// invalidateCache is called when precisions are modified in the transaction of env.
+// The cache is not used by this transaction anymore and is cleared after commit,
+// so that other transactions never cache values that are not committed.
+func invalidateCache(env models.Environment) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.modified[env.Cr()] {
+		return
+	}
+	cache.modified[env.Cr()] = true
+	cr := env.Cr()
+	env.AfterCommit(func() {
+		cache.Lock()
+		defer cache.Unlock()
+		delete(cache.modified, cr)
+		cache.digits = make(map[string]int8)
+	})
+	env.AfterRollback(func() {
+		cache.Lock()
+		defer cache.Unlock()
+		delete(cache.modified, cr)
+	})
+}
+
+// Get returns the number of digits after the decimal point of the given precision usage.
+//
+// The second returned value is false if there is no DecimalPrecision with this usage name.
+// Precisions are read as superuser, since they apply to all users.
+func Get(env models.Environment, usage string) (int8, bool) {
+	cache.RLock()
+	digits, ok := cache.digits[usage]
+	useCache := !cache.modified[env.Cr()]
+	cache.RUnlock()
+	if ok && useCache {
+		return digits, true
+	}
+	rc := env.Pool("DecimalPrecision").Sudo()
+	rec := rc.Search(rc.Model().Field(rc.Model().FieldName("Name")).Equals(usage)).Limit(1)
+	if rec.IsEmpty() {
+		return 0, false
+	}
+	digits = int8(rec.Get(rc.Model().FieldName("Digits")).(int64))
+	if !useCache {
+		return digits, true
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	cache.digits[usage] = digits
+	return digits, true
+}
+
+func init() {
+	log = logging.GetLogger("precision")
+	clearCache()
+	cache.modified = make(map[*models.Cursor]bool)
+	declarePrecisionModel()
+	models.RegisterPrecisionGetter(Get)
+}
+
+func declarePrecisionModel() {
+	precisionModel := models.NewModel("DecimalPrecision")
+	precisionModel.AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{String: "Usage", Required: true, Unique: true, Index: true,
+			Help: "Name of the usage of this precision, referenced by the Precision parameter of float fields"},
+		"Digits": fields.Integer{Required: true, Default: models.DefaultValue(DefaultDigits),
+			Help: "Number of digits after the decimal point"},
+	})
+
+	precisionModel.Methods().MustGet("Create").Extend(decimalPrecisionCreate)
+	precisionModel.Methods().MustGet("Write").Extend(decimalPrecisionWrite)
+	precisionModel.Methods().MustGet("Unlink").Extend(decimalPrecisionUnlink)
+}
+
+// Create a new decimal precision and clear the precisions cache after commit.
+func decimalPrecisionCreate(rc *models.RecordCollection, data models.RecordData) *models.RecordCollection {
+	invalidateCache(rc.Env())
+	return rc.Super().Call("Create", data).(models.RecordSet).Collection()
+}
+
+// Write the given data to these decimal precisions and clear the precisions cache after commit.
+//
+// A warning is logged when the digits of a precision are changed, since values
+// already stored in the database are not rounded again.
+func decimalPrecisionWrite(rc *models.RecordCollection, data models.RecordData) bool {
+	digitsField := rc.Model().FieldName("Digits")
+	if data.Underlying().Has(digitsField) {
+		newDigits, err := nbutils.CastToInteger(data.Underlying().Get(digitsField))
+		if err != nil {
+			log.Panic("Invalid digits value for decimal precision", "precision", rc, "error", err)
+		}
+		for _, rec := range rc.Records() {
+			if oldDigits := rec.Get(digitsField).(int64); oldDigits != newDigits {
+				log.Warn("Decimal precision changed: existing data is not re-rounded", "usage", rec.Get(rc.Model().FieldName("Name")),
+					"oldDigits", oldDigits, "newDigits", newDigits)
+			}
+		}
+	}
+	invalidateCache(rc.Env())
+	return rc.Super().Call("Write", data).(bool)
+}
+
+// Unlink these decimal precisions and clear the precisions cache after commit.
+func decimalPrecisionUnlink(rc *models.RecordCollection) int64 {
+	invalidateCache(rc.Env())
+	return rc.Super().Call("Unlink").(int64)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package precision
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrecisions(t *testing.T) {
+	getDigits := func(uid int64, usage string) (digits int8, ok bool) {
+		So(models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			digits, ok = Get(env, usage)
+		}), ShouldBeNil)
+		return
+	}
+	var price models.RecordSet
+	Convey("Testing decimal precisions", t, func() {
+		Convey("Unknown usages have no precision", func() {
+			_, ok := getDigits(security.SuperUserID, "Test Price")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Creating a precision", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rc := env.Pool("DecimalPrecision")
+				price = rc.Call("Create", models.NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("Name"), "Test Price").
+					Set(rc.Model().FieldName("Digits"), int64(3))).(models.RecordSet)
+			}), ShouldBeNil)
+			digits, ok := getDigits(security.SuperUserID, "Test Price")
+			So(ok, ShouldBeTrue)
+			So(digits, ShouldEqual, 3)
+			So(cache.digits, ShouldContainKey, "Test Price")
+		})
+		Convey("Precisions are read as superuser", func() {
+			digits, ok := getDigits(2, "Test Price")
+			So(ok, ShouldBeTrue)
+			So(digits, ShouldEqual, 3)
+		})
+		Convey("Uncommitted changes are only seen by their transaction", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rec := price.Collection().WithEnv(env)
+				rec.Call("Write", models.NewModelData(rec.Model()).Set(rec.Model().FieldName("Digits"), int64(4)))
+				digits, _ := Get(env, "Test Price")
+				So(digits, ShouldEqual, 4)
+				otherDigits, _ := getDigits(security.SuperUserID, "Test Price")
+				So(otherDigits, ShouldEqual, 3)
+				So(cache.digits["Test Price"], ShouldEqual, 3)
+			}), ShouldBeNil)
+			digits, _ := getDigits(security.SuperUserID, "Test Price")
+			So(digits, ShouldEqual, 3)
+			So(cache.modified, ShouldBeEmpty)
+		})
+		Convey("The cache is cleared once changes are committed", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rec := price.Collection().WithEnv(env)
+				rec.Call("Write", models.NewModelData(rec.Model()).Set(rec.Model().FieldName("Digits"), int64(4)))
+				otherDigits, _ := getDigits(security.SuperUserID, "Test Price")
+				So(otherDigits, ShouldEqual, 3)
+			}), ShouldBeNil)
+			So(cache.digits, ShouldNotContainKey, "Test Price")
+			digits, _ := getDigits(security.SuperUserID, "Test Price")
+			So(digits, ShouldEqual, 4)
+		})
+		Convey("Deleted precisions are removed from the cache", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				price.Collection().WithEnv(env).Call("Unlink")
+			}), ShouldBeNil)
+			_, ok := getDigits(security.SuperUserID, "Test Price")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/hweb"
+	"github.com/spf13/viper"
 )
 
 // The Context allows to pass data across controller layers
@@ -34,7 +35,7 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 		id = req.ID
 	}
 	if len(err) > 0 && err[0] != nil {
-		userError, ok2 := err[0].(exceptions.UserError)
+		hexyaError, ok2 := err[0].(exceptions.Error)
 		if !ok2 {
 			c.AbortWithError(http.StatusInternalServerError, errors.New("error is of unknown type"))
 			return
@@ -42,15 +43,7 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 		respErr := ResponseError{
 			JsonRPC: "2.0",
 			ID:      id.(int64),
-			Error: JSONRPCError{
-				Code:    code,
-				Message: "Hexya Server Error",
-				Data: JSONRPCErrorData{
-					Arguments:     []string{userError.Message},
-					ExceptionType: "user_error",
-					Debug:         userError.Debug,
-				},
-			},
+			Error:   newJSONRPCError(code, hexyaError),
 		}
 		c.JSON(code, respErr)
 		return
@@ -63,6 +56,35 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 	c.JSON(code, resp)
 }
 
+// genericErrorMessages are the messages sent to the client instead
+// of the actual error messages when the server is not in debug mode.
+var genericErrorMessages = map[string]string{
+	exceptions.ValidationErrorCode:  "The operation cannot be completed: the data is not valid.",
+	exceptions.AccessErrorCode:      "You are not allowed to perform this operation.",
+	exceptions.MissingErrorCode:     "The requested records do not exist or have been deleted.",
+	exceptions.ConcurrencyErrorCode: exceptions.ConcurrencyErrorMessage,
+}
+
+// newJSONRPCError returns the JSONRPCError to send to the client for the given error.
+//
+// The message of a UserError is always sent as is. For other errors, a generic
+// message is sent without debug information unless the server is in debug mode.
+func newJSONRPCError(code int, err exceptions.Error) JSONRPCError {
+	message, debug := err.UserMessage(), err.DebugInfo()
+	if generic, ok := genericErrorMessages[err.Code()]; ok && !viper.GetBool("Debug") {
+		message, debug = generic, ""
+	}
+	return JSONRPCError{
+		Code:    code,
+		Message: "Hexya Server Error",
+		Data: JSONRPCErrorData{
+			Arguments:     []string{message},
+			ExceptionType: err.Code(),
+			Debug:         debug,
+		},
+	}
+}
+
 // BindRPCParams binds the RPC parameters to the given data object.
 func (c *Context) BindRPCParams(data interface{}) {
 	var req RequestRPC
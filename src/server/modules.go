@@ -26,6 +26,7 @@ import (
 	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/menus"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/templates"
 	"github.com/hexya-erp/hexya/src/views"
 )
@@ -37,9 +38,11 @@ var ResourceDir string
 // A Module is a go package that implements business features.
 // This struct is used to register modules.
 type Module struct {
-	Name     string
-	PreInit  func() // Function to be run before bootstrap but after all calls to init
-	PostInit func() // Function to be run after initialisation is complete and before server starts
+	Name          string
+	Depends       []string                 // Names of the modules this module depends on
+	PreInit       func()                   // Function to be run before bootstrap but after all calls to init
+	PostInit      func()                   // Function to be run after initialisation is complete and before server starts
+	UninstallHook func(models.Environment) // Function to be run when the module is uninstalled, before its data is removed
 }
 
 // A ModulesList is a list of Module objects
@@ -60,7 +63,15 @@ var Modules ModulesList
 // RegisterModule registers the given module in the server
 // This function should be called in the init() function of
 // all Hexya Addons.
+//
+// The modules listed in the Depends field of mod must have been registered
+// before, which is the case if they are imported by the package of mod.
 func RegisterModule(mod *Module) {
+	for _, dep := range mod.Depends {
+		if Modules.get(dep) == nil {
+			log.Panic("Module dependency is not registered, did you import it?", "module", mod.Name, "dependency", dep)
+		}
+	}
 	Modules = append(Modules, mod)
 }
 
@@ -125,23 +136,28 @@ func LoadModuleTranslations(i18nDir string, langs []string) {
 // using the loader function.
 func loadData(resourceDir, dir, ext string, loader func(string)) {
 	for _, mod := range Modules {
-		dataDir := filepath.Join(resourceDir, dir, mod.Name)
-		if _, err := os.Stat(dataDir); err != nil {
-			// No resources dir in this module
-			continue
-		}
-		dataFiles, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dataDir, ext))
-		if err != nil {
-			log.Panic("Unable to scan directory for data files", "dir", dataDir, "type", ext, "error", err)
-		}
-		dataFilesSorted := sort.StringSlice(dataFiles)
-		dataFilesSorted.Sort()
-		for _, dataFile := range dataFilesSorted {
+		for _, dataFile := range moduleDataFiles(resourceDir, dir, ext, mod.Name) {
 			loader(dataFile)
 		}
 	}
 }
 
+// moduleDataFiles returns the sorted list of files with the given extension
+// (without .) in the given dir of the given module in resourceDir.
+func moduleDataFiles(resourceDir, dir, ext, moduleName string) []string {
+	dataDir := filepath.Join(resourceDir, dir, moduleName)
+	if _, err := os.Stat(dataDir); err != nil {
+		// No resources dir in this module
+		return nil
+	}
+	dataFiles, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dataDir, ext))
+	if err != nil {
+		log.Panic("Unable to scan directory for data files", "dir", dataDir, "type", ext, "error", err)
+	}
+	sort.Strings(dataFiles)
+	return dataFiles
+}
+
 // loadXMLResourceFile loads the data from an XML data file into memory.
 func loadXMLResourceFile(fileName string) {
 	doc := etree.NewDocument()
@@ -159,6 +175,8 @@ func loadXMLResourceFile(fileName string) {
 				menus.LoadFromEtree(object)
 			case "template":
 				templates.LoadFromEtree(object)
+			case "group":
+				security.LoadFromEtree(object)
 			default:
 				log.Panic("Unknown XML tag", "filename", fileName, "tag", object.Tag)
 			}
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"path/filepath"
+	"sync"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -33,6 +34,8 @@ import (
 // It is internally a wrapper around a gin.Engine
 type Server struct {
 	*gin.Engine
+	httpServers   []*http.Server
+	httpServersMu sync.Mutex
 }
 
 // Group creates a new router group. You should add all the routes that have common middlwares or the same path prefix.
@@ -45,30 +48,33 @@ func (s *Server) Group(relativePath string, handlers ...HandlerFunc) *RouterGrou
 
 // Run attaches the router to a http.Server and starts listening and serving HTTP requests.
 // It is a shortcut for http.ListenAndServe(addr, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine indefinitely unless an error happens
+// or Shutdown is called, in which case it returns http.ErrServerClosed.
 func (s *Server) Run(addr string) (err error) {
 	defer func() { log.Error("HTTP server stopped", "error", err) }()
 
 	log.Info("Hexya is up and running HTTP", "address", addr)
-	err = http.ListenAndServe(addr, s)
+	err = s.newHTTPServer(addr, s).ListenAndServe()
 	return
 }
 
 // RunTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests.
 // It is a shortcut for http.ListenAndServeTLS(addr, certFile, keyFile, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine indefinitely unless an error happens
+// or Shutdown is called, in which case it returns http.ErrServerClosed.
 func (s *Server) RunTLS(addr string, certFile string, keyFile string) (err error) {
 	defer func() { log.Error("HTTPS server stopped", err) }()
 
 	log.Info("Hexya is up and running HTTPS", "address", addr, "cert", certFile, "key", keyFile)
-	err = http.ListenAndServeTLS(addr, certFile, keyFile, s)
+	err = s.newHTTPServer(addr, s).ListenAndServeTLS(certFile, keyFile)
 	return
 }
 
 // RunAutoTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests on port 443
 // for all interfaces.
 // It automatically gets certificate for the given domain from Letsencrypt.
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine indefinitely unless an error happens
+// or Shutdown is called, in which case it returns http.ErrServerClosed.
 func (s *Server) RunAutoTLS(domain string) (err error) {
 	defer func() { log.Error("HTTPS server stopped", err) }()
 
@@ -80,12 +86,9 @@ func (s *Server) RunAutoTLS(domain string) (err error) {
 		Prompt:     autocert.AcceptTOS,
 		HostPolicy: autocert.HostWhitelist(domain),
 	}
-	go http.ListenAndServe(":http", m.HTTPHandler(nil))
-	srv := &http.Server{
-		Addr:      ":https",
-		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
-		Handler:   s,
-	}
+	go s.newHTTPServer(":http", m.HTTPHandler(nil)).ListenAndServe()
+	srv := s.newHTTPServer(":https", s)
+	srv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
 	err = srv.ListenAndServeTLS("", "")
 	return
 }
@@ -140,7 +143,7 @@ func init() {
 	log = logging.GetLogger("server")
 	// Set to ReleaseMode now for tests and is overridden later (hexya/cmd/server.go)
 	gin.SetMode(gin.ReleaseMode)
-	hexyaServer = &Server{gin.New()}
+	hexyaServer = &Server{Engine: gin.New()}
 	store := cookie.NewStore([]byte(">r&5#5T/sG-jnf=EW8$(WQX'-m2R6Gk*^qqr`CxEtG'wQ[/'G@`NYn^on?b!4G`9"),
 		[]byte("!WY9Q|}09!4Ke=@w0HS|]$u,p1f^k(5T"))
 	hexyaServer.Use(gin.Recovery())
@@ -0,0 +1,74 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/models"
+)
+
+// newHTTPServer returns a new http.Server serving this Server on the given
+// address, registered so that it is stopped by Shutdown.
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	s.httpServersMu.Lock()
+	defer s.httpServersMu.Unlock()
+	s.httpServers = append(s.httpServers, srv)
+	return srv
+}
+
+// Shutdown gracefully stops the hexya server:
+//
+// - It stops accepting new requests and waits for the requests being
+// handled to finish, so that their transactions are committed or rolled back.
+//
+// - It stops the core worker loop, waiting for the running worker functions
+// such as scheduled jobs to finish. Worker functions processing several items
+// can check models.WorkerLoopStopping to return after the current item.
+//
+// - It closes the database connection pool.
+//
+// If ctx expires before requests or worker functions are finished, Shutdown
+// returns the context's error without waiting any longer and the database is
+// not closed. Shutdown is meant to be called when the process receives a
+// termination signal, and the worker loop must be running.
+func Shutdown(ctx context.Context) error {
+	log.Info("Shutting down server")
+	if err := hexyaServer.shutdownHTTPServers(ctx); err != nil {
+		return err
+	}
+	log.Info("HTTP requests finished, stopping workers")
+	workersStopped := make(chan struct{})
+	go func() {
+		models.StopWorkerLoop()
+		close(workersStopped)
+	}()
+	select {
+	case <-workersStopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	models.DBClose()
+	log.Info("Server shut down successfully")
+	return nil
+}
+
+// shutdownHTTPServers gracefully shuts down the http servers of this Server.
+func (s *Server) shutdownHTTPServers(ctx context.Context) error {
+	s.httpServersMu.Lock()
+	servers := s.httpServers
+	s.httpServers = nil
+	s.httpServersMu.Unlock()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
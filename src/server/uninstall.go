@@ -0,0 +1,142 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// UninstalledModuleModel is the name of the model recording the modules
+// whose data has been removed by UninstallModules.
+const UninstalledModuleModel = "UninstalledModule"
+
+func init() {
+	models.NewModel(UninstalledModuleModel).AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{Required: true, Unique: true},
+	})
+}
+
+// UninstallModules removes from the database the data of the modules with the
+// given names, in a single transaction. For each module, in the reverse order
+// of the modules registration, i.e. dependents first:
+// - its UninstallHook is run,
+// - the records of its demo and data files in resourceDir are deleted,
+// files being processed in reverse order.
+//
+// Update data files, whose name ends with "_update", are skipped since they
+// modify records created by other modules.
+//
+// It returns an error without changing anything if one of the modules is not
+// registered, or if another registered module depends on one of them, unless
+// this other module is uninstalled too.
+//
+// Models and fields, as well as views, actions, menus and access rules are
+// defined by the code and the resources of the modules, and are not removed.
+// The modules are instead recorded as uninstalled: they must then be removed
+// from the project, and `hexya updatedb` drops the tables and columns of their
+// models and fields. Until then, CheckUninstalledModules returns an error.
+func UninstallModules(resourceDir string, names ...string) error {
+	modules, err := modulesToUninstall(names)
+	if err != nil {
+		return err
+	}
+	return models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		uninstalledRC := env.Pool(UninstalledModuleModel)
+		nameField := uninstalledRC.Model().FieldName("Name")
+		for _, mod := range modules {
+			log.Info("Uninstalling module", "module", mod.Name)
+			if mod.UninstallHook != nil {
+				mod.UninstallHook(env)
+			}
+			for _, dir := range []string{"demo", "data"} {
+				dataFiles := moduleDataFiles(resourceDir, dir, "csv", mod.Name)
+				for i := len(dataFiles) - 1; i >= 0; i-- {
+					models.UnlinkCSVDataRecords(env, dataFiles[i])
+				}
+			}
+			uninstalledRC.Call("WriteOrCreate",
+				models.NewModelData(uninstalledRC.Model()).Set(nameField, mod.Name),
+				models.NewModelData(uninstalledRC.Model()))
+		}
+	})
+}
+
+// CheckUninstalledModules returns an error if modules of the project have been
+// uninstalled by UninstallModules, since their models, views, menus and access
+// rights are still defined and their data would be loaded again.
+//
+// If forgetRemoved is true, the modules that have been removed from the project
+// since they have been uninstalled are forgotten, so that they can be installed
+// again. This is meant to be done once the database has been updated.
+func CheckUninstalledModules(forgetRemoved bool) error {
+	var remaining []string
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		uninstalledRC := env.Pool(UninstalledModuleModel)
+		nameField := uninstalledRC.Model().FieldName("Name")
+		for _, rec := range uninstalledRC.SearchAll().Records() {
+			name := rec.Get(nameField).(string)
+			switch {
+			case Modules.get(name) != nil:
+				remaining = append(remaining, name)
+			case forgetRemoved:
+				rec.Call("Unlink")
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("modules %s have been uninstalled: remove them from the project and run 'hexya updatedb'",
+			strings.Join(remaining, ", "))
+	}
+	return nil
+}
+
+// modulesToUninstall returns the registered modules with the given names, in
+// the reverse order of their registration. It returns an error if one of them
+// is not registered, or if another module depends on one of them.
+func modulesToUninstall(names []string) (ModulesList, error) {
+	toUninstall := make(map[string]bool)
+	for _, name := range names {
+		toUninstall[name] = true
+	}
+	var modules ModulesList
+	for i := len(Modules) - 1; i >= 0; i-- {
+		mod := Modules[i]
+		if toUninstall[mod.Name] {
+			modules = append(modules, mod)
+			continue
+		}
+		for _, dep := range mod.Depends {
+			if toUninstall[dep] {
+				return nil, fmt.Errorf("module %s depends on module %s and must be uninstalled too", mod.Name, dep)
+			}
+		}
+	}
+	if len(modules) != len(toUninstall) {
+		for name := range toUninstall {
+			if modules.get(name) == nil {
+				return nil, fmt.Errorf("unknown module %s", name)
+			}
+		}
+	}
+	return modules, nil
+}
+
+// get returns the module with the given name in this ModulesList, or nil if
+// there is none.
+func (ml *ModulesList) get(name string) *Module {
+	for _, mod := range *ml {
+		if mod.Name == name {
+			return mod
+		}
+	}
+	return nil
+}
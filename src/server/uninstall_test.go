@@ -0,0 +1,112 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tests"
+	_ "github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testRecordModel = "UninstallTestRecord"
+
+// uninstalled lists the modules whose UninstallHook has been run
+var uninstalled []string
+
+func init() {
+	models.NewModel(testRecordModel).AddFields(map[string]models.FieldDefinition{
+		"Name": fields.Char{},
+	})
+	for _, mod := range []*server.Module{
+		{Name: "uninstallbase"},
+		{Name: "uninstalldep", Depends: []string{"uninstallbase"}},
+	} {
+		name := mod.Name
+		mod.UninstallHook = func(models.Environment) {
+			uninstalled = append(uninstalled, name)
+		}
+		server.RegisterModule(mod)
+	}
+}
+
+func TestMain(m *testing.M) {
+	tests.RunTests(m, "server", nil)
+}
+
+func TestUninstallModules(t *testing.T) {
+	resourceDir := "testdata"
+	testRecords := func() []string {
+		var names []string
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			rs := env.Pool(testRecordModel).SearchAll().OrderBy("ID")
+			for _, rec := range rs.Records() {
+				names = append(names, rec.Get(rs.Model().FieldName("Name")).(string))
+			}
+		}), ShouldBeNil)
+		return names
+	}
+	uninstalledModules := func() []string {
+		var names []string
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			rs := env.Pool(server.UninstalledModuleModel).SearchAll().OrderBy("Name")
+			for _, rec := range rs.Records() {
+				names = append(names, rec.Get(rs.Model().FieldName("Name")).(string))
+			}
+		}), ShouldBeNil)
+		return names
+	}
+	Convey("Testing modules uninstallation", t, func() {
+		models.LoadCSVDataFile(filepath.Join(resourceDir, "data", "uninstallbase", "UninstallTestRecord.csv"))
+		models.LoadCSVDataFile(filepath.Join(resourceDir, "data", "uninstalldep", "UninstallTestRecord_update.csv"))
+		So(testRecords(), ShouldResemble, []string{"Updated Record", "Second Record"})
+		Convey("Unknown modules cannot be uninstalled", func() {
+			So(server.UninstallModules(resourceDir, "uninstallbase", "unknown"), ShouldNotBeNil)
+			So(uninstalled, ShouldBeEmpty)
+		})
+		Convey("Modules cannot be uninstalled without the modules depending on them", func() {
+			So(server.UninstallModules(resourceDir, "uninstallbase"), ShouldNotBeNil)
+			So(uninstalled, ShouldBeEmpty)
+			So(testRecords(), ShouldHaveLength, 2)
+		})
+		Convey("Update files do not remove the records of other modules", func() {
+			So(server.UninstallModules(resourceDir, "uninstalldep"), ShouldBeNil)
+			So(uninstalled, ShouldResemble, []string{"uninstalldep"})
+			So(testRecords(), ShouldResemble, []string{"Updated Record", "Second Record"})
+		})
+		Convey("Dependent modules are uninstalled first", func() {
+			So(server.UninstallModules(resourceDir, "uninstallbase", "uninstalldep"), ShouldBeNil)
+			So(uninstalled, ShouldResemble, []string{"uninstalldep", "uninstallbase"})
+			So(testRecords(), ShouldBeEmpty)
+		})
+		Convey("Uninstalled modules must be removed from the project", func() {
+			So(server.CheckUninstalledModules(false), ShouldBeNil)
+			So(server.UninstallModules(resourceDir, "uninstalldep"), ShouldBeNil)
+			err := server.CheckUninstalledModules(true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "uninstalldep")
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				rs := env.Pool(server.UninstalledModuleModel)
+				rs.Call("Create", models.NewModelData(rs.Model()).Set(rs.Model().FieldName("Name"), "removedmodule"))
+			}), ShouldBeNil)
+			So(uninstalledModules(), ShouldResemble, []string{"removedmodule", "uninstalldep"})
+			So(server.CheckUninstalledModules(false), ShouldNotBeNil)
+			So(uninstalledModules(), ShouldResemble, []string{"removedmodule", "uninstalldep"})
+			So(server.CheckUninstalledModules(true), ShouldNotBeNil)
+			So(uninstalledModules(), ShouldResemble, []string{"uninstalldep"})
+		})
+		Reset(func() {
+			uninstalled = nil
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				env.Pool(server.UninstalledModuleModel).SearchAll().Call("Unlink")
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -6,8 +6,42 @@ package exceptions
 
 import "fmt"
 
+// Codes of the Hexya errors. They are sent to the client
+// as exception type so that it can display them accordingly.
+const (
+	UserErrorCode        = "user_error"
+	ValidationErrorCode  = "validation_error"
+	AccessErrorCode      = "access_error"
+	MissingErrorCode     = "missing_error"
+	ConcurrencyErrorCode = "concurrency_error"
+)
+
+// An Error is an error that must rollback the current transaction
+// and that can be reported to the user.
+//
+// Errors are raised by panicking with the error value. They are
+// returned as is by ExecuteInNewEnvironment and SimulateInNewEnvironment.
+type Error interface {
+	error
+	// Code returns the code of this type of error
+	Code() string
+	// UserMessage returns the message to display to the user.
+	// It should have been translated in the user's language.
+	UserMessage() string
+	// DebugInfo returns the optional details of this error for developers.
+	DebugInfo() string
+}
+
+// formatError returns the string representation of an Error
+// with the given message and debug info
+func formatError(message, debug string) string {
+	return fmt.Sprintf("%s\n----------------------------------\n%s", message, debug)
+}
+
 // UserError is an error that must rollback the current transaction and
 // be displayed as a warning to the user.
+//
+// Contrary to other errors, its message is always displayed as is.
 type UserError struct {
 	Message string
 	Debug   string
@@ -16,5 +50,142 @@ type UserError struct {
 // Error method for the UserError type.
 // Returns the message.
 func (u UserError) Error() string {
-	return fmt.Sprintf("%s\n----------------------------------\n%s", u.Message, u.Debug)
+	return formatError(u.Message, u.Debug)
+}
+
+// Code returns the code of UserError
+func (u UserError) Code() string {
+	return UserErrorCode
+}
+
+// UserMessage returns the message to display to the user
+func (u UserError) UserMessage() string {
+	return u.Message
+}
+
+// DebugInfo returns the details of this error for developers
+func (u UserError) DebugInfo() string {
+	return u.Debug
+}
+
+var _ Error = UserError{}
+
+// ValidationError is an error raised when the data to
+// write in the database violates a constraint.
+type ValidationError struct {
+	Message string
+	Debug   string
+}
+
+// Error method for the ValidationError type.
+func (v ValidationError) Error() string {
+	return formatError(v.Message, v.Debug)
+}
+
+// Code returns the code of ValidationError
+func (v ValidationError) Code() string {
+	return ValidationErrorCode
+}
+
+// UserMessage returns the message to display to the user
+func (v ValidationError) UserMessage() string {
+	return v.Message
+}
+
+// DebugInfo returns the details of this error for developers
+func (v ValidationError) DebugInfo() string {
+	return v.Debug
+}
+
+var _ Error = ValidationError{}
+
+// AccessError is an error raised when the user is not
+// allowed to access a method or records.
+type AccessError struct {
+	Message string
+	Debug   string
+}
+
+// Error method for the AccessError type.
+func (a AccessError) Error() string {
+	return formatError(a.Message, a.Debug)
+}
+
+// Code returns the code of AccessError
+func (a AccessError) Code() string {
+	return AccessErrorCode
 }
+
+// UserMessage returns the message to display to the user
+func (a AccessError) UserMessage() string {
+	return a.Message
+}
+
+// DebugInfo returns the details of this error for developers
+func (a AccessError) DebugInfo() string {
+	return a.Debug
+}
+
+var _ Error = AccessError{}
+
+// MissingError is an error raised when trying to access
+// records that do not exist or have been deleted.
+type MissingError struct {
+	Message string
+	Debug   string
+}
+
+// Error method for the MissingError type.
+func (m MissingError) Error() string {
+	return formatError(m.Message, m.Debug)
+}
+
+// Code returns the code of MissingError
+func (m MissingError) Code() string {
+	return MissingErrorCode
+}
+
+// UserMessage returns the message to display to the user
+func (m MissingError) UserMessage() string {
+	return m.Message
+}
+
+// DebugInfo returns the details of this error for developers
+func (m MissingError) DebugInfo() string {
+	return m.Debug
+}
+
+var _ Error = MissingError{}
+
+// ConcurrencyErrorMessage is the message of the ConcurrencyError raised
+// when a transaction still fails after its retries, before translation.
+const ConcurrencyErrorMessage = "The operation cannot be completed due to concurrent updates, please try again."
+
+// ConcurrencyError is an error raised when a transaction
+// could not be completed because of concurrent updates.
+type ConcurrencyError struct {
+	Message string
+	Debug   string
+}
+
+// Error method for the ConcurrencyError type.
+func (c ConcurrencyError) Error() string {
+	return formatError(c.Message, c.Debug)
+}
+
+// Code returns the code of ConcurrencyError
+func (c ConcurrencyError) Code() string {
+	return ConcurrencyErrorCode
+}
+
+// UserMessage returns the message to display to the user
+func (c ConcurrencyError) UserMessage() string {
+	return c.Message
+}
+
+// DebugInfo returns the details of this error for developers
+func (c ConcurrencyError) DebugInfo() string {
+	return c.Debug
+}
+
+var _ Error = ConcurrencyError{}
@@ -16,6 +16,7 @@ import (
 	"text/template"
 
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
 
@@ -30,6 +31,8 @@ type fieldData struct {
 	SanType     string
 	ImportPath  string
 	IsRS        bool
+	IsX2Many    bool
+	IsM2O       bool
 	MixinField  bool
 	EmbedField  bool
 }
@@ -61,6 +64,8 @@ type fieldType struct {
 	Type      string
 	SanType   string
 	IsRS      bool
+	IsDate    bool
+	IsString  bool
 	Operators []operatorDef
 }
 
@@ -267,6 +272,8 @@ func addFieldsToModelData(modelASTData ModelASTData, modelData *modelData, depsM
 			Type:       typStr,
 			IType:      iTypStr,
 			IsRS:       fieldASTData.IsRS,
+			IsX2Many:   fieldASTData.FType.Is2ManyRelationType(),
+			IsM2O:      fieldASTData.FType == fieldtype.Many2One,
 			RelModel:   fieldASTData.RelModel,
 			SanType:    createTypeIdent(typStr),
 			MixinField: fieldASTData.MixinField,
@@ -292,14 +299,16 @@ func addFieldTypesToModelData(mData *modelData) {
 		fTypes[f.IType] = true
 		tDeps[f.ImportPath] = true
 		mData.Types = append(mData.Types, fieldType{
-			Type:    f.IType,
-			SanType: f.SanType,
-			IsRS:    f.IsRS,
+			Type:     f.IType,
+			SanType:  f.SanType,
+			IsRS:     f.IsRS,
+			IsDate:   f.IType == "dates.Date" || f.IType == "dates.DateTime",
+			IsString: f.IType == "string",
 			Operators: []operatorDef{
 				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
 				{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
 				{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
-				{Name: "ChildOf"},
+				{Name: "ChildOf"}, {Name: "Similar"},
 			},
 		})
 	}
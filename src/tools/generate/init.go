@@ -28,10 +28,11 @@ var (
 	log logging.Logger
 	// ModelMixins are the names of the mixins declared in the models package
 	ModelMixins = map[string]bool{
-		"CommonMixin":    true,
-		"BaseMixin":      true,
-		"ModelMixin":     true,
-		"TransientMixin": true,
+		"CommonMixin":     true,
+		"BaseMixin":       true,
+		"ModelMixin":      true,
+		"TransientMixin":  true,
+		"LineNumberMixin": true,
 	}
 	// MethodsToAdd are methods that are declared directly in the generated code.
 	// Usually this is because they can't be declared in base_model due to not convertible arg or return types.
@@ -232,6 +232,14 @@ func defaultFields(name string) map[string]FieldASTData {
 			Type:        TypeData{Type: "int"},
 			FType:       fieldtype.Integer,
 		}
+	case "LineNumberMixin":
+		res["LineNumber"] = FieldASTData{
+			Name:        "LineNumber",
+			JSON:        "line_number",
+			Description: "Line Number",
+			Type:        TypeData{Type: "int"},
+			FType:       fieldtype.Integer,
+		}
 	}
 	return res
 }
@@ -366,6 +374,16 @@ func setModelData(modelsData *map[string]ModelASTData, modelName string, modelTy
 	case "":
 		model.Mixins["BaseMixin"] = true
 		model.Mixins["ModelMixin"] = true
+	case "UUID":
+		model.Mixins["BaseMixin"] = true
+		model.Mixins["ModelMixin"] = true
+		model.Fields["UUID"] = FieldASTData{
+			Name:        "UUID",
+			JSON:        "uuid",
+			Description: "UUID",
+			Type:        TypeData{Type: "string"},
+			FType:       fieldtype.Char,
+		}
 	case "Transient":
 		model.Mixins["BaseMixin"] = true
 	}
@@ -577,7 +595,8 @@ func extractModel(ident ast.Expr, modInfo *ModuleInfo) (string, error) {
 					return "", fmt.Errorf("unexpected function identifier: %v (%T)", rd.Fun, rd.Fun)
 				}
 				switch fnIdent.Name {
-				case "Get", "MustGet", "NewModel", "NewMixinModel", "NewTransientModel", "NewManualModel":
+				case "Get", "MustGet", "NewModel", "NewMixinModel", "NewTransientModel", "NewManualModel", "NewMaterializedViewModel",
+					"NewUUIDModel":
 					return strings.Trim(rd.Args[0].(*ast.BasicLit).Value, "\"`"), nil
 				case "CreateModel", "getOrCreateModel":
 					// This is a call from inside a NewXXXXModel function
@@ -80,6 +80,15 @@ func (md {{ .Name }}Model) BrowseOne(env models.Environment, id int64) {{ .Inter
 		RecordCollection: md.Model.BrowseOne(env, id),
 	}
 }
+{{ if eq .ModelType "UUID" }}
+// BrowseUUIDs returns a new RecordSet with the records with the given uuid primary keys.
+// Note that this function is just a shorcut for Search on a list of uuids.
+func (md {{ .Name }}Model) BrowseUUIDs(env models.Environment, uuids []string) {{ .InterfacesPackageName }}.{{ .Name }}Set {
+	return {{ .SnakeName }}.{{ .Name }}Set{
+		RecordCollection: md.Model.BrowseUUIDs(env, uuids),
+	}
+}
+{{ end }}
 
 {{ end }}
 
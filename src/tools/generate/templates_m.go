@@ -28,6 +28,11 @@ type {{ .Name }}Set interface {
 	//
 	// It also returns this {{ .Name }}Set.
 	ForceLoad(fields ...models.FieldName) {{ .Name }}Set
+	{{- if eq .ModelType "UUID" }}
+	// UUIDs returns the uuid primary keys of the records of this {{ .Name }}Set,
+	// in the same order as Ids.
+	UUIDs() []string
+	{{- end }}
 	{{- range .Fields }}
 	// {{ .Name }} is a getter for the value of the "{{ .Name }}" field of the first
 	// record in this RecordSet. It returns the Go zero value if the RecordSet is empty.
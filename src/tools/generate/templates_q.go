@@ -105,6 +105,44 @@ func (cs ConditionStart) NewCondition() Condition {
 	}
 }
 
+// InBoundingBox adds a condition which is true for the records whose lat and lng
+// fields fall within the given bounding box. See models.ConditionStart.InBoundingBox.
+func (cs ConditionStart) InBoundingBox(lat, lng models.FieldName, south, west, north, east float64) Condition {
+	return Condition{
+		Condition: cs.ConditionStart.InBoundingBox(lat, lng, south, west, north, east),
+	}
+}
+
+// CreatedBy adds a condition which is true for the records that have been
+// created by the user with the given id. See models.ConditionStart.CreatedBy.
+func (cs ConditionStart) CreatedBy(uid int64) Condition {
+	return Condition{
+		Condition: cs.ConditionStart.CreatedBy(uid),
+	}
+}
+
+// FollowedBy adds a condition which is true for the records that have a followers
+// record whose follower field equals value. See models.ConditionStart.FollowedBy.
+func (cs ConditionStart) FollowedBy(followers, follower models.FieldName, value interface{}) Condition {
+	return Condition{
+		Condition: cs.ConditionStart.FollowedBy(followers, follower, value),
+	}
+}
+
+// AggregateCondition returns a condition field on the aggregate with function
+// of the aggregated field of the related records of the given one2many or
+// many2many field that match condition, which may be nil.
+// See models.ConditionStart.AggregateCondition.
+func (cs ConditionStart) AggregateCondition(field models.FieldName, function models.AggregateFunction, aggregated models.FieldName, condition models.Conditioner) pAggregateConditionField {
+	var cond *models.Condition
+	if condition != nil {
+		cond = condition.Underlying()
+	}
+	return pAggregateConditionField{
+		ConditionField: cs.ConditionStart.AggregateCondition(field, function, aggregated, cond),
+	}
+}
+
 {{ range .Fields }}
 // {{ .Name }} adds the "{{ .Name }}" field to the Condition
 func (cs ConditionStart) {{ .Name }}() p{{ .SanType }}ConditionField {
@@ -122,6 +160,49 @@ func (cs ConditionStart) {{ .Name }}FilteredOn(cond {{ .RelModel }}Condition) Co
 	}
 }
 {{ end }}
+{{ if .IsM2O }}
+// {{ .Name }}AnyOf adds a condition which is true for the records whose
+// "{{ .Name }}" record matches the given condition, with the record rules
+// of the related model applied
+func (cs ConditionStart) {{ .Name }}AnyOf(cond {{ .RelModel }}Condition) Condition {
+	return Condition{
+		Condition: cs.AnyOf(models.NewFieldName("{{ .Name }}", "{{ .JSON }}"), cond.Underlying()),
+	}
+}
+{{ end }}
+{{ if .IsX2Many }}
+// {{ .Name }}AnyOf adds a condition which is true for the records that have
+// at least one "{{ .Name }}" record matching the given condition
+func (cs ConditionStart) {{ .Name }}AnyOf(cond {{ .RelModel }}Condition) Condition {
+	return Condition{
+		Condition: cs.AnyOf(models.NewFieldName("{{ .Name }}", "{{ .JSON }}"), cond.Underlying()),
+	}
+}
+
+// {{ .Name }}AllOf adds a condition which is true for the records whose
+// "{{ .Name }}" records all match the given condition
+func (cs ConditionStart) {{ .Name }}AllOf(cond {{ .RelModel }}Condition) Condition {
+	return Condition{
+		Condition: cs.AllOf(models.NewFieldName("{{ .Name }}", "{{ .JSON }}"), cond.Underlying()),
+	}
+}
+
+// {{ .Name }}ContainsAll adds a condition which is true for the records whose
+// "{{ .Name }}" records include all the given records
+func (cs ConditionStart) {{ .Name }}ContainsAll(arg {{ .RelModel }}Set) Condition {
+	return Condition{
+		Condition: cs.Field(models.NewFieldName("{{ .Name }}", "{{ .JSON }}")).ContainsAll(arg),
+	}
+}
+
+// {{ .Name }}ContainsAny adds a condition which is true for the records whose
+// "{{ .Name }}" records include at least one of the given records
+func (cs ConditionStart) {{ .Name }}ContainsAny(arg {{ .RelModel }}Set) Condition {
+	return Condition{
+		Condition: cs.Field(models.NewFieldName("{{ .Name }}", "{{ .JSON }}")).ContainsAny(arg),
+	}
+}
+{{ end }}
 {{ end }}
 
 // ------- CONDITION FIELDS ----------
@@ -162,20 +243,79 @@ func (c p{{ $typ.SanType }}ConditionField) {{ .Name }}Eval(expression string) Co
 
 {{ end }}
 
-// IsNull checks if the current condition field is null
+// IsNull checks if the current condition field is empty
 func (c p{{ $typ.SanType }}ConditionField) IsNull() Condition {
 	return Condition{
 		Condition: c.ConditionField.IsNull(),
 	}
 }
 
-// IsNotNull checks if the current condition field is not null
+// IsNotNull checks if the current condition field is set
 func (c p{{ $typ.SanType }}ConditionField) IsNotNull() Condition {
 	return Condition{
 		Condition: c.ConditionField.IsNotNull(),
 	}
 }
 
+// IsSet checks if the current condition field is set
+func (c p{{ $typ.SanType }}ConditionField) IsSet() Condition {
+	return Condition{
+		Condition: c.ConditionField.IsSet(),
+	}
+}
+
+{{ if $typ.IsDate }}
+// InPeriod adds a condition which is true if the field is within the given
+// period, computed in the timezone of the user when the query is performed
+func (c p{{ $typ.SanType }}ConditionField) InPeriod(period models.DatePeriod) Condition {
+	return Condition{
+		Condition: c.ConditionField.InPeriod(period),
+	}
+}
+
+// BeforePeriod adds a condition which is true if the field is before
+// the start of the given period
+func (c p{{ $typ.SanType }}ConditionField) BeforePeriod(period models.DatePeriod) Condition {
+	return Condition{
+		Condition: c.ConditionField.BeforePeriod(period),
+	}
+}
+
+// AfterPeriod adds a condition which is true if the field is after
+// the end of the given period
+func (c p{{ $typ.SanType }}ConditionField) AfterPeriod(period models.DatePeriod) Condition {
+	return Condition{
+		Condition: c.ConditionField.AfterPeriod(period),
+	}
+}
+
+// DatePart returns a condition field on the given part of the field, such as
+// its month or weekday, computed in the timezone of the user for datetimes
+func (c p{{ $typ.SanType }}ConditionField) DatePart(part models.DatePart) pDatePartConditionField {
+	return pDatePartConditionField{
+		ConditionField: c.ConditionField.DatePart(part),
+	}
+}
+{{ end }}
+
+{{ if $typ.IsString }}
+// JSONContains adds a condition which is true if the JSON value of the field
+// contains the JSON encoding of the given value, such as an element of an array
+func (c p{{ $typ.SanType }}ConditionField) JSONContains(value interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.JSONContains(value),
+	}
+}
+
+// JSONPathContains adds a condition which is true if the JSON value of the field
+// has the given value at the given dot separated path of keys
+func (c p{{ $typ.SanType }}ConditionField) JSONPathContains(path string, value interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.JSONPathContains(path, value),
+	}
+}
+{{ end }}
+
 // AddOperator adds a condition value to the condition with the given operator and data
 // If multi is true, a recordset will be converted into a slice of int64
 // otherwise, it will return an int64 and panic if the recordset is not a singleton.
@@ -189,4 +329,114 @@ func (c p{{ $typ.SanType }}ConditionField) AddOperator(op operator.Operator, dat
 
 {{ end }}
 
+// A pDatePartConditionField is a partial Condition when we have selected
+// a part of a date or datetime field and expecting an operator.
+type pDatePartConditionField struct {
+	*models.ConditionField
+}
+
+// Equals adds a condition value to the ConditionPath
+func (c pDatePartConditionField) Equals(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.Equals(arg),
+	}
+}
+
+// NotEquals adds a condition value to the ConditionPath
+func (c pDatePartConditionField) NotEquals(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.NotEquals(arg),
+	}
+}
+
+// Greater adds a condition value to the ConditionPath
+func (c pDatePartConditionField) Greater(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.Greater(arg),
+	}
+}
+
+// GreaterOrEqual adds a condition value to the ConditionPath
+func (c pDatePartConditionField) GreaterOrEqual(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.GreaterOrEqual(arg),
+	}
+}
+
+// Lower adds a condition value to the ConditionPath
+func (c pDatePartConditionField) Lower(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.Lower(arg),
+	}
+}
+
+// LowerOrEqual adds a condition value to the ConditionPath
+func (c pDatePartConditionField) LowerOrEqual(arg int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.LowerOrEqual(arg),
+	}
+}
+
+// In adds a condition value to the ConditionPath
+func (c pDatePartConditionField) In(arg []int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.In(arg),
+	}
+}
+
+// NotIn adds a condition value to the ConditionPath
+func (c pDatePartConditionField) NotIn(arg []int64) Condition {
+	return Condition{
+		Condition: c.ConditionField.NotIn(arg),
+	}
+}
+
+// A pAggregateConditionField is a partial Condition when we have selected
+// an aggregate of related records and expecting a comparison operator.
+type pAggregateConditionField struct {
+	*models.ConditionField
+}
+
+// Equals adds a condition value to the ConditionPath
+func (c pAggregateConditionField) Equals(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.Equals(arg),
+	}
+}
+
+// NotEquals adds a condition value to the ConditionPath
+func (c pAggregateConditionField) NotEquals(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.NotEquals(arg),
+	}
+}
+
+// Greater adds a condition value to the ConditionPath
+func (c pAggregateConditionField) Greater(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.Greater(arg),
+	}
+}
+
+// GreaterOrEqual adds a condition value to the ConditionPath
+func (c pAggregateConditionField) GreaterOrEqual(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.GreaterOrEqual(arg),
+	}
+}
+
+// Lower adds a condition value to the ConditionPath
+func (c pAggregateConditionField) Lower(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.Lower(arg),
+	}
+}
+
+// LowerOrEqual adds a condition value to the ConditionPath
+func (c pAggregateConditionField) LowerOrEqual(arg interface{}) Condition {
+	return Condition{
+		Condition: c.ConditionField.LowerOrEqual(arg),
+	}
+}
+
 `))
@@ -180,7 +180,13 @@ func GetLogger(moduleName string) Logger {
 // error with the panic message. This function is separated from
 // LogAndPanic so that unwanted panics can still be logged with
 // this function.
+//
+// If panicData is an exceptions.Error, it is returned as is.
 func LogPanicData(panicData interface{}) error {
+	if err, ok := panicData.(exceptions.Error); ok {
+		log.Error("Hexya panicked", "code", err.Code(), "msg", err.UserMessage(), "debug", err.DebugInfo())
+		return err
+	}
 	msg := fmt.Sprintf("%v", panicData)
 	log.Error("Hexya panicked", "msg", msg)
 
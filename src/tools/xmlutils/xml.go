@@ -111,6 +111,8 @@ func ApplyExtensions(base *etree.Document, specs *etree.Document) (*etree.Docume
 				nodeToModify.RemoveAttr(attrName)
 				nodeToModify.CreateAttr(attrName, node.Text())
 			}
+		default:
+			return nil, fmt.Errorf("unknown position '%s' in spec for %s", modifyAction.Value, xpath)
 		}
 	}
 	return baseElem, nil
@@ -121,7 +123,11 @@ func ApplyExtensions(base *etree.Document, specs *etree.Document) (*etree.Docume
 func getInheritXPathFromSpec(spec *etree.Element) (string, error) {
 	if spec.Tag == "xpath" {
 		// We have an xpath expression, we take it
-		return spec.SelectAttr("expr").Value, nil
+		expr := spec.SelectAttr("expr")
+		if expr == nil {
+			return "", errors.New("xpath spec should include 'expr' attribute")
+		}
+		return expr.Value, nil
 	}
 	if len(spec.Attr) < 1 || len(spec.Attr) > 2 {
 		return "", errors.New("invalid view inherit spec")
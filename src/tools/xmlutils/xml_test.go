@@ -102,6 +102,16 @@ var (
 <field name="Email">
 	<field name="Something"/>
 </field>
+`
+	unknownPositionSpec = `
+<field name="Email" position="below">
+	<field name="Something"/>
+</field>
+`
+	noExprSpec = `
+<xpath position="after">
+	<field name="Something"/>
+</xpath>
 `
 )
 
@@ -172,6 +182,23 @@ func TestApplyExtensions(t *testing.T) {
 `)
 			So(res, ShouldBeNil)
 		})
+		Convey("Specs with unknown position should fail", func() {
+			specDoc.ReadFromString(unknownPositionSpec)
+			res, err := ApplyExtensions(baseElem, specDoc)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown position 'below' in spec for //field[@name='Email']")
+			So(res, ShouldBeNil)
+		})
+		Convey("XPath specs without expr attribute should fail", func() {
+			specDoc.ReadFromString(noExprSpec)
+			res, err := ApplyExtensions(baseElem, specDoc)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `error in spec <xpath position="after">
+	<field name="Something"/>
+</xpath>
+: xpath spec should include 'expr' attribute`)
+			So(res, ShouldBeNil)
+		})
 	})
 }
 
@@ -0,0 +1,115 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+)
+
+// A FieldsView holds everything a client needs to render a view:
+// - Arch is the XML arch of the view after inheritance, translated
+// - Fields holds the definition of each field of the arch, keyed by JSON name.
+// The Views entry of x2many fields with embedded views holds the FieldsView of
+// each embedded view, keyed by view type.
+// - Modifiers holds the modifiers of the fields of the arch for each record
+type FieldsView struct {
+	ViewID    string                                     `json:"view_id"`
+	Name      string                                     `json:"name"`
+	Model     string                                     `json:"model"`
+	Type      ViewType                                   `json:"type"`
+	Arch      string                                     `json:"arch"`
+	Fields    map[string]*models.FieldInfo               `json:"fields"`
+	Modifiers map[int64]map[string]models.FieldModifiers `json:"modifiers,omitempty"`
+}
+
+// A fieldsGetFunc returns the definition of the given fields of the given model.
+type fieldsGetFunc func(modelName string, fields models.FieldNames) map[string]*models.FieldInfo
+
+// declareFieldsViewGetMethod adds the FieldsViewGet method to all models.
+func declareFieldsViewGetMethod() {
+	models.Registry.MustGet("CommonMixin").NewMethod("FieldsViewGet", commonMixinFieldsViewGet)
+}
+
+// FieldsViewGet returns the FieldsView of the view with the given ID, or of the
+// first view of the given type for the model of rc if viewID is empty.
+//
+// Field definitions are those returned by the FieldsGet method and the arch is
+// translated in the language of the context of rc. Modifiers are evaluated for
+// the records of rc, and are not returned if rc is empty.
+//
+// It panics if the view does not exist or is not a view of the model of rc,
+// and if rc has records that are not stored in the database yet.
+func commonMixinFieldsViewGet(rc *models.RecordCollection, viewType ViewType, viewID string) *FieldsView {
+	return Registry.fieldsViewGet(rc, viewType, viewID)
+}
+
+// fieldsViewGet returns the FieldsView of the view of this collection with the
+// given ID, or of the first view of the given type for the model of rc if viewID
+// is empty. See the FieldsViewGet method.
+func (vc *Collection) fieldsViewGet(rc *models.RecordCollection, viewType ViewType, viewID string) *FieldsView {
+	view := vc.GetFirstViewForModel(rc.ModelName(), viewType)
+	if viewID != "" {
+		view = vc.GetByID(viewID)
+	}
+	if view == nil || view.Model != rc.ModelName() {
+		log.Panic("Unable to find view for model", "model", rc.ModelName(), "viewID", viewID, "type", viewType)
+	}
+	env := rc.Env()
+	res := view.fieldsView(env.Context().GetString("lang"), func(modelName string, fields models.FieldNames) map[string]*models.FieldInfo {
+		return env.Pool(modelName).Call("FieldsGet", models.FieldsGetArgs{Fields: fields}).(map[string]*models.FieldInfo)
+	})
+	if !rc.IsEmpty() {
+		res.Modifiers = rc.Modifiers(view.fieldNames()...)
+	}
+	return res
+}
+
+// fieldsView returns the FieldsView of this view in the given language,
+// using fieldsGet to get the definitions of the fields.
+func (v *View) fieldsView(lang string, fieldsGet fieldsGetFunc) *FieldsView {
+	arch, err := xmlutils.DocumentToXML(v.Arch(lang))
+	if err != nil {
+		log.Panic("Unable to render view arch", "error", err, "view", v.ID)
+	}
+	fInfos := make(map[string]*models.FieldInfo)
+	if fieldNames := v.fieldNames(); len(fieldNames) > 0 {
+		fInfos = fieldsGet(v.Model, fieldNames)
+	}
+	model := models.Registry.MustGet(v.Model)
+	for fieldName, subViews := range v.SubViews {
+		fInfo, ok := fInfos[model.JSONizeFieldName(fieldName)]
+		if !ok {
+			continue
+		}
+		fInfo.Views = make(map[string]interface{})
+		for viewType, subView := range subViews {
+			fInfo.Views[string(viewType)] = subView.fieldsView(lang, fieldsGet)
+		}
+	}
+	return &FieldsView{
+		ViewID: v.ID,
+		Name:   v.Name,
+		Model:  v.Model,
+		Type:   v.Type,
+		Arch:   string(arch),
+		Fields: fInfos,
+	}
+}
+
+// fieldNames returns the FieldNames of the fields of this view's arch,
+// without duplicates.
+func (v *View) fieldNames() models.FieldNames {
+	model := models.Registry.MustGet(v.Model)
+	var res models.FieldNames
+	seen := make(map[string]bool)
+	for _, f := range v.Fields {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		res = append(res, model.FieldName(f))
+	}
+	return res
+}
@@ -15,9 +15,14 @@ const maxInheritanceDepth = 100
 var log logging.Logger
 
 // BootStrap makes the necessary updates to view definitions. In particular:
+// - applies inheriting views to their parent view in the order they have been loaded,
+// i.e. in module dependency order.
 // - sets the type of the view from the arch root.
 // - extracts embedded views
 // - populates the fields map from the views arch.
+//
+// It panics if an inheriting view references a view that does not exist
+// or if one of its specs cannot be applied to its parent view.
 func BootStrap() {
 	if !models.BootStrapped() {
 		log.Panic("Models must be bootstrapped before bootstrapping views")
@@ -76,6 +81,12 @@ func BootStrap() {
 			Registry.rawInheritedViews[i] = nil
 		}
 	}
+	for _, xmlView := range Registry.rawInheritedViews {
+		if xmlView == nil {
+			continue
+		}
+		log.Panic("Unable to find the parent view of an inheriting view", "view", xmlView.ID, "inheritID", xmlView.InheritID)
+	}
 	// Post-process all views
 	for _, v := range Registry.views {
 		log.Debug("Postprocessing view", "viewID", v.ID, "model", v.Model, "Type", v.Type)
@@ -99,4 +110,5 @@ func loadModelViews() {
 func init() {
 	log = logging.GetLogger("views")
 	Registry = NewCollection()
+	declareFieldsViewGetMethod()
 }
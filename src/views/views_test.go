@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/xmlutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -242,8 +243,11 @@ func TestViews(t *testing.T) {
 		})
 		user.AddFields(map[string]models.FieldDefinition{
 			"UserName": fields.Char{},
-			"Age":      fields.Integer{OnChange: models.Registry.MustGet("User").Methods().MustGet("OnChangeAge")},
-			"Groups":   fields.Many2Many{RelationModel: models.Registry.MustGet("Group")},
+			"Age": fields.Integer{OnChange: models.Registry.MustGet("User").Methods().MustGet("OnChangeAge"),
+				ReadOnlyFunc: func(env models.Environment) (bool, models.Conditioner) {
+					return env.Context().GetBool("lock_age"), nil
+				}},
+			"Groups": fields.Many2Many{RelationModel: models.Registry.MustGet("Group")},
 			"Categories": fields.Many2Many{RelationModel: models.Registry.MustGet("Category"),
 				JSON: "category_ids"},
 		})
@@ -435,6 +439,71 @@ func TestViews(t *testing.T) {
 	<field name="active"/>
 </tree>
 `)
+		fieldsGet := func(modelName string, fields models.FieldNames) map[string]*models.FieldInfo {
+			return models.Registry.MustGet(modelName).FieldsGet(fields...)
+		}
+		fieldsView := view.fieldsView("", fieldsGet)
+		So(fieldsView.ViewID, ShouldEqual, "embedded_form")
+		So(fieldsView.Model, ShouldEqual, "User")
+		So(fieldsView.Type, ShouldEqual, ViewTypeForm)
+		So(fieldsView.Arch, ShouldEqual, documentToXMLString(view.Arch("")))
+		So(fieldsView.Fields, ShouldHaveLength, 4)
+		So(fieldsView.Fields, ShouldContainKey, "user_name")
+		So(fieldsView.Fields, ShouldContainKey, "age")
+		So(fieldsView.Fields["age"].OnChange, ShouldBeTrue)
+		So(fieldsView.Fields["user_name"].Views, ShouldBeNil)
+		So(fieldsView.Fields["category_ids"].Views, ShouldHaveLength, 2)
+		categoriesTree := fieldsView.Fields["category_ids"].Views["tree"].(*FieldsView)
+		So(categoriesTree.Model, ShouldEqual, "Category")
+		So(categoriesTree.Arch, ShouldEqual, documentToXMLString(viewCategoriesTree.Arch("")))
+		So(categoriesTree.Fields, ShouldHaveLength, 2)
+		So(categoriesTree.Fields, ShouldContainKey, "name")
+		So(categoriesTree.Fields, ShouldContainKey, "color")
+		So(fieldsView.Fields["category_ids"].Views["form"].(*FieldsView).Fields, ShouldHaveLength, 3)
+		So(fieldsView.Fields["groups_ids"].Views, ShouldHaveLength, 1)
+		So(fieldsView.Modifiers, ShouldBeNil)
+	})
+	Convey("Getting fields views of RecordSets", t, func() {
+		Registry = NewCollection()
+		loadView(viewDef1)
+		loadView(viewDef2)
+		loadView(viewDef3)
+		loadView(viewDef6)
+		loadView(viewDef7)
+		BootStrap()
+		env := models.NewDetachedEnvironment(security.SuperUserID)
+		users := env.Pool("User")
+		treeView := users.Call("FieldsViewGet", ViewTypeTree, "").(*FieldsView)
+		So(treeView.ViewID, ShouldEqual, "my_tree_id")
+		So(treeView.Type, ShouldEqual, ViewTypeTree)
+		So(treeView.Arch, ShouldEqual, documentToXMLString(Registry.GetByID("my_tree_id").Arch("")))
+		So(treeView.Fields, ShouldHaveLength, 2)
+		So(treeView.Fields, ShouldContainKey, "user_name")
+		So(treeView.Fields["age"].ReadOnly, ShouldBeFalse)
+		So(treeView.Modifiers, ShouldBeNil)
+
+		formView := users.Call("FieldsViewGet", ViewTypeForm, "embedded_form").(*FieldsView)
+		So(formView.ViewID, ShouldEqual, "embedded_form")
+		So(formView.Fields, ShouldHaveLength, 4)
+		So(formView.Fields["category_ids"].Views, ShouldHaveLength, 2)
+		So(func() { users.Call("FieldsViewGet", ViewTypeForm, "my_other_id") }, ShouldPanic)
+		So(func() { users.Call("FieldsViewGet", ViewTypeForm, "unknown_view") }, ShouldPanic)
+
+		frArch, err := xmlutils.XMLToDocument(`<tree><field name="user_name" string="Nom"/></tree>`)
+		So(err, ShouldBeNil)
+		Registry.GetByID("my_tree_id").arches["fr"] = frArch
+		frTreeView := users.WithContext("lang", "fr").Call("FieldsViewGet", ViewTypeTree, "").(*FieldsView)
+		So(frTreeView.Arch, ShouldEqual, documentToXMLString(frArch))
+
+		john := users.Detached(models.FieldMap{"id": int64(1), "UserName": "John"})
+		johnView := john.Call("FieldsViewGet", ViewTypeTree, "").(*FieldsView)
+		So(johnView.Modifiers, ShouldHaveLength, 1)
+		So(johnView.Modifiers[1], ShouldHaveLength, 2)
+		So(johnView.Modifiers[1]["age"], ShouldResemble, models.FieldModifiers{})
+		lockedView := john.WithContext("lock_age", true).Call("FieldsViewGet", ViewTypeTree, "").(*FieldsView)
+		So(lockedView.Fields["age"].ReadOnly, ShouldBeTrue)
+		So(lockedView.Modifiers[1]["age"], ShouldResemble, models.FieldModifiers{ReadOnly: true})
+		So(lockedView.Modifiers[1]["user_name"], ShouldResemble, models.FieldModifiers{})
 	})
 	Convey("Inheriting embedded views", t, func() {
 		Registry = NewCollection()
@@ -722,5 +791,28 @@ func TestViews(t *testing.T) {
 </search>
 `)
 	})
-
+	Convey("Inheriting an unknown view should panic", t, func() {
+		Registry = NewCollection()
+		loadView(viewDef1)
+		loadView(`
+<view inherit_id="no_such_view">
+	<field name="UserName" position="after">
+		<field name="Age"/>
+	</field>
+</view>
+`)
+		So(BootStrap, ShouldPanic)
+	})
+	Convey("Inheriting a view with an unknown xpath target should panic", t, func() {
+		Registry = NewCollection()
+		loadView(viewDef1)
+		loadView(`
+<view inherit_id="my_id">
+	<xpath expr="//field[@name='NoSuchField']" position="after">
+		<field name="Age"/>
+	</xpath>
+</view>
+`)
+		So(BootStrap, ShouldPanic)
+	})
 }